@@ -0,0 +1,109 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const accessCheckFileName string = "accesscheck.go"
+
+var accessCheckCmd = &cobra.Command{
+	Use:   "access-check user model operation [record]",
+	Short: "Explain why a user is granted or denied access",
+	Long: `Explain how the access of a user to an operation on a model is evaluated.
+
+'user' is the id or the login of the user.
+'operation' is one of read, create, write or unlink, or the name of a method of the model.
+If 'record' is given, record rules are evaluated on the record with this id.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.RangeArgs(3, 4),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Set("AccessCheck.User", args[0])
+		viper.Set("AccessCheck.Model", args[1])
+		viper.Set("AccessCheck.Operation", args[2])
+		if len(args) > 3 {
+			recordID, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil {
+				fmt.Println("Invalid record id:", args[3])
+				os.Exit(1)
+			}
+			viper.Set("AccessCheck.Record", recordID)
+		}
+		generateAndRunFile(viper.GetString("AccessCheck.ProjectDir"), accessCheckFileName, accessCheckTemplate)
+	},
+}
+
+// AccessCheck prints the explanation of the access evaluation for the user,
+// model, operation and record given in the configuration. It is meant to be
+// called from a project start file which imports all the project's module.
+func AccessCheck(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	models.LoadFieldAccess()
+	server.PostInitModules()
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		uid := resolveUser(env, viper.GetString("AccessCheck.User"))
+		report := models.CheckAccess(env, uid, viper.GetString("AccessCheck.Model"),
+			viper.GetString("AccessCheck.Operation"), viper.GetInt64("AccessCheck.Record"))
+		fmt.Print(report)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// resolveUser returns the uid of the given user, which can be
+// either a uid or the login of a user.
+func resolveUser(env models.Environment, user string) int64 {
+	if uid, err := strconv.ParseInt(user, 10, 64); err == nil {
+		return uid
+	}
+	if _, ok := models.Registry.Get("User"); !ok {
+		log.Panic("Users must be given by id since there is no User model", "user", user)
+	}
+	rc := env.Pool("User")
+	userRec := rc.Search(rc.Model().Field("Login").Equals(user)).Limit(1)
+	if userRec.IsEmpty() {
+		log.Panic("Unknown user", "login", user)
+	}
+	return userRec.Ids()[0]
+}
+
+func init() {
+	accessCheckCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("AccessCheck.ProjectDir", accessCheckCmd.PersistentFlags().Lookup("project-dir"))
+	DoxaCmd.AddCommand(accessCheckCmd)
+}
+
+var accessCheckTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.AccessCheck({{ .Config }})
+}
+`))
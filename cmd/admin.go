@@ -0,0 +1,142 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const adminResetPasswordFileName string = "adminresetpassword.go"
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administer the users of the database",
+	Long: `Administer the users of the database, e.g. to recover an installation where
+administrators cannot log in anymore.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+}
+
+var adminResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password",
+	Short: "Set or reset the password of a user",
+	Long: `Set or reset the password of the user given with --user, which is the id or the login
+of the user, directly in the database. The lock of the user's login after too many failed
+login attempts is removed.
+
+The new password is read from the first line of the standard input if --password-stdin
+is set. Otherwise, a random password is generated and printed. In both cases, the password
+must comply with the password policy of the configuration.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		generateAndRunFile(viper.GetString("Admin.ProjectDir"), adminResetPasswordFileName, adminResetPasswordTemplate)
+	},
+}
+
+// AdminResetPassword resets the password of the user given in the configuration.
+// It is meant to be called from a project start file which imports all the project's module.
+func AdminResetPassword(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	setupSecurity()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	server.PostInitModules()
+
+	password, generated := readOrGeneratePassword(viper.GetBool("Admin.PasswordStdin"))
+	user := viper.GetString("Admin.User")
+	var policyErr error
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		policyErr = models.ResetPassword(env, resolveUser(env, user), password)
+	})
+	switch {
+	case policyErr != nil:
+		fmt.Println("Error:", policyErr)
+		os.Exit(1)
+	case err != nil:
+		fmt.Println(err)
+		os.Exit(1)
+	case generated:
+		fmt.Printf("Password of user %s reset to: %s\n", user, password)
+	default:
+		fmt.Printf("Password of user %s reset\n", user)
+	}
+}
+
+// readOrGeneratePassword returns the first line of the standard input if
+// fromStdin is true, and a random password complying with the password
+// policy otherwise. The second returned value is true if the password
+// has been generated.
+func readOrGeneratePassword(fromStdin bool) (string, bool) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			log.Panic("Unable to read password from standard input", "error", err)
+		}
+		return password, false
+	}
+	return generatePassword(), true
+}
+
+// generatePassword returns a random password complying with security.DefaultPasswordPolicy
+func generatePassword() string {
+	length := 16
+	if security.DefaultPasswordPolicy.MinLength > length {
+		length = security.DefaultPasswordPolicy.MinLength
+	}
+	buf := make([]byte, length)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			log.Panic("Unable to generate random password", "error", err)
+		}
+		// The URL encoding has no digit or symbol guarantee, so
+		// passwords are generated until one complies with the policy.
+		password := base64.RawURLEncoding.EncodeToString(buf)[:length]
+		if security.DefaultPasswordPolicy.Check(password) == nil {
+			return password
+		}
+	}
+}
+
+func init() {
+	adminCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Admin.ProjectDir", adminCmd.PersistentFlags().Lookup("project-dir"))
+	adminResetPasswordCmd.Flags().String("user", "admin", "Id or login of the user")
+	viper.BindPFlag("Admin.User", adminResetPasswordCmd.Flags().Lookup("user"))
+	adminResetPasswordCmd.Flags().Bool("password-stdin", false, "Read the new password from the standard input")
+	viper.BindPFlag("Admin.PasswordStdin", adminResetPasswordCmd.Flags().Lookup("password-stdin"))
+	adminCmd.AddCommand(adminResetPasswordCmd)
+	DoxaCmd.AddCommand(adminCmd)
+}
+
+var adminResetPasswordTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.AdminResetPassword({{ .Config }})
+}
+`))
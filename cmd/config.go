@@ -0,0 +1,183 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/strutils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// maskedValue replaces the values of secret settings in 'doxa config show'
+const maskedValue = "********"
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and check the configuration",
+	Long: `Inspect and check the effective configuration, which is made of the command line flags,
+the DOXA_* environment variables and the configuration file, in this order of precedence.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Print the effective configuration, that is the value of each setting after applying the
+command line flags, the DOXA_* environment variables, the configuration file and the defaults.
+
+The values of passwords and secrets are masked, unless --show-secrets is set.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		showSecrets, _ := cmd.Flags().GetBool("show-secrets")
+		if file := viper.ConfigFileUsed(); file != "" {
+			fmt.Printf("# Configuration file: %s\n", file)
+		}
+		settings := flattenSettings("", viper.AllSettings())
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := settings[key]
+			if !showSecrets && isSecretSetting(key) && fmt.Sprint(value) != "" {
+				value = maskedValue
+			}
+			fmt.Printf("%s = %v\n", key, value)
+		}
+	},
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that the server can start with the configuration",
+	Long: `Check that the server can start with the effective configuration, that is:
+- the database can be connected to,
+- the data directory can be written to,
+- the module paths can be found.
+
+The command exits with an error status if one of the checks fails, so that it can be
+run before starting the server.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checks := []struct {
+			name  string
+			check func() error
+		}{
+			{"Database connection", checkDBConnection},
+			{"Data directory", checkDataDir},
+			{"Module paths", checkModulePaths},
+		}
+		var failed bool
+		for _, c := range checks {
+			if err := c.check(); err != nil {
+				fmt.Printf("%-20s FAIL: %s\n", c.name, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%-20s OK\n", c.name)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// flattenSettings returns the given nested settings as a single map
+// whose keys are the dot separated paths of the settings.
+func flattenSettings(prefix string, settings map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sub, ok := value.(map[string]interface{}); ok {
+			for subKey, subValue := range flattenSettings(key, sub) {
+				res[subKey] = subValue
+			}
+			continue
+		}
+		res[key] = value
+	}
+	return res
+}
+
+// isSecretSetting returns true if the setting with the given key holds a
+// password or a secret which must not be displayed, that is if its last
+// part contains one of the strutils.SensitiveWords.
+func isSecretSetting(key string) bool {
+	parts := strings.Split(key, ".")
+	return strutils.IsSensitiveName(parts[len(parts)-1])
+}
+
+// checkDBConnection returns an error if the configured database cannot be connected to
+func checkDBConnection() (rErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rErr = fmt.Errorf("%v", r)
+		}
+	}()
+	connectToDB()
+	defer models.DBClose()
+	return models.DBPing()
+}
+
+// checkDataDir returns an error if files cannot be written in the configured data
+// directory. The nearest existing parent is checked if the directory does not exist
+// yet, since it is created when data is first written.
+func checkDataDir() error {
+	dir := viper.GetString("DataDir")
+	if dir == "" {
+		return fmt.Errorf("no data directory is set")
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	file, err := ioutil.TempFile(dir, ".doxa-check")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %s", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkModulePaths returns an error if the source directory
+// of one of the configured modules cannot be found.
+func checkModulePaths() error {
+	var missing []string
+	for _, importPath := range viper.GetStringSlice("Modules") {
+		if _, err := build.Import(importPath, ".", build.FindOnly); err != nil {
+			missing = append(missing, importPath)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("modules not found: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func init() {
+	configShowCmd.Flags().Bool("show-secrets", false, "Print the values of passwords and secrets")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configCheckCmd)
+	DoxaCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,258 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/exceptions"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	dataExportFileName string = "dataexport.go"
+	dataImportFileName string = "dataimport.go"
+)
+
+var dataCmd = &cobra.Command{
+	Use:   "data",
+	Short: "Export and import records as CSV data files",
+	Long: `Export and import records as CSV data files, for migrating data between instances.
+
+Data files have the format of the data files of modules: the name of the file is the
+name of the model, the ID column holds the external IDs of the records and relation
+fields hold the external IDs of the related records.`,
+}
+
+var dataExportCmd = &cobra.Command{
+	Use:   "export model...",
+	Short: "Export the records of models to CSV data files",
+	Long: `Export the records of the given model to a CSV data file.
+
+The records to export are filtered with --domain, given in the syntax of the client,
+e.g. "[('Active', '=', True)]". The exported fields default to the fields of the model
+that are stored and not computed. The contents of binary fields are written to files
+in a directory named after the model, next to the data file.
+
+The data file is written to <model>.csv, or to the file set with --output.
+
+If several models are given, all their records are exported to numbered data files
+of the directory set with --output, which defaults to "snapshot". The files are
+numbered so that the directory can be loaded into another database as is.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Set("Data.Models", args)
+		if len(args) > 1 && (viper.GetString("Data.Domain") != "" || len(viper.GetStringSlice("Data.Fields")) > 0) {
+			fmt.Println("Error: --domain and --fields can only be set when exporting a single model")
+			os.Exit(1)
+		}
+		if viper.GetString("Data.Output") == "" {
+			viper.Set("Data.Output", fmt.Sprintf("%s.csv", args[0]))
+			if len(args) > 1 {
+				viper.Set("Data.Output", "snapshot")
+			}
+		}
+		generateAndRunFile(viper.GetString("Data.ProjectDir"), dataExportFileName, dataExportTemplate)
+	},
+}
+
+var dataImportCmd = &cobra.Command{
+	Use:   "import file...",
+	Short: "Import CSV data files into the database",
+	Long: `Import the records of the given CSV data files into the database.
+
+Records are created or updated by external ID as when loading the data of modules,
+except that existing records are updated if --update is set. Records are committed
+by batches of --batch-size records. Lines that cannot be imported are reported with
+the reason of the failure, without preventing the other lines from being imported.
+The command exits with an error status if some lines could not be imported.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Set("Data.Files", args)
+		generateAndRunFile(viper.GetString("Data.ProjectDir"), dataImportFileName, dataImportTemplate)
+	},
+}
+
+// bootstrapData initializes the registry and the database connection
+// for the data commands.
+func bootstrapData(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	server.PostInitModules()
+}
+
+// DataExport exports the records of the models given in the configuration to
+// CSV data files. It is meant to be called from a project start file which
+// imports all the project's module.
+func DataExport(config map[string]interface{}) {
+	bootstrapData(config)
+	fileName := viper.GetString("Data.Output")
+	modelNames := viper.GetStringSlice("Data.Models")
+	var counts map[string]int
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		if len(modelNames) > 1 {
+			counts = models.ExportCSVSnapshot(env, fileName, modelNames...)
+			return
+		}
+		rc := env.Pool(modelNames[0])
+		if domain := viper.GetString("Data.Domain"); domain != "" {
+			cond, err := parseDomainString(domain)
+			if err != nil {
+				log.Panic("Invalid domain", "domain", domain, "error", err)
+			}
+			rc = rc.Search(cond)
+		} else {
+			rc = rc.SearchAll()
+		}
+		file, err := os.Create(fileName)
+		if err != nil {
+			log.Panic("Unable to create data file", "fileName", fileName, "error", err)
+		}
+		defer file.Close()
+		models.ExportCSVData(file, rc, filepath.Dir(fileName), viper.GetStringSlice("Data.Fields")...)
+		counts = map[string]int{rc.ModelName(): rc.Len()}
+	})
+	if err != nil {
+		if userErr, ok := err.(exceptions.UserError); ok {
+			err = fmt.Errorf("%s", userErr.Message)
+		}
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	for _, modelName := range modelNames {
+		fmt.Printf("%s: %d records exported to %s\n", modelName, counts[modelName], fileName)
+	}
+}
+
+// DataImport imports the CSV data files given in the configuration into the
+// database and prints a report of the import. It is meant to be called from
+// a project start file which imports all the project's module.
+func DataImport(config map[string]interface{}) {
+	bootstrapData(config)
+	var failed bool
+	for _, fileName := range viper.GetStringSlice("Data.Files") {
+		report, err := importDataFile(fileName)
+		if err != nil {
+			fmt.Printf("%s: %s\n", fileName, err)
+			failed = true
+			continue
+		}
+		printImportReport(report)
+		failed = failed || report.Failed > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printImportReport prints the counters and the errors of the given report
+func printImportReport(report models.ImportReport) {
+	fmt.Printf("%s (%s): %d created, %d updated, %d skipped, %d failed\n", report.FileName, report.ModelName,
+		report.Created, report.Updated, report.Skipped, report.Failed)
+	for _, importErr := range report.Errors {
+		if importErr.Field != "" {
+			fmt.Printf("  line %d (%s): %s: %s (%q)\n", importErr.Line, importErr.ExternalID, importErr.Field, importErr.Message, importErr.Value)
+			continue
+		}
+		fmt.Printf("  line %d (%s): %s\n", importErr.Line, importErr.ExternalID, importErr.Message)
+	}
+}
+
+// importDataFile imports the given data file and returns its report,
+// or an error if the file itself could not be imported.
+func importDataFile(fileName string) (report models.ImportReport, rError error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rError = fmt.Errorf("%v", r)
+			if userErr, ok := r.(exceptions.UserError); ok {
+				rError = fmt.Errorf("%s", userErr.Message)
+			}
+		}
+	}()
+	return models.ImportCSVDataFile(fileName, viper.GetInt("Data.BatchSize"), viper.GetBool("Data.Update")), nil
+}
+
+// parseDomainString returns the Condition of the given domain,
+// given as a string in the syntax of the client.
+func parseDomainString(src string) (*models.Condition, error) {
+	val, err := exprutils.Eval(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	dom, ok := val.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("domain must be a list, got %v", val)
+	}
+	return models.ParseDomain(dom)
+}
+
+func init() {
+	dataCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Data.ProjectDir", dataCmd.PersistentFlags().Lookup("project-dir"))
+
+	dataExportCmd.Flags().StringP("domain", "d", "", "Domain of the records to export")
+	viper.BindPFlag("Data.Domain", dataExportCmd.Flags().Lookup("domain"))
+	dataExportCmd.Flags().StringSliceP("fields", "f", []string{}, "Comma separated list of the fields to export")
+	viper.BindPFlag("Data.Fields", dataExportCmd.Flags().Lookup("fields"))
+	dataExportCmd.Flags().String("output", "", "Data file to write, or directory when exporting several models. Defaults to <model>.csv")
+	viper.BindPFlag("Data.Output", dataExportCmd.Flags().Lookup("output"))
+	dataCmd.AddCommand(dataExportCmd)
+
+	dataImportCmd.Flags().Int("batch-size", 100, "Number of records committed at once")
+	viper.BindPFlag("Data.BatchSize", dataImportCmd.Flags().Lookup("batch-size"))
+	dataImportCmd.Flags().Bool("update", false, "Update existing records")
+	viper.BindPFlag("Data.Update", dataImportCmd.Flags().Lookup("update"))
+	dataCmd.AddCommand(dataImportCmd)
+
+	DoxaCmd.AddCommand(dataCmd)
+}
+
+var dataExportTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.DataExport({{ .Config }})
+}
+`))
+
+var dataImportTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.DataImport({{ .Config }})
+}
+`))
@@ -0,0 +1,391 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const dbAnonymizeFileName string = "dbanonymize.go"
+
+const (
+	// dbArchiveDumpName is the name of the database dump in backup archives
+	dbArchiveDumpName = "database.dump"
+	// dbArchiveFileStoreDir is the directory of the filestore in backup archives
+	dbArchiveFileStoreDir = "filestore"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Back up, restore and anonymize the database",
+	Long: `Back up and restore the database and its filestore, and anonymize the personal data
+of the database.
+
+Backups are gzipped tar archives holding a dump of the database made with pg_dump in
+its custom format and the files of the filestore of the database, i.e. the content of
+the attachments. pg_dump and pg_restore must be installed and are run with the
+database connection parameters of the configuration.`,
+}
+
+var dbDumpCmd = &cobra.Command{
+	Use:   "dump [file]",
+	Short: "Back up the database and its filestore in an archive",
+	Long: `Back up the database and its filestore in the given archive file.
+The archive is written to <db-name>_<date>.tar.gz if no file is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fileName := fmt.Sprintf("%s_%s.tar.gz", viper.GetString("DB.Name"), time.Now().Format("20060102_150405"))
+		if len(args) > 0 {
+			fileName = args[0]
+		}
+		if err := dumpDB(fileName); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Database %s backed up to %s\n", viper.GetString("DB.Name"), fileName)
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore file",
+	Short: "Restore the database and its filestore from an archive",
+	Long: `Restore the database and its filestore from the given archive made by 'doxa db dump'.
+
+The database must exist. Its objects that are in the archive are dropped before being
+restored, and its filestore is replaced by the one of the archive. The archive can be
+restored into another database than the one it was made from with --db-name.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := restoreDB(args[0]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Database %s restored from %s\n", viper.GetString("DB.Name"), args[0])
+	},
+}
+
+var dbAnonymizeCmd = &cobra.Command{
+	Use:   "anonymize",
+	Short: "Scramble the personal data of the database",
+	Long: `Scramble the personal data of the database, so that a copy of a production database
+can be used for development.
+
+The values of the fields of the models that have an Anonymize parameter are replaced in all
+the records, e.g. with "Partner 42" for "name" fields, "partner.42@example.com" for "email"
+fields and NULL for "null" fields. The files of the filestore are not modified.
+
+This cannot be undone: the command must only be run on a copy of the database, e.g. restored
+with 'doxa db restore --db-name <copy>'. The name of the database is asked for confirmation,
+unless --yes is set.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dbName := viper.GetString("DB.Name")
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+			fmt.Printf("The personal data of database %s will be irreversibly scrambled.\n", dbName)
+			fmt.Print("Type the name of the database to confirm: ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if strings.TrimSpace(answer) != dbName {
+				fmt.Println("Aborted")
+				os.Exit(1)
+			}
+		}
+		generateAndRunFile(viper.GetString("Anonymize.ProjectDir"), dbAnonymizeFileName, dbAnonymizeTemplate)
+	},
+}
+
+// DBAnonymize scrambles the personal data of the database. It is meant to be
+// called from a project start file which imports all the project's module.
+func DBAnonymize(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	var res map[string]int64
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		res = models.Anonymize(env)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	modelNames := make([]string, 0, len(res))
+	for modelName := range res {
+		modelNames = append(modelNames, modelName)
+	}
+	sort.Strings(modelNames)
+	for _, modelName := range modelNames {
+		fmt.Printf("%-30s %d records anonymized\n", modelName, res[modelName])
+	}
+	fmt.Printf("Database %s anonymized\n", viper.GetString("DB.Name"))
+}
+
+// pgEnv returns the environment of the PostgreSQL client tools, with the
+// database connection parameters of the configuration.
+func pgEnv() ([]string, error) {
+	if driver := viper.GetString("DB.Driver"); driver != "postgres" {
+		return nil, fmt.Errorf("backups are not supported for database driver %s", driver)
+	}
+	env := append(os.Environ(), fmt.Sprintf("PGDATABASE=%s", viper.GetString("DB.Name")))
+	params := []struct {
+		variable string
+		key      string
+	}{
+		{"PGUSER", "DB.User"},
+		{"PGPASSWORD", "DB.Password"},
+		{"PGHOST", "DB.Host"},
+		{"PGSSLMODE", "DB.SSLMode"},
+		{"PGSSLCERT", "DB.SSLCert"},
+		{"PGSSLKEY", "DB.SSLKey"},
+		{"PGSSLROOTCERT", "DB.SSLCA"},
+	}
+	for _, param := range params {
+		if value := viper.GetString(param.key); value != "" {
+			env = append(env, fmt.Sprintf("%s=%s", param.variable, value))
+		}
+	}
+	if viper.GetString("DB.Host") != "" && viper.GetString("DB.Port") != "" {
+		env = append(env, fmt.Sprintf("PGPORT=%s", viper.GetString("DB.Port")))
+	}
+	return env, nil
+}
+
+// runPGTool runs the given PostgreSQL client tool with the given arguments
+func runPGTool(name string, args ...string) error {
+	env, err := pgEnv()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s", name, err)
+	}
+	return nil
+}
+
+// fileStoreDir returns the directory of the filestore of the configured database
+func fileStoreDir() string {
+	return filepath.Join(viper.GetString("DataDir"), "filestore", viper.GetString("DB.Name"))
+}
+
+// dumpDB writes a backup archive of the configured database
+// and of its filestore to the given file.
+func dumpDB(fileName string) (rErr error) {
+	tmpDir, err := ioutil.TempDir("", "doxa-dump")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	dumpFile := filepath.Join(tmpDir, dbArchiveDumpName)
+	if err = runPGTool("pg_dump", "--format=custom", "--no-owner", "--file", dumpFile); err != nil {
+		return err
+	}
+
+	out, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := out.Close(); rErr == nil {
+			rErr = err
+		}
+		if rErr != nil {
+			os.Remove(fileName)
+		}
+	}()
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+	if err = addFileToArchive(tw, dumpFile, dbArchiveDumpName); err != nil {
+		return err
+	}
+	fsDir := fileStoreDir()
+	err = filepath.Walk(fsDir, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case os.IsNotExist(err) && path == fsDir:
+			// No attachment has been stored yet
+			return nil
+		case err != nil:
+			return err
+		case info.IsDir() && info.Name() == "tmp":
+			// Skip files being written
+			return filepath.SkipDir
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		relPath, err := filepath.Rel(fsDir, path)
+		if err != nil {
+			return err
+		}
+		return addFileToArchive(tw, path, dbArchiveFileStoreDir+"/"+filepath.ToSlash(relPath))
+	})
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addFileToArchive adds the file at the given path to tw with the given name
+func addFileToArchive(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// restoreDB restores the configured database and its
+// filestore from the backup archive with the given name.
+func restoreDB(fileName string) error {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gr, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("%s is not a backup archive: %s", fileName, err)
+	}
+	tmpDir, err := ioutil.TempDir("", "doxa-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	dumpFile := filepath.Join(tmpDir, dbArchiveDumpName)
+	// Files are extracted next to the filestore and swapped only if the database is restored
+	fsDir := fileStoreDir()
+	newFSDir := fsDir + ".restore"
+	if err = os.RemoveAll(newFSDir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(newFSDir)
+	if err = os.MkdirAll(newFSDir, 0700); err != nil {
+		return err
+	}
+	var hasDump bool
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read archive %s: %s", fileName, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		var dest string
+		switch {
+		case header.Name == dbArchiveDumpName:
+			dest, hasDump = dumpFile, true
+		case strings.HasPrefix(header.Name, dbArchiveFileStoreDir+"/"):
+			relPath := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(header.Name, dbArchiveFileStoreDir+"/")))
+			if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) || filepath.IsAbs(relPath) {
+				return fmt.Errorf("invalid file name in archive: %s", header.Name)
+			}
+			dest = filepath.Join(newFSDir, relPath)
+		default:
+			continue
+		}
+		if err = extractArchiveFile(tr, dest); err != nil {
+			return err
+		}
+	}
+	if !hasDump {
+		return errors.New("the archive has no database dump")
+	}
+	err = runPGTool("pg_restore", "--clean", "--if-exists", "--no-owner", "--single-transaction",
+		"--dbname", viper.GetString("DB.Name"), dumpFile)
+	if err != nil {
+		return err
+	}
+	if err = os.RemoveAll(fsDir); err != nil {
+		return err
+	}
+	return os.Rename(newFSDir, fsDir)
+}
+
+// extractArchiveFile writes the content of the current file of tr to dest
+func extractArchiveFile(tr *tar.Reader, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, tr); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func init() {
+	dbCmd.AddCommand(dbDumpCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+	dbAnonymizeCmd.Flags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Anonymize.ProjectDir", dbAnonymizeCmd.Flags().Lookup("project-dir"))
+	dbAnonymizeCmd.Flags().Bool("yes", false, "Do not ask for confirmation")
+	dbCmd.AddCommand(dbAnonymizeCmd)
+	DoxaCmd.AddCommand(dbCmd)
+}
+
+var dbAnonymizeTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.DBAnonymize({{ .Config }})
+}
+`))
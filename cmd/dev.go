@@ -0,0 +1,236 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/labneco/doxa/doxa/tools/generate"
+	"github.com/spf13/viper"
+)
+
+const (
+	// devDebounceDelay is the time during which changes are gathered
+	// before the server is rebuilt or restarted in development mode
+	devDebounceDelay = 300 * time.Millisecond
+	// devStopTimeout is the time after which the server is killed
+	// if it has not stopped by itself in development mode
+	devStopTimeout = 10 * time.Second
+)
+
+// devResourceExtensions are the extensions of the resource files of modules which
+// are loaded at startup, so that the server only needs to be restarted when they change.
+var devResourceExtensions = map[string]bool{
+	".xml":  true,
+	".html": true,
+	".csv":  true,
+	".po":   true,
+	".css":  true,
+	".less": true,
+	".js":   true,
+}
+
+// A devChange is the kind of change of a watched file in development mode
+type devChange int
+
+const (
+	devNoChange devChange = iota
+	// devResourceChange means that the server must be restarted
+	devResourceChange
+	// devSourceChange means that the server must be rebuilt and restarted
+	devSourceChange
+)
+
+// devChangeOf returns the kind of change of the given file in development mode
+func devChangeOf(fileName string) devChange {
+	base := filepath.Base(fileName)
+	if strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") {
+		// Hidden or editor backup file
+		return devNoChange
+	}
+	ext := filepath.Ext(base)
+	switch {
+	case ext == ".go" && !strings.HasSuffix(base, "_test.go"):
+		return devSourceChange
+	case devResourceExtensions[ext]:
+		return devResourceChange
+	}
+	return devNoChange
+}
+
+// runDevServer runs the server of the project in the given directory in development
+// mode: the server is rebuilt and restarted when the Go sources of the modules or of
+// the pool change, and restarted when their resources change. All SQL queries are
+// logged to stdout.
+//
+// runDevServer returns when a SIGINT or SIGTERM signal is received.
+func runDevServer(projectDir string) {
+	viper.Set("LogStdout", true)
+	viper.Set("LogSQL", true)
+	buildDir, err := ioutil.TempDir("", "doxa-dev")
+	if err != nil {
+		log.Panic("Unable to create build directory", "error", err)
+	}
+	defer os.RemoveAll(buildDir)
+	binary := filepath.Join(buildDir, "doxa-dev")
+	startFile := createStartFile(projectDir, startFileName, startFileTemplate)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Panic("Unable to watch files", "error", err)
+	}
+	defer watcher.Close()
+	// The root directory is watched for the pool directory being recreated by 'doxa generate'
+	if err = watcher.Add(generate.DoxaDir); err != nil {
+		log.Panic("Unable to watch directory", "dir", generate.DoxaDir, "error", err)
+	}
+	dirs := []string{filepath.Join(generate.DoxaDir, PoolDirRel)}
+	for _, importPath := range viper.GetStringSlice("Modules") {
+		pack, err := build.Import(importPath, ".", build.FindOnly)
+		if err != nil {
+			log.Panic("Unable to find module", "module", importPath, "error", err)
+		}
+		dirs = append(dirs, pack.Dir)
+	}
+	for _, dir := range dirs {
+		if err := watchDirTree(watcher, dir); err != nil && !os.IsNotExist(err) {
+			log.Panic("Unable to watch directory", "dir", dir, "error", err)
+		}
+	}
+
+	var proc *devProcess
+	built := buildDevServer(startFile, binary)
+	if built {
+		proc = startDevProcess(binary)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	timer := time.NewTimer(devDebounceDelay)
+	timer.Stop()
+	pending := devNoChange
+	for {
+		select {
+		case event := <-watcher.Events:
+			change := devChangeOf(event.Name)
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() && event.Op&fsnotify.Create != 0 {
+				// Files may have been created in the new directory before it is watched
+				watchDirTree(watcher, event.Name)
+				change = devSourceChange
+			}
+			if change == devNoChange {
+				continue
+			}
+			if change > pending {
+				pending = change
+			}
+			timer.Reset(devDebounceDelay)
+		case err := <-watcher.Errors:
+			fmt.Println("Error while watching files:", err)
+		case <-timer.C:
+			if pending == devSourceChange || !built {
+				fmt.Println("Sources changed, rebuilding ...")
+				if built = buildDevServer(startFile, binary); !built {
+					// Keep the current server until the sources are fixed
+					pending = devNoChange
+					continue
+				}
+			} else {
+				fmt.Println("Resources changed, restarting ...")
+			}
+			pending = devNoChange
+			proc.stop()
+			proc = startDevProcess(binary)
+		case <-signals:
+			proc.stop()
+			return
+		}
+	}
+}
+
+// watchDirTree adds dir and all its subdirectories, except hidden ones, to watcher
+func watchDirTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// buildDevServer builds the given start file into binary.
+// It returns false and prints the errors if the build fails.
+func buildDevServer(startFile, binary string) bool {
+	cmd := exec.Command("go", "build", "-o", binary, startFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Println("Build failed, waiting for changes:", err)
+		return false
+	}
+	return true
+}
+
+// A devProcess is a server process started in development mode
+type devProcess struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// startDevProcess starts the given server binary and returns its devProcess,
+// or nil if it could not be started.
+func startDevProcess(binary string) *devProcess {
+	cmd := exec.Command(binary)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Println("Unable to start server:", err)
+		return nil
+	}
+	proc := &devProcess{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			fmt.Println("Server stopped, waiting for changes:", err)
+		}
+		close(proc.done)
+	}()
+	return proc
+}
+
+// stop stops the process gracefully, or kills it if it
+// does not stop within devStopTimeout. It is a no-op on nil.
+func (p *devProcess) stop() {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(devStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
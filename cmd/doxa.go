@@ -50,12 +50,36 @@ func init() {
 	viper.BindPFlag("LogLevel", DoxaCmd.PersistentFlags().Lookup("log-level"))
 	DoxaCmd.PersistentFlags().String("log-file", "", "File to which the log will be written")
 	viper.BindPFlag("LogFile", DoxaCmd.PersistentFlags().Lookup("log-file"))
+	DoxaCmd.PersistentFlags().Int("log-file-max-size", 0, "Size in megabytes above which the log file is rotated. 0 disables size-based rotation")
+	viper.BindPFlag("LogFileMaxSize", DoxaCmd.PersistentFlags().Lookup("log-file-max-size"))
+	DoxaCmd.PersistentFlags().String("log-file-rotate", "", "Period after which the log file is rotated. Should be one of 'hourly', 'daily' or 'weekly'. Empty disables time-based rotation")
+	viper.BindPFlag("LogFileRotate", DoxaCmd.PersistentFlags().Lookup("log-file-rotate"))
+	DoxaCmd.PersistentFlags().Int("log-file-max-backups", 0, "Number of rotated log files to keep. 0 keeps all files")
+	viper.BindPFlag("LogFileMaxBackups", DoxaCmd.PersistentFlags().Lookup("log-file-max-backups"))
+	DoxaCmd.PersistentFlags().String("log-format", "text", "Format of the stdout and file logs. Should be one of 'text' or 'json'")
+	viper.BindPFlag("LogFormat", DoxaCmd.PersistentFlags().Lookup("log-format"))
 	DoxaCmd.PersistentFlags().BoolP("log-stdout", "o", false, "Enable stdout logging. Use for development or debugging.")
 	viper.BindPFlag("LogStdout", DoxaCmd.PersistentFlags().Lookup("log-stdout"))
+	DoxaCmd.PersistentFlags().Bool("log-sql", false, "Log all SQL queries whatever the log level")
+	viper.BindPFlag("LogSQL", DoxaCmd.PersistentFlags().Lookup("log-sql"))
 	DoxaCmd.PersistentFlags().Bool("debug", false, "Enable server debug mode for development")
 	viper.BindPFlag("Debug", DoxaCmd.PersistentFlags().Lookup("debug"))
 	DoxaCmd.PersistentFlags().Bool("demo", false, "Load demo data for evaluating or tests")
 	viper.BindPFlag("Demo", DoxaCmd.PersistentFlags().Lookup("demo"))
+	DoxaCmd.PersistentFlags().StringSlice("demo-tags", []string{}, "Comma separated tags of the demo data files to load. Untagged files are always loaded. Defaults to all files")
+	viper.BindPFlag("DemoTags", DoxaCmd.PersistentFlags().Lookup("demo-tags"))
+	DoxaCmd.PersistentFlags().Bool("demo-large", false, "Also generate the volumes of fake records declared by the modules when loading demo data, e.g. for performance tests")
+	viper.BindPFlag("DemoLarge", DoxaCmd.PersistentFlags().Lookup("demo-large"))
+	DoxaCmd.PersistentFlags().Int64("demo-seed", 1, "Seed of the generator of large demo records. The same seed generates the same records")
+	viper.BindPFlag("DemoSeed", DoxaCmd.PersistentFlags().Lookup("demo-seed"))
+	DoxaCmd.PersistentFlags().Float64("demo-scale", 1, "Factor applied to the volumes of large demo records")
+	viper.BindPFlag("DemoScale", DoxaCmd.PersistentFlags().Lookup("demo-scale"))
+	DoxaCmd.PersistentFlags().Int("data-batch-size", 1000, "Number of records of CSV data files loaded in each transaction. 0 loads each file in a single transaction")
+	viper.BindPFlag("DataBatchSize", DoxaCmd.PersistentFlags().Lookup("data-batch-size"))
+	DoxaCmd.PersistentFlags().Bool("data-collect-errors", false, "Skip the lines of CSV data files that cannot be loaded and report them at the end, instead of aborting")
+	viper.BindPFlag("DataCollectErrors", DoxaCmd.PersistentFlags().Lookup("data-collect-errors"))
+	DoxaCmd.PersistentFlags().Bool("strict-views", true, "Panic at startup if a view is invalid. If false, invalid views are logged and discarded")
+	viper.BindPFlag("StrictViews", DoxaCmd.PersistentFlags().Lookup("strict-views"))
 
 	DoxaCmd.PersistentFlags().String("data-dir", "", "Path to the directory where Doxa should store its data")
 	viper.BindPFlag("DataDir", DoxaCmd.PersistentFlags().Lookup("data-dir"))
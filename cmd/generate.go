@@ -49,6 +49,19 @@ This command must be rerun after each source code modification, including module
 	},
 }
 
+var generatePoolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "Generate only the source code of the model pool",
+	Long: `Generate the source code of the pool package which includes the definition of all the models.
+For each model, the pool defines typed RecordSet and Data types with a getter and a setter for
+each field and a method for each model method with its actual signature, so that module code is
+checked at compile time.
+Unlike 'doxa generate', this command does not recreate the symlinks to the modules' resources.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		generatePool(false)
+	},
+}
+
 var symlinkDirs = []string{"static", "templates", "data", "demo", "resources", "i18n"}
 
 var (
@@ -60,9 +73,20 @@ func init() {
 	DoxaCmd.AddCommand(generateCmd)
 	generateCmd.Flags().StringVarP(&testedModule, "test", "t", "", "Generate pool for testing the module in the given source directory. When set projectDir is ignored.")
 	generateCmd.Flags().BoolVar(&generateEmptyPool, "empty", false, "Generate an empty pool package. When set projectDir is ignored.")
+	generateCmd.AddCommand(generatePoolCmd)
+	generatePoolCmd.Flags().StringVarP(&testedModule, "test", "t", "", "Generate pool for testing the module in the given source directory. When set projectDir is ignored.")
+	generatePoolCmd.Flags().BoolVar(&generateEmptyPool, "empty", false, "Generate an empty pool package. When set projectDir is ignored.")
 }
 
+// runGenerate generates the pool and the symlinks to the modules' resources
 func runGenerate() {
+	generatePool(true)
+}
+
+// generatePool generates the source code of the pool package from the
+// models of the configured modules. If withSymlinks is true, the symlinks
+// to the resources of the modules are also created in the server directory.
+func generatePool(withSymlinks bool) {
 	poolDir := filepath.Join(generate.DoxaDir, PoolDirRel)
 	cleanPoolDir(poolDir)
 	if generateEmptyPool {
@@ -100,20 +124,22 @@ func runGenerate() {
 	program, _ := conf.Load()
 	fmt.Println("Ok")
 
-	fmt.Print("Generating symlinks...")
-	modules := generate.GetModulePackages(program)
-	cleanModuleSymlinks()
-	for _, m := range modules {
-		if m.ModType != generate.Base {
-			continue
-		}
-		pkg, err := build.Import(m.Pkg.Path(), "", 0)
-		if err != nil {
-			panic(err)
+	if withSymlinks {
+		fmt.Print("Generating symlinks...")
+		modules := generate.GetModulePackages(program)
+		cleanModuleSymlinks()
+		for _, m := range modules {
+			if m.ModType != generate.Base {
+				continue
+			}
+			pkg, err := build.Import(m.Pkg.Path(), "", 0)
+			if err != nil {
+				panic(err)
+			}
+			createModuleSymlinks(pkg)
 		}
-		createModuleSymlinks(pkg)
+		fmt.Println("Ok")
 	}
-	fmt.Println("Ok")
 
 	fmt.Print("Generating pool...")
 	generate.CreatePool(program, poolDir)
@@ -6,22 +6,31 @@ package cmd
 import (
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/beevik/etree"
 	"github.com/labneco/doxa/doxa/actions"
 	"github.com/labneco/doxa/doxa/i18n"
 	"github.com/labneco/doxa/doxa/menus"
 	"github.com/labneco/doxa/doxa/models/types"
-	"github.com/labneco/doxa/doxa/server"
 	"github.com/labneco/doxa/doxa/tools/generate"
 	"github.com/labneco/doxa/doxa/tools/po"
 	"github.com/labneco/doxa/doxa/tools/strutils"
 	"github.com/labneco/doxa/doxa/views"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 	"golang.org/x/tools/go/loader"
 )
 
@@ -32,35 +41,278 @@ var i18nCmd = &cobra.Command{
 }
 
 var i18nUpdate = &cobra.Command{
-	Use:   "update [dir]",
-	Short: "Create or update PO files",
-	Long: `Create or update PO files of the module specified by 'dir'.
-PO files will be generated for each loaded language (--language flag)
-in the i18n directory of the module.`,
+	Use:     "update [dir...]",
+	Aliases: []string{"extract"},
+	Short:   "Create or update the POT and PO files of modules",
+	Long: `Extract the translatable strings of the modules specified by 'dir' and of the
+modules given with the --modules flag, and synchronize the translation files of the
+i18n directory of each module with them.
+
+Translatable strings are the strings passed to T, TC, TN and i18n.Lazy in Go sources,
+the String, Help and Selection of fields, the strings of the views, menus and actions
+defined in XML resources, and the strings passed to _t in javascript files.
+
+The strings are written in the <module>.pot template file, which is then merged into the
+PO file of each language given with the --languages flag (created if needed), or into all
+the PO files of the module if no language is given:
+ - translations of existing strings are kept,
+ - new strings that are similar to a removed string get its translation, marked fuzzy,
+ - translations of strings whose plural form has changed are marked fuzzy,
+ - other new strings are added untranslated and removed strings are deleted.
+
+Modules given with --modules are Go import paths. If neither 'dir' nor --modules is
+given, the module in the current directory is updated.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		moduleDir := "."
-		if len(args) > 0 {
-			moduleDir = args[0]
-		}
 		langs, err := cmd.Flags().GetStringSlice("languages")
 		if err != nil {
 			log.Panic("Unable to read languages from the command line")
 		}
-		updatePOFiles(moduleDir, langs)
+		moduleDirs := args
+		if cmd.Flag("modules").Changed {
+			for _, modulePath := range viper.GetStringSlice("Modules") {
+				moduleDir, err := moduleDirFromImportPath(modulePath)
+				if err != nil {
+					fmt.Printf("Unable to find module %s: %s\n", modulePath, err)
+					os.Exit(1)
+				}
+				moduleDirs = append(moduleDirs, moduleDir)
+			}
+		}
+		if len(moduleDirs) == 0 {
+			moduleDirs = []string{"."}
+		}
+		for _, moduleDir := range moduleDirs {
+			updatePOFiles(moduleDir, langs, os.Stdout)
+		}
 	},
 }
 
+// moduleDirFromImportPath returns the directory of the module with the given
+// import path, relative to the current directory since Go sources of modules
+// are loaded from relative paths.
+func moduleDirFromImportPath(importPath string) (string, error) {
+	pack, err := build.Import(importPath, ".", build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	relDir, err := filepath.Rel(wd, pack.Dir)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(relDir, "..") {
+		relDir = "." + string(filepath.Separator) + relDir
+	}
+	return relDir, nil
+}
+
 // A messageRef identifies unique messages
 type messageRef struct {
 	msgId   string
 	msgCtxt string
 }
 
-// updatePOFiles creates or updates PO files of the module in the given
-// dir with the data in the Translation registry.
-func updatePOFiles(moduleDir string, langs []string) {
+var i18nCoverage = &cobra.Command{
+	Use:   "coverage [dir...]",
+	Short: "Report the translation coverage of modules",
+	Long: `Report, for each module specified by 'dir' and each language, the number and
+percentage of translated messages of the PO files of the i18n directory of the module.
+
+Messages to translate are those of the <module>.pot template file if it exists, or those
+of each PO file otherwise. Fuzzy messages are not counted as translated.
+
+Only the languages given with the --languages flag are reported, or all the PO files of
+the modules if no language is given. Untranslated messages are listed with --missing.
+With --fail-under, the command exits with an error status if the coverage of a PO file
+is below the given percentage.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		langs, err := cmd.Flags().GetStringSlice("languages")
+		if err != nil {
+			log.Panic("Unable to read languages from the command line")
+		}
+		missing, _ := cmd.Flags().GetBool("missing")
+		failUnder, _ := cmd.Flags().GetFloat64("fail-under")
+		var coverages []translationCoverage
+		for _, moduleDir := range args {
+			coverages = append(coverages, moduleTranslationCoverage(moduleDir, langs)...)
+		}
+		printTranslationCoverage(os.Stdout, coverages, missing)
+		for _, cov := range coverages {
+			if cov.Percent() < failUnder {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// A translationCoverage is the coverage of the PO file of a module for a language
+type translationCoverage struct {
+	po.Coverage
+	module string
+	lang   string
+}
+
+// moduleTranslationCoverage returns the translation coverage of the PO files
+// of the module in the given dir for the given langs, or for all its PO files
+// if langs is empty. Languages without PO file are reported untranslated.
+func moduleTranslationCoverage(moduleDir string, langs []string) []translationCoverage {
+	absDir, err := filepath.Abs(moduleDir)
+	if err != nil {
+		log.Panic("Unable to find module directory", "dir", moduleDir, "error", err)
+	}
+	moduleName := filepath.Base(absDir)
 	i18nDir := filepath.Join(moduleDir, "i18n")
-	server.LoadModuleTranslations(i18nDir, langs)
+	var template *po.File
+	potFile := filepath.Join(i18nDir, fmt.Sprintf("%s.pot", moduleName))
+	if _, err = os.Stat(potFile); err == nil {
+		template, err = po.Load(potFile)
+		if err != nil {
+			log.Panic("Error while parsing POT file", "file", potFile, "error", err)
+		}
+	}
+	if len(langs) == 0 {
+		poFiles, err := filepath.Glob(filepath.Join(i18nDir, "*.po"))
+		if err != nil {
+			log.Panic("Unable to scan directory for PO files", "dir", i18nDir, "error", err)
+		}
+		for _, poFile := range poFiles {
+			langs = append(langs, strings.TrimSuffix(filepath.Base(poFile), ".po"))
+		}
+	}
+	res := make([]translationCoverage, len(langs))
+	for i, lang := range langs {
+		file := &po.File{}
+		poFile := filepath.Join(i18nDir, fmt.Sprintf("%s.po", lang))
+		if _, err = os.Stat(poFile); err == nil {
+			file, err = po.Load(poFile)
+			if err != nil {
+				log.Panic("Error while parsing PO file", "file", poFile, "error", err)
+			}
+		}
+		res[i] = translationCoverage{
+			Coverage: file.Coverage(template),
+			module:   moduleName,
+			lang:     lang,
+		}
+	}
+	return res
+}
+
+// printTranslationCoverage writes the given coverages as a table to w.
+// If missing is true, untranslated messages are listed below each row.
+func printTranslationCoverage(w io.Writer, coverages []translationCoverage, missing bool) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tLANGUAGE\tTRANSLATED\tTOTAL\tCOVERAGE")
+	for _, cov := range coverages {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\n", cov.module, cov.lang, cov.Translated, cov.Total, cov.Percent())
+		if !missing {
+			continue
+		}
+		for _, msg := range cov.Missing {
+			msgID := strconv.Quote(msg.MsgId)
+			if msg.MsgContext != "" {
+				msgID = fmt.Sprintf("%s (context %s)", msgID, strconv.Quote(msg.MsgContext))
+			}
+			fmt.Fprintf(tw, "\t\t%s\n", msgID)
+		}
+	}
+	tw.Flush()
+}
+
+// updatePOFiles writes the POT file of the module in the given dir and merges
+// it into the PO files of the given langs, or of all languages if langs is
+// empty. A summary of the merge of each PO file is written to w.
+func updatePOFiles(moduleDir string, langs []string, w io.Writer) {
+	absDir, err := filepath.Abs(moduleDir)
+	if err != nil {
+		log.Panic("Unable to find module directory", "dir", moduleDir, "error", err)
+	}
+	moduleName := filepath.Base(absDir)
+	i18nDir := filepath.Join(moduleDir, "i18n")
+	if err = os.MkdirAll(i18nDir, 0755); err != nil {
+		log.Panic("Unable to create i18n directory", "dir", i18nDir, "error", err)
+	}
+	template := po.File{
+		Messages: moduleMessages(moduleDir, loadModelsASTData(moduleDir), ""),
+		MimeHeader: po.Header{
+			ProjectIdVersion:        moduleName,
+			POTCreationDate:         time.Now().Format("2006-01-02 15:04-0700"),
+			ContentType:             "text/plain; charset=utf-8",
+			ContentTransferEncoding: "8bit",
+			MimeVersion:             "1.0",
+		},
+	}
+	potFile := filepath.Join(i18nDir, fmt.Sprintf("%s.pot", moduleName))
+	if err = template.Save(potFile); err != nil {
+		log.Panic("Error while saving POT file", "file", potFile, "error", err)
+	}
+	fmt.Fprintf(w, "%s: %d strings\n", potFile, len(template.Messages))
+	if len(langs) == 0 {
+		poFiles, err := filepath.Glob(filepath.Join(i18nDir, "*.po"))
+		if err != nil {
+			log.Panic("Unable to scan directory for PO files", "dir", i18nDir, "error", err)
+		}
+		for _, poFile := range poFiles {
+			langs = append(langs, strings.TrimSuffix(filepath.Base(poFile), ".po"))
+		}
+	}
+	for _, lang := range langs {
+		poFile := filepath.Join(i18nDir, fmt.Sprintf("%s.po", lang))
+		file, stats := mergePOFile(poFile, lang, template)
+		if err = file.Save(poFile); err != nil {
+			log.Panic("Error while saving PO file", "file", poFile, "error", err)
+		}
+		fmt.Fprintf(w, "%s: %d kept, %d fuzzy, %d new, %d obsolete\n", poFile,
+			stats.Kept, stats.Fuzzy, stats.New, stats.Obsolete)
+	}
+}
+
+// mergePOFile returns the content of the PO file with the given name for the
+// given lang merged with the messages of the given template (see po.File.Merge),
+// and the statistics of the merge. A new PO file is returned if there is no
+// such file.
+func mergePOFile(fileName, lang string, template po.File) (*po.File, po.MergeStats) {
+	file := &po.File{
+		MimeHeader: po.Header{
+			Language:                lang,
+			ContentType:             "text/plain; charset=utf-8",
+			ContentTransferEncoding: "8bit",
+			MimeVersion:             "1.0",
+		},
+	}
+	if _, err := os.Stat(fileName); err == nil {
+		file, err = po.Load(fileName)
+		if err != nil {
+			log.Panic("Error while parsing PO file", "file", fileName, "error", err)
+		}
+	}
+	file.MimeHeader.POTCreationDate = template.MimeHeader.POTCreationDate
+	nPlurals := 2
+	if file.MimeHeader.PluralForms == "" {
+		if pf := i18n.Registry.PluralForms(lang); pf != nil {
+			file.MimeHeader.PluralForms = pf.String()
+		}
+	}
+	if file.MimeHeader.PluralForms != "" {
+		pf, err := i18n.ParsePluralForms(file.MimeHeader.PluralForms)
+		if err != nil {
+			log.Panic("Invalid Plural-Forms in PO file header", "file", fileName, "error", err)
+		}
+		nPlurals = pf.NPlurals()
+	}
+	stats := file.Merge(&template, nPlurals)
+	return file, stats
+}
+
+// loadModelsASTData returns the AST data of the models
+// defined in the Go package of the module in the given dir.
+func loadModelsASTData(moduleDir string) map[string]generate.ModelASTData {
 	conf := loader.Config{}
 	conf.Import(moduleDir)
 	program, err := conf.Load()
@@ -72,46 +324,40 @@ func updatePOFiles(moduleDir string, langs []string) {
 		log.Panic("Something has gone wrong, we have more than one package", "packs", packs)
 	}
 	modInfos := []*generate.ModuleInfo{{PackageInfo: *packs[0], ModType: generate.Base}}
-	modelsASTData := generate.GetModelsASTDataForModules(modInfos, false)
+	return generate.GetModelsASTDataForModules(modInfos, false)
+}
 
-	for _, lang := range langs {
-		messages := make(map[messageRef]po.Message)
-		for model, modelASTData := range modelsASTData {
-			for field, fieldASTData := range modelASTData.Fields {
-				messages = addDescriptionToMessages(lang, model, field, fieldASTData, messages)
-				messages = addHelpToMessages(lang, model, field, fieldASTData, messages)
-				messages = addSelectionToMessages(lang, model, field, fieldASTData, messages)
-			}
+// moduleMessages returns the translatable messages of the module in the given
+// dir with their translation in the given lang from the Translation registry.
+func moduleMessages(moduleDir string, modelsASTData map[string]generate.ModelASTData, lang string) []po.Message {
+	messages := make(map[messageRef]po.Message)
+	for model, modelASTData := range modelsASTData {
+		for field, fieldASTData := range modelASTData.Fields {
+			messages = addDescriptionToMessages(lang, model, field, fieldASTData, messages)
+			messages = addHelpToMessages(lang, model, field, fieldASTData, messages)
+			messages = addSelectionToMessages(lang, model, field, fieldASTData.Selection, messages)
 		}
-		messages = addResourceItemsToMessages(lang, filepath.Join(moduleDir, "resources"), messages)
-		messages = addCodeToMessages(lang, moduleDir, messages)
-
-		msgs := make([]po.Message, len(messages))
-		i := 0
-		for _, m := range messages {
-			m.ExtractedComment = strings.TrimSuffix(m.ExtractedComment, "\n")
-			msgs[i] = m
-			i += 1
-		}
-		file := po.File{
-			Messages: msgs,
-			MimeHeader: po.Header{
-				Language:                lang,
-				ContentType:             "text/plain; charset=utf-8",
-				ContentTransferEncoding: "8bit",
-				MimeVersion:             "1.0",
-			},
-		}
-		err = file.Save(fmt.Sprintf("%s/%s.po", i18nDir, lang))
-		if err != nil {
-			log.Panic("Error while saving PO file", "error", err)
+		for field, selection := range modelASTData.SelectionUpdates {
+			messages = addSelectionToMessages(lang, model, field, selection, messages)
 		}
 	}
+	messages = addResourceItemsToMessages(lang, filepath.Join(moduleDir, "resources"), messages)
+	messages = addCodeToMessages(lang, moduleDir, messages)
+	messages = addStaticToMessages(lang, filepath.Join(moduleDir, "static"), messages)
+
+	msgs := make([]po.Message, len(messages))
+	i := 0
+	for _, m := range messages {
+		m.ExtractedComment = strings.TrimSuffix(m.ExtractedComment, "\n")
+		msgs[i] = m
+		i += 1
+	}
+	return msgs
 }
 
 // addCodeToMessages adds to the given messages map the translatable fields of the code
 // defined in go files inside the given resourcesDir and sub directories.
-// This extracts strings given as argument to T().
+// This extracts strings given as argument to T(), TC(), TN() and i18n.Lazy().
 func addCodeToMessages(lang string, moduleDir string, messages map[messageRef]po.Message) map[messageRef]po.Message {
 	fSet := token.NewFileSet()
 	goFiles, err := filepath.Glob(fmt.Sprintf("%s/**.go", moduleDir))
@@ -130,6 +376,19 @@ func addCodeToMessages(lang string, moduleDir string, messages map[messageRef]po
 				if err != nil {
 					return true
 				}
+				switch fnctName {
+				case "TN":
+					messages = addPluralCodeToMessages(lang, node, messages)
+					return true
+				case "TC":
+					messages = addContextCodeToMessages(lang, node, messages)
+					return true
+				case "Lazy":
+					if strArgs := stringLiteralArgs(node); len(strArgs) > 0 {
+						messages = addCodeStringToMessages(lang, "", strArgs[0], messages)
+					}
+					return true
+				}
 				if fnctName != "T" {
 					return true
 				}
@@ -149,6 +408,109 @@ func addCodeToMessages(lang string, moduleDir string, messages map[messageRef]po
 	return messages
 }
 
+// jsTranslationCall matches the calls to _t() of javascript files
+// with a single or double quoted string literal as first argument.
+var jsTranslationCall = regexp.MustCompile(`\b_t\(\s*(?:"((?:[^"\\\n]|\\.)*)"|'((?:[^'\\\n]|\\.)*)')`)
+
+// jsUnescaper unescapes the string literals of javascript files
+var jsUnescaper = strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\n`, "\n", `\t`, "\t", `\\`, `\`)
+
+// addStaticToMessages adds to the given messages map the strings of the web
+// client defined in the javascript files inside the given staticDir and sub
+// directories. This extracts strings given as argument to _t(). They are
+// served to the client in the translation catalogs of the server.
+func addStaticToMessages(lang string, staticDir string, messages map[messageRef]po.Message) map[messageRef]po.Message {
+	filepath.Walk(staticDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".js" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Panic("Unable to read javascript file", "file", path, "error", err)
+		}
+		for _, match := range jsTranslationCall.FindAllStringSubmatch(string(content), -1) {
+			src := match[1]
+			if src == "" {
+				src = match[2]
+			}
+			if src == "" {
+				continue
+			}
+			messages = addCodeStringToMessages(lang, "", jsUnescaper.Replace(src), messages)
+		}
+		return nil
+	})
+	return messages
+}
+
+// stringLiteralArgs returns the values of the string literal arguments of the given call
+func stringLiteralArgs(node *ast.CallExpr) []string {
+	var res []string
+	for _, arg := range node.Args {
+		lit, ok := arg.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			continue
+		}
+		str, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			continue
+		}
+		res = append(res, str)
+	}
+	return res
+}
+
+// addContextCodeToMessages adds to the given messages map the message given as
+// argument to the given TC() call. The context and the source string are the
+// first two string literal arguments of the call.
+func addContextCodeToMessages(lang string, node *ast.CallExpr, messages map[messageRef]po.Message) map[messageRef]po.Message {
+	strArgs := stringLiteralArgs(node)
+	if len(strArgs) < 2 {
+		return messages
+	}
+	return addCodeStringToMessages(lang, strArgs[0], strArgs[1], messages)
+}
+
+// addCodeStringToMessages adds to the given messages map the given
+// code string src in the given context.
+func addCodeStringToMessages(lang, context, src string, messages map[messageRef]po.Message) map[messageRef]po.Message {
+	codeTrans := i18n.TranslateCode(lang, context, src)
+	if codeTrans == src {
+		codeTrans = ""
+	}
+	msgRef := messageRef{msgId: src, msgCtxt: context}
+	msg := getOrCreateMessage(messages, msgRef, codeTrans)
+	msg.ExtractedComment += "code:\n"
+	messages[msgRef] = msg
+	return messages
+}
+
+// addPluralCodeToMessages adds to the given messages map the message with plural
+// forms given as argument to the given TN() call. The singular and plural forms
+// are the first two string literal arguments of the call.
+func addPluralCodeToMessages(lang string, node *ast.CallExpr, messages map[messageRef]po.Message) map[messageRef]po.Message {
+	strArgs := stringLiteralArgs(node)
+	if len(strArgs) < 2 {
+		return messages
+	}
+	msgRef := messageRef{msgId: strArgs[0]}
+	msg := getOrCreateMessage(messages, msgRef, "")
+	msg.MsgIdPlural = strArgs[1]
+	if len(msg.MsgStrPlural) == 0 {
+		msg.MsgStrPlural = i18n.Registry.PluralTranslations(lang, "", strArgs[0])
+	}
+	if len(msg.MsgStrPlural) == 0 {
+		nPlurals := 2
+		if pf := i18n.Registry.PluralForms(lang); pf != nil {
+			nPlurals = pf.NPlurals()
+		}
+		msg.MsgStrPlural = make([]string, nPlurals)
+	}
+	msg.ExtractedComment += "code:\n"
+	messages[msgRef] = msg
+	return messages
+}
+
 // addResourceItemsToMessages adds to the given messages map the translatable fields of the views
 // defined in XML files inside the given resourcesDir
 func addResourceItemsToMessages(lang string, resourcesDir string, messages map[messageRef]po.Message) map[messageRef]po.Message {
@@ -207,12 +569,14 @@ func updateMessagesWithResourceTranslation(lang, id, source string, messages map
 	return messages
 }
 
-// addSelectionToMessages adds to the given messages map the selections for the given model and field
-func addSelectionToMessages(lang string, model string, field string, fieldASTData generate.FieldASTData, messages map[messageRef]po.Message) map[messageRef]po.Message {
-	if len(fieldASTData.Selection) == 0 {
+// addSelectionToMessages adds to the given messages map the labels of the given selection
+// of the given model and field, either from its definition or from a SetSelection or
+// UpdateSelection call.
+func addSelectionToMessages(lang string, model string, field string, sel map[string]string, messages map[messageRef]po.Message) map[messageRef]po.Message {
+	if len(sel) == 0 {
 		return messages
 	}
-	selection := types.Selection(fieldASTData.Selection)
+	selection := types.Selection(sel)
 	selTranslated := i18n.TranslateFieldSelection(lang, model, field, selection)
 	for k, v := range selection {
 		transValue := selTranslated[k]
@@ -221,7 +585,10 @@ func addSelectionToMessages(lang string, model string, field string, fieldASTDat
 		}
 		msgRef := messageRef{msgId: v}
 		msg := getOrCreateMessage(messages, msgRef, transValue)
-		msg.ExtractedComment += fmt.Sprintf("selection:%s.%s\n", model, field)
+		comment := fmt.Sprintf("selection:%s.%s\n", model, field)
+		if !strings.Contains(msg.ExtractedComment, comment) {
+			msg.ExtractedComment += comment
+		}
 		messages[msgRef] = msg
 	}
 	return messages
@@ -235,7 +602,8 @@ func getOrCreateMessage(messages map[messageRef]po.Message, msgRef messageRef, v
 	msg, ok := messages[msgRef]
 	if !ok {
 		msg = po.Message{
-			MsgId: msgRef.msgId,
+			MsgId:      msgRef.msgId,
+			MsgContext: msgRef.msgCtxt,
 		}
 	}
 	if msg.MsgStr == "" {
@@ -272,8 +640,21 @@ func addHelpToMessages(lang string, model string, field string, fieldASTData gen
 	return messages
 }
 
+// languagesFlagAlias accepts --lang as an alias of the --languages flag
+func languagesFlagAlias(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "lang" {
+		name = "languages"
+	}
+	return pflag.NormalizedName(name)
+}
+
 func init() {
-	i18nUpdate.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of languages codes to load (ex: fr,de,es).")
+	i18nUpdate.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of languages codes of the PO files to create or update (ex: fr,de,es). Defaults to all PO files of the module.")
+	i18nUpdate.SetGlobalNormalizationFunc(languagesFlagAlias)
+	i18nCoverage.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of languages codes to report (ex: fr,de,es). Defaults to all PO files of the modules.")
+	i18nCoverage.PersistentFlags().Bool("missing", false, "List the untranslated messages of each PO file")
+	i18nCoverage.PersistentFlags().Float64("fail-under", 0, "Exit with an error status if the coverage of a PO file is below this percentage")
 	DoxaCmd.AddCommand(i18nCmd)
 	i18nCmd.AddCommand(i18nUpdate)
+	i18nCmd.AddCommand(i18nCoverage)
 }
@@ -0,0 +1,271 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	modulesListFileName      string = "moduleslist.go"
+	modulesInstallFileName   string = "modulesinstall.go"
+	modulesUpgradeFileName   string = "modulesupgrade.go"
+	modulesUninstallFileName string = "modulesuninstall.go"
+)
+
+var modulesCmd = &cobra.Command{
+	Use:   "modules",
+	Short: "Manage the modules installed in the database",
+	Long: `Manage the modules installed in the database.
+
+The modules of the project are the modules set in the Modules configuration. The modules
+installed in the database and their versions are recorded in the Module model, so that
+the data of a module is migrated when it is upgraded to a newer version.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+}
+
+var modulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the modules of the project and their installation state",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		generateAndRunFile(viper.GetString("Module.ProjectDir"), modulesListFileName, modulesListTemplate)
+	},
+}
+
+var modulesInstallCmd = &cobra.Command{
+	Use:   "install [module...]",
+	Short: "Install modules in the database",
+	Long: `Install the given modules of the project in the database, or all the modules of the
+project that are not installed if no module is given.
+
+The database schema is synchronized with the models, then the data records of the modules
+(and their demo records in demo mode) are loaded and their versions are recorded.
+A module must be added to the Modules configuration and the pool generated with
+'doxa generate' before it can be installed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Set("Module.Names", args)
+		generateAndRunFile(viper.GetString("Module.ProjectDir"), modulesInstallFileName, modulesInstallTemplate)
+	},
+}
+
+var modulesUpgradeCmd = &cobra.Command{
+	Use:   "upgrade [module...]",
+	Short: "Upgrade installed modules to the version of the project",
+	Long: `Upgrade the given installed modules, or all the installed modules if no module is given,
+to their version in the project.
+
+The database schema is synchronized with the models, then the migrations of each module
+that are newer than its installed version are run in version order and its data records
+are reloaded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		viper.Set("Module.Names", args)
+		generateAndRunFile(viper.GetString("Module.ProjectDir"), modulesUpgradeFileName, modulesUpgradeTemplate)
+	},
+}
+
+var modulesUninstallCmd = &cobra.Command{
+	Use:   "uninstall module...",
+	Short: "Uninstall modules from the database",
+	Long: `Uninstall the given modules from the database.
+
+The pool is generated again without the modules and the database schema is synchronized
+with the remaining models, which drops the tables of the models of the modules and the
+columns they added to other models. The data stored in these tables and columns is lost.
+
+The modules must then be removed from the Modules configuration of the project.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		modules, err := modulesWithout(viper.GetStringSlice("Modules"), args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		viper.Set("Modules", modules)
+		viper.Set("Module.Names", args)
+		runGenerate()
+		generateAndRunFile(viper.GetString("Module.ProjectDir"), modulesUninstallFileName, modulesUninstallTemplate)
+	},
+}
+
+// modulesWithout returns the given import paths of modules without the
+// paths of the modules with the given names. It returns an error if one
+// of the names is not the name of the package of one of the paths.
+func modulesWithout(importPaths, names []string) ([]string, error) {
+	byName, err := modulesByName(importPaths)
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool, len(names))
+	for _, name := range names {
+		importPath, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("module %s is not in the modules of the project", name)
+		}
+		removed[importPath] = true
+	}
+	var res []string
+	for _, importPath := range importPaths {
+		if !removed[importPath] {
+			res = append(res, importPath)
+		}
+	}
+	return res, nil
+}
+
+// modulesByName returns the given import paths of modules
+// by module name, that is by the name of their package.
+func modulesByName(importPaths []string) (map[string]string, error) {
+	res := make(map[string]string, len(importPaths))
+	for _, importPath := range importPaths {
+		pack, err := build.Import(importPath, ".", 0)
+		if err != nil {
+			return nil, err
+		}
+		res[pack.Name] = importPath
+	}
+	return res, nil
+}
+
+// bootstrapModules initializes the registry and the database connection for the
+// modules commands and synchronizes the database schema with the models.
+func bootstrapModules(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	models.SyncDatabase()
+	server.PostInitModules()
+}
+
+// ModulesList prints the modules of the project and the modules installed in
+// the database with their versions and states. It is meant to be called from
+// a project start file which imports all the project's module.
+func ModulesList(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tVERSION\tINSTALLED\tSTATE")
+	for _, status := range server.ModulesStatus() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status.Name, status.Version, status.InstalledVersion, status.State)
+	}
+	tw.Flush()
+}
+
+// ModulesInstall installs the modules given in the configuration in the database.
+// It is meant to be called from a project start file which imports all the project's module.
+func ModulesInstall(config map[string]interface{}) {
+	bootstrapModules(config)
+	server.InstallModules(viper.GetBool("Demo"), viper.GetStringSlice("Module.Names")...)
+	log.Info("Modules installed successfully")
+}
+
+// ModulesUpgrade upgrades the modules given in the configuration in the database.
+// It is meant to be called from a project start file which imports all the project's module.
+func ModulesUpgrade(config map[string]interface{}) {
+	bootstrapModules(config)
+	server.UpgradeModules(viper.GetStringSlice("Module.Names")...)
+	log.Info("Modules upgraded successfully")
+}
+
+// ModulesUninstall uninstalls the modules given in the configuration from the database.
+// It is meant to be called from a project start file which imports all the project's
+// module, except the modules to uninstall.
+func ModulesUninstall(config map[string]interface{}) {
+	bootstrapModules(config)
+	server.UninstallModules(viper.GetStringSlice("Module.Names")...)
+	log.Info("Modules uninstalled successfully")
+}
+
+func init() {
+	modulesCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Module.ProjectDir", modulesCmd.PersistentFlags().Lookup("project-dir"))
+	modulesCmd.AddCommand(modulesListCmd)
+	modulesCmd.AddCommand(modulesInstallCmd)
+	modulesCmd.AddCommand(modulesUpgradeCmd)
+	modulesCmd.AddCommand(modulesUninstallCmd)
+	DoxaCmd.AddCommand(modulesCmd)
+}
+
+var modulesListTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.ModulesList({{ .Config }})
+}
+`))
+
+var modulesInstallTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.ModulesInstall({{ .Config }})
+}
+`))
+
+var modulesUpgradeTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.ModulesUpgrade({{ .Config }})
+}
+`))
+
+var modulesUninstallTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.ModulesUninstall({{ .Config }})
+}
+`))
@@ -0,0 +1,63 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"os"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/controllers"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const routesFileName string = "routes.go"
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Print the effective handler chain of each route",
+	Long: `Print the routes declared in the controllers registry by the project's modules,
+with the effective chain of each route: the middlewares of its groups, the extensions
+of its controller and its base implementation, in execution order and with their sequence.
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		generateAndRunFile(viper.GetString("Routes.ProjectDir"), routesFileName, routesTemplate)
+	},
+}
+
+// Routes prints the effective handler chains of the routes of the
+// controllers registry. It is meant to be called from a project start
+// file which imports all the project's module.
+func Routes(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	controllers.Registry.Dump(os.Stdout)
+}
+
+func init() {
+	routesCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Routes.ProjectDir", routesCmd.PersistentFlags().Lookup("project-dir"))
+	DoxaCmd.AddCommand(routesCmd)
+}
+
+var routesTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.Routes({{ .Config }})
+}
+`))
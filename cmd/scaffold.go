@@ -0,0 +1,275 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/tools/generate"
+	"github.com/labneco/doxa/doxa/tools/po"
+	"github.com/spf13/cobra"
+)
+
+var generateModuleCmd = &cobra.Command{
+	Use:   "module name",
+	Short: "Create the skeleton of a new module",
+	Long: `Create the skeleton of a new module called 'name' in a new directory of the same name,
+inside the current directory or the directory set with --dir.
+
+The skeleton follows the layout expected by the server:
+ - doxa.go registers the module with its PreInit and PostInit functions,
+ - models.go declares a first model, named after the module,
+ - controllers.go adds a controller group under /<name>,
+ - resources/<name>.xml defines the action, menus and views of the model,
+ - data/<Model>.csv holds the data records of the model,
+ - i18n/<name>.pot is the translation template of the module,
+ - <name>_test.go runs the tests of the module in a test database.
+
+The module name must be a valid Go package name made of lower case letters, digits and underscores.
+Run 'doxa generate' after adding the module to the project to generate the code of its model.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		files, err := scaffoldModule(dir, args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Module %s created:\n", args[0])
+		for _, file := range files {
+			fmt.Println(" -", file)
+		}
+	},
+}
+
+// moduleNameRegex matches valid module names
+var moduleNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// scaffoldData is the data passed to module skeleton templates
+type scaffoldData struct {
+	Name      string
+	ModelName string
+}
+
+// A scaffoldFile is a file of the module skeleton. Its path and its
+// content are templates executed with the scaffoldData of the module.
+type scaffoldFile struct {
+	path     string
+	template *template.Template
+}
+
+// scaffoldFiles are the files of the module skeleton
+var scaffoldFiles = []scaffoldFile{
+	{path: "doxa.go", template: moduleDoxaTemplate},
+	{path: "models.go", template: moduleModelsTemplate},
+	{path: "controllers.go", template: moduleControllersTemplate},
+	{path: "{{ .Name }}_test.go", template: moduleTestTemplate},
+	{path: "resources/{{ .Name }}.xml", template: moduleResourcesTemplate},
+	{path: "data/{{ .ModelName }}.csv", template: moduleDataTemplate},
+}
+
+// scaffoldModule creates the skeleton of the module with the given name in
+// a new directory of parentDir and returns the paths of the created files.
+// It returns an error if the name is invalid or if the directory exists.
+func scaffoldModule(parentDir, name string) ([]string, error) {
+	if !moduleNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid module name '%s': it must be made of lower case letters, digits and underscores and start with a letter", name)
+	}
+	moduleDir := filepath.Join(parentDir, name)
+	if _, err := os.Stat(moduleDir); err == nil {
+		return nil, fmt.Errorf("directory %s already exists", moduleDir)
+	}
+	data := scaffoldData{Name: name, ModelName: snakeToCamelCase(name)}
+	var files []string
+	for _, sf := range scaffoldFiles {
+		var path bytes.Buffer
+		if err := template.Must(template.New("").Parse(sf.path)).Execute(&path, data); err != nil {
+			return files, err
+		}
+		fileName := filepath.Join(moduleDir, filepath.FromSlash(path.String()))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return files, err
+		}
+		if filepath.Ext(fileName) == ".go" {
+			generate.CreateFileFromTemplate(fileName, sf.template, data)
+		} else {
+			var content bytes.Buffer
+			if err := sf.template.Execute(&content, data); err != nil {
+				return files, err
+			}
+			if err := ioutil.WriteFile(fileName, content.Bytes(), 0644); err != nil {
+				return files, err
+			}
+		}
+		files = append(files, fileName)
+	}
+	i18nDir := filepath.Join(moduleDir, "i18n")
+	if err := os.MkdirAll(i18nDir, 0755); err != nil {
+		return files, err
+	}
+	potFile := filepath.Join(i18nDir, fmt.Sprintf("%s.pot", name))
+	pot := po.File{
+		MimeHeader: po.Header{
+			ProjectIdVersion:        name,
+			ContentType:             "text/plain; charset=utf-8",
+			ContentTransferEncoding: "8bit",
+			MimeVersion:             "1.0",
+		},
+	}
+	if err := pot.Save(potFile); err != nil {
+		return files, err
+	}
+	return append(files, potFile), nil
+}
+
+// snakeToCamelCase returns the given snake_case string in CamelCase
+func snakeToCamelCase(in string) string {
+	var res strings.Builder
+	for _, part := range strings.Split(in, "_") {
+		if part == "" {
+			continue
+		}
+		res.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return res.String()
+}
+
+func init() {
+	generateModuleCmd.Flags().String("dir", ".", "Directory in which the module directory is created")
+	generateCmd.AddCommand(generateModuleCmd)
+}
+
+var moduleDoxaTemplate = template.Must(template.New("").Parse(`
+package {{ .Name }}
+
+import (
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// MODULE_NAME is the name of the {{ .Name }} module
+const MODULE_NAME string = "{{ .Name }}"
+
+func init() {
+	server.RegisterModule(&server.Module{
+		Name: MODULE_NAME,
+		// Version is recorded in the database when the module is installed
+		// or upgraded with 'doxa modules'.
+		Version: "0.1",
+		// PreInit is run after all models are declared and the configuration
+		// is loaded, but before the models are bootstrapped.
+		PreInit: func() {},
+		// PostInit is run after the models, views and controllers are bootstrapped.
+		PostInit: func() {},
+	})
+}
+`))
+
+var moduleModelsTemplate = template.Must(template.New("").Parse(`
+package {{ .Name }}
+
+import (
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/pool/h"
+)
+
+func init() {
+	h.{{ .ModelName }}().DeclareModel()
+	h.{{ .ModelName }}().AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{Required: true},
+	})
+}
+`))
+
+var moduleControllersTemplate = template.Must(template.New("").Parse(`
+package {{ .Name }}
+
+import (
+	"net/http"
+
+	"github.com/labneco/doxa/doxa/controllers"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+func init() {
+	group := controllers.Registry.AddGroup("/{{ .Name }}")
+	group.SetAuth(server.AuthUser)
+	group.AddController(http.MethodGet, "/status", Status)
+}
+
+// Status returns the name of the module. It is served under /{{ .Name }}/status.
+func Status(c *server.Context) {
+	c.JSON(http.StatusOK, map[string]interface{}{"module": MODULE_NAME})
+}
+`))
+
+var moduleTestTemplate = template.Must(template.New("").Parse(`
+package {{ .Name }}
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tests"
+	"github.com/labneco/doxa/pool/h"
+	_ "github.com/lib/pq"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMain(m *testing.M) {
+	tests.RunTests(m, MODULE_NAME)
+}
+
+func Test{{ .ModelName }}(t *testing.T) {
+	Convey("Testing {{ .ModelName }} records", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			rec := h.{{ .ModelName }}().Create(env, &h.{{ .ModelName }}Data{
+				Name: "Test",
+			})
+			So(rec.Len(), ShouldEqual, 1)
+			So(rec.Name(), ShouldEqual, "Test")
+		}), ShouldBeNil)
+	})
+}
+`))
+
+var moduleResourcesTemplate = template.Must(template.New("").Parse(`<?xml version="1.0" encoding="utf-8"?>
+<doxa>
+    <data>
+        <view id="{{ .Name }}_tree" model="{{ .ModelName }}">
+            <tree>
+                <field name="Name"/>
+            </tree>
+        </view>
+
+        <view id="{{ .Name }}_form" model="{{ .ModelName }}">
+            <form>
+                <sheet>
+                    <group>
+                        <field name="Name"/>
+                    </group>
+                </sheet>
+            </form>
+        </view>
+
+        <action id="{{ .Name }}_action" name="{{ .ModelName }}" model="{{ .ModelName }}"
+                view_mode="tree,form" type="ir.actions.act_window"/>
+
+        <menuitem id="{{ .Name }}_main_menu" name="{{ .ModelName }}"/>
+
+        <menuitem id="{{ .Name }}_menu" name="{{ .ModelName }}" parent="{{ .Name }}_main_menu"
+                  action="{{ .Name }}_action"/>
+    </data>
+</doxa>
+`))
+
+var moduleDataTemplate = template.Must(template.New("").Parse(`id,Name
+`))
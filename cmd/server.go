@@ -15,22 +15,35 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/boards"
 	"github.com/labneco/doxa/doxa/controllers"
 	"github.com/labneco/doxa/doxa/i18n"
+	// Declare the mail models and register the mail delivery job
+	_ "github.com/labneco/doxa/doxa/mail"
 	"github.com/labneco/doxa/doxa/menus"
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/reports"
 	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/cache"
+	"github.com/labneco/doxa/doxa/tools/filestore"
 	"github.com/labneco/doxa/doxa/tools/generate"
 	"github.com/labneco/doxa/doxa/tools/logging"
+	"github.com/labneco/doxa/doxa/tools/sentry"
+	"github.com/labneco/doxa/doxa/tools/tracing"
 	"github.com/labneco/doxa/doxa/views"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -42,19 +55,47 @@ var serverCmd = &cobra.Command{
 	Use:   "server [projectDir]",
 	Short: "Start the Doxa server",
 	Long: `Start the Doxa server of the project in 'projectDir'.
-If projectDir is omitted, defaults to the current directory.`,
+If projectDir is omitted, defaults to the current directory.
+
+With --dev, the server is rebuilt and restarted when the Go sources of the modules
+or of the pool change, and restarted when the resources of the modules change. All
+SQL queries are logged to stdout.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectDir := "."
 		if len(args) > 0 {
 			projectDir = args[0]
 		}
+		if viper.GetBool("Server.Dev") {
+			runDevServer(projectDir)
+			return
+		}
 		generateAndRunFile(projectDir, startFileName, startFileTemplate)
 	},
 }
 
 // generateAndRunFile creates the startup file of the project and runs it.
+// It exits with the status of the project process if it fails.
 func generateAndRunFile(projectDir, fileName string, tmpl *template.Template) {
 	fmt.Println("Please wait, Doxa is starting ...")
+	startFileName := createStartFile(projectDir, fileName, tmpl)
+	cmd := exec.Command("go", "run", startFileName)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// Exit with the status of the project process so that
+		// failures can be detected by scripts
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// createStartFile creates the startup file of the project with the given name from
+// tmpl, with the modules and the configuration of the command. It returns its path.
+func createStartFile(projectDir, fileName string, tmpl *template.Template) string {
 	conf := viper.AllSettings()
 	delete(conf, "modules")
 
@@ -69,10 +110,7 @@ func generateAndRunFile(projectDir, fileName string, tmpl *template.Template) {
 	}
 	startFileName := filepath.Join(projectDir, fileName)
 	generate.CreateFileFromTemplate(startFileName, tmpl, tmplData)
-	cmd := exec.Command("go", "run", startFileName)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
+	return startFileName
 }
 
 // StartServer starts the Doxa server. It is meant to be called from
@@ -80,33 +118,137 @@ func generateAndRunFile(projectDir, fileName string, tmpl *template.Template) {
 func StartServer(config map[string]interface{}) {
 	setupConfig(config)
 	setupLogger()
+	if workers := viper.GetInt("Server.Workers"); workers > 0 && server.WorkerRole() == "" {
+		superviseWorkers(workers)
+		return
+	}
+	tracing.Initialize()
+	defer tracing.Shutdown()
+	sentry.Initialize()
+	defer sentry.Shutdown()
+	cache.Initialize()
+	defer cache.Shutdown()
 	setupDebug()
+	setupSecurity()
 	server.PreInit()
 	connectToDB()
 	models.BootStrap()
+	models.LoadFieldAccess()
+	// Old bus messages are deleted and jobs are run by cron workers if there are some,
+	// and not at all if they are run by dedicated 'doxa worker' processes
+	backgroundTasks := (server.WorkerRole() != server.WorkerHTTP || viper.GetInt("Server.CronWorkers") == 0) &&
+		viper.GetBool("Server.Jobs.Enabled")
+	models.BusGarbageCollection = backgroundTasks
+	models.WebhookAllowPrivateNetworks = viper.GetBool("Server.Webhooks.AllowPrivateNetworks")
+	models.StartBusRelay(viper.GetString("Server.Bus.Relay"), viper.GetDuration("Server.Bus.PollInterval"))
+	if backgroundTasks {
+		models.StartJobRunner(viper.GetDuration("Server.Jobs.PollInterval"), 1)
+	}
 	i18n.BootStrap()
 	server.LoadTranslations(i18n.Langs)
 	server.LoadInternalResources()
+	views.StrictValidation = !viper.IsSet("StrictViews") || viper.GetBool("StrictViews")
 	views.BootStrap()
+	reports.BootStrap()
+	boards.BootStrap()
 	actions.BootStrap()
 	controllers.BootStrap()
 	menus.BootStrap()
 	server.PostInit()
 	srv := server.GetServer()
+	server.RegisterShutdownHook(func(ctx context.Context) {
+		models.StopBusRelay()
+		models.StopJobRunner()
+	})
+	done := make(chan struct{})
+	if server.WorkerRole() != server.WorkerCron {
+		go func() {
+			runServer(srv)
+			close(done)
+		}()
+	}
+	waitForSignals(srv, done)
+	models.DBClose()
+}
+
+// superviseWorkers runs this process as the master of the given number of
+// HTTP workers and of Server.CronWorkers cron workers. It returns when all
+// workers have been stopped.
+func superviseWorkers(workers int) {
+	address := fmt.Sprintf("%s:%s", viper.GetString("Server.Interface"), viper.GetString("Server.Port"))
+	addresses := []string{address}
+	redirect := viper.GetString("Server.RedirectHTTP")
+	switch {
+	case viper.GetString("Server.Certificate") != "":
+		if redirect != "" {
+			addresses = append(addresses, redirect)
+		}
+	case len(viper.GetStringSlice("Server.Domain")) > 0:
+		if redirect == "" {
+			redirect = ":http"
+		}
+		addresses = []string{":https", redirect}
+	}
+	err := server.Supervise(addresses, workers, viper.GetInt("Server.CronWorkers"), viper.GetDuration("Server.ShutdownTimeout"))
+	if err != nil {
+		log.Panic("Unable to start workers", "error", err)
+	}
+}
+
+// runServer starts serving HTTP or HTTPS requests depending on the configuration.
+// It returns when the server is shut down.
+func runServer(srv *server.Server) {
 	address := fmt.Sprintf("%s:%s", viper.GetString("Server.Interface"), viper.GetString("Server.Port"))
 	cert := viper.GetString("Server.Certificate")
 	key := viper.GetString("Server.PrivateKey")
-	domain := viper.GetString("Server.Domain")
+	domains := viper.GetStringSlice("Server.Domain")
 	switch {
 	case cert != "":
+		if redirect := viper.GetString("Server.RedirectHTTP"); redirect != "" {
+			go srv.RunHTTPRedirect(redirect, address)
+		}
 		srv.RunTLS(address, cert, key)
-	case domain != "":
-		srv.RunAutoTLS(domain)
+	case len(domains) > 0:
+		srv.RunAutoTLS(domains...)
 	default:
 		srv.Run(address)
 	}
 }
 
+// waitForSignals blocks until the server stops by itself (done is closed)
+// or until a SIGINT or SIGTERM signal is received, in which case the
+// server is shut down gracefully within Server.ShutdownTimeout.
+//
+// On SIGHUP, a new server process which inherits the listening sockets
+// is started, and this process is stopped once the new one is serving.
+func waitForSignals(srv *server.Server, done <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if server.WorkerRole() != "" {
+					// Workers are restarted by their master
+					continue
+				}
+				if err := srv.Restart(); err != nil {
+					log.Error("Unable to restart server", "error", err)
+				}
+				continue
+			}
+			log.Info("Received signal, shutting down", "signal", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("Server.ShutdownTimeout"))
+			srv.Shutdown(ctx)
+			cancel()
+			return
+		}
+	}
+}
+
 // setupConfig takes the given config map and stores it into the viper configuration
 func setupConfig(config map[string]interface{}) {
 	for key, value := range config {
@@ -129,8 +271,34 @@ func setupDebug() {
 	pprof.Register(server.GetServer().Engine)
 }
 
-// connectToDB creates the connection to the database
+// setupSecurity sets the password and lockout policies from the configuration
+func setupSecurity() {
+	policy := security.DefaultPasswordPolicy
+	if viper.IsSet("Security.Password.MinLength") {
+		policy.MinLength = viper.GetInt("Security.Password.MinLength")
+	}
+	policy.RequireUpper = viper.GetBool("Security.Password.RequireUpper")
+	policy.RequireLower = viper.GetBool("Security.Password.RequireLower")
+	policy.RequireDigit = viper.GetBool("Security.Password.RequireDigit")
+	policy.RequireSymbol = viper.GetBool("Security.Password.RequireSymbol")
+	policy.MaxAge = viper.GetDuration("Security.Password.MaxAge")
+	policy.HistorySize = viper.GetInt("Security.Password.HistorySize")
+	security.DefaultPasswordPolicy = policy
+
+	lockout := security.DefaultLockoutPolicy
+	if viper.IsSet("Security.Lockout.MaxFailures") {
+		lockout.MaxFailures = viper.GetInt("Security.Lockout.MaxFailures")
+	}
+	if viper.IsSet("Security.Lockout.Cooldown") {
+		lockout.Cooldown = viper.GetDuration("Security.Lockout.Cooldown")
+	}
+	security.DefaultLockoutPolicy = lockout
+}
+
+// connectToDB creates the connection to the database and sets
+// the file store of its attachments in the data directory.
 func connectToDB() {
+	models.FileStore = filestore.New(fileStoreDir())
 	models.DBConnect(viper.GetString("DB.Driver"), models.ConnectionParams{
 		Host:     viper.GetString("DB.Host"),
 		Port:     viper.GetString("DB.Port"),
@@ -145,18 +313,85 @@ func connectToDB() {
 }
 
 func init() {
+	serverCmd.PersistentFlags().Bool("dev", false, "Development mode: rebuild and restart the server when the sources of the modules change")
+	viper.BindPFlag("Server.Dev", serverCmd.PersistentFlags().Lookup("dev"))
 	serverCmd.PersistentFlags().StringP("interface", "i", "", "Interface on which the server should listen. Empty string is all interfaces")
 	viper.BindPFlag("Server.Interface", serverCmd.PersistentFlags().Lookup("interface"))
 	serverCmd.PersistentFlags().StringP("port", "p", "8080", "Port on which the server should listen.")
 	viper.BindPFlag("Server.Port", serverCmd.PersistentFlags().Lookup("port"))
 	serverCmd.PersistentFlags().StringSliceP("languages", "l", []string{}, "Comma separated list of language codes to load (ex: fr,de,es).")
 	viper.BindPFlag("Server.Languages", serverCmd.PersistentFlags().Lookup("languages"))
-	serverCmd.PersistentFlags().StringP("domain", "d", "", "Domain name of the server. When set, interface and port are set to 0.0.0.0:443 and it will automatically get an HTTPS certificate from Letsencrypt")
+	serverCmd.PersistentFlags().StringSliceP("domain", "d", []string{}, "Comma separated list of domain names of the server. When set, interface and port are set to 0.0.0.0:443 and it will automatically get HTTPS certificates from Letsencrypt")
 	viper.BindPFlag("Server.Domain", serverCmd.PersistentFlags().Lookup("domain"))
 	serverCmd.PersistentFlags().StringP("certificate", "C", "", "Certificate file for HTTPS. If neither certificate nor domain is set, the server will run on plain HTTP. When certificate is set, private-key must also be set.")
 	viper.BindPFlag("Server.Certificate", serverCmd.PersistentFlags().Lookup("certificate"))
 	serverCmd.PersistentFlags().StringP("private-key", "K", "", "Private key file for HTTPS.")
 	viper.BindPFlag("Server.PrivateKey", serverCmd.PersistentFlags().Lookup("private-key"))
+	serverCmd.PersistentFlags().String("redirect-http", "", "Address on which plain HTTP requests are redirected to HTTPS (ex: :80). Defaults to :80 when domain is set, disabled otherwise.")
+	viper.BindPFlag("Server.RedirectHTTP", serverCmd.PersistentFlags().Lookup("redirect-http"))
+	serverCmd.PersistentFlags().String("acme-email", "", "Contact email registered with the ACME server for certificate expiry notices.")
+	viper.BindPFlag("Server.ACME.Email", serverCmd.PersistentFlags().Lookup("acme-email"))
+	serverCmd.PersistentFlags().String("acme-directory", "", "Directory URL of the ACME server. Defaults to the Letsencrypt production server.")
+	viper.BindPFlag("Server.ACME.DirectoryURL", serverCmd.PersistentFlags().Lookup("acme-directory"))
+	serverCmd.PersistentFlags().Duration("shutdown-timeout", 30*time.Second, "Maximum duration to wait for in-flight requests and background workers on shutdown.")
+	viper.BindPFlag("Server.ShutdownTimeout", serverCmd.PersistentFlags().Lookup("shutdown-timeout"))
+	serverCmd.PersistentFlags().Int64("max-request-size", 32<<20, "Maximum size in bytes of request bodies. 0 means no limit.")
+	viper.BindPFlag("Server.MaxRequestSize", serverCmd.PersistentFlags().Lookup("max-request-size"))
+	serverCmd.PersistentFlags().Duration("read-header-timeout", 10*time.Second, "Maximum duration for reading request headers.")
+	viper.BindPFlag("Server.ReadHeaderTimeout", serverCmd.PersistentFlags().Lookup("read-header-timeout"))
+	serverCmd.PersistentFlags().Duration("read-timeout", time.Minute, "Maximum duration for reading entire requests, including their body. 0 means no timeout.")
+	viper.BindPFlag("Server.ReadTimeout", serverCmd.PersistentFlags().Lookup("read-timeout"))
+	serverCmd.PersistentFlags().Duration("write-timeout", 2*time.Minute, "Maximum duration before timing out writes of responses. 0 means no timeout.")
+	viper.BindPFlag("Server.WriteTimeout", serverCmd.PersistentFlags().Lookup("write-timeout"))
+	serverCmd.PersistentFlags().Duration("idle-timeout", 2*time.Minute, "Maximum duration to wait for the next request on keep-alive connections.")
+	viper.BindPFlag("Server.IdleTimeout", serverCmd.PersistentFlags().Lookup("idle-timeout"))
+	serverCmd.PersistentFlags().Duration("handler-timeout", time.Minute, "Default deadline of request handlers. 0 means no deadline.")
+	viper.BindPFlag("Server.HandlerTimeout", serverCmd.PersistentFlags().Lookup("handler-timeout"))
+	serverCmd.PersistentFlags().Int("workers", 0, "Number of HTTP worker processes. 0 runs the server in a single process.")
+	viper.BindPFlag("Server.Workers", serverCmd.PersistentFlags().Lookup("workers"))
+	serverCmd.PersistentFlags().Int("cron-workers", 0, "Number of worker processes dedicated to background jobs when workers is set.")
+	viper.BindPFlag("Server.CronWorkers", serverCmd.PersistentFlags().Lookup("cron-workers"))
+	serverCmd.PersistentFlags().String("jwt-secret", "", "Secret key used to sign JSON Web Tokens. JWT authentication is disabled if empty.")
+	viper.BindPFlag("Server.JWT.Secret", serverCmd.PersistentFlags().Lookup("jwt-secret"))
+	serverCmd.PersistentFlags().Duration("jwt-expiry", 24*time.Hour, "Validity duration of issued JSON Web Tokens.")
+	viper.BindPFlag("Server.JWT.Expiry", serverCmd.PersistentFlags().Lookup("jwt-expiry"))
+	serverCmd.PersistentFlags().Int("password-min-length", 8, "Minimum length of user passwords.")
+	viper.BindPFlag("Security.Password.MinLength", serverCmd.PersistentFlags().Lookup("password-min-length"))
+	serverCmd.PersistentFlags().Duration("password-max-age", 0, "Duration after which users must change their password. 0 means passwords never expire.")
+	viper.BindPFlag("Security.Password.MaxAge", serverCmd.PersistentFlags().Lookup("password-max-age"))
+	serverCmd.PersistentFlags().Int("password-history", 0, "Number of previous passwords that users cannot reuse.")
+	viper.BindPFlag("Security.Password.HistorySize", serverCmd.PersistentFlags().Lookup("password-history"))
+	serverCmd.PersistentFlags().Int("lockout-max-failures", 5, "Number of failed login attempts after which the account and IP address are locked. 0 disables locking.")
+	viper.BindPFlag("Security.Lockout.MaxFailures", serverCmd.PersistentFlags().Lookup("lockout-max-failures"))
+	serverCmd.PersistentFlags().Duration("lockout-cooldown", 15*time.Minute, "Duration during which locked accounts cannot log in.")
+	viper.BindPFlag("Security.Lockout.Cooldown", serverCmd.PersistentFlags().Lookup("lockout-cooldown"))
+	serverCmd.PersistentFlags().String("bus-relay", models.BusRelayNotify, "How bus messages are relayed between server workers. Either 'notify' (PostgreSQL LISTEN/NOTIFY) or 'poll'.")
+	viper.BindPFlag("Server.Bus.Relay", serverCmd.PersistentFlags().Lookup("bus-relay"))
+	serverCmd.PersistentFlags().Duration("bus-poll-interval", time.Second, "Interval at which the database is polled for bus messages in 'poll' relay mode.")
+	viper.BindPFlag("Server.Bus.PollInterval", serverCmd.PersistentFlags().Lookup("bus-poll-interval"))
+	serverCmd.PersistentFlags().Duration("job-poll-interval", 10*time.Second, "Interval at which the job queue is polled for jobs to run.")
+	viper.BindPFlag("Server.Jobs.PollInterval", serverCmd.PersistentFlags().Lookup("job-poll-interval"))
+	serverCmd.PersistentFlags().Bool("jobs", true, "Run the background jobs in this server. Set to false when they are run by dedicated 'doxa worker' processes.")
+	viper.BindPFlag("Server.Jobs.Enabled", serverCmd.PersistentFlags().Lookup("jobs"))
+	serverCmd.PersistentFlags().Int64("webhook-uid", 0, "ID of the technical user under which incoming webhooks are processed. Incoming webhooks are disabled if 0.")
+	viper.BindPFlag("Server.Webhooks.UID", serverCmd.PersistentFlags().Lookup("webhook-uid"))
+	serverCmd.PersistentFlags().Bool("webhook-allow-private-networks", false, "Allow outgoing webhooks to post to private, loopback and link-local addresses.")
+	viper.BindPFlag("Server.Webhooks.AllowPrivateNetworks", serverCmd.PersistentFlags().Lookup("webhook-allow-private-networks"))
+	serverCmd.PersistentFlags().String("report-converter", "wkhtmltopdf", "Converter of HTML reports to PDF. Either 'wkhtmltopdf' or 'chromium'.")
+	viper.BindPFlag("Reports.Converter", serverCmd.PersistentFlags().Lookup("report-converter"))
+	serverCmd.PersistentFlags().String("report-converter-path", "", "Path of the executable of the report converter. Defaults to the converter name, looked up in the PATH.")
+	viper.BindPFlag("Reports.ConverterPath", serverCmd.PersistentFlags().Lookup("report-converter-path"))
+	serverCmd.PersistentFlags().Duration("report-timeout", time.Minute, "Maximum duration of the conversion of a report to PDF. 0 means no limit.")
+	viper.BindPFlag("Reports.Timeout", serverCmd.PersistentFlags().Lookup("report-timeout"))
+	serverCmd.PersistentFlags().String("tracing-exporter", "", "Name of the OpenTelemetry span exporter to use (e.g. 'stdout'). Tracing is disabled if empty.")
+	viper.BindPFlag("Tracing.Exporter", serverCmd.PersistentFlags().Lookup("tracing-exporter"))
+	serverCmd.PersistentFlags().Float64("tracing-sample-ratio", 1, "Ratio of requests to trace, between 0 and 1.")
+	viper.BindPFlag("Tracing.SampleRatio", serverCmd.PersistentFlags().Lookup("tracing-sample-ratio"))
+	serverCmd.PersistentFlags().String("sentry-dsn", "", "DSN of the Sentry-compatible project to which errors and panics are reported. Reporting is disabled if empty.")
+	viper.BindPFlag("Sentry.DSN", serverCmd.PersistentFlags().Lookup("sentry-dsn"))
+	serverCmd.PersistentFlags().String("sentry-environment", "production", "Environment of the reported errors")
+	viper.BindPFlag("Sentry.Environment", serverCmd.PersistentFlags().Lookup("sentry-environment"))
+	viper.SetDefault("Sentry.Release", "doxa@"+server.Version)
 	DoxaCmd.AddCommand(serverCmd)
 }
 
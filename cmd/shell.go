@@ -0,0 +1,392 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/exceptions"
+	"github.com/labneco/doxa/doxa/tools/generate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+const shellFileName string = "shell.go"
+
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start an interactive shell on the database",
+	Long: `Start an interactive Go shell on the database, for debugging and one-off data fixes.
+
+Go code is interpreted with yaegi: statements, loops, function literals and imports of the
+standard library are available. The following packages are imported:
+ - models, security, types and dates of Doxa,
+ - h and q, the typed pool packages of the project, if they have been generated,
+ - shell, which provides shell.Domain(src) to parse a domain given as a string.
+The env variable is an environment of the user given with --user (the administrator by
+default). Type 'exit' or Ctrl-D to quit.
+
+Each input is run in its own transaction, which is committed unless the input fails or
+panics. Record sets kept in variables are bound to the transaction in which they were
+loaded: call WithEnv(env) on them to use them in a later input.
+With --dry-run, all transactions are rolled back.
+
+	doxa> users := h.User().Search(env, q.User().Active().Equals(true))
+	doxa> users.Len()
+	doxa> for _, u := range users.WithEnv(env).Records() {
+	...     u.SetActive(false)
+	...   }
+
+The project is looked for in the current directory, or in the directory set with --project-dir.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		generateAndRunFile(viper.GetString("Shell.ProjectDir"), shellFileName, shellTemplate)
+	},
+}
+
+// Shell runs the interactive shell on the database given in the
+// configuration. It is meant to be called from a project start
+// file which imports all the project's module.
+//
+// symbols are the symbols of the pool packages of the project
+// made available to the interpreter of the shell.
+func Shell(config map[string]interface{}, symbols map[string]map[string]reflect.Value) {
+	setupConfig(config)
+	setupLogger()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	models.LoadFieldAccess()
+	server.PostInitModules()
+	uid := security.SuperUserID
+	if user := viper.GetString("Shell.User"); user != "" {
+		err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			uid = resolveUser(env, user)
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	execute := models.ExecuteInNewEnvironment
+	if viper.GetBool("Shell.DryRun") {
+		execute = models.SimulateInNewEnvironment
+	}
+	fmt.Printf("Doxa shell of user %d. Type 'exit' or Ctrl-D to quit.\n", uid)
+	err := runShell(os.Stdin, os.Stdout, symbols, func(fnct func(models.Environment)) error {
+		return execute(uid, fnct)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// shellEnv is the environment of the input being run by the
+// shell, exported to the interpreter as shell.Env.
+var shellEnv models.Environment
+
+// shellSymbols are the symbols of the Doxa packages
+// made available to the interpreter of the shell.
+var shellSymbols = interp.Exports{
+	"doxa/shell/shell": {
+		"Env":    reflect.ValueOf(&shellEnv).Elem(),
+		"Domain": reflect.ValueOf(parseDomainString),
+	},
+	"github.com/labneco/doxa/doxa/models/models": {
+		"Condition":        reflect.ValueOf((*models.Condition)(nil)),
+		"Environment":      reflect.ValueOf((*models.Environment)(nil)),
+		"FieldMap":         reflect.ValueOf((*models.FieldMap)(nil)),
+		"Model":            reflect.ValueOf((*models.Model)(nil)),
+		"ParseDomain":      reflect.ValueOf(models.ParseDomain),
+		"RecordCollection": reflect.ValueOf((*models.RecordCollection)(nil)),
+		"RecordSet":        reflect.ValueOf((*models.RecordSet)(nil)),
+		"Registry":         reflect.ValueOf(&models.Registry).Elem(),
+	},
+	"github.com/labneco/doxa/doxa/models/security/security": {
+		"All":         reflect.ValueOf(security.All),
+		"GroupAdmin":  reflect.ValueOf(&security.GroupAdmin).Elem(),
+		"Permission":  reflect.ValueOf((*security.Permission)(nil)),
+		"Read":        reflect.ValueOf(security.Read),
+		"Registry":    reflect.ValueOf(&security.Registry).Elem(),
+		"SuperUserID": reflect.ValueOf(security.SuperUserID),
+		"Unlink":      reflect.ValueOf(security.Unlink),
+		"Write":       reflect.ValueOf(security.Write),
+	},
+	"github.com/labneco/doxa/doxa/models/types/types": {
+		"Context":    reflect.ValueOf((*types.Context)(nil)),
+		"NewContext": reflect.ValueOf(types.NewContext),
+	},
+	"github.com/labneco/doxa/doxa/models/types/dates/dates": {
+		"Date":          reflect.ValueOf((*dates.Date)(nil)),
+		"DateTime":      reflect.ValueOf((*dates.DateTime)(nil)),
+		"Now":           reflect.ValueOf(dates.Now),
+		"ParseDate":     reflect.ValueOf(dates.ParseDate),
+		"ParseDateTime": reflect.ValueOf(dates.ParseDateTime),
+		"Today":         reflect.ValueOf(dates.Today),
+	},
+}
+
+// newShellInterpreter returns a new interpreter for the shell with the
+// standard library, the Doxa packages and the given symbols, and with
+// the packages and the env variable of the shell declared.
+func newShellInterpreter(symbols map[string]map[string]reflect.Value) (*interp.Interpreter, error) {
+	i := interp.New(interp.Options{})
+	for _, exports := range []interp.Exports{stdlib.Symbols, shellSymbols, symbols} {
+		if err := i.Use(exports); err != nil {
+			return nil, err
+		}
+	}
+	imports := []string{"doxa/shell"}
+	for key := range shellSymbols {
+		imports = append(imports, path.Dir(key))
+	}
+	for key := range symbols {
+		imports = append(imports, path.Dir(key))
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		if _, err := i.Eval(fmt.Sprintf("import %q", imp)); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := i.Eval("var env models.Environment"); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// shellInputComplete returns true if the given source has no unclosed
+// bracket, parenthesis, brace or string, so that it can be evaluated.
+func shellInputComplete(src string) bool {
+	var depth int
+	var quote rune
+	var escaped bool
+	for _, r := range src {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			switch {
+			case r == '\\' && quote != '`':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+		}
+	}
+	return depth <= 0 && (quote == 0 || quote == '"' || quote == '\'')
+}
+
+// runShell reads Go code from in and writes the result of its evaluation
+// to out until in is exhausted or an exit line is read. Inputs spanning
+// several lines are read until their brackets are closed. Each input is
+// evaluated in the environment given by execute to its function.
+func runShell(in io.Reader, out io.Writer, symbols map[string]map[string]reflect.Value, execute func(func(models.Environment)) error) error {
+	i, err := newShellInterpreter(symbols)
+	if err != nil {
+		return err
+	}
+	i.Stdout, i.Stderr = out, out
+	scanner := bufio.NewScanner(in)
+	prompt := "doxa> "
+	var src string
+	for {
+		fmt.Fprint(out, prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return nil
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if src == "" {
+			switch line {
+			case "":
+				continue
+			case "exit", "quit":
+				return nil
+			}
+		}
+		src += scanner.Text() + "\n"
+		if !shellInputComplete(src) {
+			prompt = "...   "
+			continue
+		}
+		input := src
+		src, prompt = "", "doxa> "
+		var res reflect.Value
+		err := execute(func(env models.Environment) {
+			shellEnv = env
+			if _, err := i.Eval("env = shell.Env"); err != nil {
+				panic(err)
+			}
+			val, err := i.Eval(input)
+			if err != nil {
+				// Panic to roll back the transaction
+				panic(err)
+			}
+			if val.IsValid() && val.CanInterface() {
+				if rs, ok := val.Interface().(models.RecordSet); ok {
+					// Load the records before the transaction ends
+					rs.Collection().Fetch()
+				}
+			}
+			res = val
+		})
+		if err != nil {
+			if userErr, ok := err.(exceptions.UserError); ok {
+				err = fmt.Errorf("%s", userErr.Message)
+			}
+			fmt.Fprintln(out, "Error:", err)
+			continue
+		}
+		if res.IsValid() && res.CanInterface() {
+			fmt.Fprintf(out, "%v\n", res.Interface())
+		}
+	}
+}
+
+// shellPoolSymbols are the import paths of the pool packages of the project
+// and the Go source of their symbols, inserted in the start file of the shell.
+type shellPoolSymbols struct {
+	Imports []string
+	Exports string
+}
+
+// poolSymbols returns the exported declarations of the pool packages of the
+// project, so that the typed pool API can be called in the shell. Packages
+// that have not been generated are skipped.
+func poolSymbols() (shellPoolSymbols, error) {
+	var res shellPoolSymbols
+	var exports bytes.Buffer
+	for _, pkgName := range []string{generate.PoolModelPackage, generate.PoolQueryPackage} {
+		dir := filepath.Join(generate.DoxaDir, PoolDirRel, pkgName)
+		pkgs, err := parser.ParseDir(token.NewFileSet(), dir, func(fi os.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, 0)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		pkg, ok := pkgs[pkgName]
+		if !ok {
+			continue
+		}
+		importPath := path.Join(generate.PoolPath, pkgName)
+		res.Imports = append(res.Imports, importPath)
+		fmt.Fprintf(&exports, "\t%q: {\n", importPath+"/"+pkgName)
+		decls := exportedDecls(pkg)
+		names := make([]string, 0, len(decls))
+		for name := range decls {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&exports, "\t\t%q: %s,\n", name, fmt.Sprintf(decls[name], pkgName+"."+name))
+		}
+		exports.WriteString("\t},\n")
+	}
+	res.Exports = exports.String()
+	return res, nil
+}
+
+// exportedDecls returns the exported top level declarations of the given
+// package, with the format of the expression returning their reflect.Value.
+func exportedDecls(pkg *ast.Package) map[string]string {
+	res := make(map[string]string)
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil && d.Name.IsExported() {
+					res[d.Name.Name] = "reflect.ValueOf(%s)"
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.IsExported() {
+							res[s.Name.Name] = "reflect.ValueOf((*%s)(nil))"
+						}
+					case *ast.ValueSpec:
+						for _, name := range s.Names {
+							if !name.IsExported() {
+								continue
+							}
+							res[name.Name] = "reflect.ValueOf(%s)"
+							if d.Tok == token.VAR {
+								res[name.Name] = "reflect.ValueOf(&%s).Elem()"
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+func init() {
+	shellCmd.PersistentFlags().String("project-dir", ".", "Directory of the project")
+	viper.BindPFlag("Shell.ProjectDir", shellCmd.PersistentFlags().Lookup("project-dir"))
+	shellCmd.PersistentFlags().StringP("user", "u", "", "Id or login of the user of the environment. Defaults to the administrator.")
+	viper.BindPFlag("Shell.User", shellCmd.PersistentFlags().Lookup("user"))
+	shellCmd.PersistentFlags().Bool("dry-run", false, "Roll back all transactions")
+	viper.BindPFlag("Shell.DryRun", shellCmd.PersistentFlags().Lookup("dry-run"))
+	DoxaCmd.AddCommand(shellCmd)
+}
+
+var shellTemplate = template.Must(template.New("").Funcs(template.FuncMap{"poolSymbols": poolSymbols}).Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"reflect"
+
+	"github.com/labneco/doxa/cmd"
+{{- $pool := poolSymbols }}
+{{ range $pool.Imports }}	"{{ . }}"
+{{ end }}
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+// poolSymbols are the symbols of the pool packages
+// of the project made available to the shell.
+var poolSymbols = map[string]map[string]reflect.Value{
+{{ $pool.Exports }}}
+
+func main() {
+	cmd.Shell({{ .Config }}, poolSymbols)
+}
+`))
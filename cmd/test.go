@@ -0,0 +1,248 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [module...]",
+	Short: "Run the tests of modules",
+	Long: `Run the Go tests of the given modules of the project, or of all the modules of the
+project if no module is given.
+
+For each module, the pool is generated for testing the module, then its packages are
+tested with 'go test'. Test packages are expected to set up their database with
+tests.RunTests, which is given the database parameters of the configuration through
+the DOXA_DB_* environment variables. The test databases are named
+<db-prefix>_<module>_tests.
+
+With --db-template, the test databases are copied from template databases named
+<db-prefix>_<module>_template, which are loaded with the data and demo records of
+the modules once and kept between runs. Templates are rebuilt automatically when
+the models or data files change, or on demand with --rebuild-template.
+
+With --parallel, several packages of a module are tested at the same time, each
+in its own database named <db-prefix>_<module>_tests_<pid> and cloned from the
+template database of the module.
+
+With --update-golden, the golden files compared by the tests with the golden package
+are written with the actual outputs instead. They must be reviewed before being
+committed.
+
+The coverage profiles of all the modules are aggregated in the file set with
+--coverprofile. The command exits with an error status if the tests of a module fail.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		importPaths := viper.GetStringSlice("Modules")
+		if len(args) > 0 {
+			byName, err := modulesByName(importPaths)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			importPaths = nil
+			for _, name := range args {
+				importPath, ok := byName[name]
+				if !ok {
+					fmt.Printf("Error: module %s is not in the modules of the project\n", name)
+					os.Exit(1)
+				}
+				importPaths = append(importPaths, importPath)
+			}
+		}
+		if !runModulesTests(importPaths) {
+			os.Exit(1)
+		}
+	},
+}
+
+// runModulesTests runs the tests of the modules with the given import paths
+// and aggregates their coverage profiles. It returns false if the tests of a
+// module failed.
+func runModulesTests(importPaths []string) bool {
+	coverFile := viper.GetString("Test.CoverProfile")
+	coverage, err := os.Create(coverFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	fmt.Fprintln(coverage, "mode: atomic")
+	tmpDir, err := ioutil.TempDir("", "doxa-test")
+	if err != nil {
+		coverage.Close()
+		fmt.Println("Error:", err)
+		return false
+	}
+	defer os.RemoveAll(tmpDir)
+
+	results := make(map[string]bool, len(importPaths))
+	for i, importPath := range importPaths {
+		pack, err := build.Import(importPath, ".", build.FindOnly)
+		if err != nil {
+			fmt.Printf("Unable to find module %s: %s\n", importPath, err)
+			continue
+		}
+		testedModule = pack.Dir
+		runGenerate()
+		profile := filepath.Join(tmpDir, fmt.Sprintf("profile%d.out", i))
+		results[importPath] = runModuleTests(importPath, profile)
+		if err = appendCoverProfile(coverage, profile); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Unable to aggregate coverage of module %s: %s\n", importPath, err)
+		}
+	}
+	if err = coverage.Close(); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	success := len(results) == len(importPaths)
+	fmt.Println("\nTest results:")
+	for _, importPath := range importPaths {
+		status := "FAIL"
+		if results[importPath] {
+			status = "ok"
+		}
+		fmt.Printf("%-4s %s\n", status, importPath)
+		success = success && results[importPath]
+	}
+	if total, err := totalCoverage(coverFile); err == nil {
+		fmt.Printf("Total coverage: %s (profile in %s)\n", total, coverFile)
+	}
+	return success
+}
+
+// runModuleTests runs the tests of all the packages of the module with the given
+// import path, writing their coverage profile to profile. It returns true if the
+// tests passed.
+func runModuleTests(importPath, profile string) bool {
+	parallel := viper.GetInt("Test.Parallel")
+	if parallel < 1 {
+		parallel = 1
+	}
+	args := []string{"test", "-p", strconv.Itoa(parallel), "-covermode=atomic", "-coverprofile=" + profile}
+	if viper.GetBool("Test.Race") {
+		args = append(args, "-race")
+	}
+	if viper.GetBool("Test.Verbose") {
+		args = append(args, "-v")
+	}
+	if run := viper.GetString("Test.Run"); run != "" {
+		args = append(args, "-run", run)
+	}
+	args = append(args, importPath+"/...")
+	cmd := exec.Command("go", args...)
+	cmd.Env = testEnv()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run() == nil
+}
+
+// testEnv returns the environment of the tests, with the database
+// parameters of the configuration read by tests.RunTests.
+func testEnv() []string {
+	env := os.Environ()
+	params := []struct {
+		variable string
+		key      string
+	}{
+		{"DOXA_DB_DRIVER", "DB.Driver"},
+		{"DOXA_DB_HOST", "DB.Host"},
+		{"DOXA_DB_PORT", "DB.Port"},
+		{"DOXA_DB_USER", "DB.User"},
+		{"DOXA_DB_PASSWORD", "DB.Password"},
+		{"DOXA_DB_PREFIX", "Test.DBPrefix"},
+	}
+	for _, param := range params {
+		if value := viper.GetString(param.key); value != "" {
+			env = append(env, fmt.Sprintf("%s=%s", param.variable, value))
+		}
+	}
+	switch {
+	case viper.GetBool("Test.RebuildTemplate"):
+		// The run ID lets the packages of a module tested in
+		// parallel rebuild their template database only once.
+		env = append(env, fmt.Sprintf("DOXA_DB_TEMPLATE=rebuild-%d", time.Now().UnixNano()))
+	case viper.GetBool("Test.DBTemplate"):
+		env = append(env, "DOXA_DB_TEMPLATE=1")
+	}
+	if viper.GetInt("Test.Parallel") > 1 {
+		env = append(env, "DOXA_TEST_PARALLEL=1")
+	}
+	if viper.GetBool("Test.UpdateGolden") {
+		env = append(env, "DOXA_UPDATE_GOLDEN=1")
+	}
+	if viper.GetBool("Debug") {
+		env = append(env, "DOXA_DEBUG=1")
+	}
+	return env
+}
+
+// appendCoverProfile appends the blocks of the coverage profile
+// in the given file to w, without its mode line.
+func appendCoverProfile(w io.Writer, fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "mode:") {
+			continue
+		}
+		if _, err = fmt.Fprintln(w, scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// totalCoverage returns the total statement coverage of the given coverage profile
+func totalCoverage(fileName string) (string, error) {
+	out, err := exec.Command("go", "tool", "cover", "-func="+fileName).Output()
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no coverage data in %s", fileName)
+	}
+	return fields[len(fields)-1], nil
+}
+
+func init() {
+	testCmd.Flags().String("coverprofile", "coverage.txt", "File to which the aggregated coverage profile is written")
+	viper.BindPFlag("Test.CoverProfile", testCmd.Flags().Lookup("coverprofile"))
+	testCmd.Flags().String("db-prefix", "doxa", "Prefix of the names of the test databases")
+	viper.BindPFlag("Test.DBPrefix", testCmd.Flags().Lookup("db-prefix"))
+	testCmd.Flags().Bool("db-template", false, "Clone the test databases from template databases kept between runs")
+	viper.BindPFlag("Test.DBTemplate", testCmd.Flags().Lookup("db-template"))
+	testCmd.Flags().Bool("rebuild-template", false, "Rebuild the template databases of the tests (implies --db-template)")
+	viper.BindPFlag("Test.RebuildTemplate", testCmd.Flags().Lookup("rebuild-template"))
+	testCmd.Flags().IntP("parallel", "p", 1, "Number of packages tested in parallel, each in a database cloned from a template database (implies --db-template)")
+	viper.BindPFlag("Test.Parallel", testCmd.Flags().Lookup("parallel"))
+	testCmd.Flags().Bool("race", false, "Enable the data race detector")
+	viper.BindPFlag("Test.Race", testCmd.Flags().Lookup("race"))
+	testCmd.Flags().String("run", "", "Run only the tests matching the given regular expression")
+	viper.BindPFlag("Test.Run", testCmd.Flags().Lookup("run"))
+	testCmd.Flags().Bool("update-golden", false, "Write the golden files of the tests with the actual outputs instead of comparing them")
+	viper.BindPFlag("Test.UpdateGolden", testCmd.Flags().Lookup("update-golden"))
+	testCmd.Flags().BoolP("verbose", "v", false, "Print the output of all tests")
+	viper.BindPFlag("Test.Verbose", testCmd.Flags().Lookup("verbose"))
+	DoxaCmd.AddCommand(testCmd)
+}
@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"os"
 	"text/template"
 
 	"github.com/labneco/doxa/doxa/models"
@@ -15,9 +16,18 @@ import (
 const updateDBFileName string = "updatedb.go"
 
 var updateDBCmd = &cobra.Command{
-	Use:   "updatedb",
+	Use:   "updatedb [projectDir]",
 	Short: "Update the database schema",
-	Long:  `Synchronize the database schema with the models definitions.`,
+	Long: `Synchronize the database schema with the models definitions and load the data
+records of the modules, without starting the HTTP server, so that it can run as a
+deployment step. If projectDir is omitted, defaults to the current directory.
+
+The schema of all the models is synchronized. With --data-modules, only the data
+records (and demo records in demo mode) of the given modules are loaded.
+
+The command exits with an error status if the update fails. With --data-collect-errors,
+the lines of the data files that cannot be loaded are skipped and printed at the end,
+and the command exits with an error status if there are some.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		projectDir := "."
 		if len(args) > 0 {
@@ -37,15 +47,24 @@ func UpdateDB(config map[string]interface{}) {
 	connectToDB()
 	models.BootStrap()
 	models.SyncDatabase()
-	server.LoadDataRecords()
+	modules := viper.GetStringSlice("UpdateDB.DataModules")
+	reports := server.LoadDataRecords(modules...)
 	if viper.GetBool("Demo") {
 		log.Info("Demo mode detected: loading demo data")
-		server.LoadDemoRecords()
+		reports = append(reports, server.LoadDemoRecords(modules...)...)
+	}
+	if len(reports) > 0 {
+		for _, report := range reports {
+			printImportReport(report)
+		}
+		os.Exit(1)
 	}
 	log.Info("Database updated successfully")
 }
 
 func init() {
+	updateDBCmd.PersistentFlags().StringSlice("data-modules", []string{}, "Comma separated list of the names of the modules whose data records are loaded. Defaults to all modules.")
+	viper.BindPFlag("UpdateDB.DataModules", updateDBCmd.PersistentFlags().Lookup("data-modules"))
 	DoxaCmd.AddCommand(updateDBCmd)
 }
 
@@ -15,20 +15,91 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"text/template"
 
+	"github.com/labneco/doxa/doxa/server"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
+const versionFileName string = "version.go"
+
 var versionCmd = &cobra.Command{
-	Use:   "version",
+	Use:   "version [projectDir]",
 	Short: "Print the version Doxa",
-	Long:  `Print the version of the Doxa framework`,
+	Long: `Print the version and the git commit of the Doxa framework and the Go version.
+
+If projectDir is given, the project in 'projectDir' is built and the list of the modules
+compiled in it and their versions are printed too. The same information is returned by the
+'/version_info' JSON-RPC endpoint of a running server.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Doxa version 0.1")
+		if len(args) > 0 {
+			generateAndRunFile(args[0], versionFileName, versionTemplate)
+			return
+		}
+		printBuildInfo(server.GetBuildInfo())
 	},
 }
 
+// PrintVersion prints the build information of the project, including its modules.
+// It is meant to be called from a project start file which imports all the project's module.
+func PrintVersion(config map[string]interface{}) {
+	setupConfig(config)
+	printBuildInfo(server.GetBuildInfo())
+}
+
+// printBuildInfo prints the given build information, as JSON if
+// the Version.JSON setting is set and as text otherwise.
+func printBuildInfo(info server.BuildInfo) {
+	if viper.GetBool("Version.JSON") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return
+	}
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	fmt.Printf("Doxa version %s\n", info.Version)
+	fmt.Printf("Commit:     %s\n", commit)
+	fmt.Printf("Go version: %s\n", info.GoVersion)
+	if len(info.Modules) == 0 {
+		return
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tVERSION")
+	for _, mod := range info.Modules {
+		fmt.Fprintf(tw, "%s\t%s\n", mod.Name, mod.Version)
+	}
+	tw.Flush()
+}
+
 func init() {
+	versionCmd.Flags().Bool("json", false, "Print the version information as JSON")
+	viper.BindPFlag("Version.JSON", versionCmd.Flags().Lookup("json"))
 	DoxaCmd.AddCommand(versionCmd)
 }
+
+var versionTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.PrintVersion({{ .Config }})
+}
+`))
@@ -0,0 +1,132 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/boards"
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/menus"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/reports"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/cache"
+	"github.com/labneco/doxa/doxa/tools/tracing"
+	"github.com/labneco/doxa/doxa/views"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const workerFileName string = "worker.go"
+
+var workerCmd = &cobra.Command{
+	Use:   "worker [projectDir]",
+	Short: "Start a worker running the background jobs",
+	Long: `Start a worker of the project in 'projectDir' which runs the background jobs of the
+job queue and the periodic tasks of the server, such as the deletion of old bus messages,
+without serving HTTP requests. If projectDir is omitted, defaults to the current directory.
+
+Background load can thus be isolated from the web nodes, which are then started with
+'doxa server --jobs=false'. Several workers can run at the same time: each job is run
+by a single worker.
+
+On SIGINT or SIGTERM, the worker stops claiming jobs and exits once the jobs being run
+are done, or after --drain-timeout. Jobs that are still running are then run again
+later by any worker.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		projectDir := "."
+		if len(args) > 0 {
+			projectDir = args[0]
+		}
+		generateAndRunFile(projectDir, workerFileName, workerTemplate)
+	},
+}
+
+// StartWorker starts a worker running the background jobs. It is meant to be
+// called from a project start file which imports all the project's module.
+func StartWorker(config map[string]interface{}) {
+	setupConfig(config)
+	setupLogger()
+	tracing.Initialize()
+	defer tracing.Shutdown()
+	cache.Initialize()
+	defer cache.Shutdown()
+	setupSecurity()
+	server.PreInit()
+	connectToDB()
+	models.BootStrap()
+	models.LoadFieldAccess()
+	i18n.BootStrap()
+	server.LoadTranslations(i18n.Langs)
+	server.LoadInternalResources()
+	views.StrictValidation = !viper.IsSet("StrictViews") || viper.GetBool("StrictViews")
+	views.BootStrap()
+	reports.BootStrap()
+	boards.BootStrap()
+	actions.BootStrap()
+	menus.BootStrap()
+	server.PostInitModules()
+
+	models.BusGarbageCollection = true
+	models.WebhookAllowPrivateNetworks = viper.GetBool("Server.Webhooks.AllowPrivateNetworks")
+	models.StartBusRelay(viper.GetString("Server.Bus.Relay"), viper.GetDuration("Server.Bus.PollInterval"))
+	models.StartJobRunner(viper.GetDuration("Worker.PollInterval"), viper.GetInt("Worker.Concurrency"))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+	drainTimeout := viper.GetDuration("Worker.DrainTimeout")
+	log.Info("Received signal, draining jobs", "signal", sig, "timeout", drainTimeout)
+	drained := make(chan struct{})
+	go func() {
+		models.StopJobRunner()
+		models.StopBusRelay()
+		close(drained)
+	}()
+	var timeout <-chan time.Time
+	if drainTimeout > 0 {
+		timeout = time.After(drainTimeout)
+	}
+	select {
+	case <-drained:
+		log.Info("Worker stopped")
+	case <-timeout:
+		log.Warn("Drain timeout reached, running jobs will be run again later")
+	}
+	models.DBClose()
+}
+
+func init() {
+	workerCmd.Flags().Int("concurrency", 1, "Number of jobs run at the same time")
+	viper.BindPFlag("Worker.Concurrency", workerCmd.Flags().Lookup("concurrency"))
+	workerCmd.Flags().Duration("poll-interval", 10*time.Second, "Interval at which the job queue is polled for jobs to run")
+	viper.BindPFlag("Worker.PollInterval", workerCmd.Flags().Lookup("poll-interval"))
+	workerCmd.Flags().Duration("drain-timeout", 5*time.Minute, "Maximum duration to wait for the jobs being run when stopping. 0 means no limit.")
+	viper.BindPFlag("Worker.DrainTimeout", workerCmd.Flags().Lookup("drain-timeout"))
+	DoxaCmd.AddCommand(workerCmd)
+}
+
+var workerTemplate = template.Must(template.New("").Parse(`
+// This file is autogenerated by doxa-server
+// DO NOT MODIFY THIS FILE - ANY CHANGES WILL BE OVERWRITTEN
+
+package main
+
+import (
+	"github.com/labneco/doxa/cmd"
+{{ range .Imports }}	_ "{{ . }}"
+{{ end }}
+)
+
+func main() {
+	cmd.StartWorker({{ .Config }})
+}
+`))
@@ -37,6 +37,7 @@ const (
 	ActionServer      ActionType = "ir.actions.server"
 	ActionClient      ActionType = "ir.actions.client"
 	ActionCloseWindow ActionType = "ir.actions.act_window_close"
+	ActionReport      ActionType = "ir.actions.report"
 )
 
 // ActionViewType defines the type of view of an action
@@ -129,10 +130,20 @@ func NewCollection() *Collection {
 	return &res
 }
 
-// Add adds the given action to our Collection
+// Add adds the given action to our Collection,
+// replacing any action with the same id.
 func (ar *Collection) Add(a *Action) {
 	ar.Lock()
 	defer ar.Unlock()
+	if old, exists := ar.actions[a.ID]; exists {
+		links := ar.links[old.SrcModel]
+		for i, link := range links {
+			if link == old {
+				ar.links[old.SrcModel] = append(links[:i:i], links[i+1:]...)
+				break
+			}
+		}
+	}
 	ar.actions[a.ID] = a
 	ar.links[a.SrcModel] = append(ar.links[a.SrcModel], a)
 }
@@ -214,10 +225,24 @@ type Action struct {
 	AutoSearch   bool                   `json:"auto_search" xml:"auto_search,attr"`
 	Filter       bool                   `json:"filter" xml:"filter,attr"`
 	Limit        int64                  `json:"limit" xml:"limit,attr"`
-	Context      *types.Context         `json:"context" xml:"context,attr"`
+	Context      *types.Context         `json:"context" xml:"-"`
+	ContextExpr  string                 `json:"-" xml:"context,attr"`
 	Flags        map[string]interface{} `json:"flags"`
-	Tag          string                 `json:"tag"`
+	Tag          string                 `json:"tag" xml:"tag,attr"`
+	Params       *types.Context         `json:"params,omitempty" xml:"params,attr"`
+	ReportName   string                 `json:"report_name,omitempty" xml:"report_name,attr"`
+	ReportType   string                 `json:"report_type,omitempty" xml:"report_type,attr"`
+	State        ServerActionState      `json:"state,omitempty" xml:"state,attr"`
+	Values       *types.Context         `json:"-" xml:"values,attr"`
+	EmailTo      string                 `json:"-" xml:"email_to,attr"`
+	EmailSubject string                 `json:"-" xml:"email_subject,attr"`
+	EmailBody    string                 `json:"-" xml:"email_body,attr"`
+	BindingModel string                 `json:"binding_model_id,omitempty" xml:"binding_model,attr"`
+	BindingType  BindingType            `json:"binding_type,omitempty" xml:"binding_type,attr"`
 	names        map[string]string
+	// defaultViews are the ids of the views of this action that
+	// were not given explicitly and are resolved for each user.
+	defaultViews map[string]bool
 }
 
 // TranslatedName returns the translated name of this action
@@ -20,6 +20,10 @@ import (
 	"testing"
 
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
 	"github.com/labneco/doxa/doxa/tools/xmlutils"
 	"github.com/labneco/doxa/doxa/views"
 	. "github.com/smartystreets/goconvey/convey"
@@ -41,6 +45,37 @@ var actionDef2 = `
 </action>
 `
 
+var actionDef3 = `
+<action id="my_client_action" name="My Client Action" type="ir.actions.client" tag="my_screen"
+        params='{"mode": "edit"}' groups="everyone, admin"/>
+`
+
+var actionDef4 = `
+<action id="my_server_action" name="Rename Partners" type="ir.actions.server" model="Partner"
+        state="write" values='{"Name": "Renamed"}' src_model="Partner"/>
+`
+
+var actionDef5 = `
+<action id="my_method_action" name="Compute Partners" type="ir.actions.server" model="Partner"
+        method="Write"/>
+`
+
+var actionDef6 = `
+<action id="my_eval_action" name="My Partners" type="ir.actions.act_window" model="Partner" view_mode="tree,form"
+        domain="[('Name', '=', context.get('name', 'Doe')), ('ID', 'in', active_ids)]"
+        context="{'default_user_id': uid, 'search_default_mine': 1}"/>
+`
+
+var actionDef7 = `
+<action id="my_const_action" name="My Constant Partners" type="ir.actions.act_window" model="Partner" view_mode="tree,form"
+        domain="[('Name', 'ilike', 'Doe')]" context="{'search_default_mine': True}"/>
+`
+
+var actionDef8 = `
+<action id="my_bound_action" name="Archive Partners" type="ir.actions.server" model="Partner"
+        state="write" values='{"Name": "Archived"}' binding_model="Partner"/>
+`
+
 var viewDef1 = `
 <view id="my_id" name="My View" model="User">
 	<form>
@@ -156,5 +191,184 @@ func TestActions(t *testing.T) {
 			So(vr.Name(), ShouldEqual, "My Second Action")
 		})
 	})
+	Convey("Testing client actions", t, func() {
+		security.Registry.AddMembership(2, security.GroupAdmin)
+		LoadFromEtree(xmlutils.XMLToElement(actionDef3))
+		RegisterClientAction("my_admin_client_action", "My Admin Client Action", "my_admin_screen",
+			map[string]interface{}{"size": 3}, security.GroupAdmin)
+		RegisterClientAction("my_public_client_action", "My Public Client Action", "my_public_screen", nil)
+		BootStrap()
+		Convey("Client actions should be loaded from XML", func() {
+			action := Registry.MustGetById("my_client_action")
+			So(action.Type, ShouldEqual, ActionClient)
+			So(action.Tag, ShouldEqual, "my_screen")
+			So(action.Params.GetString("mode"), ShouldEqual, "edit")
+			So(action.Groups, ShouldResemble, []string{"everyone", "admin"})
+			So(action.Target, ShouldEqual, "current")
+		})
+		Convey("Client actions should be registered with their params", func() {
+			action := Registry.MustGetById("my_admin_client_action")
+			So(action.Type, ShouldEqual, ActionClient)
+			So(action.Tag, ShouldEqual, "my_admin_screen")
+			So(action.Params.GetInteger("size"), ShouldEqual, 3)
+			So(action.Groups, ShouldResemble, []string{"admin"})
+			data, err := json.Marshal(action)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"tag":"my_admin_screen","params":{"size":3}`)
+		})
+		Convey("Only members of the groups of an action should be allowed to launch it", func() {
+			So(Registry.MustGetById("my_client_action").IsAllowed(3), ShouldBeTrue)
+			So(Registry.MustGetById("my_public_client_action").IsAllowed(3), ShouldBeTrue)
+			So(Registry.MustGetById("my_admin_client_action").IsAllowed(2), ShouldBeTrue)
+			So(Registry.MustGetById("my_admin_client_action").IsAllowed(security.SuperUserID), ShouldBeTrue)
+			So(Registry.MustGetById("my_admin_client_action").IsAllowed(3), ShouldBeFalse)
+			So(Registry.GetAllowed(2), ShouldHaveLength, 5)
+			So(Registry.GetAllowed(3), ShouldHaveLength, 4)
+		})
+		Convey("Client actions without tag should panic at bootstrap", func() {
+			RegisterClientAction("my_bad_client_action", "My Bad Client Action", "", nil)
+			So(BootStrap, ShouldPanic)
+			delete(Registry.actions, "my_bad_client_action")
+		})
+		Convey("Actions with unknown groups should panic at bootstrap", func() {
+			Registry.Add(&Action{ID: "my_bad_group_action", Type: ActionClient, Tag: "my_screen", Groups: []string{"unknown_group"}})
+			So(BootStrap, ShouldPanic)
+			delete(Registry.actions, "my_bad_group_action")
+		})
+	})
+	Convey("Testing server actions", t, func() {
+		LoadFromEtree(xmlutils.XMLToElement(actionDef4))
+		LoadFromEtree(xmlutils.XMLToElement(actionDef5))
+		BootStrap()
+		Convey("Server actions should be loaded from XML", func() {
+			action := Registry.MustGetById("my_server_action")
+			So(action.State, ShouldEqual, ServerActionWrite)
+			So(action.Values.GetString("Name"), ShouldEqual, "Renamed")
+			So(Registry.GetActionLinksForModel("Partner"), ShouldContain, action)
+			data, err := json.Marshal(action)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"state":"write"`)
+			So(string(data), ShouldNotContainSubstring, "Renamed")
+		})
+		Convey("Server actions with a method should default to the method state", func() {
+			So(Registry.MustGetById("my_method_action").State, ShouldEqual, ServerActionMethod)
+		})
+		Convey("Invalid server actions should panic at bootstrap", func() {
+			for _, action := range []*Action{
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Unknown", State: ServerActionWrite},
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Partner", State: "unknown"},
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Partner", State: ServerActionMethod, Method: "Unknown"},
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Partner", State: ServerActionCreate},
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Partner", State: ServerActionWrite,
+					Values: types.NewContext(map[string]interface{}{"Unknown": 1})},
+				{ID: "my_bad_server_action", Type: ActionServer, Model: "Partner", State: ServerActionEmail, EmailTo: "Unknown"},
+			} {
+				Registry.Add(action)
+				So(BootStrap, ShouldPanic)
+			}
+			delete(Registry.actions, "my_bad_server_action")
+		})
+	})
+	Convey("Testing domain and context expressions", t, func() {
+		LoadFromEtree(xmlutils.XMLToElement(actionDef6))
+		LoadFromEtree(xmlutils.XMLToElement(actionDef7))
+		BootStrap()
+		Convey("Constant expressions should be evaluated at bootstrap", func() {
+			action := Registry.MustGetById("my_const_action")
+			So(action.Domain, ShouldEqual, `[["Name","ilike","Doe"]]`)
+			So(action.ContextExpr, ShouldBeEmpty)
+			So(action.Context.GetBool("search_default_mine"), ShouldBeTrue)
+		})
+		Convey("Expressions with variables should be evaluated for each request", func() {
+			action := Registry.MustGetById("my_eval_action")
+			So(action.Context, ShouldBeNil)
+			evaluated, err := action.evaluate(map[string]interface{}{
+				"uid":        int64(2),
+				"context":    map[string]interface{}{"name": "Smith"},
+				"active_ids": []int64{3, 4},
+			})
+			So(err, ShouldBeNil)
+			So(evaluated.Domain, ShouldEqual, `[["Name","=","Smith"],["ID","in",[3,4]]]`)
+			So(evaluated.Context.GetInteger("default_user_id"), ShouldEqual, 2)
+			So(evaluated.Context.GetInteger("search_default_mine"), ShouldEqual, 1)
+			So(action.Context, ShouldBeNil)
+			_, err = action.evaluate(map[string]interface{}{"uid": int64(2)})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("today() and now() should be available in expressions", func() {
+			action := &Action{ID: "my_today_action", Domain: "[('Date', '<=', today()), ('LastUpdate', '<', now())]"}
+			Registry.Add(action)
+			BootStrap()
+			evaluated, err := action.evaluate(map[string]interface{}{"today": exprutils.Func(today), "now": exprutils.Func(now)})
+			So(err, ShouldBeNil)
+			So(evaluated.Domain, ShouldContainSubstring, dates.Today().String())
+			_, err = exprutils.Eval("today(1)", map[string]interface{}{"today": exprutils.Func(today)})
+			So(err, ShouldNotBeNil)
+			_, err = exprutils.Eval("today", map[string]interface{}{"today": exprutils.Func(today)})
+			So(err, ShouldNotBeNil)
+			delete(Registry.actions, "my_today_action")
+		})
+		Convey("Invalid expressions should panic at bootstrap", func() {
+			for _, action := range []*Action{
+				{ID: "my_bad_expr_action", Domain: "[('Name', '=', "},
+				{ID: "my_bad_expr_action", Domain: "[('Name', '=', user.name)]"},
+				{ID: "my_bad_expr_action", Domain: "{'Name': 'Doe'}"},
+				{ID: "my_bad_expr_action", Domain: "[('Name', 'unknown', 'Doe')]"},
+				{ID: "my_bad_expr_action", ContextExpr: "[('Name', '=', 'Doe')]"},
+				{ID: "my_bad_expr_action", ContextExpr: "{'default_user_id': user_id}"},
+			} {
+				Registry.Add(action)
+				So(BootStrap, ShouldPanic)
+			}
+			delete(Registry.actions, "my_bad_expr_action")
+		})
+	})
+	Convey("Testing toolbar bindings", t, func() {
+		LoadFromEtree(xmlutils.XMLToElement(actionDef8))
+		Registry.MustGetById("my_admin_client_action").Bind("Partner", BindingAction)
+		Registry.Add((&Action{ID: "my_report_action", Type: ActionReport, Name: "Partner Card", Model: "Partner",
+			ReportName: "my_report_action"}).Bind("Partner", ""))
+		BootStrap()
+		Convey("Binding types should default from the action type", func() {
+			So(Registry.MustGetById("my_bound_action").BindingType, ShouldEqual, BindingAction)
+			So(Registry.MustGetById("my_report_action").BindingType, ShouldEqual, BindingReport)
+		})
+		Convey("Toolbars should list the bound actions the user is allowed to launch", func() {
+			toolbar := Registry.GetToolbar("Partner", 2, "")
+			So(toolbar.Print, ShouldHaveLength, 1)
+			So(toolbar.Print[0].ID, ShouldEqual, "my_report_action")
+			So(toolbar.Action, ShouldHaveLength, 2)
+			So(toolbar.Action[0].ID, ShouldEqual, "my_bound_action")
+			So(toolbar.Action[1].ID, ShouldEqual, "my_admin_client_action")
+			toolbar = Registry.GetToolbar("Partner", 3, "")
+			So(toolbar.Action, ShouldHaveLength, 1)
+			So(Registry.GetToolbar("User", 2, "").Action, ShouldBeEmpty)
+			data, err := json.Marshal(Registry.GetToolbar("User", 2, ""))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"print":[],"action":[]}`)
+		})
+		Convey("Invalid bindings should panic at bootstrap", func() {
+			for _, action := range []*Action{
+				(&Action{ID: "my_bad_binding_action", Type: ActionClient, Tag: "t"}).Bind("Unknown", ""),
+				(&Action{ID: "my_bad_binding_action", Type: ActionClient, Tag: "t"}).Bind("Partner", "menu"),
+			} {
+				Registry.Add(action)
+				So(BootStrap, ShouldPanic)
+			}
+			delete(Registry.actions, "my_bad_binding_action")
+		})
+	})
+	Convey("Testing views of window actions for users", t, func() {
+		views.LoadFromEtree(xmlutils.XMLToElement(`<view id="my_partner_admin_form" model="Partner" groups="admin">
+	<form><field name="Name"/></form>
+</view>`))
+		views.BootStrap()
+		Registry.Add(&Action{ID: "my_views_action", Type: ActionActWindow, Name: "Partners", Model: "Partner", ViewMode: "tree,form"})
+		BootStrap()
+		action := Registry.MustGetById("my_views_action")
+		So(action.Views, ShouldResemble, []views.ViewTuple{{Type: views.ViewTypeList}, {ID: "my_partner_admin_form", Type: views.ViewTypeForm}})
+		So(action.viewsForUser(2), ShouldResemble, action.Views)
+		So(action.viewsForUser(3), ShouldResemble, []views.ViewTuple{{Type: views.ViewTypeList}, {Type: views.ViewTypeForm}})
+	})
 
 }
@@ -0,0 +1,111 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package actions
+
+import (
+	"sort"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// A BindingType defines in which toolbar menu of the views
+// of its binding model an action is displayed.
+type BindingType string
+
+// Binding types
+const (
+	// BindingAction actions are displayed in the 'Action' menu
+	BindingAction BindingType = "action"
+	// BindingReport actions are displayed in the 'Print' menu
+	BindingReport BindingType = "report"
+)
+
+// A Toolbar holds the actions bound to the views of a model
+type Toolbar struct {
+	// Print holds the actions of the 'Print' menu
+	Print []*Action `json:"print"`
+	// Action holds the actions of the 'Action' menu
+	Action []*Action `json:"action"`
+}
+
+// Bind binds this action to the toolbar of the views of the given model with
+// the given binding type, and returns this action. The binding type defaults
+// to BindingReport for report actions and to BindingAction otherwise.
+//
+// This method must be called before BootStrap.
+func (a *Action) Bind(model string, bindingType BindingType) *Action {
+	a.BindingModel = model
+	a.BindingType = bindingType
+	return a
+}
+
+// GetToolbar returns the actions bound to the toolbar of the views of the
+// given model that the user with the given uid is allowed to launch, with
+// their names translated in the given language. Actions are sorted by name.
+func (ar *Collection) GetToolbar(model string, uid int64, lang string) Toolbar {
+	ar.RLock()
+	defer ar.RUnlock()
+	res := Toolbar{
+		Print:  []*Action{},
+		Action: []*Action{},
+	}
+	for _, action := range ar.actions {
+		if action.BindingModel != model || !action.IsAllowed(uid) {
+			continue
+		}
+		bound := *action
+		bound.Name = action.TranslatedName(lang)
+		switch action.BindingType {
+		case BindingReport:
+			res.Print = append(res.Print, &bound)
+		default:
+			res.Action = append(res.Action, &bound)
+		}
+	}
+	for _, list := range [][]*Action{res.Print, res.Action} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name == list[j].Name {
+				return list[i].ID < list[j].ID
+			}
+			return list[i].Name < list[j].Name
+		})
+	}
+	return res
+}
+
+// bootStrapBinding sets the default binding type of the given action
+// if it has a binding model.
+//
+// It panics if the binding model or type is unknown.
+func bootStrapBinding(a *Action) {
+	if a.BindingModel == "" {
+		return
+	}
+	if _, ok := models.Registry.Get(a.BindingModel); !ok {
+		log.Panic("Unknown binding model in action", "action", a.ID, "model", a.BindingModel)
+	}
+	switch a.BindingType {
+	case "":
+		a.BindingType = BindingAction
+		if a.Type == ActionReport {
+			a.BindingType = BindingReport
+		}
+	case BindingAction, BindingReport:
+	default:
+		log.Panic("Unknown binding type in action", "action", a.ID, "type", a.BindingType)
+	}
+}
+
+// declareToolbarMethod adds to all models the GetToolbar method
+// through which clients get the actions of the toolbar of views.
+func declareToolbarMethod() {
+	models.Registry.MustGet("CommonMixin").AddMethod("GetToolbar",
+		`GetToolbar returns the actions bound to the 'Print' and 'Action' menus of
+		the toolbar of the views of this model that the current user is allowed to launch.`,
+		func(rc *models.RecordCollection) Toolbar {
+			lang := rc.Env().Lang()
+			return Registry.GetToolbar(rc.ModelName(), rc.Env().Uid(), lang)
+		}).AllowGroup(security.GroupEveryone)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package actions
+
+import (
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+)
+
+// RegisterClientAction adds to the Registry a client action with the given
+// id, name and tag, replacing any action with the same id, and returns it.
+//
+// A client action launches a screen implemented in the web client, which is
+// registered there under the same tag and receives the given params. It can
+// be bound to menus and buttons as any other action. If groups are given, only
+// the members of one of them are allowed to launch the action.
+//
+// This function must be called before BootStrap.
+func RegisterClientAction(id, name, tag string, params map[string]interface{}, groups ...*security.Group) *Action {
+	action := &Action{
+		ID:     id,
+		Type:   ActionClient,
+		Name:   name,
+		Tag:    tag,
+		Params: types.NewContext(params),
+	}
+	for _, group := range groups {
+		action.Groups = append(action.Groups, group.ID)
+	}
+	Registry.Add(action)
+	return action
+}
+
+// IsAllowed returns true if the user with the given uid can launch this action,
+// that is if the action has no groups or if the user is member of one of them.
+// The super user is allowed to launch all actions.
+func (a *Action) IsAllowed(uid int64) bool {
+	if len(a.Groups) == 0 || uid == security.SuperUserID {
+		return true
+	}
+	for _, groupID := range a.Groups {
+		if group := security.Registry.GetGroup(groupID); group != nil && security.Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllowed returns the actions of this Collection that the user
+// with the given uid can launch, in an arbitrary order.
+func (ar *Collection) GetAllowed(uid int64) []*Action {
+	ar.RLock()
+	defer ar.RUnlock()
+	var res []*Action
+	for _, action := range ar.actions {
+		if action.IsAllowed(uid) {
+			res = append(res, action)
+		}
+	}
+	return res
+}
+
+// bootStrapGroups splits the comma separated group ids of the given action,
+// as they are given in the groups attribute of XML actions.
+//
+// It panics if a group does not exist.
+func bootStrapGroups(a *Action) {
+	var groups []string
+	for _, ids := range a.Groups {
+		for _, id := range strings.Split(ids, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			if security.Registry.GetGroup(id) == nil {
+				log.Panic("Unknown group in action", "action", a.ID, "group", id)
+			}
+			groups = append(groups, id)
+		}
+	}
+	a.Groups = groups
+}
+
+// bootStrapClientAction sets the default values of the given client action.
+//
+// It panics if the action has no tag.
+func bootStrapClientAction(a *Action) {
+	if a.Tag == "" {
+		log.Panic("Client actions must have a tag", "action", a.ID)
+	}
+	if a.Target == "" {
+		a.Target = "current"
+	}
+	if a.Params == nil {
+		a.Params = types.NewContext()
+	}
+}
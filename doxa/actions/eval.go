@@ -0,0 +1,211 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package actions
+
+import (
+	"encoding/json"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+	"github.com/labneco/doxa/doxa/views"
+)
+
+// evalVars are the variables available in the domain
+// and context expressions of actions.
+var evalVars = map[string]bool{
+	"uid":          true,
+	"context":      true,
+	"active_model": true,
+	"active_id":    true,
+	"active_ids":   true,
+	"today":        true,
+	"now":          true,
+}
+
+// An InvalidExpressionError is returned when the domain or
+// context expression of an action does not evaluate to a valid
+// domain or context.
+type InvalidExpressionError string
+
+// Error returns the error message
+func (iee InvalidExpressionError) Error() string {
+	return "Invalid action expression: " + string(iee)
+}
+
+// Evaluate returns a copy of this action in which the domain and context
+// expressions are evaluated for the current user of env and the given active
+// records. The domain of the returned action is JSON encoded. The views of
+// window actions are those the current user should see.
+//
+// The following variables are available in the expressions:
+//   - uid: the id of the current user,
+//   - context: the context of env,
+//   - active_model, active_id and active_ids: the model and ids of the
+//     records from which the action is launched. active_id is the first
+//     of active_ids, or None if there is none,
+//   - today() and now(): functions returning the current date
+//     and date time, as strings.
+func (a *Action) Evaluate(env models.Environment, activeModel string, activeIDs ...int64) (*Action, error) {
+	var activeID interface{}
+	if len(activeIDs) > 0 {
+		activeID = activeIDs[0]
+	}
+	ctx := make(map[string]interface{})
+	if env.Context() != nil {
+		ctx = env.Context().ToMap()
+	}
+	vars := map[string]interface{}{
+		"uid":          env.Uid(),
+		"context":      ctx,
+		"active_model": activeModel,
+		"active_id":    activeID,
+		"active_ids":   activeIDs,
+		"today":        exprutils.Func(today),
+		"now":          exprutils.Func(now),
+	}
+	res, err := a.evaluate(vars)
+	if err != nil {
+		return nil, err
+	}
+	res.Views = a.viewsForUser(env.Uid())
+	return res, nil
+}
+
+// today returns the current date as a string. It is
+// available as today() in the expressions of actions.
+func today(args ...interface{}) (interface{}, error) {
+	if len(args) > 0 {
+		return nil, InvalidExpressionError("today() takes no arguments")
+	}
+	return dates.Today().String(), nil
+}
+
+// now returns the current date time as a string. It is
+// available as now() in the expressions of actions.
+func now(args ...interface{}) (interface{}, error) {
+	if len(args) > 0 {
+		return nil, InvalidExpressionError("now() takes no arguments")
+	}
+	return dates.Now().String(), nil
+}
+
+// viewsForUser returns the views of this action for the user with the given
+// uid. Views that were not given explicitly in the action definition or that
+// the user cannot access are replaced by the view of the same type that the
+// user should see (see views.Collection.GetViewForUser).
+func (a *Action) viewsForUser(uid int64) []views.ViewTuple {
+	if a.Type != ActionActWindow {
+		return a.Views
+	}
+	res := make([]views.ViewTuple, len(a.Views))
+	for i, vt := range a.Views {
+		res[i] = vt
+		view := views.Registry.GetByID(vt.ID)
+		if view != nil && !a.defaultViews[vt.ID] && view.IsAllowed(uid) {
+			continue
+		}
+		viewType := vt.Type
+		switch {
+		case view != nil:
+			viewType = view.Type
+		case viewType == views.ViewTypeList:
+			viewType = views.ViewTypeTree
+		}
+		res[i].ID = views.Registry.GetViewForUser(a.Model, viewType, uid).ID
+	}
+	return res
+}
+
+// evaluate returns a copy of this action in which the domain and
+// context expressions are evaluated with the given variables.
+func (a *Action) evaluate(vars map[string]interface{}) (*Action, error) {
+	res := *a
+	if a.Domain != "" {
+		domain, err := evalDomain(a.Domain, vars)
+		if err != nil {
+			return nil, err
+		}
+		res.Domain = domain
+	}
+	if a.ContextExpr != "" {
+		ctx, err := evalContext(a.ContextExpr, vars)
+		if err != nil {
+			return nil, err
+		}
+		res.Context = ctx
+	}
+	return &res, nil
+}
+
+// bootStrapExpressions checks the domain and context expressions of the given
+// action. Expressions without variables are evaluated once and for all.
+//
+// It panics if an expression is invalid or uses an unknown variable.
+func bootStrapExpressions(a *Action) {
+	if a.Domain != "" && checkExpression(a, a.Domain) {
+		domain, err := evalDomain(a.Domain, nil)
+		if err != nil {
+			log.Panic("Invalid domain in action", "action", a.ID, "error", err)
+		}
+		a.Domain = domain
+	}
+	if a.ContextExpr != "" && checkExpression(a, a.ContextExpr) {
+		ctx, err := evalContext(a.ContextExpr, nil)
+		if err != nil {
+			log.Panic("Invalid context in action", "action", a.ID, "error", err)
+		}
+		a.Context = ctx
+		a.ContextExpr = ""
+	}
+}
+
+// checkExpression checks that the given expression of the given action
+// compiles and only uses known variables. It returns true if the
+// expression is constant.
+func checkExpression(a *Action, src string) bool {
+	expr, err := exprutils.Compile(src)
+	if err != nil {
+		log.Panic("Invalid expression in action", "action", a.ID, "error", err)
+	}
+	names := expr.Names()
+	for _, name := range names {
+		if !evalVars[name] {
+			log.Panic("Unknown variable in action expression", "action", a.ID, "variable", name, "expression", src)
+		}
+	}
+	return len(names) == 0
+}
+
+// evalDomain evaluates the given domain expression with
+// the given variables and returns it JSON encoded.
+func evalDomain(src string, vars map[string]interface{}) (string, error) {
+	val, err := exprutils.Eval(src, vars)
+	if err != nil {
+		return "", err
+	}
+	domain, ok := val.([]interface{})
+	if !ok {
+		return "", InvalidExpressionError("domain is not a list: " + src)
+	}
+	if _, err = models.ParseDomain(domain); err != nil {
+		return "", InvalidExpressionError(err.Error())
+	}
+	res, _ := json.Marshal(domain)
+	return string(res), nil
+}
+
+// evalContext evaluates the given context expression with the given variables
+func evalContext(src string, vars map[string]interface{}) (*types.Context, error) {
+	val, err := exprutils.Eval(src, vars)
+	if err != nil {
+		return nil, err
+	}
+	ctx, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, InvalidExpressionError("context is not a dict: " + src)
+	}
+	return types.NewContext(ctx), nil
+}
@@ -17,9 +17,16 @@ var log *logging.Logger
 // This function must be called prior to any access to the actions Registry.
 func BootStrap() {
 	for _, a := range Registry.actions {
+		bootStrapGroups(a)
+		bootStrapExpressions(a)
+		bootStrapBinding(a)
 		switch a.Type {
 		case ActionActWindow:
 			bootStrapWindowAction(a)
+		case ActionClient:
+			bootStrapClientAction(a)
+		case ActionServer:
+			bootStrapServerAction(a)
 		}
 		// Populate translations
 		if a.names == nil {
@@ -88,6 +95,10 @@ modeLoop:
 			Type: view.Type,
 		}
 		a.Views = append(a.Views, newRef)
+		if a.defaultViews == nil {
+			a.defaultViews = make(map[string]bool)
+		}
+		a.defaultViews[view.ID] = true
 	}
 
 	// Fixes
@@ -112,4 +123,6 @@ func fixViewModes(a *Action) {
 func init() {
 	log = logging.GetLogger("actions")
 	Registry = NewCollection()
+	declareServerActionModel()
+	declareToolbarMethod()
 }
@@ -0,0 +1,199 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package actions
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/views"
+)
+
+// A ServerActionState defines what a server action does
+type ServerActionState string
+
+// Server action states
+const (
+	// ServerActionMethod actions call the method of their Method
+	// field on the records. If the method returns an *Action, it
+	// is executed next by the client.
+	ServerActionMethod ServerActionState = "method"
+	// ServerActionWrite actions write their Values on the records
+	ServerActionWrite ServerActionState = "write"
+	// ServerActionCreate actions create a record of their model with
+	// their Values and open it in a form view.
+	ServerActionCreate ServerActionState = "create"
+	// ServerActionEmail actions send an email to the address
+	// held by the EmailTo field of each record.
+	ServerActionEmail ServerActionState = "email"
+)
+
+var (
+	// ErrActionNotFound is returned when running an action that
+	// does not exist or that is not a server action.
+	ErrActionNotFound = errors.New("server action not found")
+	// ErrActionNotAllowed is returned when running an action that is
+	// restricted to groups the user is not member of.
+	ErrActionNotAllowed = errors.New("action not allowed")
+	// ErrNoEmailSender is returned when running an email server
+	// action while no EmailSender has been registered.
+	ErrNoEmailSender = errors.New("no email sender registered")
+)
+
+// An Email is an email sent by a server action about a record
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+	// Model and ResID identify the record the email is about
+	Model string
+	ResID int64
+}
+
+// An EmailSender sends the given email in the given environment
+type EmailSender func(env models.Environment, email Email) error
+
+var (
+	emailSender      EmailSender
+	emailSenderMutex sync.RWMutex
+)
+
+// RegisterEmailSender sets the function used by email server actions to
+// send emails. It is typically called by the module that implements the mail
+// subsystem.
+func RegisterEmailSender(sender EmailSender) {
+	emailSenderMutex.Lock()
+	defer emailSenderMutex.Unlock()
+	emailSender = sender
+}
+
+// RunServerAction runs the server action with the given id on the records of
+// its model with the given ids and returns the action the client must execute
+// next, if any.
+//
+// The action is run with the rights of the current user of env: the user
+// must be allowed to launch the action, records they cannot read are ignored,
+// and methods and access rights are checked as for any call.
+func RunServerAction(env models.Environment, id string, ids []int64) (*Action, error) {
+	action := Registry.GetById(id)
+	if action == nil || action.Type != ActionServer {
+		return nil, ErrActionNotFound
+	}
+	if !action.IsAllowed(env.Uid()) {
+		return nil, ErrActionNotAllowed
+	}
+	rc := env.Pool(action.Model)
+	if action.State == ServerActionCreate {
+		record := rc.Call("Create", models.FieldMap(action.Values.ToMap())).(models.RecordSet)
+		return &Action{
+			Type:     ActionActWindow,
+			Name:     action.Name,
+			Model:    action.Model,
+			ResID:    record.Ids()[0],
+			ViewMode: "form",
+			Views:    []views.ViewTuple{{Type: views.ViewTypeForm}},
+			Target:   "current",
+		}, nil
+	}
+	records := rc.Search(rc.Model().Field("ID").In(ids))
+	if records.IsEmpty() {
+		return nil, nil
+	}
+	switch action.State {
+	case ServerActionMethod:
+		if next, ok := records.Call(action.Method).(*Action); ok {
+			return next, nil
+		}
+	case ServerActionWrite:
+		records.Call("Write", models.FieldMap(action.Values.ToMap()))
+	case ServerActionEmail:
+		return nil, sendServerActionEmails(env, action, records)
+	}
+	return nil, nil
+}
+
+// sendServerActionEmails sends the email of the given email server action
+// to each of the given records that has an address.
+func sendServerActionEmails(env models.Environment, action *Action, records *models.RecordCollection) error {
+	emailSenderMutex.RLock()
+	sender := emailSender
+	emailSenderMutex.RUnlock()
+	if sender == nil {
+		return ErrNoEmailSender
+	}
+	for _, record := range records.Records() {
+		to, _ := record.Get(action.EmailTo).(string)
+		if to == "" {
+			continue
+		}
+		err := sender(env, Email{
+			To:      to,
+			Subject: action.EmailSubject,
+			Body:    action.EmailBody,
+			Model:   action.Model,
+			ResID:   record.Ids()[0],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootStrapServerAction checks the given server action. Its state
+// defaults to ServerActionMethod if it has a method.
+//
+// It panics if its model, state, method or values are invalid.
+func bootStrapServerAction(a *Action) {
+	model, ok := models.Registry.Get(a.Model)
+	if !ok {
+		log.Panic("Unknown model in server action", "action", a.ID, "model", a.Model)
+	}
+	if a.State == "" && a.Method != "" {
+		a.State = ServerActionMethod
+	}
+	switch a.State {
+	case ServerActionMethod:
+		var found bool
+		for _, name := range model.Methods().Names() {
+			found = found || name == a.Method
+		}
+		if !found {
+			log.Panic("Unknown method in server action", "action", a.ID, "model", a.Model, "method", a.Method)
+		}
+	case ServerActionWrite, ServerActionCreate:
+		if a.Values == nil || a.Values.IsEmpty() {
+			log.Panic("Server actions that write or create records must have values", "action", a.ID)
+		}
+		for field := range a.Values.ToMap() {
+			if _, ok := model.Fields().Get(field); !ok {
+				log.Panic("Unknown field in server action values", "action", a.ID, "model", a.Model, "field", field)
+			}
+		}
+	case ServerActionEmail:
+		if _, ok := model.Fields().Get(a.EmailTo); !ok {
+			log.Panic("Unknown email field in server action", "action", a.ID, "model", a.Model, "field", a.EmailTo)
+		}
+	default:
+		log.Panic("Unknown server action state", "action", a.ID, "state", a.State)
+	}
+}
+
+// declareServerActionModel declares the ServerAction model through which
+// clients run server actions.
+func declareServerActionModel() {
+	serverAction := models.NewManualModel("ServerAction")
+	serverAction.AddMethod("Run",
+		`Run runs the server action with the given id on the records with the given ids
+		and returns the action to execute next, if any.`,
+		func(rc *models.RecordCollection, actionID string, ids []int64) *Action {
+			next, err := RunServerAction(rc.Env(), actionID, ids)
+			if err != nil {
+				log.Panic(err.Error(), "action", actionID, "ids", ids, "uid", rc.Env().Uid())
+			}
+			return next
+		}).AllowGroup(security.GroupEveryone)
+}
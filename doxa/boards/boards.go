@@ -0,0 +1,257 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package boards
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models"
+)
+
+// DashboardActionID is the id of the client action that displays the
+// dashboard of the current user.
+const DashboardActionID = "board_my_dashboard"
+
+// A Layout defines the number and relative widths of the columns of a
+// board, such as "1-2" for a narrow column followed by a wide one.
+type Layout string
+
+// Board layouts
+const (
+	LayoutOneColumn    Layout = "1"
+	LayoutTwoColumns   Layout = "1-1"
+	LayoutNarrowWide   Layout = "1-2"
+	LayoutWideNarrow   Layout = "2-1"
+	LayoutThreeColumns Layout = "1-1-1"
+	DefaultLayout             = LayoutWideNarrow
+)
+
+// Columns returns the number of columns of this layout, or 0 if it is invalid
+func (l Layout) Columns() int {
+	switch l {
+	case LayoutOneColumn:
+		return 1
+	case LayoutTwoColumns, LayoutNarrowWide, LayoutWideNarrow:
+		return 2
+	case LayoutThreeColumns:
+		return 3
+	}
+	return 0
+}
+
+var (
+	// ErrUnknownAction is returned when adding to a board an action
+	// that does not exist or that is not a window action.
+	ErrUnknownAction = errors.New("unknown window action")
+	// ErrActionNotAllowed is returned when adding to a board an
+	// action that is restricted to groups the user is not member of.
+	ErrActionNotAllowed = errors.New("action not allowed")
+	// ErrItemNotFound is returned when saving a board with an
+	// item that does not exist or does not belong to the user.
+	ErrItemNotFound = errors.New("board item not found")
+)
+
+// An InvalidBoardError is returned when trying to save a board with an invalid
+// layout or column, or an item with an invalid view mode, domain or context.
+type InvalidBoardError string
+
+// Error returns the error message
+func (ibe InvalidBoardError) Error() string {
+	return "Invalid board: " + string(ibe)
+}
+
+// An Item is an action displayed in a board, with
+// the search the user made when adding it.
+type Item struct {
+	ID       int64  `json:"id"`
+	ActionID string `json:"action_id"`
+	Name     string `json:"name"`
+	// ViewMode is the type of the view of the action displayed in the board
+	ViewMode string `json:"view_mode"`
+	// Domain is the JSON encoded domain of the search
+	Domain string `json:"domain"`
+	// Context is the JSON encoded context of the search, which can hold a 'group_by' key
+	Context string `json:"context"`
+}
+
+// A Board is the dashboard of a user. Items are displayed in columns,
+// the number of which is given by the layout.
+type Board struct {
+	Layout  Layout   `json:"layout"`
+	Columns [][]Item `json:"columns"`
+}
+
+// GetBoard returns the board of the current user of env. Items whose
+// action no longer exists or is not allowed to the user are left out.
+func GetBoard(env models.Environment) Board {
+	layout := DefaultLayout
+	rc := env.Pool("Board").Sudo()
+	if board := rc.Search(rc.Model().Field("UserID").Equals(env.Uid())).Limit(1); !board.IsEmpty() {
+		layout = Layout(board.Get("Layout").(string))
+	}
+	res := Board{
+		Layout:  layout,
+		Columns: make([][]Item, layout.Columns()),
+	}
+	for i := range res.Columns {
+		res.Columns[i] = []Item{}
+	}
+	for _, rec := range userItems(env).Records() {
+		item := Item{
+			ID:       rec.Ids()[0],
+			ActionID: rec.Get("ActionID").(string),
+			Name:     rec.Get("Name").(string),
+			ViewMode: rec.Get("ViewMode").(string),
+			Domain:   rec.Get("Domain").(string),
+			Context:  rec.Get("Context").(string),
+		}
+		if _, err := windowAction(env.Uid(), item.ActionID); err != nil {
+			continue
+		}
+		col := int(rec.Get("BoardColumn").(int64))
+		if col >= len(res.Columns) {
+			// The layout has been changed to one with fewer columns
+			col = len(res.Columns) - 1
+		}
+		res.Columns[col] = append(res.Columns[col], item)
+	}
+	return res
+}
+
+// AddToDashboard adds the given item at the top of the first column of the
+// board of the current user of env and returns its id. This is how users add
+// the search they made on a list or any other view of an action to their board.
+//
+// The view mode defaults to the first view mode of the action and the
+// name to the name of the action.
+func AddToDashboard(env models.Environment, item Item) (int64, error) {
+	action, err := windowAction(env.Uid(), item.ActionID)
+	if err != nil {
+		return 0, err
+	}
+	if item.ViewMode == "" {
+		item.ViewMode = strings.TrimSpace(strings.Split(action.ViewMode, ",")[0])
+	}
+	if item.Name == "" {
+		item.Name = action.Name
+	}
+	if err = checkItem(action, item); err != nil {
+		return 0, err
+	}
+	if item.Domain == "" {
+		item.Domain = "[]"
+	}
+	if item.Context == "" {
+		item.Context = "{}"
+	}
+	rc := env.Pool("BoardItem").Sudo()
+	for _, rec := range rc.Search(rc.Model().Field("UserID").Equals(env.Uid()).And().Field("BoardColumn").Equals(0)).Records() {
+		rec.Call("Write", models.FieldMap{"Sequence": rec.Get("Sequence").(int64) + 1})
+	}
+	return rc.Call("Create", models.FieldMap{
+		"UserID":      env.Uid(),
+		"ActionID":    item.ActionID,
+		"Name":        item.Name,
+		"ViewMode":    item.ViewMode,
+		"Domain":      item.Domain,
+		"Context":     item.Context,
+		"BoardColumn": 0,
+		"Sequence":    0,
+	}).(models.RecordSet).Ids()[0], nil
+}
+
+// SaveBoard saves the layout of the given board and the position and name
+// of its items for the current user of env. The items of the user that are
+// not in the given board are removed.
+func SaveBoard(env models.Environment, board Board) error {
+	if board.Layout.Columns() == 0 {
+		return InvalidBoardError("unknown layout " + string(board.Layout))
+	}
+	if len(board.Columns) > board.Layout.Columns() {
+		return InvalidBoardError("too many columns for layout " + string(board.Layout))
+	}
+	items := userItems(env)
+	existing := make(map[int64]*models.RecordCollection)
+	for _, rec := range items.Records() {
+		existing[rec.Ids()[0]] = rec
+	}
+	kept := make(map[int64]bool)
+	for col, column := range board.Columns {
+		for seq, item := range column {
+			rec, ok := existing[item.ID]
+			if !ok {
+				return ErrItemNotFound
+			}
+			values := models.FieldMap{"BoardColumn": col, "Sequence": seq}
+			if item.Name != "" {
+				values["Name"] = item.Name
+			}
+			rec.Call("Write", values)
+			kept[item.ID] = true
+		}
+	}
+	for id, rec := range existing {
+		if !kept[id] {
+			rec.Call("Unlink")
+		}
+	}
+	rc := env.Pool("Board").Sudo()
+	values := models.FieldMap{"UserID": env.Uid(), "Layout": string(board.Layout)}
+	if rec := rc.Search(rc.Model().Field("UserID").Equals(env.Uid())).Limit(1); !rec.IsEmpty() {
+		rec.Call("Write", values)
+		return nil
+	}
+	rc.Call("Create", values)
+	return nil
+}
+
+// userItems returns the board items of the current user of env, in display order
+func userItems(env models.Environment) *models.RecordCollection {
+	rc := env.Pool("BoardItem").Sudo()
+	return rc.Search(rc.Model().Field("UserID").Equals(env.Uid())).OrderBy("BoardColumn", "Sequence", "ID")
+}
+
+// windowAction returns the window action with the given id if the user
+// with the given uid is allowed to access it.
+func windowAction(uid int64, id string) (*actions.Action, error) {
+	action := actions.Registry.GetById(id)
+	if action == nil || action.Type != actions.ActionActWindow {
+		return nil, ErrUnknownAction
+	}
+	if !action.IsAllowed(uid) {
+		return nil, ErrActionNotAllowed
+	}
+	return action, nil
+}
+
+// checkItem returns an InvalidBoardError if the view mode of the given item
+// is not a view mode of the given action or if its domain or context is invalid.
+func checkItem(action *actions.Action, item Item) error {
+	var validMode bool
+	for _, mode := range strings.Split(action.ViewMode, ",") {
+		validMode = validMode || strings.TrimSpace(mode) == item.ViewMode
+	}
+	if !validMode {
+		return InvalidBoardError("view mode " + item.ViewMode + " is not a view mode of action " + action.ID)
+	}
+	if item.Domain != "" {
+		var domain []interface{}
+		if err := json.Unmarshal([]byte(item.Domain), &domain); err != nil {
+			return InvalidBoardError("domain is not a JSON list: " + err.Error())
+		}
+		if _, err := models.ParseDomain(domain); err != nil {
+			return InvalidBoardError(err.Error())
+		}
+	}
+	if item.Context != "" {
+		var ctx map[string]interface{}
+		if err := json.Unmarshal([]byte(item.Context), &ctx); err != nil {
+			return InvalidBoardError("context is not a JSON object: " + err.Error())
+		}
+	}
+	return nil
+}
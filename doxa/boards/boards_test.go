@@ -0,0 +1,108 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package boards
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tests"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMain(m *testing.M) {
+	tests.RunUnitTests(m)
+}
+
+func TestBoards(t *testing.T) {
+	managers := security.Registry.NewGroup("board_test_managers", "Board Managers")
+	security.Registry.AddMembership(2, managers)
+	actions.Registry.Add(&actions.Action{
+		ID:       "board_test_users",
+		Type:     actions.ActionActWindow,
+		Name:     "Users",
+		Model:    "User",
+		ViewMode: "tree, form, graph",
+	})
+	actions.Registry.Add(&actions.Action{
+		ID:       "board_test_reports",
+		Type:     actions.ActionActWindow,
+		Name:     "Reports",
+		Model:    "User",
+		ViewMode: "graph",
+		Groups:   []string{managers.ID},
+	})
+	Convey("Testing board layouts", t, func() {
+		So(LayoutOneColumn.Columns(), ShouldEqual, 1)
+		So(LayoutNarrowWide.Columns(), ShouldEqual, 2)
+		So(LayoutThreeColumns.Columns(), ShouldEqual, 3)
+		So(DefaultLayout.Columns(), ShouldEqual, 2)
+		So(Layout("3-1").Columns(), ShouldEqual, 0)
+	})
+	Convey("Testing the actions that can be added to a board", t, func() {
+		BootStrap()
+		So(actions.Registry.MustGetById(DashboardActionID).Type, ShouldEqual, actions.ActionClient)
+		Convey("Window actions without groups should be allowed to all users", func() {
+			action, err := windowAction(3, "board_test_users")
+			So(err, ShouldBeNil)
+			So(action.Name, ShouldEqual, "Users")
+		})
+		Convey("Restricted window actions should only be allowed to their groups", func() {
+			_, err := windowAction(2, "board_test_reports")
+			So(err, ShouldBeNil)
+			_, err = windowAction(3, "board_test_reports")
+			So(err, ShouldEqual, ErrActionNotAllowed)
+		})
+		Convey("Unknown actions and other types of actions should not be allowed", func() {
+			_, err := windowAction(2, "board_test_unknown")
+			So(err, ShouldEqual, ErrUnknownAction)
+			_, err = windowAction(2, DashboardActionID)
+			So(err, ShouldEqual, ErrUnknownAction)
+		})
+	})
+	Convey("Testing board items checks", t, func() {
+		action := actions.Registry.MustGetById("board_test_users")
+		Convey("Valid items should pass", func() {
+			So(checkItem(action, Item{ViewMode: "graph", Domain: `[["IsStaff", "=", true]]`, Context: `{"group_by": "name"}`}), ShouldBeNil)
+			So(checkItem(action, Item{ViewMode: "tree"}), ShouldBeNil)
+		})
+		Convey("Items with a view mode which is not one of the action should fail", func() {
+			So(checkItem(action, Item{ViewMode: "kanban"}), ShouldHaveSameTypeAs, InvalidBoardError(""))
+		})
+		Convey("Items with an invalid domain or context should fail", func() {
+			So(checkItem(action, Item{ViewMode: "tree", Domain: `{"IsStaff": true}`}), ShouldHaveSameTypeAs, InvalidBoardError(""))
+			So(checkItem(action, Item{ViewMode: "tree", Domain: `[["IsStaff", "="]]`}), ShouldHaveSameTypeAs, InvalidBoardError(""))
+			So(checkItem(action, Item{ViewMode: "tree", Context: `["group_by"]`}), ShouldHaveSameTypeAs, InvalidBoardError(""))
+		})
+	})
+}
+
+func TestBoardAccess(t *testing.T) {
+	Convey("Testing the access to the dashboards", t, func() {
+		env := models.NewMockEnvironment(security.SuperUserID)
+		for _, uid := range []int64{2, 3} {
+			env.Pool("Board").Call("Create", models.FieldMap{"UserID": uid, "Layout": string(DefaultLayout)})
+			env.Pool("BoardItem").Call("Create", models.FieldMap{
+				"UserID":   uid,
+				"ActionID": "board_test_users",
+				"Name":     "Users",
+				"ViewMode": "tree",
+				"Domain":   `[["IsStaff", "=", true]]`,
+			})
+		}
+		Convey("Users should only read their own board and items", func() {
+			for _, modelName := range []string{"Board", "BoardItem"} {
+				records := env.Pool(modelName).Sudo(2).SearchAll()
+				So(records.Len(), ShouldEqual, 1)
+				So(records.Get("UserID"), ShouldEqual, 2)
+			}
+		})
+		Convey("Administrators should read all boards and items", func() {
+			So(env.Pool("Board").SearchAll().Len(), ShouldEqual, 2)
+			So(env.Pool("BoardItem").SearchAll().Len(), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package boards implements the dashboards of the users.
+
+A dashboard is a per user page aggregating several window actions, each
+displayed with the view mode, domain and context the user had when adding it
+with the 'Add to my dashboard' function of the search view.
+
+Clients manage their dashboard through the GetBoard, AddToDashboard and
+SaveBoard methods of the Board model, and display it with the
+'board_my_dashboard' client action.
+*/
+package boards
+
+import (
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+var log *logging.Logger
+
+// BootStrap registers the client action that displays the dashboard of the
+// current user, so that it can be opened from a menu.
+// This function must be called before actions.BootStrap.
+func BootStrap() {
+	actions.Registry.Add(&actions.Action{
+		ID:   DashboardActionID,
+		Type: actions.ActionClient,
+		Name: "My Dashboard",
+		Tag:  "board",
+	})
+}
+
+func init() {
+	log = logging.GetLogger("boards")
+
+	// Users can only access their own board and items. The methods of
+	// the Board model reach them with Sudo for the current user.
+	board := models.NewModel("Board")
+	board.AddFields(map[string]models.FieldDefinition{
+		"UserID": models.IntegerField{Required: true, Unique: true, Index: true},
+		"Layout": models.CharField{Default: models.DefaultValue(string(DefaultLayout))},
+	})
+	board.RestrictToOwner("UserID")
+	board.AddMethod("GetBoard",
+		`GetBoard returns the dashboard of the current user`,
+		func(rc *models.RecordCollection) Board {
+			return GetBoard(rc.Env())
+		}).AllowGroup(security.GroupEveryone)
+	board.AddMethod("AddToDashboard",
+		`AddToDashboard adds the given action with its view mode, domain and context
+		to the dashboard of the current user and returns the id of the new item.`,
+		func(rc *models.RecordCollection, item Item) int64 {
+			id, err := AddToDashboard(rc.Env(), item)
+			if err != nil {
+				log.Panic(err.Error(), "action", item.ActionID, "uid", rc.Env().Uid())
+			}
+			return id
+		}).AllowGroup(security.GroupEveryone)
+	board.AddMethod("SaveBoard",
+		`SaveBoard saves the layout of the dashboard of the current user,
+		with the position and name of its items. Items that are not in the
+		given board are removed from the dashboard.`,
+		func(rc *models.RecordCollection, b Board) {
+			if err := SaveBoard(rc.Env(), b); err != nil {
+				log.Panic(err.Error(), "layout", b.Layout, "uid", rc.Env().Uid())
+			}
+		}).AllowGroup(security.GroupEveryone)
+
+	boardItem := models.NewModel("BoardItem")
+	boardItem.AddFields(map[string]models.FieldDefinition{
+		"UserID":      models.IntegerField{Required: true, Index: true},
+		"ActionID":    models.CharField{Required: true},
+		"Name":        models.CharField{Required: true},
+		"ViewMode":    models.CharField{Required: true},
+		"Domain":      models.TextField{Default: models.DefaultValue("[]")},
+		"Context":     models.TextField{Default: models.DefaultValue("{}")},
+		"BoardColumn": models.IntegerField{Help: "Index of the column of the board in which the item is displayed"},
+		"Sequence":    models.IntegerField{},
+	})
+	boardItem.RestrictToOwner("UserID")
+}
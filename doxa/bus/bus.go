@@ -0,0 +1,251 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package bus dispatches live notifications to the clients connected to this
+server process.
+
+Messages are sent on named channels. By convention, channel names are made of
+a kind and an identifier separated by a colon, such as 'user:3' or
+'model:Partner'. Clients subscribe to the channels they are interested in
+and receive the messages sent on these channels.
+
+This package only dispatches messages between the subscribers of the current
+process. Sending messages and relaying them across server workers is done by
+the models package, which stores messages in the database and calls Dispatch
+in each worker.
+*/
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+// SubscriberBufferSize is the number of messages that can be waiting
+// for a subscriber. Messages sent to a subscriber whose buffer is full
+// are dropped.
+var SubscriberBufferSize = 100
+
+var (
+	log        *logging.Logger
+	defaultHub *Hub
+)
+
+// A Message is a notification sent on a channel
+type Message struct {
+	ID      int64           `json:"id"`
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"`
+}
+
+// A Subscriber receives the messages sent on the channels it is subscribed to
+type Subscriber struct {
+	// UID is the id of the user of this Subscriber
+	UID int64
+	// C is the channel on which messages are delivered
+	C        <-chan Message
+	c        chan Message
+	hub      *Hub
+	channels map[string]bool
+	closed   bool
+}
+
+// Subscribe subscribes this Subscriber to the given channels
+func (s *Subscriber) Subscribe(channels ...string) {
+	s.hub.Lock()
+	defer s.hub.Unlock()
+	if s.closed {
+		return
+	}
+	for _, channel := range channels {
+		s.channels[channel] = true
+		if _, ok := s.hub.subscribers[channel]; !ok {
+			s.hub.subscribers[channel] = make(map[*Subscriber]bool)
+		}
+		s.hub.subscribers[channel][s] = true
+	}
+}
+
+// Unsubscribe unsubscribes this Subscriber from the given channels
+func (s *Subscriber) Unsubscribe(channels ...string) {
+	s.hub.Lock()
+	defer s.hub.Unlock()
+	s.unsubscribe(channels...)
+}
+
+// unsubscribe removes this Subscriber from the given channels.
+// The hub must be locked by the caller.
+func (s *Subscriber) unsubscribe(channels ...string) {
+	for _, channel := range channels {
+		delete(s.channels, channel)
+		delete(s.hub.subscribers[channel], s)
+		if len(s.hub.subscribers[channel]) == 0 {
+			delete(s.hub.subscribers, channel)
+		}
+	}
+}
+
+// Channels returns the sorted list of channels this Subscriber is subscribed to
+func (s *Subscriber) Channels() []string {
+	s.hub.RLock()
+	defer s.hub.RUnlock()
+	res := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		res = append(res, channel)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Close unsubscribes this Subscriber from all its channels and closes C
+func (s *Subscriber) Close() {
+	s.hub.Lock()
+	defer s.hub.Unlock()
+	if s.closed {
+		return
+	}
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	s.unsubscribe(channels...)
+	s.closed = true
+	close(s.c)
+}
+
+// A Hub dispatches messages to its subscribers
+type Hub struct {
+	sync.RWMutex
+	subscribers map[string]map[*Subscriber]bool
+}
+
+// NewHub returns a pointer to a new empty Hub
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[string]map[*Subscriber]bool),
+	}
+}
+
+// NewSubscriber returns a new Subscriber for the given user
+// subscribed to the given channels.
+func (h *Hub) NewSubscriber(uid int64, channels ...string) *Subscriber {
+	c := make(chan Message, SubscriberBufferSize)
+	sub := &Subscriber{
+		UID:      uid,
+		C:        c,
+		c:        c,
+		hub:      h,
+		channels: make(map[string]bool),
+	}
+	sub.Subscribe(channels...)
+	return sub
+}
+
+// Dispatch delivers the given messages to the subscribers of their channels
+func (h *Hub) Dispatch(messages ...Message) {
+	h.RLock()
+	defer h.RUnlock()
+	for _, msg := range messages {
+		for sub := range h.subscribers[msg.Channel] {
+			select {
+			case sub.c <- msg:
+			default:
+				log.Warn("Dropping bus message for slow subscriber", "channel", msg.Channel, "id", msg.ID, "uid", sub.UID)
+			}
+		}
+	}
+}
+
+// Presence returns the sorted ids of the users subscribed
+// to the given channel on this Hub.
+func (h *Hub) Presence(channel string) []int64 {
+	h.RLock()
+	defer h.RUnlock()
+	uids := make(map[int64]bool)
+	for sub := range h.subscribers[channel] {
+		uids[sub.UID] = true
+	}
+	res := make([]int64, 0, len(uids))
+	for uid := range uids {
+		res = append(res, uid)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Subscribe returns a new Subscriber of the default hub for the
+// given user subscribed to the given channels.
+func Subscribe(uid int64, channels ...string) *Subscriber {
+	return defaultHub.NewSubscriber(uid, channels...)
+}
+
+// Dispatch delivers the given messages to the
+// subscribers of their channels on the default hub.
+func Dispatch(messages ...Message) {
+	defaultHub.Dispatch(messages...)
+}
+
+// Presence returns the sorted ids of the users subscribed
+// to the given channel on the default hub.
+func Presence(channel string) []int64 {
+	return defaultHub.Presence(channel)
+}
+
+// A ChannelAuthorizer returns true if the user with the given uid
+// may subscribe to the channel with the given identifier.
+type ChannelAuthorizer func(uid int64, identifier string) bool
+
+var (
+	authorizersMutex sync.RWMutex
+	authorizers      map[string]ChannelAuthorizer
+)
+
+// RegisterChannelKind registers the ChannelAuthorizer of the channels
+// of the given kind, that is the channels named '<kind>:<identifier>'.
+func RegisterChannelKind(kind string, authorizer ChannelAuthorizer) {
+	authorizersMutex.Lock()
+	defer authorizersMutex.Unlock()
+	authorizers[kind] = authorizer
+}
+
+// CanSubscribe returns true if the user with the given uid may subscribe
+// to the given channel. Subscribing to channels of unregistered kinds
+// is not allowed.
+func CanSubscribe(uid int64, channel string) bool {
+	authorizersMutex.RLock()
+	defer authorizersMutex.RUnlock()
+	kind, identifier := channel, ""
+	if i := strings.Index(channel, ":"); i >= 0 {
+		kind, identifier = channel[:i], channel[i+1:]
+	}
+	authorizer, ok := authorizers[kind]
+	if !ok {
+		return false
+	}
+	return authorizer(uid, identifier)
+}
+
+// UserChannel returns the private channel of the user with the given uid
+func UserChannel(uid int64) string {
+	return fmt.Sprintf("user:%d", uid)
+}
+
+func init() {
+	log = logging.GetLogger("bus")
+	defaultHub = NewHub()
+	authorizers = make(map[string]ChannelAuthorizer)
+	// Public channels can be subscribed by anyone
+	RegisterChannelKind("public", func(uid int64, identifier string) bool {
+		return true
+	})
+	// User channels can only be subscribed by their user
+	RegisterChannelKind("user", func(uid int64, identifier string) bool {
+		return identifier == fmt.Sprintf("%d", uid)
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package bus
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBus(t *testing.T) {
+	Convey("Testing the bus hub", t, func() {
+		hub := NewHub()
+		sub1 := hub.NewSubscriber(1, "public:news", "user:1")
+		sub2 := hub.NewSubscriber(2, "public:news")
+		Convey("Messages should be delivered to the subscribers of their channel", func() {
+			hub.Dispatch(Message{ID: 1, Channel: "public:news", Message: json.RawMessage(`"hello"`)},
+				Message{ID: 2, Channel: "user:1", Message: json.RawMessage(`"private"`)})
+			So(sub1.C, ShouldHaveLength, 2)
+			So(sub2.C, ShouldHaveLength, 1)
+			msg := <-sub1.C
+			So(msg.ID, ShouldEqual, 1)
+			msg = <-sub1.C
+			So(msg.Channel, ShouldEqual, "user:1")
+			msg = <-sub2.C
+			So(string(msg.Message), ShouldEqual, `"hello"`)
+		})
+		Convey("Subscriptions can be modified", func() {
+			sub2.Subscribe("public:sales")
+			sub1.Unsubscribe("public:news")
+			So(sub1.Channels(), ShouldResemble, []string{"user:1"})
+			So(sub2.Channels(), ShouldResemble, []string{"public:news", "public:sales"})
+			hub.Dispatch(Message{ID: 3, Channel: "public:news"})
+			So(sub1.C, ShouldHaveLength, 0)
+			So(sub2.C, ShouldHaveLength, 1)
+		})
+		Convey("Presence should list the users subscribed to a channel", func() {
+			So(hub.Presence("public:news"), ShouldResemble, []int64{1, 2})
+			So(hub.Presence("user:1"), ShouldResemble, []int64{1})
+			So(hub.Presence("public:other"), ShouldBeEmpty)
+		})
+		Convey("Closed subscribers should not receive messages anymore", func() {
+			sub2.Close()
+			_, ok := <-sub2.C
+			So(ok, ShouldBeFalse)
+			So(hub.Presence("public:news"), ShouldResemble, []int64{1})
+			hub.Dispatch(Message{ID: 4, Channel: "public:news"})
+			So(sub1.C, ShouldHaveLength, 1)
+			So(func() { sub2.Close() }, ShouldNotPanic)
+		})
+		Convey("Messages should be dropped for subscribers with a full buffer", func() {
+			for i := 0; i < SubscriberBufferSize+10; i++ {
+				hub.Dispatch(Message{ID: int64(i), Channel: "user:1"})
+			}
+			So(sub1.C, ShouldHaveLength, SubscriberBufferSize)
+		})
+	})
+	Convey("Testing channel authorizations", t, func() {
+		So(CanSubscribe(3, "public:news"), ShouldBeTrue)
+		So(CanSubscribe(3, UserChannel(3)), ShouldBeTrue)
+		So(CanSubscribe(3, "user:4"), ShouldBeFalse)
+		So(CanSubscribe(3, "unknown:channel"), ShouldBeFalse)
+		So(CanSubscribe(3, "nokind"), ShouldBeFalse)
+		RegisterChannelKind("team", func(uid int64, identifier string) bool {
+			return uid == 3 && identifier == "a"
+		})
+		So(CanSubscribe(3, "team:a"), ShouldBeTrue)
+		So(CanSubscribe(3, "team:b"), ShouldBeFalse)
+		So(CanSubscribe(4, "team:a"), ShouldBeFalse)
+	})
+}
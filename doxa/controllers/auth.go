@@ -0,0 +1,109 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// tokenRequest is the body expected by the token endpoint
+type tokenRequest struct {
+	Login    string `json:"login" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	TOTPCode string `json:"totp_code"`
+}
+
+// IssueToken authenticates the user with the login and password given in
+// the JSON body of the request and returns a new JSON Web Token for the user.
+// If the user has enabled two-factor authentication, a valid code must also
+// be given in the totp_code field. Codes are checked with
+// models.AuthenticateTOTP, so that the user is locked out after too many
+// invalid codes. No token is issued for inactive users.
+//
+// Clients then authenticate their requests with this token in the
+// Authorization header ("Authorization: Bearer <token>").
+func IssueToken(c *server.Context) {
+	signer := server.JWTSigner()
+	if len(signer.SigningKey) == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var req tokenRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if !c.AllowLogin(req.Login) {
+		return
+	}
+	uid, err := models.Authenticate(req.Login, req.Password, c.ClientIP(), types.NewContext())
+	if _, locked := err.(security.AccountLockedError); locked {
+		c.AbortWithError(http.StatusTooManyRequests, err)
+		return
+	}
+	if err != nil {
+		log.Warn("Token request with invalid credentials", "login", req.Login, "ip", c.ClientIP())
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	if models.TOTPEnabled(uid) {
+		if !c.AllowLogin(models.TOTPLogin(uid)) {
+			return
+		}
+		err = models.AuthenticateTOTP(uid, req.TOTPCode, c.ClientIP())
+		if _, locked := err.(security.AccountLockedError); locked {
+			c.AbortWithError(http.StatusTooManyRequests, err)
+			return
+		}
+		if err != nil {
+			log.Warn("Token request with invalid two-factor authentication code", "login", req.Login, "ip", c.ClientIP())
+			c.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+	}
+	token, err := c.IssueToken(uid)
+	if _, invalid := err.(security.InvalidTokenError); invalid {
+		log.Warn("Token request for an inactive user", "login", req.Login, "ip", c.ClientIP())
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(signer.Expiry.Seconds()),
+	})
+}
+
+// unlockRequest is the body expected by the unlock endpoint
+type unlockRequest struct {
+	Login string `json:"login" binding:"required"`
+	IP    string `json:"ip"`
+}
+
+// UnlockLogin removes the lock of the login (and optionally of the IP
+// address) given in the JSON body of the request. Only users allowed to
+// unlink LoginLockout records (administrators by default) can call it.
+func UnlockLogin(c *server.Context) {
+	var req unlockRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		models.UnlockLogin(env, req.Login, req.IP)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
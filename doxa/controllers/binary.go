@@ -0,0 +1,266 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// uploadFilePart is the name of the multipart form part holding the uploaded file
+const uploadFilePart = "ufile"
+
+// An uploadTarget is the record field to which an uploaded file is linked
+type uploadTarget struct {
+	model string
+	id    int64
+	field string
+}
+
+// detectMimeType returns the given declared mime type of a file, or the
+// mime type detected from the first bytes of r if it is not specific.
+func detectMimeType(declared string, r *bufio.Reader) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	head, _ := r.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// storeUpload streams the content of r into the file store and links it to
+// the given target with an attachment. It returns the attachment data.
+func storeUpload(uid int64, target uploadTarget, name, mimeType string, r io.Reader) (gin.H, error) {
+	// We check access before streaming so that unauthorized
+	// uploads are not written to the file store.
+	var accessErr error
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		accessErr = models.CheckAttachmentAccess(env, target.model, target.id, target.field, "write")
+	})
+	if err != nil {
+		return nil, err
+	}
+	if accessErr != nil {
+		return nil, accessErr
+	}
+	br := bufio.NewReader(r)
+	mimeType = detectMimeType(mimeType, br)
+	// The content is streamed outside of any transaction
+	checksum, size, err := models.FileStore.Write(br)
+	if err != nil {
+		return nil, err
+	}
+	var res gin.H
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		var attachment *models.RecordCollection
+		attachment, accessErr = models.LinkAttachment(env, name, mimeType, checksum, size, target.model, target.id, target.field)
+		if accessErr != nil {
+			return
+		}
+		res = gin.H{
+			"id":       attachment.Ids()[0],
+			"name":     name,
+			"mimetype": mimeType,
+			"size":     size,
+			"checksum": checksum,
+		}
+	})
+	if err == nil {
+		err = accessErr
+	}
+	return res, err
+}
+
+// UploadBinary streams an uploaded file into the file store and links it to
+// the binary field given by the 'model', 'id' and 'field' query parameters.
+//
+// The file can be sent either as the 'ufile' part of a multipart form, or as
+// the raw body of the request (possibly with chunked transfer encoding), in
+// which case its name is given by the 'filename' query parameter.
+//
+// The file is never fully loaded in memory, so that large files can be uploaded.
+// It returns the id, name, mime type, size and checksum of the attachment.
+func UploadBinary(c *server.Context) {
+	target := uploadTarget{model: c.Query("model"), field: c.Query("field")}
+	target.id, _ = strconv.ParseInt(c.Query("id"), 10, 64)
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	var (
+		res gin.H
+		err error
+	)
+	if mediaType == "multipart/form-data" {
+		res, err = uploadMultipart(c, target)
+	} else {
+		name := c.Query("filename")
+		if name == "" {
+			name = target.field
+		}
+		res, err = storeUpload(c.UID(), target, name, c.ContentType(), c.Request.Body)
+	}
+	switch {
+	case err == models.ErrAttachmentNotAllowed:
+		c.AbortWithError(http.StatusForbidden, err)
+	case err != nil:
+		log.Warn("Unable to store uploaded file", "model", target.model, "id", target.id, "field", target.field, "error", err)
+		c.AbortWithError(http.StatusBadRequest, err)
+	default:
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// uploadMultipart stores the 'ufile' part of the multipart form of the request.
+// Parts are read in order without parsing the whole form.
+func uploadMultipart(c *server.Context, target uploadTarget) (gin.H, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != uploadFilePart {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+		return storeUpload(c.UID(), target, part.FileName(), part.Header.Get("Content-Type"), part)
+	}
+}
+
+// contentCacheControl is the Cache-Control header of binary contents
+// requested with their checksum in the 'unique' query parameter.
+const contentCacheControl = "max-age=31536000, immutable"
+
+// contentSecurityPolicy is the Content-Security-Policy header of binary
+// contents, so that they cannot run scripts if they are opened in the browser.
+// It is not sent with PDF files, which browsers do not display when sandboxed.
+const contentSecurityPolicy = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+// activeMimeTypes are the mime types of the contents that browsers may run
+// scripts from. Since the mime types of uploaded files are given by clients,
+// these contents are always sent as attachments.
+var activeMimeTypes = map[string]bool{
+	"text/html":                     true,
+	"application/xhtml+xml":         true,
+	"image/svg+xml":                 true,
+	"text/xml":                      true,
+	"application/xml":               true,
+	"text/javascript":               true,
+	"application/javascript":        true,
+	"application/x-javascript":      true,
+	"text/xsl":                      true,
+	"application/x-shockwave-flash": true,
+}
+
+// Content serves the content of an attachment or of a binary field.
+// The route path is either '/<id>[/<filename>]' for the attachment with the
+// given id, or '/<model>/<id>/<field>[/<filename>]' for a binary field.
+//
+// Content supports conditional requests with the ETag (which is the
+// checksum of the content) and Range requests for media playback. If the
+// 'download' query parameter is set, or if the content could run scripts in
+// the browser, such as HTML or SVG files, the content is sent as an attachment.
+// If the 'unique' query parameter is the checksum of the content, the
+// response is cached by browsers without revalidation.
+func Content(c *server.Context) {
+	segments := strings.Split(strings.Trim(c.Param("path"), "/"), "/")
+	var (
+		content    *models.BinaryContent
+		err        error
+		contentErr error
+		name       string
+	)
+	switch len(segments) {
+	case 1, 2:
+		id, _ := strconv.ParseInt(segments[0], 10, 64)
+		err = models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+			content, contentErr = models.AttachmentContent(env, id)
+		})
+		if len(segments) == 2 {
+			name = segments[1]
+		}
+	case 3, 4:
+		id, _ := strconv.ParseInt(segments[1], 10, 64)
+		err = models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+			content, contentErr = models.FieldContent(env, segments[0], id, segments[2])
+		})
+		if len(segments) == 4 {
+			name = segments[3]
+		}
+	default:
+		err = models.ErrNoContent
+	}
+	if err == nil {
+		err = contentErr
+	}
+	switch {
+	case err == models.ErrAttachmentNotAllowed && c.UID() == 0:
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	case err == models.ErrAttachmentNotAllowed:
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	case err != nil || content == nil:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if name == "" {
+		name = content.Name
+	}
+	serveContent(c, content, name)
+}
+
+// serveContent writes the given content to the response with the given file name
+func serveContent(c *server.Context, content *models.BinaryContent, name string) {
+	var reader io.ReadSeeker
+	if content.Path != "" {
+		f, err := os.Open(content.Path)
+		if err != nil {
+			log.Warn("Unable to open file store content", "checksum", content.Checksum, "error", err)
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		reader = f
+	} else {
+		reader = bytes.NewReader(content.Data)
+	}
+	header := c.Writer.Header()
+	if content.MimeType != "" {
+		header.Set("Content-Type", content.MimeType)
+	}
+	mediaType, _, _ := mime.ParseMediaType(content.MimeType)
+	disposition := "inline"
+	if c.Query("download") != "" || activeMimeTypes[mediaType] {
+		disposition = "attachment"
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	header.Set("ETag", strconv.Quote(content.Checksum))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if mediaType != "application/pdf" {
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+	}
+	if content.Checksum != "" && c.Query("unique") == content.Checksum {
+		header.Set("Cache-Control", "private, "+contentCacheControl)
+	} else {
+		header.Set("Cache-Control", "private, no-cache")
+	}
+	// ServeContent handles If-None-Match and Range headers
+	http.ServeContent(c.Writer, c.Request, name, time.Time{}, reader)
+}
@@ -0,0 +1,334 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+var (
+	// BusPollTimeout is the maximum duration during which a
+	// longpolling request waits for new bus messages.
+	BusPollTimeout = 50 * time.Second
+	// BusPingPeriod is the period at which WebSocket clients are pinged
+	// and their presence is updated. Clients that do not answer within
+	// two periods are disconnected.
+	BusPingPeriod = 30 * time.Second
+)
+
+const (
+	// busWriteTimeout is the maximum duration of a write on a WebSocket connection
+	busWriteTimeout = 10 * time.Second
+	// busEventsRetry is the delay after which server-sent
+	// events clients reconnect when the connection is lost.
+	busEventsRetry = 3 * time.Second
+)
+
+var busUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// A busRequest is a command sent by a client to the bus.
+//
+// Event is only used by WebSocket clients and is either "subscribe" or
+// "unsubscribe". Last is the ID of the last message received by the client,
+// so that the messages it missed on the given channels are sent again.
+type busRequest struct {
+	Event    string   `json:"event"`
+	Channels []string `json:"channels"`
+	Last     int64    `json:"last"`
+}
+
+// authorizedChannels returns the given channels that the
+// user with the given uid is allowed to subscribe to.
+func authorizedChannels(uid int64, channels []string) []string {
+	res := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if !bus.CanSubscribe(uid, channel) {
+			log.Warn("Bus subscription denied", "uid", uid, "channel", channel)
+			continue
+		}
+		res = append(res, channel)
+	}
+	return res
+}
+
+// missedBusMessages returns the messages sent on the given
+// channels after the message with the given last ID.
+func missedBusMessages(channels []string, last int64) []bus.Message {
+	if last == 0 || len(channels) == 0 {
+		return nil
+	}
+	var res []bus.Message
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		res = models.BusMessages(env, channels, last)
+	})
+	if err != nil {
+		log.Warn("Unable to fetch missed bus messages", "error", err)
+	}
+	return res
+}
+
+// BusPoll is the longpolling endpoint of the bus. It returns the messages
+// sent on the channels given in the JSON body of the request after the
+// message with the given last ID, waiting up to BusPollTimeout for new
+// messages if there are none.
+func BusPoll(c *server.Context) {
+	var req busRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	uid := c.UID()
+	channels := authorizedChannels(uid, req.Channels)
+	// We subscribe before fetching missed messages so that
+	// we do not lose messages sent in the meantime.
+	sub := bus.Subscribe(uid, channels...)
+	defer sub.Close()
+	models.UpdateBusPresence(uid)
+	if missed := missedBusMessages(channels, req.Last); len(missed) > 0 {
+		c.JSON(http.StatusOK, missed)
+		return
+	}
+	cursor := models.NewBusCursor(req.Last)
+	timer := time.NewTimer(BusPollTimeout)
+	defer timer.Stop()
+	res := make([]bus.Message, 0)
+	for len(res) == 0 {
+		select {
+		case msg := <-sub.C:
+			if cursor.Accept(msg) {
+				res = append(res, msg)
+			}
+		case <-timer.C:
+			c.JSON(http.StatusOK, res)
+			return
+		case <-server.ShutdownStarted():
+			c.JSON(http.StatusOK, res)
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+	// Send all messages that are already waiting
+	for {
+		select {
+		case msg := <-sub.C:
+			if cursor.Accept(msg) {
+				res = append(res, msg)
+			}
+		default:
+			c.JSON(http.StatusOK, res)
+			return
+		}
+	}
+}
+
+// BusWebSocket upgrades the connection to a WebSocket on which bus messages
+// are sent to the client as JSON objects.
+//
+// The client subscribes to channels by sending busRequest JSON objects
+// with the "subscribe" event and unsubscribes with the "unsubscribe" event.
+func BusWebSocket(c *server.Context) {
+	conn, err := busUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Warn("Unable to upgrade bus connection", "error", err)
+		return
+	}
+	defer conn.Close()
+	uid := c.UID()
+	sub := bus.Subscribe(uid)
+	defer sub.Close()
+	models.UpdateBusPresence(uid)
+	missed := make(chan []bus.Message, 10)
+	go readBusRequests(conn, sub, missed)
+
+	ping := time.NewTicker(BusPingPeriod)
+	defer ping.Stop()
+	for {
+		var messages []bus.Message
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			messages = []bus.Message{msg}
+		case messages = <-missed:
+		case <-ping.C:
+			models.UpdateBusPresence(uid)
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(busWriteTimeout)); err != nil {
+				return
+			}
+			continue
+		case <-server.ShutdownStarted():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"), time.Now().Add(busWriteTimeout))
+			return
+		}
+		for _, msg := range messages {
+			conn.SetWriteDeadline(time.Now().Add(busWriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readBusRequests reads the requests of the client on conn and updates the
+// subscriptions of sub accordingly. Missed messages to send again to the
+// client are sent to the missed channel.
+//
+// sub is closed when the connection is closed by the client
+// or when the client does not answer pings.
+func readBusRequests(conn *websocket.Conn, sub *bus.Subscriber, missed chan<- []bus.Message) {
+	defer sub.Close()
+	conn.SetReadLimit(64 * 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * BusPingPeriod))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(2 * BusPingPeriod))
+		return nil
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req busRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Warn("Invalid bus request", "uid", sub.UID, "error", err)
+			continue
+		}
+		switch req.Event {
+		case "subscribe":
+			channels := authorizedChannels(sub.UID, req.Channels)
+			sub.Subscribe(channels...)
+			if messages := missedBusMessages(channels, req.Last); len(messages) > 0 {
+				select {
+				case missed <- messages:
+				default:
+					log.Warn("Dropping missed bus messages for slow client", "uid", sub.UID)
+				}
+			}
+		case "unsubscribe":
+			sub.Unsubscribe(req.Channels...)
+		default:
+			log.Warn("Unknown bus request event", "uid", sub.UID, "event", req.Event)
+		}
+	}
+}
+
+// BusPresence returns the ids of the users given in the JSON
+// body of the request who are currently connected to the bus.
+func BusPresence(c *server.Context) {
+	var req struct {
+		UIDs []int64 `json:"uids"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	var online []int64
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		online = models.BusPresence(env, req.UIDs)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"online": online})
+}
+
+// writeBusEvent writes the given message to w as a server-sent event
+// whose id is the message ID and whose data is the JSON encoded message.
+func writeBusEvent(w io.Writer, msg bus.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.ID, data)
+	return err
+}
+
+// BusEvents streams bus messages as server-sent events. It is meant for
+// clients that cannot open a WebSocket, for instance behind some proxies.
+//
+// The channels to subscribe to are given as a comma separated list in the
+// 'channels' query parameter. Messages missed since the message with the
+// ID given in the Last-Event-ID header (or the 'last' query parameter)
+// are sent first.
+func BusEvents(c *server.Context) {
+	uid := c.UID()
+	var requested []string
+	if c.Query("channels") != "" {
+		requested = strings.Split(c.Query("channels"), ",")
+	}
+	channels := authorizedChannels(uid, requested)
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last")
+	}
+	last, _ := strconv.ParseInt(lastID, 10, 64)
+	sub := bus.Subscribe(uid, channels...)
+	defer sub.Close()
+	models.UpdateBusPresence(uid)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Disable response buffering in nginx
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", busEventsRetry/time.Millisecond)
+	// The cursor skips the messages received both from the database
+	// and from the subscription, but not those committed out of order.
+	cursor := models.NewBusCursor(last)
+	for _, msg := range missedBusMessages(channels, last) {
+		cursor.Accept(msg)
+		if writeBusEvent(c.Writer, msg) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	ping := time.NewTicker(BusPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !cursor.Accept(msg) {
+				continue
+			}
+			if writeBusEvent(c.Writer, msg) != nil {
+				return
+			}
+		case <-ping.C:
+			models.UpdateBusPresence(uid)
+			// SSE comment lines keep the connection open through proxies
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-server.ShutdownStarted():
+			// Clients reconnect to another worker after busEventsRetry
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// Kinds of handlers in a RouteChain
+const (
+	MiddleWareHandler = "middleware"
+	ExtensionHandler  = "extension"
+	ControllerHandler = "controller"
+)
+
+// A ChainHandler is a handler function of a RouteChain
+type ChainHandler struct {
+	// Name is the name of the handler function
+	Name string
+	// Kind is MiddleWareHandler, ExtensionHandler or ControllerHandler
+	Kind string
+	// Group is the path of the group of a middleware
+	Group    string
+	Sequence int
+}
+
+// A RouteChain is the effective chain of handlers of a route, in
+// execution order: the middlewares of the groups from the root group,
+// then the extensions of the controller and its base implementation.
+type RouteChain struct {
+	Method string
+	Path   string
+	// Auth is the authentication requirement of the route,
+	// which is enforced before the middlewares of the groups.
+	Auth server.AuthType
+	// Timeout is the handler deadline of the route. If it is 0,
+	// the Server.HandlerTimeout configuration key applies.
+	Timeout  time.Duration
+	Handlers []ChainHandler
+}
+
+// handlerName returns the name of the given handler function,
+// without the path of its package.
+func handlerName(fnct server.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fnct).Pointer()).Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// Chains returns the effective chains of handlers of all the routes of
+// this group and of its sub groups, sorted by path and method.
+func (g *Group) Chains() []RouteChain {
+	res := g.chains("/", nil, routeSettings{auth: server.AuthPublic})
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Method < res[j].Method
+	})
+	return res
+}
+
+// chains returns the chains of the routes of this group. basePath is the
+// full path of the parent group, middleWares are the middlewares of the
+// parent groups and parent are the settings of the parent group.
+func (g *Group) chains(basePath string, middleWares []ChainHandler, parent routeSettings) []RouteChain {
+	groupPath := path.Join(basePath, g.relativePath)
+	settings := g.settings.inherit(parent)
+	for _, mw := range sortHandlers(g.middleWares) {
+		middleWares = append(middleWares, ChainHandler{
+			Name:     handlerName(mw.fnct),
+			Kind:     MiddleWareHandler,
+			Group:    groupPath,
+			Sequence: mw.sequence,
+		})
+	}
+	var res []RouteChain
+	for _, grp := range g.groups {
+		res = append(res, grp.chains(groupPath, middleWares, settings)...)
+	}
+	for route, ctlr := range g.controllers {
+		routeSettings := ctlr.settings.inherit(settings)
+		chain := RouteChain{
+			Method:   route.Method,
+			Path:     path.Join(groupPath, route.Path),
+			Auth:     routeSettings.auth,
+			Timeout:  routeSettings.timeout,
+			Handlers: append([]ChainHandler(nil), middleWares...),
+		}
+		handlers := ctlr.chain()
+		for i, h := range handlers {
+			kind := ExtensionHandler
+			if i == len(handlers)-1 {
+				kind = ControllerHandler
+			}
+			chain.Handlers = append(chain.Handlers, ChainHandler{
+				Name:     handlerName(h.fnct),
+				Kind:     kind,
+				Sequence: h.sequence,
+			})
+		}
+		res = append(res, chain)
+	}
+	return res
+}
+
+// Dump writes the effective chains of handlers of all the routes of this
+// group to w, in a human readable format. Global middlewares of the
+// server, which are executed before these chains, are not included.
+func (g *Group) Dump(w io.Writer) {
+	for _, chain := range g.Chains() {
+		switch chain.Timeout {
+		case 0:
+			fmt.Fprintf(w, "%s %s [auth: %s]\n", chain.Method, chain.Path, chain.Auth)
+		case server.NoTimeout:
+			fmt.Fprintf(w, "%s %s [auth: %s, timeout: none]\n", chain.Method, chain.Path, chain.Auth)
+		default:
+			fmt.Fprintf(w, "%s %s [auth: %s, timeout: %s]\n", chain.Method, chain.Path, chain.Auth, chain.Timeout)
+		}
+		for _, h := range chain.Handlers {
+			switch h.Kind {
+			case MiddleWareHandler:
+				fmt.Fprintf(w, "\t%-10s %4d  %s (%s)\n", h.Kind, h.Sequence, h.Name, h.Group)
+			case ExtensionHandler:
+				fmt.Fprintf(w, "\t%-10s %4d  %s\n", h.Kind, h.Sequence, h.Name)
+			default:
+				fmt.Fprintf(w, "\t%-10s %4s  %s\n", h.Kind, "", h.Name)
+			}
+		}
+	}
+}
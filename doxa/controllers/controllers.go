@@ -3,16 +3,55 @@
 
 package controllers
 
-import "github.com/labneco/doxa/doxa/server"
+import (
+	"sort"
+	"time"
+
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// DefaultSequence is the sequence of middlewares and controller
+// extensions that are added without an explicit sequence.
+const DefaultSequence = 10
 
 // Registry is the central collection of all the application controllers
 var Registry *Group
 
+// A handler is a handler function with its sequence.
+// Handlers with a lower sequence are executed first.
+type handler struct {
+	fnct     server.HandlerFunc
+	sequence int
+}
+
+// sortHandlers sorts the given handlers by sequence. The relative
+// order of handlers with the same sequence is kept.
+func sortHandlers(handlers []handler) []handler {
+	res := make([]handler, len(handlers))
+	copy(res, handlers)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].sequence < res[j].sequence
+	})
+	return res
+}
+
 // A Controller is a server function that is called through
 // an http route.
 type Controller struct {
-	route    Route
-	handlers []server.HandlerFunc
+	route Route
+	// settings are the settings of this controller.
+	// Empty settings are inherited from its group.
+	settings routeSettings
+	// handlers are the extensions of the controller
+	// followed by its base implementation
+	handlers []handler
+}
+
+// chain returns the handlers of this controller in execution order,
+// i.e. the extensions by sequence followed by the base implementation.
+func (c *Controller) chain() []handler {
+	last := len(c.handlers) - 1
+	return append(sortHandlers(c.handlers[:last]), c.handlers[last])
 }
 
 // A Group is used to group routes with common prefix, in order
@@ -22,7 +61,9 @@ type Group struct {
 	controllers  map[Route]*Controller
 	groups       map[string]*Group
 	static       map[string]string
-	middleWares  []server.HandlerFunc
+	middleWares  []handler
+	cors         *server.CORSPolicy
+	settings     routeSettings
 }
 
 // newGroup returns a pointer to a new empty Group
@@ -49,9 +90,19 @@ func (g *Group) AddGroup(relativePath string) *Group {
 }
 
 // AddController creates a controller for the given method and path and sets
-// fnct as the base handler function for this controller.
+// fnct as the base handler function for this controller. The authentication
+// requirement of the controller is the one of its group.
 // It panics if such a controller already exists.
 func (g *Group) AddController(method, relativePath string, fnct server.HandlerFunc) {
+	g.AddControllerWithAuth(method, relativePath, "", fnct)
+}
+
+// AddControllerWithAuth creates a controller for the given method and path,
+// like AddController, with the given authentication requirement. The
+// requirement is enforced before the controller and its extensions are
+// called, so that they do not need to check the user themselves.
+// It panics if such a controller already exists.
+func (g *Group) AddControllerWithAuth(method, relativePath string, authType server.AuthType, fnct server.HandlerFunc) {
 	route := Route{
 		Method: method,
 		Path:   relativePath,
@@ -61,7 +112,8 @@ func (g *Group) AddController(method, relativePath string, fnct server.HandlerFu
 	}
 	controller := &Controller{
 		route:    route,
-		handlers: []server.HandlerFunc{fnct},
+		settings: routeSettings{auth: authType},
+		handlers: []handler{{fnct: fnct}},
 	}
 	g.controllers[route] = controller
 }
@@ -75,8 +127,23 @@ func (g *Group) AddController(method, relativePath string, fnct server.HandlerFu
 // is automatically called at the end of the handler fnct. If this is not
 // the wanted behaviour, use OverrideController instead.
 //
+// The extension has the DefaultSequence. It is executed before the
+// extensions with the same sequence that have been added before.
+//
 // ExtendController panics if such a controller does not exist
 func (g *Group) ExtendController(method, relativePath string, fnct server.HandlerFunc) {
+	g.ExtendControllerWithSequence(method, relativePath, DefaultSequence, fnct)
+}
+
+// ExtendControllerWithSequence extends the controller for the given method
+// and path with the given fnct handler function, like ExtendController.
+//
+// Extensions are executed by ascending sequence, whatever the order in
+// which they are added, so that independent modules can control the order
+// of their extensions.
+//
+// ExtendControllerWithSequence panics if such a controller does not exist
+func (g *Group) ExtendControllerWithSequence(method, relativePath string, sequence int, fnct server.HandlerFunc) {
 	route := Route{
 		Method: method,
 		Path:   relativePath,
@@ -85,7 +152,7 @@ func (g *Group) ExtendController(method, relativePath string, fnct server.Handle
 		log.Panic("Trying to extend a non-existent controller",
 			"method", method, "path", relativePath)
 	}
-	g.controllers[route].handlers = append([]server.HandlerFunc{fnct}, g.controllers[route].handlers...)
+	g.controllers[route].handlers = append([]handler{{fnct: fnct, sequence: sequence}}, g.controllers[route].handlers...)
 }
 
 // OverrideController overrides the controller for the given method and path
@@ -105,7 +172,7 @@ func (g *Group) OverrideController(method, relativePath string, fnct server.Hand
 		log.Panic("Trying to override a non-existent controller",
 			"method", method, "path", relativePath)
 	}
-	g.controllers[route].handlers = append([]server.HandlerFunc{fnct})
+	g.controllers[route].handlers = []handler{{fnct: fnct}}
 }
 
 // AddStatic creates a new route at relativePath that will serve
@@ -118,13 +185,56 @@ func (g *Group) AddStatic(relativePath, fsPath string) {
 }
 
 // AddMiddleWare adds the given fnct as a new middleware for this group
-// fnct will be executed before any other middleware of this group.
+// with the DefaultSequence. fnct will be executed before any other
+// middleware of this group with the same sequence.
 //
 // Call the Next() method of fnct's context to call the next middleware.
 // If Next is not called explicitly, the next middleware is called automatically
 // at the end of fnct.
 func (g *Group) AddMiddleWare(fnct server.HandlerFunc) {
-	g.middleWares = append([]server.HandlerFunc{fnct}, g.middleWares...)
+	g.AddMiddleWareWithSequence(DefaultSequence, fnct)
+}
+
+// AddMiddleWareWithSequence adds the given fnct as a new middleware for
+// this group with the given sequence. Middlewares of a group are executed
+// by ascending sequence, whatever the order in which they are added.
+func (g *Group) AddMiddleWareWithSequence(sequence int, fnct server.HandlerFunc) {
+	g.middleWares = append([]handler{{fnct: fnct, sequence: sequence}}, g.middleWares...)
+}
+
+// SetCORS sets the CORS policy of the routes of this group and of its
+// sub groups, overriding the policy of its parent groups.
+func (g *Group) SetCORS(policy server.CORSPolicy) {
+	g.cors = &policy
+}
+
+// SetAuth sets the authentication requirement of the controllers of this
+// group and of its sub groups, overriding the requirement of its parent
+// groups. Controllers added with AddControllerWithAuth keep their own
+// requirement. Routes are server.AuthPublic by default.
+func (g *Group) SetAuth(authType server.AuthType) {
+	g.settings.auth = authType
+}
+
+// SetTimeout sets the handler deadline of the controllers of this group and
+// of its sub groups, overriding the deadline of their parent groups. Routes
+// without deadline use the Server.HandlerTimeout configuration key.
+//
+// Use server.NoTimeout for long-lived routes, such as websockets, event
+// streams or large uploads and downloads.
+func (g *Group) SetTimeout(timeout time.Duration) {
+	g.settings.timeout = timeout
+}
+
+// SetControllerTimeout sets the handler deadline of the controller for the
+// given method and path of this group, overriding the deadline of its group.
+// It panics if the controller does not exist.
+func (g *Group) SetControllerTimeout(method, relativePath string, timeout time.Duration) {
+	controller, exists := g.controllers[Route{Method: method, Path: relativePath}]
+	if !exists {
+		log.Panic("Trying to set the timeout of a controller that does not exist", "method", method, "path", relativePath)
+	}
+	controller.settings.timeout = timeout
 }
 
 // GetGroup returns the sub group of this group for the given relativePath
@@ -140,18 +250,68 @@ func (g *Group) GetGroup(relativePath string) *Group {
 // createRoutes creates the router groups and routes defined in this Group
 // in the given underlying server.RouterGroup recursively.
 func (g *Group) createRoutes(base *server.RouterGroup) {
-	for _, mw := range g.middleWares {
-		base.Use(mw)
+	g.createRoutesWithSettings(base, nil, routeSettings{auth: server.AuthPublic})
+}
+
+// routeSettings are the settings of the routes of a group or of a
+// controller. Empty values are inherited from the parent group.
+type routeSettings struct {
+	// auth is the authentication requirement of the routes
+	auth server.AuthType
+	// timeout is the handler deadline of the routes
+	timeout time.Duration
+}
+
+// inherit returns the given settings of the parent group,
+// overridden by the non empty values of rs.
+func (rs routeSettings) inherit(parent routeSettings) routeSettings {
+	if rs.auth != "" {
+		parent.auth = rs.auth
+	}
+	if rs.timeout != 0 {
+		parent.timeout = rs.timeout
+	}
+	return parent
+}
+
+// createRoutesWithSettings creates the routes of this group like
+// createRoutes. middleWares are the middlewares of the parent groups
+// and parent are the settings of the parent group.
+//
+// The authentication requirement of each route is enforced first, so
+// that no middleware runs for requests that do not fulfil it.
+func (g *Group) createRoutesWithSettings(base *server.RouterGroup, middleWares []server.HandlerFunc, parent routeSettings) {
+	settings := g.settings.inherit(parent)
+	if g.cors != nil {
+		server.SetCORSPolicy(base.BasePath(), *g.cors)
+	}
+	// Do not append to the backing array of the parent's middlewares
+	middleWares = middleWares[:len(middleWares):len(middleWares)]
+	for _, mw := range sortHandlers(g.middleWares) {
+		middleWares = append(middleWares, mw.fnct)
 	}
 	for path, grp := range g.groups {
 		newRtGrp := base.Group(path)
-		grp.createRoutes(newRtGrp)
+		grp.createRoutesWithSettings(newRtGrp, middleWares, settings)
 	}
 	for route, ctlr := range g.controllers {
-		base.Handle(route.Method, route.Path, ctlr.handlers...)
+		routeSettings := ctlr.settings.inherit(settings)
+		var handlers []server.HandlerFunc
+		if routeSettings.auth != server.AuthPublic {
+			handlers = append(handlers, server.RequireAuth(routeSettings.auth))
+		}
+		handlers = append(handlers, server.RequestTimeout(routeSettings.timeout))
+		handlers = append(handlers, middleWares...)
+		for _, h := range ctlr.chain() {
+			handlers = append(handlers, h.fnct)
+		}
+		base.Handle(route.Method, route.Path, handlers...)
 	}
-	for path, fsPath := range g.static {
-		base.Static(path, fsPath)
+	if len(g.static) > 0 {
+		staticGrp := base.Group("", middleWares...)
+		for path, fsPath := range g.static {
+			staticGrp.Static(path, fsPath)
+		}
 	}
 }
 
@@ -4,13 +4,25 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/menus"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
 	"github.com/labneco/doxa/doxa/server"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
 )
 
 func performRequest(r http.Handler, method, path string) *httptest.ResponseRecorder {
@@ -104,5 +116,477 @@ func TestControllers(t *testing.T) {
 			So(r.Code, ShouldEqual, http.StatusOK)
 			So(r.Body.String(), ShouldEqual, "doxa-middleware-before/pong-middleware")
 		})
+		Convey("Testing sequences of middlewares and extensions", func() {
+			grp := registry.GetGroup("/test")
+			grp.AddMiddleWareWithSequence(20, func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "mw20-")
+			})
+			grp.AddMiddleWare(func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "mw10-")
+			})
+			grp.AddMiddleWareWithSequence(5, func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "mw5-")
+			})
+			grp.AddController(http.MethodGet, "/ping", func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "pong")
+			})
+			grp.ExtendControllerWithSequence(http.MethodGet, "/ping", 1, func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "ext1-")
+			})
+			grp.ExtendController(http.MethodGet, "/ping", func(ctx *server.Context) {
+				ctx.String(http.StatusOK, "ext10-")
+			})
+			srv := newServer()
+			registry.createRoutes(srv.Group("/"))
+			r := performRequest(srv, http.MethodGet, "/test/ping")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(r.Body.String(), ShouldEqual, "mw5-mw10-mw20-ext1-ext10-pong")
+			Convey("Chains should list the effective handlers in execution order", func() {
+				chains := registry.Chains()
+				So(chains, ShouldHaveLength, 1)
+				So(chains[0].Method, ShouldEqual, http.MethodGet)
+				So(chains[0].Path, ShouldEqual, "/test/ping")
+				var kinds []string
+				var sequences []int
+				for _, h := range chains[0].Handlers {
+					kinds = append(kinds, h.Kind)
+					sequences = append(sequences, h.Sequence)
+				}
+				So(kinds, ShouldResemble, []string{MiddleWareHandler, MiddleWareHandler, MiddleWareHandler,
+					ExtensionHandler, ExtensionHandler, ControllerHandler})
+				So(sequences[:5], ShouldResemble, []int{5, 10, 20, 1, 10})
+				So(chains[0].Handlers[0].Group, ShouldEqual, "/test")
+				var buf bytes.Buffer
+				registry.Dump(&buf)
+				So(buf.String(), ShouldStartWith, "GET /test/ping [auth: public]\n")
+				So(buf.String(), ShouldContainSubstring, "controllers.TestControllers")
+			})
+		})
+	})
+}
+
+func TestAuthRequirements(t *testing.T) {
+	Convey("Testing authentication requirements of routes", t, func() {
+		registry := newGroup("/")
+		whoAmI := func(ctx *server.Context) {
+			ctx.String(http.StatusOK, "%d", ctx.UID())
+		}
+		registry.AddController(http.MethodGet, "/public", whoAmI)
+		registry.AddControllerWithAuth(http.MethodGet, "/none", server.AuthNone, whoAmI)
+		registry.AddControllerWithAuth(http.MethodGet, "/apikey", server.AuthAPIKey, whoAmI)
+		users := registry.AddGroup("/users")
+		users.SetAuth(server.AuthUser)
+		var middleWareCalls int
+		users.AddMiddleWare(func(ctx *server.Context) {
+			middleWareCalls++
+		})
+		users.AddController(http.MethodGet, "/me", whoAmI)
+		users.AddControllerWithAuth(http.MethodGet, "/public", server.AuthPublic, whoAmI)
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		authenticated := false
+		srv.Use(func(ctx *gin.Context) {
+			if authenticated {
+				ctx.Set("uid", int64(2))
+			}
+		})
+		registry.createRoutes(srv.Group("/"))
+		Convey("Anonymous requests should only reach public and none routes", func() {
+			So(performRequest(srv, http.MethodGet, "/public").Code, ShouldEqual, http.StatusOK)
+			So(performRequest(srv, http.MethodGet, "/none").Code, ShouldEqual, http.StatusOK)
+			So(performRequest(srv, http.MethodGet, "/users/public").Code, ShouldEqual, http.StatusOK)
+			So(performRequest(srv, http.MethodGet, "/users/me").Code, ShouldEqual, http.StatusUnauthorized)
+			So(performRequest(srv, http.MethodGet, "/apikey").Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Middlewares should not run for unauthenticated requests", func() {
+			middleWareCalls = 0
+			So(performRequest(srv, http.MethodGet, "/users/me").Code, ShouldEqual, http.StatusUnauthorized)
+			So(middleWareCalls, ShouldEqual, 0)
+			So(performRequest(srv, http.MethodGet, "/users/public").Code, ShouldEqual, http.StatusOK)
+			So(middleWareCalls, ShouldEqual, 1)
+		})
+		Convey("Authenticated requests should reach user routes", func() {
+			authenticated = true
+			r := performRequest(srv, http.MethodGet, "/users/me")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(r.Body.String(), ShouldEqual, "2")
+			So(performRequest(srv, http.MethodGet, "/public").Body.String(), ShouldEqual, "2")
+			So(performRequest(srv, http.MethodGet, "/none").Body.String(), ShouldEqual, "0")
+			So(performRequest(srv, http.MethodGet, "/apikey").Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Chains should give the requirement of each route", func() {
+			auths := make(map[string]server.AuthType)
+			for _, chain := range registry.Chains() {
+				auths[chain.Path] = chain.Auth
+			}
+			So(auths, ShouldResemble, map[string]server.AuthType{
+				"/public":       server.AuthPublic,
+				"/none":         server.AuthNone,
+				"/apikey":       server.AuthAPIKey,
+				"/users/me":     server.AuthUser,
+				"/users/public": server.AuthPublic,
+			})
+		})
+	})
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	Convey("Testing handler deadlines of routes", t, func() {
+		registry := newGroup("/")
+		ok := func(ctx *server.Context) {
+			ctx.String(http.StatusOK, "ok")
+		}
+		registry.AddController(http.MethodGet, "/default", ok)
+		registry.AddController(http.MethodGet, "/download", ok)
+		registry.SetControllerTimeout(http.MethodGet, "/download", server.NoTimeout)
+		reports := registry.AddGroup("/reports")
+		reports.SetTimeout(5 * time.Minute)
+		reports.AddController(http.MethodGet, "/print", ok)
+		So(func() { registry.SetControllerTimeout(http.MethodGet, "/missing", time.Second) }, ShouldPanic)
+		timeouts := make(map[string]time.Duration)
+		for _, chain := range registry.Chains() {
+			timeouts[chain.Path] = chain.Timeout
+		}
+		So(timeouts, ShouldResemble, map[string]time.Duration{
+			"/default":       0,
+			"/download":      server.NoTimeout,
+			"/reports/print": 5 * time.Minute,
+		})
+		var buf bytes.Buffer
+		registry.Dump(&buf)
+		So(buf.String(), ShouldContainSubstring, "GET /download [auth: public, timeout: none]\n")
+		So(buf.String(), ShouldContainSubstring, "GET /reports/print [auth: public, timeout: 5m0s]\n")
+		srv := newServer()
+		registry.createRoutes(srv.Group("/"))
+		So(performRequest(srv, http.MethodGet, "/reports/print").Body.String(), ShouldEqual, "ok")
+	})
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	Convey("Testing incoming webhooks", t, func() {
+		viper.Set("Server.Webhooks.UID", 0)
+		viper.Set("Webhooks.Test.Secret", "s3cr3t")
+		registry := newGroup("/")
+		registry.AddWebhook("/hook", IncomingWebhook{Model: "Partner", Method: "OnHook", SecretKey: "Webhooks.Test.Secret"})
+		registry.AddWebhook("/custom", IncomingWebhook{Model: "Partner", Method: "OnHook", Verify: func(c *server.Context, body []byte) bool {
+			return c.GetHeader("X-Token") == "token"
+		}})
+		srv := newServer()
+		registry.createRoutes(srv.Group("/"))
+		post := func(path string, body []byte, header, value string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+			req.Header.Set(header, value)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			return w
+		}
+		body := []byte(`{"event":"paid"}`)
+		Convey("Unsigned or badly signed requests should be rejected", func() {
+			So(performRequest(srv, http.MethodPost, "/hook").Code, ShouldEqual, http.StatusUnauthorized)
+			So(post("/hook", body, models.WebhookSignatureHeader, security.SignPayload([]byte("wrong"), body)).Code,
+				ShouldEqual, http.StatusUnauthorized)
+			So(post("/custom", body, "X-Token", "wrong").Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Too large requests should be rejected", func() {
+			large := bytes.Repeat([]byte("a"), int(server.MaxWebhookBodySize)+1)
+			So(post("/hook", large, models.WebhookSignatureHeader, security.SignPayload([]byte("s3cr3t"), large)).Code,
+				ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+		Convey("Signed requests should be refused without technical user", func() {
+			So(post("/hook", body, models.WebhookSignatureHeader, security.SignPayload([]byte("s3cr3t"), body)).Code,
+				ShouldEqual, http.StatusServiceUnavailable)
+			So(post("/custom", body, "X-Token", "token").Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+		Convey("Webhooks should be public routes", func() {
+			for _, chain := range registry.Chains() {
+				So(chain.Method, ShouldEqual, http.MethodPost)
+				So(chain.Auth, ShouldEqual, server.AuthNone)
+			}
+		})
+		Convey("Webhooks without verification should not be registered", func() {
+			So(func() { registry.AddWebhook("/unsafe", IncomingWebhook{Model: "Partner", Method: "OnHook"}) }, ShouldPanic)
+		})
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	Convey("Testing OpenAPI generation", t, func() {
+		Convey("Gin paths should be converted to OpenAPI paths", func() {
+			apiPath, params := openAPIPath("/users/:id/files/*path")
+			So(apiPath, ShouldEqual, "/users/{id}/files/{path}")
+			So(params, ShouldHaveLength, 2)
+			So(params[0].Name, ShouldEqual, "id")
+			So(params[0].In, ShouldEqual, "path")
+			So(params[1].Name, ShouldEqual, "path")
+			apiPath, params = openAPIPath("/ping")
+			So(apiPath, ShouldEqual, "/ping")
+			So(params, ShouldBeEmpty)
+		})
+		Convey("Controllers of all groups should be documented", func() {
+			registry := newGroup("/")
+			registry.AddController(http.MethodGet, "/ping", func(ctx *server.Context) {})
+			grp := registry.AddGroup("/test")
+			grp.AddController(http.MethodPost, "/items/:id", func(ctx *server.Context) {})
+			doc := &OpenAPIDocument{Paths: make(map[string]map[string]OpenAPIOperation)}
+			registry.addToOpenAPI(doc, "/")
+			So(doc.Paths, ShouldHaveLength, 2)
+			So(doc.Paths["/ping"], ShouldContainKey, "get")
+			So(doc.Paths["/test/items/{id}"], ShouldContainKey, "post")
+			op := doc.Paths["/test/items/{id}"]["post"]
+			So(op.OperationID, ShouldEqual, "POST /test/items/{id}")
+			So(op.Parameters, ShouldHaveLength, 1)
+		})
+		Convey("The OpenAPI document should require authentication", func() {
+			registry := newGroup("/")
+			route := Route{Method: http.MethodGet, Path: "/api/openapi.json"}
+			registry.controllers[route] = Registry.controllers[route]
+			srv := newServer()
+			srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+			registry.createRoutes(srv.Group("/"))
+			r := performRequest(srv, http.MethodGet, "/api/openapi.json")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestServeContent(t *testing.T) {
+	Convey("Testing binary contents", t, func() {
+		registry := newGroup("/")
+		var content *models.BinaryContent
+		registry.AddController(http.MethodGet, "/file", func(c *server.Context) {
+			serveContent(c, content, "file")
+		})
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		registry.createRoutes(srv.Group("/"))
+		Convey("Passive contents should be served inline in a sandbox", func() {
+			content = &models.BinaryContent{MimeType: "image/png", Checksum: "abc", Data: []byte("png")}
+			r := performRequest(srv, http.MethodGet, "/file")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(r.Header().Get("Content-Disposition"), ShouldStartWith, "inline")
+			So(r.Header().Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+			So(r.Header().Get("Content-Security-Policy"), ShouldContainSubstring, "sandbox")
+		})
+		Convey("Active contents should always be served as attachments", func() {
+			for _, mimeType := range []string{"text/html; charset=utf-8", "image/svg+xml"} {
+				content = &models.BinaryContent{MimeType: mimeType, Checksum: "abc", Data: []byte("<script>alert(1)</script>")}
+				r := performRequest(srv, http.MethodGet, "/file")
+				So(r.Code, ShouldEqual, http.StatusOK)
+				So(r.Header().Get("Content-Disposition"), ShouldStartWith, "attachment")
+			}
+		})
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	Convey("Testing metadata endpoints", t, func() {
+		registry := newGroup("/")
+		registry.groups["/metadata"] = Registry.GetGroup("/metadata")
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		var uid int64
+		srv.Use(func(ctx *gin.Context) {
+			if uid != 0 {
+				ctx.Set("uid", uid)
+			}
+		})
+		registry.createRoutes(srv.Group("/"))
+		Convey("Metadata should require authentication", func() {
+			So(performRequest(srv, http.MethodGet, "/metadata/menus").Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Menus should be filtered for the current user", func() {
+			menus.Registry.Add(&menus.Menu{ID: "ctl_menu_public", Name: "Public", Active: true, HasAction: true})
+			menus.Registry.Add(&menus.Menu{ID: "ctl_menu_admin", Name: "Admin", Active: true, HasAction: true,
+				Groups: []string{security.GroupAdminID}})
+			var data []MenuData
+			uid = 2
+			r := performRequest(srv, http.MethodGet, "/metadata/menus")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(json.Unmarshal(r.Body.Bytes(), &data), ShouldBeNil)
+			So(data, ShouldHaveLength, 1)
+			So(data[0].ID, ShouldEqual, "ctl_menu_public")
+			uid = security.SuperUserID
+			r = performRequest(srv, http.MethodGet, "/metadata/menus")
+			So(json.Unmarshal(r.Body.Bytes(), &data), ShouldBeNil)
+			So(data, ShouldHaveLength, 2)
+		})
+		Convey("Client actions should only be listed and loaded by allowed users", func() {
+			actions.RegisterClientAction("ctl_action_public", "Public", "ctl_public", nil)
+			actions.RegisterClientAction("ctl_action_admin", "Admin", "ctl_admin", nil, security.GroupAdmin)
+			listed := func() []string {
+				var data []actions.Action
+				r := performRequest(srv, http.MethodGet, "/metadata/client_actions")
+				So(r.Code, ShouldEqual, http.StatusOK)
+				So(json.Unmarshal(r.Body.Bytes(), &data), ShouldBeNil)
+				var ids []string
+				for _, action := range data {
+					if strings.HasPrefix(action.ID, "ctl_action_") {
+						ids = append(ids, action.ID)
+					}
+				}
+				return ids
+			}
+			uid = 2
+			So(listed(), ShouldResemble, []string{"ctl_action_public"})
+			So(performRequest(srv, http.MethodGet, "/metadata/actions/ctl_action_admin").Code, ShouldEqual, http.StatusForbidden)
+			So(performRequest(srv, http.MethodGet, "/metadata/actions/ctl_unknown").Code, ShouldEqual, http.StatusNotFound)
+			uid = security.SuperUserID
+			So(listed(), ShouldResemble, []string{"ctl_action_admin", "ctl_action_public"})
+		})
+		Convey("Metadata should be revalidated with its ETag", func() {
+			uid = 2
+			r := performRequest(srv, http.MethodGet, "/metadata/session_info")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			var info SessionInfo
+			So(json.Unmarshal(r.Body.Bytes(), &info), ShouldBeNil)
+			So(info.UID, ShouldEqual, 2)
+			So(info.IsAdmin, ShouldBeFalse)
+			etag := r.Header().Get("ETag")
+			So(etag, ShouldNotBeEmpty)
+			req, _ := http.NewRequest(http.MethodGet, "/metadata/session_info", nil)
+			req.Header.Set("If-None-Match", etag)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			So(w.Body.Len(), ShouldEqual, 0)
+			Convey("The ETag should change with the user", func() {
+				uid = security.SuperUserID
+				w := httptest.NewRecorder()
+				srv.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+func TestBusEvents(t *testing.T) {
+	Convey("Testing bus server-sent events", t, func() {
+		Convey("Messages should be written as events with their ID", func() {
+			var buf bytes.Buffer
+			msg := bus.Message{ID: 42, Channel: "public:news", Message: json.RawMessage(`{"a":1}`)}
+			So(writeBusEvent(&buf, msg), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "id: 42\ndata: {\"id\":42,\"channel\":\"public:news\",\"message\":{\"a\":1}}\n\n")
+		})
+		Convey("Events endpoint should require authentication", func() {
+			registry := newGroup("/")
+			registry.groups["/bus"] = Registry.GetGroup("/bus")
+			srv := newServer()
+			srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+			registry.createRoutes(srv.Group("/"))
+			r := performRequest(srv, http.MethodGet, "/bus/events?channels=public:news")
+			So(r.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestHealth(t *testing.T) {
+	Convey("Testing health and readiness endpoints", t, func() {
+		registry := newGroup("/")
+		registry.AddController(http.MethodGet, "/healthz", Healthz)
+		registry.AddController(http.MethodGet, "/readyz", Readyz)
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		registry.createRoutes(srv.Group("/"))
+		Convey("Healthz should answer as soon as the process is up", func() {
+			r := performRequest(srv, http.MethodGet, "/healthz")
+			So(r.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("Readyz should fail until the application is ready", func() {
+			r := performRequest(srv, http.MethodGet, "/readyz?verbose")
+			So(r.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(r.Body.String(), ShouldEqual, `{"status":"unavailable"}`)
+		})
+		Convey("Readyz should succeed when all checks pass", func() {
+			checks := server.ReadinessChecks()
+			defer func() {
+				for name, check := range checks {
+					server.RegisterReadinessCheck(name, check)
+				}
+			}()
+			for _, name := range []string{"database", "bootstrap", "modules"} {
+				server.RegisterReadinessCheck(name, func() error { return nil })
+			}
+			r := performRequest(srv, http.MethodGet, "/readyz")
+			So(r.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestVersionInfo(t *testing.T) {
+	Convey("Testing the version info endpoint", t, func() {
+		registry := newGroup("/")
+		registry.AddController(http.MethodPost, "/version_info", VersionInfo)
+		srv := newServer()
+		registry.createRoutes(srv.Group("/"))
+		req, _ := http.NewRequest(http.MethodPost, "/version_info", bytes.NewBufferString(`{"jsonrpc":"2.0","id":7,"method":"call","params":{}}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		var resp struct {
+			ID     int64            `json:"id"`
+			Result server.BuildInfo `json:"result"`
+		}
+		So(json.Unmarshal(w.Body.Bytes(), &resp), ShouldBeNil)
+		So(resp.ID, ShouldEqual, 7)
+		So(resp.Result.Version, ShouldEqual, server.Version)
+		So(resp.Result.GoVersion, ShouldStartWith, "go")
+		So(resp.Result.Modules, ShouldHaveLength, len(server.Modules))
+	})
+}
+
+func TestTranslationCatalog(t *testing.T) {
+	Convey("Testing translation catalogs", t, func() {
+		i18n.Langs = []string{"fr_FR", "de_DE"}
+		registry := newGroup("/")
+		registry.AddController(http.MethodGet, "/i18n/catalog/:lang", TranslationCatalog)
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		registry.createRoutes(srv.Group("/"))
+		Convey("Catalogs of loaded languages should be served with an ETag", func() {
+			r := performRequest(srv, http.MethodGet, "/i18n/catalog/fr_FR")
+			So(r.Code, ShouldEqual, http.StatusOK)
+			So(r.Header().Get("Content-Type"), ShouldStartWith, "application/json")
+			So(r.Header().Get("ETag"), ShouldNotBeEmpty)
+			var catalog i18n.Catalog
+			So(json.Unmarshal(r.Body.Bytes(), &catalog), ShouldBeNil)
+			So(catalog.Lang, ShouldEqual, "fr_FR")
+			req, _ := http.NewRequest(http.MethodGet, "/i18n/catalog/fr_FR", nil)
+			req.Header.Set("If-None-Match", r.Header().Get("ETag"))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			So(w.Body.Len(), ShouldEqual, 0)
+		})
+		Convey("The current language should be that of the request", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/i18n/catalog/current", nil)
+			req.Header.Set("Accept-Language", "de-CH, fr;q=0.8")
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			var catalog i18n.Catalog
+			So(json.Unmarshal(w.Body.Bytes(), &catalog), ShouldBeNil)
+			So(catalog.Lang, ShouldEqual, "de_DE")
+		})
+		Convey("Catalogs of other languages should not be found", func() {
+			So(performRequest(srv, http.MethodGet, "/i18n/catalog/es_ES").Code, ShouldEqual, http.StatusNotFound)
+			So(performRequest(srv, http.MethodGet, "/i18n/catalog/current").Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestContent(t *testing.T) {
+	Convey("Testing the content endpoint", t, func() {
+		registry := newGroup("/")
+		registry.AddController(http.MethodGet, "/content/*path", Content)
+		srv := newServer()
+		srv.Use(sessions.Sessions("test-session", sessions.NewCookieStore([]byte("secret"))))
+		registry.createRoutes(srv.Group("/"))
+		Convey("Invalid content paths should not be found", func() {
+			r := performRequest(srv, http.MethodGet, "/content/a/b/c/d/e")
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+			r = performRequest(srv, http.MethodGet, "/content/a/b/c/d/e/f")
+			So(r.Code, ShouldEqual, http.StatusNotFound)
+		})
 	})
 }
@@ -0,0 +1,50 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// Healthz answers as long as the server process is up.
+// It is meant for liveness probes.
+func Healthz(c *server.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz answers with a 200 status if the application is ready to serve
+// requests and with a 503 status otherwise. It is meant for load balancers
+// and readiness probes.
+//
+// If the 'verbose' query parameter is set and the user is an administrator,
+// the result of each readiness check and the loaded modules are returned.
+func Readyz(c *server.Context) {
+	results := server.CheckReadiness()
+	status, code := "ok", http.StatusOK
+	checks := make(map[string]string, len(results))
+	for name, err := range results {
+		checks[name] = "ok"
+		if err != nil {
+			checks[name] = err.Error()
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	res := gin.H{"status": status}
+	if _, verbose := c.GetQuery("verbose"); verbose && security.Registry.HasMembership(c.UID(), security.GroupAdmin) {
+		res["checks"] = checks
+		res["modules"] = server.Modules.Names()
+	}
+	c.JSON(code, res)
+}
+
+// VersionInfo returns the version and the commit of the framework, the Go
+// version and the versions of the modules compiled in the server, as the
+// result of a JSON-RPC call. It is meant for support diagnostics.
+func VersionInfo(c *server.Context) {
+	c.RPC(http.StatusOK, server.GetBuildInfo())
+}
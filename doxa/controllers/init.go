@@ -4,6 +4,8 @@
 package controllers
 
 import (
+	"net/http"
+
 	"github.com/labneco/doxa/doxa/server"
 	"github.com/labneco/doxa/doxa/tools/logging"
 )
@@ -19,4 +21,47 @@ func BootStrap() {
 func init() {
 	log = logging.GetLogger("controllers")
 	Registry = newGroup("/")
+	Registry.AddMiddleWare(server.RPCRateLimit)
+	auth := Registry.AddGroup("/auth")
+	auth.AddMiddleWare(server.LoginRateLimit)
+	auth.AddController(http.MethodPost, "/token", IssueToken)
+	auth.AddController(http.MethodPost, "/totp/verify", VerifyTOTP)
+	auth.AddControllerWithAuth(http.MethodPost, "/totp/enroll", server.AuthUser, EnrollTOTP)
+	auth.AddControllerWithAuth(http.MethodPost, "/totp/confirm", server.AuthUser, ConfirmTOTP)
+	auth.AddControllerWithAuth(http.MethodPost, "/totp/disable", server.AuthUser, DisableTOTP)
+	auth.AddControllerWithAuth(http.MethodPost, "/unlock", server.AuthUser, UnlockLogin)
+	Registry.AddControllerWithAuth(http.MethodGet, "/healthz", server.AuthNone, Healthz)
+	Registry.AddController(http.MethodGet, "/readyz", Readyz)
+	Registry.AddControllerWithAuth(http.MethodPost, "/version_info", server.AuthUser, VersionInfo)
+	Registry.AddControllerWithAuth(http.MethodGet, "/api/openapi.json", server.AuthUser, OpenAPI)
+	Registry.AddController(http.MethodGet, server.AssetsPath+"/*file", server.ServeAsset)
+	Registry.AddController(http.MethodGet, "/i18n/catalog/:lang", TranslationCatalog)
+	busGroup := Registry.AddGroup("/bus")
+	busGroup.SetAuth(server.AuthUser)
+	busGroup.SetTimeout(server.NoTimeout)
+	busGroup.AddController(http.MethodGet, "/websocket", BusWebSocket)
+	busGroup.AddController(http.MethodGet, "/events", BusEvents)
+	busGroup.AddController(http.MethodPost, "/poll", BusPoll)
+	busGroup.AddController(http.MethodPost, "/presence", BusPresence)
+	binary := Registry.AddGroup("/binary")
+	binary.SetAuth(server.AuthUser)
+	binary.SetTimeout(server.NoTimeout)
+	binary.AddMiddleWare(server.MaxRequestSize(0))
+	binary.AddController(http.MethodPost, "/upload", UploadBinary)
+	Registry.AddController(http.MethodGet, "/content/*path", Content)
+	Registry.SetControllerTimeout(http.MethodGet, "/content/*path", server.NoTimeout)
+	report := Registry.AddGroup("/report")
+	report.SetAuth(server.AuthUser)
+	report.AddController(http.MethodGet, "/:report/:ids", DownloadReport)
+	metadata := Registry.AddGroup("/metadata")
+	metadata.SetAuth(server.AuthUser)
+	metadata.AddMiddleWare(server.Revalidate)
+	metadata.AddController(http.MethodGet, "/menus", LoadMenus)
+	metadata.AddController(http.MethodGet, "/views/:model/:type", LoadView)
+	metadata.AddController(http.MethodGet, "/session_info", LoadSessionInfo)
+	metadata.AddController(http.MethodGet, "/actions/:id", LoadAction)
+	metadata.AddController(http.MethodGet, "/client_actions", ClientActions)
+	share := Registry.AddGroup("/share")
+	share.AddController(http.MethodGet, "/:token", ViewShared)
+	share.AddController(http.MethodPost, "/:token/comment", CommentShared)
 }
@@ -0,0 +1,193 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/menus"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+	"github.com/labneco/doxa/doxa/views"
+)
+
+// A MenuData is a menu as served to the web client
+type MenuData struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Sequence     uint8             `json:"sequence"`
+	ActionID     string            `json:"action_id,omitempty"`
+	WebIcon      string            `json:"web_icon,omitempty"`
+	WebIconClass string            `json:"web_icon_class,omitempty"`
+	Attrs        map[string]string `json:"attrs,omitempty"`
+	Children     []MenuData        `json:"children"`
+}
+
+// newMenusData returns the data of the given menus and their
+// children with their names translated in the given language.
+func newMenusData(collection *menus.Collection, lang string) []MenuData {
+	res := []MenuData{}
+	if collection == nil {
+		return res
+	}
+	for _, menu := range collection.Menus {
+		res = append(res, MenuData{
+			ID:           menu.ID,
+			Name:         menu.TranslatedName(lang),
+			Sequence:     menu.Sequence,
+			ActionID:     menu.ActionID,
+			WebIcon:      menu.WebIcon,
+			WebIconClass: menu.WebIconClass,
+			Attrs:        menu.Attrs,
+			Children:     newMenusData(menu.Children, lang),
+		})
+	}
+	return res
+}
+
+// LoadMenus serves the tree of the menus that the current user is allowed
+// to see (see menus.Collection.ForUser), in the language of the request.
+func LoadMenus(c *server.Context) {
+	c.JSON(http.StatusOK, newMenusData(menus.Registry.ForUser(c.UID()), c.Lang()))
+}
+
+// ClientActions serves the client actions that the current user is allowed
+// to launch, sorted by id, with their names translated in the language of
+// the request.
+func ClientActions(c *server.Context) {
+	res := []*actions.Action{}
+	for _, action := range actions.Registry.GetAllowed(c.UID()) {
+		if action.Type != actions.ActionClient {
+			continue
+		}
+		translated := *action
+		translated.Name = action.TranslatedName(c.Lang())
+		res = append(res, &translated)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+// LoadAction serves the action with the given id evaluated for the current
+// user (see actions.Action.Evaluate) and the records given by the
+// 'active_model' and 'active_ids' query parameters, the latter being a comma
+// separated list of ids. Users who are not allowed to launch the action get
+// a 403 Forbidden response.
+func LoadAction(c *server.Context) {
+	action := actions.Registry.GetById(c.Param("id"))
+	if action == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !action.IsAllowed(c.UID()) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	var activeIDs []int64
+	for _, id := range strings.Split(c.Query("active_ids"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		activeID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		activeIDs = append(activeIDs, activeID)
+	}
+	var (
+		res     *actions.Action
+		evalErr error
+	)
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		res, evalErr = action.Evaluate(env, c.Query("active_model"), activeIDs...)
+	})
+	if err == nil {
+		err = evalErr
+	}
+	if err != nil {
+		log.Warn("Unable to evaluate action", "action", action.ID, "error", err)
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	res.Name = action.TranslatedName(c.Lang())
+	c.JSON(http.StatusOK, res)
+}
+
+// A ViewData is a view as served to the web client
+type ViewData struct {
+	ID       string              `json:"id"`
+	Name     string              `json:"name"`
+	Model    string              `json:"model"`
+	Type     views.ViewType      `json:"type"`
+	Arch     string              `json:"arch"`
+	Fields   []string            `json:"fields"`
+	Kanban   *views.KanbanInfo   `json:"kanban,omitempty"`
+	Calendar *views.CalendarInfo `json:"calendar,omitempty"`
+	Gantt    *views.GanttInfo    `json:"gantt,omitempty"`
+	Search   *views.SearchInfo   `json:"search,omitempty"`
+}
+
+// LoadView serves the view of the type and model given in the route that
+// the current user should see (see views.Collection.GetViewForUser), with
+// its arch translated in the language of the request.
+func LoadView(c *server.Context) {
+	modelName := c.Param("model")
+	if _, ok := models.Registry.Get(modelName); !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	view := views.Registry.GetViewForUser(modelName, views.ViewType(c.Param("type")), c.UID())
+	res := ViewData{
+		ID:       view.ID,
+		Name:     view.Name,
+		Model:    view.Model,
+		Type:     view.Type,
+		Arch:     xmlutils.ElementToXML(view.Arch(c.Lang())),
+		Fields:   []string{},
+		Kanban:   view.Kanban,
+		Calendar: view.Calendar,
+		Gantt:    view.Gantt,
+		Search:   view.Search,
+	}
+	for _, field := range view.Fields {
+		res.Fields = append(res.Fields, field.String())
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// A SessionInfo gives the current user and the settings of the session
+type SessionInfo struct {
+	UID     int64    `json:"uid"`
+	Lang    string   `json:"lang"`
+	Groups  []string `json:"groups"`
+	IsAdmin bool     `json:"is_admin"`
+	Version string   `json:"server_version"`
+}
+
+// LoadSessionInfo serves the SessionInfo of the current user. Groups are the
+// ids of the groups of the user, including inherited groups, sorted by id.
+func LoadSessionInfo(c *server.Context) {
+	uid := c.UID()
+	res := SessionInfo{
+		UID:     uid,
+		Lang:    c.Lang(),
+		Groups:  []string{},
+		IsAdmin: security.Registry.HasMembership(uid, security.GroupAdmin),
+		Version: server.Version,
+	}
+	for group := range security.Registry.UserGroups(uid) {
+		res.Groups = append(res.Groups, group.ID)
+	}
+	sort.Strings(res.Groups)
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,262 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// RPCPath is the path of the JSON-RPC endpoint through which model methods
+// are called, as registered by the web client module. Model methods are
+// documented in the OpenAPI document as '<RPCPath>/<Model>/<Method>'.
+var RPCPath = "/web/dataset/call_kw"
+
+// An OpenAPIDocument is an OpenAPI 3 specification document
+type OpenAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       OpenAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                      `json:"components"`
+}
+
+// OpenAPIInfo is the metadata of an OpenAPIDocument
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// An OpenAPIOperation describes a single API operation on a path
+type OpenAPIOperation struct {
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+// An OpenAPIParameter describes a path parameter of an operation
+type OpenAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   OpenAPISchema `json:"schema"`
+}
+
+// An OpenAPIRequestBody describes the body of a request
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// An OpenAPIResponse describes a response of an operation
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// An OpenAPIMediaType gives the schema of a request or response body
+type OpenAPIMediaType struct {
+	Schema OpenAPISchema `json:"schema"`
+}
+
+// An OpenAPISchema is a JSON schema
+type OpenAPISchema struct {
+	Ref         string                   `json:"$ref,omitempty"`
+	Type        string                   `json:"type,omitempty"`
+	Format      string                   `json:"format,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Items       *OpenAPISchema           `json:"items,omitempty"`
+	Properties  map[string]OpenAPISchema `json:"properties,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+	ReadOnly    bool                     `json:"readOnly,omitempty"`
+}
+
+// OpenAPIComponents holds the reusable schemas of an OpenAPIDocument
+type OpenAPIComponents struct {
+	Schemas map[string]OpenAPISchema `json:"schemas"`
+}
+
+// fieldSchemas maps field types to their JSON schema
+var fieldSchemas = map[fieldtype.Type]OpenAPISchema{
+	fieldtype.Binary:    {Type: "string", Format: "byte"},
+	fieldtype.Boolean:   {Type: "boolean"},
+	fieldtype.Char:      {Type: "string"},
+	fieldtype.Date:      {Type: "string", Format: "date"},
+	fieldtype.DateTime:  {Type: "string", Format: "date-time"},
+	fieldtype.Float:     {Type: "number"},
+	fieldtype.HTML:      {Type: "string"},
+	fieldtype.Integer:   {Type: "integer"},
+	fieldtype.Many2Many: {Type: "array", Items: &OpenAPISchema{Type: "integer"}},
+	fieldtype.Many2One:  {Type: "integer"},
+	fieldtype.One2Many:  {Type: "array", Items: &OpenAPISchema{Type: "integer"}},
+	fieldtype.One2One:   {Type: "integer"},
+	fieldtype.Rev2One:   {Type: "integer"},
+	fieldtype.Reference: {Type: "string"},
+	fieldtype.Selection: {Type: "string"},
+	fieldtype.Text:      {Type: "string"},
+}
+
+// GenerateOpenAPI returns an OpenAPI document describing all the
+// controllers of the Registry and the methods and fields of the models
+// that the user with the given uid can access. Use security.SuperUserID
+// to describe the whole API.
+//
+// It must be called after the models have been bootstrapped.
+func GenerateOpenAPI(title, version string, uid int64) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.0",
+		Info: OpenAPIInfo{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]map[string]OpenAPIOperation),
+		Components: OpenAPIComponents{
+			Schemas: make(map[string]OpenAPISchema),
+		},
+	}
+	Registry.addToOpenAPI(doc, "/")
+	addModelsToOpenAPI(doc, uid)
+	return doc
+}
+
+// addToOpenAPI adds the controllers of this group and its sub groups to doc.
+// basePath is the full path of the parent group.
+func (g *Group) addToOpenAPI(doc *OpenAPIDocument, basePath string) {
+	groupPath := path.Join(basePath, g.relativePath)
+	for _, grp := range g.groups {
+		grp.addToOpenAPI(doc, groupPath)
+	}
+	for route, ctlr := range g.controllers {
+		apiPath, params := openAPIPath(path.Join(groupPath, route.Path))
+		op := OpenAPIOperation{
+			Summary:     handlerName(ctlr.handlers[len(ctlr.handlers)-1].fnct),
+			OperationID: fmt.Sprintf("%s %s", route.Method, apiPath),
+			Parameters:  params,
+			Responses: map[string]OpenAPIResponse{
+				"200": {Description: "Success"},
+			},
+		}
+		if _, ok := doc.Paths[apiPath]; !ok {
+			doc.Paths[apiPath] = make(map[string]OpenAPIOperation)
+		}
+		doc.Paths[apiPath][strings.ToLower(route.Method)] = op
+	}
+}
+
+// openAPIPath converts the given gin route path into an OpenAPI
+// path ('/users/:id' becomes '/users/{id}') and returns its parameters.
+func openAPIPath(ginPath string) (string, []OpenAPIParameter) {
+	var params []OpenAPIParameter
+	parts := strings.Split(ginPath, "/")
+	for i, part := range parts {
+		if len(part) < 2 || (part[0] != ':' && part[0] != '*') {
+			continue
+		}
+		parts[i] = fmt.Sprintf("{%s}", part[1:])
+		params = append(params, OpenAPIParameter{
+			Name:     part[1:],
+			In:       "path",
+			Required: true,
+			Schema:   OpenAPISchema{Type: "string"},
+		})
+	}
+	return strings.Join(parts, "/"), params
+}
+
+// addModelsToOpenAPI adds to doc a JSON-RPC operation for each model
+// method that the user with the given uid can execute, and a schema with
+// the readable fields of each model that has at least one such method.
+func addModelsToOpenAPI(doc *OpenAPIDocument, uid int64) {
+	for _, modelName := range models.Registry.Names() {
+		model := models.Registry.MustGet(modelName)
+		var methNames []string
+		for _, methName := range model.Methods().Names() {
+			if model.Methods().MustGet(methName).AllowedForUser(uid) {
+				methNames = append(methNames, methName)
+			}
+		}
+		if len(methNames) == 0 {
+			continue
+		}
+		schema := OpenAPISchema{
+			Type:       "object",
+			Properties: make(map[string]OpenAPISchema),
+		}
+		for jsonName, fInfo := range model.FieldsGet() {
+			if !model.FieldReadable(uid, models.FieldName(jsonName)) {
+				continue
+			}
+			fSchema := fieldSchemas[fInfo.Type]
+			fSchema.Description = fInfo.Help
+			fSchema.ReadOnly = fInfo.ReadOnly
+			schema.Properties[jsonName] = fSchema
+			if fInfo.Required {
+				schema.Required = append(schema.Required, jsonName)
+			}
+		}
+		sort.Strings(schema.Required)
+		doc.Components.Schemas[modelName] = schema
+		for _, methName := range methNames {
+			meth := model.Methods().MustGet(methName)
+			apiPath := fmt.Sprintf("%s/%s/%s", RPCPath, modelName, methName)
+			doc.Paths[apiPath] = map[string]OpenAPIOperation{
+				"post": {
+					Summary:     methName,
+					Description: meth.Doc(),
+					OperationID: fmt.Sprintf("%s.%s", modelName, methName),
+					Tags:        []string{modelName},
+					RequestBody: &OpenAPIRequestBody{
+						Required: true,
+						Content: map[string]OpenAPIMediaType{
+							"application/json": {Schema: OpenAPISchema{Ref: "#/components/schemas/RequestRPC"}},
+						},
+					},
+					Responses: map[string]OpenAPIResponse{
+						"200": {
+							Description: "JSON-RPC response",
+							Content: map[string]OpenAPIMediaType{
+								"application/json": {Schema: OpenAPISchema{Ref: "#/components/schemas/ResponseRPC"}},
+							},
+						},
+					},
+				},
+			}
+		}
+	}
+	doc.Components.Schemas["RequestRPC"] = OpenAPISchema{
+		Type: "object",
+		Properties: map[string]OpenAPISchema{
+			"jsonrpc": {Type: "string"},
+			"id":      {Type: "integer"},
+			"method":  {Type: "string"},
+			"params":  {Type: "object"},
+		},
+		Required: []string{"jsonrpc", "method", "params"},
+	}
+	doc.Components.Schemas["ResponseRPC"] = OpenAPISchema{
+		Type: "object",
+		Properties: map[string]OpenAPISchema{
+			"jsonrpc": {Type: "string"},
+			"id":      {Type: "integer"},
+			"result":  {},
+			"error":   {Type: "object"},
+		},
+	}
+}
+
+// OpenAPI serves the OpenAPI document of the application, restricted
+// to the models methods and fields that the current user can access.
+func OpenAPI(c *server.Context) {
+	c.JSON(http.StatusOK, GenerateOpenAPI("Doxa API", "0.1", c.UID()))
+}
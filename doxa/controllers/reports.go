@@ -0,0 +1,63 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/reports"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// DownloadReport serves the report given by the 'report' path parameter for
+// the records whose ids are given by the comma separated 'ids' path parameter.
+// If the 'download' query parameter is set, the report is sent as an attachment.
+func DownloadReport(c *server.Context) {
+	id := c.Param("report")
+	var ids []int64
+	for _, idStr := range strings.Split(c.Param("ids"), ",") {
+		recID, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, recID)
+	}
+	var (
+		data        []byte
+		contentType string
+		rErr        error
+	)
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		data, contentType, rErr = server.RenderReport(c.Request.Context(), env, id, ids)
+	})
+	if err == nil {
+		err = rErr
+	}
+	switch {
+	case err == server.ErrReportNotFound, err == server.ErrReportRecordsNotFound:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Warn("Unable to render report", "report", id, "ids", ids, "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	report := reports.Registry.GetByID(id)
+	ext := ".pdf"
+	if report.Type == reports.ReportHTML {
+		ext = ".html"
+	}
+	disposition := "inline"
+	if c.Query("download") != "" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": report.FileName + ext}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, contentType, data)
+}
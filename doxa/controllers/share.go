@@ -0,0 +1,65 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// commentRequest is the body expected by the shared record comment endpoint
+type commentRequest struct {
+	Author  string `json:"author"`
+	Comment string `json:"comment" binding:"required"`
+}
+
+// ViewShared returns the fields given in the comma separated 'fields' query
+// parameter of the record shared by the token of the route, or all the fields
+// shared by the token if no fields are given.
+func ViewShared(c *server.Context) {
+	claims, err := c.ShareClaims()
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	var fields []string
+	if f := c.Query("fields"); f != "" {
+		fields = strings.Split(f, ",")
+	}
+	var res models.FieldMap
+	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		res, err = models.ReadShared(env, claims, fields)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// CommentShared posts a comment on the record shared by the token of the route,
+// if the token grants the comment access.
+func CommentShared(c *server.Context) {
+	claims, err := c.ShareClaims()
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	var req commentRequest
+	if err = c.BindJSON(&req); err != nil {
+		return
+	}
+	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		err = models.CommentShared(env, claims, req.Author, req.Comment)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusForbidden, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/viper"
+)
+
+// totpRequest is the body expected by the two-factor authentication endpoints
+type totpRequest struct {
+	Code    string `json:"code"`
+	Account string `json:"account"`
+}
+
+// VerifyTOTP completes the login of a user who has enabled two-factor
+// authentication with the code given in the JSON body of the request.
+func VerifyTOTP(c *server.Context) {
+	var req totpRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if err := c.VerifyTOTP(req.Code); err != nil {
+		if c.IsAborted() {
+			return
+		}
+		status := http.StatusUnauthorized
+		if _, locked := err.(security.AccountLockedError); locked {
+			status = http.StatusTooManyRequests
+		}
+		c.AbortWithError(status, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"uid": c.UID()})
+}
+
+// EnrollTOTP starts two-factor authentication enrollment for the current
+// user and returns the secret and the provisioning URI to show as QR code.
+//
+// The account given in the request body is used as label in the
+// authenticator application. If the user has already enabled two-factor
+// authentication, a valid code must be given to enroll again.
+func EnrollTOTP(c *server.Context) {
+	var req totpRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	uid := c.UID()
+	if req.Account == "" {
+		req.Account = fmt.Sprintf("user-%d", uid)
+	}
+	issuer := viper.GetString("Server.TOTP.Issuer")
+	if issuer == "" {
+		issuer = "Doxa"
+	}
+	var (
+		secret, uri string
+		enrollErr   error
+	)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		secret, uri, enrollErr = models.EnrollTOTP(env, uid, issuer, req.Account, req.Code)
+	})
+	if enrollErr != nil {
+		c.AbortWithError(http.StatusUnauthorized, enrollErr)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": uri})
+}
+
+// ConfirmTOTP enables two-factor authentication for the current user if
+// the code given in the request body is valid and returns the recovery codes.
+func ConfirmTOTP(c *server.Context) {
+	var req totpRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	var codes []string
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		codes = models.ConfirmTOTP(env, c.UID(), req.Code)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if codes == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTOTP disables two-factor authentication for the current user.
+// A valid code must be given in the request body.
+func DisableTOTP(c *server.Context) {
+	var req totpRequest
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	uid := c.UID()
+	if !models.CheckTOTP(uid, req.Code) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		models.DisableTOTP(env, uid)
+	})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
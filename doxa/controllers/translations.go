@@ -0,0 +1,48 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/server"
+)
+
+// currentLang is the language parameter of TranslationCatalog
+// that stands for the language of the request.
+const currentLang = "current"
+
+// TranslationCatalog serves the JSON catalog of the code translations of the
+// language given in the route, merged across all loaded modules, so that the
+// web client can translate its own strings (see i18n.Catalog). The language
+// 'current' stands for the language of the request.
+//
+// Catalogs are served with an ETag so that clients revalidate them cheaply.
+// Languages that are not loaded are not found.
+func TranslationCatalog(c *server.Context) {
+	lang := c.Param("lang")
+	if lang == currentLang {
+		lang = c.Lang()
+	}
+	if !isLoadedLang(lang) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	data, etag := i18n.Registry.CatalogJSON(lang)
+	if c.NotModified(etag, server.BootstrapTime()) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// isLoadedLang returns true if the given lang is one of the loaded languages
+func isLoadedLang(lang string) bool {
+	for _, l := range i18n.Langs {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
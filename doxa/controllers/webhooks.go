@@ -0,0 +1,105 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/spf13/viper"
+)
+
+// An IncomingWebhook binds an endpoint receiving webhook requests from an
+// external service to a model method.
+//
+// The method is called on an empty recordset of the model with a
+// *models.WebhookRequest argument, in a new environment of the technical
+// user of the webhook. If it returns a non nil value, it is sent as JSON in
+// the response.
+type IncomingWebhook struct {
+	Model  string
+	Method string
+	// SecretKey is the configuration key of the secret with which the
+	// body of the requests must be signed, as computed by security.SignPayload.
+	SecretKey string
+	// SignatureHeader is the header holding the signature.
+	// It defaults to models.WebhookSignatureHeader.
+	SignatureHeader string
+	// Verify, if set, replaces the signature check, for services that sign
+	// their requests differently. It must return true if the request is authentic.
+	Verify func(c *server.Context, body []byte) bool
+	// UID is the id of the user under which the method is called.
+	// It defaults to the Server.Webhooks.UID configuration key.
+	UID int64
+}
+
+// AddWebhook registers the given incoming webhook as a POST controller at
+// the given relative path. The route requires no authentication, since
+// requests are authenticated by their signature.
+func (g *Group) AddWebhook(relativePath string, webhook IncomingWebhook) {
+	if webhook.Model == "" || webhook.Method == "" {
+		log.Panic("Incoming webhooks must have a model and a method", "path", relativePath)
+	}
+	if webhook.SecretKey == "" && webhook.Verify == nil {
+		log.Panic("Incoming webhooks must have a secret key or a verify function", "path", relativePath)
+	}
+	if webhook.SignatureHeader == "" {
+		webhook.SignatureHeader = models.WebhookSignatureHeader
+	}
+	g.AddControllerWithAuth(http.MethodPost, relativePath, server.AuthNone, webhook.handle)
+}
+
+// handle is the controller of the incoming webhook
+func (w IncomingWebhook) handle(c *server.Context) {
+	logCtx := log.New("model", w.Model, "method", w.Method, "path", c.Request.URL.Path)
+	var (
+		body []byte
+		err  error
+	)
+	if w.Verify != nil {
+		body, err = c.Body()
+		if err == nil && !w.Verify(c, body) {
+			err = server.ErrInvalidSignature
+		}
+	} else {
+		body, err = c.SignedBody([]byte(viper.GetString(w.SecretKey)), w.SignatureHeader)
+	}
+	switch {
+	case err == server.ErrBodyTooLarge:
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		logCtx.Warn("Rejected incoming webhook", "error", err)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	uid := w.UID
+	if uid == 0 {
+		uid = server.WebhookUID()
+	}
+	if uid == 0 {
+		logCtx.Warn("No technical user configured for incoming webhooks")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	req := &models.WebhookRequest{
+		Header: c.Request.Header,
+		Query:  c.Request.URL.Query(),
+		Body:   body,
+	}
+	var res interface{}
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		res = env.Pool(w.Model).Call(w.Method, req)
+	})
+	switch {
+	case err != nil:
+		logCtx.Warn("Error while processing incoming webhook", "uid", uid, "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	case res != nil:
+		c.JSON(http.StatusOK, res)
+	default:
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package i18n
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// CatalogContextSeparator separates the context from the source string in
+// the keys of the messages of a Catalog, as in the compiled files of gettext.
+const CatalogContextSeparator = "\x04"
+
+// A Catalog holds the translations of the code strings of a language, merged
+// across all loaded modules. It is sent as JSON to the web client so that it
+// can translate its own strings.
+//
+// Messages and Plurals are indexed by source string, prefixed by their
+// context and CatalogContextSeparator for messages given with a context.
+type Catalog struct {
+	Lang        string              `json:"lang"`
+	PluralForms string              `json:"plural_forms"`
+	Params      LangParameters      `json:"lang_params"`
+	Messages    map[string]string   `json:"messages"`
+	Plurals     map[string][]string `json:"plurals"`
+}
+
+// A cachedCatalog is the JSON encoded catalog of a language with its ETag
+// and the version of the language parameters it has been computed with.
+type cachedCatalog struct {
+	data          []byte
+	etag          string
+	paramsVersion int
+}
+
+// Catalog returns the Catalog of the given lang. Messages that are not
+// translated in lang are taken from its fallback languages (see
+// FallbackLangs). Untranslated messages are not included.
+func (tc *TranslationsCollection) Catalog(lang string) *Catalog {
+	pf := tc.PluralForms(lang)
+	if pf == nil {
+		pf = defaultPluralForms
+	}
+	res := Catalog{
+		Lang:        lang,
+		PluralForms: pf.String(),
+		Params:      GetLangParameters(lang),
+		Messages:    make(map[string]string),
+		Plurals:     make(map[string][]string),
+	}
+	// Most specific languages come last to override their fallbacks
+	langs := FallbackLangs(lang)
+	for i := len(langs) - 1; i >= 0; i-- {
+		for ref, msgStr := range tc.code {
+			if ref.lang != langs[i] || msgStr == "" {
+				continue
+			}
+			res.Messages[catalogKey(ref)] = msgStr
+		}
+		for ref, msgStrs := range tc.plural {
+			if ref.lang != langs[i] || len(msgStrs) == 0 {
+				continue
+			}
+			res.Plurals[catalogKey(ref)] = msgStrs
+		}
+	}
+	return &res
+}
+
+// CatalogJSON returns the JSON encoded Catalog of the given lang and its
+// ETag, which changes with its content. Catalogs are computed once and
+// cached until a new PO file is loaded or language parameters are set.
+func (tc *TranslationsCollection) CatalogJSON(lang string) (data []byte, etag string) {
+	tc.catalogsMutex.Lock()
+	defer tc.catalogsMutex.Unlock()
+	paramsVersion := getLangParametersVersion()
+	if cached, ok := tc.catalogs[lang]; ok && cached.paramsVersion == paramsVersion {
+		return cached.data, cached.etag
+	}
+	data, err := json.Marshal(tc.Catalog(lang))
+	if err != nil {
+		log.Panic("Unable to encode translation catalog", "lang", lang, "error", err)
+	}
+	hash := sha1.Sum(data)
+	etag = `"` + hex.EncodeToString(hash[:]) + `"`
+	tc.catalogs[lang] = cachedCatalog{data: data, etag: etag, paramsVersion: paramsVersion}
+	return data, etag
+}
+
+// resetCatalogs clears the cached catalogs of this TranslationsCollection
+func (tc *TranslationsCollection) resetCatalogs() {
+	tc.catalogsMutex.Lock()
+	defer tc.catalogsMutex.Unlock()
+	tc.catalogs = make(map[string]cachedCatalog)
+}
+
+// catalogKey returns the key of the message with the given ref in catalogs
+func catalogKey(ref codeRef) string {
+	if ref.context == "" {
+		return ref.source
+	}
+	return ref.context + CatalogContextSeparator + ref.source
+}
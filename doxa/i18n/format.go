@@ -0,0 +1,284 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// DefaultLangParameters are the parameters of languages for
+// which no parameters have been set, i.e. those of en_US.
+var DefaultLangParameters = LangParameters{
+	DateFormat:       "%m/%d/%Y",
+	Direction:        LangDirectionLTR,
+	ThousandsSep:     ",",
+	TimeFormat:       "%H:%M:%S",
+	DecimalPoint:     ".",
+	Grouping:         "[3,0]",
+	CurrencyPosition: CurrencyPositionBefore,
+}
+
+// MonetaryDigits is the number of digits after the decimal
+// point of the amounts formatted with FormatMonetary.
+const MonetaryDigits = 2
+
+var (
+	langParameters   = make(map[string]LangParameters)
+	langParametersMu sync.RWMutex
+	// langParametersVersion is incremented each time parameters are set
+	langParametersVersion int
+)
+
+// SetLangParameters sets the parameters of the given lang
+func SetLangParameters(lang string, params LangParameters) {
+	langParametersMu.Lock()
+	defer langParametersMu.Unlock()
+	langParameters[lang] = params
+	langParametersVersion++
+}
+
+// getLangParametersVersion returns the number of times
+// language parameters have been set.
+func getLangParametersVersion() int {
+	langParametersMu.RLock()
+	defer langParametersMu.RUnlock()
+	return langParametersVersion
+}
+
+// GetLangParameters returns the parameters of the given lang, or
+// DefaultLangParameters if no parameters have been set for lang.
+func GetLangParameters(lang string) LangParameters {
+	langParametersMu.RLock()
+	defer langParametersMu.RUnlock()
+	params, ok := langParameters[lang]
+	if !ok {
+		return DefaultLangParameters
+	}
+	return params
+}
+
+// FormatFloat returns the given value formatted with the given number of
+// digits after the decimal point, with the decimal point of the language and,
+// if grouping is true, the thousands separator and grouping of the language.
+func (lp LangParameters) FormatFloat(value float64, digits int, grouping bool) string {
+	str := strconv.FormatFloat(math.Abs(value), 'f', digits, 64)
+	intPart, decPart := str, ""
+	if idx := strings.IndexByte(str, '.'); idx >= 0 {
+		intPart, decPart = str[:idx], str[idx+1:]
+	}
+	if grouping {
+		intPart = lp.group(intPart)
+	}
+	res := intPart
+	if decPart != "" {
+		res += lp.decimalPoint() + decPart
+	}
+	if value < 0 && strings.Trim(str, "0.") != "" {
+		res = "-" + res
+	}
+	return res
+}
+
+// FormatInteger returns the given value formatted with, if grouping
+// is true, the thousands separator and grouping of the language.
+func (lp LangParameters) FormatInteger(value int64, grouping bool) string {
+	return lp.FormatFloat(float64(value), 0, grouping)
+}
+
+// FormatMonetary returns the given amount formatted as FormatFloat does with
+// grouping and the given digits, and the currency symbol placed before or
+// after it according to the CurrencyPosition of the language. The symbol is
+// separated from the amount by a non-breaking space and the minus sign of
+// negative amounts always comes first, e.g. "-$\u00a01,234.50" in en_US and
+// "-1\u00a0234,50\u00a0€" in fr_FR. If currencySymbol is empty, only the
+// formatted amount is returned.
+func (lp LangParameters) FormatMonetary(amount float64, currencySymbol string, digits int) string {
+	res := lp.FormatFloat(amount, digits, true)
+	if currencySymbol == "" {
+		return res
+	}
+	sign := ""
+	if strings.HasPrefix(res, "-") {
+		sign, res = "-", res[1:]
+	}
+	switch lp.CurrencyPosition {
+	case CurrencyPositionAfter:
+		return sign + res + "\u00a0" + currencySymbol
+	default:
+		return sign + currencySymbol + "\u00a0" + res
+	}
+}
+
+// FormatMonetary returns the given amount formatted with MonetaryDigits digits
+// and the given currency symbol with the parameters of the given lang. It is
+// meant to display amounts in reports, emails and exports alike.
+func FormatMonetary(amount float64, currencySymbol, lang string) string {
+	return GetLangParameters(lang).FormatMonetary(amount, currencySymbol, MonetaryDigits)
+}
+
+// ParseFloat parses the given number formatted with the
+// thousands separator and decimal point of the language.
+func (lp LangParameters) ParseFloat(str string) (float64, error) {
+	str = strings.TrimSpace(str)
+	if lp.ThousandsSep != "" {
+		str = strings.Replace(str, lp.ThousandsSep, "", -1)
+	}
+	str = strings.Replace(str, lp.decimalPoint(), ".", 1)
+	return strconv.ParseFloat(str, 64)
+}
+
+// FormatDate returns the given date formatted with the date format of the
+// language. It returns an empty string if the date is zero.
+func (lp LangParameters) FormatDate(date dates.Date) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(lp.DateLayout())
+}
+
+// FormatTime returns the time of the given time
+// formatted with the time format of the language.
+func (lp LangParameters) FormatTime(t time.Time) string {
+	return t.Format(lp.TimeLayout())
+}
+
+// FormatDateTime returns the given date time formatted with the date and time
+// formats of the language. It returns an empty string if the date time is zero.
+func (lp LangParameters) FormatDateTime(dateTime dates.DateTime) string {
+	if dateTime.IsZero() {
+		return ""
+	}
+	return dateTime.Format(lp.DateTimeLayout())
+}
+
+// ParseDate parses the given date formatted with the date format of the language
+func (lp LangParameters) ParseDate(str string) (dates.Date, error) {
+	return dates.ParseDate(lp.DateLayout(), strings.TrimSpace(str))
+}
+
+// ParseDateTime parses the given date time formatted
+// with the date and time formats of the language
+func (lp LangParameters) ParseDateTime(str string) (dates.DateTime, error) {
+	return dates.ParseDateTime(lp.DateTimeLayout(), strings.TrimSpace(str))
+}
+
+// DateLayout returns the Go time layout of the date format of the language
+func (lp LangParameters) DateLayout() string {
+	format := lp.DateFormat
+	if format == "" {
+		format = DefaultLangParameters.DateFormat
+	}
+	return StrftimeToLayout(format)
+}
+
+// TimeLayout returns the Go time layout of the time format of the language
+func (lp LangParameters) TimeLayout() string {
+	format := lp.TimeFormat
+	if format == "" {
+		format = DefaultLangParameters.TimeFormat
+	}
+	return StrftimeToLayout(format)
+}
+
+// DateTimeLayout returns the Go time layout of the date
+// format followed by the time format of the language
+func (lp LangParameters) DateTimeLayout() string {
+	return lp.DateLayout() + " " + lp.TimeLayout()
+}
+
+// decimalPoint returns the decimal point of the language, defaulting to '.'
+func (lp LangParameters) decimalPoint() string {
+	if lp.DecimalPoint == "" {
+		return "."
+	}
+	return lp.DecimalPoint
+}
+
+// group inserts the thousands separator of the language in
+// the given string of digits according to its grouping.
+//
+// Grouping is a JSON list of group sizes starting from the right. A 0 repeats
+// the previous size for the remaining digits and -1 stops grouping. Grouping
+// is disabled if it is invalid or empty, or if there is no thousands separator.
+func (lp LangParameters) group(digits string) string {
+	if lp.ThousandsSep == "" {
+		return digits
+	}
+	var sizes []int
+	if err := json.Unmarshal([]byte(lp.Grouping), &sizes); err != nil || len(sizes) == 0 {
+		return digits
+	}
+	var groups []string
+	size := sizes[0]
+	for i := 0; len(digits) > 0; i++ {
+		if i < len(sizes) {
+			switch {
+			case sizes[i] == -1:
+				size = len(digits)
+			case sizes[i] > 0:
+				size = sizes[i]
+			}
+		}
+		if size <= 0 || size >= len(digits) {
+			groups = append([]string{digits}, groups...)
+			break
+		}
+		groups = append([]string{digits[len(digits)-size:]}, groups...)
+		digits = digits[:len(digits)-size]
+	}
+	return strings.Join(groups, lp.ThousandsSep)
+}
+
+// strftimeLayouts maps strftime directives to Go time layout elements
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'S': "05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// StrftimeToLayout returns the Go time layout of the given strftime format,
+// as used by the DateFormat and TimeFormat of LangParameters. Composite
+// directives %D, %F, %R and %T are expanded. Unknown directives are kept as is.
+func StrftimeToLayout(format string) string {
+	format = strings.NewReplacer("%D", "%m/%d/%y", "%F", "%Y-%m-%d", "%R", "%H:%M", "%T", "%H:%M:%S").Replace(format)
+	var res strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			res.WriteByte(format[i])
+			continue
+		}
+		layout, ok := strftimeLayouts[format[i+1]]
+		if !ok {
+			res.WriteString(fmt.Sprintf("%%%c", format[i+1]))
+			i++
+			continue
+		}
+		res.WriteString(layout)
+		i++
+	}
+	return res.String()
+}
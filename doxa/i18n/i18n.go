@@ -5,6 +5,7 @@ package i18n
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/labneco/doxa/doxa/models/types"
 	"github.com/labneco/doxa/doxa/tools/po"
@@ -22,76 +23,176 @@ type TranslationsCollection struct {
 	fieldSelection   map[selectionRef]string
 	resource         map[resourceRef]string
 	code             map[codeRef]string
+	plural           map[codeRef][]string
+	pluralForms      map[string]*PluralForms
+	catalogs         map[string]cachedCatalog
+	catalogsMutex    sync.Mutex
 }
 
 // TranslateFieldDescription returns the translation for the given model field
-// name in the given lang. If no translation is found or if the translation
-// is the empty string defaultValue is returned.
+// name in the given lang or in its fallback languages (see FallbackLangs). If
+// no translation is found or if the translation is the empty string, the
+// translation of defaultValue in the 'Model.Field' context is returned
+// (see TranslateContext).
 func (tc *TranslationsCollection) TranslateFieldDescription(lang, model, field, defaultValue string) string {
-	key := fieldRef{lang: lang, model: model, field: field}
-	val, ok := tc.fieldDescription[key]
-	if !ok || val == "" {
-		return defaultValue
+	for _, l := range FallbackLangs(lang) {
+		if val := tc.fieldDescription[fieldRef{lang: l, model: model, field: field}]; val != "" {
+			return val
+		}
 	}
-	return val
+	return tc.TranslateContext(lang, model+fieldSep+field, defaultValue)
 }
 
 // TranslateFieldHelp returns the translation for the given model field
-// help in the given lang. If no translation is found or if the translation
-// is the empty string defaultValue is returned.
+// help in the given lang or in its fallback languages (see FallbackLangs).
+// If no translation is found or if the translation is the empty string, the
+// translation of defaultValue in the 'Model.Field' context is returned
+// (see TranslateContext).
 func (tc *TranslationsCollection) TranslateFieldHelp(lang, model, field, defaultValue string) string {
-	key := fieldRef{lang: lang, model: model, field: field}
-	val, ok := tc.fieldHelp[key]
-	if !ok || val == "" {
-		return defaultValue
+	for _, l := range FallbackLangs(lang) {
+		if val := tc.fieldHelp[fieldRef{lang: l, model: model, field: field}]; val != "" {
+			return val
+		}
 	}
-	return val
+	return tc.TranslateContext(lang, model+fieldSep+field, defaultValue)
 }
 
-// TranslateFieldSelection returns the translated version of the given selection in the given lang.
-// When no translation is found for an item, the original string is used.
+// TranslateFieldSelection returns the translated version of the given selection in the given lang
+// or in its fallback languages (see FallbackLangs). When no translation is found for an item, its
+// translation in the 'Model.Field' context is used (see TranslateContext).
 func (tc *TranslationsCollection) TranslateFieldSelection(lang, model, field string, selection types.Selection) types.Selection {
 	res := make(types.Selection)
+	langs := FallbackLangs(lang)
+SelectionItems:
 	for selKey, selItem := range selection {
-		key := selectionRef{lang: lang, model: model, field: field, source: selItem}
-		val, ok := tc.fieldSelection[key]
-		if !ok || val == "" {
-			res[selKey] = selItem
-			continue
+		for _, l := range langs {
+			if val := tc.fieldSelection[selectionRef{lang: l, model: model, field: field, source: selItem}]; val != "" {
+				res[selKey] = val
+				continue SelectionItems
+			}
 		}
-		res[selKey] = val
+		res[selKey] = tc.TranslateContext(lang, model+fieldSep+field, selItem)
 	}
 	return res
 }
 
 // TranslateResourceItem returns the translation for the given src of the given resource
-// in the given lang. If no translation is found or if the translation is the
-// empty string src is returned.
+// in the given lang or in its fallback languages (see FallbackLangs). If no translation
+// is found or if the translation is the empty string, the translation of src in the
+// context of the resource ID is returned (see TranslateContext).
 func (tc *TranslationsCollection) TranslateResourceItem(lang, resourceID, src string) string {
-	key := resourceRef{lang: lang, viewID: resourceID, source: src}
-	val, ok := tc.resource[key]
-	if !ok || val == "" {
-		return src
+	for _, l := range FallbackLangs(lang) {
+		if val := tc.resource[resourceRef{lang: l, viewID: resourceID, source: src}]; val != "" {
+			return val
+		}
 	}
-	return val
+	return tc.TranslateContext(lang, resourceID, src)
 }
 
-// TranslateCode returns the translation for the given src in the given lang, in the
-// given context. If no translation is found or if the translation is the empty
-// string src is returned.
+// TranslateCode returns the translation for the given src in the given lang or in
+// its fallback languages (see FallbackLangs), in the given context. If no translation
+// is found or if the translation is the empty string src is returned.
 func (tc *TranslationsCollection) TranslateCode(lang, context, src string) string {
-	key := codeRef{lang: lang, context: context, source: src}
-	val, ok := tc.code[key]
-	if !ok || val == "" {
+	for _, l := range FallbackLangs(lang) {
+		if val := tc.code[codeRef{lang: l, context: context, source: src}]; val != "" {
+			return val
+		}
+	}
+	return src
+}
+
+// TranslateContext returns the translation for the given src in the given lang,
+// in the given context. Contrary to TranslateCode, translations given without
+// context are used if there is no translation in this context, so that a
+// translation can be given for a specific context only. If no translation is
+// found or if the translation is the empty string src is returned.
+//
+// Translations in the given context are looked up in lang and its fallback
+// languages (see FallbackLangs) before translations without context.
+func (tc *TranslationsCollection) TranslateContext(lang, context, src string) string {
+	if src == "" {
 		return src
 	}
-	return val
+	langs := FallbackLangs(lang)
+	for _, l := range langs {
+		if val := tc.code[codeRef{lang: l, context: context, source: src}]; val != "" {
+			return val
+		}
+	}
+	for _, l := range langs {
+		if val := tc.code[codeRef{lang: l, source: src}]; val != "" {
+			return val
+		}
+	}
+	return src
+}
+
+// TranslateCodePlural returns the translation for the given singular src in
+// the given lang or in its fallback languages (see FallbackLangs), in the
+// given context, in the plural form to use for the count n. If no translation
+// is found or if the translation is the empty string, singular is returned if
+// n is 1 and plural otherwise.
+func (tc *TranslationsCollection) TranslateCodePlural(lang, context, singular, plural string, n int) string {
+	for _, l := range FallbackLangs(lang) {
+		forms := tc.plural[codeRef{lang: l, context: context, source: singular}]
+		pf := tc.PluralForms(l)
+		if pf == nil {
+			pf = defaultPluralForms
+		}
+		idx := pf.Index(n)
+		if idx < len(forms) && forms[idx] != "" {
+			return forms[idx]
+		}
+	}
+	if defaultPluralForms.Index(n) == 0 {
+		return singular
+	}
+	return plural
+}
+
+// PluralTranslations returns the translations in the given lang of each plural
+// form of the message with the given singular src in the given context.
+// It returns nil if there is no such translation.
+func (tc *TranslationsCollection) PluralTranslations(lang, context, singular string) []string {
+	return tc.plural[codeRef{lang: lang, context: context, source: singular}]
+}
+
+// PluralForms returns the plural forms of the given lang, as given by the
+// Plural-Forms header of its PO files or, failing that, of the PO files of
+// its fallback languages (see FallbackLangs). It returns nil if no PO file
+// with a Plural-Forms header has been loaded for these languages. English
+// plural forms are used for translations in such languages.
+func (tc *TranslationsCollection) PluralForms(lang string) *PluralForms {
+	for _, l := range FallbackLangs(lang) {
+		if pf, ok := tc.pluralForms[l]; ok {
+			return pf
+		}
+	}
+	return nil
+}
+
+// FallbackLangs returns the languages in which translations for the given
+// lang are looked up, by decreasing priority: lang itself, then the languages
+// obtained by successively removing its modifier and its territory. For
+// instance, 'fr_CA' falls back to 'fr' and 'sr_RS@latin' to 'sr_RS' and 'sr'.
+// Strings that are translated in none of them are left untranslated.
+func FallbackLangs(lang string) []string {
+	res := []string{lang}
+	for {
+		idx := strings.LastIndexAny(lang, "_@")
+		if idx <= 0 {
+			return res
+		}
+		lang = lang[:idx]
+		res = append(res, lang)
+	}
 }
 
 // LoadPOFile load the file with the given filename into the TranslationsCollection.
 // This function can be called several times to iteratively load translations.
 // It panics in case of errors in the PO file.
 func (tc *TranslationsCollection) LoadPOFile(fileName string) {
+	defer tc.resetCatalogs()
 	poFile, err := po.Load(fileName)
 	if err != nil {
 		log.Panic("Error while parsing PO file", "file", fileName, "error", err)
@@ -100,6 +201,13 @@ func (tc *TranslationsCollection) LoadPOFile(fileName string) {
 	if lang == "" {
 		log.Panic("Language should be specified in PO file header", "file", fileName)
 	}
+	if poFile.MimeHeader.PluralForms != "" {
+		pf, err := ParsePluralForms(poFile.MimeHeader.PluralForms)
+		if err != nil {
+			log.Panic("Invalid Plural-Forms in PO file header", "file", fileName, "error", err)
+		}
+		tc.pluralForms[lang] = pf
+	}
 	for _, msg := range poFile.Messages {
 		for _, line := range strings.Split(msg.ExtractedComment, "\n") {
 			tokens := strings.Split(line, ":")
@@ -138,7 +246,12 @@ func (tc *TranslationsCollection) LoadPOFile(fileName string) {
 				tc.resource[resourceRef{lang: lang, viewID: viewID, source: msg.MsgId}] = msg.MsgStr
 			default:
 				// Translating code. Context may be given as msgctxt
-				tc.code[codeRef{lang: lang, context: msg.MsgContext, source: msg.MsgId}] = msg.MsgStr
+				key := codeRef{lang: lang, context: msg.MsgContext, source: msg.MsgId}
+				if msg.MsgIdPlural != "" {
+					tc.plural[key] = msg.MsgStrPlural
+					continue
+				}
+				tc.code[key] = msg.MsgStr
 			}
 		}
 	}
@@ -181,6 +294,52 @@ func TranslateCode(lang, context, src string) string {
 	return Registry.TranslateCode(lang, context, src)
 }
 
+// TC returns the translation for the given src in the given lang, in the given
+// context, using the default translation Registry. Contexts are given as
+// msgctxt in PO files and allow the same source string to be translated
+// differently depending on where it appears:
+//
+//	#. code:
+//	msgctxt "sale"
+//	msgid "Order"
+//	msgstr "Commande"
+//
+// If there is no translation in this context, the translation without context
+// is used. If no translation is found, src is returned.
+//
+// Field descriptions, help and selection values fall back to translations in
+// the 'Model.Field' context, and strings of views, menus and actions to
+// translations in the context of their ID.
+func TC(lang, context, src string) string {
+	return Registry.TranslateContext(lang, context, src)
+}
+
+// TranslateCodePlural returns the translation for the given singular src in
+// the given lang, in the given context, in the plural form to use for the
+// count n, using the default translation Registry. If no translation is found
+// or if the translation is the empty string, singular is returned if n is 1
+// and plural otherwise.
+func TranslateCodePlural(lang, context, singular, plural string, n int) string {
+	return Registry.TranslateCodePlural(lang, context, singular, plural, n)
+}
+
+// TN returns the translation in the given lang of the message with the
+// given singular and plural forms in the plural form to use for the count
+// n, using the default translation Registry. Translations are given in PO
+// files as msgid_plural entries:
+//
+//	#. code:
+//	msgid "%d record deleted"
+//	msgid_plural "%d records deleted"
+//	msgstr[0] "%d запись удалена"
+//	msgstr[1] "%d записи удалены"
+//	msgstr[2] "%d записей удалено"
+//
+// If no translation is found, singular is returned if n is 1 and plural otherwise.
+func TN(lang, singular, plural string, n int) string {
+	return Registry.TranslateCodePlural(lang, "", singular, plural, n)
+}
+
 // A fieldRef references a field in the translation maps
 type fieldRef struct {
 	lang  string
@@ -223,6 +382,9 @@ func NewTranslationsCollection() *TranslationsCollection {
 		fieldSelection:   make(map[selectionRef]string),
 		resource:         make(map[resourceRef]string),
 		code:             make(map[codeRef]string),
+		plural:           make(map[codeRef][]string),
+		pluralForms:      make(map[string]*PluralForms),
+		catalogs:         make(map[string]cachedCatalog),
 	}
 }
 
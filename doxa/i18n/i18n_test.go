@@ -5,8 +5,10 @@ package i18n
 
 import (
 	"testing"
+	"time"
 
 	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -99,6 +101,243 @@ func TestI18N(t *testing.T) {
 			So(func() { LoadPOFile("testdata/invalid-field.po") }, ShouldPanic)
 			So(func() { LoadPOFile("testdata/invalid-help.po") }, ShouldPanic)
 			So(func() { LoadPOFile("testdata/invalid-selection.po") }, ShouldPanic)
+			So(func() { LoadPOFile("testdata/invalid-plural.po") }, ShouldPanic)
+		})
+	})
+	Convey("Testing translation contexts", t, func() {
+		LoadPOFile("testdata/fr-context.po")
+		Convey("Code should be translated in its context", func() {
+			So(TC("fr", "sale", "Order"), ShouldEqual, "Commande")
+			So(TC("fr", "purchase", "Order"), ShouldEqual, "Ordre")
+			So(TC("fr", "", "Order"), ShouldEqual, "Ordre")
+			So(TC("de", "sale", "Order"), ShouldEqual, "Order")
+			So(TC("fr", "sale", "Unknown"), ShouldEqual, "Unknown")
+			So(TranslateCode("fr", "purchase", "Order"), ShouldEqual, "Order")
+		})
+		Convey("Fields should fall back to translations in their context", func() {
+			So(TranslateFieldDescription("fr", "User", "Login", "Login"), ShouldEqual, "Identifiant")
+			So(TranslateFieldDescription("fr", "Partner", "Login", "Login"), ShouldEqual, "Login")
+			So(TranslateFieldDescription("fr", "User", "Active", "Active"), ShouldEqual, "Actif")
+			So(TranslateFieldHelp("fr", "User", "Login", ""), ShouldEqual, "")
+			trans := TranslateFieldSelection("fr", "Profile", "State", types.Selection{"draft": "Draft", "active": "Active"})
+			So(trans["draft"], ShouldEqual, "Brouillon de profil")
+			So(trans["active"], ShouldEqual, "Activé")
+			trans = TranslateFieldSelection("fr", "Order", "State", types.Selection{"draft": "Draft"})
+			So(trans["draft"], ShouldEqual, "Brouillon")
+		})
+		Convey("Resources should fall back to translations in the context of their ID", func() {
+			So(TranslateResourceItem("fr", "user_view_id", "Settings"), ShouldEqual, "Réglages")
+			So(TranslateResourceItem("fr", "user_view2_id", "Settings"), ShouldEqual, "Settings")
+			So(TranslateResourceItem("fr", "user_view2_id", "Draft"), ShouldEqual, "Brouillon")
+		})
+	})
+	Convey("Testing lazy translations", t, func() {
+		LoadPOFile("testdata/fr.po")
+		LoadPOFile("testdata/fr-context.po")
+		label := Lazy("Order")
+		So(label.String(), ShouldEqual, "Order")
+		So(label.Translate("fr"), ShouldEqual, "Ordre")
+		So(label.Translate("de"), ShouldEqual, "Order")
+		So(TranslateValue("fr", map[string]interface{}{
+			"label":  label,
+			"states": []interface{}{Lazy("Draft"), "Draft", int64(3)},
+		}), ShouldResemble, map[string]interface{}{
+			"label":  "Ordre",
+			"states": []interface{}{"Brouillon", "Draft", int64(3)},
+		})
+		So(TranslateValue("fr", 12), ShouldEqual, 12)
+	})
+	Convey("Testing plural forms", t, func() {
+		Convey("Plural forms headers should be parsed", func() {
+			pf, err := ParsePluralForms("nplurals=6; plural=(n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n%100>=3 && n%100<=10 ? 3 : n%100>=11 ? 4 : 5);")
+			So(err, ShouldBeNil)
+			So(pf.NPlurals(), ShouldEqual, 6)
+			for n, idx := range map[int]int{0: 0, 1: 1, 2: 2, 5: 3, 100: 5, 111: 4, 1000: 5} {
+				So(pf.Index(n), ShouldEqual, idx)
+			}
+			pf, err = ParsePluralForms("nplurals=2; plural=n > 1;")
+			So(err, ShouldBeNil)
+			So(pf.Index(0), ShouldEqual, 0)
+			So(pf.Index(2), ShouldEqual, 1)
+			So(pf.String(), ShouldEqual, "nplurals=2; plural=n > 1;")
+			pf, err = ParsePluralForms("nplurals=2; plural=!(n == 1) * 3 - 2;")
+			So(err, ShouldBeNil)
+			So(pf.Index(1), ShouldEqual, 0)
+			So(pf.Index(4), ShouldEqual, 1)
+			for _, header := range []string{
+				"nplurals=2;",
+				"plural=(n != 1);",
+				"nplurals=x; plural=(n != 1);",
+				"nplurals=2; plural=(n != 1;",
+				"nplurals=2; plural=n ? 1;",
+				"nplurals=2; plural=m != 1;",
+				"nplurals=2; plural=n != ;",
+				"nplurals=2; plural=n 1;",
+				"nplurals=2; plural=(n != 1); other=1;",
+			} {
+				_, err := ParsePluralForms(header)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("Plural messages should be translated with the plural forms of the language", func() {
+			LoadPOFile("testdata/ru.po")
+			So(Registry.PluralForms("ru").NPlurals(), ShouldEqual, 3)
+			So(Registry.PluralForms("de"), ShouldBeNil)
+			So(TN("ru", "%d record deleted", "%d records deleted", 1), ShouldEqual, "%d запись удалена")
+			So(TN("ru", "%d record deleted", "%d records deleted", 21), ShouldEqual, "%d запись удалена")
+			So(TN("ru", "%d record deleted", "%d records deleted", 3), ShouldEqual, "%d записи удалены")
+			So(TN("ru", "%d record deleted", "%d records deleted", 11), ShouldEqual, "%d записей удалено")
+			So(TN("ru", "%d record deleted", "%d records deleted", 25), ShouldEqual, "%d записей удалено")
+			So(Registry.PluralTranslations("ru", "", "%d file"), ShouldResemble, []string{"%d файл", "", "%d файлов"})
+		})
+		Convey("Untranslated plural messages should use English plural forms", func() {
+			So(TN("ru", "%d file", "%d files", 5), ShouldEqual, "%d файлов")
+			So(TN("ru", "%d file", "%d files", 2), ShouldEqual, "%d files")
+			So(TN("de", "%d record deleted", "%d records deleted", 1), ShouldEqual, "%d record deleted")
+			So(TN("de", "%d record deleted", "%d records deleted", 0), ShouldEqual, "%d records deleted")
+			So(TranslateCodePlural("ru", "base", "%d record deleted", "%d records deleted", 5), ShouldEqual, "%d records deleted")
+		})
+	})
+	Convey("Testing locale fallbacks", t, func() {
+		LoadPOFile("testdata/fr.po")
+		LoadPOFile("testdata/fr-context.po")
+		LoadPOFile("testdata/fr_CA.po")
+		LoadPOFile("testdata/ru.po")
+		Convey("Languages should fall back to their base language", func() {
+			So(FallbackLangs("fr_CA"), ShouldResemble, []string{"fr_CA", "fr"})
+			So(FallbackLangs("sr_RS@latin"), ShouldResemble, []string{"sr_RS@latin", "sr_RS", "sr"})
+			So(FallbackLangs("fr"), ShouldResemble, []string{"fr"})
+			So(FallbackLangs(""), ShouldResemble, []string{""})
+		})
+		Convey("Regional translations should be used first", func() {
+			So(TC("fr_CA", "", "Draft"), ShouldEqual, "Ébauche")
+			So(TranslateFieldDescription("fr_CA", "User", "Login", "Login"), ShouldEqual, "Code d'utilisateur")
+		})
+		Convey("Missing regional translations should be taken from the base language", func() {
+			So(TC("fr_CA", "sale", "Order"), ShouldEqual, "Commande")
+			So(TC("fr_CA", "purchase", "Order"), ShouldEqual, "Ordre")
+			So(TC("fr_CA", "Profile.State", "Draft"), ShouldEqual, "Brouillon de profil")
+			So(TranslateCode("fr_CA", "base", "You are not allowed to perform this operation"), ShouldEqual, "Vous n'êtes pas autorisé à faire cette opération")
+			So(TranslateFieldDescription("fr_CA", "User", "Active", "Active"), ShouldEqual, "Actif")
+			So(TranslateFieldHelp("fr_CA", "User", "Active", ""), ShouldStartWith, "Lorsqu'il est inactif")
+			So(TranslateFieldSelection("fr_CA", "Profile", "State", types.Selection{"active": "Active"})["active"], ShouldEqual, "Actif")
+			So(TranslateResourceItem("fr_CA", "user_view_id", "Profile Data"), ShouldEqual, "Données du profil")
+			So(Registry.PluralForms("ru_UA").NPlurals(), ShouldEqual, 3)
+			So(TN("ru_UA", "%d record deleted", "%d records deleted", 3), ShouldEqual, "%d записи удалены")
+		})
+		Convey("Strings translated in no fallback language should not be translated", func() {
+			So(TC("fr_CA", "", "Unknown"), ShouldEqual, "Unknown")
+			So(TC("de_AT", "sale", "Order"), ShouldEqual, "Order")
+			So(TN("de_AT", "%d record deleted", "%d records deleted", 3), ShouldEqual, "%d records deleted")
+		})
+		Convey("Catalogs should merge the translations of the base language", func() {
+			catalog := Registry.Catalog("fr_CA")
+			So(catalog.Messages["Draft"], ShouldEqual, "Ébauche")
+			So(catalog.Messages["Order"], ShouldEqual, "Ordre")
+			So(catalog.PluralForms, ShouldEqual, "nplurals=1; plural=0;")
+		})
+	})
+	Convey("Testing translation catalogs", t, func() {
+		tc := NewTranslationsCollection()
+		tc.LoadPOFile("testdata/fr-context.po")
+		tc.LoadPOFile("testdata/ru.po")
+		Convey("Catalogs should hold the code translations of their language", func() {
+			catalog := tc.Catalog("fr")
+			So(catalog.Lang, ShouldEqual, "fr")
+			So(catalog.PluralForms, ShouldEqual, "nplurals=1; plural=0;")
+			So(catalog.Messages, ShouldHaveLength, 6)
+			So(catalog.Messages["Order"], ShouldEqual, "Ordre")
+			So(catalog.Messages["sale"+CatalogContextSeparator+"Order"], ShouldEqual, "Commande")
+			So(catalog.Plurals, ShouldBeEmpty)
+			catalog = tc.Catalog("ru")
+			So(catalog.Plurals["%d record deleted"], ShouldHaveLength, 3)
+			So(catalog.Messages, ShouldNotContainKey, "sale"+CatalogContextSeparator+"Order")
+			So(tc.Catalog("de").PluralForms, ShouldEqual, "nplurals=2; plural=(n != 1);")
+		})
+		Convey("JSON catalogs should be cached until translations change", func() {
+			data, etag := tc.CatalogJSON("fr")
+			So(string(data), ShouldContainSubstring, `"Commande"`)
+			So(etag, ShouldStartWith, `"`)
+			data2, etag2 := tc.CatalogJSON("fr")
+			So(etag2, ShouldEqual, etag)
+			So(&data2[0], ShouldEqual, &data[0])
+			tc.LoadPOFile("testdata/fr.po")
+			_, etag3 := tc.CatalogJSON("fr")
+			So(etag3, ShouldNotEqual, etag)
+			SetLangParameters("fr", LangParameters{DecimalPoint: ","})
+			data4, etag4 := tc.CatalogJSON("fr")
+			So(etag4, ShouldNotEqual, etag3)
+			So(string(data4), ShouldContainSubstring, `"decimal_point":","`)
+		})
+	})
+}
+
+func TestFormat(t *testing.T) {
+	Convey("Testing locale-aware formatting", t, func() {
+		fr := LangParameters{
+			DateFormat:       "%d/%m/%Y",
+			TimeFormat:       "%H:%M",
+			ThousandsSep:     "\u00a0",
+			DecimalPoint:     ",",
+			Grouping:         "[3,0]",
+			CurrencyPosition: CurrencyPositionAfter,
+		}
+		in := LangParameters{ThousandsSep: ",", DecimalPoint: ".", Grouping: "[3,2,0]"}
+		Convey("Numbers should be formatted with the grouping and decimal point of the language", func() {
+			So(DefaultLangParameters.FormatFloat(1234567.891, 2, true), ShouldEqual, "1,234,567.89")
+			So(DefaultLangParameters.FormatFloat(1234567.891, 2, false), ShouldEqual, "1234567.89")
+			So(DefaultLangParameters.FormatFloat(-999.999, 2, true), ShouldEqual, "-1,000.00")
+			So(DefaultLangParameters.FormatFloat(-0.001, 2, true), ShouldEqual, "0.00")
+			So(fr.FormatFloat(1234567.5, 2, true), ShouldEqual, "1\u00a0234\u00a0567,50")
+			So(fr.FormatInteger(-12345, true), ShouldEqual, "-12\u00a0345")
+			So(in.FormatFloat(123456789, 0, true), ShouldEqual, "12,34,56,789")
+			noRepeat := LangParameters{ThousandsSep: ",", Grouping: "[3,-1]"}
+			So(noRepeat.FormatInteger(123456789, true), ShouldEqual, "123456,789")
+			So(LangParameters{ThousandsSep: ","}.FormatInteger(123456, true), ShouldEqual, "123456")
+		})
+		Convey("Amounts should be formatted with the currency position of the language", func() {
+			So(DefaultLangParameters.FormatMonetary(1234.5, "$", 2), ShouldEqual, "$\u00a01,234.50")
+			So(DefaultLangParameters.FormatMonetary(-1234.5, "$", 2), ShouldEqual, "-$\u00a01,234.50")
+			So(fr.FormatMonetary(1234.5, "€", 2), ShouldEqual, "1\u00a0234,50\u00a0€")
+			So(fr.FormatMonetary(-1234.5, "€", 2), ShouldEqual, "-1\u00a0234,50\u00a0€")
+			So(fr.FormatMonetary(-0.001, "€", 2), ShouldEqual, "0,00\u00a0€")
+			So(in.FormatMonetary(1234567, "₹", 0), ShouldEqual, "₹\u00a012,34,567")
+			So(fr.FormatMonetary(12, "", 2), ShouldEqual, "12,00")
+			SetLangParameters("fr", fr)
+			So(FormatMonetary(1234.567, "€", "fr"), ShouldEqual, "1\u00a0234,57\u00a0€")
+			So(FormatMonetary(1234.567, "$", "xx"), ShouldEqual, "$\u00a01,234.57")
+		})
+		Convey("Numbers should be parsed with the separators of the language", func() {
+			val, err := fr.ParseFloat("1\u00a0234,5")
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, 1234.5)
+			val, err = DefaultLangParameters.ParseFloat(" 1,234.5 ")
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, 1234.5)
+			_, err = fr.ParseFloat("abc")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Dates and times should be formatted with the formats of the language", func() {
+			dateTime := dates.DateTime{Time: time.Date(2017, 7, 14, 9, 5, 30, 0, time.UTC)}
+			So(fr.FormatDate(dateTime.ToDate()), ShouldEqual, "14/07/2017")
+			So(fr.FormatDateTime(dateTime), ShouldEqual, "14/07/2017 09:05")
+			So(fr.FormatTime(dateTime.Time), ShouldEqual, "09:05")
+			So(DefaultLangParameters.FormatDateTime(dateTime), ShouldEqual, "07/14/2017 09:05:30")
+			So(fr.FormatDate(dates.Date{}), ShouldBeEmpty)
+			date, err := fr.ParseDate("14/07/2017")
+			So(err, ShouldBeNil)
+			So(date.Equal(dateTime.ToDate()), ShouldBeTrue)
+			parsed, err := DefaultLangParameters.ParseDateTime("07/14/2017 09:05:30")
+			So(err, ShouldBeNil)
+			So(parsed.Equal(dateTime), ShouldBeTrue)
+			_, err = fr.ParseDate("2017-07-14")
+			So(err, ShouldNotBeNil)
+			So(StrftimeToLayout("%A %e %B %Y, %I:%M %p %% %Q %T"), ShouldEqual, "Monday _2 January 2006, 03:04 PM % %Q 15:04:05")
+		})
+		Convey("Parameters should be set per language", func() {
+			SetLangParameters("fr", fr)
+			So(GetLangParameters("fr"), ShouldResemble, fr)
+			So(GetLangParameters("xx"), ShouldResemble, DefaultLangParameters)
 		})
 	})
 }
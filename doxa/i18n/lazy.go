@@ -0,0 +1,56 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package i18n
+
+// A Translatable is a value that is translated when it is rendered
+type Translatable interface {
+	Translate(lang string) string
+}
+
+// A Lazy is a string whose translation is deferred until it is rendered in
+// the language of the request. It is meant for strings that are defined at
+// init, before the language is known, such as labels displayed in templates:
+//
+//	var sessionFullLabel = i18n.Lazy("This session is full")
+//
+// Lazy strings are translated when they are rendered by QWeb templates, or
+// explicitly with Translate or TranslateValue. They are extracted in PO files
+// as code strings without context.
+type Lazy string
+
+// String returns the untranslated source string
+func (l Lazy) String() string {
+	return string(l)
+}
+
+// Translate returns the translation of this string in the given lang.
+// If no translation is found, the source string is returned.
+func (l Lazy) Translate(lang string) string {
+	return Registry.TranslateContext(lang, "", string(l))
+}
+
+var _ Translatable = Lazy("")
+
+// TranslateValue returns the given value in which Translatable values are
+// translated in the given lang. Values of maps and items of slices of
+// interface{} are translated recursively. Other values are returned as is.
+func TranslateValue(lang string, value interface{}) interface{} {
+	switch val := value.(type) {
+	case Translatable:
+		return val.Translate(lang)
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, item := range val {
+			res[i] = TranslateValue(lang, item)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			res[k] = TranslateValue(lang, item)
+		}
+		return res
+	}
+	return value
+}
@@ -0,0 +1,327 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultPluralForms are the plural forms of languages
+// without Plural-Forms header, i.e. those of English.
+var defaultPluralForms = MustParsePluralForms("nplurals=2; plural=(n != 1);")
+
+// PluralForms define how the plural form of a message to use for a count is
+// selected in a language. They are given by the Plural-Forms header of PO files:
+//
+//	Plural-Forms: nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);
+type PluralForms struct {
+	header   string
+	nPlurals int
+	plural   pluralExpr
+}
+
+// String returns the Plural-Forms header value of these PluralForms
+func (pf *PluralForms) String() string {
+	return pf.header
+}
+
+// NPlurals returns the number of plural forms of the language
+func (pf *PluralForms) NPlurals() int {
+	return pf.nPlurals
+}
+
+// Index returns the index of the plural form to use for the given count.
+// The returned index is always between 0 and NPlurals()-1.
+func (pf *PluralForms) Index(n int) int {
+	idx := int(pf.plural(int64(n)))
+	if idx < 0 || idx >= pf.nPlurals {
+		return 0
+	}
+	return idx
+}
+
+// ParsePluralForms parses the given Plural-Forms header value. The plural
+// expression is a C expression of the count n which can use integers, n,
+// arithmetic, comparison and logical operators and the ternary operator.
+func ParsePluralForms(header string) (*PluralForms, error) {
+	res := PluralForms{header: strings.TrimSpace(header)}
+	for _, item := range strings.Split(header, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tokens := strings.SplitN(item, "=", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid plural forms item '%s'", item)
+		}
+		switch strings.TrimSpace(tokens[0]) {
+		case "nplurals":
+			n, err := strconv.Atoi(strings.TrimSpace(tokens[1]))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid nplurals '%s'", tokens[1])
+			}
+			res.nPlurals = n
+		case "plural":
+			expr, err := parsePluralExpr(tokens[1])
+			if err != nil {
+				return nil, err
+			}
+			res.plural = expr
+		default:
+			return nil, fmt.Errorf("unknown plural forms item '%s'", item)
+		}
+	}
+	if res.nPlurals == 0 || res.plural == nil {
+		return nil, fmt.Errorf("nplurals and plural must be given in plural forms '%s'", header)
+	}
+	return &res, nil
+}
+
+// MustParsePluralForms parses the given Plural-Forms header value.
+// It panics if the header is invalid.
+func MustParsePluralForms(header string) *PluralForms {
+	res, err := ParsePluralForms(header)
+	if err != nil {
+		log.Panic("Invalid plural forms", "header", header, "error", err)
+	}
+	return res
+}
+
+// A pluralExpr is a compiled plural expression
+type pluralExpr func(n int64) int64
+
+// A pluralParser is a recursive descent parser of plural expressions
+type pluralParser struct {
+	tokens []string
+	pos    int
+}
+
+// parsePluralExpr compiles the given plural expression
+func parsePluralExpr(src string) (pluralExpr, error) {
+	tokens, err := tokenizePlural(src)
+	if err != nil {
+		return nil, err
+	}
+	p := pluralParser{tokens: tokens}
+	res, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in plural expression '%s'", p.tokens[p.pos], src)
+	}
+	return res, nil
+}
+
+// pluralOperators are the operators of plural expressions,
+// two characters operators first.
+var pluralOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "?", ":", "+", "-", "*", "/", "%", "(", ")"}
+
+// tokenizePlural splits the given plural expression into tokens
+func tokenizePlural(src string) ([]string, error) {
+	var res []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == 'n':
+			res = append(res, "n")
+			i++
+			continue
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && src[j] >= '0' && src[j] <= '9' {
+				j++
+			}
+			res = append(res, src[i:j])
+			i = j
+			continue
+		}
+		var op string
+		for _, o := range pluralOperators {
+			if strings.HasPrefix(src[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("invalid character '%c' in plural expression '%s'", c, src)
+		}
+		res = append(res, op)
+		i += len(op)
+	}
+	return res, nil
+}
+
+// peek returns the current token, or an empty string at the end
+func (p *pluralParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// accept consumes the current token if it is one of the given
+// operators and returns it. It returns an empty string otherwise.
+func (p *pluralParser) accept(ops ...string) string {
+	tok := p.peek()
+	for _, op := range ops {
+		if tok == op {
+			p.pos++
+			return tok
+		}
+	}
+	return ""
+}
+
+// parseTernary parses a ternary expression
+func (p *pluralParser) parseTernary() (pluralExpr, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("?") == "" {
+		return cond, nil
+	}
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept(":") == "" {
+		return nil, fmt.Errorf("missing ':' in plural expression")
+	}
+	els, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return func(n int64) int64 {
+		if cond(n) != 0 {
+			return then(n)
+		}
+		return els(n)
+	}, nil
+}
+
+// pluralPrecedence lists the binary operators of
+// plural expressions by increasing precedence.
+var pluralPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseBinary parses a binary expression whose
+// operators have at least the given precedence level.
+func (p *pluralParser) parseBinary(level int) (pluralExpr, error) {
+	if level == len(pluralPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.accept(pluralPrecedence[level]...)
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryPluralExpr(op, left, right)
+	}
+}
+
+// parseUnary parses a negation, a parenthesized expression, n or an integer
+func (p *pluralParser) parseUnary() (pluralExpr, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "!":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n int64) int64 { return boolToInt(operand(n) == 0) }, nil
+	case tok == "(":
+		res, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(")") == "" {
+			return nil, fmt.Errorf("missing ')' in plural expression")
+		}
+		return res, nil
+	case tok == "n":
+		return func(n int64) int64 { return n }, nil
+	case tok != "" && tok[0] >= '0' && tok[0] <= '9':
+		val, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(n int64) int64 { return val }, nil
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of plural expression")
+	default:
+		return nil, fmt.Errorf("unexpected '%s' in plural expression", tok)
+	}
+}
+
+// binaryPluralExpr returns the expression applying
+// the given binary operator to left and right.
+func binaryPluralExpr(op string, left, right pluralExpr) pluralExpr {
+	return func(n int64) int64 {
+		l, r := left(n), right(n)
+		switch op {
+		case "||":
+			return boolToInt(l != 0 || r != 0)
+		case "&&":
+			return boolToInt(l != 0 && r != 0)
+		case "==":
+			return boolToInt(l == r)
+		case "!=":
+			return boolToInt(l != r)
+		case "<":
+			return boolToInt(l < r)
+		case "<=":
+			return boolToInt(l <= r)
+		case ">":
+			return boolToInt(l > r)
+		case ">=":
+			return boolToInt(l >= r)
+		case "+":
+			return l + r
+		case "-":
+			return l - r
+		case "*":
+			return l * r
+		case "/":
+			if r == 0 {
+				return 0
+			}
+			return l / r
+		default:
+			if r == 0 {
+				return 0
+			}
+			return l % r
+		}
+	}
+}
+
+// boolToInt returns 1 if b is true and 0 otherwise
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
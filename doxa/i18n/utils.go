@@ -14,13 +14,25 @@ const (
 	LangDirectionRTL LangDirection = "rtl"
 )
 
+// A CurrencyPosition defines where the currency symbol
+// is placed relatively to a monetary amount
+type CurrencyPosition string
+
+const (
+	// CurrencyPositionBefore places the currency symbol before the amount
+	CurrencyPositionBefore CurrencyPosition = "before"
+	// CurrencyPositionAfter places the currency symbol after the amount
+	CurrencyPositionAfter CurrencyPosition = "after"
+)
+
 // LangParameters defines the parameters of a language locale
 type LangParameters struct {
-	DateFormat   string        `json:"date_format"`
-	Direction    LangDirection `json:"lang_direction"`
-	ThousandsSep string        `json:"thousands_sep"`
-	TimeFormat   string        `json:"time_format"`
-	DecimalPoint string        `json:"decimal_point"`
-	ID           int64         `json:"id"`
-	Grouping     string        `json:"grouping"`
+	DateFormat       string           `json:"date_format"`
+	Direction        LangDirection    `json:"lang_direction"`
+	ThousandsSep     string           `json:"thousands_sep"`
+	TimeFormat       string           `json:"time_format"`
+	DecimalPoint     string           `json:"decimal_point"`
+	ID               int64            `json:"id"`
+	Grouping         string           `json:"grouping"`
+	CurrencyPosition CurrencyPosition `json:"currency_position"`
 }
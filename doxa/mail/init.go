@@ -0,0 +1,75 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package mail implements the sending of emails through SMTP servers.
+
+Outgoing SMTP servers are defined as records of the MailServer model. Emails
+are queued with Send, which creates a record of the Mail model, and delivered
+in the background by the job runner, with retries in case of failure. The
+state of each email can be followed on its Mail record.
+
+Only administrators can access the MailServer and Mail records. The password
+of the servers can be written but not read back.
+*/
+package mail
+
+import (
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+var log *logging.Logger
+
+func init() {
+	log = logging.GetLogger("mail")
+
+	mailServer := models.NewModel("MailServer")
+	mailServer.AddFields(map[string]models.FieldDefinition{
+		"Name": models.CharField{Required: true},
+		"Host": models.CharField{Required: true},
+		"Port": models.IntegerField{Default: models.DefaultValue(int64(25))},
+		"Encryption": models.SelectionField{Selection: types.Selection{
+			EncryptionNone:     "None",
+			EncryptionStartTLS: "STARTTLS",
+			EncryptionSSL:      "SSL/TLS",
+		}, Required: true, Default: models.DefaultValue(EncryptionNone)},
+		"User":     models.CharField{Anonymize: models.AnonymizeNull},
+		"Password": models.CharField{NoCopy: true, Anonymize: models.AnonymizeNull},
+		"Sequence": models.IntegerField{Help: "Servers with the lowest sequence are used first"},
+		"Active":   models.BooleanField{Default: models.DefaultValue(true)},
+	})
+	mailServer.RestrictToAdmins()
+	// The password can be set but not read back: it is only read
+	// from the database by serverConfig when sending emails.
+	mailServer.Fields().MustGet("Password").RevokeAccess(security.GroupEveryone, security.Read)
+
+	mail := models.NewModel("Mail")
+	mail.AddFields(map[string]models.FieldDefinition{
+		"Subject":   models.CharField{Anonymize: models.AnonymizeName},
+		"From":      models.CharField{Required: true, Anonymize: models.AnonymizeEmail},
+		"To":        models.TextField{Help: "Comma separated addresses of the recipients", Anonymize: models.AnonymizeEmail},
+		"Cc":        models.TextField{Help: "Comma separated addresses of the carbon copy recipients", Anonymize: models.AnonymizeEmail},
+		"Bcc":       models.TextField{Help: "Comma separated addresses of the blind carbon copy recipients", Anonymize: models.AnonymizeEmail},
+		"ReplyTo":   models.CharField{Anonymize: models.AnonymizeEmail},
+		"Body":      models.HTMLField{Anonymize: models.AnonymizeNull},
+		"MessageID": models.CharField{Index: true, NoCopy: true},
+		"State": models.SelectionField{Selection: types.Selection{
+			StateOutgoing:  "Outgoing",
+			StateSent:      "Sent",
+			StateException: "Delivery Failed",
+			StateCancelled: "Cancelled",
+		}, Required: true, Index: true, Default: models.DefaultValue(StateOutgoing)},
+		"Server": models.Many2OneField{RelationModel: models.Registry.MustGet("MailServer"),
+			Help: "Server through which the email is sent. Defaults to the active server with the lowest sequence"},
+		"ResModel":      models.CharField{Index: true, Help: "Model of the record this email is about"},
+		"ResID":         models.IntegerField{Index: true},
+		"SentDate":      models.DateTimeField{NoCopy: true},
+		"FailureReason": models.TextField{NoCopy: true, Anonymize: models.AnonymizeNull},
+	})
+	mail.RestrictToAdmins()
+
+	models.RegisterJobHandler(mailJob, deliverMail)
+}
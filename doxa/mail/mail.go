@@ -0,0 +1,453 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// mailJob is the name of the job that delivers emails
+const mailJob = "mail"
+
+// States of emails
+const (
+	// StateOutgoing emails are waiting to be sent
+	StateOutgoing = "outgoing"
+	// StateSent emails have been accepted by the SMTP server
+	StateSent = "sent"
+	// StateException emails could not be sent after models.JobMaxAttempts attempts
+	StateException = "exception"
+	// StateCancelled emails are not sent
+	StateCancelled = "cancel"
+)
+
+// Encryptions of the connections to SMTP servers
+const (
+	// EncryptionNone connects to the server in clear text
+	EncryptionNone = "none"
+	// EncryptionStartTLS upgrades the connection to TLS with the STARTTLS command
+	EncryptionStartTLS = "starttls"
+	// EncryptionSSL connects to the server with TLS, usually on port 465
+	EncryptionSSL = "ssl"
+)
+
+var (
+	// ErrNoRecipient is returned by Send for messages without recipients
+	ErrNoRecipient = errors.New("message has no recipient")
+	// ErrNoServer is returned when sending an email while there is no active mail server
+	ErrNoServer = errors.New("no active mail server")
+	// DialTimeout is the timeout of the connections to SMTP servers
+	DialTimeout = 30 * time.Second
+)
+
+// An Attachment is a file attached to a Message
+type Attachment struct {
+	Name     string
+	MimeType string
+	Content  []byte
+}
+
+// A Message is an email to send with Send
+type Message struct {
+	From    string
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	Subject string
+	// Body is the HTML body of the message
+	Body        string
+	Attachments []Attachment
+	// ResModel and ResID are the model and the id
+	// of the record this message is about, if any.
+	ResModel string
+	ResID    int64
+	// ServerID is the id of the MailServer through which the message
+	// is sent. If 0, the active server with the lowest sequence is used.
+	ServerID int64
+}
+
+// A ServerConfig holds the parameters of the connection to an SMTP server
+type ServerConfig struct {
+	Host       string
+	Port       int
+	Encryption string
+	User       string
+	Password   string
+}
+
+// mailDelivery is the payload of a mail delivery job
+type mailDelivery struct {
+	MailID int64 `json:"mail_id"`
+}
+
+// SendSMTP sends the given raw message to the recipients through the
+// SMTP server of the given config. It can be replaced, e.g. in tests.
+var SendSMTP = sendSMTP
+
+// Send queues the given message to be sent in the background and returns
+// its Mail record. Attachments are saved in models.FileStore.
+//
+// The message is created in the transaction of env, so that it is only sent
+// if the transaction is committed. It returns an error if the message has no
+// recipient or if one of its addresses is invalid.
+func Send(env models.Environment, msg Message) (*models.RecordCollection, error) {
+	if len(msg.To)+len(msg.Cc)+len(msg.Bcc) == 0 {
+		return nil, ErrNoRecipient
+	}
+	for _, addresses := range [][]string{{msg.From}, msg.To, msg.Cc, msg.Bcc} {
+		if _, err := parseAddresses(addresses); err != nil {
+			return nil, err
+		}
+	}
+	if msg.ReplyTo != "" {
+		if _, err := netmail.ParseAddress(msg.ReplyTo); err != nil {
+			return nil, fmt.Errorf("invalid address %q: %s", msg.ReplyTo, err)
+		}
+	}
+	values := models.FieldMap{
+		"Subject":   msg.Subject,
+		"From":      msg.From,
+		"To":        strings.Join(msg.To, ", "),
+		"Cc":        strings.Join(msg.Cc, ", "),
+		"Bcc":       strings.Join(msg.Bcc, ", "),
+		"ReplyTo":   msg.ReplyTo,
+		"Body":      msg.Body,
+		"MessageID": newMessageID(msg.From),
+		"ResModel":  msg.ResModel,
+		"ResID":     msg.ResID,
+	}
+	if msg.ServerID != 0 {
+		values["Server"] = msg.ServerID
+	}
+	rec := env.Pool("Mail").Sudo().Call("Create", values).(models.RecordSet).Collection()
+	for _, att := range msg.Attachments {
+		_, err := models.CreateAttachment(rec.Env(), att.Name, att.MimeType, bytes.NewReader(att.Content), "Mail", rec.Ids()[0], "")
+		if err != nil {
+			log.Panic("Unable to save mail attachment", "mail", rec.Ids()[0], "name", att.Name, "error", err)
+		}
+	}
+	models.EnqueueJob(env, mailJob, mailDelivery{MailID: rec.Ids()[0]})
+	return rec, nil
+}
+
+// deliverMail sends the email of the given mail delivery job and updates its
+// state. It returns an error if the email could not be sent, so that the
+// delivery is retried. The email is marked as failed after the last attempt.
+func deliverMail(env models.Environment, job *models.Job) error {
+	var delivery mailDelivery
+	if err := json.Unmarshal(job.Payload, &delivery); err != nil {
+		return err
+	}
+	rc := env.Pool("Mail").Sudo()
+	rec := rc.Search(rc.Model().Field("ID").Equals(delivery.MailID))
+	if rec.IsEmpty() || rec.Get("State").(string) != StateOutgoing {
+		log.Info("Mail deleted or not outgoing anymore, dropping delivery", "mail", delivery.MailID)
+		return nil
+	}
+	err := sendMailRecord(env, rec)
+	if err != nil {
+		log.Info("Mail delivery failed", "mail", delivery.MailID, "attempt", job.Attempt, "error", err)
+		values := models.FieldMap{"FailureReason": err.Error()}
+		if job.Attempt >= int64(models.JobMaxAttempts) {
+			values["State"] = StateException
+		}
+		rec.Call("Write", values)
+		return err
+	}
+	rec.Call("Write", models.FieldMap{"State": StateSent, "SentDate": dates.Now(), "FailureReason": ""})
+	return nil
+}
+
+// sendMailRecord sends the given Mail record through its server
+func sendMailRecord(env models.Environment, rec *models.RecordCollection) error {
+	config, err := serverConfig(env, rec.Get("Server").(models.RecordSet).Collection())
+	if err != nil {
+		return err
+	}
+	attachments, err := mailAttachments(env, rec.Ids()[0])
+	if err != nil {
+		return err
+	}
+	msg := Message{
+		From:        rec.Get("From").(string),
+		To:          splitAddresses(rec.Get("To").(string)),
+		Cc:          splitAddresses(rec.Get("Cc").(string)),
+		Bcc:         splitAddresses(rec.Get("Bcc").(string)),
+		ReplyTo:     rec.Get("ReplyTo").(string),
+		Subject:     rec.Get("Subject").(string),
+		Body:        rec.Get("Body").(string),
+		Attachments: attachments,
+	}
+	raw, err := buildMessage(msg, rec.Get("MessageID").(string), time.Now())
+	if err != nil {
+		return err
+	}
+	recipients, err := parseAddresses(append(append(msg.To, msg.Cc...), msg.Bcc...))
+	if err != nil {
+		return err
+	}
+	from, err := netmail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	return SendSMTP(config, from.Address, recipients, raw)
+}
+
+// serverConfig returns the config of the given MailServer record, or of the
+// active server with the lowest sequence if server is empty.
+func serverConfig(env models.Environment, server *models.RecordCollection) (ServerConfig, error) {
+	if server.IsEmpty() {
+		rc := env.Pool("MailServer").Sudo()
+		server = rc.Search(rc.Model().Field("Active").Equals(true)).OrderBy("Sequence", "ID").Limit(1)
+		if server.IsEmpty() {
+			return ServerConfig{}, ErrNoServer
+		}
+	}
+	server = server.Sudo()
+	// The Password field is not readable, even by administrators
+	var password sql.NullString
+	env.Cr().Get(&password, `SELECT password FROM mail_server WHERE id = ?`, server.Ids()[0])
+	return ServerConfig{
+		Host:       server.Get("Host").(string),
+		Port:       int(server.Get("Port").(int64)),
+		Encryption: server.Get("Encryption").(string),
+		User:       server.Get("User").(string),
+		Password:   password.String,
+	}, nil
+}
+
+// mailAttachments returns the attachments of the Mail record with the
+// given id, read from models.FileStore.
+func mailAttachments(env models.Environment, mailID int64) ([]Attachment, error) {
+	rc := env.Pool("Attachment").Sudo()
+	records := rc.Search(rc.Model().Field("ResModel").Equals("Mail").And().Field("ResID").Equals(mailID)).OrderBy("ID")
+	var res []Attachment
+	for _, rec := range records.Records() {
+		content, err := models.AttachmentContent(env, rec.Ids()[0])
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(content.Path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Attachment{Name: content.Name, MimeType: content.MimeType, Content: data})
+	}
+	return res, nil
+}
+
+// buildMessage returns the given message in the MIME format, with the
+// given Message-ID and date. Bcc recipients are not written in the headers.
+func buildMessage(msg Message, messageID string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	headers := map[string]string{
+		"From":         msg.From,
+		"To":           strings.Join(msg.To, ", "),
+		"Cc":           strings.Join(msg.Cc, ", "),
+		"Reply-To":     msg.ReplyTo,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         date.Format(time.RFC1123Z),
+		"Message-ID":   messageID,
+		"MIME-Version": "1.0",
+	}
+	var names []string
+	for name, value := range headers {
+		if value != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, headers[name])
+	}
+	if len(msg.Attachments) == 0 {
+		fmt.Fprintf(&buf, "Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(part, msg.Body); err != nil {
+		return nil, err
+	}
+	for _, att := range msg.Attachments {
+		mimeType := att.MimeType
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(mimeType, map[string]string{"name": att.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, att.Content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes the given text to w with the quoted-printable encoding
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// writeBase64Lines writes the given data to w base64 encoded
+// in lines of 76 characters, as required by RFC 2045.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if len(encoded) < n {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// parseAddresses returns the email addresses of the given
+// addresses, which may include display names.
+func parseAddresses(addresses []string) ([]string, error) {
+	res := make([]string, len(addresses))
+	for i, address := range addresses {
+		addr, err := netmail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %s", address, err)
+		}
+		res[i] = addr.Address
+	}
+	return res, nil
+}
+
+// splitAddresses returns the addresses of the given comma separated list
+func splitAddresses(list string) []string {
+	var res []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			res = append(res, address)
+		}
+	}
+	return res
+}
+
+// newMessageID returns a new unique Message-ID header value
+// for a message sent from the given address.
+func newMessageID(from string) string {
+	domain := "localhost"
+	if addr, err := netmail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate Message-ID", "error", err)
+	}
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), buf, domain)
+}
+
+// sendSMTP is the default implementation of SendSMTP
+func sendSMTP(config ServerConfig, from string, to []string, msg []byte) error {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+	var conn net.Conn
+	var err error
+	switch config.Encryption {
+	case EncryptionSSL:
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: DialTimeout}, "tcp", addr, tlsConfig)
+	default:
+		conn, err = net.DialTimeout("tcp", addr, DialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if config.Encryption == EncryptionStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if config.User != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.User, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package mail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMessages(t *testing.T) {
+	Convey("Testing the checks of messages", t, func() {
+		var env models.Environment
+		Convey("Messages without recipients should be rejected", func() {
+			_, err := Send(env, Message{From: "admin@example.com", Subject: "Hello"})
+			So(err, ShouldEqual, ErrNoRecipient)
+		})
+		Convey("Messages with invalid addresses should be rejected", func() {
+			_, err := Send(env, Message{From: "admin@example.com", To: []string{"john.example.com"}})
+			So(err, ShouldNotBeNil)
+			_, err = Send(env, Message{To: []string{"john@example.com"}})
+			So(err, ShouldNotBeNil)
+			_, err = Send(env, Message{From: "admin@example.com", To: []string{"john@example.com"}, ReplyTo: "nobody"})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Addresses should be parsed and split", func() {
+			addresses, err := parseAddresses([]string{"John Smith <john@example.com>", "jane@example.com"})
+			So(err, ShouldBeNil)
+			So(addresses, ShouldResemble, []string{"john@example.com", "jane@example.com"})
+			So(splitAddresses(" john@example.com, ,Jane <jane@example.com>"), ShouldResemble,
+				[]string{"john@example.com", "Jane <jane@example.com>"})
+			So(splitAddresses(""), ShouldBeEmpty)
+		})
+		Convey("Message-IDs should be unique and use the domain of the sender", func() {
+			id := newMessageID("Admin <admin@example.com>")
+			So(id, ShouldStartWith, "<")
+			So(id, ShouldEndWith, "@example.com>")
+			So(newMessageID("admin@example.com"), ShouldNotEqual, id)
+		})
+	})
+	Convey("Testing the MIME format of messages", t, func() {
+		msg := Message{
+			From:    "Admin <admin@example.com>",
+			To:      []string{"john@example.com", "jane@example.com"},
+			Bcc:     []string{"boss@example.com"},
+			Subject: "Réunion",
+			Body:    "<p>Bonjour à tous</p>",
+		}
+		date := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+		Convey("Simple messages should be a single HTML part", func() {
+			raw, err := buildMessage(msg, "<1@example.com>", date)
+			So(err, ShouldBeNil)
+			parsed, err := netmail.ReadMessage(bytes.NewReader(raw))
+			So(err, ShouldBeNil)
+			So(parsed.Header.Get("From"), ShouldEqual, "Admin <admin@example.com>")
+			So(parsed.Header.Get("To"), ShouldEqual, "john@example.com, jane@example.com")
+			So(parsed.Header.Get("Bcc"), ShouldBeEmpty)
+			So(parsed.Header.Get("Message-ID"), ShouldEqual, "<1@example.com>")
+			subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			So(subject, ShouldEqual, "Réunion")
+			So(parsed.Header.Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+			body, _ := ioutil.ReadAll(parsed.Body)
+			So(string(body), ShouldContainSubstring, "Bonjour =C3=A0 tous")
+		})
+		Convey("Messages with attachments should be multipart", func() {
+			msg.Attachments = []Attachment{{Name: "report.txt", MimeType: "text/plain", Content: []byte(strings.Repeat("report ", 20))}}
+			raw, err := buildMessage(msg, "<2@example.com>", date)
+			So(err, ShouldBeNil)
+			parsed, err := netmail.ReadMessage(bytes.NewReader(raw))
+			So(err, ShouldBeNil)
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			So(err, ShouldBeNil)
+			So(mediaType, ShouldEqual, "multipart/mixed")
+			mr := multipart.NewReader(parsed.Body, params["boundary"])
+			part, err := mr.NextPart()
+			So(err, ShouldBeNil)
+			So(part.Header.Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+			part, err = mr.NextPart()
+			So(err, ShouldBeNil)
+			So(part.FileName(), ShouldEqual, "report.txt")
+			So(part.Header.Get("Content-Transfer-Encoding"), ShouldEqual, "base64")
+			_, err = mr.NextPart()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMailServerAccess(t *testing.T) {
+	Convey("The password of mail servers should not be readable", t, func() {
+		mailServer := models.Registry.MustGet("MailServer")
+		So(mailServer.FieldReadable(security.SuperUserID, models.FieldName("User")), ShouldBeTrue)
+		So(mailServer.FieldReadable(security.SuperUserID, models.FieldName("Password")), ShouldBeFalse)
+	})
+}
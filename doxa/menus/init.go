@@ -6,6 +6,7 @@ package menus
 import (
 	"github.com/labneco/doxa/doxa/actions"
 	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/models/security"
 	"github.com/labneco/doxa/doxa/tools/logging"
 )
 
@@ -22,6 +23,11 @@ func BootStrap() {
 			}
 			menu.Parent = parentMenu
 		}
+		for _, group := range menu.Groups {
+			if security.Registry.GetGroup(group) == nil {
+				log.Panic("Unknown group in menu", "menu", menu.ID, "group", group)
+			}
+		}
 		var noName bool
 		if menu.ActionID != "" {
 			menu.Action = actions.Registry.MustGetById(menu.ActionID)
@@ -15,12 +15,15 @@
 package menus
 
 import (
+	"path"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/beevik/etree"
 	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models/security"
 )
 
 // Registry is the menu Collection of the application
@@ -78,6 +81,50 @@ func (mc *Collection) GetByID(id string) *Menu {
 	return mc.menusMap[id]
 }
 
+// ForUser returns a copy of this Collection with only the active menus that
+// the user with the given uid is allowed to see. Menus without action are also
+// left out if none of their children can be seen.
+func (mc *Collection) ForUser(uid int64) *Collection {
+	res := NewCollection()
+	res.Menus = mc.forUser(uid, nil, res)
+	return res
+}
+
+// forUser returns copies of the menus of this Collection that the user with the
+// given uid is allowed to see, with the given parent. Copies are registered in the
+// given root collection.
+func (mc *Collection) forUser(uid int64, parent *Menu, root *Collection) []*Menu {
+	mc.RLock()
+	defer mc.RUnlock()
+	var res []*Menu
+	for _, menu := range mc.Menus {
+		if !menu.Active || !menu.IsAllowed(uid) {
+			continue
+		}
+		m := *menu
+		m.Parent = parent
+		m.Children = nil
+		m.HasChildren = false
+		if menu.Children != nil {
+			if children := menu.Children.forUser(uid, &m, root); len(children) > 0 {
+				m.Children = NewCollection()
+				m.Children.Menus = children
+				for _, child := range children {
+					child.ParentCollection = m.Children
+				}
+				m.HasChildren = true
+			}
+		}
+		if menu.HasChildren && !m.HasChildren && !m.HasAction {
+			continue
+		}
+		m.ParentCollection = root
+		root.menusMap[m.ID] = &m
+		res = append(res, &m)
+	}
+	return res
+}
+
 // NewCollection returns a pointer to a new
 // Collection instance
 func NewCollection() *Collection {
@@ -100,7 +147,37 @@ type Menu struct {
 	Action           *actions.Action
 	HasChildren      bool
 	HasAction        bool
-	names            map[string]string
+	// Groups are the ids of the groups allowed to see this menu.
+	// All users can see it if empty.
+	Groups []string
+	// WebIcon is the URL of the image of the icon of this menu
+	WebIcon string
+	// WebIconClass is the CSS class of the font icon of this menu
+	WebIconClass string
+	// Active is false if this menu is hidden to all users
+	Active bool
+	// Attrs are the other attributes of the menu declaration,
+	// which are forwarded to the client.
+	Attrs map[string]string
+	names map[string]string
+}
+
+// IsAllowed returns true if the user with the given uid can see this menu, that
+// is if the user is member of one of its groups, if any, and is allowed to
+// launch its action. The super user can see all menus.
+func (m Menu) IsAllowed(uid int64) bool {
+	if m.Action != nil && !m.Action.IsAllowed(uid) {
+		return false
+	}
+	if len(m.Groups) == 0 || uid == security.SuperUserID {
+		return true
+	}
+	for _, groupID := range m.Groups {
+		if group := security.Registry.GetGroup(groupID); group != nil && security.Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
 }
 
 // TranslatedName returns the translated name of this menu
@@ -119,8 +196,24 @@ func LoadFromEtree(element *etree.Element) {
 	AddMenuToMapFromEtree(element, bootstrapMap)
 }
 
+// menuAttrs are the attributes of menu declarations that are not
+// forwarded to the client as is.
+var menuAttrs = map[string]bool{
+	"id":       true,
+	"action":   true,
+	"name":     true,
+	"parent":   true,
+	"sequence": true,
+	"groups":   true,
+	"web_icon": true,
+	"active":   true,
+}
+
 // AddMenuToMapFromEtree reads the menu from the given element
 // and adds it to the given map.
+//
+// The web_icon attribute is either the path of an image in the static
+// directory of a module, given as 'module,path', or a font icon class.
 func AddMenuToMapFromEtree(element *etree.Element, mMap map[string]*Menu) map[string]*Menu {
 	seq, _ := strconv.Atoi(element.SelectAttrValue("sequence", "10"))
 	menu := Menu{
@@ -129,6 +222,33 @@ func AddMenuToMapFromEtree(element *etree.Element, mMap map[string]*Menu) map[st
 		Name:     element.SelectAttrValue("name", ""),
 		ParentID: element.SelectAttrValue("parent", ""),
 		Sequence: uint8(seq),
+		Active:   true,
+		Attrs:    make(map[string]string),
+	}
+	for _, group := range strings.Split(element.SelectAttrValue("groups", ""), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			menu.Groups = append(menu.Groups, group)
+		}
+	}
+	if icon := element.SelectAttrValue("web_icon", ""); icon != "" {
+		tokens := strings.SplitN(icon, ",", 2)
+		switch {
+		case len(tokens) == 1:
+			menu.WebIconClass = icon
+		case tokens[0] == "" || tokens[1] == "":
+			log.Panic("Menu web icons must be given as 'module,path' or as a font class", "menu", menu.ID, "web_icon", icon)
+		default:
+			menu.WebIcon = path.Join("/static", strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1]))
+		}
+	}
+	switch element.SelectAttrValue("active", "") {
+	case "0", "false", "False":
+		menu.Active = false
+	}
+	for _, attr := range element.Attr {
+		if !menuAttrs[attr.Key] {
+			menu.Attrs[attr.Key] = attr.Value
+		}
 	}
 	mMap[menu.ID] = &menu
 	return mMap
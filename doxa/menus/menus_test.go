@@ -0,0 +1,104 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package menus
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var menuDefs = []string{
+	`<menuitem id="menu_root" name="Root" sequence="1"/>`,
+	`<menuitem id="menu_public" name="Public" parent="menu_root" action="menu_test_public_action" sequence="1"/>`,
+	`<menuitem id="menu_managers" name="Managers" parent="menu_root" action="menu_test_public_action"
+	           groups="menu_test_managers, admin" sequence="2"/>`,
+	`<menuitem id="menu_restricted_action" name="Restricted" parent="menu_root" action="menu_test_admin_action" sequence="3"/>`,
+	`<menuitem id="menu_admin_root" name="Admin Root" groups="admin" sequence="2"/>`,
+	`<menuitem id="menu_admin_child" name="Admin Child" parent="menu_admin_root" action="menu_test_public_action"/>`,
+	`<menuitem id="menu_empty_root" name="Empty Root" sequence="3"/>`,
+	`<menuitem id="menu_empty_child" name="Empty Child" parent="menu_empty_root" action="menu_test_admin_action"/>`,
+	`<menuitem id="menu_icon_root" name="Icon Root" web_icon="base,src/img/icon.png" sequence="4"
+	           data-color="#875A7B" help="Icons"/>`,
+	`<menuitem id="menu_font_child" name="Font Child" parent="menu_icon_root" action="menu_test_public_action"
+	           web_icon="fa fa-calendar" sequence="1"/>`,
+	`<menuitem id="menu_inactive_child" name="Inactive Child" parent="menu_icon_root" action="menu_test_public_action"
+	           active="0" sequence="2"/>`,
+}
+
+func TestMenus(t *testing.T) {
+	managers := security.Registry.NewGroup("menu_test_managers", "Menu Managers")
+	security.Registry.AddMembership(2, managers)
+	actions.Registry.Add(&actions.Action{ID: "menu_test_public_action", Type: actions.ActionClient, Name: "Public", Tag: "public"})
+	actions.Registry.Add(&actions.Action{ID: "menu_test_admin_action", Type: actions.ActionClient, Name: "Admin", Tag: "admin",
+		Groups: []string{security.GroupAdminID}})
+	for _, def := range menuDefs {
+		LoadFromEtree(xmlutils.XMLToElement(def))
+	}
+	BootStrap()
+	Convey("Testing menu groups", t, func() {
+		Convey("Groups should be loaded from XML", func() {
+			So(Registry.GetByID("menu_managers").Groups, ShouldResemble, []string{"menu_test_managers", "admin"})
+			So(Registry.GetByID("menu_public").Groups, ShouldBeEmpty)
+		})
+		Convey("Menus should only be allowed to the members of their groups", func() {
+			So(Registry.GetByID("menu_public").IsAllowed(3), ShouldBeTrue)
+			So(Registry.GetByID("menu_managers").IsAllowed(2), ShouldBeTrue)
+			So(Registry.GetByID("menu_managers").IsAllowed(3), ShouldBeFalse)
+			So(Registry.GetByID("menu_restricted_action").IsAllowed(2), ShouldBeFalse)
+			So(Registry.GetByID("menu_restricted_action").IsAllowed(security.SuperUserID), ShouldBeTrue)
+		})
+		Convey("Filtering menus for a simple user", func() {
+			menus := Registry.ForUser(3)
+			So(menus.Menus, ShouldHaveLength, 2)
+			root := menus.Menus[0]
+			So(root.ID, ShouldEqual, "menu_root")
+			So(root.Children.Menus, ShouldHaveLength, 1)
+			So(root.Children.Menus[0].ID, ShouldEqual, "menu_public")
+			So(root.Children.Menus[0].Parent, ShouldEqual, root)
+			So(menus.GetByID("menu_public"), ShouldEqual, root.Children.Menus[0])
+			So(menus.GetByID("menu_empty_root"), ShouldBeNil)
+			So(Registry.GetByID("menu_root").Children.Menus, ShouldHaveLength, 3)
+		})
+		Convey("Filtering menus for a member of a group", func() {
+			menus := Registry.ForUser(2)
+			So(menus.Menus, ShouldHaveLength, 2)
+			So(menus.Menus[0].Children.Menus, ShouldHaveLength, 2)
+			So(menus.GetByID("menu_managers"), ShouldNotBeNil)
+		})
+		Convey("Filtering menus for the super user", func() {
+			menus := Registry.ForUser(security.SuperUserID)
+			So(menus.Menus, ShouldHaveLength, 4)
+			So(menus.GetByID("menu_admin_child"), ShouldNotBeNil)
+			So(menus.GetByID("menu_empty_child"), ShouldNotBeNil)
+		})
+	})
+	Convey("Testing menu icons and attributes", t, func() {
+		Convey("Web icons should be loaded as static paths or font classes", func() {
+			So(Registry.GetByID("menu_icon_root").WebIcon, ShouldEqual, "/static/base/src/img/icon.png")
+			So(Registry.GetByID("menu_icon_root").WebIconClass, ShouldBeEmpty)
+			So(Registry.GetByID("menu_font_child").WebIcon, ShouldBeEmpty)
+			So(Registry.GetByID("menu_font_child").WebIconClass, ShouldEqual, "fa fa-calendar")
+		})
+		Convey("Other attributes should be forwarded to the client", func() {
+			So(Registry.GetByID("menu_icon_root").Attrs, ShouldResemble, map[string]string{"data-color": "#875A7B", "help": "Icons"})
+			So(Registry.GetByID("menu_font_child").Attrs, ShouldBeEmpty)
+		})
+		Convey("Inactive menus should be hidden to all users", func() {
+			So(Registry.GetByID("menu_font_child").Active, ShouldBeTrue)
+			So(Registry.GetByID("menu_inactive_child").Active, ShouldBeFalse)
+			menus := Registry.ForUser(security.SuperUserID)
+			So(menus.GetByID("menu_icon_root").Children.Menus, ShouldHaveLength, 1)
+			So(menus.GetByID("menu_inactive_child"), ShouldBeNil)
+		})
+		Convey("Invalid web icons should panic", func() {
+			So(func() {
+				LoadFromEtree(xmlutils.XMLToElement(`<menuitem id="menu_bad_icon" name="Bad" web_icon="base,"/>`))
+			}, ShouldPanic)
+		})
+	})
+}
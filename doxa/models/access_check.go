@@ -0,0 +1,236 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// accessOperations maps the operations understood by CheckAccess
+// to the method and the permission they require.
+var accessOperations = map[string]struct {
+	method string
+	perm   security.Permission
+}{
+	"read":   {method: "Load", perm: security.Read},
+	"create": {method: "Create", perm: security.Write},
+	"write":  {method: "Write", perm: security.Write},
+	"unlink": {method: "Unlink", perm: security.Unlink},
+}
+
+// A RuleEvaluation is the result of the evaluation of
+// a record rule on a given record.
+type RuleEvaluation struct {
+	Name      string
+	Group     string
+	Global    bool
+	Condition string
+	// Matches is true if the record satisfies the condition of the rule.
+	// It is always false if no record has been given.
+	Matches bool
+}
+
+// An AccessReport explains how the access of a user to
+// an operation on a model (or one of its records) is evaluated.
+type AccessReport struct {
+	UID       int64
+	Model     string
+	Operation string
+	RecordID  int64
+	// Groups are the IDs of the groups of the user, including inherited groups.
+	Groups []string
+	// Method is the name of the method checked for execution permission.
+	Method string
+	// MethodGroups are the IDs of the groups allowed to execute Method.
+	MethodGroups  []string
+	MethodAllowed bool
+	// DeniedFields are the fields on which the user does not have the
+	// permission required by the operation.
+	DeniedFields []string
+	// Rules are the record rules applying to the user for this operation.
+	Rules []RuleEvaluation
+	// RecordExists is false if the given record does not exist.
+	RecordExists bool
+	// Allowed is the final result of the evaluation and
+	// DeniedBy explains why the access has been denied.
+	Allowed  bool
+	DeniedBy string
+}
+
+// CheckAccess evaluates whether the user with the given uid can perform the
+// given operation on the given model and returns a report explaining the
+// evaluation: groups of the user, method execution permission, field
+// permissions and record rules.
+//
+// operation can be one of "read", "create", "write" or "unlink", or the
+// name of any method of the model. If recordID is not 0, record rules are
+// evaluated on this record.
+func CheckAccess(env Environment, uid int64, modelName, operation string, recordID int64) *AccessReport {
+	model := Registry.MustGet(modelName)
+	report := &AccessReport{
+		UID:       uid,
+		Model:     model.name,
+		Operation: operation,
+		RecordID:  recordID,
+		Method:    operation,
+		Allowed:   true,
+	}
+	var perm security.Permission
+	if op, ok := accessOperations[strings.ToLower(operation)]; ok {
+		report.Method = op.method
+		perm = op.perm
+	}
+	userGroups := security.Registry.UserGroups(uid)
+	for group := range userGroups {
+		report.Groups = append(report.Groups, group.ID)
+	}
+	sort.Strings(report.Groups)
+
+	// Method execution permission
+	method, ok := model.methods.get(report.Method)
+	if !ok {
+		report.Allowed = false
+		report.DeniedBy = fmt.Sprintf("model %s has no method %s", model.name, report.Method)
+		return report
+	}
+	method.RLock()
+	for group := range method.groups {
+		report.MethodGroups = append(report.MethodGroups, group.ID)
+		if _, member := userGroups[group]; member {
+			report.MethodAllowed = true
+		}
+	}
+	method.RUnlock()
+	sort.Strings(report.MethodGroups)
+	if !report.MethodAllowed {
+		report.Allowed = false
+		report.DeniedBy = fmt.Sprintf("none of the user's groups is allowed to execute %s.%s", model.name, report.Method)
+	}
+
+	// Field permissions
+	if fieldPerm := perm & (security.Read | security.Write); fieldPerm != 0 {
+		for name, fi := range model.fields.registryByName {
+			if !checkFieldPermission(fi, uid, fieldPerm) {
+				report.DeniedFields = append(report.DeniedFields, name)
+			}
+		}
+		sort.Strings(report.DeniedFields)
+	}
+
+	// Record rules (which do not apply on creation)
+	if perm == 0 || report.Method == "Create" {
+		return report
+	}
+	var record *RecordCollection
+	if recordID != 0 {
+		rc := env.Pool(model.name).Sudo()
+		// Rules are evaluated one by one below, so we
+		// don't want them to be applied to our queries.
+		rc.filtered = true
+		record = rc.Search(rc.Model().Field("ID").Equals(recordID))
+		report.RecordExists = !record.IsEmpty()
+		if !report.RecordExists && report.Allowed {
+			report.Allowed = false
+			report.DeniedBy = fmt.Sprintf("record %s(%d) does not exist", model.name, recordID)
+		}
+	}
+	// The functions of the conditions of the rules, such as currentUID,
+	// are evaluated in an environment of the checked user.
+	userRecords := env.Pool(model.name).Sudo(uid)
+	evaluate := func(rule *RecordRule, groupID string) RuleEvaluation {
+		eval := RuleEvaluation{
+			Name:      rule.Name,
+			Group:     groupID,
+			Global:    rule.Global,
+			Condition: rule.Condition.String(),
+		}
+		if report.RecordExists {
+			cond := *rule.Condition
+			cond.evaluateArgFunctions(userRecords)
+			eval.Matches = !record.Search(&cond).IsEmpty()
+		}
+		return eval
+	}
+	model.rulesRegistry.RLock()
+	defer model.rulesRegistry.RUnlock()
+	var groupRules, groupRuleMatched bool
+	for _, rule := range model.rulesRegistry.globalRules {
+		if perm&rule.Perms == 0 {
+			continue
+		}
+		eval := evaluate(rule, "")
+		report.Rules = append(report.Rules, eval)
+		if report.RecordExists && !eval.Matches && report.Allowed {
+			report.Allowed = false
+			report.DeniedBy = fmt.Sprintf("record does not match global rule %s", rule.Name)
+		}
+	}
+	for group := range userGroups {
+		for _, rule := range model.rulesRegistry.rulesByGroup[group.ID] {
+			if perm&rule.Perms == 0 {
+				continue
+			}
+			eval := evaluate(rule, group.ID)
+			report.Rules = append(report.Rules, eval)
+			groupRules = true
+			groupRuleMatched = groupRuleMatched || eval.Matches
+		}
+	}
+	if report.RecordExists && groupRules && !groupRuleMatched && report.Allowed {
+		report.Allowed = false
+		report.DeniedBy = "record does not match any of the rules of the user's groups"
+	}
+	return report
+}
+
+// String returns a human readable explanation of this report
+func (ar *AccessReport) String() string {
+	var buf bytes.Buffer
+	target := ar.Model
+	if ar.RecordID != 0 {
+		target = fmt.Sprintf("%s(%d)", ar.Model, ar.RecordID)
+	}
+	fmt.Fprintf(&buf, "Access check: user %d, operation '%s' on %s\n", ar.UID, ar.Operation, target)
+	fmt.Fprintf(&buf, "\nUser groups: %s\n", strings.Join(ar.Groups, ", "))
+	fmt.Fprintf(&buf, "\nMethod %s: ", ar.Method)
+	if ar.MethodAllowed {
+		buf.WriteString("allowed")
+	} else {
+		buf.WriteString("denied")
+	}
+	fmt.Fprintf(&buf, " (allowed groups: %s)\n", strings.Join(ar.MethodGroups, ", "))
+	if len(ar.DeniedFields) > 0 {
+		fmt.Fprintf(&buf, "\nFields without access (silently ignored): %s\n", strings.Join(ar.DeniedFields, ", "))
+	}
+	if len(ar.Rules) > 0 {
+		buf.WriteString("\nRecord rules:\n")
+		for _, rule := range ar.Rules {
+			scope := "global"
+			if !rule.Global {
+				scope = "group " + rule.Group
+			}
+			fmt.Fprintf(&buf, "- %s (%s): %s", rule.Name, scope, rule.Condition)
+			if ar.RecordExists {
+				if rule.Matches {
+					buf.WriteString(" => matches")
+				} else {
+					buf.WriteString(" => does not match")
+				}
+			}
+			buf.WriteString("\n")
+		}
+	}
+	buf.WriteString("\nResult: ")
+	if ar.Allowed {
+		buf.WriteString("ACCESS GRANTED\n")
+	} else {
+		fmt.Fprintf(&buf, "ACCESS DENIED: %s\n", ar.DeniedBy)
+	}
+	return buf.String()
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/tools/strutils"
+)
+
+// Anonymization strategies, to be set as the Anonymize parameter of fields
+// holding personal data.
+const (
+	// AnonymizeName replaces the value with the model name followed by the record id,
+	// e.g. "Partner 42". It can only be set on char, text and html fields.
+	AnonymizeName = "name"
+	// AnonymizeEmail replaces the value with an email address made of the model name
+	// and the record id, e.g. "partner.42@example.com". It can only be set on char,
+	// text and html fields.
+	AnonymizeEmail = "email"
+	// AnonymizeNull replaces the value with NULL. It cannot be set on required fields.
+	AnonymizeNull = "null"
+)
+
+// checkAnonymize panics if the Anonymize parameter of the given field is not valid
+func checkAnonymize(fi *Field) {
+	switch fi.anonymize {
+	case AnonymizeName, AnonymizeEmail:
+		switch fi.fieldType {
+		case fieldtype.Char, fieldtype.Text, fieldtype.HTML:
+		default:
+			log.Panic("Anonymize strategy can only be set on char, text and html fields", "model", fi.model.name,
+				"field", fi.name, "type", fi.fieldType, "anonymize", fi.anonymize)
+		}
+	case AnonymizeNull:
+		if fi.required {
+			log.Panic("Required fields cannot be anonymized with NULL", "model", fi.model.name, "field", fi.name)
+		}
+	default:
+		log.Panic("Unknown anonymize strategy", "model", fi.model.name, "field", fi.name, "anonymize", fi.anonymize)
+	}
+}
+
+// checkAnonymizedFields checks the Anonymize parameter of all the fields of
+// the registry. It is called at bootstrap, once the field updates (such as
+// SetAnonymize or SetRequired) have been applied.
+func checkAnonymizedFields() {
+	for _, model := range Registry.registryByName {
+		for _, fi := range model.fields.registryByName {
+			if fi.anonymize != "" {
+				checkAnonymize(fi)
+			}
+		}
+	}
+}
+
+// anonymizedValueSQL returns the SQL expression of the anonymized value of the given field.
+// Values that are NULL are kept NULL.
+func anonymizedValueSQL(fi *Field) string {
+	var expr string
+	switch fi.anonymize {
+	case AnonymizeNull:
+		return "NULL"
+	case AnonymizeName:
+		expr = fmt.Sprintf("'%s ' || id", fi.model.name)
+	case AnonymizeEmail:
+		expr = fmt.Sprintf("'%s.' || id || '@example.com'", strutils.SnakeCaseString(fi.model.name))
+	}
+	if fi.size > 0 {
+		expr = fmt.Sprintf("LEFT(%s, %d)", expr, fi.size)
+	}
+	return fmt.Sprintf("CASE WHEN %s IS NULL THEN NULL ELSE %s END", fi.json, expr)
+}
+
+// Anonymize replaces the values of the fields that have an Anonymize parameter
+// in all the records of the database. It returns the number of anonymized
+// records by model name.
+//
+// Values are replaced with a single SQL query per model, bypassing the methods
+// of the models and the computation of the fields depending on the anonymized
+// fields. It is meant to scramble the personal data of a copy of a production
+// database, so that it can be used for development.
+func Anonymize(env Environment) map[string]int64 {
+	adapter := adapters[db.DriverName()]
+	res := make(map[string]int64)
+	for _, model := range Registry.registryByName {
+		if model.isMixin() || model.isManual() {
+			continue
+		}
+		var sets []string
+		for _, fi := range model.fields.registryByName {
+			if fi.anonymize == "" || !fi.isStored() {
+				continue
+			}
+			sets = append(sets, fmt.Sprintf("%s = %s", fi.json, anonymizedValueSQL(fi)))
+		}
+		if len(sets) == 0 {
+			continue
+		}
+		sort.Strings(sets)
+		query := fmt.Sprintf("UPDATE %s SET %s", adapter.quoteTableName(model.tableName), strings.Join(sets, ", "))
+		num, _ := env.cr.Execute(query).RowsAffected()
+		res[model.name] = num
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// declareAPIKeyModel declares the APIKey model which stores the API keys
+// that users can create for server-to-server integrations.
+//
+// Keys are never stored in clear: only their hash and a short prefix
+// to identify them are kept in the database. Users can only see their
+// own keys and the hash can only be read by administrators.
+func declareAPIKeyModel() {
+	apiKey := NewModel("APIKey")
+	apiKey.AddFields(map[string]FieldDefinition{
+		"Name":           CharField{Required: true},
+		"UserID":         IntegerField{Required: true, Index: true},
+		"Prefix":         CharField{Index: true, NoCopy: true},
+		"KeyHash":        CharField{Required: true, Unique: true, NoCopy: true},
+		"Scopes":         CharField{Help: "Comma separated list of scopes granted to this key"},
+		"ExpirationDate": DateTimeField{Help: "Date after which this key is no longer valid. Leave empty for no expiration"},
+		"LastUsed":       DateTimeField{NoCopy: true},
+		"Active":         BooleanField{Default: DefaultValue(true)},
+	})
+	apiKey.RestrictToOwner("UserID")
+	apiKey.RestrictFieldsToAdmins("KeyHash")
+}
+
+// NewAPIKey creates a new API key with the given name and scopes for the user
+// with the given uid. If expiration is not the zero value, the key will be
+// rejected after this date.
+//
+// It returns the key in clear. It must be communicated to the user at once
+// since it cannot be retrieved afterwards.
+func NewAPIKey(env Environment, uid int64, name string, scopes security.APIScopes, expiration dates.DateTime) string {
+	key := security.GenerateAPIKey()
+	values := FieldMap{
+		"Name":    name,
+		"UserID":  uid,
+		"Prefix":  security.APIKeyPrefix(key),
+		"KeyHash": security.HashAPIKey(key),
+		"Scopes":  scopes.String(),
+	}
+	if !expiration.IsZero() {
+		values["ExpirationDate"] = expiration
+	}
+	env.Pool("APIKey").Sudo().Call("Create", values)
+	return key
+}
+
+// RevokeAPIKeys deactivates all the API keys of the user with the given uid.
+func RevokeAPIKeys(env Environment, uid int64) {
+	rc := env.Pool("APIKey").Sudo()
+	keys := rc.Search(rc.Model().Field("UserID").Equals(uid))
+	if keys.IsEmpty() {
+		return
+	}
+	keys.Call("Write", FieldMap{"Active": false})
+}
+
+// AuthenticateAPIKey checks the given API key and returns the uid of its
+// owner together with the scopes granted to the key.
+//
+// It returns a security.InvalidAPIKeyError if the key is unknown,
+// revoked or expired, or if its owner has been archived.
+func AuthenticateAPIKey(key string) (int64, security.APIScopes, error) {
+	var (
+		uid    int64
+		scopes security.APIScopes
+		found  bool
+	)
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("APIKey").Sudo()
+		cond := rc.Model().Field("KeyHash").Equals(security.HashAPIKey(key)).
+			And().Field("Active").Equals(true)
+		keyRec := rc.Search(cond).Limit(1)
+		if keyRec.IsEmpty() {
+			return
+		}
+		expiration := keyRec.Get("ExpirationDate").(dates.DateTime)
+		if !expiration.IsZero() && expiration.Lower(dates.Now()) {
+			return
+		}
+		keyRec.Call("Write", FieldMap{"LastUsed": dates.Now()})
+		uid = keyRec.Get("UserID").(int64)
+		scopes = security.ParseAPIScopes(keyRec.Get("Scopes").(string))
+		found = true
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if !found || !UserActive(uid) {
+		return 0, nil, security.InvalidAPIKeyError(security.APIKeyPrefix(key))
+	}
+	return uid, scopes, nil
+}
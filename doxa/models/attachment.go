@@ -0,0 +1,235 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/filestore"
+)
+
+// FileStore is the store in which the content of attachments is saved.
+// It is set at startup from the configuration.
+var FileStore *filestore.Store
+
+// ErrAttachmentNotAllowed is returned when the user is not allowed to
+// access the record or the field to which an attachment is linked.
+var ErrAttachmentNotAllowed = errors.New("access to the attached record is not allowed")
+
+// ErrNoContent is returned when an attachment or a binary field has no content
+var ErrNoContent = errors.New("no content")
+
+// A BinaryContent is the content of an attachment or of a binary field,
+// with the metadata needed to serve it over HTTP.
+//
+// The content is either in the file store at Path
+// or in Data for binary fields stored in the database.
+type BinaryContent struct {
+	Name     string
+	MimeType string
+	// Checksum is the SHA1 checksum of the content
+	Checksum string
+	Size     int64
+	Path     string
+	Data     []byte
+}
+
+// declareAttachmentModel declares the Attachment model which holds the
+// metadata of files whose content is saved in the FileStore.
+//
+// An attachment can be linked to a record (ResModel and ResID) and to a
+// binary field of this record (ResField), in which case its content is
+// the content of the field.
+//
+// Ordinary users can only read public attachments through the ORM. The
+// content of the others is reached with AttachmentContent and FieldContent,
+// which check the access to the linked record.
+func declareAttachmentModel() {
+	attachment := NewModel("Attachment")
+	attachment.AddFields(map[string]FieldDefinition{
+		"Name":     CharField{Required: true},
+		"MimeType": CharField{},
+		"FileSize": IntegerField{},
+		"Checksum": CharField{Index: true, NoCopy: true, Help: "SHA1 checksum of the content in the file store"},
+		"ResModel": CharField{Index: true},
+		"ResID":    IntegerField{Index: true},
+		"ResField": CharField{},
+		"Public":   BooleanField{Help: "Public attachments can be downloaded by anyone"},
+	})
+	attachment.AddRecordRule(&RecordRule{
+		Name:      "AttachmentPublic",
+		Group:     security.GroupEveryone,
+		Condition: attachment.Field("Public").Equals(true),
+		Perms:     security.Read,
+	})
+	attachment.AddRecordRule(adminRecordRule(attachment))
+}
+
+// CheckAttachmentAccess returns ErrAttachmentNotAllowed if the user of env
+// cannot perform the given operation ("read" or "write") on the field
+// resField of the record resModel(resID). resField may be empty.
+func CheckAttachmentAccess(env Environment, resModel string, resID int64, resField, operation string) error {
+	if _, ok := Registry.Get(resModel); !ok || resID == 0 {
+		return ErrAttachmentNotAllowed
+	}
+	if !CheckAccess(env, env.uid, resModel, operation, resID).Allowed && env.uid != security.SuperUserID {
+		return ErrAttachmentNotAllowed
+	}
+	if resField == "" {
+		return nil
+	}
+	fi, ok := Registry.MustGet(resModel).fields.Get(resField)
+	if !ok {
+		return ErrAttachmentNotAllowed
+	}
+	var perm security.Permission = security.Read
+	if operation == "write" {
+		perm = security.Write
+	}
+	if !checkFieldPermission(fi, env.uid, perm) && env.uid != security.SuperUserID {
+		return ErrAttachmentNotAllowed
+	}
+	return nil
+}
+
+// CreateAttachment streams content into the FileStore and creates an
+// attachment with the given name and mime type, linked to the field
+// resField of the record resModel(resID). It returns the new attachment.
+//
+// The user of env must be allowed to write the field of the record.
+// Any previous attachment of this field is deleted.
+func CreateAttachment(env Environment, name, mimeType string, content io.Reader, resModel string, resID int64, resField string) (*RecordCollection, error) {
+	if err := CheckAttachmentAccess(env, resModel, resID, resField, "write"); err != nil {
+		return nil, err
+	}
+	checksum, size, err := FileStore.Write(content)
+	if err != nil {
+		return nil, err
+	}
+	return LinkAttachment(env, name, mimeType, checksum, size, resModel, resID, resField)
+}
+
+// LinkAttachment creates an attachment for the content with the given
+// checksum and size, which must have already been written to the FileStore.
+// The attachment is linked to the field resField of the record
+// resModel(resID).
+//
+// It is meant for content that is streamed to the FileStore outside of a
+// transaction, such as uploads. See CreateAttachment for details.
+func LinkAttachment(env Environment, name, mimeType, checksum string, size int64, resModel string, resID int64, resField string) (*RecordCollection, error) {
+	if err := CheckAttachmentAccess(env, resModel, resID, resField, "write"); err != nil {
+		return nil, err
+	}
+	if _, err := FileStore.Path(checksum); err != nil {
+		return nil, err
+	}
+	rc := env.Pool("Attachment").Sudo()
+	if resField != "" {
+		previous := rc.Search(rc.Model().Field("ResModel").Equals(resModel).
+			And().Field("ResID").Equals(resID).
+			And().Field("ResField").Equals(resField))
+		if !previous.IsEmpty() {
+			previous.Call("Unlink")
+		}
+	}
+	attachment := rc.Call("Create", FieldMap{
+		"Name":     name,
+		"MimeType": mimeType,
+		"FileSize": size,
+		"Checksum": checksum,
+		"ResModel": resModel,
+		"ResID":    resID,
+		"ResField": resField,
+	}).(RecordSet).Collection()
+	log.Info("Attachment created", "id", attachment.ids[0], "model", resModel, "record", resID, "field", resField, "size", size)
+	return attachment, nil
+}
+
+// attachmentContent returns the BinaryContent of the given attachment record
+func attachmentContent(attachment *RecordCollection) (*BinaryContent, error) {
+	checksum := attachment.Get("Checksum").(string)
+	path, err := FileStore.Path(checksum)
+	if err != nil {
+		return nil, ErrNoContent
+	}
+	return &BinaryContent{
+		Name:     attachment.Get("Name").(string),
+		MimeType: attachment.Get("MimeType").(string),
+		Checksum: checksum,
+		Size:     attachment.Get("FileSize").(int64),
+		Path:     path,
+	}, nil
+}
+
+// AttachmentContent returns the content of the attachment with the given id.
+//
+// Public attachments can be read by anyone. Attachments linked to a record
+// can be read by the users who can read the record and field. Other
+// attachments can only be read by administrators.
+func AttachmentContent(env Environment, id int64) (*BinaryContent, error) {
+	rc := env.Pool("Attachment").Sudo()
+	attachment := rc.Search(rc.Model().Field("ID").Equals(id))
+	if attachment.IsEmpty() {
+		return nil, ErrNoContent
+	}
+	switch {
+	case attachment.Get("Public").(bool):
+	case attachment.Get("ResModel").(string) != "":
+		err := CheckAttachmentAccess(env, attachment.Get("ResModel").(string), attachment.Get("ResID").(int64),
+			attachment.Get("ResField").(string), "read")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if !CheckAccess(env, env.uid, "Attachment", "read", id).Allowed && env.uid != security.SuperUserID {
+			return nil, ErrAttachmentNotAllowed
+		}
+	}
+	return attachmentContent(attachment)
+}
+
+// FieldContent returns the content of the binary field of the given record,
+// which is either the attachment of the field or the value of the field in
+// the database. The user of env must be allowed to read the field.
+func FieldContent(env Environment, modelName string, id int64, field string) (*BinaryContent, error) {
+	if err := CheckAttachmentAccess(env, modelName, id, field, "read"); err != nil {
+		return nil, err
+	}
+	rc := env.Pool("Attachment").Sudo()
+	attachment := rc.Search(rc.Model().Field("ResModel").Equals(modelName).
+		And().Field("ResID").Equals(id).
+		And().Field("ResField").Equals(field))
+	if !attachment.IsEmpty() {
+		return attachmentContent(attachment.Records()[0])
+	}
+	fi := Registry.MustGet(modelName).fields.MustGet(field)
+	if fi.fieldType != fieldtype.Binary {
+		return nil, ErrNoContent
+	}
+	rec := env.Pool(modelName).Sudo()
+	rec = rec.Search(rec.Model().Field("ID").Equals(id))
+	if rec.IsEmpty() {
+		return nil, ErrNoContent
+	}
+	value, _ := rec.Get(fi.name).(string)
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, ErrNoContent
+	}
+	hash := sha1.Sum(data)
+	return &BinaryContent{
+		Name:     fi.name,
+		MimeType: http.DetectContentType(data),
+		Checksum: hex.EncodeToString(hash[:]),
+		Size:     int64(len(data)),
+		Data:     data,
+	}, nil
+}
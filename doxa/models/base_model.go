@@ -77,10 +77,11 @@ func declareModelMixin() {
 	modelMixin.AddFields(map[string]FieldDefinition{
 		"DoxaExternalID": CharField{Unique: true, Index: true, NoCopy: true, Required: true,
 			Default: func(env Environment) interface{} {
-				return fmt.Sprintf("__doxa_external_id__%d", idSeq.NextValue())
+				return fmt.Sprintf("%s%d", autoExternalIDPrefix, env.nextSequenceValue(idSeq))
 			},
 		},
-		"DoxaVersion": IntegerField{GoType: new(int)},
+		"DoxaVersion":  IntegerField{GoType: new(int)},
+		"DoxaNoUpdate": BooleanField{NoCopy: true},
 	})
 	modelMixin.InheritModel(Registry.MustGet("BaseMixin"))
 }
@@ -251,11 +252,13 @@ func declareRecordSetMethods() {
 			res := rc.model.FieldsGet(fields...)
 
 			// Translate attributes when required
-			lang := rc.Env().Context().GetString("lang")
-			for fieldName, fInfo := range res {
-				res[fieldName].Help = i18n.Registry.TranslateFieldHelp(lang, rc.model.name, fieldName, fInfo.Help)
-				res[fieldName].String = i18n.Registry.TranslateFieldDescription(lang, rc.model.name, fieldName, fInfo.String)
-				res[fieldName].Selection = i18n.Registry.TranslateFieldSelection(lang, rc.model.name, fieldName, fInfo.Selection)
+			lang := rc.Env().Lang()
+			for fieldJSON, fInfo := range res {
+				// Translations are indexed by the field names, not the JSON names of the result
+				fieldName := rc.model.fields.MustGet(fieldJSON).name
+				res[fieldJSON].Help = i18n.Registry.TranslateFieldHelp(lang, rc.model.name, fieldName, fInfo.Help)
+				res[fieldJSON].String = i18n.Registry.TranslateFieldDescription(lang, rc.model.name, fieldName, fInfo.String)
+				res[fieldJSON].Selection = i18n.Registry.TranslateFieldSelection(lang, rc.model.name, fieldName, fInfo.Selection)
 			}
 			return res
 		}).AllowGroup(security.GroupEveryone)
@@ -335,7 +338,7 @@ func declareRecordSetSpecificMethods() {
 			rc.model.convertValuesToFieldType(&values)
 			retValues := make(FieldMap)
 
-			SimulateInNewEnvironment(rc.Env().Uid(), func(env Environment) {
+			onchange := func(env Environment) {
 				rs := env.Pool(rc.ModelName())
 				// Tweaks for Onchange to work on creation with empty
 				// RecordSet with ID = 0
@@ -360,7 +363,12 @@ func declareRecordSetSpecificMethods() {
 					values.MergeWith(val, rs.model)
 					retValues.MergeWith(val, rs.model)
 				}
-			})
+			}
+			if rc.env.memory != nil {
+				simulateInMockEnvironment(*rc.env, onchange)
+			} else {
+				SimulateInNewEnvironment(rc.Env().Uid(), onchange)
+			}
 			retValues.RemovePK()
 			return OnchangeResult{
 				Value: retValues,
@@ -536,6 +544,13 @@ func declareEnvironmentMethods() {
 			return rc.WithNewContext(context)
 		}).AllowGroup(security.GroupEveryone)
 
+	commonMixin.AddMethod("WithLang",
+		`WithLang returns a copy of the current RecordSet
+		with its Environment set to the given language.`,
+		func(rc *RecordCollection, lang string) *RecordCollection {
+			return rc.WithLang(lang)
+		}).AllowGroup(security.GroupEveryone)
+
 	commonMixin.AddMethod("Sudo",
 		`Sudo returns a new RecordSet with the given userID
 	 	or the superuser ID if not specified`,
@@ -581,6 +596,9 @@ type FieldInfo struct {
 	Domain           interface{}            `json:"domain"`
 	OnChange         bool                   `json:"-"`
 	ReverseFK        string                 `json:"-"`
+	Unique           bool                   `json:"-"`
+	Size             int                    `json:"-"`
+	Embed            bool                   `json:"-"`
 }
 
 // FieldsGetArgs is the args struct for the FieldsGet method
@@ -15,7 +15,10 @@
 package models
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/labneco/doxa/doxa/models/fieldtype"
 	"github.com/labneco/doxa/doxa/models/security"
@@ -51,6 +54,7 @@ func BootStrap() {
 	processDepends()
 	checkFieldMethodsExist()
 	checkComputeMethodsSignature()
+	checkAnonymizedFields()
 	setupSecurity()
 }
 
@@ -346,6 +350,48 @@ func SyncDatabase() {
 	}
 }
 
+// SchemaFingerprint returns a hash of the database schema that SyncDatabase
+// creates for the models of the registry with the given driver: sequences,
+// tables, columns, indexes and constraints.
+//
+// Two registries with the same fingerprint result in the same schema, so that
+// it can be used to check whether an existing database is up to date with the
+// models without connecting to it. BootStrap must have been called first.
+func SchemaFingerprint(driver string) string {
+	adapter, ok := adapters[driver]
+	if !ok {
+		log.Panic("Unknown database driver", "driver", driver)
+	}
+	var lines []string
+	for _, sequence := range Registry.sequences {
+		lines = append(lines, fmt.Sprintf("sequence %s", sequence.JSON))
+	}
+	for tableName, model := range Registry.registryByTableName {
+		if model.isMixin() || model.isManual() {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("table %s", tableName))
+		for colName, fi := range model.fields.registryByJSON {
+			if colName == "id" || !fi.isStored() {
+				continue
+			}
+			line := fmt.Sprintf("column %s.%s %s", tableName, colName, adapter.columnSQLDefinition(fi))
+			if fi.index {
+				line += " INDEX"
+			}
+			if fi.fieldType.IsFKRelationType() {
+				line += fmt.Sprintf(" REFERENCES %s ON DELETE %s", fi.relatedModel.tableName, fi.onDelete)
+			}
+			lines = append(lines, line)
+		}
+		for constraintName, constraint := range model.sqlConstraints {
+			lines = append(lines, fmt.Sprintf("constraint %s.%s %s", tableName, constraintName, constraint.sql))
+		}
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(lines, "\n"))))
+}
+
 // buildSQLErrorSubstitutionMap populates the sqlErrors map of the
 // model with the appropriate error message substitution
 func buildSQLErrorSubstitutionMap(model *Model) {
@@ -626,9 +672,16 @@ func bootStrapMethods() {
 // setupSecurity adds execution permission to:
 // - the admin group for all methods
 // - all methods of a model for groups that have been granted all rights
+//
+// It also revokes the permissions inherited from mixins on the methods
+// of models restricted to administrators.
 func setupSecurity() {
 	for _, model := range Registry.registryByName {
 		for _, meth := range model.methods.registry {
+			if model.methods.adminOnly {
+				meth.groups = make(map[*security.Group]bool)
+				meth.groupsCallers = make(map[callerGroup]bool)
+			}
 			meth.groups[security.GroupAdmin] = true
 			for group := range model.methods.powerGroups {
 				meth.groups[group] = true
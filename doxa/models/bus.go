@@ -0,0 +1,401 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/lib/pq"
+)
+
+// busNotifyChannel is the PostgreSQL channel on which
+// the server workers are notified of new bus messages.
+const busNotifyChannel = "doxa_bus"
+
+// Bus relay modes
+const (
+	// BusRelayNotify relays bus messages as soon as they are
+	// notified by the database with LISTEN/NOTIFY.
+	BusRelayNotify = "notify"
+	// BusRelayPoll relays bus messages by polling the database.
+	BusRelayPoll = "poll"
+)
+
+// busRelayStop is closed to stop the bus relay
+var busRelayStop chan struct{}
+
+var (
+	// BusGarbageCollection enables the periodic deletion of old bus messages
+	// by the bus relay. It can be disabled on processes where another process
+	// takes care of it.
+	BusGarbageCollection = true
+	// BusMessageTimeout is the duration during which bus messages are kept in
+	// the database so that clients can fetch the messages they missed.
+	BusMessageTimeout = time.Hour
+	// BusPresenceTimeout is the duration after which a user who has not
+	// been connected to the bus is considered offline.
+	BusPresenceTimeout = time.Minute
+	// BusGapTimeout is the duration during which the bus relay waits for
+	// the messages with IDs lower than the last relayed message, whose
+	// transactions were not committed yet when it was relayed.
+	BusGapTimeout = time.Minute
+)
+
+// maxBusGaps is the maximum number of missing message IDs
+// tracked by the bus relay.
+const maxBusGaps = 1000
+
+// A RecordChange is the message sent on the channel of a
+// model when records of this model are modified.
+//
+// It does not hold the ids of the records nor the user who modified them,
+// since the channel of a model is subscribed by all the users who can
+// read the model, whatever the records their record rules give them.
+// Clients reload the records they display with their own rights.
+type RecordChange struct {
+	Model     string `json:"model"`
+	Operation string `json:"operation"`
+}
+
+// declareBusModels declares the BusMessage model which stores the messages
+// sent on the bus until they are relayed by all server workers, and the
+// BusPresence model which holds the last time each user was connected.
+//
+// Bus messages and presences can only be read by administrators, since
+// users may only receive the messages of the channels they can subscribe to
+// and the presence of the users they can read. The bus reads and writes
+// them with Sudo.
+func declareBusModels() {
+	busMessage := NewModel("BusMessage")
+	busMessage.AddFields(map[string]FieldDefinition{
+		"Channel": CharField{Required: true, Index: true},
+		"Message": TextField{Help: "JSON encoded message"},
+	})
+	busMessage.RestrictToAdmins()
+
+	busPresence := NewModel("BusPresence")
+	busPresence.AddFields(map[string]FieldDefinition{
+		"UserID":       IntegerField{Required: true, Unique: true},
+		"LastPresence": DateTimeField{Required: true},
+	})
+	busPresence.RestrictToAdmins()
+}
+
+// ModelChannel returns the bus channel on which changes
+// of the records of the given model are sent.
+func ModelChannel(modelName string) string {
+	return "model:" + modelName
+}
+
+// EnableBusNotifications makes this model send a RecordChange message on
+// its ModelChannel each time records are created, updated or deleted.
+func (m *Model) EnableBusNotifications() {
+	m.busNotify = true
+}
+
+// notifyBusChanges sends a RecordChange message for the given operation
+// if bus notifications are enabled and ids is not empty.
+func (rc *RecordCollection) notifyBusChanges(operation string, ids []int64) {
+	if !rc.model.busNotify || len(ids) == 0 {
+		return
+	}
+	SendBus(*rc.env, ModelChannel(rc.model.name), RecordChange{
+		Model:     rc.model.name,
+		Operation: operation,
+	})
+}
+
+// SendBus sends the given message on the given bus channel.
+// message must be JSON serializable.
+//
+// The message is stored in the transaction of env, so that it is only
+// delivered to the subscribers if the transaction is committed.
+func SendBus(env Environment, channel string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Panic("Unable to marshal bus message", "channel", channel, "error", err)
+	}
+	env.Pool("BusMessage").Sudo().Call("Create", FieldMap{
+		"Channel": channel,
+		"Message": string(data),
+	})
+	if env.memory != nil {
+		// Mock environments have no transaction to notify
+		return
+	}
+	// Notifications are delivered by PostgreSQL on commit
+	env.cr.Execute("NOTIFY " + busNotifyChannel)
+}
+
+// BusMessages returns the messages sent on the given channels
+// with an ID greater than lastID, ordered by ID.
+// If channels is nil, messages of all channels are returned.
+func BusMessages(env Environment, channels []string, lastID int64) []bus.Message {
+	rc := env.Pool("BusMessage").Sudo()
+	cond := rc.Model().Field("ID").Greater(lastID)
+	if channels != nil {
+		cond = cond.And().Field("Channel").In(channels)
+	}
+	return searchBusMessages(rc, cond)
+}
+
+// searchBusMessages returns the bus messages of rc
+// matching the given condition, ordered by ID.
+func searchBusMessages(rc *RecordCollection, cond *Condition) []bus.Message {
+	var res []bus.Message
+	for _, rec := range rc.Search(cond).OrderBy("ID").Records() {
+		res = append(res, bus.Message{
+			ID:      rec.Get("ID").(int64),
+			Channel: rec.Get("Channel").(string),
+			Message: json.RawMessage(rec.Get("Message").(string)),
+		})
+	}
+	return res
+}
+
+// LastBusMessageID returns the ID of the last message sent on the bus
+func LastBusMessageID(env Environment) int64 {
+	last := env.Pool("BusMessage").Sudo().SearchAll().OrderBy("ID DESC").Limit(1)
+	if last.IsEmpty() {
+		return 0
+	}
+	return last.Get("ID").(int64)
+}
+
+// StartBusRelay starts relaying the messages sent on the bus by any server
+// worker to the subscribers of this process with bus.Dispatch.
+//
+// In BusRelayNotify mode, messages are relayed as soon as the database
+// notifies that they have been committed, and the database is only polled
+// every minute in case a notification has been missed. In BusRelayPoll
+// mode, the database is polled every pollInterval.
+//
+// Messages older than BusMessageTimeout are regularly deleted
+// if BusGarbageCollection is true.
+func StartBusRelay(mode string, pollInterval time.Duration) {
+	var lastID int64
+	ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		lastID = LastBusMessageID(env)
+	})
+	cursor := NewBusCursor(lastID)
+	var notify <-chan *pq.Notification
+	var listener *pq.Listener
+	if mode == BusRelayNotify {
+		listener = pq.NewListener(dbConnData, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Warn("Bus listener error", "event", ev, "error", err)
+			}
+		})
+		if err := listener.Listen(busNotifyChannel); err != nil {
+			log.Panic("Unable to listen to bus notifications", "error", err)
+		}
+		notify = listener.Notify
+		pollInterval = time.Minute
+	}
+	log.Info("Starting bus relay", "mode", mode, "pollInterval", pollInterval)
+	stop := make(chan struct{})
+	busRelayStop = stop
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		gcTicker := time.NewTicker(BusMessageTimeout)
+		defer gcTicker.Stop()
+		if !BusGarbageCollection {
+			gcTicker.Stop()
+		}
+		for {
+			select {
+			case <-stop:
+				if listener != nil {
+					listener.Close()
+				}
+				log.Info("Bus relay stopped")
+				return
+			case <-notify:
+			case <-ticker.C:
+			case <-gcTicker.C:
+				gcBusMessages()
+				continue
+			}
+			relayBusMessages(cursor)
+		}
+	}()
+}
+
+// StopBusRelay stops the bus relay started with StartBusRelay
+func StopBusRelay() {
+	if busRelayStop == nil {
+		return
+	}
+	close(busRelayStop)
+	busRelayStop = nil
+}
+
+// A BusCursor is the position of a reader in the bus messages, such as the
+// bus relay or a client of the bus.
+//
+// Message IDs are allocated when messages are created, so that a message
+// may be committed after messages with greater IDs. The IDs skipped below
+// lastID are therefore kept as gaps, which are read when their message
+// is committed or forgotten after BusGapTimeout if it never is.
+type BusCursor struct {
+	lastID int64
+	gaps   map[int64]time.Time
+}
+
+// NewBusCursor returns a new BusCursor after the message
+// with the given lastID, without gaps.
+func NewBusCursor(lastID int64) *BusCursor {
+	return &BusCursor{lastID: lastID, gaps: make(map[int64]time.Time)}
+}
+
+// Accept returns true if the given message comes after this cursor, that is
+// if its ID is greater than the last ID of the cursor or is one of its gaps.
+// In this case, the cursor is advanced after the message.
+func (bc *BusCursor) Accept(msg bus.Message) bool {
+	now := time.Now()
+	if len(bc.gaps) >= maxBusGaps {
+		bc.gapIDs(now)
+	}
+	if _, gap := bc.gaps[msg.ID]; !gap && msg.ID <= bc.lastID {
+		return false
+	}
+	bc.advance([]bus.Message{msg}, now)
+	return true
+}
+
+// gapIDs returns the missing IDs of this cursor, after
+// removing those that are older than BusGapTimeout.
+func (bc *BusCursor) gapIDs(now time.Time) []int64 {
+	var res []int64
+	for id, since := range bc.gaps {
+		if now.Sub(since) > BusGapTimeout {
+			delete(bc.gaps, id)
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// advance moves this cursor after the given relayed messages,
+// which must be ordered by ID, and records the skipped IDs as gaps.
+func (bc *BusCursor) advance(messages []bus.Message, now time.Time) {
+	for _, msg := range messages {
+		if _, ok := bc.gaps[msg.ID]; ok {
+			delete(bc.gaps, msg.ID)
+			continue
+		}
+		if msg.ID <= bc.lastID {
+			continue
+		}
+		for id := bc.lastID + 1; id < msg.ID && len(bc.gaps) < maxBusGaps; id++ {
+			bc.gaps[id] = now
+		}
+		bc.lastID = msg.ID
+	}
+}
+
+// relayBusMessages dispatches the bus messages with an ID greater
+// than the last ID of cursor or in its gaps, and advances cursor.
+func relayBusMessages(cursor *BusCursor) {
+	now := time.Now()
+	gapIDs := cursor.gapIDs(now)
+	var messages []bus.Message
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("BusMessage").Sudo()
+		cond := rc.Model().Field("ID").Greater(cursor.lastID)
+		if len(gapIDs) > 0 {
+			cond = cond.Or().Field("ID").In(gapIDs)
+		}
+		messages = searchBusMessages(rc, cond)
+	})
+	if err != nil {
+		log.Warn("Unable to fetch bus messages", "error", err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	bus.Dispatch(messages...)
+	cursor.advance(messages, now)
+}
+
+// gcBusMessages deletes the bus messages older than BusMessageTimeout
+func gcBusMessages() {
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("BusMessage").Sudo()
+		old := rc.Search(rc.Model().Field("CreateDate").Lower(dates.Now().Add(-BusMessageTimeout)))
+		if !old.IsEmpty() {
+			old.Call("Unlink")
+		}
+	})
+	if err != nil {
+		log.Warn("Unable to delete old bus messages", "error", err)
+	}
+}
+
+// UpdateBusPresence records that the user with the given uid is connected to the bus.
+// It is executed in its own transaction.
+func UpdateBusPresence(uid int64) {
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("BusPresence").Sudo()
+		presence := rc.Search(rc.Model().Field("UserID").Equals(uid))
+		if !presence.IsEmpty() {
+			presence.Call("Write", FieldMap{"LastPresence": dates.Now()})
+			return
+		}
+		rc.Call("Create", FieldMap{"UserID": uid, "LastPresence": dates.Now()})
+	})
+	if err != nil {
+		log.Warn("Unable to update bus presence", "uid", uid, "error", err)
+	}
+}
+
+// BusPresence returns the ids of the given users that have been
+// connected to the bus of any server worker within BusPresenceTimeout.
+//
+// Only the users that the user of env can read are returned.
+func BusPresence(env Environment, uids []int64) []int64 {
+	res := make([]int64, 0)
+	if userModel, ok := Registry.Get("User"); ok {
+		if !canSubscribeModel(env.uid, "User") {
+			return res
+		}
+		uids = env.Pool("User").Search(userModel.Field("ID").In(uids)).Ids()
+	}
+	if len(uids) == 0 {
+		return res
+	}
+	rc := env.Pool("BusPresence").Sudo()
+	online := rc.Search(rc.Model().Field("UserID").In(uids).
+		And().Field("LastPresence").Greater(dates.Now().Add(-BusPresenceTimeout))).OrderBy("UserID")
+	for _, rec := range online.Records() {
+		res = append(res, rec.Get("UserID").(int64))
+	}
+	return res
+}
+
+// canSubscribeModel returns true if the user with the given
+// uid can read the records of the model with the given name.
+func canSubscribeModel(uid int64, modelName string) bool {
+	model, ok := Registry.Get(modelName)
+	if !ok {
+		return false
+	}
+	method, ok := model.methods.get("Load")
+	if !ok {
+		return false
+	}
+	return method.AllowedForUser(uid)
+}
+
+func init() {
+	// Model channels can be subscribed by users who can read the model
+	bus.RegisterChannelKind("model", canSubscribeModel)
+}
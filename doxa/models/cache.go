@@ -27,11 +27,18 @@ type cacheRef struct {
 	id    int64
 }
 
+// An externalIDRef is a key to find the id of a record from its external ID
+type externalIDRef struct {
+	model      *Model
+	externalID string
+}
+
 // A cache holds records field values for caching the database to
 // improve performance. cache is not safe for concurrent access.
 type cache struct {
-	data     map[cacheRef]FieldMap
-	m2mLinks map[*Model]map[[2]int64]bool
+	data        map[cacheRef]FieldMap
+	m2mLinks    map[*Model]map[[2]int64]bool
+	externalIDs map[externalIDRef]int64
 }
 
 // updateEntry creates or updates an entry in the cache defined by its model, id and fieldName.
@@ -155,6 +162,29 @@ func (c *cache) invalidateRecord(mi *Model, id int64) {
 	}
 }
 
+// setExternalID records in the cache that the record of the given model with
+// the given id has the given external ID.
+func (c *cache) setExternalID(mi *Model, externalID string, id int64) {
+	c.externalIDs[externalIDRef{model: mi, externalID: externalID}] = id
+	c.updateEntry(mi, id, "doxa_external_id", externalID)
+}
+
+// getExternalID returns the id of the record of the given model with the given
+// external ID, if it is in the cache. Entries are only valid as long as the
+// external ID of their record is cached, i.e. until the record is invalidated.
+func (c *cache) getExternalID(mi *Model, externalID string) (int64, bool) {
+	ref := externalIDRef{model: mi, externalID: externalID}
+	id, ok := c.externalIDs[ref]
+	if !ok {
+		return 0, false
+	}
+	if xid, _ := c.data[cacheRef{model: mi, id: id}]["doxa_external_id"].(string); xid != externalID {
+		delete(c.externalIDs, ref)
+		return 0, false
+	}
+	return id, true
+}
+
 // removeEntry removes the given entry from cache
 func (c *cache) removeEntry(mi *Model, id int64, fieldName string) {
 	if !c.checkIfInCache(mi, []int64{id}, []string{fieldName}) {
@@ -258,8 +288,9 @@ func (c *cache) getRelatedRef(mi *Model, id int64, path string) (cacheRef, strin
 // newCache creates a pointer to a new cache instance.
 func newCache() *cache {
 	res := cache{
-		data:     make(map[cacheRef]FieldMap),
-		m2mLinks: make(map[*Model]map[[2]int64]bool),
+		data:        make(map[cacheRef]FieldMap),
+		m2mLinks:    make(map[*Model]map[[2]int64]bool),
+		externalIDs: make(map[externalIDRef]int64),
 	}
 	return &res
 }
@@ -413,10 +413,18 @@ func (c *Condition) substituteChildOfOperator(rc *RecordCollection) {
 
 // evaluateArgFunctions recursively evaluates all args in the queries that are
 // functions and substitute it with the result.
+//
+// Predicates are copied before being substituted, so that conditions shared
+// between queries (such as those of record rules) keep their functions.
 func (c *Condition) evaluateArgFunctions(rc *RecordCollection) {
+	predicates := make([]predicate, len(c.predicates))
+	copy(predicates, c.predicates)
+	c.predicates = predicates
 	for i, p := range c.predicates {
 		if p.cond != nil {
-			p.cond.evaluateArgFunctions(rc)
+			subCond := *p.cond
+			subCond.evaluateArgFunctions(rc)
+			c.predicates[i].cond = &subCond
 		}
 
 		fnctVal := reflect.ValueOf(p.arg)
@@ -6,117 +6,517 @@ package models
 import (
 	"encoding/base64"
 	"encoding/csv"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/labneco/doxa/doxa/models/fieldtype"
 	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/exceptions"
+	"github.com/labneco/doxa/doxa/tools/strutils"
 )
 
-// LoadCSVDataFile loads the data of the given file into the database.
-func LoadCSVDataFile(fileName string) {
-	csvFile, err := os.Open(fileName)
-	defer csvFile.Close()
-	if err != nil {
-		log.Panic("Unable to open CSV data file", "error", err, "fileName", fileName)
-	}
+// DataBatchSize is the number of records of CSV data files that are loaded
+// in each transaction by LoadCSVDataFile. If it is lower than 1, each file
+// is loaded in a single transaction. It is set at startup from the configuration.
+var DataBatchSize = 1000
 
+// A csvDataFile is a CSV data file opened by openCSVDataFile.
+// Its records are read on demand by nextRecords.
+type csvDataFile struct {
+	fileName  string
+	modelName string
+	update    bool
+	noUpdate  bool
+	deletion  bool
+	version   int
+	headers   []string
+	file      *os.File
+	reader    *csv.Reader
+	read      int // number of records read so far
+}
+
+// openCSVDataFile opens the given CSV data file and reads its headers.
+// The returned file must be closed by the caller.
+//
+// The model of the records is given by the file name, which may be
+// prefixed by a number for ordering and suffixed by '_update', '_noupdate',
+// '_delete' or '_<version>' (e.g. '010-User_update.csv' or 'User_12.csv').
+func openCSVDataFile(fileName string) *csvDataFile {
 	elements := strings.Split(filepath.Base(fileName), "_")
 	modelName := strings.Split(elements[0], ".")[0]
 	modelName = strings.TrimLeft(modelName, "01234567890-")
-	var (
-		update  bool
-		version int
-	)
+	res := csvDataFile{
+		fileName:  fileName,
+		modelName: modelName,
+	}
 	if len(elements) == 2 {
 		mod := strings.Split(elements[1], ".")[0]
 		ver, err := strconv.Atoi(mod)
 		switch {
 		case strings.ToLower(mod) == "update":
-			update = true
+			res.update = true
+		case strings.ToLower(mod) == "noupdate":
+			res.noUpdate = true
+		case strings.ToLower(mod) == "delete":
+			res.deletion = true
 		case err == nil:
-			version = ver
+			res.version = ver
 		}
 	}
+	model := Registry.MustGet(modelName)
 
-	r := csv.NewReader(csvFile)
-	headers, err := r.Read()
+	csvFile, err := os.Open(fileName)
+	if err != nil {
+		log.Panic("Unable to open CSV data file", "error", err, "fileName", fileName)
+	}
+	res.file = csvFile
+	res.reader = csv.NewReader(csvFile)
+	res.reader.FieldsPerRecord = -1
+	res.headers, err = res.reader.Read()
 	if err != nil {
+		csvFile.Close()
 		log.Panic("Unable to read CSV headers in data file", "error", err, "fileName", fileName)
 	}
+	// JSONize all field names
+	for i, header := range res.headers {
+		res.headers[i] = model.JSONizeFieldName(header)
+	}
+	return &res
+}
+
+// close closes the underlying file of this data file
+func (f *csvDataFile) close() {
+	f.file.Close()
+}
 
-	err = ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
-		rc := env.Pool(modelName)
-		// JSONize all field names
-		for i, header := range headers {
-			headers[i] = rc.Model().JSONizeFieldName(header)
+// nextRecords reads and returns at most max records from this file, or all
+// the remaining records if max is lower than 1. It returns no records once
+// the whole file has been read. The line of the first returned record is
+// f.read+1 before the call, line 1 being the first record.
+func (f *csvDataFile) nextRecords(max int) [][]string {
+	var res [][]string
+	for max < 1 || len(res) < max {
+		record, err := f.reader.Read()
+		if err == io.EOF {
+			break
 		}
-		line := 1
-		// Load records
-		for {
-			record, err := r.Read()
-			if err == io.EOF {
-				break
-			}
+		if err != nil {
+			log.Panic("Unable to read CSV data file", "error", err, "fileName", f.fileName, "line", f.read+1)
+		}
+		res = append(res, record)
+		f.read++
+	}
+	return res
+}
 
-			values := getRecordValuesMap(headers, modelName, record, env, line, fileName)
-
-			externalID := values["id"]
-			delete(values, "id")
-			values["doxa_external_id"] = externalID
-			values["doxa_version"] = version
-			// We deliberately call Search directly without Call so as not to be polluted by Search overrides
-			// such as "Active test".
-			rec := rc.Search(rc.Model().Field("DoxaExternalID").Equals(externalID)).Limit(1)
-			switch {
-			case rec.Len() == 0:
-				rc.Call("Create", values)
-			case rec.Len() == 1:
-				if version > rec.Get("DoxaVersion").(int) || update {
-					rec.Call("Write", values)
+// loadRecord creates or updates in env the given record of the given line of
+// this file, line 1 being the first record. Existing records are only updated
+// if update is true or if the version of the file is higher than theirs, and
+// never if they have been loaded from a noupdate file.
+//
+// If some values of the record cannot be converted, the record is not loaded
+// and the conversion errors are returned.
+func (f *csvDataFile) loadRecord(env Environment, record []string, line int, update bool) (created, updated bool, errs []ImportError) {
+	if len(record) < len(f.headers) {
+		log.Panic("Missing values in data file", "fileName", f.fileName, "line", line, "expected", len(f.headers), "got", len(record))
+	}
+	rc := env.Pool(f.modelName)
+	values, errs := convertRecordValues(f.headers, f.modelName, record, env, f.fileName)
+	if len(errs) > 0 {
+		for i := range errs {
+			errs[i].Line = line + 1
+			errs[i].ExternalID = f.externalID(record)
+		}
+		return false, false, errs
+	}
+
+	externalID := values["id"]
+	delete(values, "id")
+	values["doxa_external_id"] = externalID
+	values["doxa_version"] = f.version
+	values["doxa_no_update"] = f.noUpdate
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	// such as "Active test".
+	rec := rc.Search(rc.Model().Field("DoxaExternalID").Equals(externalID)).Limit(1)
+	switch {
+	case rec.Len() == 0:
+		rc.Call("Create", values)
+		return true, false, nil
+	case rec.Len() == 1:
+		if f.noUpdate || rec.Get("DoxaNoUpdate").(bool) {
+			return false, false, nil
+		}
+		if f.version > rec.Get("DoxaVersion").(int) || update {
+			rec.Call("Write", values)
+			return false, true, nil
+		}
+	}
+	return false, false, nil
+}
+
+// LoadCSVDataFile loads the data of the given file into the database.
+//
+// The file is read and loaded by batches of DataBatchSize records, each batch
+// being committed in its own transaction, so that large files can be loaded
+// without holding all their records in memory. If a batch fails, the previous
+// batches are kept in the database and this function panics.
+//
+// If the file name is suffixed by '_delete', the records whose external IDs
+// are given in the ID column of the file are deleted instead.
+func LoadCSVDataFile(fileName string) {
+	data := openCSVDataFile(fileName)
+	defer data.close()
+	if data.deletion {
+		data.deleteRecords()
+		return
+	}
+	for {
+		first := data.read + 1
+		records := data.nextRecords(DataBatchSize)
+		if len(records) == 0 {
+			return
+		}
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			for i, record := range records {
+				if _, _, errs := data.loadRecord(env, record, first+i, data.update); len(errs) > 0 {
+					log.Panic(errs[0].Message, "fileName", fileName, "line", first+i, "field", errs[0].Field, "value", errs[0].Value)
 				}
 			}
-			line++
+		})
+		if err != nil {
+			log.Panic("Error while loading data", "fileName", fileName, "line", first, "error", err)
+		}
+		if DataBatchSize > 0 && data.read >= DataBatchSize {
+			log.Info("Data records loaded", "fileName", fileName, "records", data.read)
+		}
+	}
+}
+
+// deleteRecords deletes in a new environment the records
+// whose external IDs are listed in this deletion file.
+func (f *csvDataFile) deleteRecords() {
+	var externalIDs []string
+	for {
+		first := f.read + 1
+		records := f.nextRecords(DataBatchSize)
+		if len(records) == 0 {
+			break
+		}
+		for i, record := range records {
+			externalID := f.externalID(record)
+			if externalID == "" {
+				log.Panic("Missing external ID in deletion data file", "fileName", f.fileName, "line", first+i)
+			}
+			externalIDs = append(externalIDs, externalID)
 		}
+	}
+	if len(externalIDs) == 0 {
+		return
+	}
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		DeleteDataRecords(env, DataDeletion{
+			Model:       f.modelName,
+			ExternalIDs: externalIDs,
+			FileName:    f.fileName,
+		})
 	})
 	if err != nil {
-		log.Panic("Error while loading data", "error", err)
+		log.Panic("Error while deleting data", "fileName", f.fileName, "error", err)
+	}
+}
+
+// LoadCSVDataFileWithReport loads the data of the given file into the database
+// as LoadCSVDataFile, but the lines that cannot be loaded are skipped and
+// reported as with ImportCSVDataFile instead of aborting the load. It panics
+// if the file itself cannot be read.
+func LoadCSVDataFileWithReport(fileName string) ImportReport {
+	data := openCSVDataFile(fileName)
+	defer data.close()
+	if data.deletion {
+		data.deleteRecords()
+		return ImportReport{FileName: fileName, ModelName: data.modelName}
+	}
+	batchSize := DataBatchSize
+	if batchSize < 1 {
+		batchSize = math.MaxInt32
+	}
+	return data.importRecords(batchSize, data.update)
+}
+
+// A DataRecord is a record defined in a data file other than a CSV file,
+// such as an XML file.
+type DataRecord struct {
+	Model      string
+	ExternalID string
+	FileName   string // file in which the record is defined
+	Line       int    // line of the record in the file, for error messages
+	// Values are the values of the fields as they would be written in a CSV
+	// data file, by field name. Relation fields hold external IDs.
+	Values map[string]string
+	// Evaluated are values of fields which are already of the type of their field
+	Evaluated FieldMap
+	// NoUpdate is true if the record must only be created, and never
+	// updated afterwards, so that the changes of the users are kept.
+	NoUpdate bool
+}
+
+// LoadDataRecord creates or updates in env the given record, which is
+// identified by its external ID. Its values are converted as in CSV data files.
+//
+// Existing records are not updated if record.NoUpdate is true, or if they
+// have been created from a NoUpdate record.
+func LoadDataRecord(env Environment, record DataRecord) {
+	model := Registry.MustGet(record.Model)
+	headers := make([]string, 0, len(record.Values))
+	for field := range record.Values {
+		headers = append(headers, field)
+	}
+	sort.Strings(headers)
+	raw := make([]string, len(headers))
+	for i, field := range headers {
+		raw[i] = record.Values[field]
+		headers[i] = model.JSONizeFieldName(field)
+	}
+	values := getRecordValuesMap(headers, record.Model, raw, env, record.Line, record.FileName)
+	for field, value := range record.Evaluated {
+		values[model.JSONizeFieldName(field)] = value
+	}
+	values["doxa_external_id"] = record.ExternalID
+	values["doxa_no_update"] = record.NoUpdate
+	rc := env.Pool(record.Model)
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	rec := rc.Search(rc.Model().Field("DoxaExternalID").Equals(record.ExternalID)).Limit(1)
+	if rec.IsEmpty() {
+		rc.Call("Create", values)
+		return
+	}
+	if record.NoUpdate || rec.Get("DoxaNoUpdate").(bool) {
+		return
+	}
+	rec.Call("Write", values)
+}
+
+// A DataDeletion is a directive of a data file to delete records, e.g.
+// records introduced by a previous version of a module.
+type DataDeletion struct {
+	Model       string
+	ExternalIDs []string   // external IDs of the records to delete
+	Condition   *Condition // condition of the records to delete, if any
+	FileName    string     // file in which the directive is defined
+	Line        int        // line of the directive in the file, for error messages
+}
+
+// DeleteDataRecords deletes in env the records given by the external IDs and
+// the condition of the given directive, and returns the number of deleted
+// records. External IDs that do not exist are ignored, so that directives can
+// be loaded again after their records have been deleted.
+func DeleteDataRecords(env Environment, deletion DataDeletion) int {
+	hasCondition := deletion.Condition != nil && !deletion.Condition.IsEmpty()
+	if len(deletion.ExternalIDs) == 0 && !hasCondition {
+		log.Panic("Deletion directives must have external IDs or a condition", "fileName", deletion.FileName, "line", deletion.Line)
+	}
+	rec := env.Pool(deletion.Model)
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	if len(deletion.ExternalIDs) > 0 {
+		rec = rec.Search(rec.Model().Field("DoxaExternalID").In(deletion.ExternalIDs))
+	}
+	if hasCondition {
+		rec = rec.Search(deletion.Condition)
+	}
+	num := rec.Len()
+	if num > 0 {
+		rec.Call("Unlink")
+	}
+	return num
+}
+
+// An ImportError is a line of a data file that could not be imported
+type ImportError struct {
+	Line       int    // line number in the file, the headers being line 1
+	ExternalID string // external ID of the record of the line
+	Field      string // field whose value could not be converted, if any
+	Value      string // value that could not be converted, if any
+	Message    string // reason of the failure
+}
+
+// An ImportReport is the result of ImportCSVDataFile
+type ImportReport struct {
+	FileName  string
+	ModelName string
+	Created   int           // number of created records
+	Updated   int           // number of updated records
+	Skipped   int           // number of existing records that were left untouched
+	Failed    int           // number of lines that could not be imported
+	Errors    []ImportError // errors of the lines that could not be imported
+}
+
+// ImportCSVDataFile imports the data of the given file into the database in
+// the same way as LoadCSVDataFile, but records are committed by batches of
+// batchSize records and the lines that fail are reported instead of aborting
+// the import. Lines whose values cannot be converted, e.g. because of an
+// invalid number or an unknown related external ID, are reported with one
+// error per invalid value and skipped, while the other lines of their batch
+// are imported. When a batch fails for another reason, its records are
+// imported one by one so that only the failing lines are rejected.
+//
+// Existing records are updated if update is true, or if the file name tells
+// so as for LoadCSVDataFile. This function panics if the file itself cannot
+// be read or if it is a deletion file.
+func ImportCSVDataFile(fileName string, batchSize int, update bool) ImportReport {
+	data := openCSVDataFile(fileName)
+	defer data.close()
+	if data.deletion {
+		log.Panic("Deletion data files cannot be imported", "fileName", fileName)
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return data.importRecords(batchSize, update || data.update)
+}
+
+// importRecords imports the records of this file by batches of batchSize
+// records and returns the report of the import. See ImportCSVDataFile.
+func (f *csvDataFile) importRecords(batchSize int, update bool) ImportReport {
+	res := ImportReport{
+		FileName:  f.fileName,
+		ModelName: f.modelName,
+	}
+	importLines := func(records [][]string, first int) error {
+		var (
+			created, updated, failed int
+			errs                     []ImportError
+		)
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			// Reset counters as the function is called again if the transaction is retried
+			created, updated, failed, errs = 0, 0, 0, nil
+			for i, record := range records {
+				c, u, lineErrs := f.loadRecord(env, record, first+i, update)
+				if c {
+					created++
+				}
+				if u {
+					updated++
+				}
+				if len(lineErrs) > 0 {
+					failed++
+					errs = append(errs, lineErrs...)
+				}
+			}
+		})
+		if err == nil {
+			res.Created += created
+			res.Updated += updated
+			res.Failed += failed
+			res.Errors = append(res.Errors, errs...)
+		}
+		return err
+	}
+	for {
+		first := f.read + 1
+		records := f.nextRecords(batchSize)
+		if len(records) == 0 {
+			break
+		}
+		err := importLines(records, first)
+		if err != nil {
+			for i, record := range records {
+				if len(records) > 1 {
+					err = importLines(records[i:i+1], first+i)
+				}
+				if err != nil {
+					res.Failed++
+					res.Errors = append(res.Errors, ImportError{
+						Line:       first + i + 1,
+						ExternalID: f.externalID(record),
+						Message:    importErrorMessage(err),
+					})
+				}
+			}
+		}
+		if f.read >= batchSize {
+			log.Info("Data records imported", "fileName", f.fileName, "records", f.read, "failed", res.Failed)
+		}
+	}
+	res.Skipped = f.read - res.Created - res.Updated - res.Failed
+	return res
+}
+
+// externalID returns the external ID of the given record
+// of this file, or an empty string if it cannot be found.
+func (f *csvDataFile) externalID(record []string) string {
+	for i, header := range f.headers {
+		if header == "id" && i < len(record) {
+			return record[i]
+		}
+	}
+	return ""
+}
+
+// importErrorMessage returns the message to report for the given import error.
+// The debug part of user errors is kept since it holds the context of the error,
+// such as the field and the value that could not be imported.
+func importErrorMessage(err error) string {
+	userErr, ok := err.(exceptions.UserError)
+	if !ok {
+		return err.Error()
 	}
+	msg := userErr.Message
+	if parts := strings.SplitN(msg, "\n----------------------------------\n", 2); len(parts) == 2 {
+		msg = parts[1]
+	}
+	return strings.TrimSpace(msg)
 }
 
+// getRecordValuesMap returns the values of the given record of a data file
+// converted to the type of their field. It panics if a value cannot be converted.
 func getRecordValuesMap(headers []string, modelName string, record []string, env Environment, line int, fileName string) FieldMap {
+	values, errs := convertRecordValues(headers, modelName, record, env, fileName)
+	if len(errs) > 0 {
+		log.Panic(errs[0].Message, "fileName", fileName, "line", line, "field", errs[0].Field, "value", errs[0].Value)
+	}
+	return values
+}
+
+// convertRecordValues returns the values of the given record of a data file
+// converted to the type of their field, and the errors of the values that
+// cannot be converted, which are left out of the returned values. The Line
+// and ExternalID of the returned errors are not set.
+func convertRecordValues(headers []string, modelName string, record []string, env Environment, fileName string) (FieldMap, []ImportError) {
 	values := make(map[string]interface{})
+	var errs []ImportError
 	for i := 0; i < len(headers); i++ {
 		fi := Registry.MustGet(modelName).getRelatedFieldInfo(headers[i])
 		var (
-			val interface{}
-			err error
+			val    interface{}
+			err    error
+			errMsg string
 		)
 		switch {
 		case headers[i] == "id":
 			val = record[i]
 		case fi.fieldType == fieldtype.Integer:
 			val, err = strconv.ParseInt(record[i], 0, 64)
-			if err != nil {
-				log.Panic("Error while converting integer", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
-			}
+			errMsg = "Error while converting integer"
 		case fi.fieldType == fieldtype.Float:
 			val, err = strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				log.Panic("Error while converting float", "fileName", fileName, "line", line, "field", headers[i], "value", record[i], "error", err)
-			}
+			errMsg = "Error while converting float"
 		case fi.fieldType.IsFKRelationType():
 			val = nil
 			if record[i] != "" {
-				relRC := env.Pool(fi.relatedModelName).Search(fi.relatedModel.Field("DoxaExternalID").Equals(record[i]))
-				if relRC.Len() != 1 {
-					log.Panic("Unable to find related record from external ID", "fileName", fileName, "line", line, "field", headers[i], "value", record[i])
+				relID, ok := env.refID(fi.relatedModel, record[i])
+				if !ok {
+					errs = append(errs, ImportError{Field: headers[i], Value: record[i], Message: "Unable to find related record from external ID"})
+					continue
 				}
-				val = relRC.Ids()[0]
+				val = relID
 			}
 		case fi.fieldType == fieldtype.Many2Many:
 			ids := strings.Split(record[i], "|")
@@ -128,11 +528,22 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 			}
 			dir := filepath.Dir(fileName)
 			bFileName := filepath.Join(dir, record[i])
-			fileContent, err := ioutil.ReadFile(bFileName)
-			if err != nil {
-				log.Panic("Unable to open file with binary data", "error", err, "line", line, "field", headers[i], "value", record[i])
-			}
+			var fileContent []byte
+			fileContent, err = ioutil.ReadFile(bFileName)
+			errMsg = "Unable to open file with binary data"
 			val = base64.StdEncoding.EncodeToString(fileContent)
+		case fi.fieldType == fieldtype.Date:
+			val = dates.Date{}
+			if record[i] != "" {
+				val, err = dates.ParseDate(dates.DefaultServerDateFormat, record[i])
+				errMsg = "Error while converting date"
+			}
+		case fi.fieldType == fieldtype.DateTime:
+			val = dates.DateTime{}
+			if record[i] != "" {
+				val, err = dates.ParseDateTime(dates.DefaultServerDateTimeFormat, record[i])
+				errMsg = "Error while converting datetime"
+			}
 		case fi.fieldType == fieldtype.Boolean:
 			val = false
 			if res, _ := strconv.ParseBool(record[i]); res {
@@ -141,7 +552,260 @@ func getRecordValuesMap(headers []string, modelName string, record []string, env
 		default:
 			val = record[i]
 		}
+		if err != nil {
+			errs = append(errs, ImportError{Field: headers[i], Value: record[i], Message: fmt.Sprintf("%s: %s", errMsg, err)})
+			continue
+		}
 		values[headers[i]] = val
 	}
-	return values
+	return values, errs
+}
+
+// csvExportExcludedFields are the fields that are never exported by
+// ExportCSVData, since they are set by the ORM.
+var csvExportExcludedFields = map[string]bool{
+	"ID":             true,
+	"CreateDate":     true,
+	"CreateUID":      true,
+	"WriteDate":      true,
+	"WriteUID":       true,
+	"DoxaExternalID": true,
+	"DoxaVersion":    true,
+	"DoxaNoUpdate":   true,
+}
+
+// autoExternalIDPrefix is the prefix of the external IDs that are
+// generated by the ORM for records created without one.
+const autoExternalIDPrefix = "__doxa_external_id__"
+
+// ExportCSVData writes the records of rc to w as CSV in the format of data
+// files, so that they can be loaded into another database with
+// ImportCSVDataFile or LoadCSVDataFile.
+//
+// The ID column holds the external IDs of the records, and relation fields
+// hold the external IDs of the related records, separated by '|' for
+// many2many fields. fieldNames defaults to the fields of the model which are
+// stored and not computed, excluding the fields set by the ORM.
+//
+// External IDs generated by the ORM are replaced by IDs made of the model
+// name and the record id, which do not collide with the generated IDs of
+// the target database. Records are written after the records of the same
+// model they are related to, so that the file can be loaded in a single pass.
+//
+// The contents of binary fields are written to files in a directory named
+// after the model inside dir, the data file being meant to be saved in dir.
+// If dir is empty, binary fields are not exported.
+func ExportCSVData(w io.Writer, rc *RecordCollection, dir string, fieldNames ...string) {
+	fields := make([]*Field, len(fieldNames))
+	for i, fName := range fieldNames {
+		fields[i] = rc.model.fields.MustGet(fName)
+		if fields[i].fieldType.IsReverseRelationType() {
+			log.Panic("Reverse relation fields cannot be exported", "model", rc.model.name, "field", fName)
+		}
+		if fields[i].fieldType == fieldtype.Binary && dir == "" {
+			log.Panic("Binary fields can only be exported to a directory", "model", rc.model.name, "field", fName)
+		}
+	}
+	if len(fields) == 0 {
+		fields = exportedFields(rc.model, dir != "")
+	}
+	headers := []string{"ID"}
+	for _, fi := range fields {
+		headers = append(headers, fi.name)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(headers); err != nil {
+		log.Panic("Unable to write CSV headers", "model", rc.model.name, "error", err)
+	}
+	for _, rec := range exportOrder(rc, fields) {
+		externalID := exportExternalID(rec)
+		line := []string{externalID}
+		for _, fi := range fields {
+			line = append(line, exportFieldValue(rec, fi, externalID, dir))
+		}
+		if err := cw.Write(line); err != nil {
+			log.Panic("Unable to write CSV record", "model", rc.model.name, "externalID", externalID, "error", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Panic("Unable to write CSV data", "model", rc.model.name, "error", err)
+	}
+}
+
+// exportedFields returns the fields of the given model that are exported by
+// default by ExportCSVData, sorted by name. Binary fields are only included
+// if binary is true.
+func exportedFields(model *Model, binary bool) []*Field {
+	var res []*Field
+	for fName, fi := range model.fields.registryByName {
+		switch {
+		case csvExportExcludedFields[fName]:
+		case fi.isComputedField(), fi.isRelatedField():
+		case fi.fieldType.IsReverseRelationType():
+		case fi.fieldType == fieldtype.Binary && !binary:
+		default:
+			res = append(res, fi)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// exportFieldValue returns the value of the given field of rec as it is
+// written in data files. The content of binary fields is written to a file
+// of dir, whose path relative to dir is returned.
+func exportFieldValue(rec *RecordCollection, fi *Field, externalID, dir string) string {
+	value := rec.Get(fi.name)
+	switch {
+	case fi.fieldType.IsFKRelationType(), fi.fieldType == fieldtype.Many2Many:
+		var ids []string
+		for _, relRec := range value.(RecordSet).Collection().Records() {
+			ids = append(ids, exportExternalID(relRec))
+		}
+		return strings.Join(ids, "|")
+	case fi.fieldType == fieldtype.Date:
+		if date := value.(dates.Date); !date.IsZero() {
+			return date.Format(dates.DefaultServerDateFormat)
+		}
+		return ""
+	case fi.fieldType == fieldtype.DateTime:
+		if dateTime := value.(dates.DateTime); !dateTime.IsZero() {
+			return dateTime.Format(dates.DefaultServerDateTimeFormat)
+		}
+		return ""
+	case fi.fieldType == fieldtype.Binary:
+		content, _ := value.(string)
+		if content == "" {
+			return ""
+		}
+		data, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			log.Panic("Unable to decode binary field", "model", rec.model.name, "field", fi.name, "externalID", externalID, "error", err)
+		}
+		relPath := filepath.Join(rec.model.name, strings.Replace(fmt.Sprintf("%s_%s", externalID, fi.name), string(filepath.Separator), "_", -1))
+		if err := os.MkdirAll(filepath.Join(dir, rec.model.name), 0755); err != nil {
+			log.Panic("Unable to create binary data directory", "dir", dir, "error", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, relPath), data, 0644); err != nil {
+			log.Panic("Unable to write binary data file", "model", rec.model.name, "field", fi.name, "externalID", externalID, "error", err)
+		}
+		return filepath.ToSlash(relPath)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// exportExternalID returns the external ID of rec as written by ExportCSVData.
+// External IDs generated by the ORM are replaced by __export__<model>_<id>.
+func exportExternalID(rec *RecordCollection) string {
+	externalID := rec.Get("DoxaExternalID").(string)
+	if !strings.HasPrefix(externalID, autoExternalIDPrefix) {
+		return externalID
+	}
+	return fmt.Sprintf("__export__%s_%d", strutils.SnakeCaseString(rec.model.name), rec.ids[0])
+}
+
+// exportOrder returns the records of rc in the order in which they are
+// written by ExportCSVData: each record comes after the records of rc it
+// is related to through the given fields. Circular relations are written
+// in the order of rc.
+func exportOrder(rc *RecordCollection, fields []*Field) []*RecordCollection {
+	var selfFields []*Field
+	for _, fi := range fields {
+		if fi.relatedModelName == rc.model.name && (fi.fieldType.IsFKRelationType() || fi.fieldType == fieldtype.Many2Many) {
+			selfFields = append(selfFields, fi)
+		}
+	}
+	records := rc.Records()
+	if len(selfFields) == 0 {
+		return records
+	}
+	byID := make(map[int64]*RecordCollection)
+	for _, rec := range records {
+		byID[rec.ids[0]] = rec
+	}
+	res := make([]*RecordCollection, 0, len(records))
+	visited := make(map[int64]bool)
+	var visit func(rec *RecordCollection)
+	visit = func(rec *RecordCollection) {
+		if visited[rec.ids[0]] {
+			return
+		}
+		visited[rec.ids[0]] = true
+		for _, fi := range selfFields {
+			for _, relID := range rec.Get(fi.name).(RecordSet).Collection().Ids() {
+				if relRec, ok := byID[relID]; ok {
+					visit(relRec)
+				}
+			}
+		}
+		res = append(res, rec)
+	}
+	for _, rec := range records {
+		visit(rec)
+	}
+	return res
+}
+
+// ExportCSVSnapshot exports all the records of the given models with
+// ExportCSVData into data files of dir, and returns the number of exported
+// records by model name. Binary contents are written to dir too.
+//
+// Files are named <NNN>-<Model>.csv, numbered so that the models are loaded
+// after the models they are related to. The directory can therefore be loaded
+// as is into another database, e.g. as the data directory of a module.
+func ExportCSVSnapshot(env Environment, dir string, modelNames ...string) map[string]int {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Panic("Unable to create snapshot directory", "dir", dir, "error", err)
+	}
+	res := make(map[string]int)
+	for i, model := range snapshotOrder(modelNames) {
+		fileName := filepath.Join(dir, fmt.Sprintf("%03d-%s.csv", (i+1)*10, model.name))
+		file, err := os.Create(fileName)
+		if err != nil {
+			log.Panic("Unable to create data file", "fileName", fileName, "error", err)
+		}
+		rc := env.Pool(model.name).SearchAll()
+		ExportCSVData(file, rc, dir)
+		if err := file.Close(); err != nil {
+			log.Panic("Unable to write data file", "fileName", fileName, "error", err)
+		}
+		res[model.name] = rc.Len()
+	}
+	return res
+}
+
+// snapshotOrder returns the models with the given names sorted so that
+// each model comes after the other given models it is related to through
+// its exported fields. Circular relations are broken at the model that
+// comes first in alphabetical order, which is then sorted last.
+func snapshotOrder(modelNames []string) []*Model {
+	names := make([]string, len(modelNames))
+	copy(names, modelNames)
+	sort.Strings(names)
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[Registry.MustGet(name).name] = true
+	}
+	var res []*Model
+	visited := make(map[string]bool)
+	var visit func(model *Model)
+	visit = func(model *Model) {
+		if visited[model.name] {
+			return
+		}
+		visited[model.name] = true
+		for _, fi := range exportedFields(model, true) {
+			if fi.relatedModelName != "" && selected[fi.relatedModelName] {
+				visit(Registry.MustGet(fi.relatedModelName))
+			}
+		}
+		res = append(res, model)
+	}
+	for _, name := range names {
+		visit(Registry.MustGet(name))
+	}
+	return res
 }
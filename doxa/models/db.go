@@ -16,6 +16,9 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/labneco/doxa/doxa/models/operator"
@@ -23,8 +26,9 @@ import (
 )
 
 var (
-	db       *sqlx.DB
-	adapters map[string]dbAdapter
+	db         *sqlx.DB
+	dbConnData string
+	adapters   map[string]dbAdapter
 )
 
 // ConnectionParams are the database agnostic parameters to connect to the database
@@ -105,6 +109,9 @@ func registerDBAdapter(name string, adapter dbAdapter) {
 // Cursor is a wrapper around a database transaction
 type Cursor struct {
 	tx *sqlx.Tx
+	// savepoint is the name of the savepoint of this Cursor if it
+	// is nested in the transaction of a test (see newSavepoint).
+	savepoint string
 }
 
 // Execute a query without returning any rows. It panics in case of error.
@@ -135,11 +142,26 @@ func newCursor(db *sqlx.DB) *Cursor {
 	}
 }
 
+// savepointSeq numbers the savepoints created by newSavepoint
+var savepointSeq uint64
+
+// newSavepoint returns a new Cursor on the transaction of the given
+// Cursor, within a new savepoint of this transaction.
+func newSavepoint(parent *Cursor) *Cursor {
+	name := fmt.Sprintf("doxa_savepoint_%d", atomic.AddUint64(&savepointSeq, 1))
+	dbExecute(parent.tx, fmt.Sprintf("SAVEPOINT %s", name))
+	return &Cursor{
+		tx:        parent.tx,
+		savepoint: name,
+	}
+}
+
 // DBConnect connects to a database using the given driver and arguments.
 func DBConnect(driver string, params ConnectionParams) {
 	adapter := adapters[driver]
 	connData := adapter.connectionString(params)
 	db = sqlx.MustConnect(driver, connData)
+	dbConnData = connData
 	log.Info("Connected to database", "driver", driver, "connData", connData)
 }
 
@@ -150,6 +172,14 @@ func DBClose() {
 	log.Info("Closed database", "error", err)
 }
 
+// DBPing checks that the database is reachable
+func DBPing() error {
+	if db == nil {
+		return errors.New("not connected to database")
+	}
+	return db.Ping()
+}
+
 // dbExecute is a wrapper around sqlx.MustExec
 // It executes a query that returns no row
 func dbExecute(cr *sqlx.Tx, query string, args ...interface{}) sql.Result {
@@ -235,7 +265,11 @@ func sanitizeQuery(query string, args ...interface{}) (string, []interface{}) {
 // Log the result of the given sql query started at start time with the
 // given args, and error. This function panics after logging if error is not nil.
 func logSQLResult(err error, start time.Time, query string, args ...interface{}) {
+	traceSQLQuery(err, start, query)
 	logCtx := log.New("query", query, "args", args, "duration", time.Now().Sub(start))
+	if requestID := RequestID(); requestID != "" {
+		logCtx = logCtx.New(RequestIDKey, requestID)
+	}
 	if err != nil {
 		// We don't log.Panic to keep db error information in recovery
 		logCtx.Error("Error while executing query", "error", err, "query", query, "args", args)
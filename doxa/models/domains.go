@@ -0,0 +1,81 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/labneco/doxa/doxa/models/operator"
+)
+
+// ParseDomain returns the Condition of the given domain, which is the
+// serialized form of a condition as returned by Condition.Serialize.
+//
+// A domain is a list of terms in prefix notation. Terms are either
+// predicates (e.g. ["Name", "ilike", "doe"]) or the logical operators
+// "&", "|" and "!". Terms that are not combined by an operator are
+// combined with AND, so that an empty domain is an empty Condition.
+func ParseDomain(domain []interface{}) (*Condition, error) {
+	res := newCondition()
+	for i := 0; i < len(domain); {
+		cond, next, err := parseDomainTerm(domain, i)
+		if err != nil {
+			return nil, err
+		}
+		res = res.AndCond(cond)
+		i = next
+	}
+	return res, nil
+}
+
+// parseDomainTerm parses the term of domain at position i and
+// returns its Condition and the position of the next term.
+func parseDomainTerm(domain []interface{}, i int) (*Condition, int, error) {
+	if i >= len(domain) {
+		return nil, i, fmt.Errorf("missing operand at the end of domain %v", domain)
+	}
+	switch term := domain[i].(type) {
+	case string:
+		switch term {
+		case "!":
+			cond, next, err := parseDomainTerm(domain, i+1)
+			if err != nil {
+				return nil, next, err
+			}
+			return newCondition().AndNotCond(cond), next, nil
+		case "&", "|":
+			left, next, err := parseDomainTerm(domain, i+1)
+			if err != nil {
+				return nil, next, err
+			}
+			right, next, err := parseDomainTerm(domain, next)
+			if err != nil {
+				return nil, next, err
+			}
+			if term == "|" {
+				// Operands are swapped so that Serialize gives back the same domain
+				return newCondition().AndCond(right).OrCond(left), next, nil
+			}
+			return newCondition().AndCond(left).AndCond(right), next, nil
+		}
+	case []interface{}:
+		if len(term) != 3 {
+			break
+		}
+		field, ok := term[0].(string)
+		var op operator.Operator
+		switch o := term[1].(type) {
+		case string:
+			op = operator.Operator(o)
+		case operator.Operator:
+			op = o
+		}
+		if !ok || !op.IsValid() {
+			break
+		}
+		cs := ConditionStart{}
+		return cs.Field(field).AddOperator(op, term[2]), i + 1, nil
+	}
+	return nil, i, fmt.Errorf("invalid domain term %v", domain[i])
+}
@@ -15,6 +15,9 @@
 package models
 
 import (
+	"fmt"
+
+	"github.com/jtolds/gls"
 	"github.com/labneco/doxa/doxa/models/types"
 	"github.com/labneco/doxa/doxa/tools/logging"
 )
@@ -24,6 +27,17 @@ import (
 // be retried.
 const DBSerializationMaxRetries uint8 = 5
 
+// RequestIDKey is the key of the request ID in the
+// context of the Environments created by WithRequestID.
+const RequestIDKey = "request_id"
+
+// LangKey is the key of the language in the context of Environments
+const LangKey = "lang"
+
+// testCursorKey is the key of the Cursor of the test transaction
+// attached to the current goroutine by SimulateInTestTransaction.
+const testCursorKey = "doxa_test_cursor"
+
 // An Environment stores various contextual data used by the models:
 // - the database cursor (current open transaction),
 // - the current user ID (for access rights checking)
@@ -36,6 +50,8 @@ type Environment struct {
 	cache   *cache
 	super   bool
 	retries uint8
+	calls   *methodCalls
+	memory  *memoryStore
 }
 
 // Cr returns a pointer to the Cursor of the Environment
@@ -53,12 +69,30 @@ func (env Environment) Context() *types.Context {
 	return env.context
 }
 
+// Lang returns the language of the Environment, i.e. the value of the
+// LangKey of its context. It is the language in which the ORM translates
+// field definitions, selection labels and the strings of T, TC and TN.
+func (env Environment) Lang() string {
+	return env.context.GetString(LangKey)
+}
+
+// WithLang returns a copy of this Environment with the given language.
+// The returned Environment shares the transaction and the cache of env.
+func (env Environment) WithLang(lang string) Environment {
+	env.context = env.context.Copy().WithKey(LangKey, lang)
+	return env
+}
+
 // commit the transaction of this environment.
 //
 // WARNING: Do NOT call Commit on Environment instances that you
 // did not create yourself with NewEnvironment. The framework will
 // automatically commit the Environment.
 func (env Environment) commit() {
+	if env.Cr().savepoint != "" {
+		env.Cr().tx.Exec(fmt.Sprintf("RELEASE SAVEPOINT %s", env.Cr().savepoint))
+		return
+	}
 	env.Cr().tx.Commit()
 }
 
@@ -68,24 +102,81 @@ func (env Environment) commit() {
 // did not create yourself with NewEnvironment. Just panic instead
 // for the framework to roll back automatically for you.
 func (env Environment) rollback() {
+	if env.Cr().savepoint != "" {
+		env.Cr().tx.Exec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", env.Cr().savepoint))
+		env.Cr().tx.Exec(fmt.Sprintf("RELEASE SAVEPOINT %s", env.Cr().savepoint))
+		return
+	}
 	env.Cr().tx.Rollback()
 }
 
 // newEnvironment returns a new Environment for the given user ID
 //
+// If a test transaction is attached to the current goroutine by
+// SimulateInTestTransaction, the Environment uses this transaction
+// within a new savepoint instead of a new transaction.
+//
 // WARNING: Callers to newEnvironment should ensure to either call Commit()
 // or Rollback() on the returned Environment after operation to release
 // the database connection.
 func newEnvironment(uid int64) Environment {
+	var cr *Cursor
+	if testCr, ok := ctxManager.GetValue(testCursorKey); ok {
+		cr = newSavepoint(testCr.(*Cursor))
+	} else {
+		cr = newCursor(db)
+	}
 	env := Environment{
-		cr:      newCursor(db),
+		cr:      cr,
 		uid:     uid,
 		context: types.NewContext(),
 		cache:   newCache(),
+		calls:   new(methodCalls),
+	}
+	if requestID := RequestID(); requestID != "" {
+		env.context = env.context.WithKey(RequestIDKey, requestID)
+	}
+	if lang := RequestLang(); lang != "" {
+		env.context = env.context.WithKey(LangKey, lang)
 	}
 	return env
 }
 
+// WithRequestID executes fnct with the given request ID attached to the
+// current goroutine. Environments created during fnct have the request ID in
+// their context under RequestIDKey and SQL queries are logged with it, so
+// that they can be correlated with the HTTP request.
+func WithRequestID(requestID string, fnct func()) {
+	ctxManager.SetValues(gls.Values{RequestIDKey: requestID}, fnct)
+}
+
+// RequestID returns the request ID attached to the current goroutine
+// by WithRequestID or an empty string if there is none.
+func RequestID() string {
+	requestID, ok := ctxManager.GetValue(RequestIDKey)
+	if !ok {
+		return ""
+	}
+	return requestID.(string)
+}
+
+// WithRequestLang executes fnct with the given language attached to the
+// current goroutine. Environments created during fnct have this language
+// in their context under LangKey, unless it is empty.
+func WithRequestLang(lang string, fnct func()) {
+	ctxManager.SetValues(gls.Values{LangKey: lang}, fnct)
+}
+
+// RequestLang returns the language attached to the current goroutine
+// by WithRequestLang or an empty string if there is none.
+func RequestLang() string {
+	lang, ok := ctxManager.GetValue(LangKey)
+	if !ok {
+		return ""
+	}
+	return lang.(string)
+}
+
 // ExecuteInNewEnvironment executes the given fnct in a new Environment
 // within a new transaction.
 //
@@ -108,7 +199,7 @@ func ExecuteInNewEnvironment(uid int64, fnct func(Environment)) (rError error) {
 					}
 				}
 			}
-			rError = logging.LogPanicData(r)
+			rError = logging.LogPanicData(r, env.panicContext()...)
 			return
 		}
 		env.commit()
@@ -127,7 +218,7 @@ func SimulateInNewEnvironment(uid int64, fnct func(Environment)) (rError error)
 	defer func() {
 		env.rollback()
 		if r := recover(); r != nil {
-			rError = logging.LogPanicData(r)
+			rError = logging.LogPanicData(r, env.panicContext()...)
 			return
 		}
 	}()
@@ -135,6 +226,32 @@ func SimulateInNewEnvironment(uid int64, fnct func(Environment)) (rError error)
 	return
 }
 
+// SimulateInTestTransaction executes the given fnct in a new Environment
+// within a new transaction and rolls back the transaction at the end, like
+// SimulateInNewEnvironment.
+//
+// In addition, the Environments created during fnct in the same goroutine,
+// e.g. by ExecuteInNewEnvironment in the tested code, use this transaction
+// within a savepoint instead of a new transaction. Their changes are thus
+// visible to fnct and rolled back at the end too, so that tests can run in
+// any order without cleaning up the database.
+//
+// This function returns an error only if fnct panicked during its execution.
+func SimulateInTestTransaction(uid int64, fnct func(Environment)) (rError error) {
+	env := newEnvironment(uid)
+	defer func() {
+		env.rollback()
+		if r := recover(); r != nil {
+			rError = logging.LogPanicData(r, env.panicContext()...)
+			return
+		}
+	}()
+	ctxManager.SetValues(gls.Values{testCursorKey: env.cr}, func() {
+		fnct(env)
+	})
+	return
+}
+
 // Pool returns an empty RecordCollection for the given modelName
 func (env Environment) Pool(modelName string) *RecordCollection {
 	return newRecordCollection(env, modelName)
@@ -0,0 +1,45 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// Ref returns the record of the given model with the given external ID, such
+// as a record defined in a data file. It panics if there is no such record.
+//
+// External IDs are unique per model. Resolved external IDs are cached in
+// the environment, so that referencing the same record again does not query
+// the database, as long as the record is not modified.
+func (env Environment) Ref(modelName, externalID string) *RecordCollection {
+	rc := env.Pool(modelName)
+	id, ok := env.refID(rc.model, externalID)
+	if !ok {
+		log.Panic("Unknown external ID", "model", modelName, "externalID", externalID)
+	}
+	return rc.withIds([]int64{id})
+}
+
+// refID returns the id of the record of the given model with the given
+// external ID. The second returned value is false if there is no such record.
+func (env Environment) refID(model *Model, externalID string) (int64, bool) {
+	if id, ok := env.cache.getExternalID(model, externalID); ok {
+		return id, true
+	}
+	rc := env.Pool(model.name).Sudo()
+	// We deliberately call Search directly without Call so as not to be polluted by Search overrides
+	// such as "Active test".
+	rec := rc.Search(rc.Model().Field("DoxaExternalID").Equals(externalID)).Load("DoxaExternalID")
+	if rec.Len() != 1 {
+		return 0, false
+	}
+	env.cache.setExternalID(model, externalID, rec.ids[0])
+	return rec.ids[0], true
+}
+
+// SetExternalID sets the external ID of the record of this RecordCollection,
+// e.g. for records created from code, so that it can be referenced with
+// Environment.Ref and in data files. It panics if rc is not a singleton.
+func (rc *RecordCollection) SetExternalID(externalID string) {
+	rc.EnsureOne()
+	rc.Call("Write", FieldMap{"DoxaExternalID": externalID})
+	rc.env.cache.setExternalID(rc.model, externalID, rc.ids[0])
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// fieldAccessChannel is the bus channel on which field permission changes
+// are sent, so that each server process reloads its field ACLs. No channel
+// kind is registered for it, so that clients cannot subscribe to it.
+const fieldAccessChannel = "field_access"
+
+// watchFieldAccessOnce starts watchFieldAccess only once per process
+var watchFieldAccessOnce sync.Once
+
+// ErrFieldAccessNotAllowed is returned when a user who is not an
+// administrator tries to change field permissions.
+var ErrFieldAccessNotAllowed = errors.New("only administrators can change field permissions")
+
+// declareFieldAccessModel declares the FieldAccess model which persists the
+// field permissions set at runtime with SetFieldAccess. They are applied
+// on top of the permissions set in code each time the database is synced,
+// when the server starts with LoadFieldAccess and each time they are changed.
+//
+// Only administrators can access this model.
+func declareFieldAccessModel() {
+	fieldAccess := NewModel("FieldAccess")
+	fieldAccess.AddFields(map[string]FieldDefinition{
+		"ModelName":  CharField{Required: true, Index: true},
+		"FieldName":  CharField{Required: true},
+		"GroupID":    CharField{Required: true},
+		"Permission": IntegerField{Help: "Permission of the group on the field (1: Read, 2: Write, 3: Read and Write)"},
+	})
+	fieldAccess.AddMethod("Init",
+		`Init applies the field permissions stored in the database to the fields ACLs`,
+		func(rc *RecordCollection) {
+			loadFieldAccess(rc.Env())
+		})
+	fieldAccess.RestrictToAdmins()
+}
+
+// LoadFieldAccess applies the field permissions stored in the database with
+// SetFieldAccess to the fields ACLs, on top of the permissions set in code.
+// They are then reloaded each time they are changed by any server process,
+// once the bus relay is started.
+//
+// It must be called when starting the server, after BootStrap and once
+// connected to the database.
+func LoadFieldAccess() {
+	watchFieldAccessOnce.Do(func() {
+		go watchFieldAccess(bus.Subscribe(security.SuperUserID, fieldAccessChannel))
+	})
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		loadFieldAccess(env)
+	})
+	if err != nil {
+		log.Panic("Unable to load field permissions", "error", err)
+	}
+}
+
+// watchFieldAccess reloads the field permissions stored in the database
+// each time a message is received by the given subscriber.
+func watchFieldAccess(sub *bus.Subscriber) {
+	for range sub.C {
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			loadFieldAccess(env)
+		})
+		if err != nil {
+			log.Warn("Unable to reload field permissions", "error", err)
+		}
+	}
+}
+
+// loadFieldAccess applies the field permissions stored in the database
+// to the fields ACLs, reading them in the given environment.
+func loadFieldAccess(env Environment) {
+	for _, rec := range env.Pool("FieldAccess").Sudo().SearchAll().Records() {
+		fi, group, err := fieldAndGroup(rec.Get("ModelName").(string), rec.Get("FieldName").(string), rec.Get("GroupID").(string))
+		if err != nil {
+			log.Warn("Ignoring field access", "error", err)
+			continue
+		}
+		fi.acl.ReplacePermission(group, security.Permission(rec.Get("Permission").(int64)))
+	}
+}
+
+// An UnknownFieldAccessError is returned when trying to set a field
+// permission on a model, a field or a group that does not exist.
+type UnknownFieldAccessError string
+
+// Error returns the error message
+func (ufae UnknownFieldAccessError) Error() string {
+	return "Unknown " + string(ufae)
+}
+
+// fieldAndGroup returns the Field and the security group with the given names
+func fieldAndGroup(modelName, fieldName, groupID string) (*Field, *security.Group, error) {
+	model, ok := Registry.Get(modelName)
+	if !ok {
+		return nil, nil, UnknownFieldAccessError("model " + modelName)
+	}
+	fi, ok := model.fields.Get(fieldName)
+	if !ok {
+		return nil, nil, UnknownFieldAccessError("field " + modelName + "." + fieldName)
+	}
+	group := security.Registry.GetGroup(groupID)
+	if group == nil {
+		return nil, nil, UnknownFieldAccessError("group " + groupID)
+	}
+	return fi, group, nil
+}
+
+// SetFieldAccess sets the permission of the group with the given groupID on
+// the given field of the given model, replacing any existing permission.
+// Only security.Read and security.Write are taken into account.
+//
+// The permission is persisted in the transaction of env. It is applied
+// by all the server processes once the transaction is committed, so that
+// it is never applied if the transaction is rolled back, and it is restored
+// when the server restarts.
+//
+// The user of env must be an administrator, otherwise
+// ErrFieldAccessNotAllowed is returned.
+func SetFieldAccess(env Environment, modelName, fieldName, groupID string, perm security.Permission) error {
+	if !security.Registry.HasMembership(env.Uid(), security.GroupAdmin) {
+		return ErrFieldAccessNotAllowed
+	}
+	fi, group, err := fieldAndGroup(modelName, fieldName, groupID)
+	if err != nil {
+		return err
+	}
+	perm = perm & (security.Read | security.Write)
+	values := FieldMap{
+		"ModelName":  fi.model.name,
+		"FieldName":  fi.name,
+		"GroupID":    group.ID,
+		"Permission": int64(perm),
+	}
+	if existing := storedFieldAccess(env, fi, group); !existing.IsEmpty() {
+		existing.Call("Write", values)
+	} else {
+		env.Pool("FieldAccess").Sudo().Call("Create", values)
+	}
+	SendBus(env, fieldAccessChannel, values)
+	return nil
+}
+
+// storedFieldAccess returns the FieldAccess record of the
+// given group on the given field, read with Sudo in env.
+func storedFieldAccess(env Environment, fi *Field, group *security.Group) *RecordCollection {
+	rc := env.Pool("FieldAccess").Sudo()
+	return rc.Search(rc.Model().Field("ModelName").Equals(fi.model.name).
+		And().Field("FieldName").Equals(fi.name).
+		And().Field("GroupID").Equals(group.ID))
+}
+
+// fieldAccess returns the permission of the given group on the given field,
+// including the changes made in the transaction of env that are not applied
+// to the ACLs yet.
+func fieldAccess(env Environment, fi *Field, group *security.Group) security.Permission {
+	if stored := storedFieldAccess(env, fi, group); !stored.IsEmpty() {
+		return security.Permission(stored.Get("Permission").(int64))
+	}
+	return fi.acl.Permissions()[group]
+}
+
+// GrantFieldAccess grants the given perm to the group with the given groupID
+// on the given field at runtime, keeping its other permissions untouched.
+// Like SetFieldAccess, it can only be called by administrators.
+func GrantFieldAccess(env Environment, modelName, fieldName, groupID string, perm security.Permission) error {
+	fi, group, err := fieldAndGroup(modelName, fieldName, groupID)
+	if err != nil {
+		return err
+	}
+	return SetFieldAccess(env, modelName, fieldName, groupID, fieldAccess(env, fi, group)|perm)
+}
+
+// RevokeFieldAccess revokes the given perm from the group with the given groupID
+// on the given field at runtime, keeping its other permissions untouched.
+// Like SetFieldAccess, it can only be called by administrators.
+func RevokeFieldAccess(env Environment, modelName, fieldName, groupID string, perm security.Permission) error {
+	fi, group, err := fieldAndGroup(modelName, fieldName, groupID)
+	if err != nil {
+		return err
+	}
+	return SetFieldAccess(env, modelName, fieldName, groupID, fieldAccess(env, fi, group)&^perm)
+}
+
+// FieldPermissions returns the effective permissions of the user with the
+// given uid on each field of the given model, taking into account the
+// groups of the user and the groups they inherit.
+func FieldPermissions(uid int64, modelName string) map[string]security.Permission {
+	model := Registry.MustGet(modelName)
+	res := make(map[string]security.Permission)
+	for name, fi := range model.fields.registryByName {
+		var perm security.Permission
+		if checkFieldPermission(fi, uid, security.Read) {
+			perm |= security.Read
+		}
+		if checkFieldPermission(fi, uid, security.Write) {
+			perm |= security.Write
+		}
+		res[name] = perm
+	}
+	return res
+}
+
+// PermissionMatrix returns the effective field permissions of the user with
+// the given uid on all the models of the application, indexed by model name
+// and field name.
+func PermissionMatrix(uid int64) map[string]map[string]security.Permission {
+	res := make(map[string]map[string]security.Permission)
+	for name, model := range Registry.registryByName {
+		if model.isMixin() {
+			continue
+		}
+		res[name] = FieldPermissions(uid, name)
+	}
+	return res
+}
@@ -208,6 +208,8 @@ type Field struct {
 	inverse          string
 	filter           *Condition
 	translate        bool
+	anonymize        string
+	image            *ImageParams
 	updates          []map[string]interface{}
 }
 
@@ -276,6 +278,10 @@ func checkFieldInfo(fi *Field) {
 			"type", fi.fieldType)
 	}
 
+	if fi.image != nil {
+		checkImageParams(fi)
+	}
+
 	if fi.stored && !fi.isComputedField() {
 		log.Warn("'stored' should be set only on computed fields", "model", fi.model.name, "field", fi.name,
 			"type", fi.fieldType)
@@ -28,6 +28,9 @@ type FieldDefinition interface {
 //
 // Binary fields are stored in the database. Consider other disk based
 // alternatives if you have a large amount of data to store.
+//
+// Binary fields holding images can set the Image parameter to validate
+// and convert the images that are written.
 type BinaryField struct {
 	JSON       string
 	String     string
@@ -43,6 +46,8 @@ type BinaryField struct {
 	NoCopy     bool
 	GoType     interface{}
 	Translate  bool
+	Anonymize  string
+	Image      *ImageParams
 	OnChange   Methoder
 	Constraint Methoder
 	Inverse    Methoder
@@ -84,6 +89,8 @@ func (bf BinaryField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   bf.Default,
 		translate:     bf.Translate,
+		anonymize:     bf.Anonymize,
+		image:         bf.Image,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -109,6 +116,7 @@ type BooleanField struct {
 	NoCopy        bool
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -158,6 +166,7 @@ func (bf BooleanField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   defaultFunc,
 		translate:     bf.Translate,
+		anonymize:     bf.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -185,6 +194,7 @@ type CharField struct {
 	Size          int
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -227,6 +237,7 @@ func (cf CharField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   cf.Default,
 		translate:     cf.Translate,
+		anonymize:     cf.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -252,6 +263,7 @@ type DateField struct {
 	NoCopy        bool
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -293,6 +305,7 @@ func (df DateField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   df.Default,
 		translate:     df.Translate,
+		anonymize:     df.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -318,6 +331,7 @@ type DateTimeField struct {
 	NoCopy        bool
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -359,6 +373,7 @@ func (df DateTimeField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   df.Default,
 		translate:     df.Translate,
+		anonymize:     df.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -383,6 +398,7 @@ type FloatField struct {
 	Digits        nbutils.Digits
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -424,6 +440,7 @@ func (ff FloatField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldtype.Float,
 		defaultFunc:   ff.Default,
 		translate:     ff.Translate,
+		anonymize:     ff.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -450,6 +467,7 @@ type HTMLField struct {
 	Size          int
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -492,6 +510,7 @@ func (tf HTMLField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   tf.Default,
 		translate:     tf.Translate,
+		anonymize:     tf.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -515,6 +534,7 @@ type IntegerField struct {
 	NoCopy        bool
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -556,6 +576,7 @@ func (i IntegerField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   i.Default,
 		translate:     i.Translate,
+		anonymize:     i.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -672,6 +693,7 @@ type Many2OneField struct {
 	RelationModel Modeler
 	Embed         bool
 	Translate     bool
+	Anonymize     string
 	OnDelete      OnDeleteAction
 	OnChange      Methoder
 	Constraint    Methoder
@@ -727,6 +749,7 @@ func (mf Many2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 		onDelete:         onDelete,
 		defaultFunc:      mf.Default,
 		translate:        mf.Translate,
+		anonymize:        mf.Anonymize,
 		onChange:         onchange,
 		filter:           filter,
 		constraint:       constraint,
@@ -820,6 +843,7 @@ type One2OneField struct {
 	RelationModel Modeler
 	Embed         bool
 	Translate     bool
+	Anonymize     string
 	OnDelete      OnDeleteAction
 	OnChange      Methoder
 	Constraint    Methoder
@@ -875,6 +899,7 @@ func (of One2OneField) DeclareField(fc *FieldsCollection, name string) *Field {
 		onDelete:         onDelete,
 		defaultFunc:      of.Default,
 		translate:        of.Translate,
+		anonymize:        of.Anonymize,
 		onChange:         onchange,
 		filter:           filter,
 		constraint:       constraint,
@@ -968,6 +993,7 @@ type SelectionField struct {
 	NoCopy     bool
 	Selection  types.Selection
 	Translate  bool
+	Anonymize  string
 	OnChange   Methoder
 	Constraint Methoder
 	Inverse    Methoder
@@ -1004,6 +1030,7 @@ func (sf SelectionField) DeclareField(fc *FieldsCollection, name string) *Field
 		fieldType:   fieldtype.Selection,
 		defaultFunc: sf.Default,
 		translate:   sf.Translate,
+		anonymize:   sf.Anonymize,
 		onChange:    onchange,
 		constraint:  constraint,
 	}
@@ -1031,6 +1058,7 @@ type TextField struct {
 	Size          int
 	GoType        interface{}
 	Translate     bool
+	Anonymize     string
 	OnChange      Methoder
 	Constraint    Methoder
 	Inverse       Methoder
@@ -1073,6 +1101,7 @@ func (tf TextField) DeclareField(fc *FieldsCollection, name string) *Field {
 		fieldType:     fieldType,
 		defaultFunc:   tf.Default,
 		translate:     tf.Translate,
+		anonymize:     tf.Anonymize,
 		onChange:      onchange,
 		constraint:    constraint,
 	}
@@ -1201,6 +1230,10 @@ func (f *Field) setProperty(property string, value interface{}) {
 		f.filter = value.(*Condition)
 	case "translate":
 		f.translate = value.(bool)
+	case "anonymize":
+		f.anonymize = value.(string)
+	case "image":
+		f.image = value.(*ImageParams)
 	}
 }
 
@@ -1310,6 +1343,18 @@ func (f *Field) SetTranslate(value bool) *Field {
 	return f
 }
 
+// SetAnonymize overrides the value of the Anonymize parameter of this Field
+func (f *Field) SetAnonymize(value string) *Field {
+	f.addUpdate("anonymize", value)
+	return f
+}
+
+// SetImage overrides the value of the Image parameter of this Field
+func (f *Field) SetImage(value *ImageParams) *Field {
+	f.addUpdate("image", value)
+	return f
+}
+
 // SetDefault overrides the value of the Default parameter of this Field
 func (f *Field) SetDefault(value func(Environment) interface{}) *Field {
 	f.addUpdate("defaultFunc", value)
@@ -0,0 +1,74 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/tools/b64image"
+)
+
+// ImageParams are the constraints of the images written in a binary field,
+// set as its Image parameter. Zero values are not checked.
+type ImageParams struct {
+	// Formats are the allowed formats of the written images, detected from
+	// their content: "png", "jpeg" or "gif".
+	Formats []string
+	// MaxWidth and MaxHeight are the maximum dimensions in pixels
+	MaxWidth  int
+	MaxHeight int
+	// MaxSize is the maximum size in bytes of the written images
+	MaxSize int
+	// ConvertTo is the format to which the written images are converted,
+	// "png" or "jpeg". If empty, images are kept in their format.
+	ConvertTo string
+}
+
+// limits returns the b64image.Limits of these params
+func (ip *ImageParams) limits() b64image.Limits {
+	return b64image.Limits{
+		Formats:   ip.Formats,
+		MaxWidth:  ip.MaxWidth,
+		MaxHeight: ip.MaxHeight,
+		MaxSize:   ip.MaxSize,
+	}
+}
+
+// checkImageParams panics if the Image parameter of the given field is not valid
+func checkImageParams(fi *Field) {
+	if fi.fieldType != fieldtype.Binary {
+		log.Panic("Image parameter can only be set on binary fields", "model", fi.model.name, "field", fi.name, "type", fi.fieldType)
+	}
+	switch fi.image.ConvertTo {
+	case "", "png", "jpeg":
+	default:
+		log.Panic("Unsupported image conversion format", "model", fi.model.name, "field", fi.name, "format", fi.image.ConvertTo)
+	}
+}
+
+// processImageValues checks the values of the given fMap for binary fields
+// with an Image parameter and converts them to the format of the field.
+// Empty values are left as is. It panics if an image is not valid.
+func (m *Model) processImageValues(fMap FieldMap) {
+	for colName, value := range fMap {
+		fi, ok := m.fields.Get(colName)
+		if !ok || fi.image == nil {
+			continue
+		}
+		content, _ := value.(string)
+		if content == "" {
+			continue
+		}
+		if err := b64image.Check(content, fi.image.limits()); err != nil {
+			log.Panic(err.Error(), "model", m.name, "field", fi.name)
+		}
+		if fi.image.ConvertTo == "" {
+			continue
+		}
+		converted, err := b64image.Convert(content, fi.image.ConvertTo)
+		if err != nil {
+			log.Panic("Unable to convert image", "model", m.name, "field", fi.name, "error", err)
+		}
+		fMap[colName] = converted
+	}
+}
@@ -34,4 +34,15 @@ func init() {
 	declareCommonMixin()
 	declareBaseMixin()
 	declareModelMixin()
+	// declare framework models
+	declareAPIKeyModel()
+	declareTOTPModel()
+	declarePasswordHistoryModel()
+	declareFieldAccessModel()
+	declareLoginAttemptModels()
+	declareBusModels()
+	declareAttachmentModel()
+	declareJobModel()
+	declareWebhookModels()
+	declareSavedFilterModel()
 }
@@ -0,0 +1,261 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// Job states
+const (
+	// JobPending jobs are waiting to be run
+	JobPending = "pending"
+	// JobRunning jobs are being run by a job runner
+	JobRunning = "running"
+	// JobDone jobs have been run successfully
+	JobDone = "done"
+	// JobFailed jobs have failed JobMaxAttempts times
+	JobFailed = "failed"
+)
+
+var (
+	// JobMaxAttempts is the number of times a job is run before
+	// it is marked as failed.
+	JobMaxAttempts = 5
+	// JobRetryDelay is the delay before the first retry of a failed job.
+	// The delay is doubled at each subsequent retry.
+	JobRetryDelay = 30 * time.Second
+	// JobTimeout is the duration after which a running job is considered
+	// lost (e.g. because its process was killed) and is run again.
+	JobTimeout = 10 * time.Minute
+)
+
+// A Job is a job of the queue that is being run
+type Job struct {
+	ID      int64
+	Name    string
+	Payload json.RawMessage
+	// Attempt is the number of the current attempt to run
+	// this job, starting at 1.
+	Attempt int64
+}
+
+// A JobHandler runs the given job in the given environment.
+//
+// If it returns an error, the job is run again later, up to JobMaxAttempts
+// times. The transaction of env is committed even if an error is returned,
+// so that handlers can log their attempts. If the handler panics, the
+// transaction is rolled back and the job is run again later.
+type JobHandler func(env Environment, job *Job) error
+
+// jobHandlers are the registered job handlers by name
+var (
+	jobHandlers      = make(map[string]JobHandler)
+	jobHandlersMutex sync.RWMutex
+)
+
+// jobRunnerStop is closed to stop the job runner
+var jobRunnerStop chan struct{}
+
+// jobRunnerDone is closed when the job runner has stopped
+var jobRunnerDone chan struct{}
+
+// declareJobModel declares the Job model which stores the
+// queue of jobs to be run in the background by job runners.
+// Jobs can only be accessed by administrators, since their
+// payloads may hold any data.
+func declareJobModel() {
+	job := NewModel("Job")
+	job.AddFields(map[string]FieldDefinition{
+		"Name":    CharField{Required: true, Index: true, Help: "Name of the JobHandler of this job"},
+		"Payload": TextField{Help: "JSON encoded payload given to the handler"},
+		"State": SelectionField{Selection: types.Selection{
+			JobPending: "Pending",
+			JobRunning: "Running",
+			JobDone:    "Done",
+			JobFailed:  "Failed",
+		}, Required: true, Index: true, Default: DefaultValue(JobPending)},
+		"Attempts":    IntegerField{NoCopy: true},
+		"NextAttempt": DateTimeField{Index: true, Help: "Date after which the job can be run"},
+		"LastError":   TextField{NoCopy: true},
+		"DoneDate":    DateTimeField{NoCopy: true},
+	})
+	job.RestrictToAdmins()
+}
+
+// RegisterJobHandler registers the given handler for the jobs with the given name.
+// It panics if a handler is already registered with this name.
+func RegisterJobHandler(name string, handler JobHandler) {
+	jobHandlersMutex.Lock()
+	defer jobHandlersMutex.Unlock()
+	if _, exists := jobHandlers[name]; exists {
+		log.Panic("Job handler already registered", "name", name)
+	}
+	jobHandlers[name] = handler
+}
+
+// EnqueueJob adds a job to the queue to be run by the handler registered
+// with the given name. payload must be JSON serializable.
+//
+// The job is created in the transaction of env, so that it is only run if
+// the transaction is committed. It returns the job record.
+func EnqueueJob(env Environment, name string, payload interface{}) *RecordCollection {
+	jobHandlersMutex.RLock()
+	_, exists := jobHandlers[name]
+	jobHandlersMutex.RUnlock()
+	if !exists {
+		log.Panic("Unknown job handler", "name", name)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Panic("Unable to marshal job payload", "name", name, "error", err)
+	}
+	return env.Pool("Job").Sudo().Call("Create", FieldMap{
+		"Name":        name,
+		"Payload":     string(data),
+		"NextAttempt": dates.Now(),
+	}).(RecordSet).Collection()
+}
+
+// claimJob marks the next job to run as running and returns its id, or 0
+// if there is no job to run. Locked rows are skipped so that several job
+// runners can claim jobs concurrently.
+func claimJob() int64 {
+	var ids []int64
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		// Running jobs whose NextAttempt is past have timed out
+		env.cr.Select(&ids, `
+			UPDATE job SET state = ?, attempts = attempts + 1, next_attempt = ?
+			WHERE id = (
+				SELECT id FROM job WHERE state IN (?, ?) AND next_attempt <= ?
+				ORDER BY next_attempt, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+			RETURNING id`,
+			JobRunning, dates.Now().Add(JobTimeout), JobPending, JobRunning, dates.Now())
+	})
+	if err != nil {
+		log.Warn("Unable to claim job", "error", err)
+		return 0
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[0]
+}
+
+// runJob runs the job with the given id, which must have been
+// claimed, and updates its state with the result.
+func runJob(id int64) {
+	job := &Job{ID: id}
+	ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rec := env.Pool("Job").Sudo().withIds([]int64{id})
+		job.Name = rec.Get("Name").(string)
+		job.Payload = json.RawMessage(rec.Get("Payload").(string))
+		job.Attempt = rec.Get("Attempts").(int64)
+	})
+	name, attempts := job.Name, job.Attempt
+	jobHandlersMutex.RLock()
+	handler, exists := jobHandlers[name]
+	jobHandlersMutex.RUnlock()
+	var jobErr error
+	if !exists {
+		jobErr = fmt.Errorf("unknown job handler %s", name)
+	} else {
+		err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			jobErr = handler(env, job)
+		})
+		if err != nil {
+			jobErr = err
+		}
+	}
+	values := FieldMap{"State": JobDone, "DoneDate": dates.Now(), "LastError": ""}
+	switch {
+	case jobErr == nil:
+		log.Debug("Job done", "id", id, "name", name, "attempts", attempts)
+	case attempts >= int64(JobMaxAttempts):
+		log.Warn("Job failed", "id", id, "name", name, "attempts", attempts, "error", jobErr)
+		values = FieldMap{"State": JobFailed, "LastError": jobErr.Error()}
+	default:
+		delay := JobRetryDelay << uint(attempts-1)
+		log.Info("Job error, will retry", "id", id, "name", name, "attempts", attempts, "delay", delay, "error", jobErr)
+		values = FieldMap{"State": JobPending, "NextAttempt": dates.Now().Add(delay), "LastError": jobErr.Error()}
+	}
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		env.Pool("Job").Sudo().withIds([]int64{id}).Call("Write", values)
+	})
+	if err != nil {
+		log.Warn("Unable to update job state", "id", id, "error", err)
+	}
+}
+
+// RunPendingJobs runs the jobs of the queue that are due, one after
+// the other, until there are no more jobs to run or until stop is closed.
+// It returns the number of jobs that have been run.
+func RunPendingJobs(stop <-chan struct{}) int {
+	var count int
+	for {
+		select {
+		case <-stop:
+			return count
+		default:
+		}
+		id := claimJob()
+		if id == 0 {
+			return count
+		}
+		runJob(id)
+		count++
+	}
+}
+
+// StartJobRunner starts running the jobs of the queue in the background with
+// the given number of concurrent runners. The queue is polled every pollInterval.
+func StartJobRunner(pollInterval time.Duration, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	log.Info("Starting job runner", "pollInterval", pollInterval, "concurrency", concurrency)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	jobRunnerStop, jobRunnerDone = stop, done
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(pollInterval)
+			defer ticker.Stop()
+			for {
+				RunPendingJobs(stop)
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		log.Info("Job runner stopped")
+		close(done)
+	}()
+}
+
+// StopJobRunner stops the job runner started with StartJobRunner.
+// It waits for the jobs being run to finish.
+func StopJobRunner() {
+	if jobRunnerStop == nil {
+		return
+	}
+	close(jobRunnerStop)
+	<-jobRunnerDone
+	jobRunnerStop = nil
+}
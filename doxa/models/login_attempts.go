@@ -0,0 +1,224 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// declareLoginAttemptModels declares the LoginAttempt model which keeps an
+// audit trail of login attempts and the LoginLockout model which holds the
+// logins and IP addresses currently locked after too many failures.
+//
+// Only administrators can access these models.
+func declareLoginAttemptModels() {
+	loginAttempt := NewModel("LoginAttempt")
+	loginAttempt.AddFields(map[string]FieldDefinition{
+		"Login":       CharField{Index: true, Anonymize: AnonymizeNull},
+		"IP":          CharField{Index: true, Anonymize: AnonymizeNull},
+		"Success":     BooleanField{},
+		"Reason":      CharField{},
+		"AttemptDate": DateTimeField{Required: true, Index: true},
+	})
+
+	loginLockout := NewModel("LoginLockout")
+	loginLockout.AddFields(map[string]FieldDefinition{
+		"Key":         CharField{Required: true, Unique: true, Anonymize: AnonymizeName, Help: "Locked login ('login:<login>') or IP address ('ip:<address>')"},
+		"LockedUntil": DateTimeField{Required: true},
+	})
+
+	loginAttempt.RestrictToAdmins()
+	loginLockout.RestrictToAdmins()
+}
+
+// lockoutKeys returns the keys of the LoginLockout model for the given login and IP
+func lockoutKeys(login, ip string) []string {
+	res := []string{"login:" + login}
+	if ip != "" {
+		res = append(res, "ip:"+ip)
+	}
+	return res
+}
+
+// checkLockout returns a security.AccountLockedError if the
+// given login or IP address is currently locked.
+func checkLockout(env Environment, login, ip string) error {
+	rc := env.Pool("LoginLockout").Sudo()
+	locks := rc.Search(rc.Model().Field("Key").In(lockoutKeys(login, ip)).
+		And().Field("LockedUntil").Greater(dates.Now()))
+	if !locks.IsEmpty() {
+		return security.AccountLockedError(login)
+	}
+	return nil
+}
+
+// LoginLockedFor returns the time during which the given login is still
+// locked in the LoginLockout model, or 0 if it is not locked. Since locks are
+// stored in the database, they are shared by all the processes of the server.
+func LoginLockedFor(login string) (time.Duration, error) {
+	if db == nil {
+		return 0, errors.New("not connected to database")
+	}
+	var res time.Duration
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("LoginLockout").Sudo()
+		lock := rc.Search(rc.Model().Field("Key").Equals("login:" + login).
+			And().Field("LockedUntil").Greater(dates.Now())).Limit(1)
+		if !lock.IsEmpty() {
+			res = time.Until(lock.Get("LockedUntil").(dates.DateTime).Time)
+		}
+	})
+	return res, err
+}
+
+// recentFailures returns the number of failed attempts for the given field
+// value since the last successful login and within the lockout cooldown.
+func recentFailures(env Environment, field, value string) int {
+	policy := security.DefaultLockoutPolicy
+	rc := env.Pool("LoginAttempt").Sudo()
+	since := dates.Now().Add(-policy.Cooldown)
+	lastSuccess := rc.Search(rc.Model().Field(field).Equals(value).And().Field("Success").Equals(true)).
+		OrderBy("AttemptDate DESC").Limit(1)
+	if !lastSuccess.IsEmpty() {
+		if lsDate := lastSuccess.Get("AttemptDate").(dates.DateTime); lsDate.Greater(since) {
+			since = lsDate
+		}
+	}
+	return rc.Search(rc.Model().Field(field).Equals(value).
+		And().Field("Success").Equals(false).
+		And().Field("AttemptDate").Greater(since)).SearchCount()
+}
+
+// recordLoginAttempt logs the given login attempt and locks the login
+// and IP address if they have too many recent failures.
+func recordLoginAttempt(env Environment, login, ip string, err error) {
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	env.Pool("LoginAttempt").Sudo().Call("Create", FieldMap{
+		"Login":       login,
+		"IP":          ip,
+		"Success":     err == nil,
+		"Reason":      reason,
+		"AttemptDate": dates.Now(),
+	})
+	if err != nil {
+		if _, locked := err.(security.AccountLockedError); locked {
+			return
+		}
+	}
+	policy := security.DefaultLockoutPolicy
+	for field, value := range map[string]string{"Login": login, "IP": ip} {
+		if value == "" || !policy.ShouldLock(recentFailures(env, field, value)) {
+			continue
+		}
+		key := "login:" + value
+		if field == "IP" {
+			key = "ip:" + value
+		}
+		log.Warn("Locking after too many failed login attempts", "key", key, "cooldown", policy.Cooldown)
+		lockUntil(env, key, dates.Now().Add(policy.Cooldown))
+	}
+}
+
+// lockUntil creates or updates the lock with the given key
+func lockUntil(env Environment, key string, until dates.DateTime) {
+	rc := env.Pool("LoginLockout").Sudo()
+	lock := rc.Search(rc.Model().Field("Key").Equals(key))
+	if !lock.IsEmpty() {
+		lock.Call("Write", FieldMap{"LockedUntil": until})
+		return
+	}
+	rc.Call("Create", FieldMap{"Key": key, "LockedUntil": until})
+}
+
+// Authenticate authenticates the user with the given login and secret
+// against security.AuthenticationRegistry, enforcing
+// security.DefaultLockoutPolicy.
+//
+// Each attempt is recorded in the LoginAttempt model in its own transaction,
+// so that it is kept even if the caller's transaction is rolled back. After
+// too many failures, the login and the IP address are locked and this
+// function returns a security.AccountLockedError until the cooldown is over.
+// The login is also refused if the locks cannot be checked.
+func Authenticate(login, secret, ip string, context *types.Context) (int64, error) {
+	var uid int64
+	err := withLockout(login, ip, func() error {
+		var authErr error
+		uid, authErr = security.AuthenticationRegistry.Authenticate(login, secret, context)
+		return authErr
+	})
+	return uid, err
+}
+
+// withLockout calls check unless the given login or IP address is locked,
+// and records the attempt in the LoginAttempt model in its own transaction.
+// It returns the error of check, or a security.AccountLockedError if the
+// login or the IP address is locked. The attempt is also refused if the
+// locks cannot be checked.
+func withLockout(login, ip string, check func() error) error {
+	var lockErr error
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		lockErr = checkLockout(env, login, ip)
+	})
+	if err != nil {
+		log.Warn("Unable to check login locks", "login", login, "ip", ip, "error", err)
+		lockErr = err
+	}
+	if lockErr != nil {
+		err = lockErr
+	} else {
+		err = check()
+	}
+	ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		recordLoginAttempt(env, login, ip, err)
+	})
+	return err
+}
+
+// UserActive returns true if the user with the given uid exists and has not
+// been archived. It must be checked before trusting credentials that outlive
+// the login, such as JSON Web Tokens.
+//
+// It returns false for uid 0, which is no user, and if the application
+// has no User model.
+func UserActive(uid int64) bool {
+	if uid == 0 {
+		return false
+	}
+	if _, ok := Registry.Get("User"); !ok {
+		return false
+	}
+	var active bool
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rc := env.Pool("User")
+		cond := rc.Model().Field("ID").Equals(uid)
+		if _, ok := rc.model.fields.Get("Active"); ok {
+			cond = cond.And().Field("Active").Equals(true)
+		}
+		active = rc.Search(cond).SearchCount() > 0
+	})
+	return err == nil && active
+}
+
+// UnlockLogin removes the locks of the given login and IP address.
+// ip may be empty to unlock only the login.
+//
+// This function runs with the rights of the environment's user, which
+// must be allowed to unlink LoginLockout records (administrators only by
+// default).
+func UnlockLogin(env Environment, login, ip string) {
+	rc := env.Pool("LoginLockout")
+	locks := rc.Search(rc.Model().Field("Key").In(lockoutKeys(login, ip)))
+	if locks.IsEmpty() {
+		return
+	}
+	locks.Call("Unlink")
+}
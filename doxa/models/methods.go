@@ -16,6 +16,7 @@ package models
 
 import (
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/labneco/doxa/doxa/models/security"
@@ -26,6 +27,7 @@ type MethodsCollection struct {
 	model        *Model
 	registry     map[string]*Method
 	powerGroups  map[*security.Group]bool
+	adminOnly    bool
 	bootstrapped bool
 }
 
@@ -46,6 +48,16 @@ func (mc *MethodsCollection) get(methodName string) (*Method, bool) {
 	return mi, true
 }
 
+// Names returns the sorted names of all the methods of this collection
+func (mc *MethodsCollection) Names() []string {
+	res := make([]string, 0, len(mc.registry))
+	for name := range mc.registry {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
 // MustGet returns the Method of the given method. It panics if the
 // method is not found.
 func (mc *MethodsCollection) MustGet(methodName string) *Method {
@@ -164,6 +176,29 @@ func (m *Method) RevokeGroup(group *security.Group) *Method {
 	return m
 }
 
+// AllowedForUser returns true if the user with the given uid
+// can execute this method, whatever the caller.
+func (m *Method) AllowedForUser(uid int64) bool {
+	m.RLock()
+	defer m.RUnlock()
+	for group := range security.Registry.UserGroups(uid) {
+		if m.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of this method
+func (m *Method) Name() string {
+	return m.name
+}
+
+// Doc returns the documentation of this method
+func (m *Method) Doc() string {
+	return m.doc
+}
+
 // Underlying returns the underlysing method data object
 func (m *Method) Underlying() *Method {
 	return m
@@ -0,0 +1,576 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/operator"
+	"github.com/labneco/doxa/doxa/models/types"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/nbutils"
+)
+
+// NewMockEnvironment returns a new Environment for the given user ID whose
+// records are stored in memory instead of in the database.
+//
+// Mock environments are meant to unit test compute, onchange, constraint and
+// other model methods without a database. Records are created, read, written,
+// searched and deleted through the usual RecordSet API. The following is not
+// supported and panics or is ignored:
+//
+//   - Aggregates and grouped queries, as well as the child_of operator.
+//   - Queries executed directly on the Cursor of the Environment, which is nil,
+//     and the NextValue method of Sequences.
+//   - SQL constraints and 'on delete' actions of relation fields.
+//   - Environments created by ExecuteInNewEnvironment, which use the database.
+//
+// There is no transaction either: changes made before a method panics are kept.
+//
+// Models must have been bootstrapped with BootStrap, but no database connection
+// is needed. Webhooks are not dispatched for changes in mock environments.
+func NewMockEnvironment(uid int64) Environment {
+	if !Registry.bootstrapped {
+		log.Panic("Models must be bootstrapped before creating a mock environment")
+	}
+	env := Environment{
+		uid:     uid,
+		context: types.NewContext(),
+		cache:   newCache(),
+		calls:   new(methodCalls),
+		memory:  newMemoryStore(),
+	}
+	if lang := RequestLang(); lang != "" {
+		env.context = env.context.WithKey(LangKey, lang)
+	}
+	return env
+}
+
+// simulateInMockEnvironment executes fnct in a new mock Environment for the user
+// of env with a copy of the records of env, which is discarded at the end.
+func simulateInMockEnvironment(env Environment, fnct func(Environment)) {
+	fnct(Environment{
+		uid:     env.uid,
+		context: types.NewContext(),
+		cache:   newCache(),
+		calls:   new(methodCalls),
+		memory:  env.memory.clone(),
+	})
+}
+
+// IsMock returns true if this Environment has been created by NewMockEnvironment
+func (env Environment) IsMock() bool {
+	return env.memory != nil
+}
+
+// nextSequenceValue returns the next value of the given Sequence,
+// which is kept in memory for mock environments.
+func (env Environment) nextSequenceValue(seq *Sequence) int64 {
+	if env.memory == nil {
+		return seq.NextValue()
+	}
+	env.memory.sequences[seq.Name]++
+	return env.memory.sequences[seq.Name]
+}
+
+// A memoryStore holds the records of a mock Environment
+type memoryStore struct {
+	// records are the stored values of the records by model name and id
+	records map[string]map[int64]FieldMap
+	// m2mLinks are the rows of the many2many relation tables by table name
+	m2mLinks map[string][]FieldMap
+	lastID   map[string]int64
+	// sequences are the last values of the sequences by name
+	sequences map[string]int64
+}
+
+// newMemoryStore returns a new empty memoryStore
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		records:   make(map[string]map[int64]FieldMap),
+		m2mLinks:  make(map[string][]FieldMap),
+		lastID:    make(map[string]int64),
+		sequences: make(map[string]int64),
+	}
+}
+
+// clone returns a copy of this memoryStore
+func (ms *memoryStore) clone() *memoryStore {
+	res := newMemoryStore()
+	for model, records := range ms.records {
+		res.records[model] = make(map[int64]FieldMap, len(records))
+		for id, record := range records {
+			res.records[model][id] = record.Copy()
+		}
+	}
+	for table, rows := range ms.m2mLinks {
+		res.m2mLinks[table] = append([]FieldMap(nil), rows...)
+	}
+	for model, id := range ms.lastID {
+		res.lastID[model] = id
+	}
+	for name, value := range ms.sequences {
+		res.sequences[name] = value
+	}
+	return res
+}
+
+// insert stores a new record of the given model with the given stored values
+// and returns its id. Stored fields missing from fMap are set to null.
+func (ms *memoryStore) insert(mi *Model, fMap FieldMap) int64 {
+	ms.lastID[mi.name]++
+	id := ms.lastID[mi.name]
+	record := make(FieldMap)
+	for jsonName, fi := range mi.fields.registryByJSON {
+		if fi.isStored() {
+			record[jsonName] = nil
+		}
+	}
+	for field, value := range fMap {
+		record[mi.getRelatedFieldInfo(field).json] = value
+	}
+	record["id"] = id
+	mi.convertValuesToFieldType(&record)
+	if ms.records[mi.name] == nil {
+		ms.records[mi.name] = make(map[int64]FieldMap)
+	}
+	ms.records[mi.name][id] = record
+	return id
+}
+
+// update sets the given stored values on the records of the given model
+// with the given ids and returns the number of updated records.
+func (ms *memoryStore) update(mi *Model, ids []int64, fMap FieldMap) int64 {
+	values := make(FieldMap)
+	for field, value := range fMap {
+		values[mi.getRelatedFieldInfo(field).json] = value
+	}
+	mi.convertValuesToFieldType(&values)
+	var num int64
+	for _, id := range ids {
+		record, ok := ms.records[mi.name][id]
+		if !ok {
+			continue
+		}
+		for jsonName, value := range values {
+			record[jsonName] = value
+		}
+		num++
+	}
+	return num
+}
+
+// delete removes the records of the given model with the given ids, as well
+// as their many2many links, and returns the number of deleted records.
+func (ms *memoryStore) delete(mi *Model, ids []int64) int64 {
+	var num int64
+	for _, id := range ids {
+		if _, ok := ms.records[mi.name][id]; !ok {
+			continue
+		}
+		delete(ms.records[mi.name], id)
+		num++
+	}
+	for _, fi := range mi.fields.registryByJSON {
+		if fi.fieldType == fieldtype.Many2Many {
+			ms.setM2MLinks(fi, ids, nil)
+		}
+	}
+	return num
+}
+
+// m2mLinks returns the ids of the records linked by the given
+// many2many field to the record with the given id.
+func (ms *memoryStore) getM2MLinks(fi *Field, id int64) []int64 {
+	var res []int64
+	for _, row := range ms.m2mLinks[fi.m2mRelModel.tableName] {
+		if row[fi.m2mOurField.json] == id {
+			res = append(res, row[fi.m2mTheirField.json].(int64))
+		}
+	}
+	return res
+}
+
+// setM2MLinks replaces the links of the given many2many field of
+// the records with the given ids by links to relIds.
+func (ms *memoryStore) setM2MLinks(fi *Field, ids []int64, relIds []int64) {
+	table := fi.m2mRelModel.tableName
+	idsMap := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		idsMap[id] = true
+	}
+	var rows []FieldMap
+	for _, row := range ms.m2mLinks[table] {
+		if !idsMap[row[fi.m2mOurField.json].(int64)] {
+			rows = append(rows, row)
+		}
+	}
+	for _, id := range ids {
+		for _, relID := range relIds {
+			rows = append(rows, FieldMap{fi.m2mOurField.json: id, fi.m2mTheirField.json: relID})
+		}
+	}
+	ms.m2mLinks[table] = rows
+}
+
+// loadRecord adds to the given cache the values of the given paths of field
+// names for the record of the given model with the given id. The values of the
+// intermediate many2one and one2one fields of the paths are also added.
+func (ms *memoryStore) loadRecord(c *cache, mi *Model, id int64, paths []string) {
+	for _, path := range paths {
+		model, recID := mi, id
+		for _, expr := range jsonizeExpr(mi, strings.Split(path, ExprSep)) {
+			record, ok := ms.records[model.name][recID]
+			if !ok {
+				break
+			}
+			fi := model.fields.MustGet(expr)
+			c.updateEntry(model, recID, fi.json, record[fi.json])
+			relID, ok := record[fi.json].(int64)
+			if !ok || relID == 0 || fi.relatedModel == nil {
+				break
+			}
+			model, recID = fi.relatedModel, relID
+		}
+	}
+}
+
+// search returns the ids of the records matching the query of rc,
+// sorted and limited as the query.
+func (ms *memoryStore) search(rc *RecordCollection) []int64 {
+	if rc.query.isEmpty() && !rc.query.fetchAll {
+		return nil
+	}
+	if len(rc.query.groups) > 0 {
+		log.Panic("Grouped queries are not supported in mock environments", "model", rc.model.name, "groups", rc.query.groups)
+	}
+	rc.query.evaluateConditionArgFunctions()
+	var records []FieldMap
+	for _, record := range ms.records[rc.model.name] {
+		if ms.matchCondition(rc.model, record, rc.query.cond) {
+			records = append(records, record)
+		}
+	}
+	orders := rc.query.orders
+	if len(orders) == 0 {
+		orders = rc.model.defaultOrder
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, order := range orders {
+			tokens := strings.Fields(order)
+			exprs := jsonizeExpr(rc.model, strings.Split(tokens[0], ExprSep))
+			cmp, ok := compareValues(ms.pathValues(rc.model, records[i], exprs)[0], ms.pathValues(rc.model, records[j], exprs)[0])
+			if !ok || cmp == 0 {
+				continue
+			}
+			if len(tokens) > 1 && strings.ToUpper(tokens[1]) == "DESC" {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return records[i]["id"].(int64) < records[j]["id"].(int64)
+	})
+	ids := make([]int64, len(records))
+	for i, record := range records {
+		ids[i] = record["id"].(int64)
+	}
+	if rc.query.offset > 0 {
+		if rc.query.offset >= len(ids) {
+			return nil
+		}
+		ids = ids[rc.query.offset:]
+	}
+	if rc.query.limit > 0 && rc.query.limit < len(ids) {
+		ids = ids[:rc.query.limit]
+	}
+	return ids
+}
+
+// matchCondition returns true if the given record of the given model matches
+// cond. Predicates are evaluated with the precedence of their SQL clause.
+func (ms *memoryStore) matchCondition(mi *Model, record FieldMap, cond *Condition) bool {
+	if cond == nil || cond.IsEmpty() {
+		return true
+	}
+	var (
+		orGroups bool
+		current  bool
+	)
+	for i, p := range cond.predicates {
+		var match bool
+		if p.isCond {
+			match = ms.matchCondition(mi, record, p.cond)
+		} else {
+			match = ms.matchPredicate(mi, record, p)
+		}
+		if p.isNot {
+			match = !match
+		}
+		switch {
+		case i == 0:
+			current = match
+		case p.isCond && p.isOr:
+			current, orGroups = orGroups || current || match, false
+		case p.isCond:
+			current, orGroups = (orGroups || current) && match, false
+		case p.isOr:
+			orGroups, current = orGroups || current, match
+		default:
+			current = current && match
+		}
+	}
+	return orGroups || current
+}
+
+// matchPredicate returns true if the given record of the given model matches p.
+// Predicates on a path through x2many fields match if any of the related records
+// matches, as the joins of SQL queries.
+func (ms *memoryStore) matchPredicate(mi *Model, record FieldMap, p predicate) bool {
+	exprs := jsonizeExpr(mi, p.exprs)
+	fi := mi.getRelatedFieldInfo(strings.Join(exprs, ExprSep))
+	arg := p.arg
+	if fi.fieldType.IsFKRelationType() {
+		if valInt, err := nbutils.CastToInteger(arg); err == nil && valInt == 0 {
+			arg = nil
+		}
+	}
+	for _, value := range ms.pathValues(mi, record, exprs) {
+		if matchValue(p.operator, value, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathValues returns the values of the given path of JSON field names for
+// the given record of the given model. It returns several values if the path
+// goes through x2many fields, and a nil value if there is no related record.
+func (ms *memoryStore) pathValues(mi *Model, record FieldMap, exprs []string) []interface{} {
+	fi := mi.fields.MustGet(exprs[0])
+	id := record["id"].(int64)
+	var relIds []int64
+	switch fi.fieldType {
+	case fieldtype.One2Many, fieldtype.Rev2One:
+		for relID, relRecord := range ms.records[fi.relatedModel.name] {
+			if relRecord[fi.jsonReverseFK] == id {
+				relIds = append(relIds, relID)
+			}
+		}
+	case fieldtype.Many2Many:
+		relIds = ms.getM2MLinks(fi, id)
+	case fieldtype.Many2One, fieldtype.One2One:
+		if relID, ok := record[fi.json].(int64); ok && relID != 0 {
+			relIds = []int64{relID}
+		}
+	default:
+		return []interface{}{record[fi.json]}
+	}
+	if len(relIds) == 0 {
+		return []interface{}{nil}
+	}
+	var res []interface{}
+	for _, relID := range relIds {
+		if len(exprs) == 1 {
+			res = append(res, relID)
+			continue
+		}
+		relRecord, ok := ms.records[fi.relatedModel.name][relID]
+		if !ok {
+			res = append(res, nil)
+			continue
+		}
+		res = append(res, ms.pathValues(fi.relatedModel, relRecord, exprs[1:])...)
+	}
+	return res
+}
+
+// matchValue returns true if value matches arg with the given operator,
+// with the semantics of SQL operators for null values.
+func matchValue(op operator.Operator, value, arg interface{}) bool {
+	if isNullValue(value) || arg == nil {
+		switch {
+		case arg == nil && op == operator.Equals:
+			return isNullValue(value)
+		case arg == nil && op == operator.NotEquals:
+			return !isNullValue(value)
+		case arg == nil:
+			log.Panic("Null argument can only be used with = and != operators", "operator", op)
+		}
+		return false
+	}
+	switch op {
+	case operator.Equals, operator.NotEquals:
+		cmp, ok := compareValues(value, arg)
+		return (ok && cmp == 0) == (op == operator.Equals)
+	case operator.Greater, operator.GreaterOrEqual, operator.Lower, operator.LowerOrEqual:
+		cmp, ok := compareValues(value, arg)
+		if !ok {
+			log.Panic("Unable to compare values", "operator", op, "value", value, "arg", arg)
+		}
+		switch op {
+		case operator.Greater:
+			return cmp > 0
+		case operator.GreaterOrEqual:
+			return cmp >= 0
+		case operator.Lower:
+			return cmp < 0
+		}
+		return cmp <= 0
+	case operator.In, operator.NotIn:
+		argVal := reflect.ValueOf(arg)
+		if argVal.Kind() != reflect.Slice {
+			log.Panic("Argument of in and not in operators must be a slice", "operator", op, "arg", arg)
+		}
+		var found bool
+		for i := 0; i < argVal.Len(); i++ {
+			if cmp, ok := compareValues(value, argVal.Index(i).Interface()); ok && cmp == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (op == operator.In)
+	case operator.Like, operator.ILike:
+		return likePattern(fmt.Sprint(arg), op == operator.ILike).MatchString(fmt.Sprint(value))
+	case operator.Contains, operator.NotContains, operator.IContains, operator.NotIContains:
+		pattern := likePattern(fmt.Sprintf("%%%s%%", arg), op == operator.IContains || op == operator.NotIContains)
+		return pattern.MatchString(fmt.Sprint(value)) == (op == operator.Contains || op == operator.IContains)
+	}
+	log.Panic("Operator is not supported in mock environments", "operator", op)
+	return false
+}
+
+// isNullValue returns true if value is the null value of a relation field
+func isNullValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if ptr, ok := value.(*interface{}); ok && ptr == nil {
+		return true
+	}
+	return false
+}
+
+// likePattern returns a regular expression equivalent to the
+// given SQL LIKE pattern, case insensitive if insensitive is true.
+func likePattern(pattern string, insensitive bool) *regexp.Regexp {
+	var expr strings.Builder
+	if insensitive {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// compareValues compares the given values and returns -1, 0 or 1 if a is lower
+// than, equal to or greater than b. The second returned value is false if the
+// values cannot be compared. Dates and datetimes can be compared to strings.
+func compareValues(a, b interface{}) (int, bool) {
+	if isNullValue(a) || isNullValue(b) {
+		return 0, false
+	}
+	switch aVal := a.(type) {
+	case dates.Date:
+		if bStr, ok := b.(string); ok {
+			bDate, err := dates.ParseDate(dates.DefaultServerDateFormat, bStr)
+			if err != nil {
+				return 0, false
+			}
+			b = bDate
+		}
+		if bDate, ok := b.(dates.Date); ok {
+			return compareTimes(aVal.Lower(bDate), aVal.Greater(bDate)), true
+		}
+		return 0, false
+	case dates.DateTime:
+		if bStr, ok := b.(string); ok {
+			bDate, err := dates.ParseDateTime(dates.DefaultServerDateTimeFormat, bStr)
+			if err != nil {
+				return 0, false
+			}
+			b = bDate
+		}
+		if bDate, ok := b.(dates.DateTime); ok {
+			return compareTimes(aVal.Lower(bDate), aVal.Greater(bDate)), true
+		}
+		return 0, false
+	case bool:
+		bBool, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if aVal == bBool {
+			return 0, true
+		}
+		if aVal {
+			return 1, true
+		}
+		return -1, true
+	}
+	if _, ok := b.(dates.Date); ok {
+		cmp, ok := compareValues(b, a)
+		return -cmp, ok
+	}
+	if _, ok := b.(dates.DateTime); ok {
+		cmp, ok := compareValues(b, a)
+		return -cmp, ok
+	}
+	if aVal, bVal := reflect.ValueOf(a), reflect.ValueOf(b); aVal.Kind() == reflect.String && bVal.Kind() == reflect.String {
+		return strings.Compare(aVal.String(), bVal.String()), true
+	}
+	aFloat, aOk := numberValue(a)
+	bFloat, bOk := numberValue(b)
+	if !aOk || !bOk {
+		return 0, reflect.DeepEqual(a, b)
+	}
+	switch {
+	case aFloat < bFloat:
+		return -1, true
+	case aFloat > bFloat:
+		return 1, true
+	}
+	return 0, true
+}
+
+// numberValue returns the given value of any numeric
+// type as a float64 and true, or false if it is not a number.
+func numberValue(value interface{}) (float64, bool) {
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), true
+	}
+	return 0, false
+}
+
+// compareTimes returns the result of a comparison given
+// whether the first value is lower or greater.
+func compareTimes(lower, greater bool) int {
+	switch {
+	case lower:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// declarePasswordHistoryModel declares the PasswordHistory model which
+// keeps the hashes of the previous passwords of the users, so that the
+// password policy can prevent their reuse and enforce their expiry.
+//
+// Only administrators can access this model.
+func declarePasswordHistoryModel() {
+	passwordHistory := NewModel("PasswordHistory")
+	passwordHistory.AddFields(map[string]FieldDefinition{
+		"UserID":  IntegerField{Required: true, Index: true},
+		"Hash":    CharField{Required: true, NoCopy: true, Anonymize: AnonymizeName},
+		"SetDate": DateTimeField{Required: true},
+	})
+	passwordHistory.RestrictToAdmins()
+}
+
+// passwordHistory returns the password history records of
+// the given user, most recent first.
+func passwordHistory(env Environment, uid int64) *RecordCollection {
+	rc := env.Pool("PasswordHistory").Sudo()
+	return rc.Search(rc.Model().Field("UserID").Equals(uid)).OrderBy("SetDate DESC", "ID DESC")
+}
+
+// SetPassword checks the given password of the user with the given uid
+// against security.DefaultPasswordPolicy, records it in the user's
+// password history and returns its hash to be stored by the caller.
+//
+// It returns a security.PasswordPolicyError if the password does not comply
+// with the policy or if it is one of the user's previous passwords.
+func SetPassword(env Environment, uid int64, password string) (string, error) {
+	policy := security.DefaultPasswordPolicy
+	if err := policy.Check(password); err != nil {
+		return "", err
+	}
+	history := passwordHistory(env, uid).Records()
+	for i, rec := range history {
+		if i >= policy.HistorySize {
+			break
+		}
+		if security.CheckPassword(password, rec.Get("Hash").(string)) {
+			return "", security.PasswordPolicyError("must be different from your previous passwords")
+		}
+	}
+	hash := security.HashPassword(password)
+	env.Pool("PasswordHistory").Sudo().Call("Create", FieldMap{
+		"UserID":  uid,
+		"Hash":    hash,
+		"SetDate": dates.Now(),
+	})
+	// Keep at least the current password for expiry checks
+	keep := policy.HistorySize
+	if keep < 1 {
+		keep = 1
+	}
+	for i, rec := range history {
+		if i >= keep-1 {
+			rec.Call("Unlink")
+		}
+	}
+	return hash, nil
+}
+
+// PasswordExpired returns true if the password of the user with the given
+// uid is older than the maximum age set in security.DefaultPasswordPolicy.
+//
+// Users whose password has never been set with SetPassword are
+// considered as having a valid password.
+func PasswordExpired(env Environment, uid int64) bool {
+	last := passwordHistory(env, uid).Limit(1)
+	if last.IsEmpty() {
+		return false
+	}
+	return security.DefaultPasswordPolicy.Expired(last.Get("SetDate").(dates.DateTime).Time)
+}
+
+// ResetPassword sets the password of the user with the given uid with
+// SetPassword and removes the lock of the user's login, if any. It is meant
+// to recover installations where users cannot log in anymore.
+//
+// The hash is written directly in the Password column of the User model,
+// bypassing the Write method and its overrides. It returns the error of
+// SetPassword if the password does not comply with the password policy.
+func ResetPassword(env Environment, uid int64, password string) error {
+	rc := env.Pool("User").Sudo()
+	if _, ok := rc.model.fields.Get("Password"); !ok {
+		log.Panic("User model has no Password field")
+	}
+	user := rc.Search(rc.Model().Field("ID").Equals(uid))
+	if user.IsEmpty() {
+		log.Panic("Unknown user", "uid", uid)
+	}
+	hash, err := SetPassword(env, uid, password)
+	if err != nil {
+		return err
+	}
+	user.doUpdate(FieldMap{"Password": hash})
+	if _, ok := rc.model.fields.Get("Login"); !ok {
+		return nil
+	}
+	locks := env.Pool("LoginLockout").Sudo()
+	locks = locks.Search(locks.Model().Field("Key").In(lockoutKeys(user.Get("Login").(string), "")))
+	if !locks.IsEmpty() {
+		locks.Call("Unlink")
+	}
+	return nil
+}
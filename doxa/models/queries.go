@@ -16,6 +16,7 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/labneco/doxa/doxa/models/fieldtype"
@@ -51,6 +52,49 @@ type Query struct {
 	orders     []string
 }
 
+// GroupIntervalSep separates a date or datetime field from the interval by
+// which its values are grouped in a GroupBy expression, as in 'StartDate:month'.
+const GroupIntervalSep = ":"
+
+// groupIntervals are the intervals by which date and datetime fields can be
+// grouped, with the number of years, months and days they span.
+var groupIntervals = map[string][3]int{
+	"day":     {0, 0, 1},
+	"week":    {0, 0, 7},
+	"month":   {0, 1, 0},
+	"quarter": {0, 3, 0},
+	"year":    {1, 0, 0},
+}
+
+// IsGroupInterval returns true if the given interval can be
+// used to group date and datetime fields in a GroupBy query.
+func IsGroupInterval(interval string) bool {
+	_, ok := groupIntervals[interval]
+	return ok
+}
+
+// splitGroupInterval splits the given GroupBy expression into its field
+// expression and its interval, which is empty if there is none.
+func splitGroupInterval(group string) (string, string) {
+	if i := strings.Index(group, GroupIntervalSep); i >= 0 {
+		return group[:i], group[i+1:]
+	}
+	return group, ""
+}
+
+// truncateToInterval returns the given SQL expression truncated
+// to the given interval, or the expression itself if interval is empty.
+// It panics if interval is not one of groupIntervals.
+func truncateToInterval(sqlExpr, interval string) string {
+	if interval == "" {
+		return sqlExpr
+	}
+	if !IsGroupInterval(interval) {
+		log.Panic("Unknown interval", "interval", interval)
+	}
+	return fmt.Sprintf("date_trunc('%s', %s)", interval, sqlExpr)
+}
+
 // clone returns a pointer to a deep copy of this Query
 func (q Query) clone() *Query {
 	newCond := *q.cond
@@ -165,17 +209,24 @@ func (q *Query) sqlLimitOffsetClause() string {
 func (q *Query) sqlOrderByClause() string {
 	var fExprs [][]string
 	directions := make([]string, len(q.orders))
+	intervals := make([]string, len(q.orders))
+	grpIntervals := q.groupIntervals()
 	for i, order := range q.orders {
 		fieldOrder := strings.Split(strings.TrimSpace(order), " ")
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(fieldOrder[0], ExprSep))
+		field, interval := splitGroupInterval(fieldOrder[0])
+		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(field, ExprSep))
 		fExprs = append(fExprs, oExprs)
 		if len(fieldOrder) > 1 {
 			directions[i] = fieldOrder[1]
 		}
+		intervals[i] = interval
+		if interval == "" {
+			intervals[i] = grpIntervals[strings.Join(oExprs, ExprSep)]
+		}
 	}
 	resSlice := make([]string, len(q.orders))
 	for i, field := range fExprs {
-		resSlice[i] = q.joinedFieldExpression(field)
+		resSlice[i] = truncateToInterval(q.joinedFieldExpression(field), intervals[i])
 		resSlice[i] += fmt.Sprintf(" %s", directions[i])
 	}
 	if len(resSlice) == 0 {
@@ -187,18 +238,30 @@ func (q *Query) sqlOrderByClause() string {
 // sqlGroupByClause returns the sql string for the GROUP BY clause
 // of this Query
 func (q *Query) sqlGroupByClause() string {
-	var fExprs [][]string
-	for _, group := range q.groups {
-		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(group, ExprSep))
-		fExprs = append(fExprs, oExprs)
-	}
 	resSlice := make([]string, len(q.groups))
-	for i, field := range fExprs {
-		resSlice[i] = q.joinedFieldExpression(field)
+	for i, group := range q.groups {
+		field, interval := splitGroupInterval(group)
+		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(field, ExprSep))
+		resSlice[i] = truncateToInterval(q.joinedFieldExpression(oExprs), interval)
 	}
 	return fmt.Sprintf("GROUP BY %s", strings.Join(resSlice, ", "))
 }
 
+// groupIntervals returns the intervals of the groups of this query that
+// have one. Keys are the column expressions of the grouped fields joined
+// with ExprSep (e.g. 'user_id.create_date').
+func (q *Query) groupIntervals() map[string]string {
+	res := make(map[string]string)
+	for _, group := range q.groups {
+		field, interval := splitGroupInterval(group)
+		if interval == "" {
+			continue
+		}
+		res[strings.Join(jsonizeExpr(q.recordSet.model, strings.Split(field, ExprSep)), ExprSep)] = interval
+	}
+	return res
+}
+
 // deleteQuery returns the SQL query string and parameters to unlink
 // the rows pointed at by this Query object.
 func (q *Query) deleteQuery() (string, SQLParams) {
@@ -299,6 +362,8 @@ func (q *Query) selectGroupQuery(fields map[string]string) (string, SQLParams) {
 		fieldsList[i] = f
 		i++
 	}
+	// Sort fields so that the generated SQL is the same for the same query
+	sort.Strings(fieldsList)
 	fieldExprs, allExprs := q.selectData(fieldsList)
 	// Build up the query
 	// Fields
@@ -383,10 +448,16 @@ func (q *Query) fieldsSQL(fieldExprs [][]string) string {
 // [['user_id', 'name'] ['id'] ['profile_id', 'age']]
 func (q *Query) fieldsGroupSQL(fieldExprs [][]string, fields map[string]string) string {
 	fStr := make([]string, len(fieldExprs)+1)
+	intervals := q.groupIntervals()
 	for i, exprs := range fieldExprs {
 		aggFnct := fields[strings.Join(exprs, ExprSep)]
 		joins := q.generateTableJoins(exprs)
 		lastJoin := joins[len(joins)-1]
+		if interval, ok := intervals[strings.Join(exprs, ExprSep)]; ok {
+			sqlExpr := truncateToInterval(fmt.Sprintf("%s.%s", lastJoin.alias, lastJoin.expr), interval)
+			fStr[i] = fmt.Sprintf("%s AS %s", sqlExpr, strings.Join(exprs, sqlSep))
+			continue
+		}
 		fStr[i] = fmt.Sprintf("%s(%s.%s) AS %s", aggFnct, lastJoin.alias, lastJoin.expr, strings.Join(exprs, sqlSep))
 	}
 	fStr[len(fieldExprs)] = "count(1) AS __count"
@@ -589,7 +660,7 @@ func (q *Query) getAllExpressions() [][]string {
 func (q *Query) getOrderByExpressions() [][]string {
 	var exprs [][]string
 	for _, order := range q.orders {
-		orderField := strings.Split(strings.TrimSpace(order), " ")[0]
+		orderField, _ := splitGroupInterval(strings.Split(strings.TrimSpace(order), " ")[0])
 		oExprs := jsonizeExpr(q.recordSet.model, strings.Split(orderField, ExprSep))
 		exprs = append(exprs, oExprs)
 	}
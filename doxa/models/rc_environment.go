@@ -35,6 +35,12 @@ func (rc *RecordCollection) WithContext(key string, value interface{}) *RecordCo
 	return rc.WithEnv(newEnv)
 }
 
+// WithLang returns a copy of the current RecordCollection
+// with its Environment set to the given language.
+func (rc *RecordCollection) WithLang(lang string) *RecordCollection {
+	return rc.WithEnv(rc.env.WithLang(lang))
+}
+
 // WithNewContext returns a copy of the current RecordCollection with its context
 // replaced by the given one.
 func (rc *RecordCollection) WithNewContext(context *types.Context) *RecordCollection {
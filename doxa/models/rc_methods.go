@@ -59,9 +59,17 @@ func (rc *RecordCollection) CallMulti(methName string, args ...interface{}) []in
 	rSet := rc.WithEnv(newEnv)
 
 	var res []interface{}
-	ctxManager.SetValues(gls.Values{"layers": [2]*methodLayer{methLayer, previousLayer}}, func() {
+	values := gls.Values{"layers": [2]*methodLayer{methLayer, previousLayer}}
+	if span := rc.startMethodSpan(methName, values); span != nil {
+		defer span.End()
+	}
+	// The call is not popped if the method panics, so that
+	// the panic can be reported with the method in which it occurred.
+	rc.env.calls.push(rc.model.name, methName)
+	ctxManager.SetValues(values, func() {
 		res = rSet.callMulti(methLayer, args...)
 	})
+	rc.env.calls.pop()
 	return res
 }
 
@@ -23,12 +23,20 @@ import "strings"
 //
 // This method removes duplicates and change all field names to their json names.
 func (rc *RecordCollection) substituteRelatedFields(fields []string) ([]string, *RecordCollection) {
-	// Create a keys map with our fields
+	// Create a keys map with our fields and add them to res in order,
+	// so that the generated SQL is the same for the same fields.
 	keys := make(map[string]bool)
+	var res []string
+	addKey := func(key string) {
+		if !keys[key] {
+			keys[key] = true
+			res = append(res, key)
+		}
+	}
 	for _, field := range fields {
 		// Inflate our related fields
 		inflatedPath := jsonizePath(rc.model, rc.substituteRelatedInPath(field))
-		keys[inflatedPath] = true
+		addKey(inflatedPath)
 		// Add intermediate records to our map
 		exprs := strings.Split(inflatedPath, ExprSep)
 		if len(exprs) == 1 {
@@ -37,16 +45,9 @@ func (rc *RecordCollection) substituteRelatedFields(fields []string) ([]string,
 		var curPath string
 		for _, expr := range exprs {
 			curPath = strings.TrimLeft(curPath+ExprSep+expr, ExprSep)
-			keys[curPath] = true
+			addKey(curPath)
 		}
 	}
-	// Extract keys from our map to res
-	res := make([]string, len(keys))
-	var i int
-	for key := range keys {
-		res[i] = key
-		i++
-	}
 
 	// Substitute in RecordCollection query
 	substs := make(map[string][]string)
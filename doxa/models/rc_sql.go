@@ -0,0 +1,88 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// A CapturedSQL is an SQL query generated for a RecordCollection
+// with its parameters, as they would be sent to the database.
+//
+// Placeholders are kept in the '?' form of the query builder,
+// before they are rebound to the syntax of the database driver.
+type CapturedSQL struct {
+	SQL  string
+	Args SQLParams
+}
+
+// String returns the SQL query of c, followed by one line per parameter.
+// The output is stable and is meant to be compared with golden files.
+func (c CapturedSQL) String() string {
+	var res strings.Builder
+	res.WriteString(c.SQL)
+	res.WriteString("\n")
+	for i, arg := range c.Args {
+		fmt.Fprintf(&res, "-- $%d = %#v\n", i+1, arg)
+	}
+	return res.String()
+}
+
+// sqlRecordSet returns a copy of this RecordCollection with its own query,
+// so that generating SQL does not modify this RecordCollection.
+func (rc *RecordCollection) sqlRecordSet() *RecordCollection {
+	rSet := *rc
+	query := *rc.query
+	cond := *query.cond
+	query.cond = &cond
+	rSet.query = &query
+	return &rSet
+}
+
+// SelectSQL returns the SQL query that Load executes to retrieve the given
+// fields of this RecordCollection, without executing it. Record rules, default
+// orders and related fields are taken into account as in Load.
+//
+// It returns an empty CapturedSQL if Load would not query the database.
+func (rc *RecordCollection) SelectSQL(fields ...string) CapturedSQL {
+	if rc.query.isEmpty() {
+		return CapturedSQL{}
+	}
+	if len(rc.query.groups) > 0 {
+		log.Panic("Trying to select a grouped query", "model", rc.model, "groups", rc.query.groups)
+	}
+	rSet, _, dbFields := rc.sqlRecordSet().loadRecordSet(fields)
+	sql, args := rSet.query.selectQuery(dbFields)
+	return CapturedSQL{SQL: sql, Args: args}
+}
+
+// CountSQL returns the SQL query that SearchCount executes
+// for this RecordCollection, without executing it.
+func (rc *RecordCollection) CountSQL() CapturedSQL {
+	sql, args := rc.sqlRecordSet().countRecordSet().query.countQuery()
+	return CapturedSQL{SQL: sql, Args: args}
+}
+
+// AggregatesSQL returns the SQL query that Aggregates executes for the given
+// fields of this RecordCollection, which must be grouped, without executing it.
+func (rc *RecordCollection) AggregatesSQL(fieldNames ...FieldNamer) CapturedSQL {
+	if len(rc.query.groups) == 0 {
+		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
+	}
+	rSet, _, fieldsOperatorMap := rc.sqlRecordSet().aggregatesRecordSet(fieldNames)
+	sql, args := rSet.query.selectGroupQuery(fieldsOperatorMap)
+	return CapturedSQL{SQL: sql, Args: args}
+}
+
+// DeleteSQL returns the SQL query that deletes the records of this
+// RecordCollection with the record rules of the current user,
+// without executing it.
+func (rc *RecordCollection) DeleteSQL() CapturedSQL {
+	rSet := rc.sqlRecordSet().addRecordRuleConditions(rc.env.uid, security.Unlink)
+	sql, args := rSet.query.deleteQuery()
+	return CapturedSQL{SQL: sql, Args: args}
+}
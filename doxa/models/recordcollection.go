@@ -23,6 +23,7 @@ import (
 	"github.com/labneco/doxa/doxa/i18n"
 	"github.com/labneco/doxa/doxa/models/fieldtype"
 	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
 	"github.com/labneco/doxa/doxa/models/types/dates"
 	"github.com/jmoiron/sqlx"
 )
@@ -83,14 +84,19 @@ func (rc *RecordCollection) create(data FieldMapper) *RecordCollection {
 	rc.applyDefaults(&fMap, true)
 	rc.addAccessFieldsCreateData(&fMap)
 	rc.model.convertValuesToFieldType(&fMap)
+	rc.model.processImageValues(fMap)
 	fMap = rc.createEmbeddedRecords(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePKIfZero()
 	storedFieldMap := filterMapOnStoredFields(rc.model, fMap)
 	// insert in DB
 	var createdId int64
-	sql, args := rc.query.insertQuery(storedFieldMap)
-	rc.env.cr.Get(&createdId, sql, args...)
+	if rc.env.memory != nil {
+		createdId = rc.env.memory.insert(rc.model, storedFieldMap)
+	} else {
+		sql, args := rc.query.insertQuery(storedFieldMap)
+		rc.env.cr.Get(&createdId, sql, args...)
+	}
 
 	rc.env.cache.addRecord(rc.model, createdId, storedFieldMap)
 	rSet := rc.withIds([]int64{createdId})
@@ -100,6 +106,8 @@ func (rc *RecordCollection) create(data FieldMapper) *RecordCollection {
 	rSet.processInverseMethods(fMap)
 	rSet.processTriggers(fMap)
 	rSet.checkConstraints()
+	rSet.notifyBusChanges("create", rSet.ids)
+	rSet.dispatchWebhooks("create")
 	return rSet
 }
 
@@ -210,6 +218,7 @@ func (rc *RecordCollection) update(data FieldMapper, fieldsToUnset ...FieldNamer
 	// We process inverse method before we convert RecordSets to ids
 	rSet.processInverseMethods(fMap)
 	rSet.model.convertValuesToFieldType(&fMap)
+	rSet.model.processImageValues(fMap)
 	// clean our fMap from ID and non stored fields
 	fMap.RemovePK()
 	storedFieldMap := filterMapOnStoredFields(rSet.model, fMap)
@@ -223,6 +232,8 @@ func (rc *RecordCollection) update(data FieldMapper, fieldsToUnset ...FieldNamer
 	// compute stored fields
 	rSet.processTriggers(fMap)
 	rSet.checkConstraints()
+	rSet.notifyBusChanges("write", rSet.Ids())
+	rSet.dispatchWebhooks("write")
 	return true
 }
 
@@ -248,9 +259,14 @@ func (rc *RecordCollection) doUpdate(fMap FieldMap) {
 	fMap = filterMapOnAuthorizedFields(rc.model, fMap, rc.env.uid, security.Write)
 	// update DB
 	if len(fMap) > 0 {
-		sql, args := rc.query.updateQuery(fMap)
-		res := rc.env.cr.Execute(sql, args...)
-		if num, _ := res.RowsAffected(); num == 0 {
+		var num int64
+		if rc.env.memory != nil {
+			num = rc.env.memory.update(rc.model, rc.Ids(), fMap)
+		} else {
+			sql, args := rc.query.updateQuery(fMap)
+			num, _ = rc.env.cr.Execute(sql, args...).RowsAffected()
+		}
+		if num == 0 {
 			log.Panic("Trying to update an empty RecordSet", "model", rc.ModelName(), "values", fMap)
 		}
 	}
@@ -292,6 +308,14 @@ func (rc *RecordCollection) updateRelationFields(fMap FieldMap) {
 
 		case fieldtype.Rev2One:
 		case fieldtype.Many2Many:
+			if rc.env.memory != nil {
+				rc.env.memory.setM2MLinks(fi, rc.ids, value.([]int64))
+				for _, id := range rc.ids {
+					rc.env.cache.removeM2MLinks(fi, id)
+					rc.env.cache.addM2MLink(fi, id, value.([]int64))
+				}
+				continue
+			}
 			delQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s IN (?)`, fi.m2mRelModel.tableName, fi.m2mOurField.json)
 			rc.env.cr.Execute(delQuery, rc.ids)
 			for _, id := range rc.ids {
@@ -376,12 +400,18 @@ func (rc *RecordCollection) unlink() int64 {
 	if rSet.IsEmpty() {
 		return 0
 	}
-	sql, args := rSet.query.deleteQuery()
-	res := rSet.env.cr.Execute(sql, args...)
-	num, _ := res.RowsAffected()
+	rSet.dispatchWebhooks("unlink")
+	var num int64
+	if rSet.env.memory != nil {
+		num = rSet.env.memory.delete(rSet.model, ids)
+	} else {
+		sql, args := rSet.query.deleteQuery()
+		num, _ = rSet.env.cr.Execute(sql, args...).RowsAffected()
+	}
 	for _, id := range ids {
 		rc.env.cache.invalidateRecord(rc.model, id)
 	}
+	rc.notifyBusChanges("unlink", ids)
 	return num
 }
 
@@ -419,21 +449,51 @@ func (rc *RecordCollection) Offset(offset int) *RecordCollection {
 	return &rSet
 }
 
-// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions
+// OrderBy returns a new RecordSet ordered by the given ORDER BY expressions.
+//
+// An expression is a field expression, optionally followed by 'asc' or 'desc'.
+// Date and datetime fields can be ordered by interval as in GroupBy.
 func (rc *RecordCollection) OrderBy(exprs ...string) *RecordCollection {
+	for _, expr := range exprs {
+		fieldOrder := strings.Fields(expr)
+		if len(fieldOrder) == 0 {
+			log.Panic("Empty order by expression", "model", rc.model)
+		}
+		if _, interval := splitGroupInterval(fieldOrder[0]); interval != "" && !IsGroupInterval(interval) {
+			log.Panic("Unknown order by interval", "model", rc.model, "order", expr)
+		}
+		if len(fieldOrder) > 1 && !strings.EqualFold(fieldOrder[1], "asc") && !strings.EqualFold(fieldOrder[1], "desc") {
+			log.Panic("Unknown order by direction", "model", rc.model, "order", expr)
+		}
+	}
 	rSet := *rc
 	rSet.query = rSet.query.clone()
 	rSet.query.orders = append(rSet.query.orders, exprs...)
 	return &rSet
 }
 
-// GroupBy returns a new RecordSet grouped with the given GROUP BY expressions
+// GroupBy returns a new RecordSet grouped with the given GROUP BY expressions.
+//
+// Date and datetime fields can be grouped by interval by suffixing them with
+// GroupIntervalSep and one of 'day', 'week', 'month', 'quarter' or 'year',
+// as in 'StartDate:month'. Their aggregated value is then the start of the interval.
 func (rc *RecordCollection) GroupBy(fields ...FieldNamer) *RecordCollection {
 	rSet := *rc
 	rSet.query = rSet.query.clone()
 	exprs := make([]string, len(fields))
 	for i, f := range fields {
 		exprs[i] = string(f.FieldName())
+		field, interval := splitGroupInterval(exprs[i])
+		if interval == "" {
+			continue
+		}
+		if !IsGroupInterval(interval) {
+			log.Panic("Unknown group by interval", "model", rc.model, "group", exprs[i])
+		}
+		fi := rc.model.getRelatedFieldInfo(field)
+		if fi.fieldType != fieldtype.Date && fi.fieldType != fieldtype.DateTime {
+			log.Panic("Only date and datetime fields can be grouped by interval", "model", rc.model, "group", exprs[i], "type", fi.fieldType)
+		}
 	}
 	rSet.query.groups = append(rSet.query.groups, exprs...)
 	return &rSet
@@ -467,15 +527,25 @@ func (rc *RecordCollection) SearchAll() *RecordCollection {
 // SearchCount fetch from the database the number of records that match the RecordSet conditions
 // It panics in case of error
 func (rc *RecordCollection) SearchCount() int {
-	rSet := rc.Limit(0)
-	addNameSearchesToCondition(rSet.model, rSet.query.cond)
-	_, rSet = rSet.substituteRelatedFields([]string{"id"})
+	rSet := rc.countRecordSet()
+	if rSet.env.memory != nil {
+		return len(rSet.env.memory.search(rSet))
+	}
 	sql, args := rSet.query.countQuery()
 	var res int
 	rSet.env.cr.Get(&res, sql, args...)
 	return res
 }
 
+// countRecordSet returns the RecordCollection whose
+// query is executed by SearchCount.
+func (rc *RecordCollection) countRecordSet() *RecordCollection {
+	rSet := rc.Limit(0)
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	_, rSet = rSet.substituteRelatedFields([]string{"id"})
+	return rSet
+}
+
 // Load query all data of the RecordCollection and store in cache.
 // fields are the fields to retrieve in the path format,
 // i.e. "User.Profile.Age" or "user_id.profile_id.age".
@@ -499,19 +569,20 @@ func (rc *RecordCollection) Load(fields ...string) *RecordCollection {
 		prefetch = true
 		rSet = rc.prefetchRC
 	}
-	rSet = rSet.addRecordRuleConditions(rc.env.uid, security.Read)
-	if len(rSet.query.orders) == 0 {
-		rSet.query.orders = make([]string, len(rSet.model.defaultOrder))
-		copy(rSet.query.orders, rSet.model.defaultOrder)
-	}
+	rSet, fields, dbFields := rSet.loadRecordSet(fields)
 	var results []FieldMap
-	if len(fields) == 0 {
-		fields = rSet.model.fields.storedFieldNames()
+	if rSet.env.memory != nil {
+		ids := rSet.env.memory.search(rSet)
+		for _, id := range ids {
+			rSet.env.memory.loadRecord(rSet.env.cache, rSet.model, id, dbFields)
+		}
+		rSet = rSet.withIds(ids)
+		rSet.loadRelationFields(fields)
+		if prefetch {
+			return rc
+		}
+		return rSet
 	}
-	fields = filterOnAuthorizedFields(rSet.model, rSet.env.uid, fields, security.Read)
-	addNameSearchesToCondition(rSet.model, rSet.query.cond)
-	subFields, rSet := rSet.substituteRelatedFields(fields)
-	dbFields := filterOnDBFields(rSet.model, subFields)
 	sql, args := rSet.query.selectQuery(dbFields)
 	rows := dbQuery(rSet.env.cr.tx, sql, args...)
 	defer rows.Close()
@@ -535,6 +606,25 @@ func (rc *RecordCollection) Load(fields ...string) *RecordCollection {
 	return rSet
 }
 
+// loadRecordSet returns the RecordCollection whose query is executed by Load
+// for the given fields, together with the fields to load and the DB columns
+// to select.
+func (rc *RecordCollection) loadRecordSet(fields []string) (*RecordCollection, []string, []string) {
+	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
+	if len(rSet.query.orders) == 0 {
+		rSet.query.orders = make([]string, len(rSet.model.defaultOrder))
+		copy(rSet.query.orders, rSet.model.defaultOrder)
+	}
+	if len(fields) == 0 {
+		fields = rSet.model.fields.storedFieldNames()
+	}
+	fields = filterOnAuthorizedFields(rSet.model, rSet.env.uid, fields, security.Read)
+	addNameSearchesToCondition(rSet.model, rSet.query.cond)
+	subFields, rSet := rSet.substituteRelatedFields(fields)
+	dbFields := filterOnDBFields(rSet.model, subFields)
+	return rSet, fields, dbFields
+}
+
 // loadRelationFields loads one2many, many2many and rev2one fields from the given fields
 // names in this RecordCollection into the cache. fields of other types given in fields
 // are ignored.
@@ -547,10 +637,14 @@ func (rc *RecordCollection) loadRelationFields(fields []string) {
 				relRC := rc.env.Pool(fi.relatedModelName).Search(rc.Model().Field(fi.reverseFK).Equals(id)).Fetch()
 				rc.env.cache.updateEntry(rc.model, id, fieldName, relRC.ids)
 			case fieldtype.Many2Many:
-				query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, fi.m2mTheirField.json,
-					fi.m2mRelModel.tableName, fi.m2mOurField.json)
 				var ids []int64
-				rc.env.cr.Select(&ids, query, id)
+				if rc.env.memory != nil {
+					ids = rc.env.memory.getM2MLinks(fi, id)
+				} else {
+					query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ?`, fi.m2mTheirField.json,
+						fi.m2mRelModel.tableName, fi.m2mOurField.json)
+					rc.env.cr.Select(&ids, query, id)
+				}
 				rc.env.cache.updateEntry(rc.model, id, fieldName, ids)
 			case fieldtype.Rev2One:
 				relRC := rc.env.Pool(fi.relatedModelName).Search(rc.Model().Field(fi.reverseFK).Equals(id)).Fetch()
@@ -700,15 +794,10 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregat
 	if len(rc.query.groups) == 0 {
 		log.Panic("Trying to get aggregates of a non-grouped query", "model", rc.model)
 	}
-	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
-	fields := filterOnAuthorizedFields(rSet.model, rSet.env.uid, convertToStringSlice(fieldNames), security.Read)
-	subFields, rSet := rSet.substituteRelatedFields(fields)
-	dbFields := filterOnDBFields(rSet.model, subFields, true)
-
-	if len(rSet.query.orders) == 0 {
-		rSet = rSet.OrderBy(rSet.query.groups...)
+	rSet, fields, fieldsOperatorMap := rc.aggregatesRecordSet(fieldNames)
+	if rSet.env.memory != nil {
+		log.Panic("Aggregates are not supported in mock environments", "model", rc.model)
 	}
-	fieldsOperatorMap := rSet.fieldsGroupOperators(dbFields)
 	sql, args := rSet.query.selectGroupQuery(fieldsOperatorMap)
 	var res []GroupAggregateRow
 	rows := dbQuery(rSet.env.cr.tx, sql, args...)
@@ -725,20 +814,36 @@ func (rc *RecordCollection) Aggregates(fieldNames ...FieldNamer) []GroupAggregat
 		line := GroupAggregateRow{
 			Values:    vals,
 			Count:     int(cnt),
-			Condition: getGroupCondition(rc.query.groups, vals, rc.query.cond),
+			Condition: getGroupCondition(rc.model, rc.query.groups, vals, rc.query.cond),
 		}
 		res = append(res, line)
 	}
 	return res
 }
 
+// aggregatesRecordSet returns the RecordCollection whose query is executed by
+// Aggregates for the given fields, together with the fields to retrieve and
+// their aggregate functions.
+func (rc *RecordCollection) aggregatesRecordSet(fieldNames []FieldNamer) (*RecordCollection, []string, map[string]string) {
+	rSet := rc.addRecordRuleConditions(rc.env.uid, security.Read)
+	fields := filterOnAuthorizedFields(rSet.model, rSet.env.uid, convertToStringSlice(fieldNames), security.Read)
+	subFields, rSet := rSet.substituteRelatedFields(fields)
+	dbFields := filterOnDBFields(rSet.model, subFields, true)
+
+	if len(rSet.query.orders) == 0 {
+		rSet = rSet.OrderBy(rSet.query.groups...)
+	}
+	return rSet, fields, rSet.fieldsGroupOperators(dbFields)
+}
+
 // fieldsGroupOperators returns a map of fields to retrieve in a group by query.
 // The returned map has a field as key, and sql aggregate function as value.
 // it also includes 'field_count' for grouped fields
 func (rc *RecordCollection) fieldsGroupOperators(fields []string) map[string]string {
 	groups := make(map[string]bool)
 	for _, g := range rc.query.groups {
-		groups[rc.model.JSONizeFieldName(g)] = true
+		field, _ := splitGroupInterval(g)
+		groups[rc.model.JSONizeFieldName(field)] = true
 	}
 	res := make(map[string]string)
 	for _, dbf := range fields {
@@ -847,11 +952,64 @@ func (rc *RecordCollection) withIds(ids []int64) *RecordCollection {
 // The given src will be passed to fmt.Sprintf with the optional args
 // before being returned.
 func (rc *RecordCollection) T(src string, args ...interface{}) string {
-	lang := rc.Env().Context().GetString("lang")
+	lang := rc.Env().Lang()
 	transCode := i18n.TranslateCode(lang, "", src)
 	return fmt.Sprintf(transCode, args...)
 }
 
+// TC translates the given string in the given context to the language
+// specified by the 'lang' key of rc.Env().Context(). If there is no
+// translation in this context, the translation without context is used.
+// If for any reason the string cannot be translated, then src is returned.
+//
+// You MUST pass string literals as context and src to have them
+// extracted automatically
+//
+// The given src will be passed to fmt.Sprintf with the optional args
+// before being returned.
+func (rc *RecordCollection) TC(context, src string, args ...interface{}) string {
+	lang := rc.Env().Lang()
+	transCode := i18n.TC(lang, context, src)
+	return fmt.Sprintf(transCode, args...)
+}
+
+// TN translates the given singular or plural string to the language
+// specified by the 'lang' key of rc.Env().Context(), choosing the plural
+// form of the language for the count n. If for any reason the string cannot
+// be translated, then singular is returned if n is 1 and plural otherwise.
+//
+// You MUST pass string literals as singular and plural to have them
+// extracted automatically
+//
+// The translated string will be passed to fmt.Sprintf with the optional
+// args before being returned.
+func (rc *RecordCollection) TN(singular, plural string, n int, args ...interface{}) string {
+	lang := rc.Env().Lang()
+	transCode := i18n.TN(lang, singular, plural, n)
+	return fmt.Sprintf(transCode, args...)
+}
+
+// SelectionLabel returns the label of the value of the given selection field
+// of this record, translated to the language specified by the 'lang' key of
+// rc.Env().Context(). It returns an empty string if the field is not set or
+// if its value is not in the selection of the field.
+//
+// It panics if rc is not a singleton or if field is not a selection field.
+func (rc *RecordCollection) SelectionLabel(field FieldNamer) string {
+	rc.EnsureOne()
+	fi := rc.model.fields.MustGet(field.String())
+	if fi.fieldType != fieldtype.Selection {
+		log.Panic("SelectionLabel called on a non selection field", "model", rc.ModelName(), "field", fi.name)
+	}
+	value, _ := rc.Get(fi.name).(string)
+	label, ok := fi.selection[value]
+	if !ok {
+		return ""
+	}
+	lang := rc.Env().Lang()
+	return i18n.TranslateFieldSelection(lang, rc.model.name, fi.name, types.Selection{value: label})[value]
+}
+
 // Collection returns the underlying RecordCollection instance
 // i.e. itself
 func (rc *RecordCollection) Collection() *RecordCollection {
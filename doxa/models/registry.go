@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -63,6 +64,20 @@ func (mc *modelCollection) MustGet(nameOrJSON string) *Model {
 	return mi
 }
 
+// Names returns the sorted names of all the models that hold
+// records, i.e. all models except mixins and many2many link models.
+func (mc *modelCollection) Names() []string {
+	var res []string
+	for name, model := range mc.registryByName {
+		if model.isMixin() || model.isM2MLink() {
+			continue
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
 // GetSequence the given Sequence by name or by db name
 func (mc *modelCollection) GetSequence(nameOrJSON string) (s *Sequence, ok bool) {
 	s, ok = mc.sequences[nameOrJSON]
@@ -116,6 +131,7 @@ type Model struct {
 	sqlConstraints map[string]sqlConstraint
 	sqlErrors      map[string]string
 	defaultOrder   []string
+	busNotify      bool
 }
 
 // An sqlConstraint holds the data needed to create a table constraint in the database
@@ -361,6 +377,11 @@ func (m *Model) hasParentField() bool {
 	return parentExists
 }
 
+// Name returns the name of this model
+func (m *Model) Name() string {
+	return m.name
+}
+
 // Fields returns the fields collection of this model
 func (m *Model) Fields() *FieldsCollection {
 	return m.fields
@@ -435,6 +456,9 @@ func (m *Model) FieldsGet(fields ...FieldNamer) map[string]*FieldInfo {
 			ReadOnly:   fInfo.isReadOnly(),
 			ReverseFK:  fInfo.jsonReverseFK,
 			OnChange:   fInfo.onChange != "",
+			Unique:     fInfo.unique,
+			Size:       fInfo.size,
+			Embed:      fInfo.embed,
 		}
 	}
 	return res
@@ -0,0 +1,55 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+// A methodCall is the call of a method of a model
+type methodCall struct {
+	model  string
+	method string
+}
+
+// methodCalls is the stack of the method calls being executed in an
+// Environment. Calls that panic are not popped, so that the last call
+// of the stack is the method in which a panic occurred.
+type methodCalls []methodCall
+
+// push adds the call of the given method of the given model to the stack
+func (mc *methodCalls) push(model, method string) {
+	if mc == nil {
+		return
+	}
+	*mc = append(*mc, methodCall{model: model, method: method})
+}
+
+// pop removes the last call of the stack
+func (mc *methodCalls) pop() {
+	if mc == nil || len(*mc) == 0 {
+		return
+	}
+	*mc = (*mc)[:len(*mc)-1]
+}
+
+// last returns the last call of the stack and false if it is empty
+func (mc *methodCalls) last() (methodCall, bool) {
+	if mc == nil || len(*mc) == 0 {
+		return methodCall{}, false
+	}
+	return (*mc)[len(*mc)-1], true
+}
+
+// panicContext returns the context key/value pairs with which a panic
+// in this Environment is logged and reported: the user ID, the request ID
+// and the model and method in which the panic occurred, if any.
+func (env Environment) panicContext() []interface{} {
+	ctx := []interface{}{"uid", env.uid}
+	if env.context != nil {
+		if requestID, ok := env.context.Get(RequestIDKey).(string); ok && requestID != "" {
+			ctx = append(ctx, RequestIDKey, requestID)
+		}
+	}
+	if call, ok := env.calls.last(); ok {
+		ctx = append(ctx, "model", call.model, "method", call.method)
+	}
+	return ctx
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+var (
+	// ErrSavedFilterNotFound is returned when a saved filter does not
+	// exist or does not belong to the user.
+	ErrSavedFilterNotFound = errors.New("saved filter not found")
+	// ErrSavedFilterNotAllowed is returned when a user who is not an
+	// administrator tries to save or delete a filter shared with all users.
+	ErrSavedFilterNotAllowed = errors.New("only administrators can manage shared filters")
+)
+
+// An InvalidSavedFilterError is returned when trying to save a filter
+// without name, on an unknown model or with an invalid domain or context.
+type InvalidSavedFilterError string
+
+// Error returns the error message
+func (isfe InvalidSavedFilterError) Error() string {
+	return "Invalid saved filter: " + string(isfe)
+}
+
+// A SavedFilter is a search saved by a user on the records of a model
+type SavedFilter struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	ResModel string `json:"model_id"`
+	// ActionID is the id of the action in which the filter is available.
+	// It is available in all the actions of ResModel if empty.
+	ActionID string `json:"action_id"`
+	// Domain is the JSON encoded domain of the filter
+	Domain string `json:"domain"`
+	// Context is the JSON encoded context of the filter, which can hold a 'group_by' key
+	Context string `json:"context"`
+	// Sort is the comma separated list of the order expressions of the filter
+	Sort string `json:"sort"`
+	// IsDefault is true if the filter is applied when the user opens the action
+	IsDefault bool `json:"is_default"`
+	// Shared is true if the filter is available to all users
+	Shared bool `json:"shared"`
+}
+
+// declareSavedFilterModel declares the SavedFilter model which persists the
+// searches saved by users. Filters with a UserID of 0 are shared with all users.
+//
+// Users manage their filters through the GetFilters, CreateOrReplace
+// and DeleteFilter methods, which only give access to their own filters
+// and to shared filters. Record rules give the same access to the generic
+// methods: users can read their own filters and shared filters, and only
+// write and delete their own filters.
+func declareSavedFilterModel() {
+	savedFilter := NewModel("SavedFilter")
+	savedFilter.AddFields(map[string]FieldDefinition{
+		"Name":      CharField{Required: true},
+		"UserID":    IntegerField{Index: true, Help: "Owner of the filter. 0 if the filter is shared with all users"},
+		"ResModel":  CharField{Required: true, Index: true},
+		"ActionID":  CharField{Index: true, Help: "Action in which the filter is available. Leave empty for all actions of the model"},
+		"Domain":    TextField{Default: DefaultValue("[]")},
+		"Context":   TextField{Default: DefaultValue("{}")},
+		"Sort":      CharField{},
+		"IsDefault": BooleanField{},
+		"Active":    BooleanField{Default: DefaultValue(true)},
+	})
+	savedFilter.RestrictToOwner("UserID")
+	savedFilter.AddRecordRule(&RecordRule{
+		Name:      "SavedFilterShared",
+		Group:     security.GroupEveryone,
+		Condition: savedFilter.Field("UserID").Equals(0),
+		Perms:     security.Read,
+	})
+	savedFilter.AddMethod("GetFilters",
+		`GetFilters returns the filters of the current user and the shared filters
+		of the given model which are available in the action with the given id.`,
+		func(rc *RecordCollection, modelName, actionID string) []SavedFilter {
+			return GetSavedFilters(*rc.env, modelName, actionID)
+		}).AllowGroup(security.GroupEveryone)
+	savedFilter.AddMethod("CreateOrReplace",
+		`CreateOrReplace saves the given filter for the current user, replacing the
+		filter with the same name, model and action, and returns its id.`,
+		func(rc *RecordCollection, filter SavedFilter) int64 {
+			id, err := SaveFilter(*rc.env, filter)
+			if err != nil {
+				log.Panic(err.Error(), "filter", filter.Name, "model", filter.ResModel)
+			}
+			return id
+		}).AllowGroup(security.GroupEveryone)
+	savedFilter.AddMethod("DeleteFilter",
+		`DeleteFilter deletes the filter with the given id`,
+		func(rc *RecordCollection, id int64) {
+			if err := DeleteSavedFilter(*rc.env, id); err != nil {
+				log.Panic(err.Error(), "id", id)
+			}
+		}).AllowGroup(security.GroupEveryone)
+}
+
+// GetSavedFilters returns the filters of the current user of env and the
+// shared filters of the given model which are available in the action with
+// the given id, ordered by name.
+func GetSavedFilters(env Environment, modelName, actionID string) []SavedFilter {
+	rc := env.Pool("SavedFilter").Sudo()
+	cond := rc.Model().Field("ResModel").Equals(modelName).
+		And().Field("Active").Equals(true).
+		AndCond(rc.Model().Field("UserID").Equals(env.Uid()).Or().Field("UserID").Equals(0)).
+		AndCond(rc.Model().Field("ActionID").Equals(actionID).Or().Field("ActionID").Equals(""))
+	res := []SavedFilter{}
+	for _, rec := range rc.Search(cond).OrderBy("Name").Records() {
+		res = append(res, SavedFilter{
+			ID:        rec.Ids()[0],
+			Name:      rec.Get("Name").(string),
+			ResModel:  rec.Get("ResModel").(string),
+			ActionID:  rec.Get("ActionID").(string),
+			Domain:    rec.Get("Domain").(string),
+			Context:   rec.Get("Context").(string),
+			Sort:      rec.Get("Sort").(string),
+			IsDefault: rec.Get("IsDefault").(bool),
+			Shared:    rec.Get("UserID").(int64) == 0,
+		})
+	}
+	return res
+}
+
+// SaveFilter saves the given filter for the current user of env, or for all
+// users if it is shared, and returns its id. The filter with the same name,
+// model and action is replaced. If the filter is the default one, it replaces
+// the previous default filter of the user on this model and action.
+//
+// Only administrators can save shared filters.
+func SaveFilter(env Environment, filter SavedFilter) (int64, error) {
+	if err := checkSavedFilter(filter); err != nil {
+		return 0, err
+	}
+	uid := env.Uid()
+	if filter.Shared {
+		if !security.Registry.HasMembership(uid, security.GroupAdmin) {
+			return 0, ErrSavedFilterNotAllowed
+		}
+		uid = 0
+	}
+	if filter.Domain == "" {
+		filter.Domain = "[]"
+	}
+	if filter.Context == "" {
+		filter.Context = "{}"
+	}
+	rc := env.Pool("SavedFilter").Sudo()
+	if filter.IsDefault {
+		defaults := rc.Search(savedFilterScope(rc, uid, filter).And().Field("IsDefault").Equals(true))
+		if !defaults.IsEmpty() {
+			defaults.Call("Write", FieldMap{"IsDefault": false})
+		}
+	}
+	values := FieldMap{
+		"Name":      filter.Name,
+		"UserID":    uid,
+		"ResModel":  filter.ResModel,
+		"ActionID":  filter.ActionID,
+		"Domain":    filter.Domain,
+		"Context":   filter.Context,
+		"Sort":      filter.Sort,
+		"IsDefault": filter.IsDefault,
+		"Active":    true,
+	}
+	if existing := rc.Search(savedFilterScope(rc, uid, filter).And().Field("Name").Equals(filter.Name)).Limit(1); !existing.IsEmpty() {
+		existing.Call("Write", values)
+		return existing.Ids()[0], nil
+	}
+	return rc.Call("Create", values).(RecordSet).Ids()[0], nil
+}
+
+// savedFilterScope returns the condition on the saved filters of the user with
+// the given uid on the same model and action as the given filter.
+func savedFilterScope(rc *RecordCollection, uid int64, filter SavedFilter) *Condition {
+	return rc.Model().Field("UserID").Equals(uid).
+		And().Field("ResModel").Equals(filter.ResModel).
+		And().Field("ActionID").Equals(filter.ActionID)
+}
+
+// checkSavedFilter returns an InvalidSavedFilterError if the given
+// filter has no name, an unknown model or an invalid domain or context.
+func checkSavedFilter(filter SavedFilter) error {
+	if filter.Name == "" {
+		return InvalidSavedFilterError("name is required")
+	}
+	if _, ok := Registry.Get(filter.ResModel); !ok {
+		return InvalidSavedFilterError("unknown model " + filter.ResModel)
+	}
+	if filter.Domain != "" {
+		var domain []interface{}
+		if err := json.Unmarshal([]byte(filter.Domain), &domain); err != nil {
+			return InvalidSavedFilterError("domain is not a JSON list: " + err.Error())
+		}
+		if _, err := ParseDomain(domain); err != nil {
+			return InvalidSavedFilterError(err.Error())
+		}
+	}
+	if filter.Context != "" {
+		var ctx map[string]interface{}
+		if err := json.Unmarshal([]byte(filter.Context), &ctx); err != nil {
+			return InvalidSavedFilterError("context is not a JSON object: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// DeleteSavedFilter deletes the filter with the given id if it belongs to
+// the current user of env. Shared filters can only be deleted by administrators.
+func DeleteSavedFilter(env Environment, id int64) error {
+	rc := env.Pool("SavedFilter").Sudo()
+	filter := rc.Search(rc.Model().Field("ID").Equals(id))
+	if filter.IsEmpty() {
+		return ErrSavedFilterNotFound
+	}
+	switch filter.Get("UserID").(int64) {
+	case env.Uid():
+	case 0:
+		if !security.Registry.HasMembership(env.Uid(), security.GroupAdmin) {
+			return ErrSavedFilterNotAllowed
+		}
+	default:
+		return ErrSavedFilterNotFound
+	}
+	filter.Call("Unlink")
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// APIKeyPrefixLength is the number of leading characters of an API key
+	// that are stored in clear to help users identify their keys.
+	APIKeyPrefixLength = 8
+	// ScopeAll is the API scope that grants access to all endpoints
+	ScopeAll = "all"
+	// apiKeyBytes is the number of random bytes in a generated API key
+	apiKeyBytes = 32
+)
+
+// An InvalidAPIKeyError is returned when an API key is unknown,
+// revoked or expired.
+type InvalidAPIKeyError string
+
+// Error returns the error message
+func (iake InvalidAPIKeyError) Error() string {
+	return fmt.Sprintf("Invalid API key %s...", string(iake))
+}
+
+// GenerateAPIKey returns a new random API key.
+//
+// The key is returned in clear and should be shown only once to its owner.
+// Only its hash, as given by HashAPIKey, should be stored.
+func GenerateAPIKey() string {
+	buf := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate random API key", "error", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// APIKeyPrefix returns the public prefix of the given API key
+func APIKeyPrefix(key string) string {
+	if len(key) < APIKeyPrefixLength {
+		return key
+	}
+	return key[:APIKeyPrefixLength]
+}
+
+// HashAPIKey returns the hexadecimal SHA256 hash of the given API key.
+//
+// API keys being long random strings, a fast hash is enough to protect
+// them in the database while allowing lookups by hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIScopes is the list of scopes granted to an API key.
+type APIScopes []string
+
+// ParseAPIScopes returns the APIScopes from the given
+// comma separated list of scopes.
+func ParseAPIScopes(scopes string) APIScopes {
+	var res APIScopes
+	for _, scope := range strings.Split(scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		res = append(res, scope)
+	}
+	return res
+}
+
+// String returns the scopes as a comma separated list
+func (as APIScopes) String() string {
+	return strings.Join(as, ",")
+}
+
+// Allows returns true if these scopes grant access to all the given
+// required scopes. ScopeAll grants access to any scope.
+func (as APIScopes) Allows(required ...string) bool {
+	granted := make(map[string]bool)
+	for _, scope := range as {
+		if scope == ScopeAll {
+			return true
+		}
+		granted[scope] = true
+	}
+	for _, scope := range required {
+		if !granted[scope] {
+			return false
+		}
+	}
+	return true
+}
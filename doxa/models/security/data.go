@@ -0,0 +1,169 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// splitIDs returns the non empty items of the given
+// list separated by commas or pipes.
+func splitIDs(list string) []string {
+	var res []string
+	for _, item := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '|' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// DeclareGroup registers a group with the given ID, name and inherited
+// groups IDs in this GroupCollection. If a group with this ID is already
+// registered, its name is updated and the given inherited groups are
+// added to it and to the memberships of its existing members. It returns
+// the group.
+//
+// It panics if one of the inherited groups is not registered.
+func (gc *GroupCollection) DeclareGroup(ID, name string, inheritsIDs ...string) *Group {
+	var inherits []*Group
+	for _, inhID := range inheritsIDs {
+		inhGroup := gc.GetGroup(inhID)
+		if inhGroup == nil {
+			log.Panic("Unknown inherited group", "group", ID, "inherits", inhID)
+		}
+		inherits = append(inherits, inhGroup)
+	}
+	group := gc.GetGroup(ID)
+	if group == nil {
+		return gc.NewGroup(ID, name, inherits...)
+	}
+	gc.Lock()
+	if name != "" {
+		group.Name = name
+	}
+	var added []*Group
+	for _, inhGroup := range inherits {
+		var exists bool
+		for _, g := range group.Inherits {
+			if g == inhGroup {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			group.Inherits = append(group.Inherits, inhGroup)
+			added = append(added, inhGroup)
+		}
+	}
+	var members []int64
+	for uid, groups := range gc.memberships {
+		if _, ok := groups[group]; ok {
+			members = append(members, uid)
+		}
+	}
+	gc.Unlock()
+	// Existing members of the group get the newly inherited groups
+	for _, uid := range members {
+		for _, inhGroup := range added {
+			if !gc.HasMembership(uid, inhGroup) {
+				gc.AddMembership(uid, inhGroup, true)
+			}
+		}
+	}
+	return group
+}
+
+// LoadGroupFromEtree declares the group defined by the given
+// <group> element in the Registry. The element is of the form:
+//
+//	<group id="sale_manager" name="Sales Manager" inherits="sale_user"/>
+//
+// where inherits is an optional comma separated list of group IDs.
+func LoadGroupFromEtree(element *etree.Element) {
+	ID := element.SelectAttrValue("id", "")
+	if ID == "" {
+		log.Panic("Group declared without id", "element", element.Tag)
+	}
+	Registry.DeclareGroup(ID, element.SelectAttrValue("name", ID), splitIDs(element.SelectAttrValue("inherits", ""))...)
+}
+
+// LoadMembershipFromEtree adds the membership defined by the given
+// <membership> element to the Registry. The element is of the form:
+//
+//	<membership group="sale_manager" users="2,5"/>
+//
+// where users is a comma separated list of user ids.
+func LoadMembershipFromEtree(element *etree.Element) {
+	groupID := element.SelectAttrValue("group", "")
+	group := Registry.GetGroup(groupID)
+	if group == nil {
+		log.Panic("Unknown group in membership", "group", groupID)
+	}
+	for _, user := range splitIDs(element.SelectAttrValue("users", "")) {
+		uid, err := strconv.ParseInt(user, 10, 64)
+		if err != nil {
+			log.Panic("Invalid user id in membership", "group", groupID, "user", user)
+		}
+		Registry.AddMembership(uid, group)
+	}
+}
+
+// LoadGroupsCSVFile declares the groups defined in the given CSV file
+// in the Registry. The file must have the following columns:
+//
+//	id,name,inherits,users
+//
+// where inherits is a pipe separated list of group IDs and users a pipe
+// separated list of user ids that are members of the group. The last
+// two columns are optional.
+func LoadGroupsCSVFile(fileName string) {
+	csvFile, err := os.Open(fileName)
+	if err != nil {
+		log.Panic("Unable to open CSV groups file", "error", err, "fileName", fileName)
+	}
+	defer csvFile.Close()
+	r := csv.NewReader(csvFile)
+	r.FieldsPerRecord = -1
+	headers, err := r.Read()
+	if err != nil {
+		log.Panic("Unable to read CSV headers in groups file", "error", err, "fileName", fileName)
+	}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic("Unable to read CSV groups file", "error", err, "fileName", fileName, "line", line)
+		}
+		values := make(map[string]string)
+		for i, header := range headers {
+			if i < len(record) {
+				values[strings.TrimSpace(header)] = record[i]
+			}
+		}
+		if values["id"] == "" {
+			log.Panic("Group declared without id", "fileName", fileName, "line", line)
+		}
+		name := values["name"]
+		if name == "" {
+			name = values["id"]
+		}
+		group := Registry.DeclareGroup(values["id"], name, splitIDs(values["inherits"])...)
+		for _, user := range splitIDs(values["users"]) {
+			uid, err := strconv.ParseInt(user, 10, 64)
+			if err != nil {
+				log.Panic("Invalid user id in groups file", "fileName", fileName, "line", line, "user", user)
+			}
+			Registry.AddMembership(uid, group)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the only JOSE header we produce and accept
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// An InvalidTokenError is returned when a JWT cannot be verified
+// or has expired.
+type InvalidTokenError string
+
+// Error returns the error message
+func (ite InvalidTokenError) Error() string {
+	return "Invalid token: " + string(ite)
+}
+
+// JWTClaims are the claims of the JSON Web Tokens issued by Doxa
+type JWTClaims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// UID returns the id of the user this token has been issued for
+func (c JWTClaims) UID() int64 {
+	uid, _ := strconv.ParseInt(c.Subject, 10, 64)
+	return uid
+}
+
+// A JWTSigner issues and verifies HS256 JSON Web Tokens.
+//
+// Tokens are signed with SigningKey. Tokens signed with one of the
+// VerificationKeys are also accepted, which allows rotating keys without
+// invalidating tokens already issued.
+type JWTSigner struct {
+	SigningKey       []byte
+	VerificationKeys [][]byte
+	Issuer           string
+	Expiry           time.Duration
+}
+
+// NewToken returns a new signed token for the user with the given uid.
+func (s *JWTSigner) NewToken(uid int64) (string, error) {
+	if len(s.SigningKey) == 0 {
+		return "", errors.New("no JWT signing key configured")
+	}
+	now := time.Now()
+	claims := JWTClaims{
+		Subject:   strconv.FormatInt(uid, 10),
+		Issuer:    s.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.Expiry).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtEncode([]byte(jwtHeader)) + "." + jwtEncode(payload)
+	return unsigned + "." + jwtEncode(jwtSign(s.SigningKey, unsigned)), nil
+}
+
+// ParseToken verifies the given token and returns its claims.
+//
+// It returns an InvalidTokenError if the token is malformed, if its
+// signature does not match any of our keys or if it has expired.
+func (s *JWTSigner) ParseToken(token string) (JWTClaims, error) {
+	var claims JWTClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, InvalidTokenError("malformed token")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(header) != jwtHeader {
+		return claims, InvalidTokenError("unsupported token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, InvalidTokenError("malformed signature")
+	}
+	unsigned := parts[0] + "." + parts[1]
+	var valid bool
+	for _, key := range append([][]byte{s.SigningKey}, s.VerificationKeys...) {
+		if len(key) > 0 && hmac.Equal(signature, jwtSign(key, unsigned)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return claims, InvalidTokenError("wrong signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, InvalidTokenError("malformed payload")
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, InvalidTokenError("malformed payload")
+	}
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return claims, InvalidTokenError("wrong issuer")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, InvalidTokenError("token expired")
+	}
+	return claims, nil
+}
+
+// jwtEncode returns the base64url encoding of data without padding
+func jwtEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// jwtSign returns the HMAC-SHA256 signature of data with the given key
+func jwtSign(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"fmt"
+	"time"
+)
+
+// An AccountLockedError is returned when trying to authenticate a user
+// whose account (or IP address) has been locked after too many failures.
+type AccountLockedError string
+
+// Error returns the error message
+func (ale AccountLockedError) Error() string {
+	return fmt.Sprintf("Too many failed login attempts for %s. Please try again later", string(ale))
+}
+
+// A LockoutPolicy defines when accounts are locked after failed login attempts
+type LockoutPolicy struct {
+	// MaxFailures is the number of consecutive failed attempts after which
+	// the account or the IP address is locked. Zero disables locking.
+	MaxFailures int
+	// Cooldown is the duration during which the account stays locked.
+	// Failures older than Cooldown are not counted.
+	Cooldown time.Duration
+}
+
+// DefaultLockoutPolicy is the lockout policy of the application.
+// It is set from the configuration when the server starts.
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxFailures: 5,
+	Cooldown:    15 * time.Minute,
+}
+
+// ShouldLock returns true if an account or IP address
+// with the given number of recent failures must be locked.
+func (lp LockoutPolicy) ShouldLock(failures int) bool {
+	return lp.MaxFailures > 0 && failures >= lp.MaxFailures
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters used for new password hashes
+const (
+	argon2Time    uint32 = 1
+	argon2Memory  uint32 = 64 * 1024
+	argon2Threads uint8  = 4
+	argon2KeyLen  uint32 = 32
+	argon2SaltLen        = 16
+)
+
+// A PasswordPolicyError is returned when a password
+// does not comply with the PasswordPolicy.
+type PasswordPolicyError string
+
+// Error returns the error message
+func (ppe PasswordPolicyError) Error() string {
+	return "Password does not comply with the password policy: " + string(ppe)
+}
+
+// A PasswordPolicy defines the rules that the passwords of users must follow.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters of a password
+	MinLength int
+	// RequireUpper, RequireLower, RequireDigit and RequireSymbol require
+	// at least one character of the corresponding class.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// MaxAge is the duration after which a password must be changed.
+	// Zero means that passwords never expire.
+	MaxAge time.Duration
+	// HistorySize is the number of previous passwords of a user that
+	// cannot be reused. Zero disables the check.
+	HistorySize int
+}
+
+// DefaultPasswordPolicy is the password policy of the application.
+// It is set from the configuration when the server starts.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 8,
+}
+
+// Check returns a PasswordPolicyError if the given
+// password does not comply with this policy.
+func (pp PasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < pp.MinLength {
+		return PasswordPolicyError(fmt.Sprintf("must be at least %d characters long", pp.MinLength))
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case pp.RequireUpper && !upper:
+		return PasswordPolicyError("must contain an uppercase letter")
+	case pp.RequireLower && !lower:
+		return PasswordPolicyError("must contain a lowercase letter")
+	case pp.RequireDigit && !digit:
+		return PasswordPolicyError("must contain a digit")
+	case pp.RequireSymbol && !symbol:
+		return PasswordPolicyError("must contain a symbol")
+	}
+	return nil
+}
+
+// Expired returns true if a password set at the given
+// time must be changed according to this policy.
+func (pp PasswordPolicy) Expired(setAt time.Time) bool {
+	if pp.MaxAge == 0 || setAt.IsZero() {
+		return false
+	}
+	return time.Since(setAt) > pp.MaxAge
+}
+
+// HashPassword returns the argon2id hash of the given password encoded
+// in the PHC string format, with a random salt.
+func HashPassword(password string) string {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		log.Panic("Unable to generate random salt", "error", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// CheckPassword returns true if the given password matches
+// the given hash returned by HashPassword.
+func CheckPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var (
+		version, memory, iterations uint32
+		threads                     uint8
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	otherKey := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
+
+// PasswordNeedsRehash returns true if the given hash has not been computed
+// with the current hashing parameters and should be replaced by a new hash
+// the next time the user logs in.
+func PasswordNeedsRehash(hash string) bool {
+	prefix := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$", argon2.Version, argon2Memory, argon2Time, argon2Threads)
+	return !strings.HasPrefix(hash, prefix)
+}
@@ -53,7 +53,10 @@ func (acl *AccessControlList) CheckPermission(group *Group, perm Permission) boo
 	if perm == 0 {
 		log.Panic("Trying to check nil permission for group", "group", group.Name)
 	}
-	if acl.perms[group]&perm == perm {
+	acl.RLock()
+	groupPerm := acl.perms[group]
+	acl.RUnlock()
+	if groupPerm&perm == perm {
 		return true
 	}
 	for _, inhGroup := range group.Inherits {
@@ -64,6 +67,8 @@ func (acl *AccessControlList) CheckPermission(group *Group, perm Permission) boo
 
 // Permissions returns the list of all permissions of this ACL
 func (acl *AccessControlList) Permissions() map[*Group]Permission {
+	acl.RLock()
+	defer acl.RUnlock()
 	res := make(map[*Group]Permission)
 	for k, v := range acl.perms {
 		res[k] = v
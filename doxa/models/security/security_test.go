@@ -15,8 +15,13 @@
 package security
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/beevik/etree"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -177,3 +182,255 @@ func TestGroupRegistry(t *testing.T) {
 		})
 	})
 }
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys", t, func() {
+		Convey("Generated keys should be unique and hashable", func() {
+			key1 := GenerateAPIKey()
+			key2 := GenerateAPIKey()
+			So(key1, ShouldHaveLength, 64)
+			So(key1, ShouldNotEqual, key2)
+			So(APIKeyPrefix(key1), ShouldEqual, key1[:APIKeyPrefixLength])
+			So(HashAPIKey(key1), ShouldEqual, HashAPIKey(key1))
+			So(HashAPIKey(key1), ShouldNotEqual, HashAPIKey(key2))
+			So(HashAPIKey(key1), ShouldNotContainSubstring, key1)
+		})
+		Convey("Parsing and checking scopes", func() {
+			scopes := ParseAPIScopes(" rpc, read,,")
+			So(scopes, ShouldResemble, APIScopes{"rpc", "read"})
+			So(scopes.String(), ShouldEqual, "rpc,read")
+			So(scopes.Allows("rpc"), ShouldBeTrue)
+			So(scopes.Allows("rpc", "read"), ShouldBeTrue)
+			So(scopes.Allows("rpc", "write"), ShouldBeFalse)
+			So(scopes.Allows(), ShouldBeTrue)
+			So(APIScopes{ScopeAll}.Allows("rpc", "write"), ShouldBeTrue)
+			So(APIScopes{}.Allows("rpc"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestJWT(t *testing.T) {
+	Convey("Testing JSON Web Tokens", t, func() {
+		signer := &JWTSigner{SigningKey: []byte("secret"), Issuer: "doxa", Expiry: time.Hour}
+		token, err := signer.NewToken(12)
+		So(err, ShouldBeNil)
+		So(strings.Split(token, "."), ShouldHaveLength, 3)
+		Convey("Valid tokens should be parsed", func() {
+			claims, err := signer.ParseToken(token)
+			So(err, ShouldBeNil)
+			So(claims.UID(), ShouldEqual, 12)
+			So(claims.Issuer, ShouldEqual, "doxa")
+		})
+		Convey("Tokens signed with old keys should be accepted", func() {
+			newSigner := &JWTSigner{SigningKey: []byte("new"), VerificationKeys: [][]byte{[]byte("secret")},
+				Issuer: "doxa", Expiry: time.Hour}
+			claims, err := newSigner.ParseToken(token)
+			So(err, ShouldBeNil)
+			So(claims.UID(), ShouldEqual, 12)
+		})
+		Convey("Tokens with wrong signature or issuer should be rejected", func() {
+			_, err := (&JWTSigner{SigningKey: []byte("other")}).ParseToken(token)
+			So(err, ShouldHaveSameTypeAs, InvalidTokenError(""))
+			_, err = (&JWTSigner{SigningKey: []byte("secret"), Issuer: "other"}).ParseToken(token)
+			So(err, ShouldHaveSameTypeAs, InvalidTokenError(""))
+			parts := strings.Split(token, ".")
+			_, err = signer.ParseToken(parts[0] + "." + parts[1] + "x." + parts[2])
+			So(err, ShouldNotBeNil)
+			_, err = signer.ParseToken("abc")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Expired tokens should be rejected", func() {
+			expSigner := &JWTSigner{SigningKey: []byte("secret"), Expiry: -time.Minute}
+			expToken, _ := expSigner.NewToken(12)
+			_, err := expSigner.ParseToken(expToken)
+			So(err, ShouldEqual, InvalidTokenError("token expired"))
+		})
+	})
+}
+
+func TestTOTP(t *testing.T) {
+	Convey("Testing TOTP two-factor authentication", t, func() {
+		Convey("Codes should match RFC 6238 test vectors", func() {
+			// "12345678901234567890" in base32
+			secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+			code, err := TOTPCode(secret, time.Unix(59, 0))
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, "287082")
+			code, _ = TOTPCode(secret, time.Unix(1111111109, 0))
+			So(code, ShouldEqual, "081804")
+		})
+		Convey("Verification should accept codes within the allowed skew", func() {
+			secret := GenerateTOTPSecret()
+			now := time.Now()
+			code, _ := TOTPCode(secret, now)
+			So(VerifyTOTP(secret, code, now), ShouldBeTrue)
+			So(VerifyTOTP(secret, code, now.Add(TOTPPeriod)), ShouldBeTrue)
+			So(VerifyTOTP(secret, code, now.Add(3*TOTPPeriod)), ShouldBeFalse)
+			So(VerifyTOTP(secret, "abc", now), ShouldBeFalse)
+			So(VerifyTOTP("not base32!", "123456", now), ShouldBeFalse)
+			step, ok := MatchTOTP(secret, code, now.Add(TOTPPeriod))
+			So(ok, ShouldBeTrue)
+			So(step, ShouldEqual, TOTPStep(now))
+		})
+		Convey("Provisioning URI should be well formed", func() {
+			uri := TOTPProvisioningURI("ABCDEF", "Doxa", "admin")
+			So(uri, ShouldStartWith, "otpauth://totp/Doxa:admin?")
+			So(uri, ShouldContainSubstring, "secret=ABCDEF")
+			So(uri, ShouldContainSubstring, "issuer=Doxa")
+		})
+		Convey("Recovery codes should be unique and hashed case-insensitively", func() {
+			codes := GenerateRecoveryCodes(10)
+			So(codes, ShouldHaveLength, 10)
+			So(codes[0], ShouldNotEqual, codes[1])
+			So(codes[0], ShouldHaveLength, 11)
+			So(HashRecoveryCode(strings.ToUpper(codes[0])), ShouldEqual, HashRecoveryCode(codes[0]))
+		})
+	})
+}
+
+func TestPasswords(t *testing.T) {
+	Convey("Testing password hashing and policy", t, func() {
+		Convey("Hashed passwords should be checked", func() {
+			hash := HashPassword("s3cret!")
+			So(hash, ShouldStartWith, "$argon2id$")
+			So(hash, ShouldNotEqual, HashPassword("s3cret!"))
+			So(CheckPassword("s3cret!", hash), ShouldBeTrue)
+			So(CheckPassword("secret", hash), ShouldBeFalse)
+			So(CheckPassword("s3cret!", "plain"), ShouldBeFalse)
+			So(PasswordNeedsRehash(hash), ShouldBeFalse)
+			So(PasswordNeedsRehash("$argon2id$v=19$m=1024,t=1,p=1$abc$def"), ShouldBeTrue)
+		})
+		Convey("Policy should enforce complexity rules", func() {
+			policy := PasswordPolicy{MinLength: 6, RequireUpper: true, RequireDigit: true, RequireSymbol: true}
+			So(policy.Check("Ab1!"), ShouldHaveSameTypeAs, PasswordPolicyError(""))
+			So(policy.Check("abcde1!"), ShouldEqual, PasswordPolicyError("must contain an uppercase letter"))
+			So(policy.Check("Abcdef!"), ShouldEqual, PasswordPolicyError("must contain a digit"))
+			So(policy.Check("Abcde12"), ShouldEqual, PasswordPolicyError("must contain a symbol"))
+			So(policy.Check("Abcde1!"), ShouldBeNil)
+		})
+		Convey("Policy should enforce password expiry", func() {
+			policy := PasswordPolicy{MaxAge: time.Hour}
+			So(policy.Expired(time.Now().Add(-2*time.Hour)), ShouldBeTrue)
+			So(policy.Expired(time.Now()), ShouldBeFalse)
+			So(PasswordPolicy{}.Expired(time.Now().Add(-2*time.Hour)), ShouldBeFalse)
+		})
+	})
+}
+
+func TestShareTokens(t *testing.T) {
+	Convey("Testing record share tokens", t, func() {
+		key := []byte("share-secret")
+		token := NewShareToken(key, "SaleOrder", 42, ShareRead, []string{"Name", "AmountTotal"}, time.Hour)
+		Convey("Valid tokens should be parsed", func() {
+			claims, err := ParseShareToken(key, token)
+			So(err, ShouldBeNil)
+			So(claims.Model, ShouldEqual, "SaleOrder")
+			So(claims.RecordID, ShouldEqual, 42)
+			So(claims.Allows(ShareRead), ShouldBeTrue)
+			So(claims.Allows(ShareComment), ShouldBeFalse)
+			So(claims.AllowsField("Name", "name"), ShouldBeTrue)
+			So(claims.AllowsField("AmountTotal", "amount_total"), ShouldBeTrue)
+			So(claims.AllowsField("Notes", "notes"), ShouldBeFalse)
+		})
+		Convey("Comment access should imply read access", func() {
+			claims, _ := ParseShareToken(key, NewShareToken(key, "SaleOrder", 42, ShareComment, []string{"Name"}, time.Hour))
+			So(claims.Allows(ShareRead), ShouldBeTrue)
+			So(claims.Allows(ShareComment), ShouldBeTrue)
+		})
+		Convey("Tampered, foreign or expired tokens should be rejected", func() {
+			_, err := ParseShareToken([]byte("other"), token)
+			So(err, ShouldEqual, InvalidTokenError("wrong signature"))
+			parts := strings.Split(token, ".")
+			_, err = ParseShareToken(key, jwtEncode([]byte(`{"m":"SaleOrder","id":42,"a":"read","f":["Name","Notes"],"exp":9999999999}`))+"."+parts[1])
+			So(err, ShouldEqual, InvalidTokenError("wrong signature"))
+			_, err = ParseShareToken(key, NewShareToken(key, "SaleOrder", 42, ShareRead, []string{"Name"}, -time.Minute))
+			So(err, ShouldEqual, InvalidTokenError("token expired"))
+			_, err = ParseShareToken(key, "garbage")
+			So(err, ShouldEqual, InvalidTokenError("malformed token"))
+		})
+	})
+}
+
+func TestPayloadSignatures(t *testing.T) {
+	Convey("Testing payload signatures", t, func() {
+		secret := []byte("webhook-secret")
+		payload := []byte(`{"event":"create"}`)
+		signature := SignPayload(secret, payload)
+		Convey("Signatures should be prefixed HMAC-SHA256 hex digests", func() {
+			So(signature, ShouldStartWith, "sha256=")
+			So(signature, ShouldHaveLength, 7+64)
+		})
+		Convey("Valid signatures should be accepted with or without prefix", func() {
+			So(CheckPayloadSignature(secret, payload, signature), ShouldBeTrue)
+			So(CheckPayloadSignature(secret, payload, strings.TrimPrefix(signature, "sha256=")), ShouldBeTrue)
+		})
+		Convey("Wrong signatures, payloads or secrets should be rejected", func() {
+			So(CheckPayloadSignature(secret, []byte(`{"event":"unlink"}`), signature), ShouldBeFalse)
+			So(CheckPayloadSignature([]byte("other"), payload, signature), ShouldBeFalse)
+			So(CheckPayloadSignature(nil, payload, SignPayload(nil, payload)), ShouldBeFalse)
+			So(CheckPayloadSignature(secret, payload, ""), ShouldBeFalse)
+		})
+	})
+}
+
+func TestLockoutPolicy(t *testing.T) {
+	Convey("Testing lockout policy", t, func() {
+		policy := LockoutPolicy{MaxFailures: 3, Cooldown: time.Minute}
+		So(policy.ShouldLock(2), ShouldBeFalse)
+		So(policy.ShouldLock(3), ShouldBeTrue)
+		So(LockoutPolicy{}.ShouldLock(100), ShouldBeFalse)
+		So(AccountLockedError("admin").Error(), ShouldContainSubstring, "admin")
+	})
+}
+
+func TestGroupsFromData(t *testing.T) {
+	Convey("Testing groups declared in data files", t, func() {
+		Convey("Groups and memberships should be loaded from XML", func() {
+			doc := etree.NewDocument()
+			So(doc.ReadFromString(`<doxa><data>
+	<group id="data_user" name="Data User"/>
+	<group id="data_manager" name="Data Manager" inherits="data_user"/>
+	<membership group="data_manager" users="42, 43"/>
+</data></doxa>`), ShouldBeNil)
+			LoadGroupFromEtree(doc.FindElement("doxa/data/group[@id='data_user']"))
+			LoadGroupFromEtree(doc.FindElement("doxa/data/group[@id='data_manager']"))
+			LoadMembershipFromEtree(doc.FindElement("doxa/data/membership"))
+			user, manager := Registry.GetGroup("data_user"), Registry.GetGroup("data_manager")
+			So(user, ShouldNotBeNil)
+			So(manager.Name, ShouldEqual, "Data Manager")
+			So(manager.Inherits, ShouldContain, user)
+			So(Registry.HasMembership(42, manager), ShouldBeTrue)
+			So(Registry.HasMembership(43, user), ShouldBeTrue)
+			Convey("Declaring an existing group should update it", func() {
+				other := Registry.NewGroup("data_other", "Data Other")
+				Registry.DeclareGroup("data_manager", "Data Boss", "data_other", "data_user")
+				So(manager.Name, ShouldEqual, "Data Boss")
+				So(manager.Inherits, ShouldHaveLength, 2)
+				So(manager.Inherits, ShouldContain, other)
+				So(Registry.HasMembership(42, other), ShouldBeTrue)
+				So(Registry.UserGroups(42)[other], ShouldEqual, InheritedGroup)
+				Registry.UnregisterGroup(other)
+			})
+			Convey("Unknown inherited groups should panic", func() {
+				So(func() { Registry.DeclareGroup("data_wrong", "Wrong", "data_unknown") }, ShouldPanic)
+			})
+			Registry.UnregisterGroup(manager)
+			Registry.UnregisterGroup(user)
+		})
+		Convey("Groups and memberships should be loaded from CSV", func() {
+			f, err := ioutil.TempFile("", "groups")
+			So(err, ShouldBeNil)
+			f.WriteString("id,name,inherits,users\ncsv_user,CSV User,,\ncsv_manager,CSV Manager,csv_user,7|8\n")
+			f.Close()
+			LoadGroupsCSVFile(f.Name())
+			os.Remove(f.Name())
+			user, manager := Registry.GetGroup("csv_user"), Registry.GetGroup("csv_manager")
+			So(user.Name, ShouldEqual, "CSV User")
+			So(manager.Inherits, ShouldContain, user)
+			So(Registry.HasMembership(8, manager), ShouldBeTrue)
+			So(Registry.HasMembership(7, user), ShouldBeTrue)
+			Registry.UnregisterGroup(manager)
+			Registry.UnregisterGroup(user)
+		})
+	})
+}
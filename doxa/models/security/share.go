@@ -0,0 +1,97 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Access levels that can be granted by a share token
+const (
+	// ShareRead grants read access to the shared record
+	ShareRead = "read"
+	// ShareComment grants read access to the shared
+	// record and the right to comment it.
+	ShareComment = "comment"
+)
+
+// ShareClaims describe the access granted by a share token
+type ShareClaims struct {
+	Model     string   `json:"m"`
+	RecordID  int64    `json:"id"`
+	Access    string   `json:"a"`
+	Fields    []string `json:"f"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// Allows returns true if these claims grant the given access level.
+// ShareComment implies ShareRead.
+func (sc ShareClaims) Allows(access string) bool {
+	switch access {
+	case ShareRead:
+		return sc.Access == ShareRead || sc.Access == ShareComment
+	default:
+		return sc.Access == access
+	}
+}
+
+// AllowsField returns true if the field with the given name
+// or JSON name is one of the fields shared by these claims.
+func (sc ShareClaims) AllowsField(name, json string) bool {
+	for _, f := range sc.Fields {
+		if f == name || f == json {
+			return true
+		}
+	}
+	return false
+}
+
+// NewShareToken returns a token signed with key granting the given access
+// to the given fields of the record with the given id of the given model
+// during validity.
+func NewShareToken(key []byte, model string, id int64, access string, fields []string, validity time.Duration) string {
+	claims := ShareClaims{
+		Model:     model,
+		RecordID:  id,
+		Access:    access,
+		Fields:    fields,
+		ExpiresAt: time.Now().Add(validity).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		log.Panic("Unable to marshal share claims", "error", err)
+	}
+	encoded := jwtEncode(payload)
+	return encoded + "." + jwtEncode(jwtSign(key, encoded))
+}
+
+// ParseShareToken verifies the given share token with key and returns its claims.
+// It returns an InvalidTokenError if the token is malformed, has a wrong
+// signature or has expired.
+func ParseShareToken(key []byte, token string) (ShareClaims, error) {
+	var claims ShareClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, InvalidTokenError("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, jwtSign(key, parts[0])) {
+		return claims, InvalidTokenError("wrong signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, InvalidTokenError("malformed payload")
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, InvalidTokenError("malformed payload")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, InvalidTokenError("token expired")
+	}
+	return claims, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPDigits is the number of digits of the TOTP codes
+	TOTPDigits = 6
+	// TOTPPeriod is the validity period of a TOTP code
+	TOTPPeriod = 30 * time.Second
+	// TOTPSkew is the number of periods before and after the current
+	// one for which codes are still accepted, to allow for clock drift.
+	TOTPSkew = 1
+	// totpSecretBytes is the number of random bytes of a TOTP secret
+	totpSecretBytes = 20
+	// recoveryCodeBytes is the number of random bytes of a recovery code
+	recoveryCodeBytes = 5
+)
+
+// GenerateTOTPSecret returns a new random base32 encoded TOTP secret.
+func GenerateTOTPSecret() string {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		log.Panic("Unable to generate random TOTP secret", "error", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI to give to authenticator
+// applications (usually as a QR code) to enroll the given secret.
+//
+// issuer is the name of the application and account the login of the user.
+func TOTPProvisioningURI(secret, issuer, account string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// TOTPCode returns the TOTP code of the given secret at the given time.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(TOTPStep(t))), nil
+}
+
+// TOTPStep returns the time step of the TOTP codes at time t.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// MatchTOTP checks that code is a valid TOTP code for the given secret at
+// time t, within TOTPSkew periods. It returns the time step of the code,
+// so that callers can reject codes that have already been used.
+func MatchTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	for i := -TOTPSkew; i <= TOTPSkew; i++ {
+		stepTime := t.Add(time.Duration(i) * TOTPPeriod)
+		expected, err := TOTPCode(secret, stepTime)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return TOTPStep(stepTime), true
+		}
+	}
+	return 0, false
+}
+
+// VerifyTOTP returns true if code is a valid TOTP code for the given
+// secret at time t, within TOTPSkew periods.
+func VerifyTOTP(secret, code string, t time.Time) bool {
+	_, ok := MatchTOTP(secret, code, t)
+	return ok
+}
+
+// hotp returns the HOTP code (RFC 4226) of the given key and counter
+func hotp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// GenerateRecoveryCodes returns n new random recovery codes.
+//
+// Recovery codes allow users to log in once when they have lost access to
+// their authenticator. Only their hash, as given by HashRecoveryCode,
+// should be stored.
+func GenerateRecoveryCodes(n int) []string {
+	res := make([]string, n)
+	for i := range res {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			log.Panic("Unable to generate random recovery code", "error", err)
+		}
+		code := hex.EncodeToString(buf)
+		res[i] = code[:5] + "-" + code[5:]
+	}
+	return res
+}
+
+// HashRecoveryCode returns the hash of the given recovery code
+// to be stored in the database.
+func HashRecoveryCode(code string) string {
+	return HashAPIKey(strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// signaturePrefix is the prefix of payload signatures,
+// which gives the hash function of the HMAC.
+const signaturePrefix = "sha256="
+
+// SignPayload returns the signature of the given payload with the given
+// secret. The signature is the hex encoded HMAC-SHA256 of the payload,
+// prefixed with "sha256=".
+func SignPayload(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckPayloadSignature returns true if signature is the signature of the
+// given payload with the given secret, as computed by SignPayload. The
+// "sha256=" prefix of signature is optional. The comparison is done in
+// constant time.
+func CheckPayloadSignature(secret, payload []byte, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	expected := SignPayload(secret, payload)
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		signature = signaturePrefix + signature
+	}
+	return hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected))
+}
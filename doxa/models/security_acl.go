@@ -45,6 +45,12 @@ func checkFieldPermission(f *Field, uid int64, perm security.Permission) bool {
 	return false
 }
 
+// FieldReadable returns true if the user with the given uid
+// can read the given field of this model.
+func (m *Model) FieldReadable(uid int64, field FieldNamer) bool {
+	return checkFieldPermission(m.fields.MustGet(field.String()), uid, security.Read)
+}
+
 // filterOnAuthorizedFields returns the fields slice with only the fields on
 // which the current user has the given permission.
 func filterOnAuthorizedFields(m *Model, uid int64, fields []string, perm security.Permission) []string {
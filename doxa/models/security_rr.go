@@ -50,7 +50,7 @@ func (rrr *recordRuleRegistry) addRule(rule *RecordRule) {
 	if rule.Global {
 		rrr.globalRules[rule.Name] = rule
 	} else {
-		rrr.rulesByGroup[rule.Group.Name] = append(rrr.rulesByGroup[rule.Group.Name], rule)
+		rrr.rulesByGroup[rule.Group.ID] = append(rrr.rulesByGroup[rule.Group.ID], rule)
 	}
 }
 
@@ -68,16 +68,16 @@ func (rrr *recordRuleRegistry) removeRule(name string) {
 	if rule.Global {
 		delete(rrr.globalRules, name)
 	} else {
-		newRuleSlice := make([]*RecordRule, len(rrr.rulesByGroup[rule.Group.Name])-1)
+		newRuleSlice := make([]*RecordRule, len(rrr.rulesByGroup[rule.Group.ID])-1)
 		i := 0
-		for _, r := range rrr.rulesByGroup[rule.Group.Name] {
+		for _, r := range rrr.rulesByGroup[rule.Group.ID] {
 			if r.Name == rule.Name {
 				continue
 			}
 			newRuleSlice[i] = r
 			i++
 		}
-		rrr.rulesByGroup[rule.Group.Name] = newRuleSlice
+		rrr.rulesByGroup[rule.Group.ID] = newRuleSlice
 	}
 }
 
@@ -101,3 +101,59 @@ func (m *Model) AddRecordRule(rule *RecordRule) {
 func (m *Model) RemoveRecordRule(name string) {
 	m.rulesRegistry.removeRule(name)
 }
+
+// currentUID returns the uid of the user of the given RecordSet. It is meant
+// to be used as argument of record rule conditions that depend on the user.
+func currentUID(rs RecordSet) int64 {
+	return rs.Env().Uid()
+}
+
+// adminRecordRule returns a RecordRule granting administrators
+// all permissions on all the records of the given model.
+func adminRecordRule(m *Model) *RecordRule {
+	return &RecordRule{
+		Name:      m.name + "AdminAll",
+		Group:     security.GroupAdmin,
+		Condition: m.Field("ID").IsNotNull(),
+		Perms:     security.All,
+	}
+}
+
+// RestrictToOwner adds record rules to this model so that ordinary users
+// can only access the records whose ownerField is their uid, while
+// administrators keep access to all records.
+func (m *Model) RestrictToOwner(ownerField string) {
+	m.AddRecordRule(&RecordRule{
+		Name:      m.name + "OwnRecords",
+		Group:     security.GroupEveryone,
+		Condition: m.Field(ownerField).Equals(currentUID),
+		Perms:     security.All,
+	})
+	m.AddRecordRule(adminRecordRule(m))
+}
+
+// RestrictToAdmins adds record rules to this model so that only
+// administrators can access its records and revokes the execution of its
+// methods from other groups at bootstrap. Framework code reaches these
+// records with Sudo.
+func (m *Model) RestrictToAdmins() {
+	m.AddRecordRule(&RecordRule{
+		Name:      m.name + "NoRecords",
+		Group:     security.GroupEveryone,
+		Condition: m.Field("ID").IsNull(),
+		Perms:     security.All,
+	})
+	m.AddRecordRule(adminRecordRule(m))
+	m.methods.adminOnly = true
+}
+
+// RestrictFieldsToAdmins revokes the read and write permissions of
+// security.GroupEveryone on the given fields of this model, so that only
+// administrators can read or write them.
+func (m *Model) RestrictFieldsToAdmins(fieldNames ...string) {
+	for _, fName := range fieldNames {
+		m.fields.MustGet(fName).
+			RevokeAccess(security.GroupEveryone, security.Read|security.Write).
+			GrantAccess(security.GroupAdmin, security.Read|security.Write)
+	}
+}
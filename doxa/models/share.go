@@ -0,0 +1,72 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// ErrShareNotAllowed is returned when the share token does not
+// grant the requested access or when the shared record does not exist.
+var ErrShareNotAllowed = errors.New("access not granted by this share token")
+
+// sharedRecord returns the record designated by the given
+// share claims if they grant the given access.
+func sharedRecord(env Environment, claims security.ShareClaims, access string) (*RecordCollection, error) {
+	if !claims.Allows(access) {
+		return nil, ErrShareNotAllowed
+	}
+	if _, ok := Registry.Get(claims.Model); !ok {
+		return nil, ErrShareNotAllowed
+	}
+	rc := env.Pool(claims.Model).Sudo()
+	rec := rc.Search(rc.Model().Field("ID").Equals(claims.RecordID))
+	if rec.IsEmpty() {
+		return nil, ErrShareNotAllowed
+	}
+	return rec, nil
+}
+
+// ReadShared returns the values of the given fields of the record shared
+// with the given claims. The fields shared by the claims are returned if
+// fields is empty. It returns ErrShareNotAllowed if one of the given fields
+// is not shared by the claims.
+func ReadShared(env Environment, claims security.ShareClaims, fields []string) (FieldMap, error) {
+	rec, err := sharedRecord(env, claims, security.ShareRead)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		fields = claims.Fields
+	}
+	allowed := make([]string, len(fields))
+	for i, field := range fields {
+		fi, ok := rec.model.fields.Get(field)
+		if !ok || !claims.AllowsField(fi.name, fi.json) {
+			return nil, ErrShareNotAllowed
+		}
+		allowed[i] = fi.name
+	}
+	return rec.Call("Read", allowed).([]FieldMap)[0], nil
+}
+
+// CommentShared posts the given comment on the record shared with the given
+// claims by calling its ShareComment method, which must be defined by the
+// modules that allow comments on shared records.
+//
+// It returns ErrShareNotAllowed if the claims do not grant the right to
+// comment or if the model does not define a ShareComment method.
+func CommentShared(env Environment, claims security.ShareClaims, author, comment string) error {
+	rec, err := sharedRecord(env, claims, security.ShareComment)
+	if err != nil {
+		return err
+	}
+	if _, ok := rec.model.methods.get("ShareComment"); !ok {
+		return ErrShareNotAllowed
+	}
+	rec.Call("ShareComment", author, comment)
+	return nil
+}
@@ -271,6 +271,7 @@ func TestModelDeclaration(t *testing.T) {
 			"BestPostProfile": Rev2OneField{RelationModel: Registry.MustGet("Profile"), ReverseFK: "BestPost"},
 			"Abstract":        TextField{},
 			"Attachment":      BinaryField{},
+			"Cover":           BinaryField{Image: &ImageParams{Formats: []string{"png", "jpeg"}, MaxWidth: 100, MaxHeight: 100, ConvertTo: "jpeg"}},
 			"Read":            BooleanField{Compute: Registry.MustGet("Post").Methods().MustGet("ComputeRead")},
 			"LastRead":        DateField{},
 			"Visibility": SelectionField{Selection: types.Selection{
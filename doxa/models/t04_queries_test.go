@@ -288,5 +288,22 @@ func TestConditionSerialization(t *testing.T) {
 			dom := cond.Serialize()
 			So(fmt.Sprint(dom), ShouldEqual, "[& | [C = C Value] | [B = B Value] [A = A Value] [D = D Value]]")
 		})
+		Convey("Testing domain parsing", func() {
+			aOrB := newCondition().And().Field("A").Equals("A Value").Or().Field("B").Equals("B Value")
+			cond := newCondition().AndCond(aOrB).And().Field("C").In([]interface{}{1.0, 2.0})
+			parsed, err := ParseDomain(cond.Serialize())
+			So(err, ShouldBeNil)
+			So(fmt.Sprint(parsed.Serialize()), ShouldEqual, fmt.Sprint(cond.Serialize()))
+			parsed, err = ParseDomain([]interface{}{[]interface{}{"Name", "ilike", "John"}, []interface{}{"Age", ">", 18.0}})
+			So(err, ShouldBeNil)
+			So(fmt.Sprint(parsed.Serialize()), ShouldEqual, "[& [Name ilike John] [Age > 18]]")
+			parsed, err = ParseDomain(nil)
+			So(err, ShouldBeNil)
+			So(parsed.IsEmpty(), ShouldBeTrue)
+			_, err = ParseDomain([]interface{}{"|", []interface{}{"Name", "=", "John"}})
+			So(err, ShouldNotBeNil)
+			_, err = ParseDomain([]interface{}{[]interface{}{"Name", "unknown", "John"}})
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -16,6 +16,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/labneco/doxa/doxa/models/security"
 	. "github.com/smartystreets/goconvey/convey"
@@ -516,6 +517,27 @@ func TestGroupedQueries(t *testing.T) {
 				So(groupedUsers[1].Values["nums"], ShouldEqual, 4)
 				So(groupedUsers[1].Count, ShouldEqual, 2)
 			})
+			Convey("Grouped query by date interval", func() {
+				users := env.Pool("User")
+				groupedUsers := users.GroupBy(FieldName("CreateDate:year")).Aggregates(FieldName("CreateDate"), FieldName("Nums"))
+				So(len(groupedUsers), ShouldEqual, 1)
+				So(groupedUsers[0].Count, ShouldEqual, 3)
+				So(groupedUsers[0].Values, ShouldContainKey, "create_date")
+				So(groupedUsers[0].Values["create_date"].(time.Time).YearDay(), ShouldEqual, 1)
+				So(users.Search(groupedUsers[0].Condition).Len(), ShouldEqual, 3)
+			})
+			Convey("Grouping by interval non date fields or unknown intervals should panic", func() {
+				users := env.Pool("User")
+				So(func() { users.GroupBy(FieldName("Name:month")) }, ShouldPanic)
+				So(func() { users.GroupBy(FieldName("CreateDate:decade")) }, ShouldPanic)
+			})
+			Convey("Ordering by unknown intervals or directions should panic", func() {
+				users := env.Pool("User")
+				So(users.OrderBy("CreateDate:month desc").SearchAll().Len(), ShouldEqual, 3)
+				So(func() { users.OrderBy("CreateDate:day', id) --") }, ShouldPanic)
+				So(func() { users.OrderBy("Name desc; DROP TABLE user") }, ShouldPanic)
+				So(func() { truncateToInterval("create_date", "decade") }, ShouldPanic)
+			})
 		}), ShouldBeNil)
 	})
 }
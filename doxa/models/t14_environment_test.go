@@ -61,6 +61,22 @@ func TestEnvironment(t *testing.T) {
 				So(userJane.Env().Context().Get("key"), ShouldEqual, "context value")
 				So(userJane.Env().Uid(), ShouldEqual, security.SuperUserID)
 			})
+			Convey("Checking WithLang", func() {
+				So(userJane.Env().Lang(), ShouldBeEmpty)
+				env2 := env.WithLang("fr_FR")
+				So(env2.Lang(), ShouldEqual, "fr_FR")
+				So(env2.Context().Get("key"), ShouldEqual, "context value")
+				So(env.Lang(), ShouldBeEmpty)
+				userJane1 := userJane.WithLang("de_DE")
+				So(userJane1.Env().Lang(), ShouldEqual, "de_DE")
+				So(userJane1.Env().Context().GetString(LangKey), ShouldEqual, "de_DE")
+				So(userJane.Env().Lang(), ShouldBeEmpty)
+				WithRequestLang("es_ES", func() {
+					env3 := newEnvironment(2)
+					So(env3.Lang(), ShouldEqual, "es_ES")
+					env3.rollback()
+				})
+			})
 			Convey("Checking Sudo", func() {
 				userJane1 := userJane.Sudo(2)
 				userJane2 := userJane1.Call("Sudo").(RecordSet).Collection()
@@ -228,3 +244,31 @@ func TestEnvironment(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestTestTransaction(t *testing.T) {
+	Convey("Testing test transactions", t, func() {
+		tagCount := func(env Environment, name string) int {
+			tags := env.Pool("Tag")
+			return tags.Search(tags.Model().Field("Name").Equals(name)).SearchCount()
+		}
+		So(SimulateInTestTransaction(security.SuperUserID, func(env Environment) {
+			// Committed nested environments are visible in the test transaction
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env2 Environment) {
+				So(env2.Cr().tx, ShouldEqual, env.Cr().tx)
+				env2.Pool("Tag").Call("Create", FieldMap{"Name": "Nested Tag", "Description": "Nested"})
+			}), ShouldBeNil)
+			So(tagCount(env, "Nested Tag"), ShouldEqual, 1)
+			// Failed nested environments are rolled back to their savepoint
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env2 Environment) {
+				env2.Pool("Tag").Call("Create", FieldMap{"Name": "Failed Tag", "Description": "Failed"})
+				panic("Nested environment failure")
+			}), ShouldNotBeNil)
+			So(tagCount(env, "Failed Tag"), ShouldEqual, 0)
+			So(tagCount(env, "Nested Tag"), ShouldEqual, 1)
+		}), ShouldBeNil)
+		// The test transaction is rolled back at the end
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			So(tagCount(env, "Nested Tag"), ShouldEqual, 0)
+		}), ShouldBeNil)
+	})
+}
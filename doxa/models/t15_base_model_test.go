@@ -8,8 +8,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/labneco/doxa/doxa/i18n"
 	"github.com/labneco/doxa/doxa/models/fieldtype"
 	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types"
 	"github.com/labneco/doxa/doxa/models/types/dates"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -73,6 +75,23 @@ func TestBaseModelMethods(t *testing.T) {
 				fInfos := userJane.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
 				So(fInfos, ShouldHaveLength, 30)
 			})
+			Convey("Selection translations", func() {
+				i18n.LoadPOFile("testdata/fr.po")
+				profile := userJane.Get("Profile").(RecordSet).Collection()
+				fInfo := profile.Call("FieldGet", FieldName("Gender")).(*FieldInfo)
+				So(fInfo.String, ShouldEqual, "Gender")
+				So(fInfo.Selection, ShouldResemble, types.Selection{"m": "Male", "f": "Female"})
+				profile = profile.WithContext("lang", "fr")
+				fInfo = profile.Call("FieldGet", FieldName("Gender")).(*FieldInfo)
+				So(fInfo.String, ShouldEqual, "Genre")
+				So(fInfo.Selection, ShouldResemble, types.Selection{"m": "Homme", "f": "Femme"})
+				fInfos := profile.Call("FieldsGet", FieldsGetArgs{}).(map[string]*FieldInfo)
+				So(fInfos["gender"].Selection, ShouldResemble, types.Selection{"m": "Homme", "f": "Femme"})
+				profile.Call("Write", FieldMap{"Gender": "f"})
+				So(profile.SelectionLabel(FieldName("Gender")), ShouldEqual, "Femme")
+				So(profile.WithContext("lang", "").SelectionLabel(FieldName("Gender")), ShouldEqual, "Female")
+				So(func() { profile.SelectionLabel(FieldName("Age")) }, ShouldPanic)
+			})
 			Convey("NameGet", func() {
 				So(userJane.Get("DisplayName"), ShouldEqual, "Jane A. Smith")
 				profile := userJane.Get("Profile").(RecordSet).Collection()
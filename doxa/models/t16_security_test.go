@@ -0,0 +1,406 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldAccess(t *testing.T) {
+	Convey("Testing runtime field access management", t, func() {
+		group1 := security.Registry.NewGroup("field_access_group1", "Field Access Group 1")
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			email := userModel.fields.MustGet("Email")
+			Convey("Setting access on unknown models, fields or groups should fail", func() {
+				So(SetFieldAccess(env, "Unknown", "Email", group1.ID, security.Read), ShouldHaveSameTypeAs, UnknownFieldAccessError(""))
+				So(SetFieldAccess(env, "User", "Unknown", group1.ID, security.Read), ShouldHaveSameTypeAs, UnknownFieldAccessError(""))
+				So(SetFieldAccess(env, "User", "Email", "unknown_group", security.Read), ShouldHaveSameTypeAs, UnknownFieldAccessError(""))
+			})
+			Convey("Only administrators should be allowed to change field access", func() {
+				userEnv := env
+				userEnv.uid = 2
+				So(GrantFieldAccess(userEnv, "User", "Email", group1.ID, security.Read), ShouldEqual, ErrFieldAccessNotAllowed)
+				So(SetFieldAccess(userEnv, "User", "Email", security.GroupEveryoneID, 0), ShouldEqual, ErrFieldAccessNotAllowed)
+				So(email.acl.Permissions()[group1], ShouldEqual, security.Permission(0))
+			})
+			Convey("Granting and revoking access should be persisted and broadcast", func() {
+				lastID := LastBusMessageID(env)
+				So(GrantFieldAccess(env, "User", "Email", group1.ID, security.Read), ShouldBeNil)
+				So(fieldAccess(env, email, group1), ShouldEqual, security.Read)
+				So(GrantFieldAccess(env, "User", "email", group1.ID, security.Write|security.Unlink), ShouldBeNil)
+				So(fieldAccess(env, email, group1), ShouldEqual, security.Read|security.Write)
+				So(RevokeFieldAccess(env, "User", "Email", group1.ID, security.Read), ShouldBeNil)
+				So(fieldAccess(env, email, group1), ShouldEqual, security.Write)
+				So(email.acl.Permissions()[group1], ShouldEqual, security.Permission(0))
+				So(BusMessages(env, []string{fieldAccessChannel}, lastID), ShouldHaveLength, 3)
+				rc := env.Pool("FieldAccess")
+				accesses := rc.Search(rc.Model().Field("GroupID").Equals(group1.ID))
+				So(accesses.Len(), ShouldEqual, 1)
+				So(accesses.Get("Permission"), ShouldEqual, int64(security.Write))
+				So(SetFieldAccess(env, "User", "Email", group1.ID, 0), ShouldBeNil)
+			})
+			Convey("Revoked access should stay revoked when the server restarts", func() {
+				codePerm := email.acl.Permissions()[security.GroupEveryone]
+				So(RevokeFieldAccess(env, "User", "Email", security.GroupEveryoneID, security.Write), ShouldBeNil)
+				So(email.acl.Permissions()[security.GroupEveryone], ShouldEqual, codePerm)
+				// Restarting the server applies the stored permissions to the permissions set in code
+				loadFieldAccess(env)
+				So(email.acl.Permissions()[security.GroupEveryone], ShouldEqual, codePerm&^security.Write)
+				So(checkFieldPermission(email, 2, security.Write), ShouldBeFalse)
+				email.acl.ReplacePermission(security.GroupEveryone, codePerm)
+			})
+			Convey("Permission matrix should reflect the user's groups", func() {
+				security.Registry.AddMembership(2, group1)
+				email.acl.RemovePermission(security.GroupEveryone, security.Write)
+				So(FieldPermissions(2, "User")["Email"], ShouldEqual, security.Read)
+				email.acl.AddPermission(group1, security.Write)
+				So(FieldPermissions(2, "User")["Email"], ShouldEqual, security.Read|security.Write)
+				So(PermissionMatrix(2)["User"]["Email"], ShouldEqual, security.Read|security.Write)
+				So(PermissionMatrix(2), ShouldNotContainKey, "BaseMixin")
+				email.acl.RemovePermission(group1, security.Write)
+				email.acl.AddPermission(security.GroupEveryone, security.Write)
+				security.Registry.RemoveMembership(2, group1)
+			})
+		}), ShouldBeNil)
+		security.Registry.UnregisterGroup(group1)
+	})
+}
+
+func TestFieldAccessTransactions(t *testing.T) {
+	Convey("Testing field access changes in transactions", t, func() {
+		group1 := security.Registry.NewGroup("field_access_tx_group1", "Field Access Transaction Group 1")
+		email := Registry.MustGet("User").fields.MustGet("Email")
+		sub := bus.Subscribe(security.SuperUserID, fieldAccessChannel)
+		go watchFieldAccess(sub)
+		Convey("Changes should not be applied if the transaction is rolled back", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(GrantFieldAccess(env, "User", "Email", group1.ID, security.Read), ShouldBeNil)
+			}), ShouldBeNil)
+			So(email.acl.Permissions()[group1], ShouldEqual, security.Permission(0))
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(storedFieldAccess(env, email, group1).IsEmpty(), ShouldBeTrue)
+				loadFieldAccess(env)
+			}), ShouldBeNil)
+			So(email.acl.Permissions()[group1], ShouldEqual, security.Permission(0))
+		})
+		Convey("Changes should be applied by the relayed bus message once committed", func() {
+			var lastID int64
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				lastID = LastBusMessageID(env)
+				So(GrantFieldAccess(env, "User", "Email", group1.ID, security.Read), ShouldBeNil)
+			}), ShouldBeNil)
+			relayBusMessages(NewBusCursor(lastID))
+			deadline := time.Now().Add(5 * time.Second)
+			for email.acl.Permissions()[group1] != security.Read && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(email.acl.Permissions()[group1], ShouldEqual, security.Read)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				storedFieldAccess(env, email, group1).Call("Unlink")
+			}), ShouldBeNil)
+			email.acl.ReplacePermission(group1, 0)
+		})
+		sub.Close()
+		security.Registry.UnregisterGroup(group1)
+	})
+}
+
+func TestCheckAccess(t *testing.T) {
+	Convey("Testing access check diagnostic", t, func() {
+		group1 := security.Registry.NewGroup("access_check_group1", "Access Check Group 1")
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			userJane := env.Pool("User").Search(userModel.Field("Email").Equals("jane.smith@example.com"))
+			security.Registry.AddMembership(2, group1)
+			Convey("Unknown methods should be reported", func() {
+				report := CheckAccess(env, 2, "User", "NoSuchMethod", 0)
+				So(report.Allowed, ShouldBeFalse)
+				So(report.DeniedBy, ShouldContainSubstring, "NoSuchMethod")
+			})
+			Convey("Method execution permission should be reported", func() {
+				report := CheckAccess(env, 2, "User", "unlink", 0)
+				So(report.Method, ShouldEqual, "Unlink")
+				So(report.Groups, ShouldContain, group1.ID)
+				So(report.MethodAllowed, ShouldBeFalse)
+				So(report.Allowed, ShouldBeFalse)
+				userModel.methods.MustGet("Unlink").AllowGroup(group1)
+				report = CheckAccess(env, 2, "User", "unlink", 0)
+				So(report.MethodAllowed, ShouldBeTrue)
+				So(report.Allowed, ShouldBeTrue)
+				So(report.String(), ShouldContainSubstring, "ACCESS GRANTED")
+			})
+			Convey("Record rules should be evaluated on the given record", func() {
+				userModel.methods.MustGet("Unlink").AllowGroup(group1)
+				rule := RecordRule{
+					Name:      "nobodyOnly",
+					Group:     group1,
+					Condition: userModel.Field("Name").Equals("Nobody"),
+					Perms:     security.Unlink,
+				}
+				userModel.AddRecordRule(&rule)
+				report := CheckAccess(env, 2, "User", "unlink", userJane.Ids()[0])
+				So(report.RecordExists, ShouldBeTrue)
+				So(report.Rules, ShouldHaveLength, 1)
+				So(report.Rules[0].Matches, ShouldBeFalse)
+				So(report.Allowed, ShouldBeFalse)
+				So(report.String(), ShouldContainSubstring, "ACCESS DENIED")
+				userModel.RemoveRecordRule("nobodyOnly")
+			})
+			Convey("Record rules should be evaluated for the checked user", func() {
+				janeID := userJane.Ids()[0]
+				So(env.Uid(), ShouldNotEqual, janeID)
+				security.Registry.AddMembership(janeID, group1)
+				userModel.methods.MustGet("Unlink").AllowGroup(group1)
+				rule := RecordRule{
+					Name:      "ownUserOnly",
+					Group:     group1,
+					Condition: userModel.Field("ID").Equals(currentUID),
+					Perms:     security.Unlink,
+				}
+				userModel.AddRecordRule(&rule)
+				report := CheckAccess(env, janeID, "User", "unlink", janeID)
+				So(report.Rules, ShouldHaveLength, 1)
+				So(report.Rules[0].Matches, ShouldBeTrue)
+				So(report.Allowed, ShouldBeTrue)
+				userModel.RemoveRecordRule("ownUserOnly")
+				security.Registry.RemoveMembership(janeID, group1)
+			})
+			userModel.methods.MustGet("Unlink").RevokeGroup(group1)
+			security.Registry.RemoveMembership(2, group1)
+		}), ShouldBeNil)
+		security.Registry.UnregisterGroup(group1)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	Convey("Testing login lockout after failed attempts", t, func() {
+		oldPolicy := security.DefaultLockoutPolicy
+		security.DefaultLockoutPolicy = security.LockoutPolicy{MaxFailures: 2, Cooldown: time.Hour}
+		Convey("Login should be locked after too many failures", func() {
+			_, err := Authenticate("lockout_user", "wrong", "10.0.0.1", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			_, err = Authenticate("lockout_user", "wrong", "10.0.0.2", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+			_, err = Authenticate("lockout_user", "wrong", "10.0.0.3", nil)
+			So(err, ShouldEqual, security.AccountLockedError("lockout_user"))
+			lockedFor, err := LoginLockedFor("lockout_user")
+			So(err, ShouldBeNil)
+			So(lockedFor, ShouldBeBetween, 59*time.Minute, time.Hour)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				rc := env.Pool("LoginAttempt")
+				attempts := rc.Search(rc.Model().Field("Login").Equals("lockout_user"))
+				So(attempts.SearchCount(), ShouldEqual, 3)
+				So(CheckAccess(env, 2, "LoginAttempt", "read", attempts.Ids()[0]).Allowed, ShouldBeFalse)
+				UnlockLogin(env, "lockout_user", "")
+			}), ShouldBeNil)
+			lockedFor, err = LoginLockedFor("lockout_user")
+			So(err, ShouldBeNil)
+			So(lockedFor, ShouldEqual, 0)
+			_, err = Authenticate("lockout_user", "wrong", "10.0.0.4", nil)
+			So(err, ShouldHaveSameTypeAs, security.UserNotFoundError(""))
+		})
+		Convey("Second factor should be locked after too many failures", func() {
+			var secret string
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				secret, _, _ = EnrollTOTP(env, 3, "Doxa", "will", "")
+				code, _ := security.TOTPCode(secret, time.Now().Add(-security.TOTPPeriod))
+				So(ConfirmTOTP(env, 3, code), ShouldHaveLength, 10)
+			}), ShouldBeNil)
+			So(AuthenticateTOTP(3, "000000", "10.0.1.1"), ShouldEqual, ErrInvalidTOTPCode)
+			So(AuthenticateTOTP(3, "000000", "10.0.1.2"), ShouldEqual, ErrInvalidTOTPCode)
+			code, _ := security.TOTPCode(secret, time.Now())
+			So(AuthenticateTOTP(3, code, "10.0.1.3"), ShouldEqual, security.AccountLockedError(TOTPLogin(3)))
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				rc := env.Pool("LoginAttempt")
+				attempts := rc.Search(rc.Model().Field("Login").Equals(TOTPLogin(3)))
+				So(attempts.SearchCount(), ShouldEqual, 3)
+				UnlockLogin(env, TOTPLogin(3), "")
+			}), ShouldBeNil)
+			So(AuthenticateTOTP(3, code, "10.0.1.4"), ShouldBeNil)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				DisableTOTP(env, 3)
+			}), ShouldBeNil)
+		})
+		security.DefaultLockoutPolicy = oldPolicy
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API key authentication", t, func() {
+		var (
+			userJane                     *RecordCollection
+			janeID                       int64
+			validKey, expiredKey, revKey string
+		)
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			userJane = env.Pool("User").Search(userModel.Field("Email").Equals("jane.smith@example.com"))
+			janeID = userJane.Ids()[0]
+			validKey = NewAPIKey(env, janeID, "Valid", security.APIScopes{"read"}, dates.DateTime{})
+			expiredKey = NewAPIKey(env, janeID, "Expired", nil, dates.Now().AddDate(0, 0, -1))
+			revKey = NewAPIKey(env, janeID, "Revoked", nil, dates.DateTime{})
+			rc := env.Pool("APIKey")
+			rc.Search(rc.Model().Field("KeyHash").Equals(security.HashAPIKey(revKey))).Call("Write", FieldMap{"Active": false})
+		}), ShouldBeNil)
+		Convey("Valid keys should authenticate their owner", func() {
+			uid, scopes, err := AuthenticateAPIKey(validKey)
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, janeID)
+			So(scopes, ShouldResemble, security.APIScopes{"read"})
+		})
+		Convey("Revoked, expired and unknown keys should be rejected", func() {
+			for _, key := range []string{revKey, expiredKey, security.GenerateAPIKey()} {
+				_, _, err := AuthenticateAPIKey(key)
+				So(err, ShouldEqual, security.InvalidAPIKeyError(security.APIKeyPrefix(key)))
+			}
+		})
+		Convey("Keys of archived users should be rejected", func() {
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				userJane.WithEnv(env).Call("Write", FieldMap{"Active": false})
+			}), ShouldBeNil)
+			_, _, err := AuthenticateAPIKey(validKey)
+			So(err, ShouldEqual, security.InvalidAPIKeyError(security.APIKeyPrefix(validKey)))
+			So(UserActive(janeID), ShouldBeFalse)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				userJane.WithEnv(env).Call("Write", FieldMap{"Active": true})
+			}), ShouldBeNil)
+			So(UserActive(janeID), ShouldBeTrue)
+			So(UserActive(0), ShouldBeFalse)
+		})
+		Convey("Users should only see their own keys without their hash", func() {
+			group1 := security.Registry.NewGroup("apikey_group1", "API Key Group 1")
+			security.Registry.AddMembership(janeID, group1)
+			security.Registry.AddMembership(3, group1)
+			loadMethod := Registry.MustGet("APIKey").methods.MustGet("Load")
+			loadMethod.AllowGroup(group1)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				NewAPIKey(env, 3, "Will's key", nil, dates.DateTime{})
+				So(env.Pool("APIKey").Sudo(3).SearchAll().Len(), ShouldEqual, 1)
+				keys := env.Pool("APIKey").Sudo(janeID).SearchAll()
+				So(keys.Len(), ShouldEqual, 3)
+				for _, key := range keys.Records() {
+					So(key.Get("UserID"), ShouldEqual, janeID)
+					So(key.Get("KeyHash"), ShouldBeEmpty)
+				}
+			}), ShouldBeNil)
+			loadMethod.RevokeGroup(group1)
+			security.Registry.RemoveMembership(janeID, group1)
+			security.Registry.RemoveMembership(3, group1)
+			security.Registry.UnregisterGroup(group1)
+		})
+		So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+			RevokeAPIKeys(env, janeID)
+		}), ShouldBeNil)
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	Convey("Testing password reset", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			userJane := env.Pool("User").Search(userModel.Field("Email").Equals("jane.smith@example.com"))
+			Convey("Password should be hashed and stored", func() {
+				So(ResetPassword(env, userJane.Ids()[0], "Recovered password"), ShouldBeNil)
+				hash := userJane.Get("Password").(string)
+				So(hash, ShouldStartWith, "$argon2id$")
+				So(security.CheckPassword("Recovered password", hash), ShouldBeTrue)
+			})
+			Convey("Passwords not complying with the policy should be rejected", func() {
+				So(ResetPassword(env, userJane.Ids()[0], "short"), ShouldHaveSameTypeAs, security.PasswordPolicyError(""))
+			})
+			Convey("Resetting the password of an unknown user should fail", func() {
+				So(func() { ResetPassword(env, 123456, "Recovered password") }, ShouldPanic)
+			})
+			Convey("Password history should only be readable by administrators", func() {
+				janeID := userJane.Ids()[0]
+				So(ResetPassword(env, janeID, "Recovered password"), ShouldBeNil)
+				history := passwordHistory(env, janeID)
+				So(history.Len(), ShouldEqual, 1)
+				So(CheckAccess(env, janeID, "PasswordHistory", "read", history.Ids()[0]).Allowed, ShouldBeFalse)
+				So(CheckAccess(env, security.SuperUserID, "PasswordHistory", "read", history.Ids()[0]).Allowed, ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestTOTPSettings(t *testing.T) {
+	Convey("Testing two-factor authentication settings", t, func() {
+		group1 := security.Registry.NewGroup("totp_group1", "TOTP Group 1")
+		security.Registry.AddMembership(2, group1)
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			loadMethod := Registry.MustGet("UserTOTP").methods.MustGet("Load")
+			loadMethod.AllowGroup(group1)
+			secret, _, err := EnrollTOTP(env, 2, "Doxa", "jane", "")
+			So(err, ShouldBeNil)
+			Convey("Codes should be accepted only once", func() {
+				code, _ := security.TOTPCode(secret, time.Now())
+				So(ConfirmTOTP(env, 2, code), ShouldHaveLength, 10)
+				So(ConfirmTOTP(env, 2, code), ShouldBeNil)
+			})
+			Convey("Enrolling again should require a valid code once enabled", func() {
+				code, _ := security.TOTPCode(secret, time.Now())
+				ConfirmTOTP(env, 2, code)
+				_, _, err := EnrollTOTP(env, 2, "Doxa", "jane", "")
+				So(err, ShouldEqual, ErrInvalidTOTPCode)
+				_, _, err = EnrollTOTP(env, 2, "Doxa", "jane", code)
+				So(err, ShouldEqual, ErrInvalidTOTPCode)
+			})
+			Convey("Users should only see their own settings without the secret", func() {
+				_, _, err := EnrollTOTP(env, 3, "Doxa", "will", "")
+				So(err, ShouldBeNil)
+				settings := env.Pool("UserTOTP").Sudo(2).SearchAll()
+				So(settings.Len(), ShouldEqual, 1)
+				So(settings.Get("UserID"), ShouldEqual, 2)
+				So(settings.Get("Secret"), ShouldBeEmpty)
+			})
+			loadMethod.RevokeGroup(group1)
+		}), ShouldBeNil)
+		security.Registry.RemoveMembership(2, group1)
+		security.Registry.UnregisterGroup(group1)
+	})
+}
+
+func TestReadShared(t *testing.T) {
+	Convey("Testing shared record reading", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			userModel := Registry.MustGet("User")
+			userJane := env.Pool("User").Search(userModel.Field("Email").Equals("jane.smith@example.com"))
+			claims := security.ShareClaims{
+				Model:    "User",
+				RecordID: userJane.Ids()[0],
+				Access:   security.ShareRead,
+				Fields:   []string{"Name", "Email"},
+			}
+			Convey("Shared fields should be returned", func() {
+				res, err := ReadShared(env, claims, []string{"email"})
+				So(err, ShouldBeNil)
+				So(res["Email"], ShouldEqual, "jane.smith@example.com")
+				res, err = ReadShared(env, claims, nil)
+				So(err, ShouldBeNil)
+				So(res, ShouldContainKey, "Name")
+				So(res, ShouldContainKey, "Email")
+				So(res, ShouldNotContainKey, "Password")
+			})
+			Convey("Fields outside the shared fields should be refused", func() {
+				res, err := ReadShared(env, claims, []string{"Name", "Password"})
+				So(err, ShouldEqual, ErrShareNotAllowed)
+				So(res, ShouldBeNil)
+				_, err = ReadShared(env, claims, []string{"Unknown"})
+				So(err, ShouldEqual, ErrShareNotAllowed)
+			})
+			Convey("Other records should be refused", func() {
+				claims.RecordID = -1
+				_, err := ReadShared(env, claims, []string{"Name"})
+				So(err, ShouldEqual, ErrShareNotAllowed)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/labneco/doxa/doxa/bus"
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBus(t *testing.T) {
+	Convey("Testing the bus", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			lastID := LastBusMessageID(env)
+			Convey("Sent messages should be stored until they are relayed", func() {
+				SendBus(env, "public:news", map[string]string{"title": "Hello"})
+				SendBus(env, "public:other", "ignored")
+				messages := BusMessages(env, []string{"public:news"}, lastID)
+				So(messages, ShouldHaveLength, 1)
+				So(messages[0].Channel, ShouldEqual, "public:news")
+				So(string(messages[0].Message), ShouldEqual, `{"title":"Hello"}`)
+				So(BusMessages(env, nil, lastID), ShouldHaveLength, 2)
+				So(LastBusMessageID(env), ShouldBeGreaterThan, messages[0].ID)
+			})
+			Convey("Record changes should be sent on models with bus notifications", func() {
+				tagModel := Registry.MustGet("Tag")
+				tagModel.EnableBusNotifications()
+				defer func() { tagModel.busNotify = false }()
+				tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Live"}).(RecordSet).Collection()
+				tag.Call("Write", FieldMap{"Name": "Live Updated"})
+				tag.Call("Unlink")
+				messages := BusMessages(env, []string{ModelChannel("Tag")}, lastID)
+				So(messages, ShouldHaveLength, 3)
+				var change RecordChange
+				So(json.Unmarshal(messages[1].Message, &change), ShouldBeNil)
+				So(change.Model, ShouldEqual, "Tag")
+				So(change.Operation, ShouldEqual, "write")
+				So(string(messages[1].Message), ShouldEqual, `{"model":"Tag","operation":"write"}`)
+			})
+			Convey("Model channels should only be subscribed by users who can read the model", func() {
+				So(bus.CanSubscribe(security.SuperUserID, ModelChannel("Tag")), ShouldBeTrue)
+				So(bus.CanSubscribe(security.SuperUserID, ModelChannel("Unknown")), ShouldBeFalse)
+			})
+			Convey("Bus messages should only be readable by administrators", func() {
+				So(CheckAccess(env, 2, "BusMessage", "read", 0).Allowed, ShouldBeFalse)
+				So(CheckAccess(env, security.SuperUserID, "BusMessage", "read", 0).Allowed, ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+		Convey("The relay cursor should wait for messages committed out of order", func() {
+			now := time.Now()
+			cursor := NewBusCursor(10)
+			cursor.advance([]bus.Message{{ID: 11}, {ID: 14}}, now)
+			So(cursor.lastID, ShouldEqual, 14)
+			So(cursor.gapIDs(now), ShouldHaveLength, 2)
+			cursor.advance([]bus.Message{{ID: 12}, {ID: 15}}, now)
+			So(cursor.lastID, ShouldEqual, 15)
+			So(cursor.gapIDs(now), ShouldResemble, []int64{13})
+			So(cursor.gapIDs(now.Add(2*BusGapTimeout)), ShouldBeEmpty)
+		})
+		Convey("Clients should accept messages committed out of order once", func() {
+			cursor := NewBusCursor(10)
+			So(cursor.Accept(bus.Message{ID: 9}), ShouldBeFalse)
+			So(cursor.Accept(bus.Message{ID: 13}), ShouldBeTrue)
+			So(cursor.Accept(bus.Message{ID: 12}), ShouldBeTrue)
+			So(cursor.Accept(bus.Message{ID: 12}), ShouldBeFalse)
+			So(cursor.Accept(bus.Message{ID: 13}), ShouldBeFalse)
+			So(cursor.Accept(bus.Message{ID: 11}), ShouldBeTrue)
+			So(cursor.Accept(bus.Message{ID: 14}), ShouldBeTrue)
+		})
+		Convey("Presence should list recently connected users", func() {
+			UpdateBusPresence(2)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(BusPresence(env, []int64{2, 3}), ShouldResemble, []int64{2})
+			}), ShouldBeNil)
+		})
+		Convey("Presence should only be given for the users the caller can read", func() {
+			UpdateBusPresence(2)
+			UpdateBusPresence(999999)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(BusPresence(env, []int64{2, 999999}), ShouldResemble, []int64{2})
+				So(CheckAccess(env, 2, "BusPresence", "read", 0).Allowed, ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/b64image"
+	"github.com/labneco/doxa/doxa/tools/filestore"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAttachments(t *testing.T) {
+	Convey("Testing attachments", t, func() {
+		root, err := ioutil.TempDir("", "doxa-attachments")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		FileStore = filestore.New(root)
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			post := env.Pool("Post").Call("Create", FieldMap{"Title": "With file", "Content": "<p>File</p>"}).(RecordSet).Collection()
+			postID := post.Ids()[0]
+			Convey("Attachments should store their content in the file store", func() {
+				attachment, err := CreateAttachment(env, "hello.txt", "text/plain", strings.NewReader("hello"), "Post", postID, "Attachment")
+				So(err, ShouldBeNil)
+				So(attachment.Get("FileSize"), ShouldEqual, 5)
+				So(attachment.Get("ResField"), ShouldEqual, "Attachment")
+				f, err := FileStore.Open(attachment.Get("Checksum").(string))
+				So(err, ShouldBeNil)
+				defer f.Close()
+				content, _ := ioutil.ReadAll(f)
+				So(string(content), ShouldEqual, "hello")
+				Convey("Attachment and field contents should be read from the file store", func() {
+					bc, err := AttachmentContent(env, attachment.Ids()[0])
+					So(err, ShouldBeNil)
+					So(bc.Name, ShouldEqual, "hello.txt")
+					So(bc.MimeType, ShouldEqual, "text/plain")
+					So(bc.Size, ShouldEqual, 5)
+					So(bc.Path, ShouldEndWith, bc.Checksum)
+					bc, err = FieldContent(env, "Post", postID, "Attachment")
+					So(err, ShouldBeNil)
+					So(bc.Checksum, ShouldEqual, attachment.Get("Checksum"))
+				})
+				Convey("A new attachment should replace the previous one of the field", func() {
+					_, err := CreateAttachment(env, "bye.txt", "text/plain", strings.NewReader("bye"), "Post", postID, "Attachment")
+					So(err, ShouldBeNil)
+					rc := env.Pool("Attachment")
+					So(rc.Search(rc.Model().Field("ResModel").Equals("Post").And().Field("ResID").Equals(postID)).Len(), ShouldEqual, 1)
+				})
+			})
+			Convey("Users should not read the attachments of other records", func() {
+				loadMethod := Registry.MustGet("Attachment").methods.MustGet("Load")
+				group1 := security.Registry.NewGroup("attachment_group1", "Attachment Group 1")
+				security.Registry.AddMembership(2, group1)
+				loadMethod.AllowGroup(group1)
+				checksum, size, err := FileStore.Write(strings.NewReader("secret"))
+				So(err, ShouldBeNil)
+				rc := env.Pool("Attachment").Sudo()
+				private := rc.Call("Create", FieldMap{"Name": "private.txt", "Checksum": checksum, "FileSize": size}).(RecordSet).Collection()
+				public := rc.Call("Create", FieldMap{"Name": "public.txt", "Checksum": checksum, "FileSize": size, "Public": true}).(RecordSet).Collection()
+				userEnv := rc.Sudo(2).Env()
+				visible := userEnv.Pool("Attachment").SearchAll()
+				So(visible.Ids(), ShouldContain, public.Ids()[0])
+				So(visible.Ids(), ShouldNotContain, private.Ids()[0])
+				_, err = AttachmentContent(userEnv, private.Ids()[0])
+				So(err, ShouldEqual, ErrAttachmentNotAllowed)
+				_, err = AttachmentContent(userEnv, public.Ids()[0])
+				So(err, ShouldBeNil)
+				_, err = AttachmentContent(env, private.Ids()[0])
+				So(err, ShouldBeNil)
+				loadMethod.RevokeGroup(group1)
+				security.Registry.RemoveMembership(2, group1)
+				security.Registry.UnregisterGroup(group1)
+			})
+			Convey("Binary fields without attachment should be read from the database", func() {
+				post.Set("Attachment", base64.StdEncoding.EncodeToString([]byte("GIF89a")))
+				bc, err := FieldContent(env, "Post", postID, "Attachment")
+				So(err, ShouldBeNil)
+				So(string(bc.Data), ShouldEqual, "GIF89a")
+				So(bc.MimeType, ShouldEqual, "image/gif")
+				So(bc.Checksum, ShouldHaveLength, 40)
+				_, err = FieldContent(env, "Post", postID, "Title")
+				So(err, ShouldEqual, ErrNoContent)
+				_, err = AttachmentContent(env, 0)
+				So(err, ShouldEqual, ErrNoContent)
+			})
+			Convey("Attachments cannot be linked to unknown records or fields", func() {
+				_, err := CreateAttachment(env, "a.txt", "text/plain", strings.NewReader("a"), "Unknown", postID, "")
+				So(err, ShouldEqual, ErrAttachmentNotAllowed)
+				_, err = CreateAttachment(env, "a.txt", "text/plain", strings.NewReader("a"), "Post", postID, "Unknown")
+				So(err, ShouldEqual, ErrAttachmentNotAllowed)
+				_, err = LinkAttachment(env, "a.txt", "text/plain", "../passwd", 1, "Post", postID, "")
+				So(err, ShouldEqual, filestore.ErrInvalidChecksum)
+			})
+			Convey("Images written in image fields should be checked and converted", func() {
+				pngImage := func(width, height int) string {
+					var buf bytes.Buffer
+					png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+					return base64.StdEncoding.EncodeToString(buf.Bytes())
+				}
+				post.Set("Cover", pngImage(50, 30))
+				format, err := b64image.Format(post.Get("Cover").(string))
+				So(err, ShouldBeNil)
+				So(format, ShouldEqual, "jpeg")
+				So(func() { post.Set("Cover", pngImage(200, 30)) }, ShouldPanic)
+				So(func() { post.Set("Cover", base64.StdEncoding.EncodeToString([]byte("GIF89a"))) }, ShouldPanic)
+				post.Set("Cover", "")
+				So(post.Get("Cover"), ShouldEqual, "")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhooks(t *testing.T) {
+	Convey("Testing webhooks", t, func() {
+		Convey("Record events should enqueue deliveries for matching webhooks", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Webhook").Call("Create", FieldMap{
+					"Name":     "Tags",
+					"ResModel": "Tag",
+					"OnCreate": true,
+					"Filter":   `[["Name", "ilike", "hook"]]`,
+					"URL":      "http://localhost/hook",
+				})
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Hook me"})
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Ignore me"})
+				jobs := env.Pool("Job")
+				jobs = jobs.Search(jobs.Model().Field("Name").Equals(webhookJob).And().Field("State").Equals(JobPending))
+				So(jobs.Len(), ShouldEqual, 1)
+				var delivery webhookDelivery
+				So(json.Unmarshal([]byte(jobs.Get("Payload").(string)), &delivery), ShouldBeNil)
+				So(delivery.Event, ShouldEqual, "Tag.create")
+				var payload WebhookPayload
+				So(json.Unmarshal(delivery.Body, &payload), ShouldBeNil)
+				So(payload.Model, ShouldEqual, "Tag")
+				So(payload.Records, ShouldHaveLength, 1)
+				So(payload.Records[0]["name"], ShouldEqual, "Hook me")
+			}), ShouldBeNil)
+		})
+		Convey("Passwords and restricted fields should not be posted", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				user := env.Pool("User").SearchAll().Limit(1)
+				user.Call("Write", FieldMap{"Password": "hooked"})
+				values := webhookRecordValues(user)
+				So(values, ShouldHaveLength, 1)
+				So(values[0]["name"], ShouldEqual, user.Get("Name"))
+				So(values[0], ShouldNotContainKey, "password")
+				So(webhookHiddenField(Registry.MustGet("UserTOTP").fields.MustGet("Secret")), ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+		Convey("Webhooks, deliveries and jobs should only be readable by administrators", func() {
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				for _, model := range []string{"Webhook", "WebhookDelivery", "Job"} {
+					So(CheckAccess(env, 2, model, "read", 0).Allowed, ShouldBeFalse)
+					So(CheckAccess(env, security.SuperUserID, model, "read", 0).Allowed, ShouldBeTrue)
+				}
+			}), ShouldBeNil)
+		})
+		Convey("Webhook URLs should be absolute http or https URLs", func() {
+			So(validWebhookURL("https://example.com/hook"), ShouldBeTrue)
+			So(validWebhookURL("file:///etc/passwd"), ShouldBeFalse)
+			So(validWebhookURL("gopher://example.com"), ShouldBeFalse)
+			So(validWebhookURL("/hook"), ShouldBeFalse)
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				So(func() {
+					env.Pool("Webhook").Call("Create", FieldMap{
+						"Name":     "Files",
+						"ResModel": "Tag",
+						"URL":      "file:///etc/passwd",
+					})
+				}, ShouldPanic)
+			}), ShouldBeNil)
+		})
+		Convey("Deliveries to private addresses should be refused", func() {
+			for _, address := range []string{"127.0.0.1:80", "10.1.2.3:443", "192.168.0.1:80", "169.254.169.254:80",
+				"100.100.100.200:80", "[::1]:80", "[fd00:ec2::254]:80", "[fe80::1]:80", "0.0.0.0:80"} {
+				So(checkWebhookAddress("tcp", address, nil), ShouldNotBeNil)
+			}
+			So(checkWebhookAddress("tcp", "93.184.216.34:443", nil), ShouldBeNil)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer ts.Close()
+			_, _, err := postWebhook(ts.URL, "", "Tag.create", 1, []byte("{}"))
+			So(err, ShouldNotBeNil)
+			WebhookAllowPrivateNetworks = true
+			defer func() { WebhookAllowPrivateNetworks = false }()
+			So(checkWebhookAddress("tcp", "169.254.169.254:80", nil), ShouldBeNil)
+			status, _, err := postWebhook(ts.URL, "", "Tag.create", 1, []byte("{}"))
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+		})
+		Convey("Deliveries should be posted signed and logged", func() {
+			WebhookAllowPrivateNetworks = true
+			defer func() { WebhookAllowPrivateNetworks = false }()
+			var (
+				signatureOK bool
+				status      = http.StatusOK
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				signatureOK = security.CheckPayloadSignature([]byte("s3cr3t"), body, r.Header.Get(WebhookSignatureHeader))
+				w.WriteHeader(status)
+			}))
+			defer ts.Close()
+			var webhookID int64
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				webhookID = env.Pool("Webhook").Call("Create", FieldMap{
+					"Name":     "Tags",
+					"ResModel": "Tag",
+					"OnCreate": true,
+					"OnUnlink": true,
+					"URL":      ts.URL,
+					"Secret":   "s3cr3t",
+				}).(RecordSet).Ids()[0]
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Delivered"})
+			}), ShouldBeNil)
+			So(RunPendingJobs(nil), ShouldEqual, 1)
+			So(signatureOK, ShouldBeTrue)
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				deliveries := WebhookDeliveries(env, webhookID, 10)
+				So(deliveries.Len(), ShouldEqual, 1)
+				So(deliveries.Get("StatusCode"), ShouldEqual, http.StatusOK)
+				So(deliveries.Get("Event"), ShouldEqual, "Tag.create")
+			}), ShouldBeNil)
+			Convey("Failed deliveries should be retried later", func() {
+				status = http.StatusInternalServerError
+				So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+					tags := env.Pool("Tag")
+					tags.Search(tags.Model().Field("Name").Equals("Delivered")).Call("Unlink")
+				}), ShouldBeNil)
+				So(RunPendingJobs(nil), ShouldEqual, 1)
+				So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+					jobs := env.Pool("Job")
+					job := jobs.Search(jobs.Model().Field("Name").Equals(webhookJob)).OrderBy("ID DESC").Limit(1)
+					So(job.Get("State"), ShouldEqual, JobPending)
+					So(job.Get("Attempts"), ShouldEqual, 1)
+					So(job.Get("LastError"), ShouldContainSubstring, "500")
+					So(WebhookDeliveries(env, webhookID, 1).Get("Error"), ShouldContainSubstring, "500")
+				}), ShouldBeNil)
+			})
+			So(ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+				env.Pool("Webhook").Search(env.Pool("Webhook").Model().Field("ID").Equals(webhookID)).Call("Unlink")
+				env.Pool("WebhookDelivery").SearchAll().Call("Unlink")
+				env.Pool("Job").SearchAll().Call("Unlink")
+			}), ShouldBeNil)
+		})
+	})
+}
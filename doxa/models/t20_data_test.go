@@ -4,6 +4,12 @@
 package models
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/labneco/doxa/doxa/models/security"
@@ -40,7 +46,22 @@ func TestDataLoading(t *testing.T) {
 				userPeter.Load()
 				So(userPeter.Get("Name"), ShouldEqual, "Peter Modified")
 			})
+			Convey("CSV data files should be read by batches", func() {
+				data := openCSVDataFile("testdata/User_2.csv")
+				defer data.close()
+				So(data.modelName, ShouldEqual, "User")
+				So(data.version, ShouldEqual, 2)
+				So(data.headers[0], ShouldEqual, "id")
+				So(data.nextRecords(2), ShouldHaveLength, 2)
+				records := data.nextRecords(2)
+				So(records, ShouldHaveLength, 1)
+				So(data.externalID(records[0]), ShouldEqual, "external_id_5")
+				So(data.read, ShouldEqual, 3)
+				So(data.nextRecords(2), ShouldBeEmpty)
+			})
 			Convey("Check that import with update updates even existing", func() {
+				DataBatchSize = 2
+				defer func() { DataBatchSize = 1000 }()
 				LoadCSVDataFile("testdata/200User_update.csv")
 				users := userObj.SearchAll()
 				So(users.Len(), ShouldEqual, 6)
@@ -102,6 +123,39 @@ func TestDataLoading(t *testing.T) {
 				So(userKen.Get("IsStaff").(bool), ShouldEqual, false)
 				So(userKen.Get("Size").(float64), ShouldEqual, 1.76)
 			})
+			Convey("Checking that noupdate records are never updated", func() {
+				LoadCSVDataFile("testdata/User_noupdate.csv")
+				users := userObj.SearchAll()
+				So(users.Len(), ShouldEqual, 9)
+				userMary := userObj.Search(userObj.Model().Field("DoxaExternalID").Equals("external_id_2"))
+				So(userMary.Get("Name"), ShouldEqual, "Mary modified")
+				So(userMary.Get("DoxaNoUpdate").(bool), ShouldBeFalse)
+				userZoe := userObj.Search(userObj.Model().Field("DoxaExternalID").Equals("external_id_6"))
+				So(userZoe.Get("Name"), ShouldEqual, "Zoe")
+				So(userZoe.Get("DoxaNoUpdate").(bool), ShouldBeTrue)
+				report := ImportCSVDataFile("testdata/300User_update.csv", 10, true)
+				So(report.Updated, ShouldEqual, 0)
+				So(report.Skipped, ShouldEqual, 1)
+				userZoe.Load()
+				So(userZoe.Get("Name"), ShouldEqual, "Zoe")
+				So(userZoe.Get("Nums").(int), ShouldEqual, 3)
+			})
+			Convey("Checking deletion of records", func() {
+				So(func() { ImportCSVDataFile("testdata/User_delete.csv", 10, false) }, ShouldPanic)
+				LoadCSVDataFile("testdata/User_delete.csv")
+				So(userObj.SearchAll().Len(), ShouldEqual, 8)
+				So(userObj.Search(userObj.Model().Field("DoxaExternalID").Equals("external_id_6")).IsEmpty(), ShouldBeTrue)
+				So(func() { LoadCSVDataFile("testdata/User_delete.csv") }, ShouldNotPanic)
+				num := DeleteDataRecords(env, DataDeletion{
+					Model:       "User",
+					ExternalIDs: []string{"external_id_3", "external_id_5"},
+					Condition:   userObj.Model().Field("Name").Equals("Ken"),
+				})
+				So(num, ShouldEqual, 1)
+				So(userObj.Search(userObj.Model().Field("Name").Equals("Ken")).IsEmpty(), ShouldBeTrue)
+				So(userObj.Search(userObj.Model().Field("Name").Equals("Nick")).IsEmpty(), ShouldBeFalse)
+				So(func() { DeleteDataRecords(env, DataDeletion{Model: "User"}) }, ShouldPanic)
+			})
 			Convey("Checking imports with foreign keys", func() {
 				LoadCSVDataFile("testdata/010-Tag.csv")
 				LoadCSVDataFile("testdata/Post.csv")
@@ -121,6 +175,84 @@ func TestDataLoading(t *testing.T) {
 				So(func() { LoadCSVDataFile("testdata/001Post.csv") }, ShouldPanic)
 				So(func() { LoadCSVDataFile("testdata/002Post.csv") }, ShouldPanic)
 			})
+			Convey("Checking import by batches with error report", func() {
+				report := ImportCSVDataFile("testdata/020-Tag.csv", 2, false)
+				So(report.ModelName, ShouldEqual, "Tag")
+				So(report.Created, ShouldEqual, 2)
+				So(report.Updated, ShouldEqual, 0)
+				So(report.Skipped, ShouldEqual, 0)
+				So(report.Errors, ShouldHaveLength, 2)
+				So(report.Errors[0].Line, ShouldEqual, 3)
+				So(report.Errors[0].ExternalID, ShouldEqual, "tag_import_2")
+				So(report.Errors[1].Line, ShouldEqual, 4)
+				So(report.Errors[1].ExternalID, ShouldEqual, "tag_import_3")
+				tagObj := env.Pool("Tag")
+				tag4 := tagObj.Search(tagObj.Model().Field("DoxaExternalID").Equals("tag_import_4"))
+				So(tag4.Len(), ShouldEqual, 1)
+				So(tag4.Get("Parent").(RecordSet).Collection().Get("Name"), ShouldEqual, "Import 1")
+
+				report = ImportCSVDataFile("testdata/020-Tag.csv", 10, false)
+				So(report.Created, ShouldEqual, 0)
+				So(report.Skipped, ShouldEqual, 2)
+				So(report.Errors, ShouldHaveLength, 2)
+				report = ImportCSVDataFile("testdata/020-Tag.csv", 10, true)
+				So(report.Updated, ShouldEqual, 2)
+				So(report.Failed, ShouldEqual, 2)
+				So(report.Errors, ShouldHaveLength, 2)
+			})
+			Convey("Checking that conversion errors are collected per line", func() {
+				So(func() { LoadCSVDataFile("testdata/030-Tag.csv") }, ShouldPanic)
+				report := ImportCSVDataFile("testdata/030-Tag.csv", 10, false)
+				So(report.Created, ShouldEqual, 1)
+				So(report.Failed, ShouldEqual, 1)
+				So(report.Skipped, ShouldEqual, 0)
+				So(report.Errors, ShouldHaveLength, 2)
+				So(report.Errors[0].Line, ShouldEqual, 2)
+				So(report.Errors[0].ExternalID, ShouldEqual, "tag_import_5")
+				So(report.Errors[0].Field, ShouldEqual, "rate")
+				So(report.Errors[0].Value, ShouldEqual, "abc")
+				So(report.Errors[1].Field, ShouldEqual, "parent_id")
+				So(report.Errors[1].Value, ShouldEqual, "tag_unknown")
+				So(report.Errors[1].Message, ShouldEqual, "Unable to find related record from external ID")
+				tagObj := env.Pool("Tag")
+				So(tagObj.Search(tagObj.Model().Field("DoxaExternalID").Equals("tag_import_6")).Len(), ShouldEqual, 1)
+			})
+			Convey("Checking CSV export", func() {
+				tagObj := env.Pool("Tag")
+				tags := tagObj.Search(tagObj.Model().Field("Name").In([]string{"Import 1", "Import 4"}))
+				var buf bytes.Buffer
+				ExportCSVData(&buf, tags, "", "Name", "Parent")
+				So(buf.String(), ShouldEqual, `ID,Name,Parent
+tag_import_1,Import 1,
+tag_import_4,Import 4,tag_import_1
+`)
+				buf.Reset()
+				ExportCSVData(&buf, tags, "")
+				So(strings.SplitN(buf.String(), "\n", 2)[0], ShouldEqual, "ID,Active,BestPost,Description,Name,Parent,Posts,Rate")
+				So(func() { ExportCSVData(&buf, tags, "", "Unknown") }, ShouldPanic)
+			})
+			Convey("Checking CSV export of generated external IDs", func() {
+				tagObj := env.Pool("Tag")
+				parent := tagObj.Call("Create", FieldMap{"Name": "Export Parent"}).(RecordSet).Collection()
+				child := tagObj.Call("Create", FieldMap{"Name": "Export Child", "Parent": parent}).(RecordSet).Collection()
+				var buf bytes.Buffer
+				ExportCSVData(&buf, child.Union(parent), "", "Name", "Parent")
+				parentID := fmt.Sprintf("__export__tag_%d", parent.Ids()[0])
+				So(buf.String(), ShouldEqual, fmt.Sprintf(`ID,Name,Parent
+%s,Export Parent,
+__export__tag_%d,Export Child,%s
+`, parentID, child.Ids()[0], parentID))
+			})
+			Convey("Checking CSV snapshot export", func() {
+				dir, err := ioutil.TempDir("", "doxa-snapshot")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(dir)
+				res := ExportCSVSnapshot(env, dir, "Post", "Tag")
+				So(res["Tag"], ShouldEqual, env.Pool("Tag").SearchAll().SearchCount())
+				So(res["Post"], ShouldEqual, env.Pool("Post").SearchAll().SearchCount())
+				files, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+				So(files, ShouldResemble, []string{filepath.Join(dir, "010-Tag.csv"), filepath.Join(dir, "020-Post.csv")})
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -0,0 +1,92 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSavedFilters(t *testing.T) {
+	Convey("Testing saved filters", t, func() {
+		So(SimulateInNewEnvironment(2, func(env Environment) {
+			Convey("Users should save, replace and list their filters", func() {
+				id, err := SaveFilter(env, SavedFilter{Name: "Staff", ResModel: "User", Domain: `[["IsStaff", "=", true]]`, IsDefault: true})
+				So(err, ShouldBeNil)
+				id2, err := SaveFilter(env, SavedFilter{Name: "Staff", ResModel: "User", Domain: `[["IsStaff", "=", false]]`})
+				So(err, ShouldBeNil)
+				So(id2, ShouldEqual, id)
+				_, err = SaveFilter(env, SavedFilter{Name: "Action only", ResModel: "User", ActionID: "users_action"})
+				So(err, ShouldBeNil)
+				filters := GetSavedFilters(env, "User", "")
+				So(filters, ShouldHaveLength, 1)
+				So(filters[0].Domain, ShouldEqual, `[["IsStaff", "=", false]]`)
+				So(filters[0].Context, ShouldEqual, "{}")
+				So(filters[0].IsDefault, ShouldBeFalse)
+				So(GetSavedFilters(env, "User", "users_action"), ShouldHaveLength, 2)
+				So(GetSavedFilters(env, "Post", ""), ShouldBeEmpty)
+			})
+			Convey("Saving a default filter should replace the previous default", func() {
+				first, _ := SaveFilter(env, SavedFilter{Name: "First", ResModel: "User", IsDefault: true})
+				SaveFilter(env, SavedFilter{Name: "Second", ResModel: "User", IsDefault: true})
+				for _, filter := range GetSavedFilters(env, "User", "") {
+					So(filter.IsDefault, ShouldEqual, filter.ID != first)
+				}
+			})
+			Convey("Invalid filters should not be saved", func() {
+				_, err := SaveFilter(env, SavedFilter{ResModel: "User"})
+				So(err, ShouldHaveSameTypeAs, InvalidSavedFilterError(""))
+				_, err = SaveFilter(env, SavedFilter{Name: "Unknown", ResModel: "Unknown"})
+				So(err, ShouldHaveSameTypeAs, InvalidSavedFilterError(""))
+				_, err = SaveFilter(env, SavedFilter{Name: "Bad domain", ResModel: "User", Domain: `[["Name", "like"]]`})
+				So(err, ShouldHaveSameTypeAs, InvalidSavedFilterError(""))
+				_, err = SaveFilter(env, SavedFilter{Name: "Bad context", ResModel: "User", Context: `["group_by"]`})
+				So(err, ShouldHaveSameTypeAs, InvalidSavedFilterError(""))
+			})
+			Convey("Only administrators should manage shared filters", func() {
+				_, err := SaveFilter(env, SavedFilter{Name: "Shared", ResModel: "User", Shared: true})
+				So(err, ShouldEqual, ErrSavedFilterNotAllowed)
+				var sharedID int64
+				adminEnv := env
+				adminEnv.uid = security.SuperUserID
+				sharedID, err = SaveFilter(adminEnv, SavedFilter{Name: "Shared", ResModel: "User", Shared: true})
+				So(err, ShouldBeNil)
+				filters := GetSavedFilters(env, "User", "")
+				So(filters, ShouldHaveLength, 1)
+				So(filters[0].Shared, ShouldBeTrue)
+				So(DeleteSavedFilter(env, sharedID), ShouldEqual, ErrSavedFilterNotAllowed)
+				So(DeleteSavedFilter(adminEnv, sharedID), ShouldBeNil)
+				So(GetSavedFilters(env, "User", ""), ShouldBeEmpty)
+			})
+			Convey("Users should only delete their own filters", func() {
+				id, _ := SaveFilter(env, SavedFilter{Name: "Mine", ResModel: "User"})
+				otherEnv := env
+				otherEnv.uid = 3
+				So(GetSavedFilters(otherEnv, "User", ""), ShouldBeEmpty)
+				So(DeleteSavedFilter(otherEnv, id), ShouldEqual, ErrSavedFilterNotFound)
+				So(DeleteSavedFilter(env, id), ShouldBeNil)
+				So(DeleteSavedFilter(env, id), ShouldEqual, ErrSavedFilterNotFound)
+			})
+			Convey("Record rules should give access to own and shared filters", func() {
+				mine, _ := SaveFilter(env, SavedFilter{Name: "Mine", ResModel: "User"})
+				adminEnv := env
+				adminEnv.uid = security.SuperUserID
+				shared, _ := SaveFilter(adminEnv, SavedFilter{Name: "Shared", ResModel: "User", Shared: true})
+				otherEnv := env
+				otherEnv.uid = 3
+				theirs, _ := SaveFilter(otherEnv, SavedFilter{Name: "Theirs", ResModel: "User"})
+				filters := env.Pool("SavedFilter")
+				all := filters.Model().Field("ID").In([]int64{mine, shared, theirs})
+				So(filters.Search(all).Ids(), ShouldHaveLength, 2)
+				So(adminEnv.Pool("SavedFilter").Search(all).Ids(), ShouldHaveLength, 3)
+				So(CheckAccess(env, env.uid, "SavedFilter", "write", shared).Allowed, ShouldBeFalse)
+				So(CheckAccess(env, env.uid, "SavedFilter", "read", shared).Allowed, ShouldBeTrue)
+				So(CheckAccess(env, env.uid, "SavedFilter", "write", mine).Allowed, ShouldBeTrue)
+				So(CheckAccess(env, env.uid, "SavedFilter", "read", theirs).Allowed, ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnonymize(t *testing.T) {
+	Convey("Testing data anonymization", t, func() {
+		userModel := Registry.MustGet("User")
+		Convey("Invalid anonymize strategies should be rejected", func() {
+			So(func() {
+				checkAnonymize(&Field{model: userModel, name: "Nums", fieldType: fieldtype.Integer, anonymize: AnonymizeName})
+			}, ShouldPanic)
+			So(func() {
+				checkAnonymize(&Field{model: userModel, name: "Email", fieldType: fieldtype.Char, anonymize: "scramble"})
+			}, ShouldPanic)
+			So(func() {
+				checkAnonymize(&Field{model: userModel, name: "Profile", fieldType: fieldtype.Many2One, required: true, anonymize: AnonymizeNull})
+			}, ShouldPanic)
+			So(func() {
+				checkAnonymize(&Field{model: userModel, name: "Email", fieldType: fieldtype.Char, anonymize: AnonymizeEmail})
+			}, ShouldNotPanic)
+		})
+		Convey("Anonymize strategies should be checked on all fields at bootstrap", func() {
+			So(checkAnonymizedFields, ShouldNotPanic)
+			numsField := userModel.fields.MustGet("Nums")
+			numsField.anonymize = AnonymizeEmail
+			defer func() {
+				numsField.anonymize = ""
+			}()
+			So(checkAnonymizedFields, ShouldPanic)
+		})
+		Convey("Annotated fields should be anonymized", func() {
+			nameField, emailField, sizeField := userModel.fields.MustGet("Name"), userModel.fields.MustGet("Email"), userModel.fields.MustGet("Size")
+			nameField.anonymize, emailField.anonymize, sizeField.anonymize = AnonymizeName, AnonymizeEmail, AnonymizeNull
+			defer func() {
+				nameField.anonymize, emailField.anonymize, sizeField.anonymize = "", "", ""
+			}()
+			So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+				users := env.Pool("User")
+				userJane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+				id := userJane.Ids()[0]
+				res := Anonymize(env)
+				So(res["User"], ShouldEqual, users.SearchAll().SearchCount())
+				var values struct {
+					Name  string
+					Email string
+					Size  *float64
+				}
+				env.cr.Get(&values, `SELECT name, email, size FROM "user" WHERE id = ?`, id)
+				So(values.Name, ShouldEqual, fmt.Sprintf("User %d", id))
+				So(values.Email, ShouldEqual, fmt.Sprintf("user.%d@example.com", id))
+				So(values.Size, ShouldBeNil)
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExternalIDs(t *testing.T) {
+	Convey("Testing external IDs", t, func() {
+		So(SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			userJane := users.Search(users.Model().Field("Email").Equals("jane.smith@example.com"))
+			Convey("External IDs can be set from code", func() {
+				userJane.SetExternalID("user_jane")
+				So(userJane.Get("DoxaExternalID"), ShouldEqual, "user_jane")
+				id, ok := env.cache.getExternalID(users.model, "user_jane")
+				So(ok, ShouldBeTrue)
+				So(id, ShouldEqual, userJane.Ids()[0])
+			})
+			Convey("Ref should return the record with the given external ID", func() {
+				userJane.SetExternalID("user_jane")
+				ref := env.Ref("User", "user_jane")
+				So(ref.Ids(), ShouldResemble, userJane.Ids())
+				So(ref.Get("Name"), ShouldEqual, userJane.Get("Name"))
+				So(func() { env.Ref("User", "user_unknown") }, ShouldPanic)
+			})
+			Convey("Cached external IDs should not be used once the record is modified", func() {
+				userJane.SetExternalID("user_jane")
+				userJane.SetExternalID("user_jane_2")
+				So(func() { env.Ref("User", "user_jane") }, ShouldPanic)
+				So(env.Ref("User", "user_jane_2").Ids(), ShouldResemble, userJane.Ids())
+				env.cache.invalidateRecord(users.model, userJane.Ids()[0])
+				So(env.Ref("User", "user_jane_2").Ids(), ShouldResemble, userJane.Ids())
+			})
+		}), ShouldBeNil)
+	})
+}
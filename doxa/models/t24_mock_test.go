@@ -0,0 +1,98 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMockEnvironment(t *testing.T) {
+	Convey("Testing mock environments", t, func() {
+		env := NewMockEnvironment(security.SuperUserID)
+		So(env.IsMock(), ShouldBeTrue)
+		So(env.Cr(), ShouldBeNil)
+		profile := env.Pool("Profile").Call("Create", FieldMap{
+			"Age":   23,
+			"Money": 12.5,
+			"City":  "Paris",
+		}).(RecordSet).Collection()
+		user := env.Pool("User").Call("Create", FieldMap{
+			"Name":    "Mock User",
+			"Email":   "mock@example.com",
+			"Profile": profile,
+		}).(RecordSet).Collection()
+		Convey("Created records should be read back", func() {
+			So(user.Get("Name"), ShouldEqual, "Mock User")
+			So(user.Get("Profile").(RecordSet).Collection().Get("City"), ShouldEqual, "Paris")
+			So(user.Get("PMoney"), ShouldEqual, 12.5)
+			So(env.Pool("User").Search(env.Pool("User").Model().Field("Name").Equals("Mock User")).Len(), ShouldEqual, 1)
+		})
+		Convey("Computed fields should be computed", func() {
+			So(user.Get("Age"), ShouldEqual, 23)
+			So(user.Get("DecoratedName"), ShouldEqual, "User: Mock User [<mock@example.com>]")
+			profile.Set("Age", int16(31))
+			So(user.Get("Age"), ShouldEqual, 31)
+			So(env.Pool("User").Search(env.Pool("User").Model().Field("Age").Greater(30)).Len(), ShouldEqual, 1)
+		})
+		Convey("Onchange methods should be called without changing records", func() {
+			res := user.Call("Onchange", OnchangeParams{
+				Fields:   []string{"Name"},
+				Onchange: map[string]string{"Name": "1"},
+				Values:   FieldMap{"Name": "William", "Email": "will@example.com"},
+			}).(OnchangeResult)
+			So(res.Value.FieldMap()["decorated_name"], ShouldEqual, "User: William [<will@example.com>]")
+			So(user.Get("Name"), ShouldEqual, "Mock User")
+		})
+		Convey("Constraints should be checked", func() {
+			So(func() {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Tag", "Description": "Tag"})
+			}, ShouldPanic)
+			So(func() {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": "Tag", "Rate": 12})
+			}, ShouldPanic)
+		})
+		Convey("Searches should follow conditions and orders", func() {
+			for _, name := range []string{"Go", "Python", "Rust", "Gopher"} {
+				env.Pool("Tag").Call("Create", FieldMap{"Name": name, "Description": name + " language", "Rate": 5})
+			}
+			tags := env.Pool("Tag")
+			goTags := tags.Search(tags.Model().Field("Name").ILike("go%"))
+			So(goTags.Len(), ShouldEqual, 2)
+			So(goTags.Records()[0].Get("Name"), ShouldEqual, "Gopher")
+			So(goTags.Records()[1].Get("Name"), ShouldEqual, "Go")
+			cond := tags.Model().Field("Name").Equals("Rust").Or().Field("Name").Equals("Python").And().Field("Rate").Greater(6)
+			So(tags.Search(cond).Len(), ShouldEqual, 1)
+			So(tags.SearchAll().OrderBy("Name").Limit(2).Offset(1).Records()[0].Get("Name"), ShouldEqual, "Gopher")
+			So(tags.SearchAll().SearchCount(), ShouldEqual, 4)
+		})
+		Convey("Relations should be stored and searched", func() {
+			tag := env.Pool("Tag").Call("Create", FieldMap{"Name": "Mock"}).(RecordSet).Collection()
+			post := env.Pool("Post").Call("Create", FieldMap{
+				"User":    user,
+				"Title":   "Mock Post",
+				"Content": "Content",
+				"Tags":    tag,
+			}).(RecordSet).Collection()
+			So(post.Get("Tags").(RecordSet).Collection().Ids(), ShouldResemble, tag.Ids())
+			So(user.Get("Posts").(RecordSet).Collection().Ids(), ShouldResemble, post.Ids())
+			posts := env.Pool("Post")
+			So(posts.Search(posts.Model().Field("Tags.Name").Equals("Mock")).Len(), ShouldEqual, 1)
+			So(posts.Search(posts.Model().Field("User.Profile.City").Equals("Paris")).Len(), ShouldEqual, 1)
+			users := env.Pool("User")
+			So(users.Search(users.Model().Field("Posts.Title").Equals("Mock Post")).Len(), ShouldEqual, 1)
+			So(users.Search(users.Model().Field("LastPost").IsNull()).Len(), ShouldEqual, 1)
+			So(post.Call("Unlink"), ShouldEqual, 1)
+			So(posts.SearchAll().SearchCount(), ShouldEqual, 0)
+			So(users.Search(users.Model().Field("Posts.Title").Equals("Mock Post")).Len(), ShouldEqual, 0)
+		})
+		Convey("Aggregates should not be supported", func() {
+			So(func() {
+				env.Pool("Tag").SearchAll().GroupBy(FieldName("Name")).Aggregates(FieldName("Rate"))
+			}, ShouldPanic)
+		})
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tests/golden"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGoldenSQL(t *testing.T) {
+	Convey("Testing generated SQL against golden files", t, func() {
+		SimulateInNewEnvironment(security.SuperUserID, func(env Environment) {
+			users := env.Pool("User")
+			Convey("Select queries should follow conditions, orders and related fields", func() {
+				cond := users.Model().Field("Name").Equals("John").And().Field("Profile.Age").Greater(20)
+				rs := users.Search(cond).OrderBy("Name").Limit(5)
+				So(rs.SelectSQL("Name", "Email", "PMoney"), golden.ShouldMatch, "sql/user_select")
+				So(rs.SelectSQL("Name", "Email", "PMoney").String(), ShouldEqual, rs.SelectSQL("Name", "Email", "PMoney").String())
+				So(rs.Condition().String(), ShouldEqual, users.Search(cond).Condition().String())
+			})
+			Convey("Select queries should use default orders", func() {
+				tags := env.Pool("Tag")
+				rs := tags.Search(tags.Model().Field("Name").ILike("go").Or().Field("Rate").LowerOrEqual(2.5))
+				So(rs.SelectSQL("Name", "Rate"), golden.ShouldMatch, "sql/tag_select")
+			})
+			Convey("Select queries should not be generated for empty RecordSets", func() {
+				So(users.SelectSQL("Name").SQL, ShouldBeEmpty)
+			})
+			Convey("Count queries should join relations of conditions", func() {
+				posts := env.Pool("Post")
+				rs := posts.Search(posts.Model().Field("Tags.Name").Equals("Go"))
+				So(rs.CountSQL(), golden.ShouldMatch, "sql/post_count")
+			})
+			Convey("Aggregates queries should group fields", func() {
+				rs := users.Search(users.Model().Field("IsStaff").Equals(true)).GroupBy(FieldName("IsActive"))
+				So(rs.AggregatesSQL(FieldName("Nums"), FieldName("Size")), golden.ShouldMatch, "sql/user_aggregates")
+			})
+			Convey("Delete queries should follow conditions", func() {
+				rs := users.Search(users.Model().Field("Email").IContains("example.com"))
+				So(rs.DeleteSQL(), golden.ShouldMatch, "sql/user_delete")
+			})
+		})
+	})
+}
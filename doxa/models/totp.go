@@ -0,0 +1,203 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// totpRecoveryCodesCount is the number of recovery codes generated
+// when a user enables two-factor authentication.
+const totpRecoveryCodesCount = 10
+
+// ErrInvalidTOTPCode is returned when a valid two-factor authentication
+// code is required but the given code is invalid or has already been used.
+var ErrInvalidTOTPCode = errors.New("invalid two-factor authentication code")
+
+// declareTOTPModel declares the UserTOTP model which stores the
+// two-factor authentication settings of the users.
+//
+// Users can only see their own settings and the secret and recovery
+// codes can only be read by administrators.
+func declareTOTPModel() {
+	userTOTP := NewModel("UserTOTP")
+	userTOTP.AddFields(map[string]FieldDefinition{
+		"UserID":        IntegerField{Required: true, Unique: true, Index: true},
+		"Secret":        CharField{Required: true, NoCopy: true},
+		"Enabled":       BooleanField{Help: "Set once the user has confirmed enrollment with a valid code"},
+		"RecoveryCodes": TextField{NoCopy: true, Help: "Comma separated list of the hashes of unused recovery codes"},
+		"LastStep":      IntegerField{NoCopy: true, Help: "Time step of the last accepted code, to reject replayed codes"},
+	})
+	userTOTP.RestrictToOwner("UserID")
+	userTOTP.RestrictFieldsToAdmins("Secret", "RecoveryCodes", "LastStep")
+}
+
+// userTOTP returns the UserTOTP record of the given user (possibly empty)
+func userTOTP(env Environment, uid int64) *RecordCollection {
+	rc := env.Pool("UserTOTP").Sudo()
+	return rc.Search(rc.Model().Field("UserID").Equals(uid)).Limit(1)
+}
+
+// EnrollTOTP starts two-factor authentication enrollment for the user with
+// the given uid and returns the new secret together with the provisioning
+// URI to show to the user (usually as a QR code).
+//
+// Two-factor authentication is not enforced until the enrollment has been
+// confirmed with ConfirmTOTP. Enrolling again resets any previous settings,
+// which requires a valid current code if two-factor authentication is
+// already enabled. ErrInvalidTOTPCode is returned otherwise.
+func EnrollTOTP(env Environment, uid int64, issuer, account, code string) (string, string, error) {
+	rec := userTOTP(env, uid)
+	if !rec.IsEmpty() && rec.Get("Enabled").(bool) && !checkTOTPCode(rec, code) {
+		return "", "", ErrInvalidTOTPCode
+	}
+	secret := security.GenerateTOTPSecret()
+	values := FieldMap{
+		"UserID":        uid,
+		"Secret":        secret,
+		"Enabled":       false,
+		"RecoveryCodes": "",
+		"LastStep":      int64(0),
+	}
+	if !rec.IsEmpty() {
+		rec.Call("Write", values)
+	} else {
+		env.Pool("UserTOTP").Sudo().Call("Create", values)
+	}
+	return secret, security.TOTPProvisioningURI(secret, issuer, account), nil
+}
+
+// checkTOTPCode returns true if code is a valid TOTP code for the given
+// UserTOTP record which has not been used yet. The time step of the
+// code is stored so that it cannot be replayed.
+func checkTOTPCode(rec *RecordCollection, code string) bool {
+	step, ok := security.MatchTOTP(rec.Get("Secret").(string), code, time.Now())
+	if !ok || step <= rec.Get("LastStep").(int64) {
+		return false
+	}
+	rec.Call("Write", FieldMap{"LastStep": step})
+	return true
+}
+
+// ConfirmTOTP enables two-factor authentication for the user with the given
+// uid if code is valid for the secret given by EnrollTOTP.
+//
+// It returns the recovery codes of the user in clear, or nil if the code
+// is not valid. Recovery codes must be communicated to the user at once
+// since they cannot be retrieved afterwards.
+func ConfirmTOTP(env Environment, uid int64, code string) []string {
+	rec := userTOTP(env, uid)
+	if rec.IsEmpty() {
+		return nil
+	}
+	if !checkTOTPCode(rec, code) {
+		return nil
+	}
+	codes := security.GenerateRecoveryCodes(totpRecoveryCodesCount)
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = security.HashRecoveryCode(c)
+	}
+	rec.Call("Write", FieldMap{
+		"Enabled":       true,
+		"RecoveryCodes": strings.Join(hashes, ","),
+	})
+	return codes
+}
+
+// DisableTOTP disables two-factor authentication for the user with the given uid.
+func DisableTOTP(env Environment, uid int64) {
+	rec := userTOTP(env, uid)
+	if rec.IsEmpty() {
+		return
+	}
+	rec.Call("Unlink")
+}
+
+// TOTPEnabled returns true if the user with the given uid
+// has enabled two-factor authentication.
+//
+// It also returns true if the settings of the user cannot be read,
+// so that a database failure never bypasses two-factor authentication.
+func TOTPEnabled(uid int64) bool {
+	var enabled bool
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rec := userTOTP(env, uid)
+		enabled = !rec.IsEmpty() && rec.Get("Enabled").(bool)
+	})
+	if err != nil {
+		log.Warn("Unable to read two-factor authentication settings", "uid", uid, "error", err)
+		return true
+	}
+	return enabled
+}
+
+// CheckTOTP returns true if code is either a valid TOTP code or an unused
+// recovery code of the user with the given uid. TOTP codes are rejected if
+// a code of the same or a later time step has already been accepted and
+// recovery codes can be used only once.
+//
+// It always returns false if the user has not enabled two-factor
+// authentication.
+func CheckTOTP(uid int64, code string) bool {
+	var valid bool
+	err := ExecuteInNewEnvironment(security.SuperUserID, func(env Environment) {
+		rec := userTOTP(env, uid)
+		if rec.IsEmpty() || !rec.Get("Enabled").(bool) {
+			return
+		}
+		if checkTOTPCode(rec, code) {
+			valid = true
+			return
+		}
+		hash := security.HashRecoveryCode(code)
+		var remaining []string
+		for _, h := range strings.Split(rec.Get("RecoveryCodes").(string), ",") {
+			if h == "" {
+				continue
+			}
+			if h == hash && !valid {
+				valid = true
+				continue
+			}
+			remaining = append(remaining, h)
+		}
+		if valid {
+			rec.Call("Write", FieldMap{"RecoveryCodes": strings.Join(remaining, ",")})
+		}
+	})
+	if err != nil {
+		log.Warn("Unable to check two-factor authentication code", "uid", uid, "error", err)
+		return false
+	}
+	return valid
+}
+
+// TOTPLogin returns the login under which the second factor
+// attempts of the user with the given uid are recorded.
+func TOTPLogin(uid int64) string {
+	return fmt.Sprintf("totp:%d", uid)
+}
+
+// AuthenticateTOTP checks code with CheckTOTP for the user with the given uid,
+// enforcing security.DefaultLockoutPolicy like Authenticate.
+//
+// Each attempt is recorded in the LoginAttempt model with the TOTPLogin of
+// the user, so that failures are counted per user whatever the IP address and
+// are not reset by successful password checks. It returns ErrInvalidTOTPCode
+// if the code is invalid or a security.AccountLockedError if the user or the
+// IP address is locked.
+func AuthenticateTOTP(uid int64, code, ip string) error {
+	return withLockout(TOTPLogin(uid), ip, func() error {
+		if !CheckTOTP(uid, code) {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	})
+}
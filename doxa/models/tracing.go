@@ -0,0 +1,73 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jtolds/gls"
+	"github.com/labneco/doxa/doxa/tools/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceContextKey is the gls key of the current tracing context
+const traceContextKey = "trace_context"
+
+var tracer = tracing.Tracer("github.com/labneco/doxa/doxa/models")
+
+// WithTraceContext executes fnct with the given tracing context attached to
+// the current goroutine, so that the spans of the model methods called and
+// SQL queries executed during fnct are children of the span of ctx.
+func WithTraceContext(ctx context.Context, fnct func()) {
+	if !tracing.Enabled() {
+		fnct()
+		return
+	}
+	ctxManager.SetValues(gls.Values{traceContextKey: ctx}, fnct)
+}
+
+// TraceContext returns the tracing context attached to the current goroutine
+func TraceContext() context.Context {
+	ctx, ok := ctxManager.GetValue(traceContextKey)
+	if !ok {
+		return context.Background()
+	}
+	return ctx.(context.Context)
+}
+
+// startMethodSpan starts a span for the call of the given method on rc and
+// adds its context to values. It returns nil if tracing is disabled.
+func (rc *RecordCollection) startMethodSpan(methName string, values gls.Values) trace.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	ctx, span := tracer.Start(TraceContext(), rc.model.name+"."+methName, trace.WithAttributes(
+		attribute.String("doxa.model", rc.model.name),
+		attribute.String("doxa.method", methName),
+		attribute.Int64("doxa.uid", rc.env.uid),
+	))
+	values[traceContextKey] = ctx
+	return span
+}
+
+// traceSQLQuery records a span for the given SQL query that
+// started at start and ended now with the given error.
+func traceSQLQuery(err error, start time.Time, query string) {
+	if !tracing.Enabled() {
+		return
+	}
+	_, span := tracer.Start(TraceContext(), "SQL", trace.WithTimestamp(start), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", db.DriverName()),
+			attribute.String("db.statement", query),
+		))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
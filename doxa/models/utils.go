@@ -18,6 +18,7 @@ import (
 	"errors"
 	"reflect"
 	"strings"
+	"time"
 )
 
 var (
@@ -291,11 +292,22 @@ func ConvertToFieldNameSlice(fields []string) []FieldNamer {
 }
 
 // getGroupCondition returns the condition to retrieve the individual aggregated rows in vals
-// knowing that they were grouped by groups and that we had the given initial condition
-func getGroupCondition(groups []string, vals map[string]interface{}, initialCondition *Condition) *Condition {
+// knowing that they were grouped by groups and that we had the given initial condition.
+//
+// Fields grouped by interval are conditioned on the whole interval starting at their value.
+func getGroupCondition(mi *Model, groups []string, vals map[string]interface{}, initialCondition *Condition) *Condition {
 	res := initialCondition
 	for _, group := range groups {
-		res = res.And().Field(group).Equals(vals[group])
+		field, interval := splitGroupInterval(group)
+		val := vals[strings.Join(jsonizeExpr(mi, strings.Split(field, ExprSep)), sqlSep)]
+		start, isTime := val.(time.Time)
+		if interval == "" || !isTime {
+			res = res.And().Field(field).Equals(val)
+			continue
+		}
+		span := groupIntervals[interval]
+		end := start.AddDate(span[0], span[1], span[2])
+		res = res.And().Field(field).GreaterOrEqual(start).And().Field(field).Lower(end)
 	}
 	return res
 }
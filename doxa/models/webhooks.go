@@ -0,0 +1,385 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/strutils"
+)
+
+// webhookJob is the name of the job that delivers webhook payloads
+const webhookJob = "webhook"
+
+// Headers of webhook requests
+const (
+	// WebhookEventHeader gives the event of the payload (e.g. "Post.create")
+	WebhookEventHeader = "X-Doxa-Event"
+	// WebhookSignatureHeader gives the signature of the payload with
+	// the secret of the webhook, as computed by security.SignPayload.
+	WebhookSignatureHeader = "X-Doxa-Signature"
+	// WebhookDeliveryHeader gives the id of the job delivering the payload,
+	// which is the same for all the attempts of a delivery.
+	WebhookDeliveryHeader = "X-Doxa-Delivery"
+)
+
+var (
+	// WebhookClient is the HTTP client used to deliver webhook payloads.
+	// Its dialer refuses the addresses refused by checkWebhookAddress.
+	WebhookClient = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookAddress}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	// WebhookAllowPrivateNetworks allows webhooks to post to private,
+	// loopback and link-local addresses. It is false by default so that
+	// webhooks cannot reach the internal services of the network of the
+	// server, such as the metadata endpoints of cloud providers.
+	WebhookAllowPrivateNetworks bool
+	// WebhookCacheTimeout is the duration after which the list of
+	// models with active webhooks is reloaded from the database.
+	WebhookCacheTimeout = time.Minute
+	// webhookResponseMaxSize is the number of bytes of the
+	// responses to webhook requests saved in delivery logs.
+	webhookResponseMaxSize int64 = 4096
+)
+
+// noWebhookModels are the models on which webhooks cannot
+// be set, because they are used to deliver webhooks.
+var noWebhookModels = map[string]bool{
+	"Webhook":         true,
+	"WebhookDelivery": true,
+	"Job":             true,
+}
+
+// webhookSharedNetwork is the shared address space of carrier-grade NATs,
+// in which some cloud providers serve their metadata endpoint.
+var webhookSharedNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// webhookModels is the cache of the names of the models with active webhooks.
+// generation is incremented each time the cache is invalidated.
+var webhookModels struct {
+	sync.Mutex
+	models     map[string]bool
+	loadedAt   time.Time
+	generation int
+}
+
+// A WebhookPayload is the JSON payload posted to webhook URLs
+type WebhookPayload struct {
+	Event     string     `json:"event"`
+	Model     string     `json:"model"`
+	IDs       []int64    `json:"ids"`
+	Records   []FieldMap `json:"records,omitempty"`
+	UID       int64      `json:"uid"`
+	Timestamp int64      `json:"timestamp"`
+}
+
+// A WebhookRequest is the request received by an incoming webhook endpoint.
+// It is given to the model method bound to the endpoint.
+type WebhookRequest struct {
+	Header http.Header
+	Query  url.Values
+	Body   []byte
+}
+
+// webhookDelivery is the payload of a webhook delivery job
+type webhookDelivery struct {
+	WebhookID int64           `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// declareWebhookModels declares the Webhook model which defines URLs
+// to which record events are posted, and the WebhookDelivery model
+// which logs each attempt to post an event.
+//
+// Both models can only be accessed by administrators, since webhooks
+// hold their signing secret and deliveries the posted records.
+func declareWebhookModels() {
+	webhook := NewModel("Webhook")
+	webhook.AddMethod("CheckURL",
+		`CheckURL checks that the URL of the webhook is an absolute http or https URL`,
+		func(rc *RecordCollection) {
+			if webhookURL := rc.Get("URL").(string); !validWebhookURL(webhookURL) {
+				log.Panic("Webhook URLs must be absolute http or https URLs", "webhook", rc.Get("Name"), "url", webhookURL)
+			}
+		})
+	webhook.AddFields(map[string]FieldDefinition{
+		"Name":     CharField{Required: true},
+		"ResModel": CharField{Required: true, Index: true, Help: "Model whose record events are posted"},
+		"OnCreate": BooleanField{},
+		"OnWrite":  BooleanField{},
+		"OnUnlink": BooleanField{},
+		"Filter": TextField{
+			Help: "JSON encoded domain that records must match for their events to be posted. Leave empty for all records"},
+		"URL":    CharField{Required: true, Constraint: webhook.Methods().MustGet("CheckURL")},
+		"Secret": CharField{NoCopy: true, Help: "Secret with which payloads are signed"},
+		"Active": BooleanField{Default: DefaultValue(true)},
+	})
+	webhook.RestrictToAdmins()
+
+	webhookDelivery := NewModel("WebhookDelivery")
+	webhookDelivery.AddFields(map[string]FieldDefinition{
+		"WebhookID":  IntegerField{Required: true, Index: true},
+		"JobID":      IntegerField{Index: true},
+		"Event":      CharField{},
+		"Payload":    TextField{},
+		"Attempt":    IntegerField{},
+		"StatusCode": IntegerField{},
+		"Response":   TextField{Help: "Beginning of the response body"},
+		"Error":      TextField{},
+		"Duration":   FloatField{Help: "Duration of the request in seconds"},
+	})
+	webhookDelivery.RestrictToAdmins()
+}
+
+// hasWebhooks returns true if there are active webhooks on the given model.
+// The cache is reloaded from the database outside of its lock, so that
+// concurrent transactions do not wait for each other's query.
+func hasWebhooks(env Environment, modelName string) bool {
+	webhookModels.Lock()
+	models, loadedAt, generation := webhookModels.models, webhookModels.loadedAt, webhookModels.generation
+	webhookModels.Unlock()
+	if models != nil && time.Since(loadedAt) <= WebhookCacheTimeout {
+		return models[modelName]
+	}
+	models = make(map[string]bool)
+	rc := env.Pool("Webhook").Sudo()
+	for _, webhook := range rc.Search(rc.Model().Field("Active").Equals(true)).Records() {
+		models[webhook.Get("ResModel").(string)] = true
+	}
+	webhookModels.Lock()
+	defer webhookModels.Unlock()
+	// The cache may have been invalidated during our query
+	if webhookModels.generation == generation {
+		webhookModels.models = models
+		webhookModels.loadedAt = time.Now()
+	}
+	return models[modelName]
+}
+
+// invalidateWebhookCache clears the cache of the models with active webhooks
+func invalidateWebhookCache() {
+	webhookModels.Lock()
+	defer webhookModels.Unlock()
+	webhookModels.models = nil
+	webhookModels.generation++
+}
+
+// dispatchWebhooks enqueues a delivery job for each active webhook of the
+// model of rc for the given event ("create", "write" or "unlink") whose
+// filter matches records of rc.
+//
+// Unlink events must be dispatched before the records are deleted.
+func (rc *RecordCollection) dispatchWebhooks(event string) {
+	if rc.model.name == "Webhook" {
+		invalidateWebhookCache()
+		return
+	}
+	if noWebhookModels[rc.model.name] || rc.env.memory != nil || rc.IsEmpty() || !hasWebhooks(*rc.env, rc.model.name) {
+		return
+	}
+	whRC := rc.env.Pool("Webhook").Sudo()
+	eventField := map[string]string{"create": "OnCreate", "write": "OnWrite", "unlink": "OnUnlink"}[event]
+	webhooks := whRC.Search(whRC.Model().Field("ResModel").Equals(rc.model.name).
+		And().Field("Active").Equals(true).
+		And().Field(eventField).Equals(true))
+	ids := rc.Ids()
+	for _, webhook := range webhooks.Records() {
+		records := rc.env.Pool(rc.model.name).Sudo()
+		cond := records.Model().Field("ID").In(ids)
+		if filter := webhook.Get("Filter").(string); filter != "" {
+			var domain []interface{}
+			err := json.Unmarshal([]byte(filter), &domain)
+			filterCond, pErr := ParseDomain(domain)
+			if err != nil || pErr != nil {
+				log.Warn("Invalid webhook filter", "webhook", webhook.ids[0], "filter", filter, "error", err, "parseError", pErr)
+				continue
+			}
+			cond = cond.AndCond(filterCond)
+		}
+		records = records.Search(cond)
+		if records.IsEmpty() {
+			continue
+		}
+		payload := WebhookPayload{
+			Event:     event,
+			Model:     rc.model.name,
+			IDs:       records.Ids(),
+			UID:       rc.env.uid,
+			Timestamp: time.Now().Unix(),
+		}
+		if event != "unlink" {
+			payload.Records = webhookRecordValues(records)
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Panic("Unable to marshal webhook payload", "model", rc.model.name, "event", event, "error", err)
+		}
+		EnqueueJob(*rc.env, webhookJob, webhookDelivery{
+			WebhookID: webhook.ids[0],
+			Event:     fmt.Sprintf("%s.%s", rc.model.name, event),
+			Body:      body,
+		})
+	}
+}
+
+// webhookHiddenField returns true if the values of the given field must not
+// be posted to webhooks, because it is a binary field, a field that ordinary
+// users cannot read or a field whose name contains one of the
+// strutils.SensitiveWords, such as password hashes.
+func webhookHiddenField(fi *Field) bool {
+	return fi.fieldType == fieldtype.Binary || !fi.acl.CheckPermission(security.GroupEveryone, security.Read) ||
+		strutils.IsSensitiveName(fi.name)
+}
+
+// webhookRecordValues returns the values of the stored fields of the given
+// records, by JSON field name. Relation fields are given as ids. Fields
+// for which webhookHiddenField is true are omitted.
+func webhookRecordValues(records *RecordCollection) []FieldMap {
+	var res []FieldMap
+	fields := records.model.fields.storedFieldNames()
+	for _, rec := range records.Records() {
+		values := make(FieldMap)
+		for _, fName := range fields {
+			fi := records.model.fields.MustGet(fName)
+			if webhookHiddenField(fi) {
+				continue
+			}
+			value := rec.Get(fName)
+			if rs, ok := value.(RecordSet); ok {
+				value = rs.Ids()
+				if fi.fieldType.IsFKRelationType() {
+					value = nil
+					if len(rs.Ids()) > 0 {
+						value = rs.Ids()[0]
+					}
+				}
+			}
+			values[fi.json] = value
+		}
+		res = append(res, values)
+	}
+	return res
+}
+
+// deliverWebhook posts the payload of the given webhook delivery job to the
+// URL of its webhook and logs the attempt in a WebhookDelivery record.
+// It returns an error if the request failed or if the response status is
+// not 2xx, so that the delivery is retried.
+func deliverWebhook(env Environment, job *Job) error {
+	var delivery webhookDelivery
+	if err := json.Unmarshal(job.Payload, &delivery); err != nil {
+		return err
+	}
+	rc := env.Pool("Webhook").Sudo()
+	webhook := rc.Search(rc.Model().Field("ID").Equals(delivery.WebhookID).And().Field("Active").Equals(true))
+	if webhook.IsEmpty() {
+		log.Info("Webhook deleted or deactivated, dropping delivery", "webhook", delivery.WebhookID, "event", delivery.Event)
+		return nil
+	}
+	logCtx := log.New("webhook", delivery.WebhookID, "event", delivery.Event, "job", job.ID, "attempt", job.Attempt)
+	logValues := FieldMap{
+		"WebhookID": delivery.WebhookID,
+		"JobID":     job.ID,
+		"Event":     delivery.Event,
+		"Payload":   string(delivery.Body),
+		"Attempt":   job.Attempt,
+	}
+	start := time.Now()
+	status, response, err := postWebhook(webhook.Get("URL").(string), webhook.Get("Secret").(string), delivery.Event, job.ID, delivery.Body)
+	logValues["Duration"] = time.Since(start).Seconds()
+	logValues["StatusCode"] = status
+	logValues["Response"] = response
+	if err == nil && (status < 200 || status >= 300) {
+		err = fmt.Errorf("webhook returned status %d", status)
+	}
+	if err != nil {
+		logValues["Error"] = err.Error()
+		logCtx.Info("Webhook delivery failed", "status", status, "error", err)
+	} else {
+		logCtx.Debug("Webhook delivered", "status", status)
+	}
+	env.Pool("WebhookDelivery").Sudo().Call("Create", logValues)
+	return err
+}
+
+// validWebhookURL returns true if the given URL
+// is an absolute http or https URL.
+func validWebhookURL(webhookURL string) bool {
+	u, err := url.Parse(webhookURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkWebhookAddress is the Control function of the dialer of
+// WebhookClient. It refuses to connect to loopback, private, link-local
+// and unspecified addresses and to the shared address space, unless
+// WebhookAllowPrivateNetworks is set. It is given the resolved address,
+// so that host names resolving to such addresses are refused too.
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	if WebhookAllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() || webhookSharedNetwork.Contains(ip) {
+		return fmt.Errorf("webhooks cannot be posted to the private address %s", host)
+	}
+	return nil
+}
+
+// postWebhook posts body to the given url, signed with secret if it is not
+// empty. It returns the status code and the beginning of the response body.
+func postWebhook(url, secret, event string, deliveryID int64, body []byte) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Doxa-Webhook")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, fmt.Sprintf("%d", deliveryID))
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, security.SignPayload([]byte(secret), body))
+	}
+	resp, err := WebhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	response, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookResponseMaxSize))
+	return resp.StatusCode, string(response), nil
+}
+
+// WebhookDeliveries returns the delivery logs of the webhook
+// with the given id, the most recent first.
+func WebhookDeliveries(env Environment, webhookID int64, limit int) *RecordCollection {
+	rc := env.Pool("WebhookDelivery").Sudo()
+	return rc.Search(rc.Model().Field("WebhookID").Equals(webhookID)).OrderBy("ID DESC").Limit(limit)
+}
+
+func init() {
+	RegisterJobHandler(webhookJob, deliverWebhook)
+}
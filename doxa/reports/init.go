@@ -0,0 +1,91 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+var log *logging.Logger
+
+// BootStrap checks the reports and paper formats and registers an action
+// for each report, so that reports can be printed from the views of their model.
+// Report actions are bound to the 'Print' menu of the toolbar of these views,
+// unless the report has the NoToolbar flag.
+// This function must be called before actions.BootStrap.
+func BootStrap() {
+	for _, pf := range PaperFormats.formats {
+		if width, height := pf.Size(); width <= 0 || height <= 0 {
+			log.Panic("Paper formats must have a standard format or a page size", "paperformat", pf.ID)
+		}
+	}
+	for _, report := range Registry.GetAll() {
+		if _, ok := models.Registry.Get(report.Model); !ok {
+			log.Panic("Unknown model in report", "report", report.ID, "model", report.Model)
+		}
+		if report.Template == "" {
+			log.Panic("Reports must have a template", "report", report.ID)
+		}
+		switch report.Type {
+		case "":
+			report.Type = ReportPDF
+		case ReportPDF, ReportHTML:
+		default:
+			log.Panic("Unknown report type", "report", report.ID, "type", report.Type)
+		}
+		if report.PaperFormat == "" {
+			report.PaperFormat = DefaultPaperFormat
+		}
+		if PaperFormats.GetByID(report.PaperFormat) == nil {
+			log.Panic("Unknown paper format in report", "report", report.ID, "paperformat", report.PaperFormat)
+		}
+		if report.FileName == "" {
+			report.FileName = report.Name
+		}
+		action := &actions.Action{
+			ID:         report.ID,
+			Type:       actions.ActionReport,
+			Name:       report.Name,
+			Model:      report.Model,
+			SrcModel:   report.Model,
+			Multi:      report.Multi,
+			ReportName: report.ID,
+			ReportType: string(report.Type),
+		}
+		if !report.NoToolbar {
+			action.Bind(report.Model, actions.BindingReport)
+		}
+		actions.Registry.Add(action)
+	}
+}
+
+func init() {
+	log = logging.GetLogger("reports")
+	Registry = NewCollection()
+	PaperFormats = NewPaperFormatCollection()
+	PaperFormats.Add(&PaperFormat{
+		ID:           "paperformat_a4",
+		Name:         "A4",
+		Format:       "A4",
+		Orientation:  Portrait,
+		MarginTop:    10,
+		MarginBottom: 10,
+		MarginLeft:   7,
+		MarginRight:  7,
+		DPI:          90,
+	})
+	PaperFormats.Add(&PaperFormat{
+		ID:           "paperformat_us",
+		Name:         "US Letter",
+		Format:       "Letter",
+		Orientation:  Portrait,
+		MarginTop:    10,
+		MarginBottom: 10,
+		MarginLeft:   7,
+		MarginRight:  7,
+		DPI:          90,
+	})
+}
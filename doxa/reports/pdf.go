@@ -0,0 +1,186 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// A PDFConverter converts HTML documents to PDF
+type PDFConverter interface {
+	// ToPDF returns the PDF document of the given HTML document,
+	// laid out with the given paper format.
+	ToPDF(ctx context.Context, html []byte, format *PaperFormat) ([]byte, error)
+}
+
+// converters are the registered PDF converters, by name
+var converters = struct {
+	sync.RWMutex
+	registry map[string]PDFConverter
+}{
+	registry: make(map[string]PDFConverter),
+}
+
+// RegisterConverter registers a PDF converter under the given name,
+// which can then be selected with the Reports.Converter configuration key.
+func RegisterConverter(name string, converter PDFConverter) {
+	converters.Lock()
+	defer converters.Unlock()
+	converters.registry[name] = converter
+}
+
+// ToPDF converts the given HTML document to PDF with the converter given by
+// the Reports.Converter configuration key, which defaults to 'wkhtmltopdf'.
+func ToPDF(ctx context.Context, html []byte, format *PaperFormat) ([]byte, error) {
+	name := viper.GetString("Reports.Converter")
+	if name == "" {
+		name = "wkhtmltopdf"
+	}
+	converters.RLock()
+	converter, ok := converters.registry[name]
+	converters.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown PDF converter %q", name)
+	}
+	if timeout := viper.GetDuration("Reports.Timeout"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return converter.ToPDF(ctx, html, format)
+}
+
+// converterPath returns the path of the executable of a converter, given by
+// the Reports.ConverterPath configuration key or the given default name.
+func converterPath(defaultName string) string {
+	if path := viper.GetString("Reports.ConverterPath"); path != "" {
+		return path
+	}
+	return defaultName
+}
+
+// runConverter runs the given command with stdin as input
+// and returns its output or an error with its stderr.
+func runConverter(cmd *exec.Cmd, stdin []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// formatMM formats the given length in millimeters for converters
+func formatMM(length float64) string {
+	return strconv.FormatFloat(length, 'f', -1, 64) + "mm"
+}
+
+// Wkhtmltopdf converts HTML documents to PDF with the wkhtmltopdf executable
+type Wkhtmltopdf struct{}
+
+// ToPDF returns the PDF document of the given HTML document
+func (Wkhtmltopdf) ToPDF(ctx context.Context, html []byte, format *PaperFormat) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, converterPath("wkhtmltopdf"), wkhtmltopdfArgs(format)...)
+	return runConverter(cmd, html)
+}
+
+// wkhtmltopdfArgs returns the command line arguments of wkhtmltopdf to
+// convert a document from stdin to stdout with the given paper format.
+// Documents cannot read local files, so that the content of a report
+// cannot include files of the server.
+func wkhtmltopdfArgs(format *PaperFormat) []string {
+	args := []string{"--quiet", "--encoding", "utf-8", "--disable-local-file-access"}
+	if _, ok := paperSizes[format.Format]; ok {
+		args = append(args, "--page-size", format.Format)
+		if format.Orientation != "" {
+			args = append(args, "--orientation", string(format.Orientation))
+		}
+	} else {
+		width, height := format.Size()
+		args = append(args, "--page-width", formatMM(width), "--page-height", formatMM(height))
+	}
+	args = append(args,
+		"--margin-top", formatMM(format.MarginTop),
+		"--margin-bottom", formatMM(format.MarginBottom),
+		"--margin-left", formatMM(format.MarginLeft),
+		"--margin-right", formatMM(format.MarginRight))
+	if format.DPI > 0 {
+		args = append(args, "--dpi", strconv.Itoa(format.DPI))
+	}
+	return append(args, "-", "-")
+}
+
+// Chromium converts HTML documents to PDF with a headless chromium browser
+type Chromium struct{}
+
+// ToPDF returns the PDF document of the given HTML document.
+// The paper format is given to chromium as a CSS @page rule.
+func (Chromium) ToPDF(ctx context.Context, html []byte, format *PaperFormat) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "doxa-report")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "report.html")
+	output := filepath.Join(dir, "report.pdf")
+	if err := ioutil.WriteFile(input, withPageStyle(html, format), 0600); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, converterPath("chromium"), chromiumArgs(input, output)...)
+	if _, err := runConverter(cmd, nil); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(output)
+}
+
+// chromiumArgs returns the command line arguments of chromium to convert
+// the given input file to the given output PDF file. Chromium is run with
+// its sandbox, which is why the server must not be run as root to use it.
+//
+// Since the document is loaded from a file, it is prevented from reading
+// other files by the Content-Security-Policy added by withPageStyle.
+func chromiumArgs(input, output string) []string {
+	return []string{"--headless", "--disable-gpu", "--no-pdf-header-footer", "--print-to-pdf=" + output, "file://" + input}
+}
+
+// reportCSP is the Content-Security-Policy of the documents converted by
+// chromium, which only allows inline styles and images given as data URIs.
+const reportCSP = `<meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src data:; style-src 'unsafe-inline'">`
+
+// headTag matches the opening head tag of HTML documents
+var headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// withPageStyle returns the given HTML document with reportCSP and a CSS
+// @page rule setting the size and margins of the given paper format. They
+// are inserted at the beginning of the head of the document, so that the
+// policy applies to all the resources of the document.
+func withPageStyle(html []byte, format *PaperFormat) []byte {
+	width, height := format.Size()
+	style := fmt.Sprintf("%s<style>@page { size: %s %s; margin: %s %s %s %s; }</style>", reportCSP,
+		formatMM(width), formatMM(height), formatMM(format.MarginTop), formatMM(format.MarginRight),
+		formatMM(format.MarginBottom), formatMM(format.MarginLeft))
+	if loc := headTag.FindIndex(html); loc != nil {
+		return append(append(append([]byte{}, html[:loc[1]]...), style...), html[loc[1]:]...)
+	}
+	return append([]byte(style), html...)
+}
+
+func init() {
+	RegisterConverter("wkhtmltopdf", Wkhtmltopdf{})
+	RegisterConverter("chromium", Chromium{})
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"encoding/xml"
+	"sort"
+	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+)
+
+// A ReportType defines how a report is output
+type ReportType string
+
+// Report types
+const (
+	ReportPDF  ReportType = "qweb-pdf"
+	ReportHTML ReportType = "qweb-html"
+)
+
+// An Orientation is the orientation of the pages of a report
+type Orientation string
+
+// Page orientations
+const (
+	Portrait  Orientation = "Portrait"
+	Landscape Orientation = "Landscape"
+)
+
+// DefaultPaperFormat is the id of the paper format
+// of the reports that do not define one.
+const DefaultPaperFormat = "paperformat_a4"
+
+// Registry is the report collection of the application
+var Registry *Collection
+
+// PaperFormats is the paper format collection of the application
+var PaperFormats *PaperFormatCollection
+
+// A Report is the definition of a printable document of the
+// records of a model, rendered from a QWeb template.
+type Report struct {
+	ID       string     `json:"id" xml:"id,attr"`
+	Name     string     `json:"name" xml:"name,attr"`
+	Model    string     `json:"model" xml:"model,attr"`
+	Template string     `json:"template" xml:"template,attr"`
+	Type     ReportType `json:"report_type" xml:"report_type,attr"`
+	// PaperFormat is the id of the paper format of the report.
+	// It defaults to DefaultPaperFormat.
+	PaperFormat string `json:"paperformat_id" xml:"paperformat,attr"`
+	// FileName is the name of the downloaded file, without extension.
+	// It defaults to the name of the report.
+	FileName string `json:"print_report_name" xml:"file,attr"`
+	// Multi is true if the report can be printed for several records at once
+	Multi bool `json:"multi" xml:"multi,attr"`
+	// NoToolbar is true if the report must not be listed in the
+	// 'Print' menu of the toolbar of the views of its model.
+	NoToolbar bool `json:"-" xml:"no_toolbar,attr"`
+}
+
+// A Collection is a collection of reports
+type Collection struct {
+	sync.RWMutex
+	reports map[string]*Report
+}
+
+// NewCollection returns a pointer to a new Collection instance
+func NewCollection() *Collection {
+	return &Collection{
+		reports: make(map[string]*Report),
+	}
+}
+
+// Add adds the given report to the Collection,
+// replacing any report with the same id.
+func (rc *Collection) Add(r *Report) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.reports[r.ID] = r
+}
+
+// GetByID returns the Report with the given id or nil if it does not exist
+func (rc *Collection) GetByID(id string) *Report {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.reports[id]
+}
+
+// MustGetByID returns the Report with the given id.
+// It panics if the id is not found in the Collection.
+func (rc *Collection) MustGetByID(id string) *Report {
+	report := rc.GetByID(id)
+	if report == nil {
+		log.Panic("Report does not exist", "report_id", id)
+	}
+	return report
+}
+
+// GetAll returns all the reports of this Collection, sorted by id
+func (rc *Collection) GetAll() []*Report {
+	rc.RLock()
+	defer rc.RUnlock()
+	res := make([]*Report, 0, len(rc.reports))
+	for _, report := range rc.reports {
+		res = append(res, report)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// GetAllForModel returns the reports of the given model, sorted by id
+func (rc *Collection) GetAllForModel(modelName string) []*Report {
+	var res []*Report
+	for _, report := range rc.GetAll() {
+		if report.Model == modelName {
+			res = append(res, report)
+		}
+	}
+	return res
+}
+
+// LoadFromEtree reads the report defined by the given <report> element
+// and adds it to the Collection.
+func (rc *Collection) LoadFromEtree(element *etree.Element) {
+	xmlBytes := []byte(xmlutils.ElementToXML(element))
+	var report Report
+	if err := xml.Unmarshal(xmlBytes, &report); err != nil {
+		log.Panic("Unable to unmarshal element", "error", err, "bytes", string(xmlBytes))
+	}
+	rc.Add(&report)
+}
+
+// LoadFromEtree reads the report defined by the given <report>
+// element and adds it to the report Registry.
+func LoadFromEtree(element *etree.Element) {
+	Registry.LoadFromEtree(element)
+}
+
+// A PaperFormat defines the size, orientation and margins of the
+// pages of PDF reports. Sizes and margins are in millimeters.
+type PaperFormat struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+	// Format is a standard paper size such as 'A4' or 'Letter'.
+	// If it is empty, PageWidth and PageHeight are used.
+	Format       string      `xml:"format,attr"`
+	PageWidth    float64     `xml:"page_width,attr"`
+	PageHeight   float64     `xml:"page_height,attr"`
+	Orientation  Orientation `xml:"orientation,attr"`
+	MarginTop    float64     `xml:"margin_top,attr"`
+	MarginBottom float64     `xml:"margin_bottom,attr"`
+	MarginLeft   float64     `xml:"margin_left,attr"`
+	MarginRight  float64     `xml:"margin_right,attr"`
+	// DPI is the resolution used to render the report. 0 means the converter default.
+	DPI int `xml:"dpi,attr"`
+}
+
+// paperSizes are the dimensions in millimeters
+// of the standard paper sizes, in portrait.
+var paperSizes = map[string][2]float64{
+	"A3":     {297, 420},
+	"A4":     {210, 297},
+	"A5":     {148, 210},
+	"Letter": {215.9, 279.4},
+	"Legal":  {215.9, 355.6},
+}
+
+// Size returns the width and height in millimeters of the
+// pages of this paper format, according to its orientation.
+func (pf *PaperFormat) Size() (float64, float64) {
+	width, height := pf.PageWidth, pf.PageHeight
+	if size, ok := paperSizes[pf.Format]; ok {
+		width, height = size[0], size[1]
+	}
+	if pf.Orientation == Landscape {
+		return height, width
+	}
+	return width, height
+}
+
+// A PaperFormatCollection is a collection of paper formats
+type PaperFormatCollection struct {
+	sync.RWMutex
+	formats map[string]*PaperFormat
+}
+
+// NewPaperFormatCollection returns a pointer to a new PaperFormatCollection instance
+func NewPaperFormatCollection() *PaperFormatCollection {
+	return &PaperFormatCollection{
+		formats: make(map[string]*PaperFormat),
+	}
+}
+
+// Add adds the given paper format to the collection,
+// replacing any paper format with the same id.
+func (pfc *PaperFormatCollection) Add(pf *PaperFormat) {
+	pfc.Lock()
+	defer pfc.Unlock()
+	pfc.formats[pf.ID] = pf
+}
+
+// GetByID returns the PaperFormat with the given id or nil if it does not exist
+func (pfc *PaperFormatCollection) GetByID(id string) *PaperFormat {
+	pfc.RLock()
+	defer pfc.RUnlock()
+	return pfc.formats[id]
+}
+
+// LoadFromEtree reads the paper format defined by the given
+// <paperformat> element and adds it to the collection.
+func (pfc *PaperFormatCollection) LoadFromEtree(element *etree.Element) {
+	xmlBytes := []byte(xmlutils.ElementToXML(element))
+	var pf PaperFormat
+	if err := xml.Unmarshal(xmlBytes, &pf); err != nil {
+		log.Panic("Unable to unmarshal element", "error", err, "bytes", string(xmlBytes))
+	}
+	pfc.Add(&pf)
+}
+
+// LoadPaperFormatFromEtree reads the paper format defined by the given
+// <paperformat> element and adds it to the PaperFormats collection.
+func LoadPaperFormatFromEtree(element *etree.Element) {
+	PaperFormats.LoadFromEtree(element)
+}
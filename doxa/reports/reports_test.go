@@ -0,0 +1,154 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package reports
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/actions"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+var reportDef1 = `
+<report id="report_invoice" name="Invoice" model="Invoice" template="invoice_document"/>
+`
+
+var reportDef2 = `
+<report id="report_invoice_html" name="Invoice (HTML)" model="Invoice" template="invoice_document"
+        report_type="qweb-html" paperformat="paperformat_label" file="invoices" multi="true" no_toolbar="true"/>
+`
+
+var paperFormatDef = `
+<paperformat id="paperformat_label" name="Label" page_width="100" page_height="50" orientation="Landscape"
+             margin_top="2" margin_bottom="2" margin_left="3.5" margin_right="3.5" dpi="120"/>
+`
+
+type fakeConverter struct {
+	format *PaperFormat
+}
+
+func (fc *fakeConverter) ToPDF(ctx context.Context, html []byte, format *PaperFormat) ([]byte, error) {
+	fc.format = format
+	return append([]byte("%PDF "), html...), nil
+}
+
+func TestReports(t *testing.T) {
+	Convey("Creating models", t, func() {
+		invoice := models.NewModel("Invoice")
+		invoice.AddFields(map[string]models.FieldDefinition{
+			"Number": models.CharField{},
+		})
+		models.BootStrap()
+	})
+	Convey("Loading reports and paper formats", t, func() {
+		LoadFromEtree(xmlutils.XMLToElement(reportDef1))
+		LoadFromEtree(xmlutils.XMLToElement(reportDef2))
+		LoadPaperFormatFromEtree(xmlutils.XMLToElement(paperFormatDef))
+		report := Registry.MustGetByID("report_invoice_html")
+		So(report.Name, ShouldEqual, "Invoice (HTML)")
+		So(report.Model, ShouldEqual, "Invoice")
+		So(report.Template, ShouldEqual, "invoice_document")
+		So(report.Type, ShouldEqual, ReportHTML)
+		So(report.FileName, ShouldEqual, "invoices")
+		So(report.Multi, ShouldBeTrue)
+		So(func() { Registry.MustGetByID("unknown_report") }, ShouldPanic)
+		pf := PaperFormats.GetByID("paperformat_label")
+		So(pf, ShouldNotBeNil)
+		So(pf.MarginLeft, ShouldEqual, 3.5)
+		So(pf.DPI, ShouldEqual, 120)
+		width, height := pf.Size()
+		So(width, ShouldEqual, 50)
+		So(height, ShouldEqual, 100)
+		width, height = PaperFormats.GetByID(DefaultPaperFormat).Size()
+		So(width, ShouldEqual, 210)
+		So(height, ShouldEqual, 297)
+	})
+	Convey("Bootstrapping reports", t, func() {
+		BootStrap()
+		report := Registry.MustGetByID("report_invoice")
+		So(report.Type, ShouldEqual, ReportPDF)
+		So(report.PaperFormat, ShouldEqual, DefaultPaperFormat)
+		So(report.FileName, ShouldEqual, "Invoice")
+		So(Registry.GetAllForModel("Invoice"), ShouldHaveLength, 2)
+		action := actions.Registry.GetById("report_invoice")
+		So(action, ShouldNotBeNil)
+		So(action.Type, ShouldEqual, actions.ActionReport)
+		So(action.ReportName, ShouldEqual, "report_invoice")
+		So(action.ReportType, ShouldEqual, "qweb-pdf")
+		So(actions.Registry.GetActionLinksForModel("Invoice"), ShouldHaveLength, 2)
+		So(action.BindingModel, ShouldEqual, "Invoice")
+		So(action.BindingType, ShouldEqual, actions.BindingReport)
+		So(actions.Registry.GetById("report_invoice_html").BindingModel, ShouldBeEmpty)
+		Convey("Invalid reports should panic", func() {
+			Registry.Add(&Report{ID: "report_unknown_model", Model: "Unknown", Template: "t"})
+			defer delete(Registry.reports, "report_unknown_model")
+			So(BootStrap, ShouldPanic)
+		})
+		Convey("Reports with unknown paper format should panic", func() {
+			Registry.Add(&Report{ID: "report_unknown_format", Model: "Invoice", Template: "t", PaperFormat: "unknown"})
+			defer delete(Registry.reports, "report_unknown_format")
+			So(BootStrap, ShouldPanic)
+		})
+	})
+	Convey("Converting HTML to PDF", t, func() {
+		label := PaperFormats.GetByID("paperformat_label")
+		Convey("wkhtmltopdf arguments should follow the paper format", func() {
+			args := strings.Join(wkhtmltopdfArgs(PaperFormats.GetByID(DefaultPaperFormat)), " ")
+			So(args, ShouldEqual, "--quiet --encoding utf-8 --disable-local-file-access --page-size A4 --orientation Portrait --margin-top 10mm --margin-bottom 10mm --margin-left 7mm --margin-right 7mm --dpi 90 - -")
+			args = strings.Join(wkhtmltopdfArgs(label), " ")
+			So(args, ShouldEqual, "--quiet --encoding utf-8 --disable-local-file-access --page-width 50mm --page-height 100mm --margin-top 2mm --margin-bottom 2mm --margin-left 3.5mm --margin-right 3.5mm --dpi 120 - -")
+		})
+		Convey("Chromium should be run with its sandbox", func() {
+			args := chromiumArgs("/tmp/report.html", "/tmp/report.pdf")
+			So(args, ShouldNotContain, "--no-sandbox")
+			So(args, ShouldContain, "file:///tmp/report.html")
+		})
+		Convey("Chromium documents should get a page style", func() {
+			html := string(withPageStyle([]byte("<html><head></head><body>x</body></html>"), label))
+			So(html, ShouldEqual, "<html><head>"+reportCSP+"<style>@page { size: 50mm 100mm; margin: 2mm 3.5mm 2mm 3.5mm; }</style></head><body>x</body></html>")
+		})
+		Convey("Chromium documents should not be allowed to read local files", func() {
+			html := string(withPageStyle([]byte(`<html><HEAD lang="en"><link rel="stylesheet" href="file:///etc/passwd"/></HEAD><body><header>x</header></body></html>`), label))
+			So(html, ShouldStartWith, `<html><HEAD lang="en"><meta http-equiv="Content-Security-Policy" content="default-src 'none'; img-src data:;`)
+			So(html, ShouldEndWith, `<link rel="stylesheet" href="file:///etc/passwd"/></HEAD><body><header>x</header></body></html>`)
+			So(string(withPageStyle([]byte("<p>x</p>"), label)), ShouldStartWith, reportCSP)
+		})
+		Convey("The configured converter should be used", func() {
+			fc := new(fakeConverter)
+			RegisterConverter("fake", fc)
+			viper.Set("Reports.Converter", "fake")
+			defer viper.Set("Reports.Converter", "")
+			pdf, err := ToPDF(context.Background(), []byte("<p>x</p>"), label)
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldEqual, "%PDF <p>x</p>")
+			So(fc.format, ShouldEqual, label)
+			viper.Set("Reports.Converter", "unknown")
+			_, err = ToPDF(context.Background(), []byte("<p>x</p>"), label)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("wkhtmltopdf should be run with the document on stdin", func() {
+			dir, _ := ioutil.TempDir("", "doxa-reports-test")
+			defer os.RemoveAll(dir)
+			script := filepath.Join(dir, "wkhtmltopdf")
+			ioutil.WriteFile(script, []byte("#!/bin/sh\necho -n '%PDF '; cat\n"), 0755)
+			viper.Set("Reports.ConverterPath", script)
+			defer viper.Set("Reports.ConverterPath", "")
+			pdf, err := ToPDF(context.Background(), []byte("<p>x</p>"), label)
+			So(err, ShouldBeNil)
+			So(string(pdf), ShouldEqual, "%PDF <p>x</p>")
+			ioutil.WriteFile(script, []byte("#!/bin/sh\necho 'conversion failed' >&2; exit 1\n"), 0755)
+			_, err = ToPDF(context.Background(), []byte("<p>x</p>"), label)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "conversion failed")
+		})
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+// RequestIDHeader is the HTTP header carrying the request ID. It is read
+// from incoming requests (e.g. set by a reverse proxy) and always set on
+// responses.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key under which the request ID is stored
+const requestIDKey = "doxa_request_id"
+
+// validRequestID matches the request IDs accepted from clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var accessLog *logging.Logger
+
+// newRequestID returns a new random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic("Unable to generate request ID", "error", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// AssignRequestID is a middleware that assigns an ID to each request. The ID
+// given by the client in the RequestIDHeader is kept if it is valid.
+//
+// The ID is returned in the RequestIDHeader of the response and attached
+// with models.WithRequestID to the handling of the request, so that ORM
+// logs can be correlated with the request.
+func AssignRequestID(c *Context) {
+	requestID := c.GetHeader(RequestIDHeader)
+	if !validRequestID.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	c.Set(requestIDKey, requestID)
+	c.Header(RequestIDHeader, requestID)
+	models.WithRequestID(requestID, c.Next)
+}
+
+// RequestID returns the ID of this request, as set by AssignRequestID
+func (c *Context) RequestID() string {
+	return c.GetString(requestIDKey)
+}
+
+// AccessLog is a middleware that logs each request with its method,
+// route, status, duration, user and request ID as structured fields.
+//
+// Requests with errors are logged at the error level, requests with a
+// status of 400 or above at the warning level and others at the info level.
+func AccessLog(c *Context) {
+	start := time.Now()
+	// some middlewares modify this value
+	path := c.Request.URL.Path
+	c.Next()
+
+	status := c.Writer.Status()
+	logCtx := accessLog.New(
+		"request_id", c.RequestID(),
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", path,
+		"status", status,
+		"duration", time.Since(start),
+		"uid", c.UID(),
+		"ip", c.ClientIP(),
+	)
+	switch {
+	case len(c.Errors) > 0:
+		logCtx.Error(c.Errors.String())
+	case status >= 400:
+		logCtx.Warn("HTTP Error")
+	default:
+		logCtx.Info("Request")
+	}
+}
+
+func init() {
+	accessLog = logging.GetLogger("access")
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/tools"
+	"github.com/labneco/doxa/doxa/tools/assets"
+	"github.com/spf13/viper"
+)
+
+// AssetsPath is the http path under which asset bundles are served
+const AssetsPath = "/assets"
+
+// assetsCacheControl is the Cache-Control header of asset bundles. Bundles
+// can be cached forever since their file name changes with their content.
+const assetsCacheControl = "public, max-age=31536000, immutable"
+
+// An AssetBundle is a set of static files of the same type that are served
+// as a single file. The bundle is made of all the files of SubDir in the
+// static directories of all modules, in the order of module dependencies.
+//
+// CSS bundles are also built in a right-to-left variant, flipped with
+// assets.FlipCSS, which is served to users of right-to-left languages.
+type AssetBundle struct {
+	Name        string
+	Type        string
+	SubDir      string
+	fileName    string
+	rtlFileName string
+}
+
+var (
+	assetBundles      []*AssetBundle
+	assetBundlesMutex sync.RWMutex
+)
+
+// RegisterAssetBundle declares an asset bundle with the given name and type
+// ("js" or "css") made of the files in the given subdirectory of the static
+// directory of each module (e.g. "src/js").
+//
+// Bundles are built when the server starts.
+func RegisterAssetBundle(name, bundleType, subDir string) {
+	if assets.BundleExtensions(bundleType) == nil {
+		log.Panic("Unknown asset bundle type", "name", name, "type", bundleType)
+	}
+	assetBundlesMutex.Lock()
+	defer assetBundlesMutex.Unlock()
+	assetBundles = append(assetBundles, &AssetBundle{Name: name, Type: bundleType, SubDir: subDir})
+}
+
+// assetsDir returns the directory in which asset bundles are built
+func assetsDir() string {
+	return filepath.Join(viper.GetString("DataDir"), "assets")
+}
+
+// BuildAssetBundles builds all registered asset bundles. Bundles are
+// minified, unless the server is in debug mode.
+func BuildAssetBundles() {
+	assetBundlesMutex.Lock()
+	defer assetBundlesMutex.Unlock()
+	minify := !viper.GetBool("Debug")
+	for _, bundle := range assetBundles {
+		files := tools.ListStaticFiles(bundle.SubDir, Modules.Names(), true, assets.BundleExtensions(bundle.Type)...)
+		fileName, err := assets.BuildBundle(bundle.Name, bundle.Type, files, assetsDir(), minify)
+		if err != nil {
+			log.Panic("Unable to build asset bundle", "name", bundle.Name, "error", err)
+		}
+		bundle.fileName = fileName
+		if bundle.Type != assets.CSS {
+			continue
+		}
+		rtlFileName, err := assets.BuildRTLBundle(bundle.Name, files, assetsDir(), minify)
+		if err != nil {
+			log.Panic("Unable to build RTL asset bundle", "name", bundle.Name, "error", err)
+		}
+		bundle.rtlFileName = rtlFileName
+	}
+}
+
+// AssetURL returns the URL of the asset bundle with the given name,
+// or an empty string if there is no such bundle.
+func AssetURL(name string) string {
+	assetBundlesMutex.RLock()
+	defer assetBundlesMutex.RUnlock()
+	for _, bundle := range assetBundles {
+		if bundle.Name == name && bundle.fileName != "" {
+			return AssetsPath + "/" + bundle.fileName
+		}
+	}
+	return ""
+}
+
+// AssetURLForLang returns the URL of the asset bundle with the given name
+// for the given lang, or an empty string if there is no such bundle. This
+// is the URL of the right-to-left variant of CSS bundles if the Direction
+// of the LangParameters of lang is right-to-left, and AssetURL otherwise.
+func AssetURLForLang(name, lang string) string {
+	if i18n.GetLangParameters(lang).Direction != i18n.LangDirectionRTL {
+		return AssetURL(name)
+	}
+	assetBundlesMutex.RLock()
+	defer assetBundlesMutex.RUnlock()
+	for _, bundle := range assetBundles {
+		if bundle.Name != name || bundle.fileName == "" {
+			continue
+		}
+		if bundle.rtlFileName != "" {
+			return AssetsPath + "/" + bundle.rtlFileName
+		}
+		return AssetsPath + "/" + bundle.fileName
+	}
+	return ""
+}
+
+// AssetURL returns the URL of the asset bundle with the
+// given name for the language of this request.
+func (c *Context) AssetURL(name string) string {
+	return AssetURLForLang(name, c.Lang())
+}
+
+// ServeAsset serves the asset bundle file given in the 'file'
+// path parameter with far-future cache headers.
+func ServeAsset(c *Context) {
+	fileName := filepath.Join(assetsDir(), filepath.Base(c.Param("file")))
+	if fi, err := os.Stat(fileName); err != nil || fi.IsDir() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Cache-Control", assetsCacheControl)
+	c.File(fileName)
+}
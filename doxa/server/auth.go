@@ -0,0 +1,272 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/spf13/viper"
+)
+
+const (
+	// uidKey is the key under which the authenticated user id
+	// is stored in the context and in the session.
+	uidKey = "uid"
+	// apiScopesKey is the context key under which the scopes
+	// of the API key used for the request are stored.
+	apiScopesKey = "api_scopes"
+	// totpPendingKey is the session key under which the uid of a user
+	// who still has to give a two-factor authentication code is stored.
+	totpPendingKey = "totp_pending_uid"
+)
+
+// ErrNoPendingLogin is returned by VerifyTOTP when no
+// user is pending two-factor authentication.
+var ErrNoPendingLogin = errors.New("no login pending two-factor authentication")
+
+// bearerToken returns the token given in the Authorization header
+// of the request with the 'Bearer' scheme, or an empty string.
+func bearerToken(c *Context) string {
+	authHeader := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+// APIKeyAuth is a middleware that authenticates requests bearing an
+// API key in their Authorization header ("Authorization: Bearer <key>").
+//
+// Requests without API key are left untouched so that other authentication
+// methods (such as session cookies) can apply. Requests with an invalid
+// key are aborted with a 401 status.
+//
+// On success, the uid of the key owner is available through the UID method
+// of the Context and the scopes of the key through APIScopes.
+func APIKeyAuth(c *Context) {
+	key := bearerToken(c)
+	if key == "" || strings.Contains(key, ".") {
+		// No token or a JWT token which is not ours
+		return
+	}
+	uid, scopes, err := models.AuthenticateAPIKey(key)
+	if err != nil {
+		log.Warn("API key authentication failed", "prefix", security.APIKeyPrefix(key), "ip", c.ClientIP(), "error", err)
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	c.Set(uidKey, uid)
+	c.Set(apiScopesKey, scopes)
+}
+
+// JWTSigner returns the security.JWTSigner configured for this server.
+//
+// The signer is configured with the following keys:
+// - Server.JWT.Secret is the key used to sign new tokens. JWT
+// authentication is disabled if it is empty.
+// - Server.JWT.OldSecrets are previous keys still accepted for verification.
+// - Server.JWT.Expiry is the validity duration of new tokens (default 24h).
+// - Server.JWT.Issuer is the issuer claim of the tokens.
+func JWTSigner() *security.JWTSigner {
+	expiry := viper.GetDuration("Server.JWT.Expiry")
+	if expiry == 0 {
+		expiry = 24 * time.Hour
+	}
+	var oldKeys [][]byte
+	for _, key := range viper.GetStringSlice("Server.JWT.OldSecrets") {
+		oldKeys = append(oldKeys, []byte(key))
+	}
+	return &security.JWTSigner{
+		SigningKey:       []byte(viper.GetString("Server.JWT.Secret")),
+		VerificationKeys: oldKeys,
+		Issuer:           viper.GetString("Server.JWT.Issuer"),
+		Expiry:           expiry,
+	}
+}
+
+// JWTAuth is a middleware that authenticates requests bearing a JSON
+// Web Token in their Authorization header ("Authorization: Bearer <token>").
+//
+// Requests without token are left untouched so that other authentication
+// methods can apply. Requests with an invalid or expired token are aborted
+// with a 401 status, as well as tokens of users who have been deleted or
+// deactivated since the token was issued. This middleware does nothing if
+// no JWT secret is configured.
+func JWTAuth(c *Context) {
+	token := bearerToken(c)
+	if token == "" || !strings.Contains(token, ".") {
+		// No token or an API key
+		return
+	}
+	signer := JWTSigner()
+	if len(signer.SigningKey) == 0 {
+		return
+	}
+	claims, err := signer.ParseToken(token)
+	if err == nil && !models.UserActive(claims.UID()) {
+		err = security.InvalidTokenError("user does not exist or is inactive")
+	}
+	if err != nil {
+		log.Warn("JWT authentication failed", "ip", c.ClientIP(), "error", err)
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+	c.Set(uidKey, claims.UID())
+}
+
+// IssueToken returns a new JSON Web Token for the given uid, signed
+// with the server's JWTSigner. It is meant to be called by login
+// controllers after the user has been authenticated.
+//
+// It returns a security.InvalidTokenError if the user does not exist
+// anymore or is inactive.
+func (c *Context) IssueToken(uid int64) (string, error) {
+	if !models.UserActive(uid) {
+		return "", security.InvalidTokenError("user does not exist or is inactive")
+	}
+	return JWTSigner().NewToken(uid)
+}
+
+// An AuthType is the authentication requirement of a route
+type AuthType string
+
+// Authentication requirements of routes
+const (
+	// AuthUser routes require an authenticated user, whatever the
+	// authentication method (session, JSON Web Token or API key).
+	AuthUser AuthType = "user"
+	// AuthPublic routes can be called by anyone. The user is
+	// authenticated if the request has credentials.
+	AuthPublic AuthType = "public"
+	// AuthAPIKey routes require a request authenticated with an API key
+	AuthAPIKey AuthType = "apikey"
+	// AuthNone routes are called without user, even if
+	// the request has credentials.
+	AuthNone AuthType = "none"
+)
+
+// RequireAuth returns a middleware that enforces the given authentication
+// requirement. Requests that do not fulfill it are aborted with a 401 status.
+//
+// It panics if authType is not a known AuthType.
+func RequireAuth(authType AuthType) HandlerFunc {
+	switch authType {
+	case AuthUser:
+		return func(c *Context) {
+			if c.UID() == 0 {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+	case AuthAPIKey:
+		return func(c *Context) {
+			if _, ok := c.APIScopes(); !ok {
+				c.Header("WWW-Authenticate", "Bearer")
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+	case AuthNone:
+		return func(c *Context) {
+			c.Set(uidKey, int64(0))
+		}
+	case AuthPublic:
+		return func(c *Context) {}
+	}
+	log.Panic("Unknown authentication type", "type", authType)
+	return nil
+}
+
+// RequireAPIScopes returns a middleware that aborts requests authenticated
+// with an API key which has not been granted all the given scopes.
+//
+// Requests that have not been authenticated with an API key are not
+// affected by this middleware.
+func RequireAPIScopes(scopes ...string) HandlerFunc {
+	return func(c *Context) {
+		keyScopes, ok := c.APIScopes()
+		if !ok {
+			return
+		}
+		if !keyScopes.Allows(scopes...) {
+			c.AbortWithError(http.StatusForbidden, errors.New("API key does not grant the required scopes"))
+		}
+	}
+}
+
+// UID returns the id of the user authenticated for this request or 0
+// if the request is not authenticated.
+//
+// The user may have been authenticated by a middleware for this request
+// only (e.g. API key) or be stored in the session.
+func (c *Context) UID() int64 {
+	if uid, ok := c.Get(uidKey); ok {
+		return uid.(int64)
+	}
+	if uid, ok := c.Session().Get(uidKey).(int64); ok {
+		return uid
+	}
+	return 0
+}
+
+// APIScopes returns the scopes of the API key used to authenticate
+// this request. The second returned value is false if this request has
+// not been authenticated with an API key.
+func (c *Context) APIScopes() (security.APIScopes, bool) {
+	scopes, ok := c.Get(apiScopesKey)
+	if !ok {
+		return nil, false
+	}
+	return scopes.(security.APIScopes), true
+}
+
+// LogIn sets the user with the given uid as authenticated in the session.
+//
+// If the user has enabled two-factor authentication, the user is only
+// marked as pending in the session and LogIn returns false. The login
+// must then be completed by calling VerifyTOTP with a valid code.
+func (c *Context) LogIn(uid int64) bool {
+	sess := c.Session()
+	if models.TOTPEnabled(uid) {
+		sess.Delete(uidKey)
+		sess.Set(totpPendingKey, uid)
+		sess.Save()
+		return false
+	}
+	sess.Delete(totpPendingKey)
+	sess.Set(uidKey, uid)
+	sess.Save()
+	return true
+}
+
+// VerifyTOTP completes the login of a user pending two-factor
+// authentication if code is a valid TOTP or recovery code.
+//
+// Attempts are limited per user with LoginLimiter, whatever the IP address,
+// and recorded with models.AuthenticateTOTP so that the user is locked out
+// after too many failures. It returns ErrNoPendingLogin if no user is
+// pending, or the error of models.AuthenticateTOTP. If the rate limit is
+// exceeded, the request is aborted with a 429 status and ErrRateLimitExceeded
+// is returned.
+func (c *Context) VerifyTOTP(code string) error {
+	sess := c.Session()
+	uid, ok := sess.Get(totpPendingKey).(int64)
+	if !ok {
+		return ErrNoPendingLogin
+	}
+	if !c.AllowLogin(models.TOTPLogin(uid)) {
+		return ErrRateLimitExceeded
+	}
+	if err := models.AuthenticateTOTP(uid, code, c.ClientIP()); err != nil {
+		log.Warn("Invalid two-factor authentication code", "uid", uid, "ip", c.ClientIP(), "error", err)
+		return err
+	}
+	sess.Delete(totpPendingKey)
+	sess.Set(uidKey, uid)
+	sess.Save()
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// revalidateCacheControl is the Cache-Control header of responses that
+// clients may keep but must revalidate before each use.
+const revalidateCacheControl = "private, no-cache"
+
+// bootstrapTime holds the time at which PostInit completed
+var bootstrapTime atomic.Value
+
+// BootstrapTime returns the time at which the server initialization
+// completed, truncated to the second. Data computed at bootstrap, such
+// as views, menus and translations, has not been modified since.
+//
+// It returns the zero time if the server is not initialized yet.
+func BootstrapTime() time.Time {
+	t, _ := bootstrapTime.Load().(time.Time)
+	return t
+}
+
+// NotModified sets the given ETag and Last-Modified headers of the response
+// (if they are not empty) and a Cache-Control header requiring clients to
+// revalidate. If the conditional headers of the request show that the client
+// already has this version, it aborts with 304 Not Modified and returns true,
+// so that the handler can return without computing the response.
+//
+// If an ETag is given, If-None-Match takes precedence over If-Modified-Since.
+func (c *Context) NotModified(etag string, lastModified time.Time) bool {
+	header := c.Writer.Header()
+	header.Set("Cache-Control", revalidateCacheControl)
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if inm := c.GetHeader("If-None-Match"); inm != "" && etag != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// etagMatch returns true if etag is in the given If-None-Match header,
+// using the weak comparison.
+func etagMatch(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// A revalidateWriter buffers a response to compute its ETag
+type revalidateWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader stores the status code of the response
+func (w *revalidateWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+// WriteHeaderNow does nothing, since headers are written with the buffered body
+func (w *revalidateWriter) WriteHeaderNow() {}
+
+// Write buffers the given data
+func (w *revalidateWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+// WriteString buffers the given string
+func (w *revalidateWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Status returns the status code of the response
+func (w *revalidateWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of bytes buffered
+func (w *revalidateWriter) Size() int {
+	return w.body.Len()
+}
+
+// Written returns false since nothing is written before the end of the handlers
+func (w *revalidateWriter) Written() bool {
+	return false
+}
+
+// Revalidate is a middleware for GET endpoints serving mostly static data.
+// It buffers successful responses and sets their ETag to the hash of their
+// body, so that clients revalidating with If-None-Match get a 304 Not
+// Modified without body when the data has not changed.
+//
+// Handlers that can tell cheaply whether their data changed should also
+// call NotModified before computing their response. Responses with an
+// ETag set by the handler are sent unchanged.
+func Revalidate(c *Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Next()
+		return
+	}
+	original := c.Writer
+	w := &revalidateWriter{ResponseWriter: original, status: http.StatusOK}
+	c.Writer = w
+	c.Next()
+	c.Writer = original
+	header := original.Header()
+	if w.status == http.StatusOK && header.Get("ETag") == "" {
+		hash := sha1.Sum(w.body.Bytes())
+		lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+		if c.NotModified(`"`+hex.EncodeToString(hash[:])+`"`, lastModified) {
+			return
+		}
+	}
+	original.WriteHeader(w.status)
+	original.Write(w.body.Bytes())
+}
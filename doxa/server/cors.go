@@ -0,0 +1,164 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// defaultCORSMethods are the methods allowed by
+	// CORS policies that do not define AllowedMethods.
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	// defaultCORSHeaders are the request headers allowed by
+	// CORS policies that do not define AllowedHeaders.
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", RequestIDHeader}
+)
+
+// A CORSPolicy defines which cross-origin requests are allowed.
+//
+// AllowedOrigins are full origins (e.g. "https://app.example.com"), "*" to
+// allow all origins, or origins with a wildcard subdomain (e.g.
+// "https://*.example.com"). A policy without AllowedOrigins denies all
+// cross-origin requests.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// allowOrigin returns true if this policy allows the given origin
+func (p CORSPolicy) allowOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		switch {
+		case allowed == "*", allowed == origin:
+			return true
+		case strings.Contains(allowed, "://*."):
+			parts := strings.SplitN(allowed, "*", 2)
+			if strings.HasPrefix(origin, parts[0]) && strings.HasSuffix(origin, parts[1]) &&
+				len(origin) > len(allowed)-1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// corsPolicies are the CORS policies by path prefix
+var (
+	corsPolicies      map[string]CORSPolicy
+	corsPoliciesMutex sync.RWMutex
+)
+
+// SetCORSPolicy sets the CORS policy of the routes under the given path
+// prefix (e.g. "/api"). The policy of the longest matching prefix applies.
+//
+// It panics if the policy allows credentials for all origins.
+func SetCORSPolicy(pathPrefix string, policy CORSPolicy) {
+	if policy.AllowCredentials && policy.allowOrigin("*") {
+		log.Panic("CORS policy cannot allow credentials for all origins", "path", pathPrefix)
+	}
+	corsPoliciesMutex.Lock()
+	defer corsPoliciesMutex.Unlock()
+	corsPolicies[strings.TrimSuffix(pathPrefix, "/")] = policy
+}
+
+// corsPolicy returns the CORS policy that applies to the given path
+func corsPolicy(path string) (CORSPolicy, bool) {
+	corsPoliciesMutex.RLock()
+	defer corsPoliciesMutex.RUnlock()
+	prefixes := make([]string, 0, len(corsPolicies))
+	for prefix := range corsPolicies {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(prefixes)))
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return corsPolicies[prefix], true
+		}
+	}
+	return CORSPolicy{}, false
+}
+
+// ConfigureCORS sets the CORS policy of all routes from the
+// Server.CORS configuration keys.
+func ConfigureCORS() {
+	if !viper.IsSet("Server.CORS.AllowedOrigins") {
+		return
+	}
+	SetCORSPolicy("/", CORSPolicy{
+		AllowedOrigins:   viper.GetStringSlice("Server.CORS.AllowedOrigins"),
+		AllowedMethods:   viper.GetStringSlice("Server.CORS.AllowedMethods"),
+		AllowedHeaders:   viper.GetStringSlice("Server.CORS.AllowedHeaders"),
+		ExposedHeaders:   viper.GetStringSlice("Server.CORS.ExposedHeaders"),
+		AllowCredentials: viper.GetBool("Server.CORS.AllowCredentials"),
+		MaxAge:           viper.GetDuration("Server.CORS.MaxAge"),
+	})
+}
+
+// CORS is a middleware that applies the CORS policy of the requested path.
+//
+// Preflight requests are answered directly, so that they do not need a
+// route. Cross-origin requests from origins that are not allowed are
+// served without CORS headers, so that browsers block their response.
+func CORS(c *Context) {
+	origin := c.GetHeader("Origin")
+	policy, ok := corsPolicy(c.Request.URL.Path)
+	if origin == "" || !ok {
+		return
+	}
+	c.Writer.Header().Add("Vary", "Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if !policy.allowOrigin(origin) {
+		log.Debug("CORS request denied", "origin", origin, "path", c.Request.URL.Path)
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+		return
+	}
+	if policy.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if policy.allowOrigin("*") && !policy.AllowCredentials {
+		c.Header("Access-Control-Allow-Origin", "*")
+	} else {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+	if !preflight {
+		if len(policy.ExposedHeaders) > 0 {
+			c.Header("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+		return
+	}
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+	c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+	c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	c.Header("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if policy.MaxAge > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func init() {
+	corsPolicies = make(map[string]CORSPolicy)
+}
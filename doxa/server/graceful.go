@@ -0,0 +1,208 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// listenersEnv is the environment variable that holds the comma separated
+	// addresses of the listeners inherited from the parent process on restart.
+	// The listener of the i-th address is the file descriptor 3+i.
+	listenersEnv = "DOXA_LISTENERS"
+	// parentPIDEnv is the environment variable that holds the pid of the
+	// parent process which must be stopped once the new process is serving.
+	parentPIDEnv = "DOXA_PARENT_PID"
+)
+
+// A ShutdownHook is a function called on graceful shutdown after the
+// HTTP servers have stopped. It must return before ctx is done.
+type ShutdownHook func(ctx context.Context)
+
+var (
+	shutdownHooks      []ShutdownHook
+	shutdownHooksMutex sync.Mutex
+	// shutdownStarted is closed when the graceful shutdown starts
+	shutdownStarted     = make(chan struct{})
+	shutdownStartedOnce sync.Once
+	// notifyParentOnce makes sure the parent process is only stopped once
+	notifyParentOnce sync.Once
+)
+
+// RegisterShutdownHook registers a function to be called on graceful shutdown,
+// for instance to stop a background worker. Hooks are called in the reverse
+// order of their registration.
+func RegisterShutdownHook(hook ShutdownHook) {
+	shutdownHooksMutex.Lock()
+	defer shutdownHooksMutex.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// ShutdownStarted returns a channel that is closed when the graceful
+// shutdown of the server starts. Long-lived handlers (e.g. streaming or
+// longpolling handlers) must return when it is closed, since in-flight
+// requests are waited for.
+func ShutdownStarted() <-chan struct{} {
+	return shutdownStarted
+}
+
+// inheritedListener returns the listener on the given address
+// inherited from the parent process, or nil if there is none.
+func inheritedListener(addr string) net.Listener {
+	if os.Getenv(listenersEnv) == "" {
+		return nil
+	}
+	for i, a := range strings.Split(os.Getenv(listenersEnv), ",") {
+		if a != addr {
+			continue
+		}
+		f := os.NewFile(uintptr(3+i), "listener:"+addr)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Warn("Unable to use inherited listener", "address", addr, "error", err)
+			return nil
+		}
+		log.Info("Using inherited listener", "address", addr)
+		return ln
+	}
+	return nil
+}
+
+// listen returns a listener on the given address, either inherited from the
+// parent process or newly created. The listener is kept for restarts.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln := inheritedListener(addr)
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, ln)
+	s.addresses = append(s.addresses, addr)
+	return ln, nil
+}
+
+// serve listens on the address of srv and serves HTTP requests, or HTTPS
+// requests if useTLS is true. It returns nil if the server is shut down.
+func (s *Server) serve(srv *http.Server, useTLS bool, certFile, keyFile string) error {
+	setTimeouts(srv)
+	ln, err := s.listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.servers = append(s.servers, srv)
+	s.mu.Unlock()
+	notifyParentOnce.Do(stopParent)
+	if useTLS {
+		err = srv.ServeTLS(ln, certFile, keyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// stopParent asks the parent process which started this
+// process for a restart to shut down gracefully.
+func stopParent() {
+	pid, err := strconv.Atoi(os.Getenv(parentPIDEnv))
+	if err != nil {
+		return
+	}
+	parent, err := os.FindProcess(pid)
+	if err == nil {
+		err = parent.Signal(syscall.SIGTERM)
+	}
+	log.Info("Stopping parent process", "pid", pid, "error", err)
+}
+
+// Restart starts a new process of the server which inherits the listeners
+// of this process, so that no connection is refused during the restart.
+// The new process stops this process gracefully once it is serving.
+func (s *Server) Restart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return errors.New("server is not listening")
+	}
+	files := make([]*os.File, len(s.listeners))
+	for i, ln := range s.listeners {
+		fl, ok := ln.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return fmt.Errorf("listener on %s cannot be handed over", s.addresses[i])
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	// We use os.Args[0] rather than os.Executable so that
+	// a binary replaced during a deployment is started.
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", listenersEnv, strings.Join(s.addresses, ",")),
+		fmt.Sprintf("%s=%d", parentPIDEnv, os.Getpid()))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Info("Started new server process", "pid", cmd.Process.Pid)
+	return nil
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting
+// new connections, waits for in-flight requests to complete and
+// then calls the registered shutdown hooks.
+//
+// Shutdown returns when all hooks have returned or when ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	shutdownStartedOnce.Do(func() { close(shutdownStarted) })
+	log.Info("Shutting down server")
+	s.mu.Lock()
+	servers := s.servers
+	s.mu.Unlock()
+	var res error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warn("Unable to shut down HTTP server gracefully", "address", srv.Addr, "error", err)
+			res = err
+		}
+	}
+	shutdownHooksMutex.Lock()
+	hooks := shutdownHooks
+	shutdownHooksMutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i](ctx)
+	}
+	if res == nil {
+		res = ctx.Err()
+	}
+	log.Info("Server shut down", "error", res)
+	return res
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labneco/doxa/doxa/models"
+)
+
+// A ReadinessCheck returns an error if the application
+// is not ready to serve requests.
+type ReadinessCheck func() error
+
+var (
+	readinessMutex  sync.RWMutex
+	readinessChecks map[string]ReadinessCheck
+	// postInitDone is set to 1 once PostInit has been executed
+	postInitDone int32
+)
+
+// RegisterReadinessCheck registers the given check under the given name.
+// Registered checks are run by CheckReadiness, and the application is
+// only considered ready if all checks pass.
+//
+// Modules that depend on an external service can register a check for it.
+func RegisterReadinessCheck(name string, check ReadinessCheck) {
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+	readinessChecks[name] = check
+}
+
+// ReadinessChecks returns a copy of the registered readiness checks
+// indexed by name, so that they can be restored after being replaced.
+func ReadinessChecks() map[string]ReadinessCheck {
+	readinessMutex.RLock()
+	defer readinessMutex.RUnlock()
+	res := make(map[string]ReadinessCheck, len(readinessChecks))
+	for name, check := range readinessChecks {
+		res[name] = check
+	}
+	return res
+}
+
+// CheckReadiness runs all registered readiness checks and
+// returns their results indexed by name. The result of
+// successful checks is nil.
+func CheckReadiness() map[string]error {
+	readinessMutex.RLock()
+	defer readinessMutex.RUnlock()
+	res := make(map[string]error, len(readinessChecks))
+	for name, check := range readinessChecks {
+		res[name] = check()
+	}
+	return res
+}
+
+func init() {
+	readinessChecks = make(map[string]ReadinessCheck)
+	RegisterReadinessCheck("database", models.DBPing)
+	RegisterReadinessCheck("bootstrap", func() error {
+		if !models.BootStrapped() {
+			return errors.New("models are not bootstrapped")
+		}
+		return nil
+	})
+	RegisterReadinessCheck("modules", func() error {
+		if atomic.LoadInt32(&postInitDone) == 0 {
+			return errors.New("modules are not loaded")
+		}
+		return nil
+	})
+}
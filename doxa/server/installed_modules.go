@@ -0,0 +1,263 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// Installation states of the modules returned by ModulesStatus
+const (
+	// ModuleInstalled is the state of the modules that are installed at their current version
+	ModuleInstalled = "installed"
+	// ModuleToUpgrade is the state of the modules that are installed at an older version
+	ModuleToUpgrade = "to upgrade"
+	// ModuleNotInstalled is the state of the loaded modules that are not installed
+	ModuleNotInstalled = "not installed"
+	// ModuleNotLoaded is the state of the installed modules that are not loaded in the project
+	ModuleNotLoaded = "not loaded"
+)
+
+// A ModuleStatus is the installation status of a module
+type ModuleStatus struct {
+	Name string
+	// Version is the version of the module loaded in the project
+	Version string
+	// InstalledVersion is the version of the module installed in the database
+	InstalledVersion string
+	State            string
+}
+
+// declareModuleModel declares the Module model which keeps track
+// of the modules installed in the database and of their versions.
+func declareModuleModel() {
+	module := models.NewModel("Module")
+	module.AddFields(map[string]models.FieldDefinition{
+		"Name":    models.CharField{Required: true, Unique: true},
+		"Version": models.CharField{Help: "Version of the module installed in the database"},
+	})
+}
+
+// installedModules returns the installed version of the installed modules, by module name
+func installedModules() map[string]string {
+	res := make(map[string]string)
+	err := models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		for _, rec := range env.Pool("Module").SearchAll().Records() {
+			res[rec.Get("Name").(string)] = rec.Get("Version").(string)
+		}
+	})
+	if err != nil {
+		log.Panic("Unable to read installed modules", "error", err)
+	}
+	return res
+}
+
+// setModuleVersion records in env that the module with the given
+// name is installed at the given version.
+func setModuleVersion(env models.Environment, name, version string) {
+	rc := env.Pool("Module")
+	existing := rc.Search(rc.Model().Field("Name").Equals(name))
+	if existing.IsEmpty() {
+		rc.Call("Create", models.FieldMap{"Name": name, "Version": version})
+		return
+	}
+	existing.Call("Write", models.FieldMap{"Version": version})
+}
+
+// ModulesStatus returns the installation status of the modules loaded in the
+// project and of the modules installed in the database, ordered by name.
+func ModulesStatus() []ModuleStatus {
+	installed := installedModules()
+	var res []ModuleStatus
+	for _, mod := range Modules {
+		status := ModuleStatus{Name: mod.Name, Version: mod.Version, State: ModuleNotInstalled}
+		if version, ok := installed[mod.Name]; ok {
+			status.InstalledVersion = version
+			status.State = ModuleInstalled
+			if compareVersions(version, mod.Version) < 0 {
+				status.State = ModuleToUpgrade
+			}
+			delete(installed, mod.Name)
+		}
+		res = append(res, status)
+	}
+	for name, version := range installed {
+		res = append(res, ModuleStatus{Name: name, InstalledVersion: version, State: ModuleNotLoaded})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// selectModules returns the loaded modules with the given names, or all the
+// loaded modules if no name is given. It panics if a module is not loaded.
+func selectModules(moduleNames []string) []*Module {
+	if len(moduleNames) == 0 {
+		return Modules
+	}
+	var res []*Module
+	for _, name := range moduleNames {
+		mod := Modules.Get(name)
+		if mod == nil {
+			log.Panic("Unknown module", "module", name)
+		}
+		res = append(res, mod)
+	}
+	return res
+}
+
+// InstallModules installs the loaded modules with the given names, or all the
+// loaded modules that are not installed if no name is given. Installing a module
+// loads its data records, and its demo records if demo is true, and records its
+// version in the database. Modules that are already installed are skipped.
+// Modules are installed after the modules they depend on.
+//
+// The database schema must have been synchronized with models.SyncDatabase.
+func InstallModules(demo bool, moduleNames ...string) {
+	installed := installedModules()
+	for _, mod := range dependencyOrder(selectModules(moduleNames)) {
+		if _, ok := installed[mod.Name]; ok {
+			log.Info("Module already installed", "module", mod.Name)
+			continue
+		}
+		log.Info("Installing module", "module", mod.Name, "version", mod.Version)
+		LoadDataRecords(mod.Name)
+		if demo {
+			LoadDemoRecords(mod.Name)
+		}
+		err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			setModuleVersion(env, mod.Name, mod.Version)
+		})
+		if err != nil {
+			log.Panic("Unable to install module", "module", mod.Name, "error", err)
+		}
+	}
+}
+
+// UpgradeModules upgrades the installed modules with the given names, or all the
+// installed modules if no name is given, to the version of the loaded module.
+//
+// The migrations of the module which are newer than the installed version are run
+// in version order, each in its own transaction in which the installed version is
+// updated. The data records of the module are then reloaded. Modules are
+// upgraded after the modules they depend on.
+//
+// The database schema must have been synchronized with models.SyncDatabase.
+func UpgradeModules(moduleNames ...string) {
+	installed := installedModules()
+	for _, mod := range dependencyOrder(selectModules(moduleNames)) {
+		version, ok := installed[mod.Name]
+		if !ok {
+			if len(moduleNames) > 0 {
+				log.Panic("Module is not installed", "module", mod.Name)
+			}
+			continue
+		}
+		if compareVersions(version, mod.Version) > 0 {
+			log.Panic("Installed module is newer than the loaded module", "module", mod.Name,
+				"installed", version, "loaded", mod.Version)
+		}
+		log.Info("Upgrading module", "module", mod.Name, "from", version, "to", mod.Version)
+		for _, migrationVersion := range pendingMigrations(mod, version) {
+			migrate := mod.Migrations[migrationVersion]
+			err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				migrate(env)
+				setModuleVersion(env, mod.Name, migrationVersion)
+			})
+			if err != nil {
+				log.Panic("Error while migrating module", "module", mod.Name, "version", migrationVersion, "error", err)
+			}
+		}
+		LoadDataRecords(mod.Name)
+		err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			setModuleVersion(env, mod.Name, mod.Version)
+		})
+		if err != nil {
+			log.Panic("Unable to upgrade module", "module", mod.Name, "error", err)
+		}
+	}
+}
+
+// UninstallModules removes the modules with the given names from the installed
+// modules. The modules must not be loaded in the project anymore, so that the
+// tables and columns of their models have been dropped by models.SyncDatabase.
+func UninstallModules(moduleNames ...string) {
+	installed := installedModules()
+	for _, name := range moduleNames {
+		if Modules.Get(name) != nil {
+			log.Panic("Module is still loaded in the project", "module", name)
+		}
+		if _, ok := installed[name]; !ok {
+			log.Panic("Module is not installed", "module", name)
+		}
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		rc := env.Pool("Module")
+		rc.Search(rc.Model().Field("Name").In(moduleNames)).Call("Unlink")
+	})
+	if err != nil {
+		log.Panic("Unable to uninstall modules", "modules", moduleNames, "error", err)
+	}
+	for _, name := range moduleNames {
+		log.Info("Module uninstalled", "module", name)
+	}
+}
+
+// pendingMigrations returns the versions of the migrations of mod that
+// are newer than the given installed version and not newer than the
+// version of mod, in version order.
+func pendingMigrations(mod *Module, installedVersion string) []string {
+	var res []string
+	for version := range mod.Migrations {
+		if compareVersions(version, installedVersion) > 0 && compareVersions(version, mod.Version) <= 0 {
+			res = append(res, version)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return compareVersions(res[i], res[j]) < 0
+	})
+	return res
+}
+
+// compareVersions compares the dot separated versions a and b and returns
+// -1, 0 or 1 if a is respectively older than, the same as or newer than b.
+// Numeric parts are compared as numbers and other parts as strings. A missing
+// part is older than any other, so that "1.0" is newer than "1".
+func compareVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart string
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		if aPart == bPart {
+			continue
+		}
+		aNum, aErr := strconv.Atoi(aPart)
+		bNum, bErr := strconv.Atoi(bPart)
+		if aErr == nil && bErr == nil {
+			if aNum == bNum {
+				continue
+			}
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		}
+		if aPart < bPart {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
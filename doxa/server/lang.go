@@ -0,0 +1,107 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/models"
+)
+
+// langKey is the key under which the language of the
+// user is stored in the context and in the session.
+const langKey = "lang"
+
+// AssignLang is a middleware that attaches the language of the request, as
+// returned by the Lang method of the Context, with models.WithRequestLang to
+// the handling of the request. Environments created by controllers are thus
+// in the language of the user.
+func AssignLang(c *Context) {
+	models.WithRequestLang(c.Lang(), c.Next)
+}
+
+// Lang returns the language of this request. It is, in order of priority:
+//   - the language set for this request with SetLang,
+//   - the language stored in the session with SetLang, i.e. the preference
+//     of the user,
+//   - the loaded language that best matches the Accept-Language header.
+//
+// It returns an empty string if none of them is set.
+func (c *Context) Lang() string {
+	if lang := c.GetString(langKey); lang != "" {
+		return lang
+	}
+	if lang, ok := c.Session().Get(langKey).(string); ok && lang != "" {
+		return lang
+	}
+	return matchAcceptLanguage(c.GetHeader("Accept-Language"), i18n.Langs)
+}
+
+// SetLang sets the language of the user for this request and stores it in
+// the session for the next ones. It is meant to be called by controllers
+// at login or when users change the language of their preferences.
+func (c *Context) SetLang(lang string) {
+	c.Set(langKey, lang)
+	sess := c.Session()
+	sess.Set(langKey, lang)
+	if err := sess.Save(); err != nil {
+		log.Warn("Unable to save language in session", "lang", lang, "error", err)
+	}
+}
+
+// matchAcceptLanguage returns the language of langs that best matches the
+// given Accept-Language header value, or an empty string if none matches.
+//
+// Languages of the header are tried by decreasing quality. A language tag
+// matches a language of langs with the same tag (e.g. 'fr-CA' matches
+// 'fr_CA') and, failing that, a language of langs with the same base
+// language (e.g. 'fr-CA' matches 'fr' or 'fr_FR').
+func matchAcceptLanguage(header string, langs []string) string {
+	type acceptedLang struct {
+		tag     string
+		quality float64
+	}
+	var accepted []acceptedLang
+	for _, item := range strings.Split(header, ",") {
+		tokens := strings.Split(strings.TrimSpace(item), ";")
+		tag := strings.Replace(strings.TrimSpace(tokens[0]), "-", "_", -1)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range tokens[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err == nil {
+				quality = q
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedLang{tag: tag, quality: quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	for _, al := range accepted {
+		for _, lang := range langs {
+			if strings.EqualFold(lang, al.tag) {
+				return lang
+			}
+		}
+		base := strings.SplitN(al.tag, "_", 2)[0]
+		for _, lang := range langs {
+			if strings.EqualFold(strings.SplitN(lang, "_", 2)[0], base) {
+				return lang
+			}
+		}
+	}
+	return ""
+}
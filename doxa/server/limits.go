@@ -0,0 +1,142 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// NoTimeout disables the handler deadline of a route and the read and write
+// timeouts of the server for its requests. It is meant for long-lived routes,
+// such as websockets, event streams or large uploads and downloads.
+const NoTimeout time.Duration = -1
+
+// timeoutGrace is the time given to handlers after their deadline
+// to write their response before the connection is closed.
+const timeoutGrace = 5 * time.Second
+
+// ErrBodyTooLarge is returned when reading a request body larger than its limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// setTimeouts sets the timeouts of the given server from the
+// Server.ReadHeaderTimeout, Server.ReadTimeout, Server.WriteTimeout and
+// Server.IdleTimeout configuration keys. A zero value means no timeout.
+func setTimeouts(srv *http.Server) {
+	srv.ReadHeaderTimeout = viper.GetDuration("Server.ReadHeaderTimeout")
+	srv.ReadTimeout = viper.GetDuration("Server.ReadTimeout")
+	srv.WriteTimeout = viper.GetDuration("Server.WriteTimeout")
+	srv.IdleTimeout = viper.GetDuration("Server.IdleTimeout")
+}
+
+// A limitedBody is a request body whose size is limited
+type limitedBody struct {
+	io.ReadCloser
+	ctx *Context
+	// limit is the maximum number of bytes of the body. 0 means no limit.
+	limit int64
+	read  int64
+}
+
+// Read reads from the body. It returns ErrBodyTooLarge and aborts the
+// request with 413 Request Entity Too Large if the body exceeds its limit.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.ReadCloser.Read(p)
+	}
+	if b.ctx.Request.ContentLength > b.limit || b.read > b.limit {
+		return 0, b.tooLarge()
+	}
+	if max := b.limit - b.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), b.tooLarge()
+	}
+	return n, err
+}
+
+// tooLarge aborts the request with 413 Request Entity Too Large,
+// unless a response has already been written, and returns ErrBodyTooLarge.
+func (b *limitedBody) tooLarge() error {
+	if !b.ctx.Writer.Written() {
+		b.ctx.AbortWithStatus(http.StatusRequestEntityTooLarge)
+	}
+	return ErrBodyTooLarge
+}
+
+// LimitRequestSize is a middleware limiting the size of request bodies to
+// the Server.MaxRequestSize configuration key in bytes, so that clients
+// cannot exhaust the memory of the server. Requests whose body is larger
+// get a 413 Request Entity Too Large response when the body is read.
+//
+// The limit can be changed for some routes with MaxRequestSize.
+func LimitRequestSize(c *Context) {
+	if c.Request.Body == nil {
+		return
+	}
+	c.Request.Body = &limitedBody{
+		ReadCloser: c.Request.Body,
+		ctx:        c,
+		limit:      viper.GetInt64("Server.MaxRequestSize"),
+	}
+}
+
+// MaxRequestSize returns a middleware setting the maximum size in bytes of the
+// request bodies of a route, overriding the Server.MaxRequestSize configuration
+// key. A size of 0 removes the limit, for instance for streamed uploads.
+func MaxRequestSize(size int64) HandlerFunc {
+	return func(c *Context) {
+		if body, ok := c.Request.Body.(*limitedBody); ok {
+			body.limit = size
+		}
+	}
+}
+
+// RequestTimeout returns a middleware setting the deadline of the handlers of
+// a route to timeout from now, or to the Server.HandlerTimeout configuration
+// key if timeout is 0.
+//
+// The context of the request is cancelled at the deadline, and requests whose
+// handlers return without response after the deadline get a 503 Service
+// Unavailable response. The read and write deadlines of the connection are
+// set accordingly, overriding the timeouts of the server.
+//
+// If timeout is NoTimeout, the read and write deadlines of the
+// connection are removed and the handlers have no deadline.
+func RequestTimeout(timeout time.Duration) HandlerFunc {
+	return func(c *Context) {
+		rc := http.NewResponseController(c.Writer)
+		d := timeout
+		switch d {
+		case NoTimeout:
+			rc.SetReadDeadline(time.Time{})
+			rc.SetWriteDeadline(time.Time{})
+			return
+		case 0:
+			d = viper.GetDuration("Server.HandlerTimeout")
+		}
+		if d <= 0 {
+			return
+		}
+		deadline := time.Now().Add(d)
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline.Add(timeoutGrace))
+		c.Next()
+		if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() {
+			log.Warn("Request handler deadline exceeded", "method", c.Request.Method, "path", c.Request.URL.Path, "timeout", d)
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -15,26 +15,51 @@
 package server
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/beevik/etree"
 	"github.com/labneco/doxa/doxa/actions"
 	"github.com/labneco/doxa/doxa/i18n"
 	"github.com/labneco/doxa/doxa/menus"
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/reports"
+	"github.com/labneco/doxa/doxa/tools/fake"
 	"github.com/labneco/doxa/doxa/tools/generate"
 	"github.com/labneco/doxa/doxa/views"
+	"github.com/spf13/viper"
 )
 
 // A Module is a go package that implements business features.
 // This struct is used to register modules.
 type Module struct {
-	Name     string
+	Name string
+	// Version is the current version of the module, made of dot separated
+	// parts such as "1.2.0". It is recorded in the database when the module
+	// is installed or upgraded.
+	Version string
+	// Depends are the names of the modules whose data must be loaded before
+	// the data of this module, e.g. because its data files reference records
+	// of these modules by their external IDs.
+	Depends  []string
 	PreInit  func()
 	PostInit func()
+	// Migrations are the functions that migrate the data of an installed
+	// module to the version of their key. They are run in version order by
+	// 'doxa modules upgrade' for the versions newer than the installed one.
+	Migrations map[string]func(env models.Environment)
+	// LargeDemo are the volumes of fake records of the models of this module
+	// that are generated in order after its demo records in large demo mode.
+	LargeDemo []fake.Volume
 }
 
 // A ModulesList is a list of Module objects
@@ -49,9 +74,65 @@ func (ml *ModulesList) Names() []string {
 	return res
 }
 
+// Get returns the module with the given name in this ModuleList,
+// or nil if there is no such module.
+func (ml *ModulesList) Get(name string) *Module {
+	for _, module := range *ml {
+		if module.Name == name {
+			return module
+		}
+	}
+	return nil
+}
+
 // Modules is the list of activated modules in the application
 var Modules ModulesList
 
+// dependencyOrder returns the given modules sorted so that each module comes
+// after the modules it depends on. Modules keep their order otherwise. It
+// panics if a module depends on a module that is not registered, or if
+// modules depend on each other.
+func dependencyOrder(mods []*Module) []*Module {
+	selected := make(map[string]*Module, len(mods))
+	for _, mod := range mods {
+		selected[mod.Name] = mod
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(mods))
+	res := make([]*Module, 0, len(mods))
+	var visit func(mod *Module, path []string)
+	visit = func(mod *Module, path []string) {
+		path = append(path, mod.Name)
+		switch state[mod.Name] {
+		case visiting:
+			log.Panic("Circular dependency between modules", "modules", strings.Join(path, " -> "))
+		case visited:
+			return
+		}
+		state[mod.Name] = visiting
+		for _, depName := range mod.Depends {
+			dep, ok := selected[depName]
+			if !ok {
+				if Modules.Get(depName) == nil {
+					log.Panic("Unknown module dependency", "module", mod.Name, "dependency", depName)
+				}
+				// Dependencies that are not selected are ignored
+				continue
+			}
+			visit(dep, path)
+		}
+		state[mod.Name] = visited
+		res = append(res, mod)
+	}
+	for _, mod := range mods {
+		visit(mod, nil)
+	}
+	return res
+}
+
 // RegisterModule registers the given module in the server
 // This function should be called in the init() function of
 // all Doxa Addons.
@@ -60,39 +141,236 @@ func RegisterModule(mod *Module) {
 }
 
 // LoadInternalResources loads all data in the 'resources' directory, that are
+// - security groups and memberships,
 // - views,
 // - actions,
 // - menu items
-// Internal resources are defined in XML files.
+// Internal resources are defined in XML files. Security groups can
+// also be defined in the GroupsFileName CSV file.
 func LoadInternalResources() {
+	loadData("resources", "csv", loadGroupsFile)
 	loadData("resources", "xml", loadXMLResourceFile)
 }
 
+// GroupsFileName is the name of the CSV file of the 'resources' directory
+// of a module in which security groups and memberships are declared.
+const GroupsFileName = "groups.csv"
+
+// loadGroupsFile declares the groups of the given CSV file if it is
+// a GroupsFileName file. Other CSV files are ignored.
+func loadGroupsFile(fileName string) {
+	if filepath.Base(fileName) != GroupsFileName {
+		return
+	}
+	security.LoadGroupsCSVFile(fileName)
+}
+
 // LoadDataRecords loads all the data records in the 'data' directory into the database.
-// Data records are defined in CSV files.
-func LoadDataRecords() {
-	loadData("data", "csv", models.LoadCSVDataFile)
+// Data records are defined in CSV files. Records can also be defined with record tags
+// in the XML files of the 'resources' directory, which are loaded after the CSV files.
+// If moduleNames are given, only the records of these modules are loaded.
+//
+// The records of each module, in CSV and XML files, are loaded after the
+// records of the modules it depends on.
+//
+// If CollectDataErrors is true, the reports of the CSV files with lines
+// that could not be loaded are logged and returned.
+func LoadDataRecords(moduleNames ...string) []models.ImportReport {
+	loader, reports := csvDataLoader()
+	for _, mod := range dependencyOrder(selectModules(moduleNames)) {
+		loadModulesData([]string{mod.Name}, "data", "csv", loader)
+		loadModulesData([]string{mod.Name}, "resources", "xml", loadXMLDataRecords)
+	}
+	logImportReports(*reports)
+	return *reports
 }
 
+// CollectDataErrors is true if the lines of CSV data files that cannot be loaded
+// by LoadDataRecords and LoadDemoRecords are skipped and reported at the end of
+// the load, instead of aborting it. It is set from the DataCollectErrors
+// configuration key.
+var CollectDataErrors bool
+
+// ConfigureDataLoading sets the number of records of CSV data files loaded in
+// each transaction, CollectDataErrors, DemoTags and the large demo mode from the
+// DataBatchSize, DataCollectErrors, DemoTags, DemoLarge, DemoSeed and DemoScale
+// configuration keys.
+func ConfigureDataLoading() {
+	if viper.IsSet("DataBatchSize") {
+		models.DataBatchSize = viper.GetInt("DataBatchSize")
+	}
+	CollectDataErrors = viper.GetBool("DataCollectErrors")
+	DemoTags = viper.GetStringSlice("DemoTags")
+	LargeDemo = viper.GetBool("DemoLarge")
+	DemoSeed = viper.GetInt64("DemoSeed")
+	DemoScale = 1
+	if viper.IsSet("DemoScale") {
+		DemoScale = viper.GetFloat64("DemoScale")
+	}
+}
+
+// csvDataLoader returns the function loading CSV data files according to
+// CollectDataErrors, and the reports of the loaded files with errors, which
+// are appended by this function.
+func csvDataLoader() (func(string), *[]models.ImportReport) {
+	reports := new([]models.ImportReport)
+	if !CollectDataErrors {
+		return models.LoadCSVDataFile, reports
+	}
+	return func(fileName string) {
+		report := models.LoadCSVDataFileWithReport(fileName)
+		if len(report.Errors) > 0 {
+			*reports = append(*reports, report)
+		}
+	}, reports
+}
+
+// demoFileSelected returns true if the demo data file with the given name must
+// be loaded when the given tags are selected.
+func demoFileSelected(fileName string, tags []string) bool {
+	fileTags := strings.Split(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)), ".")[1:]
+	if len(fileTags) == 0 || len(tags) == 0 {
+		return true
+	}
+	for _, fileTag := range fileTags {
+		for _, tag := range tags {
+			if fileTag == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// logImportReports logs the errors of the given import reports
+func logImportReports(reports []models.ImportReport) {
+	if len(reports) == 0 {
+		return
+	}
+	var failed int
+	for _, report := range reports {
+		for _, importErr := range report.Errors {
+			log.Warn("Data line not loaded", "file", report.FileName, "line", importErr.Line, "externalID", importErr.ExternalID,
+				"field", importErr.Field, "value", importErr.Value, "error", importErr.Message)
+		}
+		failed += report.Failed
+	}
+	log.Warn("Some data lines could not be loaded", "files", len(reports), "lines", failed)
+}
+
+// DemoTags are the tags of the demo data files that are loaded by
+// LoadDemoRecords. If empty, all demo data files are loaded. It is set
+// from the DemoTags configuration key.
+var DemoTags []string
+
 // LoadDemoRecords loads all the data records in the 'demo' directory into the database.
-// Demo records are defined in CSV files.
-func LoadDemoRecords() {
-	loadData("demo", "csv", models.LoadCSVDataFile)
+// Demo records are defined in CSV files. If moduleNames are given, only the records of
+// these modules are loaded.
+//
+// Demo files can be tagged by adding dot separated tags after the model name,
+// e.g. 020-Partner.small.perf.csv. Files without tags are always loaded, and
+// tagged files only if one of their tags is in DemoTags or if DemoTags is empty.
+//
+// If LargeDemo is true, the fake records declared in the LargeDemo field of the
+// modules are generated after the demo files are loaded.
+//
+// If CollectDataErrors is true, the reports of the files with lines that
+// could not be loaded are logged and returned.
+func LoadDemoRecords(moduleNames ...string) []models.ImportReport {
+	loader, reports := csvDataLoader()
+	loadModulesData(moduleNames, "demo", "csv", func(fileName string) {
+		if !demoFileSelected(fileName, DemoTags) {
+			log.Debug("Skipping demo data file", "file", fileName, "tags", DemoTags)
+			return
+		}
+		loader(fileName)
+	})
+	if LargeDemo {
+		generateLargeDemoRecords(moduleNames)
+	}
+	logImportReports(*reports)
+	return *reports
+}
+
+// LargeDemo is true if LoadDemoRecords also generates the fake records
+// declared in the LargeDemo field of the modules. It is set from the
+// DemoLarge configuration key.
+var LargeDemo bool
+
+// DemoSeed is the seed of the generator of large demo records, so that
+// the same records are generated for the same seed. It is set from the
+// DemoSeed configuration key.
+var DemoSeed int64
+
+// DemoScale is the factor applied to the volumes of large demo records.
+// It is set from the DemoScale configuration key.
+var DemoScale float64 = 1
+
+// generateLargeDemoRecords creates the fake records declared in the LargeDemo
+// field of the given modules, or of all modules if no module is given. The
+// records of each volume, scaled by DemoScale, are created by batches of
+// models.DataBatchSize records, each batch in its own transaction.
+func generateLargeDemoRecords(moduleNames []string) {
+	g := fake.New(DemoSeed)
+	for _, mod := range dependencyOrder(selectModules(moduleNames)) {
+		for _, volume := range mod.LargeDemo {
+			count := scaledVolume(volume.Count, DemoScale)
+			log.Info("Generating large demo records", "module", mod.Name, "model", volume.Model, "count", count)
+			for created := 0; created < count; {
+				batch := count - created
+				if models.DataBatchSize > 0 && batch > models.DataBatchSize {
+					batch = models.DataBatchSize
+				}
+				err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+					g.Create(env, volume.Model, batch, volume.Fields)
+				})
+				if err != nil {
+					log.Panic("Unable to generate large demo records", "module", mod.Name, "model", volume.Model, "error", err)
+				}
+				created += batch
+			}
+		}
+	}
+}
+
+// scaledVolume returns the given number of records multiplied by scale
+func scaledVolume(count int, scale float64) int {
+	return int(math.Round(float64(count) * scale))
 }
 
 // LoadTranslations loads all translation data from the PO files in the 'i18n' directory
-// into the translations registry.
+// into the translations registry. The PO files of the fallback languages of the given
+// langs are loaded too, so that translations missing in a regional language can be
+// taken from its base language (see i18n.FallbackLangs).
 func LoadTranslations(langs []string) {
+	langs = withFallbackLangs(langs)
 	for _, mod := range Modules {
 		dataDir := filepath.Join(generate.DoxaDir, "doxa", "server", "i18n", mod.Name)
 		if _, err := os.Stat(dataDir); err != nil {
 			// No resources dir in this module
-			return
+			continue
 		}
 		LoadModuleTranslations(dataDir, langs)
 	}
 }
 
+// withFallbackLangs returns the given langs with
+// their fallback languages, without duplicates.
+func withFallbackLangs(langs []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, lang := range langs {
+		for _, l := range i18n.FallbackLangs(lang) {
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
 // LoadModuleTranslations loads the PO files in the given directory for the given languages
 func LoadModuleTranslations(i18nDir string, langs []string) {
 	var poFiles []string
@@ -113,7 +391,30 @@ func LoadModuleTranslations(i18nDir string, langs []string) {
 // loadData loads the files in the given dir with the given extension (without .)
 // using the loader function.
 func loadData(dir, ext string, loader func(string)) {
-	for _, mod := range Modules {
+	loadModulesData(nil, dir, ext, loader)
+}
+
+// loadModulesData loads the files in the given dir with the given extension
+// (without .) of the modules with the given names using the loader function.
+// The files of all modules are loaded if moduleNames is empty. Modules are
+// loaded after their dependencies and their files by file name. It panics if
+// one of moduleNames is not a registered module.
+func loadModulesData(moduleNames []string, dir, ext string, loader func(string)) {
+	known := make(map[string]bool, len(Modules))
+	for _, name := range Modules.Names() {
+		known[name] = true
+	}
+	selected := make(map[string]bool, len(moduleNames))
+	for _, name := range moduleNames {
+		if !known[name] {
+			log.Panic("Unknown module", "module", name)
+		}
+		selected[name] = true
+	}
+	for _, mod := range dependencyOrder(Modules) {
+		if len(selected) > 0 && !selected[mod.Name] {
+			continue
+		}
 		dataDir := filepath.Join(generate.DoxaDir, "doxa", "server", dir, mod.Name)
 		if _, err := os.Stat(dataDir); err != nil {
 			// No resources dir in this module
@@ -137,18 +438,71 @@ func loadXMLResourceFile(fileName string) {
 	if err := doc.ReadFromFile(fileName); err != nil {
 		log.Panic("Error loading XML data file", "file", fileName, "error", err)
 	}
+	lines := dataObjectLines(fileName)
+	var index int
 	for _, dataTag := range doc.FindElements("doxa/data") {
 		for _, object := range dataTag.ChildElements() {
+			source := fileName
+			if index < len(lines) {
+				source = fmt.Sprintf("%s:%d", fileName, lines[index])
+			}
+			index++
 			switch object.Tag {
+			case "group":
+				security.LoadGroupFromEtree(object)
+			case "membership":
+				security.LoadMembershipFromEtree(object)
 			case "view":
-				views.LoadFromEtree(object)
+				views.LoadFromEtreeWithSource(object, source)
 			case "action":
 				actions.LoadFromEtree(object)
 			case "menuitem":
 				menus.LoadFromEtree(object)
+			case "template":
+				LoadTemplateFromEtree(object)
+			case "report":
+				reports.LoadFromEtree(object)
+			case "paperformat":
+				reports.LoadPaperFormatFromEtree(object)
+			case "record", "delete":
+				// Records are loaded into or deleted from the database by LoadDataRecords
 			default:
 				log.Panic("Unknown XML tag", "filename", fileName, "tag", object.Tag)
 			}
 		}
 	}
 }
+
+// dataObjectLines returns the line numbers of the objects defined in the data
+// tags of the given XML data file, in document order. It returns nil if the
+// file cannot be read.
+func dataObjectLines(fileName string) []int {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil
+	}
+	var (
+		lines []int
+		path  []string
+	)
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return lines
+		}
+		if err != nil {
+			return nil
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			if len(path) == 2 && path[0] == "doxa" && path[1] == "data" {
+				lines = append(lines, bytes.Count(content[:offset], []byte("\n"))+1)
+			}
+			path = append(path, tok.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+}
@@ -0,0 +1,502 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+)
+
+// maxTemplateCallDepth is the maximum number of nested t-call
+// directives, to prevent infinite recursions.
+const maxTemplateCallDepth = 50
+
+// ErrTemplateNotFound is returned when rendering a template that does not exist
+var ErrTemplateNotFound = errors.New("template not found")
+
+// qwebTemplates is the registry of QWeb templates, by id
+var qwebTemplates = struct {
+	sync.RWMutex
+	registry map[string]*etree.Element
+}{
+	registry: make(map[string]*etree.Element),
+}
+
+// qwebFormatRegexp matches the expressions of t-attf-* directives
+var qwebFormatRegexp = regexp.MustCompile(`\{\{(.+?)\}\}|#\{(.+?)\}`)
+
+// htmlVoidElements are the HTML elements which cannot have content
+// and which are rendered without closing tag.
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// LoadTemplateFromEtree registers the QWeb template defined by the given
+// <template> element, such as:
+//
+//	<template id="report_partner">
+//	    <h1 t-esc="doc.Name"/>
+//	</template>
+//
+// A template replaces any previously loaded template with the same id.
+// This function panics if the template has no id or if one of
+// its expressions is invalid.
+func LoadTemplateFromEtree(element *etree.Element) {
+	id := element.SelectAttrValue("id", "")
+	if id == "" {
+		log.Panic("Templates must have an id", "template", xmlutils.ElementToXML(element))
+	}
+	if err := checkTemplateExpressions(element); err != nil {
+		log.Panic("Invalid template expression", "template", id, "error", err)
+	}
+	qwebTemplates.Lock()
+	defer qwebTemplates.Unlock()
+	qwebTemplates.registry[id] = xmlutils.CopyElement(element)
+}
+
+// RegisterTemplate registers a QWeb template with the given id and content,
+// which is the XML of the children of the template element.
+// It panics if the content is not valid XML or if an expression is invalid.
+func RegisterTemplate(id, content string) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(fmt.Sprintf("<template>%s</template>", content)); err != nil {
+		log.Panic("Unable to parse template", "template", id, "error", err)
+	}
+	element := doc.Root()
+	element.CreateAttr("id", id)
+	LoadTemplateFromEtree(element)
+}
+
+// TemplateExists returns true if a template with the given id is registered
+func TemplateExists(id string) bool {
+	qwebTemplates.RLock()
+	defer qwebTemplates.RUnlock()
+	_, ok := qwebTemplates.registry[id]
+	return ok
+}
+
+// getTemplate returns the template with the given id
+func getTemplate(id string) (*etree.Element, error) {
+	qwebTemplates.RLock()
+	defer qwebTemplates.RUnlock()
+	tmpl, ok := qwebTemplates.registry[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, id)
+	}
+	return tmpl, nil
+}
+
+// checkTemplateExpressions returns an error if one of the
+// expressions of the directives of element is invalid.
+func checkTemplateExpressions(element *etree.Element) error {
+	for _, attr := range element.Attr {
+		switch {
+		case attr.Key == "t-if", attr.Key == "t-elif", attr.Key == "t-foreach", attr.Key == "t-esc",
+			attr.Key == "t-raw", attr.Key == "t-value", strings.HasPrefix(attr.Key, "t-att-"):
+			if _, err := exprutils.Compile(attr.Value); err != nil {
+				return err
+			}
+		case strings.HasPrefix(attr.Key, "t-attf-"):
+			for _, match := range qwebFormatRegexp.FindAllStringSubmatch(attr.Value, -1) {
+				if _, err := exprutils.Compile(match[1] + match[2]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, child := range element.ChildElements() {
+		if err := checkTemplateExpressions(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderTemplate renders the QWeb template with the given id with the given
+// values, which are the variables available in the template expressions.
+// The given environment is available as 'env'. Records read in expressions
+// are read with the rights of the user of their environment.
+//
+// Expressions are evaluated by exprutils, with the fields of RecordSets and
+// the exported fields of structs as attributes. Only the read-only methods of
+// RecordSets, dates and lang_params can be called, and functions must be
+// given as exprutils.Func values.
+//
+// The parameters of the language of the environment are available as
+// 'lang_params' to format numbers and dates, for instance:
+//
+//	<span t-esc="lang_params.FormatFloat(doc.Total, 2, True)"/>
+//
+// Templates are XML documents whose elements may have the following directives:
+//
+// - t-if, t-elif and t-else render the element only if their expression is true.
+// - t-foreach renders the element for each item of its expression, which is set in the variable named by t-as.
+// - t-esc replaces the content of the element by the HTML escaped value of its expression.
+// - t-raw replaces the content of the element by the value of its expression, without escaping.
+// - t-set sets the variable it names to the value of its t-value expression or to its rendered content.
+// - t-call renders the template it names in place of the element.
+// - t-att-<name> and t-attf-<name> set the <name> attribute to the value of an expression or of a format string.
+//
+// Elements named 't' are not output, only their content.
+func RenderTemplate(env models.Environment, id string, values map[string]interface{}) (string, error) {
+	vars := make(map[string]interface{}, len(values)+2)
+	if env.Context() != nil {
+		vars["lang_params"] = i18n.GetLangParameters(env.Lang())
+	}
+	for k, v := range values {
+		vars[k] = v
+	}
+	vars["env"] = env
+	return renderTemplate(id, vars)
+}
+
+// renderTemplate renders the template with the given id
+// with the given variables, without environment.
+func renderTemplate(id string, vars map[string]interface{}) (res string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error rendering template %s: %v", id, r)
+		}
+	}()
+	var out bytes.Buffer
+	r := &qwebRenderer{out: &out}
+	if err := r.callTemplate(id, &qwebScope{vars: vars}); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Template renders the QWeb template with the given id as an HTML response,
+// in a new environment of the user of the request (see RenderTemplate).
+func (c *Context) Template(code int, id string, values map[string]interface{}) {
+	var (
+		res  string
+		rErr error
+	)
+	err := models.ExecuteInNewEnvironment(c.UID(), func(env models.Environment) {
+		res, rErr = RenderTemplate(env, id, values)
+	})
+	if err == nil {
+		err = rErr
+	}
+	if err != nil {
+		log.Warn("Unable to render template", "template", id, "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(code, "text/html; charset=utf-8", []byte(res))
+}
+
+// Branch states of t-if, t-elif and t-else chains
+const (
+	qwebNoBranch = iota
+	qwebBranchTaken
+	qwebBranchNotTaken
+)
+
+// A qwebRenderer renders QWeb templates
+type qwebRenderer struct {
+	out   *bytes.Buffer
+	depth int
+}
+
+// callTemplate renders the template with the given id in the given scope
+func (r *qwebRenderer) callTemplate(id string, scope *qwebScope) error {
+	if r.depth >= maxTemplateCallDepth {
+		return fmt.Errorf("maximum template call depth exceeded when calling %s", id)
+	}
+	tmpl, err := getTemplate(id)
+	if err != nil {
+		return err
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	if err := r.renderChildren(tmpl, scope); err != nil {
+		return fmt.Errorf("template %s: %v", id, err)
+	}
+	return nil
+}
+
+// renderChildren renders the children of element
+func (r *qwebRenderer) renderChildren(element *etree.Element, scope *qwebScope) error {
+	branch := qwebNoBranch
+	for _, token := range element.Child {
+		switch tok := token.(type) {
+		case *etree.CharData:
+			r.out.WriteString(html.EscapeString(tok.Data))
+		case *etree.Element:
+			var (
+				rendered bool
+				err      error
+			)
+			switch {
+			case tok.SelectAttr("t-elif") != nil:
+				if branch == qwebNoBranch {
+					return fmt.Errorf("t-elif without t-if in <%s>", tok.Tag)
+				}
+				if branch == qwebBranchTaken {
+					continue
+				}
+				rendered, err = r.renderElement(tok, scope)
+				branch = qwebBranchNotTaken
+				if rendered {
+					branch = qwebBranchTaken
+				}
+			case tok.SelectAttr("t-else") != nil:
+				if branch == qwebNoBranch {
+					return fmt.Errorf("t-else without t-if in <%s>", tok.Tag)
+				}
+				if branch == qwebBranchNotTaken {
+					_, err = r.renderElement(tok, scope)
+				}
+				branch = qwebNoBranch
+			case tok.SelectAttr("t-if") != nil:
+				rendered, err = r.renderElement(tok, scope)
+				branch = qwebBranchNotTaken
+				if rendered {
+					branch = qwebBranchTaken
+				}
+			default:
+				_, err = r.renderElement(tok, scope)
+				branch = qwebNoBranch
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderElement renders the given element with its directives. It returns
+// false if the element was not rendered because its condition is false.
+func (r *qwebRenderer) renderElement(element *etree.Element, scope *qwebScope) (bool, error) {
+	foreach := element.SelectAttr("t-foreach")
+	if foreach == nil {
+		return r.renderElementOnce(element, scope)
+	}
+	items, err := evalQWebExpr(foreach.Value, scope)
+	if err != nil {
+		return false, directiveError(element, "t-foreach", err)
+	}
+	as := element.SelectAttrValue("t-as", "")
+	if as == "" {
+		return false, fmt.Errorf("t-foreach without t-as in <%s>", element.Tag)
+	}
+	var rendered bool
+	err = qwebIterate(items, func(index, size int, item, value interface{}) error {
+		loopScope := scope.child()
+		loopScope.set(as, item)
+		loopScope.set(as+"_value", value)
+		loopScope.set(as+"_index", index)
+		loopScope.set(as+"_size", size)
+		loopScope.set(as+"_first", index == 0)
+		loopScope.set(as+"_last", index == size-1)
+		res, err := r.renderElementOnce(element, loopScope)
+		rendered = rendered || res
+		return err
+	})
+	if err != nil {
+		return false, directiveError(element, "t-foreach", err)
+	}
+	return rendered, nil
+}
+
+// renderElementOnce renders the given element with its
+// directives, except t-foreach which is handled by the caller.
+func (r *qwebRenderer) renderElementOnce(element *etree.Element, scope *qwebScope) (bool, error) {
+	for _, directive := range []string{"t-if", "t-elif"} {
+		if cond := element.SelectAttr(directive); cond != nil {
+			val, err := evalQWebExpr(cond.Value, scope)
+			if err != nil {
+				return false, directiveError(element, directive, err)
+			}
+			if !exprutils.Truth(val) {
+				return false, nil
+			}
+		}
+	}
+	if name := element.SelectAttr("t-set"); name != nil {
+		return true, r.setVariable(element, name.Value, scope)
+	}
+	if id := element.SelectAttr("t-call"); id != nil {
+		return true, r.callWithBody(element, id.Value, scope)
+	}
+	if element.Tag != "t" {
+		if err := r.openTag(element, scope); err != nil {
+			return false, err
+		}
+	}
+	if err := r.renderContent(element, scope); err != nil {
+		return false, err
+	}
+	if element.Tag != "t" && !htmlVoidElements[element.Tag] {
+		fmt.Fprintf(r.out, "</%s>", element.FullTag())
+	}
+	return true, nil
+}
+
+// setVariable sets the variable with the given name in scope to the
+// value of the t-value expression of element or to its rendered content.
+func (r *qwebRenderer) setVariable(element *etree.Element, name string, scope *qwebScope) error {
+	if expr := element.SelectAttr("t-value"); expr != nil {
+		val, err := evalQWebExpr(expr.Value, scope)
+		if err != nil {
+			return directiveError(element, "t-value", err)
+		}
+		scope.set(name, val)
+		return nil
+	}
+	content, err := r.renderToString(element, scope)
+	if err != nil {
+		return err
+	}
+	scope.set(name, content)
+	return nil
+}
+
+// callWithBody renders the template with the given id in a child scope in
+// which the t-set directives of the content of element are applied and the
+// rest of the rendered content is set in the '0' variable.
+func (r *qwebRenderer) callWithBody(element *etree.Element, id string, scope *qwebScope) error {
+	callScope := scope.child()
+	body, err := r.renderToString(element, callScope)
+	if err != nil {
+		return err
+	}
+	callScope.set("0", body)
+	return r.callTemplate(id, callScope)
+}
+
+// renderToString renders the content of element in the given scope and returns
+// it as HTML, so that it is not escaped again when output with t-esc.
+func (r *qwebRenderer) renderToString(element *etree.Element, scope *qwebScope) (template.HTML, error) {
+	out := r.out
+	var buf bytes.Buffer
+	r.out = &buf
+	defer func() { r.out = out }()
+	if err := r.renderChildren(element, scope); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// openTag writes the opening tag of element, with its static attributes
+// and the attributes set by t-att-* and t-attf-* directives.
+func (r *qwebRenderer) openTag(element *etree.Element, scope *qwebScope) error {
+	var (
+		keys   []string
+		values = make(map[string]*string)
+	)
+	setAttr := func(key string, value *string) {
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	for _, attr := range element.Attr {
+		switch {
+		case strings.HasPrefix(attr.Key, "t-att-"):
+			key := strings.TrimPrefix(attr.Key, "t-att-")
+			val, err := evalQWebExpr(attr.Value, scope)
+			if err != nil {
+				return directiveError(element, attr.Key, err)
+			}
+			switch val {
+			case nil, false:
+				setAttr(key, nil)
+			case true:
+				setAttr(key, &key)
+			default:
+				str := scope.toString(val)
+				setAttr(key, &str)
+			}
+		case strings.HasPrefix(attr.Key, "t-attf-"):
+			str, err := formatQWebString(attr.Value, scope)
+			if err != nil {
+				return directiveError(element, attr.Key, err)
+			}
+			setAttr(strings.TrimPrefix(attr.Key, "t-attf-"), &str)
+		case strings.HasPrefix(attr.Key, "t-") && attr.Space == "":
+		default:
+			value := attr.Value
+			setAttr(attr.FullKey(), &value)
+		}
+	}
+	fmt.Fprintf(r.out, "<%s", element.FullTag())
+	for _, key := range keys {
+		if values[key] != nil {
+			fmt.Fprintf(r.out, ` %s="%s"`, key, html.EscapeString(*values[key]))
+		}
+	}
+	if htmlVoidElements[element.Tag] {
+		r.out.WriteString("/>")
+		return nil
+	}
+	r.out.WriteString(">")
+	return nil
+}
+
+// renderContent renders the content of element, which is the value of its
+// t-esc or t-raw expression if it is not nil, or its children otherwise.
+// HTML values (such as the content of t-set and t-call) are never escaped.
+func (r *qwebRenderer) renderContent(element *etree.Element, scope *qwebScope) error {
+	for _, directive := range []string{"t-esc", "t-raw"} {
+		expr := element.SelectAttr(directive)
+		if expr == nil {
+			continue
+		}
+		val, err := evalQWebExpr(expr.Value, scope)
+		if err != nil {
+			return directiveError(element, directive, err)
+		}
+		if val == nil {
+			break
+		}
+		switch safe, ok := val.(template.HTML); {
+		case ok:
+			r.out.WriteString(string(safe))
+		case directive == "t-raw":
+			r.out.WriteString(scope.toString(val))
+		default:
+			r.out.WriteString(html.EscapeString(scope.toString(val)))
+		}
+		return nil
+	}
+	return r.renderChildren(element, scope)
+}
+
+// formatQWebString returns the given format string with its {{expr}}
+// and #{expr} parts replaced by the value of their expression.
+func formatQWebString(format string, scope *qwebScope) (string, error) {
+	var err error
+	res := qwebFormatRegexp.ReplaceAllStringFunc(format, func(part string) string {
+		match := qwebFormatRegexp.FindStringSubmatch(part)
+		val, eErr := evalQWebExpr(match[1]+match[2], scope)
+		if eErr != nil && err == nil {
+			err = eErr
+		}
+		return scope.toString(val)
+	})
+	return res, err
+}
+
+// directiveError returns an error of the given directive of element
+func directiveError(element *etree.Element, directive string, err error) error {
+	return fmt.Errorf("%s=%q in <%s>: %v", directive, element.SelectAttrValue(directive, ""), element.Tag, err)
+}
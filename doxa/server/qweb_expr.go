@@ -0,0 +1,277 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+)
+
+// evalQWebExpr evaluates the given expression in the given scope.
+// The expression "0" gives the content of the calling t-call element, if any.
+func evalQWebExpr(src string, scope *qwebScope) (interface{}, error) {
+	if strings.TrimSpace(src) == "0" {
+		body, _ := scope.lookup("0")
+		return body, nil
+	}
+	expr, err := exprutils.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr.EvalIn(scope)
+}
+
+// A qwebScope holds the variables of a template being rendered.
+// Variables are looked up in the parent scope if not found.
+type qwebScope struct {
+	vars   map[string]interface{}
+	parent *qwebScope
+}
+
+// child returns a new child scope of s
+func (s *qwebScope) child() *qwebScope {
+	return &qwebScope{vars: make(map[string]interface{}), parent: s}
+}
+
+// lookup returns the value of the given variable
+func (s *qwebScope) lookup(name string) (interface{}, bool) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if val, ok := sc.vars[name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// lang returns the language in which the template is rendered, which is
+// given by the 'lang' variable or by the context of the 'env' variable.
+func (s *qwebScope) lang() string {
+	if lang, ok := s.lookup("lang"); ok {
+		if res, ok := lang.(string); ok {
+			return res
+		}
+	}
+	if env, ok := s.lookup("env"); ok {
+		if e, ok := env.(models.Environment); ok && e.Context() != nil {
+			return e.Lang()
+		}
+	}
+	return ""
+}
+
+// toString returns the string to output for the given value,
+// translated in the language of the template if it is translatable.
+func (s *qwebScope) toString(val interface{}) string {
+	if t, ok := val.(i18n.Translatable); ok {
+		return t.Translate(s.lang())
+	}
+	return qwebString(val)
+}
+
+// set sets the given variable in this scope
+func (s *qwebScope) set(name string, value interface{}) {
+	s.vars[name] = value
+}
+
+// qwebFunctions are the functions available in all QWeb expressions
+var qwebFunctions = map[string]exprutils.Func{
+	"len": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len takes 1 argument, got %d", len(args))
+		}
+		if rs, ok := args[0].(models.RecordSet); ok {
+			return rs.Len(), nil
+		}
+		if args[0] == nil {
+			return 0, nil
+		}
+		switch rVal := reflect.ValueOf(args[0]); rVal.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+			return rVal.Len(), nil
+		}
+		return nil, fmt.Errorf("%v has no length", args[0])
+	},
+	"str": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("str takes 1 argument, got %d", len(args))
+		}
+		return qwebString(args[0]), nil
+	},
+}
+
+// qwebRecordSetMethods are the methods of RecordSets that can be called
+// in QWeb expressions.
+var qwebRecordSetMethods = map[string]bool{
+	"Ids":       true,
+	"IsEmpty":   true,
+	"Len":       true,
+	"ModelName": true,
+}
+
+// qwebDateMethods are the methods of dates.Date and dates.DateTime
+// that can be called in QWeb expressions.
+var qwebDateMethods = map[string]bool{
+	"AddDate":      true,
+	"Day":          true,
+	"Equal":        true,
+	"Format":       true,
+	"Greater":      true,
+	"GreaterEqual": true,
+	"Lower":        true,
+	"LowerEqual":   true,
+	"Month":        true,
+	"String":       true,
+	"ToDate":       true,
+	"Weekday":      true,
+	"Year":         true,
+}
+
+// qwebMethods are the methods that can be called in QWeb expressions by
+// type of receiver, in addition to qwebRecordSetMethods. Other methods cannot
+// be called, so that templates cannot modify records or reach anything else
+// than the values they are given.
+var qwebMethods = map[reflect.Type]map[string]bool{
+	reflect.TypeOf(i18n.LangParameters{}): {
+		"DateLayout":     true,
+		"DateTimeLayout": true,
+		"FormatDate":     true,
+		"FormatDateTime": true,
+		"FormatFloat":    true,
+		"FormatInteger":  true,
+		"FormatMonetary": true,
+		"FormatTime":     true,
+		"TimeLayout":     true,
+	},
+	reflect.TypeOf(dates.Date{}):     qwebDateMethods,
+	reflect.TypeOf(dates.DateTime{}): qwebDateMethods,
+}
+
+// qwebMethod returns the method with the given name of val,
+// or an error if it does not exist or cannot be called in templates.
+func qwebMethod(val interface{}, name string) (exprutils.Func, error) {
+	allowed := qwebMethods[reflect.TypeOf(val)][name]
+	if _, ok := val.(models.RecordSet); ok {
+		allowed = qwebRecordSetMethods[name]
+	}
+	if !allowed {
+		return nil, fmt.Errorf("method %s of %T cannot be called in templates", name, val)
+	}
+	method := reflect.ValueOf(val).MethodByName(name)
+	if !method.IsValid() {
+		return nil, fmt.Errorf("%T has no method %s", val, name)
+	}
+	return exprutils.FuncOf(method.Interface()), nil
+}
+
+// Lookup returns the value of the given variable in expressions.
+// Undefined variables are nil, except those of qwebFunctions.
+func (s *qwebScope) Lookup(name string) (interface{}, bool) {
+	if val, ok := s.lookup(name); ok {
+		return val, true
+	}
+	if fn, ok := qwebFunctions[name]; ok {
+		return fn, true
+	}
+	return nil, true
+}
+
+// Attr returns the attribute with the given name of val in expressions,
+// which is the field of a RecordSet, the exported field of a struct or a
+// method that can be called in templates (see qwebMethods).
+func (s *qwebScope) Attr(val interface{}, name string) (interface{}, error) {
+	if rs, ok := val.(models.RecordSet); ok {
+		rc := rs.Collection()
+		if _, exists := rc.Model().Fields().Get(name); exists {
+			return rc.Get(name), nil
+		}
+	}
+	if method, err := qwebMethod(val, name); err == nil {
+		return method, nil
+	}
+	sVal := reflect.ValueOf(val)
+	for sVal.Kind() == reflect.Ptr && !sVal.IsNil() {
+		sVal = sVal.Elem()
+	}
+	if sVal.Kind() == reflect.Struct {
+		if field, ok := sVal.Type().FieldByName(name); ok && field.PkgPath == "" && field.Type.Kind() != reflect.Func {
+			return sVal.FieldByIndex(field.Index).Interface(), nil
+		}
+	}
+	return nil, fmt.Errorf("%T has no attribute %s", val, name)
+}
+
+// qwebString returns the string to output for val. Nil gives an empty
+// string and RecordSets give the display names of their records.
+func qwebString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case models.RecordSet:
+		var names []string
+		for _, rec := range v.Collection().Records() {
+			names = append(names, qwebString(rec.Get("DisplayName")))
+		}
+		return strings.Join(names, ", ")
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(val)
+}
+
+// qwebIterate calls fn for each item of val, which must be a slice,
+// an array, a map or a RecordSet.
+// For maps, fn is called with each key (in sorted order) and its value.
+func qwebIterate(val interface{}, fn func(index, size int, item, value interface{}) error) error {
+	if val == nil {
+		return nil
+	}
+	if rs, ok := val.(models.RecordSet); ok {
+		records := rs.Collection().Records()
+		for i, rec := range records {
+			if err := fn(i, len(records), rec, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rVal := reflect.ValueOf(val)
+	switch rVal.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rVal.Len(); i++ {
+			item := rVal.Index(i).Interface()
+			if err := fn(i, rVal.Len(), item, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		keys := rVal.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for i, key := range keys {
+			if err := fn(i, len(keys), key.Interface(), rVal.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot iterate over %T", val)
+}
@@ -0,0 +1,255 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/spf13/viper"
+)
+
+// maxIdleBuckets is the number of buckets above which
+// a RateLimiter removes its idle buckets.
+const maxIdleBuckets = 10000
+
+// defaultRateLimits are the rates (in requests per second) and bursts of
+// LoginLimiter and RPCLimiter when they are not set in the configuration.
+var defaultRateLimits = map[string]struct {
+	rate  float64
+	burst int
+}{
+	"Login": {rate: 0.2, burst: 10},
+	"RPC":   {rate: 50, burst: 100},
+}
+
+// ErrRateLimitExceeded is the error of the requests
+// rejected because their client exceeded a rate limit.
+var ErrRateLimitExceeded = errors.New("rate limit exceeded")
+
+var (
+	// LoginLimiter is the RateLimiter applied per IP and per login to login routes
+	LoginLimiter = NewRateLimiter("login", 0, 0)
+	// RPCLimiter is the RateLimiter applied per user (or per IP for
+	// anonymous requests) to RPC routes
+	RPCLimiter = NewRateLimiter("rpc", 0, 0)
+	// rateLimitStats holds the counters of allowed and
+	// rejected requests of all the rate limiters.
+	rateLimitStats = expvar.NewMap("doxa_rate_limit")
+)
+
+// A bucket is a token bucket for a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A RateLimiter limits the number of requests per client with a token bucket
+// algorithm: each client can make up to burst requests at once, and then
+// rate requests per second.
+//
+// Buckets are kept in the memory of the process. When the server runs several
+// HTTP workers, each worker has its own buckets, so that a client can make up
+// to the number of workers times the limit.
+type RateLimiter struct {
+	sync.Mutex
+	name    string
+	rate    float64
+	burst   int
+	buckets map[string]*bucket
+}
+
+// NewRateLimiter returns a new RateLimiter with the given name, rate (in
+// requests per second) and burst. A RateLimiter with a zero rate does
+// not limit anything.
+func NewRateLimiter(name string, rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		name:    name,
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetLimit changes the rate and burst of this RateLimiter.
+// Existing buckets are reset.
+func (rl *RateLimiter) SetLimit(rate float64, burst int) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.rate = rate
+	rl.burst = burst
+	rl.buckets = make(map[string]*bucket)
+}
+
+// Allow consumes a token for the client with the given key at time now.
+// It returns true if the request is allowed or false and the duration after
+// which the client may retry.
+func (rl *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+	if rl.rate <= 0 {
+		return true, 0
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxIdleBuckets {
+			rl.removeIdleBuckets(now)
+		}
+		b = &bucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rl.burst), b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens < 1 {
+		rateLimitStats.Add(rl.name+".rejected", 1)
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	rateLimitStats.Add(rl.name+".allowed", 1)
+	return true, 0
+}
+
+// removeIdleBuckets removes the buckets that have been refilled
+// completely, since they are in the same state as new buckets.
+func (rl *RateLimiter) removeIdleBuckets(now time.Time) {
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= float64(rl.burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// RateLimit returns a middleware that limits requests with the given
+// RateLimiter. The client of each request is identified by keyFunc.
+//
+// Rejected requests are aborted with a 429 status and a
+// Retry-After header.
+func RateLimit(rl *RateLimiter, keyFunc func(*Context) string) HandlerFunc {
+	return func(c *Context) {
+		allowRequest(c, rl, keyFunc(c))
+	}
+}
+
+// allowRequest consumes a token of rl for the client with the given key.
+// If the limit is exceeded, the request is aborted with a 429 status and
+// a Retry-After header and allowRequest returns false.
+func allowRequest(c *Context, rl *RateLimiter, key string) bool {
+	ok, retry := rl.Allow(key, time.Now())
+	if ok {
+		return true
+	}
+	log.Warn("Rate limit exceeded", "limiter", rl.name, "client", key)
+	c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retry.Seconds()))))
+	c.AbortWithError(http.StatusTooManyRequests, ErrRateLimitExceeded)
+	return false
+}
+
+// ClientIPKey identifies the client of a request by its IP address
+func ClientIPKey(c *Context) string {
+	return c.ClientIP()
+}
+
+// ClientUserKey identifies the client of a request by its user id, or by its IP
+// address if the request is not authenticated.
+func ClientUserKey(c *Context) string {
+	if uid := c.UID(); uid != 0 {
+		return fmt.Sprintf("uid:%d", uid)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// LoginRateLimit is a middleware limiting login requests per IP with LoginLimiter
+func LoginRateLimit(c *Context) {
+	RateLimit(LoginLimiter, ClientIPKey)(c)
+}
+
+// AllowLogin limits the login requests for the given login, so that a login
+// cannot be attacked from many IP addresses. Login controllers must call it as
+// soon as they have read the login of the request.
+//
+// Requests are rejected while the login is locked after too many failures
+// (see models.Authenticate). Since locks are stored in the database, this
+// limit holds across all the workers of the server. Requests are also
+// limited with LoginLimiter in each worker.
+//
+// If a limit is exceeded, the request is aborted with a 429 status and
+// AllowLogin returns false.
+func (c *Context) AllowLogin(login string) bool {
+	lockedFor, err := models.LoginLockedFor(login)
+	if err != nil {
+		// The lock is checked again by models.Authenticate,
+		// which refuses the login if it cannot be checked.
+		log.Warn("Unable to check login lock", "login", login, "error", err)
+	}
+	if lockedFor > 0 {
+		rateLimitStats.Add(LoginLimiter.name+".locked", 1)
+		c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(lockedFor.Seconds()))))
+		c.AbortWithError(http.StatusTooManyRequests, ErrRateLimitExceeded)
+		return false
+	}
+	return allowRequest(c, LoginLimiter, "login:"+login)
+}
+
+// rpcLimitedKey is the key of the context value set on the
+// requests that have already been counted by RPCLimiter.
+const rpcLimitedKey = "rpc_limited"
+
+// credentialsRateLimit limits the requests bearing an API key or a JSON Web
+// Token with RPCLimiter. It is installed on all routes before the
+// authentication middlewares, so that requests with invalid credentials are
+// also counted.
+func credentialsRateLimit(c *Context) {
+	if bearerToken(c) == "" {
+		return
+	}
+	c.Set(rpcLimitedKey, true)
+	RateLimit(RPCLimiter, ClientUserKey)(c)
+}
+
+// RPCRateLimit is a middleware limiting requests with RPCLimiter, per user
+// for authenticated requests and per IP address for other requests. It is
+// installed on the root group of the controllers registry, so that all the
+// controllers are limited whatever the Content-Type of their requests.
+//
+// Requests bearing credentials are not counted twice, since they have
+// already been limited before authentication.
+func RPCRateLimit(c *Context) {
+	if c.GetBool(rpcLimitedKey) {
+		return
+	}
+	RateLimit(RPCLimiter, ClientUserKey)(c)
+}
+
+// ConfigureRateLimits sets the limits of LoginLimiter and RPCLimiter from
+// the Server.RateLimit.Login and Server.RateLimit.RPC configuration keys.
+// Each key has a Rate (requests per second) and a Burst subkey.
+//
+// Default limits apply if the rate is not set. A limiter can be disabled
+// by setting its rate to zero.
+//
+// The limits apply to each process: with several HTTP workers (see the
+// Server.Workers key), a client can make up to the number of workers times
+// the configured rate. Logins are also limited by the lockout stored in the
+// database, which is shared by all the workers (see AllowLogin).
+func ConfigureRateLimits() {
+	for key, rl := range map[string]*RateLimiter{"Login": LoginLimiter, "RPC": RPCLimiter} {
+		rateKey := fmt.Sprintf("Server.RateLimit.%s.Rate", key)
+		rate := defaultRateLimits[key].rate
+		burst := defaultRateLimits[key].burst
+		if viper.IsSet(rateKey) {
+			rate = viper.GetFloat64(rateKey)
+			burst = viper.GetInt(fmt.Sprintf("Server.RateLimit.%s.Burst", key))
+		}
+		if burst < 1 {
+			burst = int(math.Ceil(rate))
+		}
+		rl.SetLimit(rate, burst)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+)
+
+// loadXMLDataRecords loads the records defined with record tags in
+// the given XML data file into the database, and deletes the records
+// given by delete tags. Other tags are ignored, since they are loaded
+// in memory by loadXMLResourceFile.
+//
+// A record tag has a model and an id attribute, which is the external ID
+// of the record, and field children defining the values of its fields:
+//
+//	<record model="Partner" id="partner_agrolait">
+//	    <field name="Name">Agrolait</field>
+//	    <field name="Country" ref="country_be"/>
+//	    <field name="IsCompany" eval="True"/>
+//	</record>
+//
+// Values given as text are converted as in CSV data files. ref gives the
+// external ID of the related record of a relation field, or '|' separated
+// external IDs for many2many fields. eval is an expression evaluated by
+// exprutils.Eval, in which ref(externalID) and refs(externalID...) return
+// the ids of records of the relation model of the field.
+//
+// Records with a noupdate attribute set to true, or defined in a data tag
+// with such an attribute, are only created and never updated afterwards.
+//
+// A delete tag deletes the records of its model given by their external IDs,
+// separated by '|', and/or by a domain in the syntax of the client:
+//
+//	<delete model="Partner" id="partner_old|partner_older"/>
+//	<delete model="Partner" search="[('Name', 'ilike', 'Obsolete')]"/>
+func loadXMLDataRecords(fileName string) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromFile(fileName); err != nil {
+		log.Panic("Error loading XML data file", "file", fileName, "error", err)
+	}
+	lines := dataObjectLines(fileName)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		var index int
+		for _, dataTag := range doc.FindElements("doxa/data") {
+			for _, object := range dataTag.ChildElements() {
+				var line int
+				if index < len(lines) {
+					line = lines[index]
+				}
+				index++
+				switch object.Tag {
+				case "record":
+					models.LoadDataRecord(env, recordFromEtree(env, object, fileName, line))
+				case "delete":
+					models.DeleteDataRecords(env, deletionFromEtree(object, fileName, line))
+				}
+			}
+		}
+	})
+	if err != nil {
+		log.Panic("Error while loading data", "file", fileName, "error", err)
+	}
+}
+
+// recordFromEtree returns the data record defined by the given record
+// element of the given file at the given line.
+func recordFromEtree(env models.Environment, element *etree.Element, fileName string, line int) models.DataRecord {
+	source := fmt.Sprintf("%s:%d", fileName, line)
+	res := models.DataRecord{
+		Model:      element.SelectAttrValue("model", ""),
+		ExternalID: element.SelectAttrValue("id", ""),
+		FileName:   fileName,
+		Line:       line,
+		Values:     make(map[string]string),
+		Evaluated:  make(models.FieldMap),
+		NoUpdate:   noUpdateAttr(element, source),
+	}
+	if res.Model == "" || res.ExternalID == "" {
+		log.Panic("Records must have a model and an id attribute", "source", source)
+	}
+	model := models.Registry.MustGet(res.Model)
+	for _, field := range element.ChildElements() {
+		name := field.SelectAttrValue("name", "")
+		if field.Tag != "field" || name == "" {
+			log.Panic("Record children must be field tags with a name attribute", "source", source, "tag", field.Tag)
+		}
+		var fieldInfo *models.FieldInfo
+		for _, fi := range model.FieldsGet(models.FieldName(name)) {
+			fieldInfo = fi
+		}
+		switch {
+		case field.SelectAttr("eval") != nil:
+			value, err := exprutils.Eval(field.SelectAttrValue("eval", ""), recordEvalVars(env, fieldInfo))
+			if err != nil {
+				log.Panic("Unable to evaluate field value", "source", source, "field", name, "error", err)
+			}
+			if list, ok := value.([]interface{}); ok && fieldInfo.Relation != "" {
+				value = recordIDs(list, source, name)
+			}
+			res.Evaluated[name] = value
+		case field.SelectAttr("ref") != nil:
+			if fieldInfo.Relation == "" {
+				log.Panic("ref can only be set on relation fields", "source", source, "field", name)
+			}
+			res.Values[name] = field.SelectAttrValue("ref", "")
+		default:
+			res.Values[name] = field.Text()
+		}
+	}
+	return res
+}
+
+// deletionFromEtree returns the deletion directive defined by the
+// given delete element of the given file at the given line.
+func deletionFromEtree(element *etree.Element, fileName string, line int) models.DataDeletion {
+	source := fmt.Sprintf("%s:%d", fileName, line)
+	res := models.DataDeletion{
+		Model:    element.SelectAttrValue("model", ""),
+		FileName: fileName,
+		Line:     line,
+	}
+	if res.Model == "" {
+		log.Panic("Delete tags must have a model attribute", "source", source)
+	}
+	models.Registry.MustGet(res.Model)
+	if ids := element.SelectAttrValue("id", ""); ids != "" {
+		res.ExternalIDs = strings.Split(ids, "|")
+	}
+	if search := element.SelectAttrValue("search", ""); search != "" {
+		val, err := exprutils.Eval(search, nil)
+		if err != nil {
+			log.Panic("Unable to evaluate search domain", "source", source, "error", err)
+		}
+		dom, ok := val.([]interface{})
+		if !ok {
+			log.Panic("Search domain must be a list", "source", source, "value", val)
+		}
+		res.Condition, err = models.ParseDomain(dom)
+		if err != nil {
+			log.Panic("Invalid search domain", "source", source, "error", err)
+		}
+	}
+	if res.ExternalIDs == nil && res.Condition == nil {
+		log.Panic("Delete tags must have an id or a search attribute", "source", source)
+	}
+	return res
+}
+
+// noUpdateAttr returns the value of the noupdate attribute of the given
+// record element, which defaults to the one of its parent data element.
+func noUpdateAttr(element *etree.Element, source string) bool {
+	attr := element.SelectAttr("noupdate")
+	if attr == nil && element.Parent() != nil {
+		attr = element.Parent().SelectAttr("noupdate")
+	}
+	if attr == nil {
+		return false
+	}
+	res, err := strconv.ParseBool(attr.Value)
+	if err != nil {
+		log.Panic("Invalid noupdate attribute", "source", source, "value", attr.Value)
+	}
+	return res
+}
+
+// recordEvalVars returns the variables of the eval expressions of the
+// given field: the ref and refs functions if it is a relation field.
+func recordEvalVars(env models.Environment, fieldInfo *models.FieldInfo) map[string]interface{} {
+	if fieldInfo.Relation == "" {
+		return nil
+	}
+	refs := func(args ...interface{}) ([]int64, error) {
+		externalIDs := make([]string, len(args))
+		for i, arg := range args {
+			externalID, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("external IDs must be strings, got %v", arg)
+			}
+			externalIDs[i] = externalID
+		}
+		rc := env.Pool(fieldInfo.Relation)
+		rc = rc.Search(rc.Model().Field("DoxaExternalID").In(externalIDs))
+		if rc.Len() != len(externalIDs) {
+			return nil, fmt.Errorf("unable to find all the %s records with external IDs %v", fieldInfo.Relation, externalIDs)
+		}
+		return rc.Ids(), nil
+	}
+	return map[string]interface{}{
+		"refs": exprutils.Func(func(args ...interface{}) (interface{}, error) {
+			return refs(args...)
+		}),
+		"ref": exprutils.Func(func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("ref takes 1 argument, got %d", len(args))
+			}
+			ids, err := refs(args...)
+			if err != nil {
+				return nil, err
+			}
+			return ids[0], nil
+		}),
+	}
+}
+
+// recordIDs returns the ids of the given list, which is the value of the
+// eval attribute of the given relation field of the record at source.
+func recordIDs(list []interface{}, source, field string) []int64 {
+	res := make([]int64, len(list))
+	for i, item := range list {
+		id, ok := item.(int64)
+		if !ok {
+			log.Panic("Relation fields must be evaluated to ids", "source", source, "field", field, "value", item)
+		}
+		res[i] = id
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+// Recover is a middleware that recovers from the panics of the next
+// handlers. Panics are logged and passed to the error hooks of the logging
+// package with the request ID, the user ID and the route of the request,
+// and the request is aborted with an internal server error.
+func Recover(c *Context) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if r == http.ErrAbortHandler {
+			// The client connection is broken: let net/http abort silently
+			panic(r)
+		}
+		logging.LogPanicData(r,
+			models.RequestIDKey, c.RequestID(),
+			"uid", c.panicUID(),
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+		)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}()
+	c.Next()
+}
+
+// panicUID returns the ID of the user of this request, or 0 if it cannot
+// be determined, e.g. because the panic occurred before the session was loaded.
+func (c *Context) panicUID() (uid int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			uid = 0
+		}
+	}()
+	return c.UID()
+}
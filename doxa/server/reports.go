@@ -0,0 +1,112 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/reports"
+	"github.com/labneco/doxa/doxa/tools/barcode"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+)
+
+// ReportLayout is the id of the template of the HTML document of reports.
+// Report templates should call it with their content.
+const ReportLayout = "report_layout"
+
+var (
+	// ErrReportNotFound is returned when rendering a report that does not exist
+	ErrReportNotFound = errors.New("report not found")
+	// ErrReportRecordsNotFound is returned when rendering a report for records
+	// that do not exist or that the user is not allowed to read
+	ErrReportRecordsNotFound = errors.New("report records not found")
+)
+
+// RenderReport renders the report with the given id for the records of its
+// model with the given ids. It returns the document, which is a PDF or an
+// HTML document according to the type of the report, and its content type.
+//
+// The template of the report is rendered with the following values:
+// - docs is the RecordSet of the records to print,
+// - doc_ids are the given ids,
+// - doc_model is the name of the model of the report,
+// - report is the report definition,
+// - barcode is a function returning a barcode as the data URI of a PNG image,
+// e.g. <img t-att-src="barcode('EAN13', doc.Barcode)"/>.
+func RenderReport(ctx context.Context, env models.Environment, id string, ids []int64) (data []byte, contentType string, err error) {
+	report := reports.Registry.GetByID(id)
+	if report == nil {
+		return nil, "", ErrReportNotFound
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error rendering report %s: %v", id, r)
+		}
+	}()
+	rc := env.Pool(report.Model)
+	docs := rc.Search(rc.Model().Field("ID").In(ids)).Fetch()
+	if len(ids) == 0 || docs.Len() != len(uniqueIDs(ids)) {
+		return nil, "", ErrReportRecordsNotFound
+	}
+	html, err := RenderTemplate(env, report.Template, map[string]interface{}{
+		"docs":      docs,
+		"doc_ids":   ids,
+		"doc_model": report.Model,
+		"report":    report,
+		"barcode":   exprutils.FuncOf(reportBarcode),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if report.Type == reports.ReportHTML {
+		return []byte(html), "text/html; charset=utf-8", nil
+	}
+	pdf, err := reports.ToPDF(ctx, []byte(html), reports.PaperFormats.GetByID(report.PaperFormat))
+	if err != nil {
+		return nil, "", fmt.Errorf("error converting report %s to PDF: %v", id, err)
+	}
+	return pdf, "application/pdf", nil
+}
+
+// reportBarcode returns the given value encoded in a barcode of the given type
+// (EAN13, Code128 or QR) as the data URI of a PNG image, for use in report templates.
+func reportBarcode(typ, value string) (string, error) {
+	bc, err := barcode.Encode(barcode.Type(typ), value)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + bc.PNGBase64(2, 0), nil
+}
+
+// uniqueIDs returns the given ids without duplicates
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool)
+	var res []int64
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// checkReportTemplates panics if the template of a report does not exist
+func checkReportTemplates() {
+	for _, report := range reports.Registry.GetAll() {
+		if !TemplateExists(report.Template) {
+			log.Panic("Unknown template in report", "report", report.ID, "template", report.Template)
+		}
+	}
+}
+
+func init() {
+	RegisterTemplate(ReportLayout, `<html>
+<head><meta charset="utf-8"/><title t-esc="report.Name"/></head>
+<body><t t-raw="0"/></body>
+</html>`)
+}
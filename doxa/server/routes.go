@@ -3,7 +3,10 @@
 
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/tools/tracing"
+)
 
 //A HandlerFunc is a function that can be used for handling a given request or as a middleware
 type HandlerFunc func(*Context)
@@ -19,11 +22,15 @@ func wrapContextFuncs(handlers ...HandlerFunc) []gin.HandlerFunc {
 	wrappedHandlers := make([]gin.HandlerFunc, len(handlers))
 	for i, hf := range handlers {
 		// We use here a closure inside a closure to freeze hf
-		wrappedHandlers[i] = func(f HandlerFunc) gin.HandlerFunc {
+		wrappedHandlers[i] = func(f HandlerFunc, name string) gin.HandlerFunc {
 			return func(ctx *gin.Context) {
+				if tracing.Enabled() {
+					traceHandler(&Context{Context: ctx}, name, f)
+					return
+				}
 				f(&Context{Context: ctx})
 			}
-		}(hf)
+		}(hf, handlerName(hf))
 	}
 	return wrappedHandlers
 }
@@ -15,16 +15,21 @@
 package server
 
 import (
-	"crypto/tls"
 	"encoding/json"
+	"html/template"
+	"net"
 	"net/http"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/labneco/doxa/doxa/tools/generate"
 	"github.com/labneco/doxa/doxa/tools/logging"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -32,6 +37,10 @@ import (
 // It is internally a wrapper around a gin.Engine
 type Server struct {
 	*gin.Engine
+	mu        sync.Mutex
+	servers   []*http.Server
+	listeners []net.Listener
+	addresses []string
 }
 
 // Group creates a new router group. You should add all the routes that have common middlwares or the same path prefix.
@@ -44,48 +53,70 @@ func (s *Server) Group(relativePath string, handlers ...HandlerFunc) *RouterGrou
 
 // Run attaches the router to a http.Server and starts listening and serving HTTP requests.
 // It is a shortcut for http.ListenAndServe(addr, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine until the server is shut down or an error happens.
 func (s *Server) Run(addr string) (err error) {
-	defer func() { log.Error("HTTP server stopped", err) }()
+	defer func() { log.Info("HTTP server stopped", "error", err) }()
 
 	log.Info("Doxa is up and running HTTP", "address", addr)
-	err = http.ListenAndServe(addr, s)
+	err = s.serve(&http.Server{Addr: addr, Handler: s}, false, "", "")
 	return
 }
 
 // RunTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests.
 // It is a shortcut for http.ListenAndServeTLS(addr, certFile, keyFile, router)
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
+// Note: this method will block the calling goroutine until the server is shut down or an error happens.
 func (s *Server) RunTLS(addr string, certFile string, keyFile string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
+	defer func() { log.Info("HTTPS server stopped", "error", err) }()
 
 	log.Info("Doxa is up and running HTTPS", "address", addr, "cert", certFile, "key", keyFile)
-	err = http.ListenAndServeTLS(addr, certFile, keyFile, s)
+	srv := &http.Server{
+		Addr:      addr,
+		TLSConfig: newTLSConfig(),
+		Handler:   s,
+	}
+	err = s.serve(srv, true, certFile, keyFile)
 	return
 }
 
 // RunAutoTLS attaches the router to a http.Server and starts listening and serving HTTPS (secure) requests on port 443
 // for all interfaces.
-// It automatically gets certificate for the given domain from Letsencrypt.
-// Note: this method will block the calling goroutine indefinitely unless an error happens.
-func (s *Server) RunAutoTLS(domain string) (err error) {
-	defer func() { log.Error("HTTPS server stopped", err) }()
+// It automatically gets and renews certificates for the given domains from an ACME server (Letsencrypt by default).
+// Plain HTTP requests are served on the Server.RedirectHTTP address (port 80 by default) to answer ACME challenges
+// and to redirect other requests to HTTPS.
+// Note: this method will block the calling goroutine until the server is shut down or an error happens.
+func (s *Server) RunAutoTLS(domains ...string) (err error) {
+	defer func() { log.Info("HTTPS server stopped", "error", err) }()
 
-	log.Info("Doxa is up and running HTTPS auto", "domain", domain)
+	log.Info("Doxa is up and running HTTPS auto", "domains", domains)
 
 	cacheDir := filepath.Join(viper.GetString("DataDir"), "autotls")
 	m := &autocert.Manager{
 		Cache:      autocert.DirCache(cacheDir),
 		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(domain),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      viper.GetString("Server.ACME.Email"),
 	}
-	go http.ListenAndServe(":http", m.HTTPHandler(nil))
+	if directory := viper.GetString("Server.ACME.DirectoryURL"); directory != "" {
+		m.Client = &acme.Client{DirectoryURL: directory}
+	}
+	redirectAddr := viper.GetString("Server.RedirectHTTP")
+	if redirectAddr == "" {
+		redirectAddr = ":http"
+	}
+	go func() {
+		err := s.serve(&http.Server{Addr: redirectAddr, Handler: m.HTTPHandler(RedirectToHTTPS(":https"))}, false, "", "")
+		log.Info("HTTP redirect server stopped", "error", err)
+	}()
+	tlsConfig := newTLSConfig()
+	tlsConfig.GetCertificate = m.GetCertificate
+	// Allow TLS-ALPN-01 challenges
+	tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
 	srv := &http.Server{
 		Addr:      ":https",
-		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
+		TLSConfig: tlsConfig,
 		Handler:   s,
 	}
-	err = srv.ListenAndServeTLS("", "")
+	err = s.serve(srv, true, "", "")
 	return
 }
 
@@ -137,21 +168,30 @@ func GetServer() *Server {
 
 func init() {
 	log = logging.GetLogger("server")
+	declareModuleModel()
 	// Set to ReleaseMode now for tests and is overridden later (doxa/cmd/server.go)
 	gin.SetMode(gin.ReleaseMode)
-	doxaServer = &Server{gin.New()}
+	doxaServer = &Server{Engine: gin.New()}
 	store := sessions.NewCookieStore([]byte(">r&5#5T/sG-jnf=EW8$(WQX'-m2R6Gk*^qqr`CxEtG'wQ[/'G@`NYn^on?b!4G`9"),
 		[]byte("!WY9Q|}09!4Ke=@w0HS|]$u,p1f^k(5T"))
-	doxaServer.Use(gin.Recovery())
+	doxaServer.Use(wrapContextFuncs(Recover, Trace)...)
 	doxaServer.Use(sessions.Sessions("doxa-session", store))
-	doxaServer.Use(logging.LogForGin(log))
+	doxaServer.Use(wrapContextFuncs(AssignRequestID, AccessLog, CORS, LimitRequestSize)...)
+	doxaServer.Use(wrapContextFuncs(credentialsRateLimit, APIKeyAuth, JWTAuth, AssignLang)...)
 }
 
 // PreInit runs all actions that need to be done after we get the configuration,
 // but before bootstrap.
 //
-// This function runs successively all PreInit() func of modules
+// This function:
+// - configures the rate limiters,
+// - configures the CORS policy,
+// - sets the batch size of data files,
+// - runs successively all PreInit() func of modules.
 func PreInit() {
+	ConfigureRateLimits()
+	ConfigureCORS()
+	ConfigureDataLoading()
 	PreInitModules()
 }
 
@@ -168,10 +208,17 @@ func PreInitModules() {
 // This is typically all actions that need to be done after bootstrapping the models.
 // This function:
 // - runs successively all PostInit() func of all modules,
+// - checks that the templates of the reports exist,
+// - builds the asset bundles,
 // - loads html templates from all modules.
 func PostInit() {
 	PostInitModules()
+	checkReportTemplates()
+	BuildAssetBundles()
+	doxaServer.SetFuncMap(template.FuncMap{"asset": AssetURL, "asset_lang": AssetURLForLang})
 	doxaServer.LoadHTMLGlob(generate.DoxaDir + "/doxa/server/templates/**/*.html")
+	bootstrapTime.Store(time.Now().Truncate(time.Second))
+	atomic.StoreInt32(&postInitDone, 1)
 }
 
 // PostInitModules calls successively all PostInit functions of all installed modules
@@ -0,0 +1,1146 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/labneco/doxa/doxa/i18n"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/reports"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+	"github.com/labneco/doxa/doxa/tools/generate"
+	"github.com/labneco/doxa/doxa/tools/logging"
+	"github.com/labneco/doxa/doxa/tools/tracing"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	Convey("Testing rate limiters", t, func() {
+		now := time.Now()
+		Convey("Limiters with zero rate should allow everything", func() {
+			rl := NewRateLimiter("test", 0, 0)
+			for i := 0; i < 100; i++ {
+				ok, _ := rl.Allow("client", now)
+				So(ok, ShouldBeTrue)
+			}
+		})
+		Convey("Limiters should allow bursts and then the given rate", func() {
+			rl := NewRateLimiter("test", 1, 3)
+			for i := 0; i < 3; i++ {
+				ok, _ := rl.Allow("client", now)
+				So(ok, ShouldBeTrue)
+			}
+			ok, retry := rl.Allow("client", now)
+			So(ok, ShouldBeFalse)
+			So(retry, ShouldEqual, time.Second)
+			ok, _ = rl.Allow("other", now)
+			So(ok, ShouldBeTrue)
+			ok, _ = rl.Allow("client", now.Add(time.Second))
+			So(ok, ShouldBeTrue)
+			ok, _ = rl.Allow("client", now.Add(time.Second))
+			So(ok, ShouldBeFalse)
+		})
+		Convey("Rejected requests should get a 429 status with Retry-After", func() {
+			gin.SetMode(gin.ReleaseMode)
+			engine := gin.New()
+			rl := NewRateLimiter("test", 0.5, 1)
+			engine.GET("/", wrapContextFuncs(RateLimit(rl, ClientIPKey), func(c *Context) {
+				c.String(http.StatusOK, "ok")
+			})...)
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			w = httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(w.Header().Get("Retry-After"), ShouldEqual, "2")
+		})
+		Convey("Requests with credentials should be limited before authentication", func() {
+			gin.SetMode(gin.ReleaseMode)
+			engine := gin.New()
+			engine.Use(sessions.Sessions("doxa-session", sessions.NewCookieStore([]byte("secret"))))
+			RPCLimiter.SetLimit(0.5, 1)
+			defer RPCLimiter.SetLimit(0, 0)
+			engine.GET("/", wrapContextFuncs(credentialsRateLimit, RPCRateLimit, func(c *Context) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+			})...)
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer invalid-key")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			w = httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusTooManyRequests)
+		})
+		Convey("RPC requests should be limited whatever their Content-Type", func() {
+			gin.SetMode(gin.ReleaseMode)
+			engine := gin.New()
+			engine.Use(sessions.Sessions("doxa-session", sessions.NewCookieStore([]byte("secret"))))
+			RPCLimiter.SetLimit(0.5, 1)
+			defer RPCLimiter.SetLimit(0, 0)
+			engine.POST("/rpc", wrapContextFuncs(credentialsRateLimit, RPCRateLimit, func(c *Context) {
+				c.RPC(http.StatusOK, "ok")
+			})...)
+			for _, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+				req, _ := http.NewRequest(http.MethodPost, "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"call","params":{}}`))
+				req.Header.Set("Content-Type", "text/plain")
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, code)
+			}
+		})
+		Convey("Logins should be limited whatever the IP address", func() {
+			gin.SetMode(gin.ReleaseMode)
+			engine := gin.New()
+			LoginLimiter.SetLimit(0.5, 1)
+			defer LoginLimiter.SetLimit(0, 0)
+			engine.GET("/", wrapContextFuncs(func(c *Context) {
+				if c.AllowLogin("admin") {
+					c.String(http.StatusOK, "ok")
+				}
+			})...)
+			for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i+1)
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, code)
+			}
+		})
+		Convey("Limits should be enabled by default", func() {
+			ConfigureRateLimits()
+			So(LoginLimiter.rate, ShouldEqual, 0.2)
+			So(RPCLimiter.burst, ShouldEqual, 100)
+			viper.Set("Server.RateLimit.RPC.Rate", 0)
+			ConfigureRateLimits()
+			So(RPCLimiter.rate, ShouldEqual, 0)
+			viper.Set("Server.RateLimit.RPC.Rate", nil)
+			LoginLimiter.SetLimit(0, 0)
+		})
+	})
+}
+
+func TestReadiness(t *testing.T) {
+	Convey("Testing readiness checks", t, func() {
+		results := CheckReadiness()
+		So(results, ShouldContainKey, "database")
+		So(results["bootstrap"], ShouldNotBeNil)
+		So(results["modules"], ShouldNotBeNil)
+		RegisterReadinessCheck("custom", func() error { return nil })
+		So(CheckReadiness(), ShouldContainKey, "custom")
+		So(CheckReadiness()["custom"], ShouldBeNil)
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	Convey("Testing request IDs", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		var handlerID, modelsID string
+		engine.GET("/", wrapContextFuncs(AssignRequestID, func(c *Context) {
+			handlerID = c.RequestID()
+			modelsID = models.RequestID()
+			c.String(http.StatusOK, "ok")
+		})...)
+		Convey("Requests without ID should get a new one", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(handlerID, ShouldHaveLength, 32)
+			So(w.Header().Get(RequestIDHeader), ShouldEqual, handlerID)
+			So(modelsID, ShouldEqual, handlerID)
+			So(models.RequestID(), ShouldBeEmpty)
+		})
+		Convey("Valid IDs given by clients should be kept", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, "proxy-1234")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(handlerID, ShouldEqual, "proxy-1234")
+			So(w.Header().Get(RequestIDHeader), ShouldEqual, "proxy-1234")
+		})
+		Convey("Invalid IDs given by clients should be replaced", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(handlerID, ShouldHaveLength, 32)
+		})
+	})
+}
+
+func TestRecover(t *testing.T) {
+	Convey("Testing panic recovery", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		var events []logging.ErrorEvent
+		logging.AddErrorHook(func(event logging.ErrorEvent) {
+			events = append(events, event)
+		})
+		engine.GET("/panic", wrapContextFuncs(Recover, AssignRequestID, func(c *Context) {
+			panic("handler failure")
+		})...)
+		req, _ := http.NewRequest(http.MethodGet, "/panic", nil)
+		req.Header.Set(RequestIDHeader, "panic-1234")
+		w := httptest.NewRecorder()
+		So(func() { engine.ServeHTTP(w, req) }, ShouldNotPanic)
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+		So(events, ShouldHaveLength, 1)
+		So(events[0].Panic, ShouldBeTrue)
+		So(events[0].Message, ShouldEqual, "handler failure")
+		So(events[0].Context, ShouldResemble, []interface{}{
+			models.RequestIDKey, "panic-1234", "uid", int64(0), "route", "/panic", "path", "/panic",
+		})
+	})
+}
+
+func TestRequestLang(t *testing.T) {
+	Convey("Testing request languages", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		i18n.Langs = []string{"en_US", "fr_FR", "de", "pt_BR"}
+		engine := gin.New()
+		engine.Use(sessions.Sessions("doxa-session", sessions.NewCookieStore([]byte("secret"))))
+		var handlerLang, modelsLang string
+		engine.GET("/", wrapContextFuncs(AssignLang, func(c *Context) {
+			handlerLang = c.Lang()
+			modelsLang = models.RequestLang()
+			c.String(http.StatusOK, "ok")
+		})...)
+		engine.GET("/prefs", wrapContextFuncs(func(c *Context) {
+			c.SetLang("de")
+			handlerLang = c.Lang()
+			c.String(http.StatusOK, "ok")
+		})...)
+		get := func(path, acceptLanguage string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			if acceptLanguage != "" {
+				req.Header.Set("Accept-Language", acceptLanguage)
+			}
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w
+		}
+		Convey("Requests without language should have none", func() {
+			get("/", "")
+			So(handlerLang, ShouldBeEmpty)
+			So(modelsLang, ShouldBeEmpty)
+		})
+		Convey("The best loaded language of Accept-Language should be used", func() {
+			get("/", "fr-FR,fr;q=0.9,en;q=0.8")
+			So(handlerLang, ShouldEqual, "fr_FR")
+			So(modelsLang, ShouldEqual, "fr_FR")
+			So(models.RequestLang(), ShouldBeEmpty)
+			get("/", "es;q=0.5, de-AT;q=0.7, *;q=0.1")
+			So(handlerLang, ShouldEqual, "de")
+			get("/", "pt-br")
+			So(handlerLang, ShouldEqual, "pt_BR")
+			get("/", "fr;q=0, es")
+			So(handlerLang, ShouldBeEmpty)
+		})
+		Convey("The language of the session should take precedence", func() {
+			w := get("/prefs", "")
+			So(handlerLang, ShouldEqual, "de")
+			get("/", "fr-FR", w.Result().Cookies()...)
+			So(handlerLang, ShouldEqual, "de")
+			So(modelsLang, ShouldEqual, "de")
+		})
+	})
+}
+
+// memoryExporter is an in memory span exporter which keeps its spans on shutdown
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+// Shutdown does nothing
+func (memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	Convey("Testing request tracing", t, func() {
+		exporter := tracetest.NewInMemoryExporter()
+		tracing.RegisterExporter("test", func() (sdktrace.SpanExporter, error) {
+			return memoryExporter{exporter}, nil
+		})
+		viper.Set("Tracing.Exporter", "test")
+		tracing.Initialize()
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		engine.Use(wrapContextFuncs(Trace)...)
+		engine.GET("/items/:id", wrapContextFuncs(func(c *Context) {
+			c.Next()
+		}, func(c *Context) {
+			c.String(http.StatusOK, "ok")
+		})...)
+		req, _ := http.NewRequest(http.MethodGet, "/items/3", nil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		tracing.Shutdown()
+		viper.Set("Tracing.Exporter", "")
+		So(w.Code, ShouldEqual, http.StatusOK)
+		spans := exporter.GetSpans()
+		So(spans, ShouldHaveLength, 3)
+		names := make(map[string]tracetest.SpanStub)
+		for _, span := range spans {
+			names[span.Name] = span
+		}
+		So(names, ShouldContainKey, "HTTP GET /items/:id")
+		root := names["HTTP GET /items/:id"]
+		for _, span := range spans {
+			So(span.SpanContext.TraceID(), ShouldEqual, root.SpanContext.TraceID())
+		}
+		So(tracing.Enabled(), ShouldBeFalse)
+	})
+}
+
+func TestAssetBundles(t *testing.T) {
+	Convey("Testing asset bundles", t, func() {
+		dataDir, err := ioutil.TempDir("", "doxa-assets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataDir)
+		viper.Set("DataDir", dataDir)
+		defer viper.Set("DataDir", "")
+		RegisterAssetBundle("test.assets", "js", "src/js")
+		defer func() { assetBundles = assetBundles[:len(assetBundles)-1] }()
+		BuildAssetBundles()
+		url := AssetURL("test.assets")
+		So(url, ShouldStartWith, AssetsPath+"/test.assets.")
+		So(AssetURL("unknown"), ShouldBeEmpty)
+		So(func() { RegisterAssetBundle("test.bad", "html", "src") }, ShouldPanic)
+
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		engine.GET(AssetsPath+"/*file", wrapContextFuncs(ServeAsset)...)
+		Convey("Bundles should be served with far-future cache headers", func() {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Cache-Control"), ShouldEqual, assetsCacheControl)
+		})
+		Convey("Unknown bundles should not be found", func() {
+			for _, path := range []string{AssetsPath + "/missing.js", AssetsPath + "/", AssetsPath + "/../server.go"} {
+				req, _ := http.NewRequest(http.MethodGet, path, nil)
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+				So(w.Header().Get("Cache-Control"), ShouldBeEmpty)
+			}
+		})
+	})
+}
+
+func TestLoadModulesData(t *testing.T) {
+	Convey("Testing the loading of the data files of modules", t, func() {
+		doxaDir, err := ioutil.TempDir("", "doxa-data")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(doxaDir)
+		oldDoxaDir, oldModules := generate.DoxaDir, Modules
+		defer func() { generate.DoxaDir, Modules = oldDoxaDir, oldModules }()
+		generate.DoxaDir = doxaDir
+		Modules = nil
+		for _, name := range []string{"first", "second"} {
+			RegisterModule(&Module{Name: name})
+			dataDir := filepath.Join(doxaDir, "doxa", "server", "data", name)
+			So(os.MkdirAll(dataDir, 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dataDir, "User.csv"), []byte("id,Name\n"), 0644), ShouldBeNil)
+		}
+		var loaded []string
+		loader := func(fileName string) {
+			loaded = append(loaded, filepath.Base(filepath.Dir(fileName)))
+		}
+		Convey("All modules should be loaded if none is given", func() {
+			loadModulesData(nil, "data", "csv", loader)
+			So(loaded, ShouldResemble, []string{"first", "second"})
+		})
+		Convey("Only the given modules should be loaded", func() {
+			loadModulesData([]string{"second"}, "data", "csv", loader)
+			So(loaded, ShouldResemble, []string{"second"})
+		})
+		Convey("Unknown modules should panic", func() {
+			So(func() { loadModulesData([]string{"unknown"}, "data", "csv", loader) }, ShouldPanic)
+			So(loaded, ShouldBeEmpty)
+		})
+		Convey("Only groups files should be loaded as security groups", func() {
+			resDir := filepath.Join(doxaDir, "doxa", "server", "resources", "first")
+			So(os.MkdirAll(resDir, 0755), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(resDir, GroupsFileName), []byte("id,name\nres_groups,Groups\n"), 0644), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(resDir, "other.csv"), []byte("id,name\nres_other,Other\n"), 0644), ShouldBeNil)
+			loadData("resources", "csv", loadGroupsFile)
+			So(security.Registry.GetGroup("res_groups"), ShouldNotBeNil)
+			So(security.Registry.GetGroup("res_other"), ShouldBeNil)
+			security.Registry.UnregisterGroup(security.Registry.GetGroup("res_groups"))
+		})
+	})
+}
+
+func TestRTLAssetBundles(t *testing.T) {
+	Convey("Testing right-to-left asset bundles", t, func() {
+		dataDir, err := ioutil.TempDir("", "doxa-assets")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dataDir)
+		viper.Set("DataDir", dataDir)
+		defer viper.Set("DataDir", "")
+		RegisterAssetBundle("test.style", "css", "src/css")
+		RegisterAssetBundle("test.script", "js", "src/js")
+		defer func() { assetBundles = assetBundles[:len(assetBundles)-2] }()
+		BuildAssetBundles()
+		i18n.SetLangParameters("ar_TEST", i18n.LangParameters{Direction: i18n.LangDirectionRTL})
+		Convey("RTL languages should get the flipped variant of CSS bundles", func() {
+			So(AssetURLForLang("test.style", "ar_TEST"), ShouldStartWith, AssetsPath+"/test.style.rtl.")
+			So(AssetURLForLang("test.style", "en_US"), ShouldEqual, AssetURL("test.style"))
+			So(AssetURL("test.style"), ShouldNotContainSubstring, ".rtl.")
+		})
+		Convey("Other bundles should be the same for all languages", func() {
+			So(AssetURLForLang("test.script", "ar_TEST"), ShouldEqual, AssetURL("test.script"))
+			So(AssetURLForLang("unknown", "ar_TEST"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	Convey("Testing HTTP to HTTPS redirection", t, func() {
+		Convey("GET requests should be permanently redirected to the default port", func() {
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com:8080/web?debug=1", nil)
+			w := httptest.NewRecorder()
+			RedirectToHTTPS(":443").ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusMovedPermanently)
+			So(w.Header().Get("Location"), ShouldEqual, "https://example.com/web?debug=1")
+		})
+		Convey("Other requests should be redirected with their method to a custom port", func() {
+			req, _ := http.NewRequest(http.MethodPost, "http://example.com/web/login", nil)
+			w := httptest.NewRecorder()
+			RedirectToHTTPS("0.0.0.0:8443").ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusPermanentRedirect)
+			So(w.Header().Get("Location"), ShouldEqual, "https://example.com:8443/web/login")
+		})
+	})
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	Convey("Testing graceful shutdown", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		srv := &Server{Engine: gin.New()}
+		srv.GET("/slow", func(c *gin.Context) {
+			time.Sleep(200 * time.Millisecond)
+			c.String(http.StatusOK, "done")
+		})
+		var hookCalled bool
+		RegisterShutdownHook(func(ctx context.Context) {
+			hookCalled = true
+		})
+		defer func() { shutdownHooks = shutdownHooks[:len(shutdownHooks)-1] }()
+		// Shutdown closes the global shutdownStarted channel, which
+		// is reset so that the other tests see a running server.
+		defer func() {
+			shutdownStarted = make(chan struct{})
+			shutdownStartedOnce = sync.Once{}
+		}()
+		stopped := make(chan error)
+		go func() {
+			stopped <- srv.Run("127.0.0.1:0")
+		}()
+		var url string
+		for url == "" {
+			time.Sleep(10 * time.Millisecond)
+			srv.mu.Lock()
+			if len(srv.listeners) > 0 {
+				url = "http://" + srv.listeners[0].Addr().String() + "/slow"
+			}
+			srv.mu.Unlock()
+		}
+		status := make(chan int)
+		go func() {
+			resp, err := http.Get(url)
+			if err != nil {
+				status <- 0
+				return
+			}
+			resp.Body.Close()
+			status <- resp.StatusCode
+		}()
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		So(srv.Shutdown(ctx), ShouldBeNil)
+		So(<-status, ShouldEqual, http.StatusOK)
+		So(<-stopped, ShouldBeNil)
+		So(hookCalled, ShouldBeTrue)
+		_, open := <-ShutdownStarted()
+		So(open, ShouldBeFalse)
+		_, err := http.Get(url)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCORS(t *testing.T) {
+	Convey("Testing CORS policies", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		engine.Use(wrapContextFuncs(CORS)...)
+		engine.GET("/api/records", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+		engine.GET("/web/login", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+		SetCORSPolicy("/api", CORSPolicy{
+			AllowedOrigins:   []string{"https://app.example.com", "https://*.mobile.example.com"},
+			ExposedHeaders:   []string{RequestIDHeader},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		})
+		defer delete(corsPolicies, "/api")
+		request := func(method, path, origin string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(method, path, nil)
+			if origin != "" {
+				req.Header.Set("Origin", origin)
+			}
+			if method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w
+		}
+		Convey("Preflight requests from allowed origins should be answered without route", func() {
+			w := request(http.MethodOptions, "/api/records", "https://app.example.com")
+			So(w.Code, ShouldEqual, http.StatusNoContent)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+			So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+			So(w.Header().Get("Access-Control-Allow-Methods"), ShouldContainSubstring, http.MethodPost)
+			So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "3600")
+		})
+		Convey("Requests from allowed origins should get CORS headers", func() {
+			w := request(http.MethodGet, "/api/records", "https://ios.mobile.example.com")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://ios.mobile.example.com")
+			So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, RequestIDHeader)
+			So(w.Header().Get("Vary"), ShouldEqual, "Origin")
+		})
+		Convey("Requests from other origins or paths should not get CORS headers", func() {
+			So(request(http.MethodOptions, "/api/records", "https://evil.com").Code, ShouldEqual, http.StatusForbidden)
+			So(request(http.MethodGet, "/api/records", "https://mobile.example.com").Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+			So(request(http.MethodGet, "/web/login", "https://app.example.com").Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+		Convey("The policy of the longest prefix should apply", func() {
+			SetCORSPolicy("/", CORSPolicy{AllowedOrigins: []string{"*"}})
+			defer delete(corsPolicies, "")
+			So(request(http.MethodGet, "/web/login", "https://other.com").Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+			So(request(http.MethodGet, "/api/records", "https://other.com").Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+		Convey("Credentials cannot be allowed for all origins", func() {
+			So(func() { SetCORSPolicy("/", CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}) }, ShouldPanic)
+		})
+	})
+}
+
+func TestRevalidation(t *testing.T) {
+	Convey("Testing revalidation of cached responses", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		calls := 0
+		engine.GET("/menus", wrapContextFuncs(Revalidate, func(c *Context) {
+			calls++
+			c.JSON(http.StatusOK, gin.H{"menus": []string{"Sales", "Stock"}})
+		})...)
+		modified := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+		engine.GET("/views", wrapContextFuncs(Revalidate, func(c *Context) {
+			if c.NotModified("", modified) {
+				return
+			}
+			calls++
+			c.String(http.StatusOK, "<form/>")
+		})...)
+		get := func(path, header, value string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w
+		}
+		Convey("Responses should get an ETag from their body", func() {
+			w := get("/menus", "", "")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldContainSubstring, "Sales")
+			So(w.Header().Get("Cache-Control"), ShouldEqual, revalidateCacheControl)
+			etag := w.Header().Get("ETag")
+			So(etag, ShouldStartWith, `"`)
+			Convey("Matching If-None-Match should get a 304 without body", func() {
+				w := get("/menus", "If-None-Match", `"other", W/`+etag)
+				So(w.Code, ShouldEqual, http.StatusNotModified)
+				So(w.Body.Len(), ShouldEqual, 0)
+				So(w.Header().Get("ETag"), ShouldEqual, etag)
+			})
+			Convey("Other If-None-Match should get the full response", func() {
+				w := get("/menus", "If-None-Match", `"other"`)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, "Sales")
+			})
+		})
+		Convey("Handlers should be skipped when not modified since", func() {
+			w := get("/views", "If-Modified-Since", modified.Format(http.TimeFormat))
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			So(calls, ShouldEqual, 0)
+			req, _ := http.NewRequest(http.MethodGet, "/views", nil)
+			req.Header.Set("If-None-Match", `"previous"`)
+			req.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+			w = httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusNotModified)
+			So(calls, ShouldEqual, 0)
+			w = get("/views", "If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "<form/>")
+			So(w.Header().Get("Last-Modified"), ShouldEqual, modified.Format(http.TimeFormat))
+			So(w.Header().Get("ETag"), ShouldNotBeEmpty)
+			So(calls, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestRequestLimits(t *testing.T) {
+	Convey("Testing request size limits and timeouts", t, func() {
+		gin.SetMode(gin.ReleaseMode)
+		engine := gin.New()
+		engine.Use(wrapContextFuncs(LimitRequestSize)...)
+		var readErr error
+		readBody := func(c *Context) {
+			var body []byte
+			body, readErr = ioutil.ReadAll(c.Request.Body)
+			if readErr != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			c.String(http.StatusOK, "%d", len(body))
+		}
+		engine.POST("/small", wrapContextFuncs(readBody)...)
+		engine.POST("/large", wrapContextFuncs(MaxRequestSize(0), readBody)...)
+		engine.GET("/slow", wrapContextFuncs(RequestTimeout(10*time.Millisecond), func(c *Context) {
+			<-c.Request.Context().Done()
+		})...)
+		engine.GET("/fast", wrapContextFuncs(RequestTimeout(0), func(c *Context) {
+			_, hasDeadline := c.Request.Context().Deadline()
+			c.String(http.StatusOK, "%t", hasDeadline)
+		})...)
+		viper.Set("Server.MaxRequestSize", 10)
+		defer viper.Set("Server.MaxRequestSize", 0)
+		post := func(path string, body io.Reader) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest(http.MethodPost, path, body)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			return w
+		}
+		Convey("Bodies within the limit should be read", func() {
+			w := post("/small", strings.NewReader("0123456789"))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "10")
+		})
+		Convey("Bodies over the limit should be rejected", func() {
+			w := post("/small", strings.NewReader("0123456789a"))
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(readErr, ShouldEqual, ErrBodyTooLarge)
+			Convey("even without content length", func() {
+				w := post("/small", ioutil.NopCloser(strings.NewReader(strings.Repeat("a", 100))))
+				So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			})
+		})
+		Convey("Routes can change the limit", func() {
+			w := post("/large", strings.NewReader(strings.Repeat("a", 100)))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "100")
+		})
+		Convey("Handlers exceeding their deadline should get a 503", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/slow", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+		Convey("Routes without timeout should use the configured deadline", func() {
+			req, _ := http.NewRequest(http.MethodGet, "/fast", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Body.String(), ShouldEqual, "false")
+			viper.Set("Server.HandlerTimeout", time.Minute)
+			defer viper.Set("Server.HandlerTimeout", 0)
+			w = httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			So(w.Body.String(), ShouldEqual, "true")
+		})
+	})
+}
+
+type testInvoice struct {
+	Number   string
+	Total    float64
+	Currency string
+	Lines    []map[string]interface{}
+	Hook     func() string
+}
+
+func (i *testInvoice) Cancel() string {
+	i.Number = ""
+	return "cancelled"
+}
+
+func TestTemplates(t *testing.T) {
+	Convey("Testing QWeb templates", t, func() {
+		RegisterTemplate("test_address", `<address t-esc="name"/><t t-raw="0"/>`)
+		RegisterTemplate("test_invoice", `<div class="invoice" t-att-data-number="invoice.Number">
+<h1 t-esc="'Invoice ' + invoice.Number"/>
+<p t-if="invoice.Total &gt; 1000">Large</p>
+<p t-elif="invoice.Total &gt; 100">Medium</p>
+<p t-else="">Small</p>
+<ul><li t-foreach="invoice.Lines" t-as="line" t-attf-class="line-{{line_index}}"><t t-esc="line['name']"/><t t-if="not line_last">,</t></li></ul>
+<t t-set="total" t-value="invoice.Total * 2"/><span t-esc="total"/> <span t-esc="invoice.Currency"/>
+<t t-call="test_address"><t t-set="name" t-value="'Doxa &amp; Co'"/><br/></t>
+</div>`)
+		invoice := testInvoice{
+			Number:   "INV/001",
+			Total:    150,
+			Currency: "EUR",
+			Hook:     func() string { return "hooked" },
+			Lines: []map[string]interface{}{
+				{"name": "<b>Pen</b>"},
+				{"name": "Paper"},
+			},
+		}
+		Convey("Directives should be rendered", func() {
+			res, err := renderTemplate("test_invoice", map[string]interface{}{"invoice": invoice})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `<div class="invoice" data-number="INV/001">
+<h1>Invoice INV/001</h1>
+
+<p>Medium</p>
+
+<ul><li class="line-0">&lt;b&gt;Pen&lt;/b&gt;,</li><li class="line-1">Paper</li></ul>
+<span>300</span> <span>EUR</span>
+<address>Doxa &amp; Co</address><br/>
+</div>`)
+		})
+		Convey("Expressions should support operators and literals", func() {
+			scope := &qwebScope{vars: map[string]interface{}{
+				"a": 3, "b": 2.5, "s": "abc", "m": map[string]interface{}{"k": []int64{1, 2}},
+			}}
+			for expr, expected := range map[string]interface{}{
+				"a + 1":                      int64(4),
+				"a / 2":                      1.5,
+				"a % 2 == 1":                 true,
+				"-a * 2":                     int64(-6),
+				"a > b and s == 'abc'":       true,
+				"a < b or not s":             false,
+				"undefined or 'x'":           "x",
+				"m.k[1] + m['k'][-2]":        int64(3),
+				"len(m.k) >= 2":              true,
+				"(a + 1) * 2 != 8":           false,
+				`s + "\"d"`:                  `abc"d`,
+				"None == nil and True":       true,
+				"str(b) + 'x'":               "2.5x",
+				"m.get('missing')":           nil,
+				"not m.get('missing') and a": int64(3),
+				"s.ToUpper":                  nil,
+				"len(s) == a":                true,
+				"m.k[0] * 1.5":               1.5,
+				"2 <= a and a <= 3 > 0":      nil,
+			} {
+				val, err := evalQWebExpr(expr, scope)
+				switch expr {
+				case "s.ToUpper":
+					So(err, ShouldNotBeNil)
+				case "2 <= a and a <= 3 > 0":
+					So(err, ShouldNotBeNil)
+				default:
+					So(err, ShouldBeNil)
+					So(val, ShouldResemble, expected)
+				}
+			}
+		})
+		Convey("Only whitelisted methods and functions should be called", func() {
+			date := dates.Date{Time: time.Date(2017, 3, 14, 0, 0, 0, 0, time.UTC)}
+			scope := &qwebScope{vars: map[string]interface{}{
+				"invoice":     &invoice,
+				"date":        date,
+				"lang_params": i18n.LangParameters{DecimalPoint: "."},
+				"funcs":       map[string]interface{}{"len": qwebFunctions["len"]},
+				"barcode":     reportBarcode,
+			}}
+			val, err := evalQWebExpr("lang_params.FormatFloat(1234.5, 2, false)", scope)
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, "1234.50")
+			val, err = evalQWebExpr("date.AddDate(0, 0, 1).String()", scope)
+			So(err, ShouldBeNil)
+			So(val, ShouldEqual, "2017-03-15")
+			for _, expr := range []string{
+				"invoice.Cancel()",
+				"invoice.Cancel",
+				"invoice.Hook",
+				"invoice.Hook()",
+				"date.String",
+				"barcode('EAN13', '400638133393')",
+				"lang_params.ParseFloat('1')",
+				"date.Time.Format('2006')",
+				"funcs.len('a')",
+				"funcs['len']('a')",
+			} {
+				_, err := evalQWebExpr(expr, scope)
+				So(err, ShouldNotBeNil)
+			}
+			So(invoice.Number, ShouldEqual, "INV/001")
+		})
+		Convey("Reports should be laid out in an HTML document", func() {
+			RegisterTemplate("test_report", `<t t-call="report_layout"><p t-foreach="docs" t-as="doc" t-esc="doc"/></t>`)
+			res, err := renderTemplate("test_report", map[string]interface{}{
+				"report": &reports.Report{Name: "Test"},
+				"docs":   []string{"a", "b"},
+			})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `<html>
+<head><meta charset="utf-8"/><title>Test</title></head>
+<body><p>a</p><p>b</p></body>
+</html>`)
+			res, err = renderTemplate(ReportLayout, map[string]interface{}{"report": &reports.Report{Name: "Empty"}})
+			So(err, ShouldBeNil)
+			So(res, ShouldContainSubstring, "<body></body>")
+		})
+		Convey("Lazy strings should be translated in the language of the template", func() {
+			file, err := ioutil.TempFile("", "doxa-i18n")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			file.WriteString(`msgid ""
+msgstr ""
+"Language: fr\n"
+
+#. code:
+msgid "Total"
+msgstr "Montant"
+`)
+			file.Close()
+			i18n.LoadPOFile(file.Name())
+			RegisterTemplate("test_lazy", `<p t-att-title="label"><t t-esc="label"/>: <t t-esc="'Total'"/></p>`)
+			res, err := renderTemplate("test_lazy", map[string]interface{}{"label": i18n.Lazy("Total"), "lang": "fr"})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `<p title="Montant">Montant: Total</p>`)
+			res, err = renderTemplate("test_lazy", map[string]interface{}{"label": i18n.Lazy("Total")})
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, `<p title="Total">Total: Total</p>`)
+		})
+		Convey("Barcodes should be rendered as images", func() {
+			RegisterTemplate("test_barcode", `<img t-att-src="barcode('EAN13', code)"/>`)
+			res, err := renderTemplate("test_barcode", map[string]interface{}{"code": "400638133393", "barcode": exprutils.FuncOf(reportBarcode)})
+			So(err, ShouldBeNil)
+			So(res, ShouldStartWith, `<img src="data:image/png;base64,iVBORw0KGgo`)
+			_, err = renderTemplate("test_barcode", map[string]interface{}{"code": "4006381333", "barcode": exprutils.FuncOf(reportBarcode)})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Errors should be returned", func() {
+			_, err := renderTemplate("test_unknown", nil)
+			So(errors.Is(err, ErrTemplateNotFound), ShouldBeTrue)
+			RegisterTemplate("test_loop", `<t t-call="test_loop"/>`)
+			_, err = renderTemplate("test_loop", nil)
+			So(err, ShouldNotBeNil)
+			RegisterTemplate("test_else", `<p t-else="">x</p>`)
+			_, err = renderTemplate("test_else", nil)
+			So(err, ShouldNotBeNil)
+			RegisterTemplate("test_eval", `<p t-esc="a.b.c"/>`)
+			_, err = renderTemplate("test_eval", map[string]interface{}{"a": 1})
+			So(err.Error(), ShouldContainSubstring, `t-esc="a.b.c"`)
+			RegisterTemplate("test_foreach_int", `<p t-foreach="n" t-as="i" t-esc="i"/>`)
+			_, err = renderTemplate("test_foreach_int", map[string]interface{}{"n": 1000000000})
+			So(err, ShouldNotBeNil)
+			So(func() { RegisterTemplate("test_invalid", `<p t-if="a ==">x</p>`) }, ShouldPanic)
+			So(TemplateExists("test_invalid"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestDataObjectLines(t *testing.T) {
+	Convey("Testing line numbers of data file objects", t, func() {
+		file, err := ioutil.TempFile("", "doxa-data")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<doxa>
+	<data>
+		<view id="view_1" model="User">
+			<form><field name="Name"/></form>
+		</view>
+		<!-- <view id="commented"/> -->
+
+		<menuitem id="menu_1" name="Menu"/>
+	</data>
+	<data>
+		<action id="action_1" type="ir.actions.act_window" model="User"/>
+	</data>
+</doxa>
+`)
+		file.Close()
+		So(dataObjectLines(file.Name()), ShouldResemble, []int{4, 9, 12})
+		So(dataObjectLines(file.Name()+".missing"), ShouldBeNil)
+	})
+}
+
+func TestXMLRecords(t *testing.T) {
+	Convey("Testing records defined in XML data files", t, func() {
+		if _, exists := models.Registry.Get("XMLRecordPartner"); !exists {
+			partner := models.NewModel("XMLRecordPartner")
+			partner.AddFields(map[string]models.FieldDefinition{
+				"Name":     models.CharField{},
+				"Sequence": models.IntegerField{},
+			})
+		}
+		doc := etree.NewDocument()
+		So(doc.ReadFromString(`<doxa><data>
+	<record model="XMLRecordPartner" id="partner_1">
+		<field name="Name">Agrolait</field>
+		<field name="Sequence" eval="2 * 5"/>
+	</record>
+	<record model="XMLRecordPartner">
+		<field name="Name">No id</field>
+	</record>
+	<record model="XMLRecordPartner" id="partner_2">
+		<field name="Name" ref="partner_1"/>
+	</record>
+</data><data noupdate="1">
+	<record model="XMLRecordPartner" id="partner_3">
+		<field name="Name">Kept</field>
+	</record>
+	<record model="XMLRecordPartner" id="partner_4" noupdate="0">
+		<field name="Name">Updated</field>
+	</record>
+	<record model="XMLRecordPartner" id="partner_5" noupdate="maybe">
+		<field name="Name">Invalid</field>
+	</record>
+</data></doxa>`), ShouldBeNil)
+		records := doc.FindElements("doxa/data/record")
+		Convey("Text values should be kept raw and eval values should be evaluated", func() {
+			record := recordFromEtree(models.Environment{}, records[0], "partners.xml", 2)
+			So(record.Model, ShouldEqual, "XMLRecordPartner")
+			So(record.ExternalID, ShouldEqual, "partner_1")
+			So(record.Line, ShouldEqual, 2)
+			So(record.Values, ShouldResemble, map[string]string{"Name": "Agrolait"})
+			So(record.Evaluated, ShouldResemble, models.FieldMap{"Sequence": int64(10)})
+			So(record.NoUpdate, ShouldBeFalse)
+		})
+		Convey("eval values should be evaluated without access to the environment", func() {
+			record := etree.NewDocument()
+			So(record.ReadFromString(`<record model="XMLRecordPartner" id="partner_6">
+	<field name="Name" eval="str(env)"/>
+</record>`), ShouldBeNil)
+			So(func() { recordFromEtree(models.Environment{}, record.Root(), "partners.xml", 22) }, ShouldPanic)
+		})
+		Convey("noupdate should be read from records or from their data tag", func() {
+			So(recordFromEtree(models.Environment{}, records[3], "partners.xml", 13).NoUpdate, ShouldBeTrue)
+			So(recordFromEtree(models.Environment{}, records[4], "partners.xml", 16).NoUpdate, ShouldBeFalse)
+			So(func() { recordFromEtree(models.Environment{}, records[5], "partners.xml", 19) }, ShouldPanic)
+		})
+		Convey("Records without id should panic", func() {
+			So(func() { recordFromEtree(models.Environment{}, records[1], "partners.xml", 6) }, ShouldPanic)
+		})
+		Convey("ref on fields which are not relation fields should panic", func() {
+			So(func() { recordFromEtree(models.Environment{}, records[2], "partners.xml", 9) }, ShouldPanic)
+		})
+		Convey("Records should be skipped when loading resources", func() {
+			file, err := ioutil.TempFile("", "doxa-records")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			_, err = doc.WriteTo(file)
+			So(err, ShouldBeNil)
+			file.Close()
+			So(func() { loadXMLResourceFile(file.Name()) }, ShouldNotPanic)
+		})
+		Convey("Delete tags should be read as deletion directives", func() {
+			delDoc := etree.NewDocument()
+			So(delDoc.ReadFromString(`<doxa><data>
+	<delete model="XMLRecordPartner" id="partner_1|partner_2"/>
+	<delete model="XMLRecordPartner" search="[('Name', '=', 'Agrolait')]"/>
+	<delete model="XMLRecordPartner"/>
+	<delete model="XMLRecordPartner" search="'Agrolait'"/>
+</data></doxa>`), ShouldBeNil)
+			deletes := delDoc.FindElements("doxa/data/delete")
+			deletion := deletionFromEtree(deletes[0], "partners.xml", 2)
+			So(deletion.Model, ShouldEqual, "XMLRecordPartner")
+			So(deletion.ExternalIDs, ShouldResemble, []string{"partner_1", "partner_2"})
+			So(deletion.Condition, ShouldBeNil)
+			deletion = deletionFromEtree(deletes[1], "partners.xml", 3)
+			So(deletion.ExternalIDs, ShouldBeNil)
+			So(deletion.Condition, ShouldNotBeNil)
+			So(func() { deletionFromEtree(deletes[2], "partners.xml", 4) }, ShouldPanic)
+			So(func() { deletionFromEtree(deletes[3], "partners.xml", 5) }, ShouldPanic)
+		})
+	})
+}
+
+func TestDataLoadingConfig(t *testing.T) {
+	Convey("Testing the configuration of data loading", t, func() {
+		viper.Set("DataBatchSize", 50)
+		viper.Set("DataCollectErrors", true)
+		defer func() {
+			viper.Set("DataBatchSize", 1000)
+			viper.Set("DataCollectErrors", false)
+			ConfigureDataLoading()
+		}()
+		ConfigureDataLoading()
+		So(models.DataBatchSize, ShouldEqual, 50)
+		So(CollectDataErrors, ShouldBeTrue)
+	})
+}
+
+func TestDemoTags(t *testing.T) {
+	Convey("Testing the selection of demo data files by tags", t, func() {
+		Convey("Demo tags should be read from the configuration", func() {
+			viper.Set("DemoTags", []string{"small"})
+			defer func() {
+				viper.Set("DemoTags", nil)
+				ConfigureDataLoading()
+			}()
+			ConfigureDataLoading()
+			So(DemoTags, ShouldResemble, []string{"small"})
+		})
+		Convey("Untagged files should always be selected", func() {
+			So(demoFileSelected("demo/010-User.csv", nil), ShouldBeTrue)
+			So(demoFileSelected("demo/010-User.csv", []string{"small"}), ShouldBeTrue)
+			So(demoFileSelected("demo/User_update.csv", []string{"small"}), ShouldBeTrue)
+		})
+		Convey("Tagged files should be selected by one of their tags", func() {
+			So(demoFileSelected("demo/020-Partner.small.perf.csv", []string{"perf"}), ShouldBeTrue)
+			So(demoFileSelected("demo/020-Partner.small.perf.csv", []string{"full", "small"}), ShouldBeTrue)
+			So(demoFileSelected("demo/020-Partner.small.perf.csv", []string{"full"}), ShouldBeFalse)
+			So(demoFileSelected("demo/Partner_update.full.csv", []string{"small"}), ShouldBeFalse)
+		})
+		Convey("All files should be selected without tags", func() {
+			So(demoFileSelected("demo/020-Partner.full.csv", nil), ShouldBeTrue)
+		})
+	})
+}
+
+func TestLargeDemo(t *testing.T) {
+	Convey("Testing the configuration of large demo data", t, func() {
+		Convey("Large demo mode should be read from the configuration", func() {
+			viper.Set("DemoLarge", true)
+			viper.Set("DemoSeed", 42)
+			viper.Set("DemoScale", 0.5)
+			defer func() {
+				viper.Set("DemoLarge", false)
+				viper.Set("DemoSeed", 0)
+				viper.Set("DemoScale", 1)
+				ConfigureDataLoading()
+			}()
+			ConfigureDataLoading()
+			So(LargeDemo, ShouldBeTrue)
+			So(DemoSeed, ShouldEqual, 42)
+			So(DemoScale, ShouldEqual, 0.5)
+		})
+		Convey("Volumes should be scaled", func() {
+			So(scaledVolume(1000, 1), ShouldEqual, 1000)
+			So(scaledVolume(1000, 0.25), ShouldEqual, 250)
+			So(scaledVolume(3, 0.5), ShouldEqual, 2)
+			So(scaledVolume(10, 0), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestModuleDependencies(t *testing.T) {
+	Convey("Testing the dependencies of modules", t, func() {
+		names := func(mods []*Module) []string {
+			res := make([]string, len(mods))
+			for i, mod := range mods {
+				res[i] = mod.Name
+			}
+			return res
+		}
+		sales := &Module{Name: "sales", Depends: []string{"partners", "products"}}
+		partners := &Module{Name: "partners"}
+		products := &Module{Name: "products", Depends: []string{"partners"}}
+		invoices := &Module{Name: "invoices", Depends: []string{"sales"}}
+		Convey("Modules should come after their dependencies", func() {
+			So(names(dependencyOrder([]*Module{invoices, sales, partners, products})), ShouldResemble,
+				[]string{"partners", "products", "sales", "invoices"})
+			So(names(dependencyOrder([]*Module{partners, products})), ShouldResemble, []string{"partners", "products"})
+		})
+		Convey("Circular and unknown dependencies should panic", func() {
+			partners.Depends = []string{"invoices"}
+			So(func() { dependencyOrder([]*Module{invoices, sales, partners, products}) }, ShouldPanic)
+			partners.Depends = []string{"unknown"}
+			So(func() { dependencyOrder([]*Module{partners}) }, ShouldPanic)
+		})
+	})
+}
+
+func TestModuleVersions(t *testing.T) {
+	Convey("Testing the versions of modules", t, func() {
+		Convey("Versions should be compared part by part", func() {
+			So(compareVersions("1.2", "1.2"), ShouldEqual, 0)
+			So(compareVersions("1.2", "1.10"), ShouldEqual, -1)
+			So(compareVersions("1.10", "1.2"), ShouldEqual, 1)
+			So(compareVersions("1.0", "1.00"), ShouldEqual, 0)
+			So(compareVersions("1", "1.0"), ShouldEqual, -1)
+			So(compareVersions("", "0.1"), ShouldEqual, -1)
+			So(compareVersions("1.0.beta", "1.0.rc"), ShouldEqual, -1)
+		})
+		Convey("Pending migrations should be the newer ones up to the module version, in order", func() {
+			noop := func(env models.Environment) {}
+			mod := &Module{
+				Name:    "migrated",
+				Version: "1.10",
+				Migrations: map[string]func(env models.Environment){
+					"1.1": noop, "1.2": noop, "1.9": noop, "1.10": noop, "2.0": noop,
+				},
+			}
+			So(pendingMigrations(mod, "1.1"), ShouldResemble, []string{"1.2", "1.9", "1.10"})
+			So(pendingMigrations(mod, ""), ShouldResemble, []string{"1.1", "1.2", "1.9", "1.10"})
+			So(pendingMigrations(mod, "1.10"), ShouldBeEmpty)
+		})
+		Convey("Modules should be found by name", func() {
+			oldModules := Modules
+			defer func() { Modules = oldModules }()
+			Modules = nil
+			RegisterModule(&Module{Name: "first"})
+			So(Modules.Get("first"), ShouldNotBeNil)
+			So(Modules.Get("unknown"), ShouldBeNil)
+			So(selectModules(nil), ShouldHaveLength, 1)
+			So(func() { selectModules([]string{"unknown"}) }, ShouldPanic)
+		})
+	})
+}
+
+func TestBuildInfo(t *testing.T) {
+	Convey("Testing the build information", t, func() {
+		oldModules, oldCommit := Modules, Commit
+		defer func() { Modules, Commit = oldModules, oldCommit }()
+		Modules = nil
+		RegisterModule(&Module{Name: "first", Version: "1.2"})
+		Commit = "0123abcd"
+		info := GetBuildInfo()
+		So(info.Version, ShouldEqual, Version)
+		So(info.Commit, ShouldEqual, "0123abcd")
+		So(info.GoVersion, ShouldEqual, runtime.Version())
+		So(info.Modules, ShouldResemble, []ModuleInfo{{Name: "first", Version: "1.2"}})
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/spf13/viper"
+)
+
+// ShareLinkPrefix is the path under which shared records are served
+const ShareLinkPrefix = "/share/"
+
+// shareKey returns the key used to sign share tokens, set by the
+// Server.Share.Secret configuration key. Sharing is disabled if it is empty.
+func shareKey() []byte {
+	return []byte(viper.GetString("Server.Share.Secret"))
+}
+
+// ShareLink returns the path of a link granting the given access (security.ShareRead
+// or security.ShareComment) to the given fields of the record with the given id
+// of the given model to anyone holding it during validity.
+func ShareLink(model string, id int64, access string, fields []string, validity time.Duration) (string, error) {
+	key := shareKey()
+	if len(key) == 0 {
+		return "", errors.New("record sharing is disabled: no share secret configured")
+	}
+	return ShareLinkPrefix + security.NewShareToken(key, model, id, access, fields, validity), nil
+}
+
+// ShareClaims returns the claims of the share token given in the 'token'
+// parameter of the request's route.
+func (c *Context) ShareClaims() (security.ShareClaims, error) {
+	key := shareKey()
+	if len(key) == 0 {
+		return security.ShareClaims{}, errors.New("record sharing is disabled")
+	}
+	return security.ParseShareToken(key, c.Param("token"))
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// newTLSConfig returns the TLS configuration of HTTPS servers, which
+// only accepts TLS 1.2 or later with forward secrecy cipher suites.
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+	}
+}
+
+// RedirectToHTTPS returns an http.Handler that redirects all requests
+// to the same URL with the https scheme on the port of httpsAddr.
+//
+// GET and HEAD requests are permanently redirected with a 301 status,
+// other requests with a 308 status so that their method and body are kept.
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil || port == "443" || port == "https" {
+		port = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// RunHTTPRedirect listens on addr for plain HTTP requests
+// and redirects them to the HTTPS server listening on httpsAddr.
+// Note: this method will block the calling goroutine until the server is shut down or an error happens.
+func (s *Server) RunHTTPRedirect(addr, httpsAddr string) (err error) {
+	defer func() { log.Info("HTTP redirect server stopped", "error", err) }()
+
+	log.Info("Redirecting HTTP to HTTPS", "address", addr, "httpsAddress", httpsAddr)
+	err = s.serve(&http.Server{Addr: addr, Handler: RedirectToHTTPS(httpsAddr)}, false, "", "")
+	return
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("github.com/labneco/doxa/doxa/server")
+
+// Trace is a middleware that starts a server span for each request when
+// tracing is enabled. The span continues the trace given in the request
+// headers, if any.
+//
+// The spans of the following handlers, model method calls and SQL
+// queries are children of this span.
+func Trace(c *Context) {
+	if !tracing.Enabled() {
+		c.Next()
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, "HTTP "+c.Request.Method, trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+			attribute.String("client.address", c.ClientIP()),
+		))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	models.WithTraceContext(ctx, c.Next)
+
+	if route := c.FullPath(); route != "" {
+		span.SetName("HTTP " + c.Request.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+	}
+	status := c.Writer.Status()
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", status),
+		attribute.String("doxa.request_id", c.RequestID()),
+	)
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// handlerName returns the name of the given handler for tracing spans
+func handlerName(handler HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// traceHandler executes the given handler inside a span with the given name
+// if the request is traced, so that the middleware chain can be broken down.
+func traceHandler(c *Context, name string, handler HandlerFunc) {
+	parent := c.Request.Context()
+	if !trace.SpanFromContext(parent).SpanContext().IsValid() {
+		handler(c)
+		return
+	}
+	ctx, span := tracer.Start(parent, name)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+	models.WithTraceContext(ctx, func() {
+		handler(c)
+	})
+	// Handlers executed after this one are not children of its span
+	c.Request = c.Request.WithContext(parent)
+}
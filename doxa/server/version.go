@@ -0,0 +1,84 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// doxaModulePath is the path of the Go module of the Doxa framework
+const doxaModulePath = "github.com/labneco/doxa"
+
+// Version is the version of the Doxa framework
+const Version = "0.1"
+
+// Commit is the git commit from which the framework has been built.
+// It can be set at build time with:
+//
+//	-ldflags "-X github.com/labneco/doxa/doxa/server.Commit=<commit>"
+//
+// If it is not set, it is read from the build information of the binary.
+var Commit string
+
+// ModuleInfo is the name and the version of a compiled-in module
+type ModuleInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// BuildInfo describes the build of a Doxa binary
+type BuildInfo struct {
+	Version   string       `json:"version"`
+	Commit    string       `json:"commit"`
+	GoVersion string       `json:"go_version"`
+	Modules   []ModuleInfo `json:"modules"`
+}
+
+// GetBuildInfo returns the version and the commit of the framework,
+// the Go version and the modules compiled in the running binary.
+func GetBuildInfo() BuildInfo {
+	res := BuildInfo{
+		Version:   Version,
+		Commit:    buildCommit(),
+		GoVersion: runtime.Version(),
+		Modules:   make([]ModuleInfo, len(Modules)),
+	}
+	for i, mod := range Modules {
+		res.Modules[i] = ModuleInfo{Name: mod.Name, Version: mod.Version}
+	}
+	return res
+}
+
+// buildCommit returns the git commit of the framework. If Commit is not
+// set, it is the VCS revision of the binary when the framework is the main
+// module, and the version of the framework module otherwise, which holds
+// the commit for pseudo-versions.
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == doxaModulePath {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != doxaModulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/spf13/viper"
+)
+
+// MaxWebhookBodySize is the maximum size in bytes of the body of incoming webhook requests
+var MaxWebhookBodySize int64 = 1 << 20
+
+// ErrInvalidSignature is returned when the signature of a request body is missing or invalid
+var ErrInvalidSignature = errors.New("invalid payload signature")
+
+// WebhookUID returns the id of the technical user under which incoming
+// webhooks are processed, set by the Server.Webhooks.UID configuration key.
+// Incoming webhooks are disabled if it is 0.
+func WebhookUID() int64 {
+	return viper.GetInt64("Server.Webhooks.UID")
+}
+
+// Body reads the body of the request. It returns ErrBodyTooLarge
+// if the body is larger than MaxWebhookBodySize bytes.
+func (c *Context) Body() ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, MaxWebhookBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > MaxWebhookBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// SignedBody reads the body of the request and checks that it is signed
+// with the given secret in the given header, as computed by security.SignPayload.
+// It returns ErrInvalidSignature if the signature is missing or invalid,
+// or if secret is empty.
+func (c *Context) SignedBody(secret []byte, header string) ([]byte, error) {
+	body, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+	if !security.CheckPayloadSignature(secret, body, c.GetHeader(header)) {
+		return nil, ErrInvalidSignature
+	}
+	return body, nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// workerRoleEnv is the environment variable that holds
+// the role of a worker process started by Supervise.
+const workerRoleEnv = "DOXA_WORKER"
+
+// Worker roles
+const (
+	// WorkerHTTP workers serve HTTP requests
+	WorkerHTTP = "http"
+	// WorkerCron workers do not serve HTTP requests and
+	// run the background jobs of the application.
+	WorkerCron = "cron"
+)
+
+var (
+	// WorkerRestartDelay is the delay after which a worker
+	// that exited unexpectedly is restarted.
+	WorkerRestartDelay = time.Second
+	// WorkerRestartInterval is the delay between the restarts of two
+	// workers on SIGHUP, during which the new worker bootstraps.
+	WorkerRestartInterval = 10 * time.Second
+)
+
+// WorkerRole returns the role of this process if it is a worker
+// started by Supervise, or an empty string otherwise.
+func WorkerRole() string {
+	return os.Getenv(workerRoleEnv)
+}
+
+// A worker is a worker process supervised by a supervisor
+type worker struct {
+	role string
+	cmd  *exec.Cmd
+	// stopping is set when the supervisor stops this worker on purpose
+	stopping bool
+}
+
+// A supervisor starts worker processes and restarts them when they exit
+type supervisor struct {
+	sync.Mutex
+	addresses []string
+	files     []*os.File
+	workers   map[*worker]bool
+	exited    chan *worker
+	stopping  bool
+}
+
+// start starts a new worker process with the given role
+func (s *supervisor) start(role string) error {
+	s.Lock()
+	stopping := s.stopping
+	s.Unlock()
+	if stopping {
+		return nil
+	}
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = s.files
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, parentPIDEnv+"=") || strings.HasPrefix(env, listenersEnv+"=") {
+			continue
+		}
+		cmd.Env = append(cmd.Env, env)
+	}
+	cmd.Env = append(cmd.Env,
+		fmt.Sprintf("%s=%s", workerRoleEnv, role),
+		fmt.Sprintf("%s=%s", listenersEnv, strings.Join(s.addresses, ",")))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w := &worker{role: role, cmd: cmd}
+	s.Lock()
+	s.workers[w] = true
+	// The supervisor may have started stopping while the worker was starting
+	w.stopping = s.stopping
+	s.Unlock()
+	log.Info("Worker started", "role", role, "pid", cmd.Process.Pid)
+	if w.stopping {
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+	go func() {
+		err := cmd.Wait()
+		log.Info("Worker exited", "role", role, "pid", cmd.Process.Pid, "error", err)
+		s.exited <- w
+	}()
+	return nil
+}
+
+// stop asks the given worker to shut down gracefully
+func (s *supervisor) stop(w *worker) {
+	s.Lock()
+	w.stopping = true
+	s.Unlock()
+	w.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// running returns the workers that are not being stopped
+func (s *supervisor) running() []*worker {
+	s.Lock()
+	defer s.Unlock()
+	var res []*worker
+	for w := range s.workers {
+		if !w.stopping {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// Supervise runs this process as the master of httpWorkers worker processes
+// which serve HTTP requests and cronWorkers worker processes which run the
+// background jobs. Worker processes run the same executable with the same
+// arguments, and their role is given by WorkerRole.
+//
+// The master listens on the given addresses and HTTP workers inherit these
+// listeners, so that they all accept connections on the same sockets.
+//
+// Workers that exit unexpectedly are restarted after WorkerRestartDelay.
+// On SIGHUP, workers are restarted one after the other, without refusing
+// connections. On SIGINT or SIGTERM, workers are shut down gracefully and
+// killed if they are still running after shutdownTimeout. Supervise
+// returns when all workers have exited.
+func Supervise(addresses []string, httpWorkers, cronWorkers int, shutdownTimeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	s := newSupervisor(addresses)
+	return s.run(httpWorkers, cronWorkers, shutdownTimeout, signals)
+}
+
+// newSupervisor returns a new supervisor of workers
+// listening on the given addresses.
+func newSupervisor(addresses []string) *supervisor {
+	return &supervisor{
+		addresses: addresses,
+		workers:   make(map[*worker]bool),
+		exited:    make(chan *worker),
+	}
+}
+
+// run listens on the addresses of this supervisor and supervises its workers
+// as described in Supervise, until all workers have exited after a SIGINT or
+// a SIGTERM. Signals are received on the given channel.
+func (s *supervisor) run(httpWorkers, cronWorkers int, shutdownTimeout time.Duration, signals <-chan os.Signal) error {
+	for _, addr := range s.addresses {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		f, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		defer ln.Close()
+		s.files = append(s.files, f)
+	}
+	log.Info("Starting workers", "http", httpWorkers, "cron", cronWorkers, "addresses", s.addresses)
+	for i := 0; i < httpWorkers+cronWorkers; i++ {
+		role := WorkerHTTP
+		if i >= httpWorkers {
+			role = WorkerCron
+		}
+		if err := s.start(role); err != nil {
+			return err
+		}
+	}
+
+	var killTimer <-chan time.Time
+	for {
+		select {
+		case w := <-s.exited:
+			s.Lock()
+			delete(s.workers, w)
+			remaining := len(s.workers)
+			stopping := s.stopping
+			s.Unlock()
+			if stopping && remaining == 0 {
+				log.Info("All workers stopped")
+				return nil
+			}
+			if !stopping && !w.stopping {
+				time.AfterFunc(WorkerRestartDelay, func() {
+					if err := s.start(w.role); err != nil {
+						log.Warn("Unable to restart worker", "role", w.role, "error", err)
+					}
+				})
+			}
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				go s.restartAll()
+				continue
+			}
+			log.Info("Received signal, stopping workers", "signal", sig)
+			s.Lock()
+			s.stopping = true
+			remaining := len(s.workers)
+			s.Unlock()
+			if remaining == 0 {
+				// Workers waiting to be restarted will not be started
+				log.Info("All workers stopped")
+				return nil
+			}
+			for _, w := range s.running() {
+				s.stop(w)
+			}
+			killTimer = time.After(shutdownTimeout)
+		case <-killTimer:
+			log.Warn("Killing workers that did not stop in time")
+			s.Lock()
+			for w := range s.workers {
+				w.cmd.Process.Kill()
+			}
+			s.Unlock()
+			// Killed workers are reaped by the exited case, which
+			// returns once the last one of them has exited.
+		}
+	}
+}
+
+// restartAll starts a new worker for each running worker and
+// stops the old one once the new one has been started.
+func (s *supervisor) restartAll() {
+	log.Info("Restarting workers")
+	for _, w := range s.running() {
+		if err := s.start(w.role); err != nil {
+			log.Warn("Unable to start worker", "role", w.role, "error", err)
+			return
+		}
+		// Leave time to the new worker to bootstrap before stopping the old one
+		time.Sleep(WorkerRestartInterval)
+		s.stop(w)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+//go:build !windows
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	// testWorkerLogEnv is the environment variable holding the file in which
+	// the test workers write their role and pid when they start.
+	testWorkerLogEnv = "DOXA_TEST_WORKER_LOG"
+	// testWorkerIgnoreTermEnv makes the test workers ignore SIGTERM
+	testWorkerIgnoreTermEnv = "DOXA_TEST_WORKER_IGNORE_TERM"
+)
+
+// TestMain runs this test binary as a test worker when
+// it is started as a worker process by a supervisor.
+func TestMain(m *testing.M) {
+	if WorkerRole() != "" {
+		runTestWorker()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestWorker logs the start of this worker process
+// and waits for SIGTERM to exit.
+func runTestWorker() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	f, err := os.OpenFile(os.Getenv(testWorkerLogEnv), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+	f.WriteString(WorkerRole() + " " + strconv.Itoa(os.Getpid()) + "\n")
+	f.Close()
+	for range signals {
+		if os.Getenv(testWorkerIgnoreTermEnv) == "" {
+			os.Exit(0)
+		}
+	}
+}
+
+// testWorkers returns the role and pid of the test workers
+// started so far, as logged in the given file.
+func testWorkers(logFile string) [][2]string {
+	data, _ := ioutil.ReadFile(logFile)
+	var res [][2]string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if parts := strings.Fields(line); len(parts) == 2 {
+			res = append(res, [2]string{parts[0], parts[1]})
+		}
+	}
+	return res
+}
+
+// waitForTestWorkers waits until count test workers have been
+// started and returns them, or returns nil after a timeout.
+func waitForTestWorkers(logFile string, count int) [][2]string {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if workers := testWorkers(logFile); len(workers) >= count {
+			return workers
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// processExists returns true if the process with the given pid is running
+func processExists(pid string) bool {
+	p, _ := strconv.Atoi(pid)
+	return syscall.Kill(p, 0) == nil
+}
+
+func TestSupervisor(t *testing.T) {
+	Convey("Testing the worker supervisor", t, func() {
+		dir, err := ioutil.TempDir("", "doxa-workers")
+		So(err, ShouldBeNil)
+		logFile := filepath.Join(dir, "workers.log")
+		os.Setenv(testWorkerLogEnv, logFile)
+		oldDelay, oldInterval := WorkerRestartDelay, WorkerRestartInterval
+		WorkerRestartDelay, WorkerRestartInterval = 10*time.Millisecond, 100*time.Millisecond
+		signals := make(chan os.Signal, 1)
+		s := newSupervisor([]string{"127.0.0.1:0"})
+		done := make(chan error, 1)
+		waitForExit := func(timeout time.Duration) bool {
+			select {
+			case err := <-done:
+				So(err, ShouldBeNil)
+				return true
+			case <-time.After(timeout):
+				return false
+			}
+		}
+		Convey("Crashed workers should be restarted", func() {
+			go func() { done <- s.run(1, 1, time.Second, signals) }()
+			workers := waitForTestWorkers(logFile, 2)
+			So(workers, ShouldHaveLength, 2)
+			var httpPID string
+			for _, w := range workers {
+				if w[0] == WorkerHTTP {
+					httpPID = w[1]
+				}
+			}
+			pid, _ := strconv.Atoi(httpPID)
+			So(syscall.Kill(pid, syscall.SIGKILL), ShouldBeNil)
+			workers = waitForTestWorkers(logFile, 3)
+			So(workers, ShouldHaveLength, 3)
+			So(workers[2][0], ShouldEqual, WorkerHTTP)
+			So(workers[2][1], ShouldNotEqual, httpPID)
+			signals <- syscall.SIGTERM
+			So(waitForExit(10*time.Second), ShouldBeTrue)
+			So(processExists(workers[2][1]), ShouldBeFalse)
+		})
+		Convey("SIGHUP should restart workers one after the other", func() {
+			go func() { done <- s.run(2, 0, time.Second, signals) }()
+			oldWorkers := waitForTestWorkers(logFile, 2)
+			So(oldWorkers, ShouldHaveLength, 2)
+			signals <- syscall.SIGHUP
+			workers := waitForTestWorkers(logFile, 4)
+			So(workers, ShouldHaveLength, 4)
+			deadline := time.Now().Add(10 * time.Second)
+			for (processExists(oldWorkers[0][1]) || processExists(oldWorkers[1][1])) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(processExists(oldWorkers[0][1]), ShouldBeFalse)
+			So(processExists(oldWorkers[1][1]), ShouldBeFalse)
+			So(processExists(workers[2][1]), ShouldBeTrue)
+			So(processExists(workers[3][1]), ShouldBeTrue)
+			signals <- syscall.SIGTERM
+			So(waitForExit(10*time.Second), ShouldBeTrue)
+		})
+		Convey("SIGTERM should stop the workers gracefully", func() {
+			go func() { done <- s.run(2, 1, time.Minute, signals) }()
+			workers := waitForTestWorkers(logFile, 3)
+			So(workers, ShouldHaveLength, 3)
+			signals <- syscall.SIGTERM
+			So(waitForExit(10*time.Second), ShouldBeTrue)
+			for _, w := range workers {
+				So(processExists(w[1]), ShouldBeFalse)
+			}
+		})
+		Convey("Workers that ignore SIGTERM should be killed after the timeout", func() {
+			os.Setenv(testWorkerIgnoreTermEnv, "1")
+			defer os.Unsetenv(testWorkerIgnoreTermEnv)
+			go func() { done <- s.run(1, 0, 200*time.Millisecond, signals) }()
+			workers := waitForTestWorkers(logFile, 1)
+			So(workers, ShouldHaveLength, 1)
+			signals <- syscall.SIGTERM
+			So(waitForExit(100*time.Millisecond), ShouldBeFalse)
+			So(waitForExit(10*time.Second), ShouldBeTrue)
+			So(processExists(workers[0][1]), ShouldBeFalse)
+		})
+		Convey("SIGTERM should not wait for crashed workers to be restarted", func() {
+			WorkerRestartDelay = time.Hour
+			go func() { done <- s.run(1, 0, time.Minute, signals) }()
+			workers := waitForTestWorkers(logFile, 1)
+			So(workers, ShouldHaveLength, 1)
+			pid, _ := strconv.Atoi(workers[0][1])
+			So(syscall.Kill(pid, syscall.SIGKILL), ShouldBeNil)
+			deadline := time.Now().Add(10 * time.Second)
+			for time.Now().Before(deadline) {
+				s.Lock()
+				remaining := len(s.workers)
+				s.Unlock()
+				if remaining == 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			signals <- syscall.SIGTERM
+			So(waitForExit(time.Second), ShouldBeTrue)
+		})
+		WorkerRestartDelay, WorkerRestartInterval = oldDelay, oldInterval
+		os.Unsetenv(testWorkerLogEnv)
+		os.RemoveAll(dir)
+	})
+}
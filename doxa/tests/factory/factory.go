@@ -0,0 +1,219 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package factory creates records for tests with sensible default values, so that
+tests only need to set the fields they are about.
+
+Default values of the fields of a model are declared once with Define, usually
+in an init function of the test package:
+
+	factory.Define("User", factory.Generators{
+		"Name":    factory.Sequence("User %d"),
+		"Email":   factory.Sequence("user%d@example.com"),
+		"Profile": factory.SubFactory("Profile"),
+	})
+
+Records are then created in tests with Create, given the values that differ
+from the defaults:
+
+	user := factory.Create(env, "User", models.FieldMap{"IsStaff": true})
+
+Fields without generator that are required and have no default value in the
+model are set automatically: a related record is created for required
+many2one and one2one fields, and a value depending on the field type is
+generated for the others. A FieldMap given as the value of a many2one or
+one2one field creates the related record with these values.
+*/
+package factory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+// A Generator returns the value of a field of the nth
+// record created by the factory of a model, starting at 1.
+type Generator func(env models.Environment, n int) interface{}
+
+// Generators maps field names of a model to their Generator
+type Generators map[string]Generator
+
+var (
+	log         *logging.Logger
+	mu          sync.Mutex
+	definitions = make(map[string]Generators)
+	counters    = make(map[string]int)
+)
+
+// Define declares the Generators of the given model's fields.
+// Calling Define several times for the same model adds or
+// replaces generators.
+func Define(modelName string, generators Generators) {
+	mu.Lock()
+	defer mu.Unlock()
+	if definitions[modelName] == nil {
+		definitions[modelName] = make(Generators)
+	}
+	for field, gen := range generators {
+		definitions[modelName][field] = gen
+	}
+}
+
+// Reset removes all the definitions and sets the record counters back to 0.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	definitions = make(map[string]Generators)
+	counters = make(map[string]int)
+}
+
+// Value returns a Generator that always returns the given value
+func Value(value interface{}) Generator {
+	return func(models.Environment, int) interface{} {
+		return value
+	}
+}
+
+// Sequence returns a Generator of strings formatted with the given
+// format and the number of the record, e.g. "user%d@example.com".
+func Sequence(format string) Generator {
+	return func(_ models.Environment, n int) interface{} {
+		return fmt.Sprintf(format, n)
+	}
+}
+
+// SubFactory returns a Generator that creates a record of the given
+// model with the given values, for use with many2one and one2one fields.
+func SubFactory(modelName string, values ...models.FieldMap) Generator {
+	return func(env models.Environment, _ int) interface{} {
+		return Create(env, modelName, values...)
+	}
+}
+
+// Build returns the values with which Create would create a record of the given
+// model, without creating it. Related records are created though.
+//
+// values are merged in order and override the generated values.
+func Build(env models.Environment, modelName string, values ...models.FieldMap) models.FieldMap {
+	model := models.Registry.MustGet(modelName)
+	overrides := make(models.FieldMap)
+	for _, v := range values {
+		overrides.MergeWith(v, model)
+	}
+
+	mu.Lock()
+	counters[modelName]++
+	n := counters[modelName]
+	generators := make(Generators, len(definitions[modelName]))
+	for field, gen := range definitions[modelName] {
+		generators[field] = gen
+	}
+	mu.Unlock()
+
+	res := make(models.FieldMap)
+	// Generators are called in a fixed order so that created records are reproducible
+	fieldNames := make([]string, 0, len(generators))
+	for field := range generators {
+		fieldNames = append(fieldNames, field)
+	}
+	sort.Strings(fieldNames)
+	for _, field := range fieldNames {
+		if _, ok := overrides.Get(field, model); ok {
+			continue
+		}
+		res.Set(field, generators[field](env, n), model)
+	}
+
+	defaults := env.Pool(modelName).Call("DefaultGet").(models.FieldMap)
+	fInfos := model.FieldsGet()
+	jsonNames := make([]string, 0, len(fInfos))
+	for jsonName := range fInfos {
+		jsonNames = append(jsonNames, jsonName)
+	}
+	sort.Strings(jsonNames)
+	for _, jsonName := range jsonNames {
+		fInfo := fInfos[jsonName]
+		if jsonName == "id" || !fInfo.Required || !fInfo.Store {
+			continue
+		}
+		if _, ok := res.Get(jsonName, model); ok {
+			continue
+		}
+		if _, ok := overrides.Get(jsonName, model); ok {
+			continue
+		}
+		if _, ok := defaults.Get(jsonName, model); ok {
+			continue
+		}
+		res.Set(jsonName, generateValue(env, model, jsonName, fInfo, n), model)
+	}
+
+	for field, value := range overrides {
+		fInfo, ok := fInfos[model.JSONizeFieldName(field)]
+		if fm, isFieldMap := value.(models.FieldMap); isFieldMap && ok && fInfo.Type.IsFKRelationType() {
+			value = Create(env, fInfo.Relation, fm)
+		}
+		res.Set(field, value, model)
+	}
+	return res
+}
+
+// Create creates a record of the given model with the values generated by the
+// definition of the model and the required fields, overridden by the given values.
+// values are merged in order.
+func Create(env models.Environment, modelName string, values ...models.FieldMap) *models.RecordCollection {
+	return models.Registry.MustGet(modelName).Create(env, Build(env, modelName, values...))
+}
+
+// CreateMany creates count records of the given model.
+// See Create for the meaning of values.
+func CreateMany(env models.Environment, modelName string, count int, values ...models.FieldMap) *models.RecordCollection {
+	res := env.Pool(modelName)
+	for i := 0; i < count; i++ {
+		res = res.Union(Create(env, modelName, values...))
+	}
+	return res
+}
+
+// generateValue returns a value for the given required field of the nth record
+// of the given model. It creates a record for many2one and one2one fields.
+func generateValue(env models.Environment, model *models.Model, jsonName string, fInfo *models.FieldInfo, n int) interface{} {
+	switch fInfo.Type {
+	case fieldtype.Many2One, fieldtype.One2One:
+		return Create(env, fInfo.Relation)
+	case fieldtype.Char, fieldtype.Text, fieldtype.HTML:
+		return fmt.Sprintf("%s %s %d", model.Name(), jsonName, n)
+	case fieldtype.Integer:
+		return n
+	case fieldtype.Float:
+		return float64(n)
+	case fieldtype.Boolean:
+		return false
+	case fieldtype.Date:
+		return dates.Today()
+	case fieldtype.DateTime:
+		return dates.Now()
+	case fieldtype.Selection:
+		keys := make([]string, 0, len(fInfo.Selection))
+		for key := range fInfo.Selection {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			return keys[0]
+		}
+	}
+	log.Panic("Unable to generate a value for required field, define a generator for it", "model", model.Name(), "field", jsonName, "type", fInfo.Type)
+	return nil
+}
+
+func init() {
+	log = logging.GetLogger("factory")
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package golden compares the outputs of tests with golden files, which hold the
+expected outputs and are committed with the tests.
+
+A golden file is stored as testdata/<name>.golden in the directory of the tested
+package. It is typically used to catch regressions in the SQL generated for a
+RecordSet, without executing it:
+
+	func TestUserQueries(t *testing.T) {
+		Convey("Testing the SQL of user queries", t, func() {
+			models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				users := h.User().Search(env, q.User().Name().Equals("John"))
+				So(users.SelectSQL("Name", "Email"), golden.ShouldMatch, "users/select_by_name")
+			})
+		})
+	}
+
+Golden files are written instead of being compared when the DOXA_UPDATE_GOLDEN
+environment variable is set, for instance with 'doxa test --update-golden'.
+Updated golden files must then be reviewed before being committed.
+*/
+package golden
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// UpdateEnvVar is the environment variable which, when set,
+// makes golden files be written with the actual outputs.
+const UpdateEnvVar = "DOXA_UPDATE_GOLDEN"
+
+// Path returns the path of the golden file with the given name,
+// relative to the directory of the tested package.
+func Path(name string) string {
+	return filepath.Join("testdata", filepath.FromSlash(name)+".golden")
+}
+
+// Compare returns an error if actual differs from the content of the golden
+// file with the given name. actual must be a string or a fmt.Stringer.
+//
+// If the DOXA_UPDATE_GOLDEN environment variable is set, the golden file is
+// written with actual instead and Compare returns nil.
+func Compare(name string, actual interface{}) error {
+	var output string
+	switch act := actual.(type) {
+	case string:
+		output = act
+	case fmt.Stringer:
+		output = act.String()
+	default:
+		return fmt.Errorf("expected a string or a fmt.Stringer but got %T", actual)
+	}
+	fileName := Path(name)
+	if os.Getenv(UpdateEnvVar) != "" {
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(fileName, []byte(output), 0644)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to read golden file (set %s to create it): %s", UpdateEnvVar, err)
+	}
+	expected := string(data)
+	if output == expected {
+		return nil
+	}
+	return fmt.Errorf("output differs from golden file %s %s\nExpected:\n%s\nActual:\n%s",
+		fileName, firstDifference(expected, output), expected, output)
+}
+
+// firstDifference returns a description of the first line that differs
+// between the expected and actual outputs.
+func firstDifference(expected, actual string) string {
+	expLines := strings.Split(expected, "\n")
+	actLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expLines) && i < len(actLines); i++ {
+		if expLines[i] != actLines[i] {
+			return fmt.Sprintf("at line %d", i+1)
+		}
+	}
+	return fmt.Sprintf("in length (%d lines expected, got %d)", len(expLines), len(actLines))
+}
+
+// Assert marks the test as failed if actual differs from
+// the content of the golden file with the given name.
+func Assert(t testing.TB, name string, actual interface{}) {
+	t.Helper()
+	if err := Compare(name, actual); err != nil {
+		t.Error(err)
+	}
+}
+
+// ShouldMatch asserts that the actual string or fmt.Stringer
+// matches the golden file whose name is the expected value.
+func ShouldMatch(actual interface{}, expected ...interface{}) string {
+	if len(expected) != 1 {
+		return fmt.Sprintf("This assertion requires exactly 1 expected value (you provided %d)", len(expected))
+	}
+	name, ok := expected[0].(string)
+	if !ok {
+		return fmt.Sprintf("Expected the name of a golden file but got %T", expected[0])
+	}
+	if err := Compare(name, actual); err != nil {
+		return err.Error()
+	}
+	return ""
+}
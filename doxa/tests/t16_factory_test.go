@@ -0,0 +1,61 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tests/factory"
+	"github.com/labneco/doxa/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFactory(t *testing.T) {
+	Convey("Testing record factories", t, func() {
+		factory.Reset()
+		factory.Define("User", factory.Generators{
+			"Name":  factory.Sequence("Factory User %d"),
+			"Email": factory.Sequence("factory.user%d@example.com"),
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Generators should set the fields of created records", func() {
+				user := h.UserSet{RecordCollection: factory.Create(env, "User")}
+				So(user.Name(), ShouldEqual, "Factory User 1")
+				So(user.Email(), ShouldEqual, "factory.user1@example.com")
+				user2 := h.UserSet{RecordCollection: factory.Create(env, "User")}
+				So(user2.Name(), ShouldEqual, "Factory User 2")
+			})
+			Convey("Given values should override generators", func() {
+				user := h.UserSet{RecordCollection: factory.Create(env, "User", models.FieldMap{"Name": "Jack", "IsStaff": true})}
+				So(user.Name(), ShouldEqual, "Jack")
+				So(user.Email(), ShouldEqual, "factory.user1@example.com")
+				So(user.IsStaff(), ShouldBeTrue)
+			})
+			Convey("Required fields should be generated", func() {
+				post := h.PostSet{RecordCollection: factory.Create(env, "Post")}
+				So(post.Title(), ShouldEqual, "Post title 1")
+			})
+			Convey("Related records should be created from FieldMap values", func() {
+				user := h.UserSet{RecordCollection: factory.Create(env, "User", models.FieldMap{
+					"Profile": models.FieldMap{"Age": 31, "City": "Paris"},
+				})}
+				So(user.Profile().IsEmpty(), ShouldBeFalse)
+				So(user.Profile().Age(), ShouldEqual, 31)
+				So(user.Profile().City(), ShouldEqual, "Paris")
+			})
+			Convey("SubFactory should create related records", func() {
+				factory.Define("Post", factory.Generators{
+					"User": factory.SubFactory("User", models.FieldMap{"IsStaff": true}),
+				})
+				posts := h.PostSet{RecordCollection: factory.CreateMany(env, "Post", 3)}
+				So(posts.Len(), ShouldEqual, 3)
+				for _, post := range posts.Records() {
+					So(post.User().IsStaff(), ShouldBeTrue)
+				}
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tests/webtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebTest(t *testing.T) {
+	Convey("Testing controllers through the HTTP server", t, func() {
+		srv := webtest.NewServer()
+		Convey("Anonymous sessions should not reach user routes", func() {
+			resp := srv.Session(0).RPC("/version_info", nil)
+			So(resp, webtest.ShouldHaveStatus, http.StatusUnauthorized)
+		})
+		Convey("Authenticated sessions should keep their user across requests", func() {
+			sess := srv.Session(security.SuperUserID)
+			for i := 0; i < 2; i++ {
+				resp := sess.RPC("/version_info", nil)
+				So(resp, webtest.ShouldHaveStatus, http.StatusOK)
+				So(resp, webtest.ShouldHaveRPCResult, server.GetBuildInfo())
+			}
+		})
+		Convey("Results should be unmarshalled", func() {
+			var info server.BuildInfo
+			So(srv.Session(security.SuperUserID).RPC("/version_info", nil).Result(&info), ShouldBeNil)
+			So(info.Version, ShouldEqual, server.Version)
+		})
+		Convey("Assertions should fail on unexpected responses", func() {
+			resp := srv.Session(0).Get("/healthz")
+			So(webtest.ShouldHaveStatus(resp, http.StatusOK), ShouldBeEmpty)
+			So(webtest.ShouldHaveStatus(resp, http.StatusNotFound), ShouldNotBeEmpty)
+			So(webtest.ShouldHaveRPCError(resp, "error"), ShouldNotBeEmpty)
+			So(webtest.ShouldHaveStatus(nil, http.StatusOK), ShouldNotBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+	"github.com/labneco/doxa/doxa/tools/fake"
+	"github.com/labneco/doxa/pool/h"
+	"github.com/labneco/doxa/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tagFields are the fields of tags which must follow the constraints of the model
+var tagFields = fake.Fields{
+	"Description": fake.Sequence("Fake tag %d"),
+	"Rate": func(g *fake.Generator, _ models.Environment, _ int) interface{} {
+		return float32(g.Rand().Intn(11))
+	},
+}
+
+func TestFake(t *testing.T) {
+	Convey("Testing the fake data generator", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			profileModel := h.Profile().Underlying()
+			Convey("Generators with the same seed should generate the same values", func() {
+				g1, g2 := fake.New(7), fake.New(7)
+				g1.FillRate, g2.FillRate = 1, 1
+				for i := 0; i < 5; i++ {
+					So(g1.Name(), ShouldEqual, g2.Name())
+				}
+				v1, v2 := g1.Values(env, "Profile", nil), g2.Values(env, "Profile", nil)
+				So(v1.MustGet("City", profileModel), ShouldEqual, v2.MustGet("City", profileModel))
+				So(v1.MustGet("Money", profileModel), ShouldEqual, v2.MustGet("Money", profileModel))
+			})
+			Convey("Values should depend on the names and types of fields", func() {
+				g := fake.New(1)
+				g.FillRate = 1
+				profile := h.ProfileSet{RecordCollection: g.Create(env, "Profile", 1, nil)}
+				So(profile.City(), ShouldNotBeEmpty)
+				So(profile.Zip(), ShouldNotBeEmpty)
+				So(profile.Country(), ShouldNotBeEmpty)
+				So(profile.Street(), ShouldNotBeEmpty)
+				So(profile.Gender(), ShouldBeIn, []string{"male", "female"})
+				user := h.UserSet{RecordCollection: g.Create(env, "User", 1, nil)}
+				So(user.Email(), ShouldContainSubstring, "@example.")
+				So(strings.ToLower(user.Email()), ShouldStartWith, strings.ToLower(strings.Fields(user.Name())[0]))
+			})
+			Convey("Volumes should be created in order with relations", func() {
+				users := h.User().NewSet(env).SearchAll().SearchCount()
+				posts := h.Post().NewSet(env).SearchAll().SearchCount()
+				g := fake.New(1)
+				g.Generate(env,
+					fake.Volume{Model: "Tag", Count: 5, Fields: tagFields},
+					fake.Volume{Model: "User", Count: 5},
+					fake.Volume{Model: "Post", Count: 20, Fields: fake.Fields{"Title": fake.Sequence("Fake post %d")}},
+				)
+				So(h.User().NewSet(env).SearchAll().SearchCount(), ShouldEqual, users+5)
+				So(h.Post().NewSet(env).SearchAll().SearchCount(), ShouldEqual, posts+20)
+				fakePosts := h.Post().Search(env, q.Post().Title().Like("Fake post"))
+				So(fakePosts.Len(), ShouldEqual, 20)
+				var withUser, withTags int
+				for _, post := range fakePosts.Records() {
+					if !post.User().IsEmpty() {
+						withUser++
+					}
+					if !post.Tags().IsEmpty() {
+						withTags++
+					}
+				}
+				So(withUser, ShouldBeGreaterThan, 0)
+				So(withTags, ShouldBeGreaterThan, 0)
+			})
+			Convey("Unique fields should get distinct values", func() {
+				g := fake.New(3)
+				g.FillRate = 1
+				users := g.Create(env, "User", 50, nil)
+				names := make(map[string]bool)
+				for _, user := range users.Records() {
+					names[user.Get("Name").(string)] = true
+				}
+				So(names, ShouldHaveLength, 50)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func BenchmarkUserSearch(b *testing.B) {
+	models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		fake.New(1).Generate(env,
+			fake.Volume{Model: "Profile", Count: 200},
+			fake.Volume{Model: "User", Count: 1000},
+		)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			h.User().Search(env, q.User().ProfileFilteredOn(q.Profile().City().Equals("Paris"))).Load()
+		}
+	})
+}
@@ -4,18 +4,30 @@
 package tests
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
 	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/filestore"
+	"github.com/labneco/doxa/doxa/tools/generate"
 	"github.com/labneco/doxa/doxa/tools/logging"
 	"github.com/jmoiron/sqlx"
 	"github.com/spf13/viper"
 )
 
-var driver, user, password, prefix, debug string
+var driver, host, port, user, password, prefix, debug, template, dbName string
+
+// fileStoreDir is the temporary directory of the file store of the tests
+var fileStoreDir string
 
 // RunTests initializes the database, run the tests given by m and
 // tears the database down.
@@ -45,14 +57,60 @@ func RunTests(m *testing.M, moduleName string) {
 
 }
 
+// RunUnitTests bootstraps the models and runs the tests given by m without
+// any database. It is meant for packages of unit tests which only use mock
+// environments, as returned by models.NewMockEnvironment:
+//
+//     func TestMain(m *testing.M) {
+//	       tests.RunUnitTests(m)
+//     }
+func RunUnitTests(m *testing.M) {
+	debug = os.Getenv("DOXA_DEBUG")
+	initializeLogging()
+	setupFileStore()
+	models.BootStrap()
+	res := m.Run()
+	os.RemoveAll(fileStoreDir)
+	os.Exit(res)
+}
+
+// RunInTransaction runs fnct as the superuser in a transaction that is rolled
+// back at the end, and fails the test t if fnct panics. Environments created by
+// the tested code during fnct share this transaction, so that each test leaves
+// the database unchanged. See models.SimulateInTestTransaction.
+//
+//     func TestCourses(t *testing.T) {
+//         tests.RunInTransaction(t, func(env models.Environment) {
+//             ...
+//         })
+//     }
+func RunInTransaction(t testing.TB, fnct func(env models.Environment)) {
+	if err := models.SimulateInTestTransaction(security.SuperUserID, fnct); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // InitializeTests initializes a database for the tests of the given module.
 // You probably want to use RunTests instead.
+//
+// If the DOXA_DB_TEMPLATE environment variable is set, the database is cloned
+// from a template database which holds the schema, data and demo records of
+// the modules, instead of being created and loaded from scratch. See
+// createTestDatabaseFromTemplate for details.
+//
+// If the DOXA_TEST_PARALLEL environment variable is set, the name of the database
+// is suffixed with the process ID, so that several test packages of the same
+// module can run in parallel, each in its own database. The database is then
+// always cloned from the template database of the module, which is shared by
+// all the packages.
 func InitializeTests(moduleName string) {
 	fmt.Printf("Initializing database for module %s\n", moduleName)
 	driver = os.Getenv("DOXA_DB_DRIVER")
 	if driver == "" {
 		driver = "postgres"
 	}
+	host = os.Getenv("DOXA_DB_HOST")
+	port = os.Getenv("DOXA_DB_PORT")
 	user = os.Getenv("DOXA_DB_USER")
 	if user == "" {
 		user = "doxa"
@@ -65,40 +123,183 @@ func InitializeTests(moduleName string) {
 	if prefix == "" {
 		prefix = "doxa"
 	}
-	dbName := fmt.Sprintf("%s_%s_tests", prefix, moduleName)
+	dbName = fmt.Sprintf("%s_%s_tests", prefix, moduleName)
 	debug = os.Getenv("DOXA_DEBUG")
+	template = os.Getenv("DOXA_DB_TEMPLATE")
+	if os.Getenv("DOXA_TEST_PARALLEL") != "" {
+		dbName = fmt.Sprintf("%s_%d", dbName, os.Getpid())
+		if template == "" {
+			template = "1"
+		}
+	}
+
+	initializeLogging()
+	setupFileStore()
+
+	models.BootStrap()
+	if template != "" {
+		createTestDatabaseFromTemplate(moduleName)
+		server.PostInitModules()
+		return
+	}
+
+	db := sqlx.MustConnect(driver, adminConnectionString())
+	db.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+	db.Close()
+
+	connectTestDatabase(dbName)
+	models.SyncDatabase()
+	server.LoadDataRecords()
+	server.LoadDemoRecords()
+
+	server.PostInitModules()
+}
 
+// initializeLogging initializes the logging of the tests,
+// at debug level if the DOXA_DEBUG environment variable is set.
+func initializeLogging() {
 	viper.Set("LogLevel", "crit")
 	if debug != "" {
 		viper.Set("LogLevel", "debug")
 		viper.Set("LogStdout", true)
 	}
 	logging.Initialize()
+}
 
-	db := sqlx.MustConnect(driver, fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", user, password))
-	db.MustExec(fmt.Sprintf("CREATE DATABASE %s", dbName))
-	db.Close()
+// createTestDatabaseFromTemplate creates the database of the tests of the
+// given module as a copy of the <prefix>_<module>_template database and connects
+// to it.
+//
+// The template database is created and loaded with the data and demo records of
+// the modules the first time, and kept afterwards. It is rebuilt if the schema of
+// the models or the data files of the modules have changed since, or if
+// DOXA_DB_TEMPLATE starts with "rebuild". Copying a database is much faster than
+// loading its records, so that only the first run of the tests of a module pays
+// for it.
+//
+// The comment of the template database is its fingerprint, followed by the value
+// of DOXA_DB_TEMPLATE if it forced its rebuild. This way, packages tested in
+// parallel with DOXA_DB_TEMPLATE set to "rebuild-<run ID>" rebuild it only once.
+func createTestDatabaseFromTemplate(moduleName string) {
+	templateName := fmt.Sprintf("%s_%s_template", prefix, moduleName)
+	fingerprint := templateFingerprint()
+	upToDate := func(comment string) bool {
+		fields := strings.Fields(comment)
+		if strings.HasPrefix(template, "rebuild") {
+			return template != "rebuild" && comment == fmt.Sprintf("%s %s", fingerprint, template)
+		}
+		return len(fields) > 0 && fields[0] == fingerprint
+	}
+
+	db := sqlx.MustConnect(driver, adminConnectionString())
+	defer db.Close()
+	// Session level locks need a single connection
+	db.SetMaxOpenConns(1)
+	// Packages tested in parallel share the template
+	// database, which is built by the first of them.
+	db.MustExec("SELECT pg_advisory_lock(hashtext($1))", templateName)
+	defer db.MustExec("SELECT pg_advisory_unlock(hashtext($1))", templateName)
+	var comment sql.NullString
+	err := db.Get(&comment, "SELECT shobj_description(oid, 'pg_database') FROM pg_database WHERE datname = $1", templateName)
+	build := true
+	switch {
+	case err == sql.ErrNoRows:
+		fmt.Printf("Creating template database %s\n", templateName)
+	case err != nil:
+		panic(fmt.Errorf("unable to read template database %s: %s", templateName, err))
+	case !upToDate(comment.String):
+		fmt.Printf("Rebuilding template database %s\n", templateName)
+		db.MustExec(fmt.Sprintf("DROP DATABASE %s", templateName))
+	default:
+		build = false
+	}
+	if build {
+		db.MustExec(fmt.Sprintf("CREATE DATABASE %s", templateName))
+		connectTestDatabase(templateName)
+		models.SyncDatabase()
+		server.LoadDataRecords()
+		server.LoadDemoRecords()
+		// No connection to the template may remain open for it to be copied
+		models.DBClose()
+		newComment := fingerprint
+		if strings.HasPrefix(template, "rebuild") {
+			newComment = fmt.Sprintf("%s %s", fingerprint, template)
+		}
+		db.MustExec(fmt.Sprintf("COMMENT ON DATABASE %s IS '%s'", templateName, newComment))
+	}
+	db.MustExec(fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", dbName, templateName))
+
+	connectTestDatabase(dbName)
+	// The schema is already up to date, but Init methods of the models must be run
+	models.SyncDatabase()
+}
 
+// templateFingerprint returns a hash of the models schema, of the data,
+// resources and demo files of the modules and of the selected demo tags.
+// A template database is outdated if its fingerprint differs.
+func templateFingerprint() string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, models.SchemaFingerprint(driver))
+	fmt.Fprintln(hash, strings.Join(server.DemoTags, ","))
+	for _, mod := range server.Modules {
+		for _, dir := range []string{"data", "resources", "demo"} {
+			dataFiles, _ := filepath.Glob(filepath.Join(generate.DoxaDir, "doxa", "server", dir, mod.Name, "*"))
+			sort.Strings(dataFiles)
+			for _, dataFile := range dataFiles {
+				content, err := ioutil.ReadFile(dataFile)
+				if err != nil {
+					panic(fmt.Errorf("unable to read data file %s: %s", dataFile, err))
+				}
+				fmt.Fprintf(hash, "%s %x\n", dataFile, sha256.Sum256(content))
+			}
+		}
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// connectTestDatabase connects the models to the given test database
+func connectTestDatabase(dbName string) {
 	models.DBConnect(driver, models.ConnectionParams{
+		Host:     host,
+		Port:     port,
 		DBName:   dbName,
 		User:     user,
 		Password: password,
 		SSLMode:  "disable",
 	})
-	models.BootStrap()
-	models.SyncDatabase()
-	server.LoadDataRecords()
-	server.LoadDemoRecords()
+}
 
-	server.PostInitModules()
+// setupFileStore sets the file store of attachments in a temporary
+// directory, which is removed by TearDownTests.
+func setupFileStore() {
+	var err error
+	fileStoreDir, err = ioutil.TempDir("", "doxa-filestore")
+	if err != nil {
+		panic(err)
+	}
+	models.FileStore = filestore.New(fileStoreDir)
 }
 
-// TearDownTests tears down the tests for the given module
+// TearDownTests tears down the tests for the given module.
+// The template database, if any, is kept for the next runs.
 func TearDownTests(moduleName string) {
 	models.DBClose()
+	os.RemoveAll(fileStoreDir)
 	fmt.Printf("Tearing down database for module %s\n", moduleName)
-	dbName := fmt.Sprintf("%s_%s_tests", prefix, moduleName)
-	db := sqlx.MustConnect(driver, fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", user, password))
+	db := sqlx.MustConnect(driver, adminConnectionString())
 	db.MustExec(fmt.Sprintf("DROP DATABASE %s", dbName))
 	db.Close()
 }
+
+// adminConnectionString returns the connection string to the postgres
+// database, through which the test databases are created and dropped.
+func adminConnectionString() string {
+	connString := fmt.Sprintf("dbname=postgres sslmode=disable user=%s password=%s", user, password)
+	if host != "" {
+		connString += fmt.Sprintf(" host=%s", host)
+		if port != "" {
+			connString += fmt.Sprintf(" port=%s", port)
+		}
+	}
+	return connString
+}
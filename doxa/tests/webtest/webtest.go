@@ -0,0 +1,297 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package webtest tests controllers through the HTTP server of the application,
+with the routes of the controllers registry and all the server middlewares.
+
+Requests are sent by a Session, which is either anonymous or authenticated as
+a given user, and keeps the cookies set by the server between requests:
+
+	func TestCourses(t *testing.T) {
+		Convey("Testing course controllers", t, func() {
+			srv := webtest.NewServer()
+			resp := srv.Session(security.SuperUserID).RPC("/openacademy/courses", map[string]interface{}{
+				"limit": 10,
+			})
+			So(resp, webtest.ShouldHaveStatus, http.StatusOK)
+			So(resp, webtest.ShouldHaveRPCResult, []string{"Go", "Python"})
+			So(srv.Session(0).RPC("/openacademy/courses", nil), webtest.ShouldHaveStatus, http.StatusUnauthorized)
+		})
+	}
+
+Requests are served in the goroutine of the test, so that they share the test
+transaction of tests.RunInTransaction.
+
+The models and the database must have been initialized, usually by
+tests.RunTests in the TestMain function of the package.
+*/
+package webtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labneco/doxa/doxa/controllers"
+	"github.com/labneco/doxa/doxa/server"
+	"github.com/labneco/doxa/doxa/tools/logging"
+)
+
+// loginPath is the route through which sessions are authenticated.
+// It is only registered by NewServer, in test binaries.
+const loginPath = "/webtest/login/:uid"
+
+var (
+	log       *logging.Logger
+	bootstrap sync.Once
+)
+
+// A Server is the HTTP server of the application under test
+type Server struct {
+	*server.Server
+}
+
+// NewServer returns the HTTP server of the application with the routes of
+// the controllers registry. Controllers must be added or extended before the
+// first call to NewServer, since routes are only created once per process.
+func NewServer() *Server {
+	bootstrap.Do(func() {
+		controllers.BootStrap()
+		srv := server.GetServer()
+		srv.Group("/").GET(loginPath, func(c *server.Context) {
+			uid, err := strconv.ParseInt(c.Param("uid"), 10, 64)
+			if err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+			sess := c.Session()
+			// The session key of server.Context.UID
+			sess.Set("uid", uid)
+			sess.Save()
+			c.Status(http.StatusNoContent)
+		})
+	})
+	return &Server{Server: server.GetServer()}
+}
+
+// Session returns a new Session on this server authenticated as the user with
+// the given uid, or an anonymous Session if uid is 0. Two-factor authentication
+// is bypassed.
+func (s *Server) Session(uid int64) *Session {
+	sess := &Session{
+		server:  s,
+		cookies: make(map[string]*http.Cookie),
+		Header:  make(http.Header),
+	}
+	if uid == 0 {
+		return sess
+	}
+	resp := sess.Get(strings.Replace(loginPath, ":uid", strconv.FormatInt(uid, 10), 1))
+	if resp.Code != http.StatusNoContent {
+		log.Panic("Unable to authenticate test session", "uid", uid, "status", resp.Code)
+	}
+	return sess
+}
+
+// A Session sends requests to a Server and keeps the cookies set by its
+// responses, such as the session cookie.
+type Session struct {
+	server  *Server
+	cookies map[string]*http.Cookie
+	rpcID   int64
+	// Header is added to all the requests of this Session
+	Header http.Header
+}
+
+// Do sends the given request to the server with the cookies and the
+// headers of this Session and returns the response.
+func (s *Session) Do(req *http.Request) *Response {
+	for key, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	for _, cookie := range s.cookies {
+		req.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	s.server.ServeHTTP(recorder, req)
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(s.cookies, cookie.Name)
+			continue
+		}
+		s.cookies[cookie.Name] = cookie
+	}
+	return &Response{ResponseRecorder: recorder}
+}
+
+// Request sends a request with the given method, path and body to the server.
+// The body is not sent if it is nil.
+func (s *Session) Request(method, path string, body io.Reader) *Response {
+	req := httptest.NewRequest(method, path, body)
+	return s.Do(req)
+}
+
+// Get sends a GET request for the given path to the server
+func (s *Session) Get(path string) *Response {
+	return s.Request(http.MethodGet, path, nil)
+}
+
+// PostJSON sends a POST request to the given path with
+// the given data marshalled as JSON as body.
+func (s *Session) PostJSON(path string, data interface{}) *Response {
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Panic("Unable to marshal request data", "path", path, "error", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return s.Do(req)
+}
+
+// RPC calls the JSON-RPC controller at the given path with the given params
+func (s *Session) RPC(path string, params interface{}) *Response {
+	if params == nil {
+		params = struct{}{}
+	}
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		log.Panic("Unable to marshal RPC params", "path", path, "error", err)
+	}
+	s.rpcID++
+	return s.PostJSON(path, server.RequestRPC{
+		JsonRPC: "2.0",
+		ID:      s.rpcID,
+		Method:  "call",
+		Params:  rawParams,
+	})
+}
+
+// A Response is the response of the server to a request of a Session
+type Response struct {
+	*httptest.ResponseRecorder
+}
+
+// rpcResponse is a JSON-RPC response with either a result or an error
+type rpcResponse struct {
+	ID     int64                `json:"id"`
+	Result json.RawMessage      `json:"result"`
+	Error  *server.JSONRPCError `json:"error"`
+}
+
+// decodeRPC returns the JSON-RPC response of r
+func (r *Response) decodeRPC() (rpcResponse, error) {
+	var res rpcResponse
+	if err := json.Unmarshal(r.Body.Bytes(), &res); err != nil {
+		return res, fmt.Errorf("response is not a JSON-RPC response: %s (body: %s)", err, r.Body.String())
+	}
+	return res, nil
+}
+
+// Result unmarshals the result of this JSON-RPC response into dest.
+// It returns an error if the response is not a JSON-RPC result.
+func (r *Response) Result(dest interface{}) error {
+	res, err := r.decodeRPC()
+	if err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return fmt.Errorf("JSON-RPC error %d: %s (data: %v)", res.Error.Code, res.Error.Message, res.Error.Data)
+	}
+	return json.Unmarshal(res.Result, dest)
+}
+
+// RPCError returns the error of this JSON-RPC response or nil
+// if the response is not a JSON-RPC error.
+func (r *Response) RPCError() *server.JSONRPCError {
+	res, err := r.decodeRPC()
+	if err != nil {
+		return nil
+	}
+	return res.Error
+}
+
+// ShouldHaveStatus asserts that the actual *Response
+// has the expected HTTP status code.
+func ShouldHaveStatus(actual interface{}, expected ...interface{}) string {
+	resp, msg := responseAndExpected(actual, expected)
+	if msg != "" {
+		return msg
+	}
+	if resp.Code != expected[0] {
+		return fmt.Sprintf("Expected status %v but got %d (body: %s)", expected[0], resp.Code, resp.Body.String())
+	}
+	return ""
+}
+
+// ShouldHaveRPCResult asserts that the actual *Response is a JSON-RPC
+// result equal to the expected value once both are marshalled to JSON.
+func ShouldHaveRPCResult(actual interface{}, expected ...interface{}) string {
+	resp, msg := responseAndExpected(actual, expected)
+	if msg != "" {
+		return msg
+	}
+	var result interface{}
+	if err := resp.Result(&result); err != nil {
+		return err.Error()
+	}
+	data, err := json.Marshal(expected[0])
+	if err != nil {
+		return fmt.Sprintf("Unable to marshal the expected result: %s", err)
+	}
+	var expectedResult interface{}
+	json.Unmarshal(data, &expectedResult)
+	if !reflect.DeepEqual(result, expectedResult) {
+		return fmt.Sprintf("Expected result %s but got %s", data, resp.Body.String())
+	}
+	return ""
+}
+
+// ShouldHaveRPCError asserts that the actual *Response is a JSON-RPC error
+// whose arguments contain the expected message.
+func ShouldHaveRPCError(actual interface{}, expected ...interface{}) string {
+	resp, msg := responseAndExpected(actual, expected)
+	if msg != "" {
+		return msg
+	}
+	rpcErr := resp.RPCError()
+	if rpcErr == nil {
+		return fmt.Sprintf("Expected a JSON-RPC error but got %s", resp.Body.String())
+	}
+	data, _ := json.Marshal(rpcErr.Data)
+	var errData server.JSONRPCErrorData
+	json.Unmarshal(data, &errData)
+	for _, arg := range errData.Arguments {
+		if strings.Contains(arg, fmt.Sprint(expected[0])) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Expected a JSON-RPC error with message %q but got %s", expected[0], resp.Body.String())
+}
+
+// responseAndExpected returns the actual value of an assertion as a *Response
+// or a failure message if it is not a *Response or if there is not exactly one
+// expected value.
+func responseAndExpected(actual interface{}, expected []interface{}) (*Response, string) {
+	resp, ok := actual.(*Response)
+	if !ok {
+		return nil, fmt.Sprintf("Expected a *webtest.Response but got %T", actual)
+	}
+	if len(expected) != 1 {
+		return nil, fmt.Sprintf("This assertion requires exactly 1 expected value (you provided %d)", len(expected))
+	}
+	return resp, ""
+}
+
+func init() {
+	log = logging.GetLogger("webtest")
+}
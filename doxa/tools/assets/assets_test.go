@@ -6,6 +6,8 @@ package assets
 import (
 	"bytes"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -24,3 +26,88 @@ func TestCompileLessFiles(t *testing.T) {
 	})
 
 }
+
+func TestMinify(t *testing.T) {
+	Convey("Testing CSS minification", t, func() {
+		css := "/* comment */\n.a  .b,\n.c {\n  color: red;\n  content: \"a  /* b */\";\n}\n/*! license */\n"
+		So(MinifyCSS(css), ShouldEqual, `.a .b,.c{color:red;content:"a  /* b */"}/*! license */`)
+	})
+	Convey("Testing JavaScript minification", t, func() {
+		js := "// comment\nvar a = 1;  /* block */\n\n    var s = 'x // y';\nvar r = /a\\/b[/]/g; // regexp\nvar d = a / 2 / 1;\nfunction f() { return /\\//; }\n"
+		So(MinifyJS(js), ShouldEqual, "var a = 1;\nvar s = 'x // y';\nvar r = /a\\/b[/]/g;\nvar d = a / 2 / 1;\nfunction f() { return /\\//; }\n")
+	})
+}
+
+func TestBuildBundle(t *testing.T) {
+	Convey("Testing bundle building", t, func() {
+		dir, err := ioutil.TempDir("", "doxa-bundle")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		first := filepath.Join(dir, "first.js")
+		second := filepath.Join(dir, "second.js")
+		So(ioutil.WriteFile(first, []byte("var a = 1"), 0644), ShouldBeNil)
+		So(ioutil.WriteFile(second, []byte("var b = a;\n"), 0644), ShouldBeNil)
+		outDir := filepath.Join(dir, "out")
+		Convey("Files should be concatenated in the given order with a hashed name", func() {
+			fileName, err := BuildBundle("web", JS, []string{first, second}, outDir, true)
+			So(err, ShouldBeNil)
+			So(fileName, ShouldStartWith, "web.")
+			So(fileName, ShouldEndWith, ".js")
+			So(fileName, ShouldHaveLength, len("web..js")+HashLength)
+			content, err := ioutil.ReadFile(filepath.Join(outDir, fileName))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "var a = 1\n;\nvar b = a;\n;\n")
+			Convey("The name should only change with the content", func() {
+				same, _ := BuildBundle("web", JS, []string{first, second}, outDir, true)
+				So(same, ShouldEqual, fileName)
+				reversed, _ := BuildBundle("web", JS, []string{second, first}, outDir, true)
+				So(reversed, ShouldNotEqual, fileName)
+			})
+		})
+		Convey("Missing files should return an error", func() {
+			_, err := BuildBundle("web", JS, []string{filepath.Join(dir, "missing.js")}, outDir, true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFlipCSS(t *testing.T) {
+	Convey("Testing right-to-left CSS flipping", t, func() {
+		Convey("Left and right should be swapped in property names and values", func() {
+			So(FlipCSS(".a { margin-left: 2px; float: left; text-align: right !important }"), ShouldEqual,
+				".a { margin-right: 2px; float: right; text-align: left !important }")
+			So(FlipCSS(".a{left:0;border-top-right-radius:3px;direction:ltr;cursor:ne-resize}"), ShouldEqual,
+				".a{right:0;border-top-left-radius:3px;direction:rtl;cursor:nw-resize}")
+		})
+		Convey("Four values box shorthands and border radius should be flipped", func() {
+			So(FlipCSS(".a{padding:1px 2px 3px 4px;margin:1px 2px;border-width:calc(1px + 1px) 0 0 1px}"), ShouldEqual,
+				".a{padding:1px 4px 3px 2px;margin:1px 2px;border-width:calc(1px + 1px) 1px 0 0}")
+			So(FlipCSS(".a{border-radius:1px 2px 3px 4px / 5px 6px}"), ShouldEqual,
+				".a{border-radius:2px 1px 4px 3px / 6px 5px}")
+			So(FlipCSS(".a{border-radius:1px 2px 3px}"), ShouldEqual, ".a{border-radius:2px 1px 2px 3px}")
+		})
+		Convey("Selectors, strings, urls and ignored declarations should be kept", func() {
+			src := `/* left */ .left:hover > a[title="left"] { content: "left; right"; background: url(data:image/png;base64,left) left; }
+@media (max-width: 10px) { .b { /* spacing */ padding-right: 1px; /* rtl:ignore */ margin-left: 1px } }`
+			So(FlipCSS(src), ShouldEqual, `/* left */ .left:hover > a[title="left"] { content: "left; right"; background: url(data:image/png;base64,left) left; }
+@media (max-width: 10px) { .b { /* spacing */ padding-left: 1px; /* rtl:ignore */ margin-left: 1px } }`)
+		})
+	})
+}
+
+func TestBuildRTLBundle(t *testing.T) {
+	Convey("Testing right-to-left bundle building", t, func() {
+		dir, err := ioutil.TempDir("", "doxa-bundle")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "style.css")
+		So(ioutil.WriteFile(file, []byte(".a { margin-left: 1px; }\n"), 0644), ShouldBeNil)
+		fileName, err := BuildRTLBundle("web", []string{file}, dir, true)
+		So(err, ShouldBeNil)
+		So(fileName, ShouldStartWith, "web.rtl.")
+		So(fileName, ShouldEndWith, ".css")
+		content, err := ioutil.ReadFile(filepath.Join(dir, fileName))
+		So(err, ShouldBeNil)
+		So(string(content), ShouldEqual, ".a{margin-right:1px}")
+	})
+}
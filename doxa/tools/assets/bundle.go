@@ -0,0 +1,269 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bundle types
+const (
+	JS  = "js"
+	CSS = "css"
+)
+
+// HashLength is the number of hexadecimal characters of the content
+// hash inserted in the file names of bundles.
+const HashLength = 12
+
+// BundleExtensions returns the file extensions of the source
+// files that can be included in a bundle of the given type.
+func BundleExtensions(bundleType string) []string {
+	switch bundleType {
+	case JS:
+		return []string{".js"}
+	case CSS:
+		return []string{".css", ".less"}
+	}
+	return nil
+}
+
+// BuildBundle concatenates the given files in the given order into a single
+// file of the given type ("js" or "css") in outDir and returns its name.
+// Less files are compiled into CSS.
+//
+// The file name is '<name>.<hash>.<type>' where hash depends on the content
+// of the bundle, so that it can be served with far-future cache headers.
+// If minify is true, the content of the bundle is minified.
+func BuildBundle(name, bundleType string, files []string, outDir string, minify bool) (string, error) {
+	return buildBundle(name, bundleType, files, outDir, minify, false)
+}
+
+// BuildRTLBundle builds the right-to-left variant of the CSS bundle made of
+// the given files, flipped with FlipCSS, in outDir and returns its name, which
+// is '<name>.rtl.<hash>.css'. It is otherwise the same as BuildBundle.
+func BuildRTLBundle(name string, files []string, outDir string, minify bool) (string, error) {
+	return buildBundle(name+".rtl", CSS, files, outDir, minify, true)
+}
+
+// buildBundle builds the bundle of the given files as BuildBundle does.
+// If flip is true, the content of the bundle is flipped with FlipCSS.
+func buildBundle(name, bundleType string, files []string, outDir string, minify, flip bool) (string, error) {
+	var buf bytes.Buffer
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		if filepath.Ext(file) == ".less" {
+			var css bytes.Buffer
+			if err := CompileLess(bytes.NewReader(content), &css, filepath.Dir(file)); err != nil {
+				return "", fmt.Errorf("%s: %s", file, err)
+			}
+			content = css.Bytes()
+		}
+		fmt.Fprintf(&buf, "/* %s */\n", filepath.Base(file))
+		buf.Write(content)
+		if bundleType == JS {
+			// Protect against files without trailing semicolon
+			buf.WriteString("\n;")
+		}
+		buf.WriteString("\n")
+	}
+	content := buf.String()
+	if flip {
+		content = FlipCSS(content)
+	}
+	if minify {
+		switch bundleType {
+		case JS:
+			content = MinifyJS(content)
+		case CSS:
+			content = MinifyCSS(content)
+		}
+	}
+	hash := sha256.Sum256([]byte(content))
+	fileName := fmt.Sprintf("%s.%s.%s", name, hex.EncodeToString(hash[:])[:HashLength], bundleType)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, fileName), []byte(content), 0644); err != nil {
+		return "", err
+	}
+	log.Debug("Asset bundle built", "name", name, "file", fileName, "sources", len(files))
+	return fileName, nil
+}
+
+// skipString appends to out the string literal starting at src[i]
+// and returns the index of the character following it.
+func skipString(src string, i int, out *[]byte) int {
+	quote := src[i]
+	*out = append(*out, quote)
+	for i++; i < len(src); i++ {
+		*out = append(*out, src[i])
+		switch src[i] {
+		case '\\':
+			if i+1 < len(src) {
+				i++
+				*out = append(*out, src[i])
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return i
+}
+
+// skipComment appends to out the block comment starting at src[i] if it
+// must be kept and returns the index of the character following it.
+func skipComment(src string, i int, out *[]byte) int {
+	end := strings.Index(src[i+2:], "*/")
+	if end < 0 {
+		return len(src)
+	}
+	end += i + 4
+	if src[i+2] == '!' {
+		*out = append(*out, src[i:end]...)
+	}
+	return end
+}
+
+// isSpace returns true if c is a white space character
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// last returns the last byte of out or 0 if out is empty
+func last(out []byte) byte {
+	if len(out) == 0 {
+		return 0
+	}
+	return out[len(out)-1]
+}
+
+// cssSeparators are the characters around which no space is needed in CSS
+const cssSeparators = "{};:,>"
+
+// MinifyCSS removes comments and unnecessary white spaces from the given CSS.
+// Comments starting with '/*!' (e.g. licenses) are kept.
+func MinifyCSS(src string) string {
+	var out []byte
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			i = skipString(src, i, &out)
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			i = skipComment(src, i, &out)
+		case isSpace(c):
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+			if i < len(src) && len(out) > 0 && strings.IndexByte(cssSeparators, last(out)) < 0 &&
+				strings.IndexByte(cssSeparators, src[i]) < 0 {
+				out = append(out, ' ')
+			}
+		case c == '}' && last(out) == ';':
+			out[len(out)-1] = c
+			i++
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return string(out)
+}
+
+// regexpAllowedAfter lists the characters after which a '/' starts a
+// regular expression literal instead of being a division operator.
+const regexpAllowedAfter = "(,=:[!&|?{};+-*%<>~^\n"
+
+// regexpAllowedAfterKeywords lists the keywords after which
+// a '/' starts a regular expression literal.
+var regexpAllowedAfterKeywords = []string{"return", "typeof", "case", "do", "else", "in", "void", "throw", "delete"}
+
+// regexpAllowed returns true if a '/' following out starts a regular expression literal
+func regexpAllowed(out []byte) bool {
+	if len(out) == 0 || strings.IndexByte(regexpAllowedAfter, last(out)) >= 0 {
+		return true
+	}
+	for _, kw := range regexpAllowedAfterKeywords {
+		if !bytes.HasSuffix(out, []byte(kw)) {
+			continue
+		}
+		if len(out) == len(kw) {
+			return true
+		}
+		prev := out[len(out)-len(kw)-1]
+		if !(prev == '_' || prev == '$' || prev == '.' || prev >= '0' && prev <= '9' || prev >= 'a' && prev <= 'z' || prev >= 'A' && prev <= 'Z') {
+			return true
+		}
+	}
+	return false
+}
+
+// MinifyJS removes comments, blank lines and indentation from the given
+// JavaScript. Line breaks are kept so that automatic semicolon insertion
+// is not affected. Comments starting with '/*!' (e.g. licenses) are kept.
+func MinifyJS(src string) string {
+	var out []byte
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			i = skipString(src, i, &out)
+		case c == '/' && strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			i = skipComment(src, i, &out)
+		case c == '/' && regexpAllowed(bytes.TrimRight(out, " ")):
+			// Regular expression literal
+			out = append(out, c)
+			inClass := false
+		regexp:
+			for i++; i < len(src) && src[i] != '\n'; i++ {
+				out = append(out, src[i])
+				switch {
+				case src[i] == '\\' && i+1 < len(src):
+					i++
+					out = append(out, src[i])
+				case src[i] == '[':
+					inClass = true
+				case src[i] == ']':
+					inClass = false
+				case src[i] == '/' && !inClass:
+					i++
+					break regexp
+				}
+			}
+		case isSpace(c):
+			newLine := false
+			for i < len(src) && isSpace(src[i]) {
+				newLine = newLine || src[i] == '\n'
+				i++
+			}
+			out = bytes.TrimRight(out, " ")
+			switch {
+			case len(out) == 0 || last(out) == '\n':
+			case newLine:
+				out = append(out, '\n')
+			default:
+				out = append(out, ' ')
+			}
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return strings.TrimSpace(string(out)) + "\n"
+}
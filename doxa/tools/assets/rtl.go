@@ -0,0 +1,239 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package assets
+
+import (
+	"strings"
+)
+
+// RTLIgnoreDirective is the comment directive which prevents
+// FlipCSS from flipping the declaration it is placed in.
+const RTLIgnoreDirective = "rtl:ignore"
+
+// rtlSwappedKeywords maps the keywords of flipped values to their opposite
+var rtlSwappedKeywords = map[string]string{
+	"left":      "right",
+	"right":     "left",
+	"ltr":       "rtl",
+	"rtl":       "ltr",
+	"e-resize":  "w-resize",
+	"w-resize":  "e-resize",
+	"ne-resize": "nw-resize",
+	"nw-resize": "ne-resize",
+	"se-resize": "sw-resize",
+	"sw-resize": "se-resize",
+}
+
+// rtlKeywordProperties are the properties whose keyword values are flipped
+var rtlKeywordProperties = map[string]bool{
+	"float":           true,
+	"clear":           true,
+	"text-align":      true,
+	"text-align-last": true,
+	"direction":       true,
+	"cursor":          true,
+}
+
+// rtlBoxProperties are the shorthand properties whose values
+// are given in top, right, bottom, left order.
+var rtlBoxProperties = map[string]bool{
+	"margin":        true,
+	"padding":       true,
+	"border-width":  true,
+	"border-style":  true,
+	"border-color":  true,
+	"inset":         true,
+	"scroll-margin": true,
+}
+
+// FlipCSS returns the right-to-left variant of the given left-to-right CSS,
+// in the way of rtlcss:
+//
+//   - 'left' and 'right' are swapped in property names (e.g. margin-left,
+//     border-top-right-radius or left),
+//   - 'left' and 'right' are swapped in the values of float, clear and
+//     text-align, 'ltr' and 'rtl' in the value of direction and east and west
+//     resize cursors in the value of cursor,
+//   - the right and left values of box shorthands with four values, such as
+//     margin, padding or border-width, are swapped, as well as the corners
+//     of border-radius.
+//
+// Declarations that contain a '/* rtl:ignore */' comment are left untouched.
+// Selectors, strings, comments and url() values are never modified.
+func FlipCSS(src string) string {
+	var out strings.Builder
+	out.Grow(len(src))
+	start, depth := 0, 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			i = skipString(src, i, new([]byte))
+			continue
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			i = skipComment(src, i, new([]byte))
+			continue
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case (c == ';' || c == '}') && depth == 0:
+			out.WriteString(flipDeclaration(src[start:i]))
+			out.WriteByte(c)
+			start = i + 1
+		case c == '{' && depth == 0:
+			out.WriteString(src[start : i+1])
+			start = i + 1
+		}
+		i++
+	}
+	out.WriteString(src[start:])
+	return out.String()
+}
+
+// flipDeclaration returns the right-to-left variant of the given
+// declaration. Text which is not a declaration is returned as is.
+func flipDeclaration(decl string) string {
+	if strings.Contains(decl, RTLIgnoreDirective) {
+		return decl
+	}
+	// Skip the white spaces and comments preceding the property name
+	nameStart := 0
+	for {
+		rest := strings.TrimLeft(decl[nameStart:], " \t\n\r\f")
+		nameStart = len(decl) - len(rest)
+		if !strings.HasPrefix(rest, "/*") {
+			break
+		}
+		end := strings.Index(rest, "*/")
+		if end < 0 {
+			return decl
+		}
+		nameStart += end + 2
+	}
+	colon := strings.IndexByte(decl[nameStart:], ':')
+	if colon < 0 {
+		return decl
+	}
+	colon += nameStart
+	name := strings.TrimRight(decl[nameStart:colon], " \t\n\r\f")
+	if name == "" || strings.ContainsAny(name, " \t\n\r\f/\"'(") {
+		return decl
+	}
+	prop := strings.ToLower(name)
+	value := decl[colon+1:]
+	switch {
+	case rtlKeywordProperties[prop]:
+		value = mapCSSValueTokens(value, func(tokens []string) []string {
+			for i, tok := range tokens {
+				if swapped, ok := rtlSwappedKeywords[strings.ToLower(tok)]; ok {
+					tokens[i] = swapped
+				}
+			}
+			return tokens
+		})
+	case rtlBoxProperties[prop]:
+		value = mapCSSValueTokens(value, func(tokens []string) []string {
+			if len(tokens) == 4 {
+				tokens[1], tokens[3] = tokens[3], tokens[1]
+			}
+			return tokens
+		})
+	case prop == "border-radius":
+		value = flipBorderRadius(value)
+	}
+	return decl[:nameStart] + flipPropertyName(name) + decl[nameStart+len(name):colon+1] + value
+}
+
+// flipPropertyName returns the given property name with 'left' and 'right' swapped
+func flipPropertyName(name string) string {
+	parts := strings.Split(name, "-")
+	for i, part := range parts {
+		switch strings.ToLower(part) {
+		case "left":
+			parts[i] = "right"
+		case "right":
+			parts[i] = "left"
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// flipBorderRadius returns the right-to-left variant of the given
+// border-radius value, which may have horizontal and vertical radii
+// separated by a slash.
+func flipBorderRadius(value string) string {
+	radii := strings.Split(value, "/")
+	for i, radius := range radii {
+		radii[i] = mapCSSValueTokens(radius, func(tokens []string) []string {
+			switch len(tokens) {
+			case 2:
+				return []string{tokens[1], tokens[0]}
+			case 3:
+				return []string{tokens[1], tokens[0], tokens[1], tokens[2]}
+			case 4:
+				return []string{tokens[1], tokens[0], tokens[3], tokens[2]}
+			}
+			return tokens
+		})
+	}
+	return strings.Join(radii, "/")
+}
+
+// mapCSSValueTokens applies fnct to the white space separated tokens of the
+// given value, a trailing '!important' excluded, and returns the value with
+// the tokens returned by fnct. Parenthesized groups such as calc() or url()
+// are single tokens. The value is returned as is if fnct does not change it.
+func mapCSSValueTokens(value string, fnct func([]string) []string) string {
+	trimmed := strings.TrimSpace(value)
+	suffix := ""
+	if idx := strings.Index(trimmed, "!"); idx >= 0 {
+		trimmed, suffix = strings.TrimSpace(trimmed[:idx]), " "+trimmed[idx:]
+	}
+	tokens := splitCSSValue(trimmed)
+	mapped := fnct(append([]string(nil), tokens...))
+	if strings.Join(mapped, " ") == strings.Join(tokens, " ") {
+		return value
+	}
+	leading := value[:len(value)-len(strings.TrimLeft(value, " \t\n\r\f"))]
+	trailing := value[len(strings.TrimRight(value, " \t\n\r\f")):]
+	return leading + strings.Join(mapped, " ") + suffix + trailing
+}
+
+// splitCSSValue splits the given value on white spaces
+// which are not inside parentheses or strings.
+func splitCSSValue(value string) []string {
+	var (
+		tokens []string
+		depth  int
+		start  = -1
+	)
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\'':
+			if start < 0 {
+				start = i
+			}
+			i = skipString(value, i, new([]byte)) - 1
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case isSpace(c) && depth == 0:
+			if start >= 0 {
+				tokens = append(tokens, value[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, value[start:])
+	}
+	return tokens
+}
@@ -4,6 +4,9 @@
 /*
 Package b64image provides helper functions for manipulating
 base64 encoded PNG or JPEG images
+
+JPEG images are rotated and flipped according to their EXIF orientation
+before being resized or cropped, so that results are always upright.
 */
 package b64image
 
@@ -4,9 +4,11 @@
 package b64image
 
 import (
+	"bytes"
 	"encoding/base64"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"io/ioutil"
 	"strings"
 	"testing"
@@ -64,3 +66,172 @@ func TestColorize(t *testing.T) {
 		})
 	})
 }
+
+// decodeImage returns the image and the format of the given base64 encoded image
+func decodeImage(imgString string) (image.Image, string) {
+	img, format, _ := image.Decode(base64.NewDecoder(base64.StdEncoding, strings.NewReader(imgString)))
+	return img, format
+}
+
+func TestResize(t *testing.T) {
+	Convey("Testing image resizing functions", t, func() {
+		imgData, _ := ioutil.ReadFile("testdata/avatar.png")
+		imgString := base64.StdEncoding.EncodeToString(imgData)
+		Convey("Resizing to given dimensions", func() {
+			destImg, format := decodeImage(Resize(imgString, 90, 45))
+			So(format, ShouldEqual, "png")
+			So(destImg.Bounds().Dx(), ShouldEqual, 90)
+			So(destImg.Bounds().Dy(), ShouldEqual, 45)
+			destImg, _ = decodeImage(Resize(imgString, 90, 90))
+			// Average of the pixels from 90,90 to 91,91 of the original
+			So(ColorsEqual(destImg.At(45, 45), color.RGBA{R: 217, G: 221, B: 226, A: 255}), ShouldBeTrue)
+		})
+		Convey("Resizing with a single dimension should keep the aspect ratio", func() {
+			destImg, _ := decodeImage(Resize(imgString, 60, 0))
+			So(destImg.Bounds().Dx(), ShouldEqual, 60)
+			So(destImg.Bounds().Dy(), ShouldEqual, 60)
+			destImg, _ = decodeImage(Resize(imgString, 0, 360))
+			So(destImg.Bounds().Dx(), ShouldEqual, 360)
+			So(destImg.Bounds().Dy(), ShouldEqual, 360)
+			So(Resize(imgString, 0, 0), ShouldEqual, imgString)
+		})
+		Convey("Fitting should scale down images keeping their aspect ratio", func() {
+			destImg, _ := decodeImage(Fit(imgString, 100, 50))
+			So(destImg.Bounds().Dx(), ShouldEqual, 50)
+			So(destImg.Bounds().Dy(), ShouldEqual, 50)
+			destImg, _ = decodeImage(Fit(imgString, 0, 120))
+			So(destImg.Bounds().Dx(), ShouldEqual, 120)
+			So(Fit(imgString, 200, 200), ShouldEqual, imgString)
+		})
+		Convey("Cropping should return thumbnails of the given dimensions", func() {
+			destImg, _ := decodeImage(Crop(imgString, 40, 20))
+			So(destImg.Bounds().Dx(), ShouldEqual, 40)
+			So(destImg.Bounds().Dy(), ShouldEqual, 20)
+			So(Crop(imgString, 40, 0), ShouldEqual, imgString)
+		})
+		Convey("Results should be encoded with the given options", func() {
+			high := Fit(imgString, 200, 200, AsJPEG(), WithQuality(95))
+			low := Fit(imgString, 200, 200, AsJPEG(), WithQuality(10))
+			destImg, format := decodeImage(low)
+			So(format, ShouldEqual, "jpeg")
+			So(destImg.Bounds().Dx(), ShouldEqual, 180)
+			So(len(low), ShouldBeLessThan, len(high))
+			_, format = decodeImage(Resize(high, 90, 90))
+			So(format, ShouldEqual, "jpeg")
+			_, format = decodeImage(Resize(high, 90, 90, AsPNG()))
+			So(format, ShouldEqual, "png")
+		})
+		Convey("Unreadable image should be returned as is", func() {
+			So(Resize("foo bar", 10, 10), ShouldEqual, "foo bar")
+			So(Fit("foo bar", 10, 10), ShouldEqual, "foo bar")
+			So(Crop("foo bar", 10, 10), ShouldEqual, "foo bar")
+		})
+	})
+}
+
+// withOrientation returns the given JPEG data with an EXIF
+// segment holding the given orientation.
+func withOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, 0, 0, 0, 0, 0, 0}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	res := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}
+	res = append(res, segment...)
+	return append(res, data[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	Convey("Testing EXIF orientation normalization", t, func() {
+		// 40x20 image with a red left half and a blue right half
+		src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 40; x++ {
+				src.Set(x, y, color.RGBA{B: 255, A: 255})
+				if x < 20 {
+					src.Set(x, y, color.RGBA{R: 255, A: 255})
+				}
+			}
+		}
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, src, &jpeg.Options{Quality: 100})
+		isRed := func(clr color.Color) bool {
+			r, _, b, _ := clr.RGBA()
+			return r > b
+		}
+		Convey("Orientation should be read from EXIF data", func() {
+			So(exifOrientation(buf.Bytes()), ShouldEqual, 1)
+			So(exifOrientation(withOrientation(buf.Bytes(), 6)), ShouldEqual, 6)
+			So(exifOrientation(withOrientation(buf.Bytes(), 9)), ShouldEqual, 1)
+			So(exifOrientation([]byte("foo bar")), ShouldEqual, 1)
+		})
+		Convey("Images rotated by 90° should be normalized", func() {
+			imgString := base64.StdEncoding.EncodeToString(withOrientation(buf.Bytes(), 6))
+			destImg, format := decodeImage(Normalize(imgString))
+			So(format, ShouldEqual, "jpeg")
+			So(destImg.Bounds().Dx(), ShouldEqual, 20)
+			So(destImg.Bounds().Dy(), ShouldEqual, 40)
+			So(isRed(destImg.At(10, 5)), ShouldBeTrue)
+			So(isRed(destImg.At(10, 35)), ShouldBeFalse)
+		})
+		Convey("Images rotated by 180° should be normalized", func() {
+			imgString := base64.StdEncoding.EncodeToString(withOrientation(buf.Bytes(), 3))
+			destImg, _ := decodeImage(Normalize(imgString))
+			So(destImg.Bounds().Dx(), ShouldEqual, 40)
+			So(isRed(destImg.At(35, 10)), ShouldBeTrue)
+			So(isRed(destImg.At(5, 10)), ShouldBeFalse)
+		})
+		Convey("Orientation should be applied before resizing", func() {
+			imgString := base64.StdEncoding.EncodeToString(withOrientation(buf.Bytes(), 8))
+			destImg, _ := decodeImage(Resize(imgString, 10, 0))
+			So(destImg.Bounds().Dx(), ShouldEqual, 10)
+			So(destImg.Bounds().Dy(), ShouldEqual, 20)
+			So(isRed(destImg.At(5, 15)), ShouldBeTrue)
+			So(Fit(imgString, 100, 100), ShouldNotEqual, imgString)
+		})
+		Convey("Metadata should be stripped", func() {
+			imgString := base64.StdEncoding.EncodeToString(withOrientation(buf.Bytes(), 1))
+			data, _ := base64.StdEncoding.DecodeString(Normalize(imgString))
+			So(bytes.Contains(data, []byte("Exif")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestValidation(t *testing.T) {
+	Convey("Testing image validation and conversion", t, func() {
+		imgData, _ := ioutil.ReadFile("testdata/avatar.png")
+		imgString := base64.StdEncoding.EncodeToString(imgData)
+		Convey("Format should be detected from the content", func() {
+			format, err := Format(imgString)
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, "png")
+			_, err = Format(base64.StdEncoding.EncodeToString([]byte("<svg></svg>")))
+			So(err, ShouldHaveSameTypeAs, InvalidImageError(""))
+			_, err = Format("foo bar")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Images should be checked against limits", func() {
+			So(Check(imgString, Limits{}), ShouldBeNil)
+			So(Check(imgString, Limits{Formats: []string{"png"}, MaxWidth: 180, MaxHeight: 180, MaxSize: len(imgData)}), ShouldBeNil)
+			So(Check(imgString, Limits{Formats: []string{"jpeg", "gif"}}), ShouldNotBeNil)
+			So(Check(imgString, Limits{MaxWidth: 100}), ShouldNotBeNil)
+			So(Check(imgString, Limits{MaxHeight: 100}), ShouldNotBeNil)
+			So(Check(imgString, Limits{MaxSize: 100}), ShouldNotBeNil)
+			So(Check("foo bar", Limits{}), ShouldNotBeNil)
+		})
+		Convey("Images should be converted to the given format", func() {
+			res, err := Convert(imgString, "jpeg", WithQuality(50))
+			So(err, ShouldBeNil)
+			destImg, format := decodeImage(res)
+			So(format, ShouldEqual, "jpeg")
+			So(destImg.Bounds().Dx(), ShouldEqual, 180)
+			res, err = Convert(res, "png")
+			So(err, ShouldBeNil)
+			_, format = decodeImage(res)
+			So(format, ShouldEqual, "png")
+			res, err = Convert(imgString, "png")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, imgString)
+			_, err = Convert(imgString, "webp")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package b64image
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// An Option modifies the encoding of the images returned by
+// Resize, Fit, Crop and Normalize.
+type Option func(*encoding)
+
+// encoding holds the parameters of the encoding of a result image
+type encoding struct {
+	format  string
+	quality int
+}
+
+// AsPNG encodes the result as a PNG image.
+func AsPNG() Option {
+	return func(e *encoding) {
+		e.format = "png"
+	}
+}
+
+// AsJPEG encodes the result as a JPEG image. Transparent
+// parts of the image are drawn on a white background.
+func AsJPEG() Option {
+	return func(e *encoding) {
+		e.format = "jpeg"
+	}
+}
+
+// WithQuality sets the quality of JPEG results, from 1 to 100.
+// It defaults to jpeg.DefaultQuality.
+func WithQuality(quality int) Option {
+	return func(e *encoding) {
+		e.quality = quality
+	}
+}
+
+// Resize scales the original image to the given dimensions and returns the
+// result. If width or height is 0, it is computed from the other one so as
+// to keep the aspect ratio of the original.
+//
+// The original must be a base64 encoded image, either JPEG or PNG. The result
+// is a base64 encoded image of the same format, unless AsPNG or AsJPEG is given.
+// The original is returned as is if it cannot be read or if both dimensions are 0.
+func Resize(original string, width, height int, opts ...Option) string {
+	img, format, ok := decode(original, "resizing")
+	if !ok {
+		return original
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	switch {
+	case width <= 0 && height <= 0:
+		log.Warn("Unable to resize image without dimensions")
+		return original
+	case width <= 0:
+		width = scaledSize(w, float64(height)/float64(h))
+	case height <= 0:
+		height = scaledSize(h, float64(width)/float64(w))
+	}
+	return encode(scale(img, img.Bounds(), width, height), format, opts)
+}
+
+// Fit scales the original image down so that it fits in maxWidth x maxHeight,
+// keeping its aspect ratio, and returns the result. A dimension set to 0 is not
+// limited. Images that already fit are not scaled.
+//
+// The original must be a base64 encoded image, either JPEG or PNG. The result
+// is a base64 encoded image of the same format, unless AsPNG or AsJPEG is given.
+// The original is returned as is if it cannot be read, or if it fits, has
+// no EXIF orientation and no option is given.
+func Fit(original string, maxWidth, maxHeight int, opts ...Option) string {
+	img, format, orientation, ok := decodeOriented(original, "fitting")
+	if !ok {
+		return original
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	ratio := 1.0
+	if maxWidth > 0 && w > maxWidth {
+		ratio = float64(maxWidth) / float64(w)
+	}
+	if maxHeight > 0 && h > maxHeight {
+		ratio = math.Min(ratio, float64(maxHeight)/float64(h))
+	}
+	if ratio == 1 {
+		if len(opts) == 0 && orientation == 1 {
+			return original
+		}
+		return encode(img, format, opts)
+	}
+	return encode(scale(img, img.Bounds(), scaledSize(w, ratio), scaledSize(h, ratio)), format, opts)
+}
+
+// Crop returns a width x height thumbnail of the original image. The largest
+// centered part of the original with the aspect ratio of the thumbnail is
+// kept and scaled to the thumbnail dimensions.
+//
+// The original must be a base64 encoded image, either JPEG or PNG. The result
+// is a base64 encoded image of the same format, unless AsPNG or AsJPEG is given.
+// The original is returned as is if it cannot be read or if a dimension is 0.
+func Crop(original string, width, height int, opts ...Option) string {
+	img, format, ok := decode(original, "cropping")
+	if !ok {
+		return original
+	}
+	if width <= 0 || height <= 0 {
+		log.Warn("Unable to crop image without dimensions", "width", width, "height", height)
+		return original
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	cropW, cropH := w, scaledSize(w, float64(height)/float64(width))
+	if cropH > h {
+		cropW, cropH = scaledSize(h, float64(width)/float64(height)), h
+	}
+	minPt := bounds.Min.Add(image.Pt((w-cropW)/2, (h-cropH)/2))
+	rect := image.Rectangle{Min: minPt, Max: minPt.Add(image.Pt(cropW, cropH))}
+	return encode(scale(img, rect, width, height), format, opts)
+}
+
+// decode returns the image and the format of the given base64 encoded image.
+// The image is rotated and flipped according to its EXIF orientation, if any.
+// The last returned value is false if the image cannot be read, in which
+// case a warning about the given action is logged.
+func decode(original, action string) (image.Image, string, bool) {
+	img, format, _, ok := decodeOriented(original, action)
+	return img, format, ok
+}
+
+// decodeOriented is the same as decode, but also returns the EXIF
+// orientation of the image, which is 1 if it has none.
+func decodeOriented(original, action string) (image.Image, string, int, bool) {
+	data, err := base64.StdEncoding.DecodeString(original)
+	if err != nil {
+		log.Warn("Unable to read image for "+action, "error", err)
+		return nil, "", 0, false
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Warn("Unable to read image for "+action, "error", err)
+		return nil, "", 0, false
+	}
+	orientation := exifOrientation(data)
+	return orient(img, orientation), format, orientation, true
+}
+
+// encode returns the given image base64 encoded in the given format,
+// as modified by opts. Formats other than JPEG are encoded as PNG.
+func encode(img image.Image, format string, opts []Option) string {
+	enc := encoding{format: format, quality: jpeg.DefaultQuality}
+	for _, opt := range opts {
+		opt(&enc)
+	}
+	var buf bytes.Buffer
+	if enc.format != "jpeg" {
+		png.Encode(&buf, img)
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		bg := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		draw.Draw(bg, bg.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = bg
+	}
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: enc.quality})
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// scaledSize returns the given size multiplied by ratio, and at least 1.
+func scaledSize(size int, ratio float64) int {
+	res := int(math.Floor(float64(size)*ratio + 0.5))
+	if res < 1 {
+		return 1
+	}
+	return res
+}
+
+// scale returns the part of src given by rect scaled to width x height.
+// Each pixel of the result is the average of the pixels of src it covers.
+func scale(src image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	sw, sh := rect.Dx(), rect.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, rect.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := span(dy, height, sh)
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := span(dx, width, sw)
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					off := rgba.PixOffset(x, y)
+					for c := range sum {
+						sum[c] += int(rgba.Pix[off+c])
+					}
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			off := dst.PixOffset(dx, dy)
+			for c := range sum {
+				dst.Pix[off+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// span returns the range of the pixels of a source dimension of srcSize
+// pixels that are covered by the pixel i of a dimension of dstSize pixels.
+func span(i, dstSize, srcSize int) (int, int) {
+	start := i * srcSize / dstSize
+	end := (i + 1) * srcSize / dstSize
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
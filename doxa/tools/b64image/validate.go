@@ -0,0 +1,114 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package b64image
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	// Load gif driver for format detection
+	_ "image/gif"
+	"strings"
+)
+
+// An InvalidImageError is returned when an image does not comply with Limits
+type InvalidImageError string
+
+// Error returns the error message
+func (iie InvalidImageError) Error() string {
+	return "Invalid image: " + string(iie)
+}
+
+// Limits are the constraints that images must comply with.
+// Zero values are not checked.
+type Limits struct {
+	// Formats are the allowed formats, as returned by Format
+	Formats []string
+	// MaxWidth and MaxHeight are the maximum dimensions in pixels
+	MaxWidth  int
+	MaxHeight int
+	// MaxSize is the maximum size in bytes of the decoded image
+	MaxSize int
+}
+
+// Format returns the real format of the given base64 encoded image, as
+// detected from its content, i.e. "png", "jpeg" or "gif". It returns an
+// InvalidImageError if the content is not an image of a known format.
+func Format(original string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(original)
+	if err != nil {
+		return "", InvalidImageError("content is not base64 encoded")
+	}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", InvalidImageError("unknown image format")
+	}
+	return format, nil
+}
+
+// Check returns an InvalidImageError if the given base64 encoded
+// image does not comply with the given limits.
+func Check(original string, limits Limits) error {
+	data, err := base64.StdEncoding.DecodeString(original)
+	if err != nil {
+		return InvalidImageError("content is not base64 encoded")
+	}
+	if limits.MaxSize > 0 && len(data) > limits.MaxSize {
+		return InvalidImageError(fmt.Sprintf("size of %d bytes exceeds %d bytes", len(data), limits.MaxSize))
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return InvalidImageError("unknown image format")
+	}
+	if len(limits.Formats) > 0 && !formatAllowed(format, limits.Formats) {
+		return InvalidImageError(fmt.Sprintf("format %s is not one of %s", format, strings.Join(limits.Formats, ", ")))
+	}
+	if (limits.MaxWidth > 0 && config.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && config.Height > limits.MaxHeight) {
+		return InvalidImageError(fmt.Sprintf("dimensions %dx%d exceed %dx%d", config.Width, config.Height, limits.MaxWidth, limits.MaxHeight))
+	}
+	return nil
+}
+
+// Convert returns the given base64 encoded image converted to the given
+// format, which must be "png" or "jpeg". Images are rotated according to
+// their EXIF orientation. Images that are already in the given format are
+// returned as is, unless options are given.
+//
+// It returns an InvalidImageError if the original cannot be read,
+// and an error if the format is not supported.
+func Convert(original, format string, opts ...Option) (string, error) {
+	var formatOpt Option
+	switch format {
+	case "png":
+		formatOpt = AsPNG()
+	case "jpeg":
+		formatOpt = AsJPEG()
+	default:
+		return "", fmt.Errorf("unsupported target image format: %s", format)
+	}
+	data, err := base64.StdEncoding.DecodeString(original)
+	if err != nil {
+		return "", InvalidImageError("content is not base64 encoded")
+	}
+	img, srcFormat, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", InvalidImageError("unknown image format")
+	}
+	orientation := exifOrientation(data)
+	if srcFormat == format && orientation == 1 && len(opts) == 0 {
+		return original, nil
+	}
+	return encode(orient(img, orientation), srcFormat, append(opts, formatOpt)), nil
+}
+
+// formatAllowed returns true if format is one of formats
+func formatAllowed(format string, formats []string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
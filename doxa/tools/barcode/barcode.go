@@ -0,0 +1,218 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package barcode generates barcodes and QR codes as PNG or SVG images,
+for use in reports, labels and inventory modules.
+
+Supported symbologies are EAN-13 and Code 128 linear barcodes, and QR codes.
+Images are returned as raw bytes or base64 encoded, so that they can be set
+in image fields or embedded in HTML with a data URI:
+
+	bc, err := barcode.NewEAN13("400638133393")
+	if err != nil {
+	    return err
+	}
+	src := "data:image/png;base64," + bc.PNGBase64(2, 60)
+*/
+package barcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// A Type is a barcode symbology
+type Type string
+
+// Available barcode types
+const (
+	EAN13   Type = "EAN13"
+	Code128 Type = "Code128"
+	QR      Type = "QR"
+)
+
+// defaultBarHeight is the height of linear barcodes in modules
+// when no height is given for rendering.
+const defaultBarHeight = 50
+
+// An EncodeError is returned when a content cannot be encoded in a barcode
+type EncodeError struct {
+	Type    Type
+	Content string
+	Reason  string
+}
+
+// Error returns the error message
+func (ee EncodeError) Error() string {
+	return fmt.Sprintf("unable to encode '%s' in %s barcode: %s", ee.Content, ee.Type, ee.Reason)
+}
+
+// A Barcode is a symbol made of dark and light modules (i.e. bars or dots),
+// that can be rendered as an image.
+//
+// Linear barcodes have a single row of modules.
+type Barcode struct {
+	// Type is the symbology of this barcode
+	Type Type
+	// Content is the encoded text. For EAN-13, it includes the check digit.
+	Content string
+	// modules are the rows of modules, true for dark modules
+	modules [][]bool
+	// quietZone is the number of light modules around the symbol
+	quietZone int
+}
+
+// Encode returns a new Barcode of the given type with the given content.
+// QR codes are created with the medium error correction level.
+func Encode(typ Type, content string) (*Barcode, error) {
+	switch typ {
+	case EAN13:
+		return NewEAN13(content)
+	case Code128:
+		return NewCode128(content)
+	case QR:
+		return NewQR(content, ECLevelM)
+	default:
+		return nil, EncodeError{Type: typ, Content: content, Reason: "unknown barcode type"}
+	}
+}
+
+// Linear returns true if this Barcode is a linear barcode
+func (b *Barcode) Linear() bool {
+	return len(b.modules) == 1
+}
+
+// Size returns the width and height in modules of this Barcode, without quiet zone
+func (b *Barcode) Size() (int, int) {
+	return len(b.modules[0]), len(b.modules)
+}
+
+// Dark returns true if the module at the given column and row is dark
+func (b *Barcode) Dark(x, y int) bool {
+	return b.modules[y][x]
+}
+
+// pixelSize returns the width and height in pixels of this Barcode rendered with
+// the given module size and bar height, as well as the actual bar height.
+func (b *Barcode) pixelSize(moduleSize, height int) (int, int, int) {
+	width, rows := b.Size()
+	width += 2 * b.quietZone
+	if !b.Linear() {
+		return width * moduleSize, (rows + 2*b.quietZone) * moduleSize, moduleSize
+	}
+	if height <= 0 {
+		height = defaultBarHeight * moduleSize
+	}
+	return width * moduleSize, height, height
+}
+
+// Image returns this Barcode as a black and white image, with each module
+// rendered moduleSize pixels wide.
+//
+// height is the height in pixels of linear barcodes. It defaults to 50 modules
+// if height is 0, and it is ignored for QR codes which are always square.
+func (b *Barcode) Image(moduleSize, height int) *image.Paletted {
+	if moduleSize < 1 {
+		moduleSize = 1
+	}
+	width, imgHeight, rowHeight := b.pixelSize(moduleSize, height)
+	img := image.NewPaletted(image.Rect(0, 0, width, imgHeight), color.Palette{color.White, color.Black})
+	offsetY := 0
+	if !b.Linear() {
+		offsetY = b.quietZone * moduleSize
+	}
+	for y, row := range b.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			x0 := (x + b.quietZone) * moduleSize
+			y0 := offsetY + y*rowHeight
+			for py := y0; py < y0+rowHeight; py++ {
+				for px := x0; px < x0+moduleSize; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG returns this Barcode as a PNG image.
+// See Image for the meaning of moduleSize and height.
+func (b *Barcode) PNG(moduleSize, height int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, b.Image(moduleSize, height))
+	return buf.Bytes()
+}
+
+// PNGBase64 returns this Barcode as a base64 encoded PNG image.
+// See Image for the meaning of moduleSize and height.
+func (b *Barcode) PNGBase64(moduleSize, height int) string {
+	return base64.StdEncoding.EncodeToString(b.PNG(moduleSize, height))
+}
+
+// SVG returns this Barcode as an SVG image. Dark modules are drawn as a
+// single path, so that the image scales without gaps between modules.
+// See Image for the meaning of moduleSize and height.
+func (b *Barcode) SVG(moduleSize, height int) []byte {
+	if moduleSize < 1 {
+		moduleSize = 1
+	}
+	width, imgHeight, _ := b.pixelSize(moduleSize, height)
+	cols, rows := b.Size()
+	viewWidth, viewHeight, rowHeight, offsetY := cols+2*b.quietZone, rows+2*b.quietZone, 1, b.quietZone
+	if b.Linear() {
+		// Linear barcodes are stretched vertically, so that their
+		// height is independent from the width of the modules.
+		viewHeight, rowHeight, offsetY = 1, 1, 0
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none" shape-rendering="crispEdges">`,
+		width, imgHeight, viewWidth, viewHeight)
+	fmt.Fprint(&buf, `<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range b.modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", start+b.quietZone, y+offsetY, x-start, rowHeight, x-start)
+		}
+	}
+	fmt.Fprint(&buf, `"/></svg>`)
+	return buf.Bytes()
+}
+
+// SVGBase64 returns this Barcode as a base64 encoded SVG image.
+// See Image for the meaning of moduleSize and height.
+func (b *Barcode) SVGBase64(moduleSize, height int) string {
+	return base64.StdEncoding.EncodeToString(b.SVG(moduleSize, height))
+}
+
+// newLinear returns a new linear Barcode with the given row of modules
+func newLinear(typ Type, content string, row []bool, quietZone int) *Barcode {
+	return &Barcode{
+		Type:      typ,
+		Content:   content,
+		modules:   [][]bool{row},
+		quietZone: quietZone,
+	}
+}
+
+// appendPattern appends to row the modules of the given pattern of
+// '1' for dark modules and '0' for light modules.
+func appendPattern(row []bool, pattern string) []bool {
+	for _, m := range pattern {
+		row = append(row, m == '1')
+	}
+	return row
+}
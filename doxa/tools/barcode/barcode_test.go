@@ -0,0 +1,287 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package barcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rowPattern returns the modules of the given linear barcode as a string of '0' and '1'
+func rowPattern(b *Barcode) string {
+	var res strings.Builder
+	for _, dark := range b.modules[0] {
+		if dark {
+			res.WriteByte('1')
+			continue
+		}
+		res.WriteByte('0')
+	}
+	return res.String()
+}
+
+// readQRCodewords reads back the codewords of the given QR code symbol of the
+// given version by reading and removing its mask. It returns the error correction
+// level and the mask read from the format information.
+func readQRCodewords(b *Barcode, version int) ([]byte, ECLevel, int) {
+	s := newQRSymbol(version)
+	var format int
+	for i := 14; i >= 9; i-- {
+		format = format<<1 | boolToInt(b.Dark(14-i, 8))
+	}
+	format = format<<1 | boolToInt(b.Dark(7, 8))
+	format = format<<1 | boolToInt(b.Dark(8, 8))
+	format = format<<1 | boolToInt(b.Dark(8, 7))
+	for i := 5; i >= 0; i-- {
+		format = format<<1 | boolToInt(b.Dark(8, i))
+	}
+	format ^= 0x5412
+	mask := (format >> 10) & 7
+	var level ECLevel
+	for l := ECLevelL; l <= ECLevelH; l++ {
+		if l.formatBits() == format>>13 {
+			level = l
+		}
+	}
+	var bits bitBuffer
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - vert
+				}
+				if s.isFunction[y][x] {
+					continue
+				}
+				bits = append(bits, b.Dark(x, y) != qrMasks[mask](x, y))
+			}
+		}
+	}
+	return bits[:len(bits)/8*8].bytes(), level, mask
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestEAN13(t *testing.T) {
+	Convey("Testing EAN-13 barcodes", t, func() {
+		Convey("Check digits should be computed or verified", func() {
+			check, err := EAN13CheckDigit("400638133393")
+			So(err, ShouldBeNil)
+			So(check, ShouldEqual, '1')
+			bc, err := NewEAN13("400638133393")
+			So(err, ShouldBeNil)
+			So(bc.Content, ShouldEqual, "4006381333931")
+			_, err = NewEAN13("4006381333931")
+			So(err, ShouldBeNil)
+			_, err = NewEAN13("4006381333932")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "expected 1")
+			_, err = NewEAN13("40063813339")
+			So(err, ShouldNotBeNil)
+			_, err = NewEAN13("40063813339A")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Digits should be encoded with the parity of the first digit", func() {
+			bc, _ := NewEAN13("590123412345")
+			So(bc.Content, ShouldEqual, "5901234123457")
+			width, height := bc.Size()
+			So(width, ShouldEqual, 95)
+			So(height, ShouldEqual, 1)
+			So(bc.Linear(), ShouldBeTrue)
+			// 5 gives LGGLLG for 9, 0, 1, 2, 3, 4
+			So(rowPattern(bc), ShouldEqual, "101"+
+				"0001011"+"0100111"+"0110011"+"0010011"+"0111101"+"0011101"+
+				"01010"+
+				"1100110"+"1101100"+"1000010"+"1011100"+"1001110"+"1000100"+
+				"101")
+		})
+	})
+}
+
+func TestCode128(t *testing.T) {
+	Convey("Testing Code 128 barcodes", t, func() {
+		Convey("All symbols should be 11 modules wide", func() {
+			for i, widths := range code128Widths {
+				var sum int
+				for _, w := range widths {
+					sum += int(w - '0')
+				}
+				if i == code128Stop {
+					So(sum, ShouldEqual, 13)
+					continue
+				}
+				So(sum, ShouldEqual, 11)
+			}
+		})
+		Convey("Code sets should be switched for digits and control characters", func() {
+			values, err := code128Values("AB12345678")
+			So(err, ShouldBeNil)
+			So(values[:8], ShouldResemble, []int{code128StartB, 33, 34, code128CodeC, 12, 34, 56, 78})
+			values, _ = code128Values("12345")
+			So(values[:5], ShouldResemble, []int{code128StartB, 17, code128CodeC, 23, 45})
+			values, _ = code128Values("1234a")
+			So(values[:5], ShouldResemble, []int{code128StartC, 12, 34, code128CodeB, 65})
+			values, _ = code128Values("a\tb")
+			So(values[:6], ShouldResemble, []int{code128StartB, 65, code128CodeA, 73, code128CodeB, 66})
+			values, _ = code128Values("42")
+			So(values[:2], ShouldResemble, []int{code128StartC, 42})
+		})
+		Convey("The checksum should be the weighted sum of the values", func() {
+			values, _ := code128Values("PJJ123C")
+			So(values, ShouldResemble, []int{code128StartB, 48, 42, 42, 17, 18, 19, 35, 55})
+		})
+		Convey("Barcodes should end with the stop pattern", func() {
+			bc, err := NewCode128("Doxa-42")
+			So(err, ShouldBeNil)
+			width, _ := bc.Size()
+			So(width, ShouldEqual, 11*(7+2)+13)
+			So(rowPattern(bc), ShouldStartWith, "11010010000")
+			So(rowPattern(bc), ShouldEndWith, "1100011101011")
+		})
+		Convey("Non ASCII or empty contents should not be encoded", func() {
+			_, err := NewCode128("café")
+			So(err, ShouldNotBeNil)
+			_, err = NewCode128("")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestQR(t *testing.T) {
+	Convey("Testing QR codes", t, func() {
+		Convey("Data capacities should match the standard", func() {
+			capacities := map[int][4]int{
+				1:  {19, 16, 13, 9},
+				2:  {34, 28, 22, 16},
+				5:  {108, 86, 62, 46},
+				10: {274, 216, 154, 122},
+				20: {861, 669, 485, 385},
+				40: {2956, 2334, 1666, 1276},
+			}
+			for version, caps := range capacities {
+				for level := ECLevelL; level <= ECLevelH; level++ {
+					So(qrDataCodewords(version, level), ShouldEqual, caps[level])
+				}
+			}
+		})
+		Convey("Alignment patterns should be at the standard positions", func() {
+			So(qrAlignmentPositions(1), ShouldBeEmpty)
+			So(qrAlignmentPositions(2), ShouldResemble, []int{6, 18})
+			So(qrAlignmentPositions(7), ShouldResemble, []int{6, 22, 38})
+			So(qrAlignmentPositions(32), ShouldResemble, []int{6, 34, 60, 86, 112, 138})
+			So(qrAlignmentPositions(40), ShouldResemble, []int{6, 30, 58, 86, 114, 142, 170})
+		})
+		Convey("Alphanumeric data and error correction should match the standard example", func() {
+			data, version, err := qrDataCodewordsFor("HELLO WORLD", ECLevelM)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+			So(data, ShouldResemble, []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17})
+			ec := reedSolomonRemainder(data, reedSolomonDivisor(10))
+			So(ec, ShouldResemble, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23})
+		})
+		Convey("The most compact mode should be used", func() {
+			data, _, _ := qrDataCodewordsFor("01234567", ECLevelM)
+			So(data[:5], ShouldResemble, []byte{0x10, 0x20, 0x0C, 0x56, 0x61})
+			data, _, _ = qrDataCodewordsFor("Doxa", ECLevelM)
+			So(data[:6], ShouldResemble, []byte{0x40, 0x44, 0x46, 0xF7, 0x86, 0x10})
+		})
+		Convey("Symbols should hold their codewords, format and version", func() {
+			for _, tc := range []struct {
+				content string
+				level   ECLevel
+				version int
+			}{
+				{"HELLO WORLD", ECLevelM, 1},
+				{"https://www.example.com/doxa?id=42", ECLevelH, 4},
+				{strings.Repeat("Doxa ERP ", 20), ECLevelQ, 12},
+			} {
+				bc, err := NewQR(tc.content, tc.level)
+				So(err, ShouldBeNil)
+				width, height := bc.Size()
+				So(width, ShouldEqual, tc.version*4+17)
+				So(height, ShouldEqual, width)
+				So(bc.Linear(), ShouldBeFalse)
+				data, _, _ := qrDataCodewordsFor(tc.content, tc.level)
+				codewords, level, _ := readQRCodewords(bc, tc.version)
+				So(level, ShouldEqual, tc.level)
+				So(codewords, ShouldResemble, qrAddECAndInterleave(data, tc.version, tc.level))
+				// Format information should be the same in both copies
+				for i, row := range []int{0, 1, 2, 3, 4, 5, 7, 8} {
+					So(bc.Dark(width-1-i, 8), ShouldEqual, bc.Dark(8, row))
+				}
+				So(bc.Dark(8, width-8), ShouldBeTrue)
+				if tc.version >= 7 {
+					// Version 12 information is 001100011101100010
+					var versionBits int
+					for i := 17; i >= 0; i-- {
+						versionBits = versionBits<<1 | boolToInt(bc.Dark(width-11+i%3, i/3))
+					}
+					So(versionBits, ShouldEqual, 0x0C762)
+				}
+			}
+		})
+		Convey("Too long contents and invalid levels should not be encoded", func() {
+			_, err := NewQR(strings.Repeat("a", 3000), ECLevelL)
+			So(err, ShouldNotBeNil)
+			_, err = NewQR("a", ECLevel(4))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRendering(t *testing.T) {
+	Convey("Testing barcode rendering", t, func() {
+		Convey("Linear barcodes should be rendered with the given height", func() {
+			bc, _ := Encode(EAN13, "400638133393")
+			img, err := png.Decode(bytes.NewReader(bc.PNG(2, 60)))
+			So(err, ShouldBeNil)
+			So(img.Bounds().Dx(), ShouldEqual, (95+2*eanQuietZone)*2)
+			So(img.Bounds().Dy(), ShouldEqual, 60)
+			r, _, _, _ := img.At(eanQuietZone*2, 30).RGBA()
+			So(r, ShouldEqual, 0)
+			r, _, _, _ = img.At(eanQuietZone*2-1, 30).RGBA()
+			So(r, ShouldEqual, 0xFFFF)
+			So(bc.Image(1, 0).Bounds().Dy(), ShouldEqual, defaultBarHeight)
+			svg := string(bc.SVG(2, 60))
+			So(svg, ShouldStartWith, `<svg xmlns="http://www.w3.org/2000/svg" width="234" height="60" viewBox="0 0 117 1"`)
+			So(svg, ShouldContainSubstring, `d="M11 0h1v1h-1zM13 0h1v1h-1z`)
+		})
+		Convey("QR codes should be square with a quiet zone", func() {
+			bc, _ := Encode(QR, "HELLO WORLD")
+			img := bc.Image(3, 100)
+			So(img.Bounds().Dx(), ShouldEqual, (21+8)*3)
+			So(img.Bounds().Dy(), ShouldEqual, (21+8)*3)
+			So(img.ColorIndexAt(qrQuietZone*3, qrQuietZone*3), ShouldEqual, 1)
+			So(img.ColorIndexAt(qrQuietZone*3-1, qrQuietZone*3), ShouldEqual, 0)
+			svg := string(bc.SVG(3, 0))
+			So(svg, ShouldContainSubstring, `viewBox="0 0 29 29"`)
+			So(svg, ShouldContainSubstring, `d="M4 4h7v1h-7z`)
+		})
+		Convey("Images should be encoded in base64", func() {
+			bc, _ := Encode(Code128, "Doxa")
+			data, err := base64.StdEncoding.DecodeString(bc.PNGBase64(1, 10))
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, bc.PNG(1, 10))
+			data, err = base64.StdEncoding.DecodeString(bc.SVGBase64(1, 10))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEndWith, "</svg>")
+			_, err = Encode(Type("UPC"), "123")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package barcode
+
+import "strings"
+
+// code128QuietZone is the width in modules of the quiet zone of Code 128 barcodes
+const code128QuietZone = 10
+
+// Special values of Code 128 symbols
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128CodeA  = 101
+	code128StartA = 103
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// code128Widths are the widths of the bars and spaces of the
+// symbols of Code 128, indexed by their value.
+var code128Widths = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// digitsRun returns the number of consecutive digits of s starting at i
+func digitsRun(s string, i int) int {
+	n := 0
+	for i+n < len(s) && s[i+n] >= '0' && s[i+n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// useCodeC returns true if the digits starting at i should be encoded in code set C,
+// i.e. if there are at least 4 of them, or 2 if they are the whole remaining content.
+func useCodeC(s string, i int) bool {
+	n := digitsRun(s, i)
+	return n >= 4 || (n >= 2 && n%2 == 0 && i+n == len(s))
+}
+
+// code128Values returns the values of the symbols encoding the given content,
+// from the start symbol to the checksum. Code set C is used for runs of digits,
+// code set A for control characters and code set B otherwise.
+func code128Values(content string) ([]int, error) {
+	for i := 0; i < len(content); i++ {
+		if content[i] > 127 {
+			return nil, EncodeError{Type: Code128, Content: content, Reason: "only ASCII characters are allowed"}
+		}
+	}
+	if content == "" {
+		return nil, EncodeError{Type: Code128, Content: content, Reason: "content is empty"}
+	}
+	var values []int
+	var set byte
+	switch {
+	case useCodeC(content, 0) && digitsRun(content, 0)%2 == 0:
+		set = 'C'
+		values = append(values, code128StartC)
+	case content[0] < 32:
+		set = 'A'
+		values = append(values, code128StartA)
+	default:
+		set = 'B'
+		values = append(values, code128StartB)
+	}
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case set == 'C':
+			if digitsRun(content, i) >= 2 {
+				values = append(values, int(c-'0')*10+int(content[i+1]-'0'))
+				i += 2
+				continue
+			}
+			if c < 32 {
+				set = 'A'
+				values = append(values, code128CodeA)
+			} else {
+				set = 'B'
+				values = append(values, code128CodeB)
+			}
+		case useCodeC(content, i) && digitsRun(content, i)%2 == 0:
+			set = 'C'
+			values = append(values, code128CodeC)
+		case set == 'A' && c >= 96:
+			set = 'B'
+			values = append(values, code128CodeB)
+		case set == 'B' && c < 32:
+			set = 'A'
+			values = append(values, code128CodeA)
+		case c < 32:
+			values = append(values, int(c)+64)
+			i++
+		default:
+			// An odd run of digits is started in the current code set
+			values = append(values, int(c)-32)
+			i++
+		}
+	}
+	checksum := values[0]
+	for i, v := range values[1:] {
+		checksum += (i + 1) * v
+	}
+	return append(values, checksum%103), nil
+}
+
+// NewCode128 returns a new Code 128 Barcode of the given ASCII content
+func NewCode128(content string) (*Barcode, error) {
+	values, err := code128Values(content)
+	if err != nil {
+		return nil, err
+	}
+	var pattern strings.Builder
+	for _, v := range append(values, code128Stop) {
+		for i, w := range code128Widths[v] {
+			module := "1"
+			if i%2 == 1 {
+				module = "0"
+			}
+			pattern.WriteString(strings.Repeat(module, int(w-'0')))
+		}
+	}
+	return newLinear(Code128, content, appendPattern(nil, pattern.String()), code128QuietZone), nil
+}
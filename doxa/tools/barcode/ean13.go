@@ -0,0 +1,88 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package barcode
+
+import "fmt"
+
+// eanQuietZone is the width in modules of the quiet zone of EAN-13 barcodes
+const eanQuietZone = 11
+
+// eanLCodes are the odd parity patterns of the digits of the left half
+var eanLCodes = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// eanGCodes are the even parity patterns of the digits of the left half
+var eanGCodes = [10]string{
+	"0100111", "0110011", "0011011", "0100001", "0011101",
+	"0111001", "0000101", "0010001", "0001001", "0010111",
+}
+
+// eanRCodes are the patterns of the digits of the right half
+var eanRCodes = [10]string{
+	"1110010", "1100110", "1101100", "1000010", "1011100",
+	"1001110", "1010000", "1000100", "1001000", "1110100",
+}
+
+// eanParities are the parities of the digits of the left half given by the
+// first digit, which is not encoded otherwise. 'G' is even and 'L' is odd.
+var eanParities = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL",
+}
+
+// EAN13CheckDigit returns the check digit of the given
+// first 12 digits of an EAN-13 code.
+func EAN13CheckDigit(digits string) (byte, error) {
+	if len(digits) != 12 {
+		return 0, EncodeError{Type: EAN13, Content: digits, Reason: "12 digits are expected"}
+	}
+	var sum int
+	for i := 0; i < 12; i++ {
+		d := digits[i]
+		if d < '0' || d > '9' {
+			return 0, EncodeError{Type: EAN13, Content: digits, Reason: "only digits are allowed"}
+		}
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(d-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+// NewEAN13 returns a new EAN-13 Barcode of the given code. The code is either
+// 12 digits, in which case the check digit is computed and appended, or 13
+// digits, in which case the check digit is verified.
+func NewEAN13(code string) (*Barcode, error) {
+	if len(code) != 12 && len(code) != 13 {
+		return nil, EncodeError{Type: EAN13, Content: code, Reason: "12 or 13 digits are expected"}
+	}
+	check, err := EAN13CheckDigit(code[:12])
+	if err != nil {
+		return nil, EncodeError{Type: EAN13, Content: code, Reason: "only digits are allowed"}
+	}
+	if len(code) == 13 && code[12] != check {
+		return nil, EncodeError{Type: EAN13, Content: code, Reason: fmt.Sprintf("invalid check digit, expected %c", check)}
+	}
+	code = code[:12] + string(check)
+	row := appendPattern(nil, "101")
+	parities := eanParities[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		d := code[i] - '0'
+		if parities[i-1] == 'G' {
+			row = appendPattern(row, eanGCodes[d])
+			continue
+		}
+		row = appendPattern(row, eanLCodes[d])
+	}
+	row = appendPattern(row, "01010")
+	for i := 7; i <= 12; i++ {
+		row = appendPattern(row, eanRCodes[code[i]-'0'])
+	}
+	row = appendPattern(row, "101")
+	return newLinear(EAN13, code, row, eanQuietZone), nil
+}
@@ -0,0 +1,585 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package barcode
+
+import "strings"
+
+// qrQuietZone is the width in modules of the quiet zone of QR codes
+const qrQuietZone = 4
+
+// An ECLevel is the error correction level of a QR code, i.e. the
+// proportion of the symbol that can be damaged and still be read.
+type ECLevel int8
+
+// Error correction levels of QR codes
+const (
+	// ECLevelL recovers 7% of the symbol
+	ECLevelL ECLevel = iota
+	// ECLevelM recovers 15% of the symbol
+	ECLevelM
+	// ECLevelQ recovers 25% of the symbol
+	ECLevelQ
+	// ECLevelH recovers 30% of the symbol
+	ECLevelH
+)
+
+// formatBits returns the bits of this level in the format information
+func (l ECLevel) formatBits() int {
+	return [4]int{1, 0, 3, 2}[l]
+}
+
+// qrECCodewordsPerBlock is the number of error correction codewords
+// in each block, indexed by error correction level and version.
+var qrECCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// qrECBlocks is the number of error correction blocks,
+// indexed by error correction level and version.
+var qrECBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrAlphanumeric is the character set of the alphanumeric mode,
+// in the order of their values.
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// A qrMode is a data encoding mode of QR codes
+type qrMode struct {
+	indicator  int
+	countBits  [3]int
+	charsValid func(string) bool
+}
+
+// QR code encoding modes, from the most to the least compact
+var (
+	qrNumericMode = qrMode{
+		indicator: 0x1,
+		countBits: [3]int{10, 12, 14},
+		charsValid: func(s string) bool {
+			return strings.Trim(s, "0123456789") == ""
+		},
+	}
+	qrAlphanumericMode = qrMode{
+		indicator: 0x2,
+		countBits: [3]int{9, 11, 13},
+		charsValid: func(s string) bool {
+			return strings.Trim(s, qrAlphanumeric) == ""
+		},
+	}
+	qrByteMode = qrMode{
+		indicator: 0x4,
+		countBits: [3]int{8, 16, 16},
+		charsValid: func(s string) bool {
+			return true
+		},
+	}
+)
+
+// charCountBits returns the length of the character count field in this mode for the given version
+func (m qrMode) charCountBits(version int) int {
+	switch {
+	case version <= 9:
+		return m.countBits[0]
+	case version <= 26:
+		return m.countBits[1]
+	default:
+		return m.countBits[2]
+	}
+}
+
+// A bitBuffer is a sequence of bits
+type bitBuffer []bool
+
+// appendBits appends the given number of low bits of value, most significant first
+func (bb *bitBuffer) appendBits(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, (value>>uint(i))&1 == 1)
+	}
+}
+
+// bytes returns the bits of this buffer as bytes. The length of the buffer must be a multiple of 8.
+func (bb bitBuffer) bytes() []byte {
+	res := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			res[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return res
+}
+
+// qrSegmentBits returns the bits of the given content encoded in the
+// given mode, without mode indicator and character count.
+func qrSegmentBits(content string, mode qrMode) bitBuffer {
+	var bb bitBuffer
+	switch mode.indicator {
+	case qrNumericMode.indicator:
+		for i := 0; i < len(content); i += 3 {
+			group := content[i:min(i+3, len(content))]
+			value := 0
+			for _, d := range group {
+				value = value*10 + int(d-'0')
+			}
+			bb.appendBits(value, len(group)*3+1)
+		}
+	case qrAlphanumericMode.indicator:
+		for i := 0; i < len(content); i += 2 {
+			if i+1 == len(content) {
+				bb.appendBits(strings.IndexByte(qrAlphanumeric, content[i]), 6)
+				continue
+			}
+			bb.appendBits(strings.IndexByte(qrAlphanumeric, content[i])*45+strings.IndexByte(qrAlphanumeric, content[i+1]), 11)
+		}
+	default:
+		for i := 0; i < len(content); i++ {
+			bb.appendBits(int(content[i]), 8)
+		}
+	}
+	return bb
+}
+
+// min returns the smallest of the given ints
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// abs returns the absolute value of the given int
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// qrRawDataModules returns the number of modules of the given version that
+// can store data, i.e. that are not used by function patterns.
+func qrRawDataModules(version int) int {
+	res := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		res -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			res -= 36
+		}
+	}
+	return res
+}
+
+// qrDataCodewords returns the number of data codewords of the
+// given version with the given error correction level.
+func qrDataCodewords(version int, level ECLevel) int {
+	return qrRawDataModules(version)/8 - qrECCodewordsPerBlock[level][version]*qrECBlocks[level][version]
+}
+
+// qrDataCodewordsFor returns the data codewords of the given content, with padding,
+// and the smallest version with the given error correction level that can hold them.
+func qrDataCodewordsFor(content string, level ECLevel) ([]byte, int, error) {
+	mode := qrByteMode
+	for _, m := range []qrMode{qrNumericMode, qrAlphanumericMode} {
+		if m.charsValid(content) {
+			mode = m
+			break
+		}
+	}
+	segment := qrSegmentBits(content, mode)
+	for version := 1; version <= 40; version++ {
+		countBits := mode.charCountBits(version)
+		capacity := qrDataCodewords(version, level) * 8
+		if len(content) >= 1<<uint(countBits) || 4+countBits+len(segment) > capacity {
+			continue
+		}
+		var bb bitBuffer
+		bb.appendBits(mode.indicator, 4)
+		bb.appendBits(len(content), countBits)
+		bb = append(bb, segment...)
+		// Terminator and padding to a byte boundary
+		bb.appendBits(0, min(4, capacity-len(bb)))
+		bb.appendBits(0, (8-len(bb)%8)%8)
+		for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+			bb.appendBits(pad, 8)
+		}
+		return bb.bytes(), version, nil
+	}
+	return nil, 0, EncodeError{Type: QR, Content: content, Reason: "content is too long"}
+}
+
+// gfMultiply returns the product of x and y in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// reedSolomonDivisor returns the coefficients of the Reed-Solomon generator polynomial
+// of the given degree, from the highest to the lowest power, without the leading 1.
+func reedSolomonDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range res {
+			res[j] = gfMultiply(res[j], root)
+			if j+1 < len(res) {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return res
+}
+
+// reedSolomonRemainder returns the error correction codewords of the given data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i, coef := range divisor {
+			res[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return res
+}
+
+// qrAddECAndInterleave splits the given data codewords in blocks, appends
+// error correction codewords to each block and interleaves the blocks.
+func qrAddECAndInterleave(data []byte, version int, level ECLevel) []byte {
+	numBlocks := qrECBlocks[level][version]
+	blockECLen := qrECCodewordsPerBlock[level][version]
+	rawCodewords := qrRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+	divisor := reedSolomonDivisor(blockECLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		dataLen := shortBlockLen - blockECLen
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := append([]byte{}, data[k:k+dataLen]...)
+		k += dataLen
+		ec := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			// Short blocks are padded so that all blocks have the same length
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ec...)
+	}
+	res := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECLen || j >= numShortBlocks {
+				res = append(res, block[i])
+			}
+		}
+	}
+	return res
+}
+
+// A qrSymbol is a QR code being built
+type qrSymbol struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// newQRSymbol returns a new qrSymbol of the given version with its function patterns
+func newQRSymbol(version int) *qrSymbol {
+	size := version*4 + 17
+	s := &qrSymbol{
+		size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := 0; i < size; i++ {
+		s.modules[i] = make([]bool, size)
+		s.isFunction[i] = make([]bool, size)
+	}
+	// Timing patterns
+	for i := 0; i < size; i++ {
+		s.setFunction(6, i, i%2 == 0)
+		s.setFunction(i, 6, i%2 == 0)
+	}
+	// Finder patterns with their separators
+	for _, pos := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := pos[0]+dx, pos[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				s.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+	// Alignment patterns, except where they overlap finder patterns
+	alignPos := qrAlignmentPositions(version)
+	last := len(alignPos) - 1
+	for i, y := range alignPos {
+		for j, x := range alignPos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					s.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// Format information is reserved here and drawn with the mask
+	s.drawFormatBits(ECLevelL, 0)
+	s.drawVersion(version)
+	return s
+}
+
+// max returns the largest of the given ints
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// qrAlignmentPositions returns the coordinates of the centers of the alignment
+// patterns of the given version. They are the same for rows and columns.
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	res := make([]int, numAlign)
+	res[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		res[i] = pos
+	}
+	return res
+}
+
+// setFunction sets the module at the given column and row as a function module
+func (s *qrSymbol) setFunction(x, y int, dark bool) {
+	s.modules[y][x] = dark
+	s.isFunction[y][x] = true
+}
+
+// drawFormatBits draws the two copies of the format information
+// of the given error correction level and mask.
+func (s *qrSymbol) drawFormatBits(level ECLevel, mask int) {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+	// First copy, around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		s.setFunction(8, i, bit(i))
+	}
+	s.setFunction(8, 7, bit(6))
+	s.setFunction(8, 8, bit(7))
+	s.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.setFunction(14-i, 8, bit(i))
+	}
+	// Second copy, split between the two other finder patterns
+	for i := 0; i < 8; i++ {
+		s.setFunction(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.setFunction(8, s.size-15+i, bit(i))
+	}
+	// Dark module
+	s.setFunction(8, s.size-8, true)
+}
+
+// drawVersion draws the two copies of the version information, for versions 7 and above
+func (s *qrSymbol) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 == 1
+		a, b := s.size-11+i%3, i/3
+		s.setFunction(a, b, dark)
+		s.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords draws the given codewords in the data modules, in zigzag
+// columns of two modules from the bottom right corner.
+func (s *qrSymbol) drawCodewords(codewords []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					// Upward column
+					y = s.size - 1 - vert
+				}
+				if s.isFunction[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				s.modules[y][x] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// qrMasks are the conditions of the data mask patterns, which
+// invert the module at the given column and row if true.
+var qrMasks = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// applyMask inverts the data modules selected by the given mask.
+// Applying the same mask twice restores the data modules.
+func (s *qrSymbol) applyMask(mask int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if !s.isFunction[y][x] && qrMasks[mask](x, y) {
+				s.modules[y][x] = !s.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty returns the penalty score of the symbol as defined by the standard.
+// The mask giving the lowest penalty is the easiest to read.
+func (s *qrSymbol) penalty() int {
+	var res int
+	finderLike := [2][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, vertical := range []bool{false, true} {
+		at := func(i, j int) bool {
+			if vertical {
+				return s.modules[j][i]
+			}
+			return s.modules[i][j]
+		}
+		for i := 0; i < s.size; i++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for j := 1; j < s.size; j++ {
+				if at(i, j) == at(i, j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					res += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				res += run - 2
+			}
+			// Patterns looking like finder patterns
+			for j := 0; j+11 <= s.size; j++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if at(i, j+k) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						res += 40
+					}
+				}
+			}
+		}
+	}
+	// Blocks of 2x2 modules of the same color
+	var dark int
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if s.modules[y][x] {
+				dark++
+			}
+			if x+1 < s.size && y+1 < s.size {
+				c := s.modules[y][x]
+				if c == s.modules[y][x+1] && c == s.modules[y+1][x] && c == s.modules[y+1][x+1] {
+					res += 3
+				}
+			}
+		}
+	}
+	// Unbalanced proportion of dark modules
+	res += abs(dark*100/(s.size*s.size)-50) / 5 * 10
+	return res
+}
+
+// NewQR returns a new QR code Barcode of the given content with the given error
+// correction level. The smallest version that can hold the content is used.
+//
+// Content made of digits only, or of upper case letters, digits and ' $%*+-./:'
+// only, is encoded compactly. Other content is encoded as UTF-8 bytes.
+func NewQR(content string, level ECLevel) (*Barcode, error) {
+	if level < ECLevelL || level > ECLevelH {
+		return nil, EncodeError{Type: QR, Content: content, Reason: "invalid error correction level"}
+	}
+	data, version, err := qrDataCodewordsFor(content, level)
+	if err != nil {
+		return nil, err
+	}
+	s := newQRSymbol(version)
+	s.drawCodewords(qrAddECAndInterleave(data, version, level))
+	bestMask, minPenalty := 0, -1
+	for mask := range qrMasks {
+		s.applyMask(mask)
+		s.drawFormatBits(level, mask)
+		if p := s.penalty(); minPenalty < 0 || p < minPenalty {
+			bestMask, minPenalty = mask, p
+		}
+		s.applyMask(mask)
+	}
+	s.applyMask(bestMask)
+	s.drawFormatBits(level, bestMask)
+	return &Barcode{
+		Type:      QR,
+		Content:   content,
+		modules:   s.modules,
+		quietZone: qrQuietZone,
+	}, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package cache provides a shared cache for data that is expensive to compute,
+such as exchange rates or report snapshots.
+
+Values are stored in a Redis server when 'Cache.RedisURL' is set in the
+configuration, so that they are shared by all the processes and workers of
+Doxa. Otherwise, or if the Redis server cannot be reached at startup, values
+are stored in the memory of each process.
+
+Modules get a Cache for their own namespace with New, usually in a package
+variable, and store JSON encodable values with a time to live:
+
+	var ratesCache = cache.New("currency_rates")
+
+	func getRate(code string) float64 {
+	    var rate float64
+	    ratesCache.Fetch(code, time.Hour, &rate, func() (interface{}, error) {
+	        return fetchRate(code)
+	    })
+	    return rate
+	}
+
+The cache is best effort: errors of the store are logged and reading
+a value that cannot be fetched is a cache miss.
+*/
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labneco/doxa/doxa/tools/logging"
+	"github.com/spf13/viper"
+)
+
+// DefaultPrefix is the prefix of all keys when 'Cache.Prefix' is not set
+const DefaultPrefix = "doxa"
+
+var (
+	log         *logging.Logger
+	storeMutex  sync.RWMutex
+	store       Store
+	storePrefix = DefaultPrefix
+)
+
+// currentStore returns the current store and key prefix
+func currentStore() (Store, string) {
+	storeMutex.RLock()
+	defer storeMutex.RUnlock()
+	return store, storePrefix
+}
+
+// SetStore sets the store used by all caches and the prefix of their keys.
+// The previous store is closed.
+//
+// SetStore is called by Initialize and should only be used in tests.
+func SetStore(s Store, prefix string) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if store != nil {
+		store.Close()
+	}
+	store = s
+	storePrefix = prefix
+}
+
+// A Cache stores values in the namespace of a module or a feature,
+// so that keys of different namespaces do not collide.
+type Cache struct {
+	namespace string
+}
+
+// New returns a Cache for the given namespace.
+//
+// New can be called before Initialize, e.g. in package variables,
+// since the store is only looked up when the cache is used.
+func New(namespace string) *Cache {
+	if namespace == "" || strings.Contains(namespace, ":") {
+		log.Panic("Cache namespaces must be non empty and cannot contain ':'", "namespace", namespace)
+	}
+	return &Cache{namespace: namespace}
+}
+
+// Namespace returns the namespace of this cache
+func (c *Cache) Namespace() string {
+	return c.namespace
+}
+
+// fullKey returns the key of the store for the given key of this cache
+func (c *Cache) fullKey(prefix, key string) string {
+	return fmt.Sprintf("%s:%s:%s", prefix, c.namespace, key)
+}
+
+// Get decodes the value stored with the given key into dest, which must be
+// a pointer. It returns false if there is no such value, if it has expired
+// or if it cannot be read or decoded into dest.
+func (c *Cache) Get(key string, dest interface{}) bool {
+	s, prefix := currentStore()
+	data, ok, err := s.Get(c.fullKey(prefix, key))
+	if err != nil {
+		log.Warn("Unable to read cached value", "namespace", c.namespace, "key", key, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err = json.Unmarshal(data, dest); err != nil {
+		log.Warn("Unable to decode cached value", "namespace", c.namespace, "key", key, "error", err)
+		return false
+	}
+	return true
+}
+
+// Set stores the given value with the given key. The value expires after the
+// given ttl, or never if ttl is 0. It panics if value cannot be JSON encoded.
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Panic("Unable to encode value to cache", "namespace", c.namespace, "key", key, "error", err)
+	}
+	s, prefix := currentStore()
+	if err = s.Set(c.fullKey(prefix, key), data, ttl); err != nil {
+		log.Warn("Unable to cache value", "namespace", c.namespace, "key", key, "error", err)
+	}
+}
+
+// Delete removes the value stored with the given key
+func (c *Cache) Delete(key string) {
+	s, prefix := currentStore()
+	if err := s.Delete(c.fullKey(prefix, key)); err != nil {
+		log.Warn("Unable to delete cached value", "namespace", c.namespace, "key", key, "error", err)
+	}
+}
+
+// Clear removes all the values of this cache's namespace
+func (c *Cache) Clear() {
+	s, prefix := currentStore()
+	if err := s.Clear(c.fullKey(prefix, "")); err != nil {
+		log.Warn("Unable to clear cache", "namespace", c.namespace, "error", err)
+	}
+}
+
+// Fetch decodes the value stored with the given key into dest, which must be
+// a pointer. If there is no such value, compute is called and its result is
+// stored with the given ttl and decoded into dest.
+//
+// If compute returns an error, nothing is stored and the error is returned.
+func (c *Cache) Fetch(key string, ttl time.Duration, dest interface{}, compute func() (interface{}, error)) error {
+	if c.Get(key, dest) {
+		return nil
+	}
+	value, err := compute()
+	if err != nil {
+		return err
+	}
+	c.Set(key, value, ttl)
+	// We go through JSON so that dest is the same as when read from the cache
+	data, _ := json.Marshal(value)
+	return json.Unmarshal(data, dest)
+}
+
+// Initialize configures the store of the caches from the configuration:
+//
+// - Cache.RedisURL is the URL of the Redis server (e.g. 'redis://localhost:6379/0').
+// If it is empty, or if the server cannot be reached, values are stored in memory.
+//
+// - Cache.Prefix is the prefix of all keys, so that several Doxa instances can share
+// a Redis server. It defaults to 'doxa:<database name>'.
+func Initialize() {
+	prefix := viper.GetString("Cache.Prefix")
+	if prefix == "" {
+		prefix = fmt.Sprintf("%s:%s", DefaultPrefix, viper.GetString("DB.Name"))
+	}
+	url := viper.GetString("Cache.RedisURL")
+	if url == "" {
+		SetStore(NewMemoryStore(), prefix)
+		return
+	}
+	redisStore, err := NewRedisStore(url)
+	if err != nil {
+		log.Warn("Unable to connect to Redis cache server, falling back to memory cache", "error", err)
+		SetStore(NewMemoryStore(), prefix)
+		return
+	}
+	SetStore(redisStore, prefix)
+	log.Info("Redis cache enabled", "prefix", prefix)
+}
+
+// Shutdown closes the store of the caches
+func Shutdown() {
+	SetStore(NewMemoryStore(), DefaultPrefix)
+}
+
+func init() {
+	log = logging.GetLogger("cache")
+	store = NewMemoryStore()
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+type rate struct {
+	Code string
+	Rate float64
+}
+
+func TestMemoryStore(t *testing.T) {
+	Convey("Testing the memory store", t, func() {
+		ms := NewMemoryStore()
+		So(ms.Set("a:1", []byte("one"), 0), ShouldBeNil)
+		So(ms.Set("a:2", []byte("two"), time.Millisecond), ShouldBeNil)
+		So(ms.Set("b:1", []byte("three"), time.Hour), ShouldBeNil)
+		Convey("Values should be returned until they expire", func() {
+			value, ok, err := ms.Get("a:1")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(string(value), ShouldEqual, "one")
+			time.Sleep(2 * time.Millisecond)
+			_, ok, _ = ms.Get("a:2")
+			So(ok, ShouldBeFalse)
+			So(ms.entries, ShouldNotContainKey, "a:2")
+			_, ok, _ = ms.Get("b:1")
+			So(ok, ShouldBeTrue)
+		})
+		Convey("Expired values should be purged when storing values", func() {
+			time.Sleep(2 * time.Millisecond)
+			ms.lastPurge = time.Now().Add(-purgeInterval)
+			So(ms.Set("c:1", []byte("four"), 0), ShouldBeNil)
+			So(ms.entries, ShouldNotContainKey, "a:2")
+			So(ms.entries, ShouldHaveLength, 3)
+		})
+		Convey("Values should be deleted by key or by prefix", func() {
+			So(ms.Delete("b:1"), ShouldBeNil)
+			_, ok, _ := ms.Get("b:1")
+			So(ok, ShouldBeFalse)
+			So(ms.Clear("a:"), ShouldBeNil)
+			So(ms.entries, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestCache(t *testing.T) {
+	Convey("Testing namespaced caches", t, func() {
+		ms := NewMemoryStore()
+		SetStore(ms, "test")
+		rates := New("rates")
+		reports := New("reports")
+		Convey("Values should be stored under the namespace of the cache", func() {
+			rates.Set("EUR", rate{Code: "EUR", Rate: 1.1}, time.Hour)
+			So(ms.entries, ShouldContainKey, "test:rates:EUR")
+			var r rate
+			So(rates.Get("EUR", &r), ShouldBeTrue)
+			So(r, ShouldResemble, rate{Code: "EUR", Rate: 1.1})
+			So(reports.Get("EUR", &r), ShouldBeFalse)
+			var s string
+			So(rates.Get("EUR", &s), ShouldBeFalse)
+		})
+		Convey("Clearing a cache should not clear other namespaces", func() {
+			rates.Set("EUR", 1.1, 0)
+			reports.Set("EUR", "report", 0)
+			rates.Clear()
+			var f float64
+			So(rates.Get("EUR", &f), ShouldBeFalse)
+			var s string
+			So(reports.Get("EUR", &s), ShouldBeTrue)
+			reports.Delete("EUR")
+			So(reports.Get("EUR", &s), ShouldBeFalse)
+		})
+		Convey("Fetch should only compute missing values", func() {
+			var calls int
+			compute := func() (interface{}, error) {
+				calls++
+				return rate{Code: "USD", Rate: 1}, nil
+			}
+			var r rate
+			So(rates.Fetch("USD", time.Hour, &r, compute), ShouldBeNil)
+			So(r, ShouldResemble, rate{Code: "USD", Rate: 1})
+			r = rate{}
+			So(rates.Fetch("USD", time.Hour, &r, compute), ShouldBeNil)
+			So(r, ShouldResemble, rate{Code: "USD", Rate: 1})
+			So(calls, ShouldEqual, 1)
+			err := rates.Fetch("GBP", time.Hour, &r, func() (interface{}, error) {
+				return nil, errors.New("unavailable")
+			})
+			So(err, ShouldNotBeNil)
+			So(ms.entries, ShouldNotContainKey, "test:rates:GBP")
+		})
+		Convey("Invalid namespaces and values should panic", func() {
+			So(func() { New("") }, ShouldPanic)
+			So(func() { New("a:b") }, ShouldPanic)
+			So(func() { rates.Set("func", func() {}, 0) }, ShouldPanic)
+		})
+	})
+	Convey("Testing cache initialization", t, func() {
+		Convey("Caches should fall back to memory if Redis cannot be reached", func() {
+			viper.Set("Cache.RedisURL", "redis://127.0.0.1:1/0")
+			viper.Set("DB.Name", "doxa_test")
+			Initialize()
+			s, prefix := currentStore()
+			So(s, ShouldHaveSameTypeAs, new(MemoryStore))
+			So(prefix, ShouldEqual, "doxa:doxa_test")
+			viper.Set("Cache.RedisURL", "")
+			viper.Set("Cache.Prefix", "shared")
+			Initialize()
+			_, prefix = currentStore()
+			So(prefix, ShouldEqual, "shared")
+		})
+		Convey("Redis stores should fail to be created with invalid URLs", func() {
+			_, err := NewRedisStore("http://localhost")
+			So(err, ShouldNotBeNil)
+		})
+		Shutdown()
+	})
+}
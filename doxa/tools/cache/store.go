@@ -0,0 +1,238 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package cache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// A Store holds cached values by key.
+//
+// Stores must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored with the given key.
+	// The second returned value is false if there is no such value
+	// or if it has expired.
+	Get(key string) ([]byte, bool, error)
+	// Set stores the given value with the given key. The value expires
+	// after the given ttl. A ttl of 0 means that the value never expires.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes the value stored with the given key, if any.
+	Delete(key string) error
+	// Clear removes all the values with keys starting with the given prefix.
+	Clear(prefix string) error
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// purgeInterval is the minimum interval between two purges
+// of the expired values of a MemoryStore.
+const purgeInterval = time.Minute
+
+// A memoryEntry is a value of a MemoryStore with its expiration time
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// expired returns true if this entry has expired at the given time
+func (me memoryEntry) expired(now time.Time) bool {
+	return !me.expires.IsZero() && !now.Before(me.expires)
+}
+
+// A MemoryStore is a Store holding values in the memory of the process.
+//
+// Values are not shared between processes. Expired values are removed
+// when they are read and periodically when new values are stored.
+type MemoryStore struct {
+	sync.RWMutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+}
+
+// NewMemoryStore returns a new empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]memoryEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+// Get returns the value stored with the given key
+func (ms *MemoryStore) Get(key string) ([]byte, bool, error) {
+	ms.RLock()
+	entry, ok := ms.entries[key]
+	ms.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if now := time.Now(); entry.expired(now) {
+		ms.Lock()
+		// The value may have been set again in the meantime
+		if e, ok := ms.entries[key]; ok && e.expired(now) {
+			delete(ms.entries, key)
+		}
+		ms.Unlock()
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+// Set stores the given value with the given key for the given ttl
+func (ms *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	ms.entries[key] = entry
+	if now.Sub(ms.lastPurge) >= purgeInterval {
+		for k, e := range ms.entries {
+			if e.expired(now) {
+				delete(ms.entries, k)
+			}
+		}
+		ms.lastPurge = now
+	}
+	return nil
+}
+
+// Delete removes the value stored with the given key
+func (ms *MemoryStore) Delete(key string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.entries, key)
+	return nil
+}
+
+// Clear removes all the values with keys starting with the given prefix
+func (ms *MemoryStore) Clear(prefix string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for k := range ms.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(ms.entries, k)
+		}
+	}
+	return nil
+}
+
+// Close removes all values of this store
+func (ms *MemoryStore) Close() error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.entries = make(map[string]memoryEntry)
+	return nil
+}
+
+var _ Store = new(MemoryStore)
+
+// A RedisStore is a Store holding values in a Redis server,
+// so that they are shared by all the processes using this server.
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+// NewRedisStore returns a new RedisStore connecting to the Redis server at the
+// given URL, such as 'redis://:password@localhost:6379/0'. It returns an error
+// if the server cannot be reached.
+func NewRedisStore(url string) (*RedisStore, error) {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url,
+				redis.DialConnectTimeout(5*time.Second),
+				redis.DialReadTimeout(5*time.Second),
+				redis.DialWriteTimeout(5*time.Second))
+		},
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &RedisStore{pool: pool}, nil
+}
+
+// Get returns the value stored with the given key
+func (rs *RedisStore) Get(key string) ([]byte, bool, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("GET", key))
+	switch err {
+	case nil:
+		return value, true, nil
+	case redis.ErrNil:
+		return nil, false, nil
+	default:
+		return nil, false, err
+	}
+}
+
+// Set stores the given value with the given key for the given ttl
+func (rs *RedisStore) Set(key string, value []byte, ttl time.Duration) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+	var err error
+	if ttl > 0 {
+		_, err = conn.Do("SET", key, value, "PX", int64(ttl/time.Millisecond))
+	} else {
+		_, err = conn.Do("SET", key, value)
+	}
+	return err
+}
+
+// Delete removes the value stored with the given key
+func (rs *RedisStore) Delete(key string) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", key)
+	return err
+}
+
+// Clear removes all the values with keys starting with the given prefix.
+//
+// Keys are iterated with SCAN so that the server is not blocked.
+func (rs *RedisStore) Clear(prefix string) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+	pattern := globEscaper.Replace(prefix) + "*"
+	cursor := "0"
+	for {
+		res, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+		var keys []interface{}
+		if _, err = redis.Scan(res, &cursor, &keys); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err = conn.Do("DEL", keys...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Close closes the connections to the Redis server
+func (rs *RedisStore) Close() error {
+	return rs.pool.Close()
+}
+
+var _ Store = new(RedisStore)
+
+// globEscaper escapes the special characters of Redis glob-style patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
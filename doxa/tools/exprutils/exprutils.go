@@ -0,0 +1,1197 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package exprutils implements a safe evaluator of the expressions found in
+the domain and context attributes of XML resources and in the directives of
+QWeb templates.
+
+The language is the subset of Python literals and operators that is commonly
+used in these attributes:
+
+	[('user_id', '=', uid), ('date', '<=', today)]
+	{'default_partner_id': active_id, 'lang': context.get('lang', 'en_US')}
+
+	[('date_deadline', '<', today()), ('partner_id', '=', parent.partner_id)]
+
+It has numbers, strings, True, False and None, lists, tuples (which evaluate
+to lists) and dicts, the 'not', 'and', 'or', 'in' and 'not in' keywords,
+comparisons, arithmetic operators (+, -, *, / and %), subscripts, attributes
+and the 'get' method of dicts, and calls of functions and methods. Names are
+only looked up in the variables given to Eval, so that expressions cannot
+access anything else. Attributes refer to the keys of dicts, such as the
+fields of a record given as a variable, and only variables holding a Func
+can be called.
+
+Evaluating an expression in a Scope with EvalIn gives attributes to other
+values than dicts. The Scope decides which attributes exist, and which
+methods can be called since they are attributes holding a Func.
+*/
+package exprutils
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Func is a function that can be called in expressions. Functions are
+// given to Eval as variables, and their arguments and result are values
+// of expressions.
+type Func func(args ...interface{}) (interface{}, error)
+
+// FuncOf returns a Func that calls the given Go function. Arguments are
+// converted to the types of the parameters of fn and nil arguments to their
+// zero values. The Func returns the first result of fn, and its last result
+// if it is an error.
+func FuncOf(fn interface{}) Func {
+	fnVal := reflect.ValueOf(fn)
+	return func(args ...interface{}) (interface{}, error) {
+		if fnVal.Kind() != reflect.Func {
+			return nil, fmt.Errorf("%v is not a function", fn)
+		}
+		fnType := fnVal.Type()
+		if fnType.IsVariadic() && len(args) < fnType.NumIn()-1 || !fnType.IsVariadic() && len(args) != fnType.NumIn() {
+			return nil, fmt.Errorf("wrong number of arguments: expected %d, got %d", fnType.NumIn(), len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var argType reflect.Type
+			if fnType.IsVariadic() && i >= fnType.NumIn()-1 {
+				argType = fnType.In(fnType.NumIn() - 1).Elem()
+			} else {
+				argType = fnType.In(i)
+			}
+			switch {
+			case arg == nil:
+				in[i] = reflect.Zero(argType)
+			case reflect.TypeOf(arg).ConvertibleTo(argType):
+				in[i] = reflect.ValueOf(arg).Convert(argType)
+			default:
+				return nil, fmt.Errorf("cannot use %v as %s argument", arg, argType)
+			}
+		}
+		out := fnVal.Call(in)
+		if len(out) == 0 {
+			return nil, nil
+		}
+		if last := out[len(out)-1]; last.Type() == errorType {
+			if !last.IsNil() {
+				return nil, last.Interface().(error)
+			}
+			if len(out) == 1 {
+				return nil, nil
+			}
+		}
+		return out[0].Interface(), nil
+	}
+}
+
+// errorType is the type of the error interface
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// A Scope gives the values of the names of expressions, and the attributes
+// of the values which are not dicts. Attributes holding a Func are methods,
+// which can be called but not read.
+type Scope interface {
+	// Lookup returns the value of the given name and true,
+	// or false if the name is unknown.
+	Lookup(name string) (interface{}, bool)
+	// Attr returns the attribute with the given name of val,
+	// which is not a dict, or an error if it has none.
+	Attr(val interface{}, name string) (interface{}, error)
+}
+
+// Vars is the Scope of the given variables, in which only dicts
+// have attributes.
+type Vars map[string]interface{}
+
+// Lookup returns the value of the variable with the given name
+func (v Vars) Lookup(name string) (interface{}, bool) {
+	val, ok := v[name]
+	return val, ok
+}
+
+// Attr returns an error, since only dicts have attributes
+func (v Vars) Attr(val interface{}, name string) (interface{}, error) {
+	return nil, fmt.Errorf("%v has no attribute %q", val, name)
+}
+
+// An Expr is a compiled expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// exprCache holds the compiled expressions by source
+var exprCache sync.Map
+
+// Compile returns the compiled expression of the given source
+func Compile(src string) (*Expr, error) {
+	if expr, ok := exprCache.Load(src); ok {
+		return expr.(*Expr), nil
+	}
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d in expression %q", tok.value, tok.pos, src)
+	}
+	expr := &Expr{src: src, root: root}
+	exprCache.Store(src, expr)
+	return expr, nil
+}
+
+// Eval compiles and evaluates the given expression with the given variables
+func Eval(src string, vars map[string]interface{}) (interface{}, error) {
+	expr, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr.Eval(vars)
+}
+
+// Eval evaluates this expression with the given variables.
+//
+// Integers evaluate to int64, floats to float64, lists and tuples
+// to []interface{} and dicts to map[string]interface{}.
+func (e *Expr) Eval(vars map[string]interface{}) (interface{}, error) {
+	return e.EvalIn(Vars(vars))
+}
+
+// EvalIn evaluates this expression in the given scope, as Eval
+func (e *Expr) EvalIn(scope Scope) (interface{}, error) {
+	res, err := e.root.eval(scope)
+	if err != nil {
+		return nil, fmt.Errorf("%s in expression %q", err, e.src)
+	}
+	return res, nil
+}
+
+// Names returns the sorted names of the variables used in this expression
+func (e *Expr) Names() []string {
+	names := make(map[string]bool)
+	e.root.names(names)
+	res := make([]string, 0, len(names))
+	for name := range names {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// String returns the source of this expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokName
+	tokNumber
+	tokString
+	tokOp
+)
+
+// A token is a token of an expression
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+// operators are the operators of expressions, longest first
+var operators = []string{"==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "(", ")", "[", "]", "{", "}", ",", ":", "."}
+
+// tokenize splits the given expression source into tokens
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+tokenLoop:
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokName, value: src[start:i], pos: start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, value: src[start:i], pos: start})
+		case c == '\'' || c == '"':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(src) && src[i] != c; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				sb.WriteByte(src[i])
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d in expression %q", start, src)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, value: sb.String(), pos: start})
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, value: op, pos: i})
+					i += len(op)
+					continue tokenLoop
+				}
+			}
+			return nil, fmt.Errorf("unexpected character %q at position %d in expression %q", c, i, src)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// A parser builds the syntax tree of an expression from its tokens
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// peek returns the current token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the current token
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the current token and returns true if it
+// has the given kind and value.
+func (p *parser) accept(kind int, value string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the current operator token or returns
+// an error if it is not the given operator.
+func (p *parser) expect(op string) error {
+	if !p.accept(tokOp, op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q at position %d in expression %q", op, tok.pos, p.src)
+	}
+	return nil
+}
+
+// parseOr parses a sequence of 'or' operations
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokName, "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses a sequence of 'and' operations
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokName, "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses a 'not' operation or a comparison
+func (p *parser) parseNot() (node, error) {
+	if p.accept(tokName, "not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses a comparison or an additive expression
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	tok := p.peek()
+	switch {
+	case tok.kind == tokOp && (tok.value == "==" || tok.value == "!=" || tok.value == "<" || tok.value == "<=" || tok.value == ">" || tok.value == ">="):
+		op = p.next().value
+	case tok.kind == tokName && tok.value == "in":
+		op = p.next().value
+	case tok.kind == tokName && tok.value == "not" && p.tokens[p.pos+1].kind == tokName && p.tokens[p.pos+1].value == "in":
+		p.pos += 2
+		op = "not in"
+	default:
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+// parseAdditive parses additions and subtractions
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || tok.value != "+" && tok.value != "-" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = arithNode{op: tok.value, left: left, right: right}
+	}
+}
+
+// parseMultiplicative parses multiplications, divisions and modulos
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || tok.value != "*" && tok.value != "/" && tok.value != "%" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = arithNode{op: tok.value, left: left, right: right}
+	}
+}
+
+// parseUnary parses a negation or a postfix expression
+func (p *parser) parseUnary() (node, error) {
+	if p.accept(tokOp, "-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return arithNode{op: "-", left: literalNode{value: int64(0)}, right: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses subscripts, attributes and method calls
+func (p *parser) parsePostfix() (node, error) {
+	operand, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(tokOp, "["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			operand = indexNode{operand: operand, key: key}
+		case p.accept(tokOp, "."):
+			tok := p.next()
+			if tok.kind != tokName {
+				return nil, fmt.Errorf("unexpected %q at position %d in expression %q", tok.value, tok.pos, p.src)
+			}
+			if !p.accept(tokOp, "(") {
+				operand = attrNode{operand: operand, name: tok.value}
+				continue
+			}
+			args, err := p.parseSequence(")")
+			if err != nil {
+				return nil, err
+			}
+			operand = methodNode{operand: operand, name: tok.value, args: args}
+		default:
+			return operand, nil
+		}
+	}
+}
+
+// parseSequence parses comma separated expressions until the given closing
+// operator, which is consumed. A trailing comma is allowed.
+func (p *parser) parseSequence(closing string) ([]node, error) {
+	var res []node
+	for !p.accept(tokOp, closing) {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, item)
+		if !p.accept(tokOp, ",") {
+			if err := p.expect(closing); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return res, nil
+}
+
+// parsePrimary parses literals, names, function calls, lists,
+// tuples, dicts and parenthesized expressions.
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		if strings.Contains(tok.value, ".") {
+			val, err := strconv.ParseFloat(tok.value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d in expression %q", tok.value, tok.pos, p.src)
+			}
+			return literalNode{value: val}, nil
+		}
+		val, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d in expression %q", tok.value, tok.pos, p.src)
+		}
+		return literalNode{value: val}, nil
+	case tokString:
+		return literalNode{value: tok.value}, nil
+	case tokName:
+		switch tok.value {
+		case "True", "true":
+			return literalNode{value: true}, nil
+		case "False", "false":
+			return literalNode{value: false}, nil
+		case "None", "null":
+			return literalNode{}, nil
+		case "and", "or", "not", "in":
+			return nil, fmt.Errorf("unexpected %q at position %d in expression %q", tok.value, tok.pos, p.src)
+		}
+		if p.accept(tokOp, "(") {
+			args, err := p.parseSequence(")")
+			if err != nil {
+				return nil, err
+			}
+			return callNode{name: tok.value, args: args}, nil
+		}
+		return nameNode{name: tok.value}, nil
+	case tokOp:
+		switch tok.value {
+		case "[":
+			items, err := p.parseSequence("]")
+			if err != nil {
+				return nil, err
+			}
+			return listNode{items: items}, nil
+		case "(":
+			if p.accept(tokOp, ")") {
+				return listNode{}, nil
+			}
+			first, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if p.accept(tokOp, ")") {
+				return first, nil
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			items, err := p.parseSequence(")")
+			if err != nil {
+				return nil, err
+			}
+			return listNode{items: append([]node{first}, items...)}, nil
+		case "{":
+			return p.parseDict()
+		}
+	}
+	if tok.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d in expression %q", tok.value, tok.pos, p.src)
+}
+
+// parseDict parses the entries of a dict, after its opening brace
+func (p *parser) parseDict() (node, error) {
+	var res dictNode
+	for !p.accept(tokOp, "}") {
+		key, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		res.keys = append(res.keys, key)
+		res.values = append(res.values, value)
+		if !p.accept(tokOp, ",") {
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return res, nil
+}
+
+// A node is a node of the syntax tree of an expression
+type node interface {
+	eval(scope Scope) (interface{}, error)
+	names(res map[string]bool)
+}
+
+// A literalNode is a constant value
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(scope Scope) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n literalNode) names(res map[string]bool) {}
+
+// A nameNode is a variable
+type nameNode struct {
+	name string
+}
+
+func (n nameNode) eval(scope Scope) (interface{}, error) {
+	val, ok := scope.Lookup(n.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q", n.name)
+	}
+	if _, ok := val.(Func); ok {
+		return nil, fmt.Errorf("function %q must be called", n.name)
+	}
+	return normalize(val), nil
+}
+
+func (n nameNode) names(res map[string]bool) {
+	res[n.name] = true
+}
+
+// A callNode is a call of a function given as a variable
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n callNode) eval(scope Scope) (interface{}, error) {
+	val, ok := scope.Lookup(n.name)
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q", n.name)
+	}
+	fnct, ok := val.(Func)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a function", n.name)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		argVal, err := arg.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = argVal
+	}
+	res, err := fnct(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s() failed: %s", n.name, err)
+	}
+	return normalize(res), nil
+}
+
+func (n callNode) names(res map[string]bool) {
+	res[n.name] = true
+	for _, arg := range n.args {
+		arg.names(res)
+	}
+}
+
+// A listNode is a list or a tuple
+type listNode struct {
+	items []node
+}
+
+func (n listNode) eval(scope Scope) (interface{}, error) {
+	res := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		val, err := item.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+	return res, nil
+}
+
+func (n listNode) names(res map[string]bool) {
+	for _, item := range n.items {
+		item.names(res)
+	}
+}
+
+// A dictNode is a dict. Its keys must evaluate to strings.
+type dictNode struct {
+	keys   []node
+	values []node
+}
+
+func (n dictNode) eval(scope Scope) (interface{}, error) {
+	res := make(map[string]interface{}, len(n.keys))
+	for i, keyNode := range n.keys {
+		key, err := keyNode.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %v", key)
+		}
+		val, err := n.values[i].eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		res[keyStr] = val
+	}
+	return res, nil
+}
+
+func (n dictNode) names(res map[string]bool) {
+	for i := range n.keys {
+		n.keys[i].names(res)
+		n.values[i].names(res)
+	}
+}
+
+// A notNode is a 'not' operation
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(scope Scope) (interface{}, error) {
+	val, err := n.operand.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	return !Truth(val), nil
+}
+
+func (n notNode) names(res map[string]bool) {
+	n.operand.names(res)
+}
+
+// A logicalNode is an 'and' or an 'or' operation. As in Python, it
+// evaluates to the last evaluated operand.
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicalNode) eval(scope Scope) (interface{}, error) {
+	left, err := n.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if Truth(left) == (n.op == "or") {
+		return left, nil
+	}
+	return n.right.eval(scope)
+}
+
+func (n logicalNode) names(res map[string]bool) {
+	n.left.names(res)
+	n.right.names(res)
+}
+
+// A compareNode is a comparison or a membership test
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(scope Scope) (interface{}, error) {
+	left, err := n.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in", "not in":
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, err
+		}
+		return found == (n.op == "in"), nil
+	}
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func (n compareNode) names(res map[string]bool) {
+	n.left.names(res)
+	n.right.names(res)
+}
+
+// An arithNode is an arithmetic operation. Additions also concatenate
+// strings and lists. As in Python, divisions always give floats and
+// modulos have the sign of their right operand.
+type arithNode struct {
+	op          string
+	left, right node
+}
+
+func (n arithNode) eval(scope Scope) (interface{}, error) {
+	left, err := n.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "+" {
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []interface{}:
+			if r, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+	}
+	li, lInt := left.(int64)
+	ri, rInt := right.(int64)
+	if lInt && rInt && n.op != "/" {
+		switch n.op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		}
+		if ri == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		res := li % ri
+		if res != 0 && (res < 0) != (ri < 0) {
+			res += ri
+		}
+		return res, nil
+	}
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("unsupported operands for %s: %v and %v", n.op, left, right)
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	}
+	if rf == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if n.op == "/" {
+		return lf / rf, nil
+	}
+	res := math.Mod(lf, rf)
+	if res != 0 && (res < 0) != (rf < 0) {
+		res += rf
+	}
+	return res, nil
+}
+
+func (n arithNode) names(res map[string]bool) {
+	n.left.names(res)
+	n.right.names(res)
+}
+
+// An indexNode is a subscript of a list or a dict
+type indexNode struct {
+	operand, key node
+}
+
+func (n indexNode) eval(scope Scope) (interface{}, error) {
+	operand, err := n.operand.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch o := operand.(type) {
+	case []interface{}:
+		idx, ok := key.(int64)
+		if !ok {
+			return nil, fmt.Errorf("list indices must be integers, got %v", key)
+		}
+		if idx < 0 {
+			idx += int64(len(o))
+		}
+		if idx < 0 || idx >= int64(len(o)) {
+			return nil, fmt.Errorf("list index %d out of range", idx)
+		}
+		return normalize(o[idx]), nil
+	}
+	if dict, ok := asDict(operand); ok {
+		keyStr, _ := key.(string)
+		val, ok := dict[keyStr]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %v", key)
+		}
+		return normalize(val), nil
+	}
+	return nil, fmt.Errorf("%v is not subscriptable", operand)
+}
+
+func (n indexNode) names(res map[string]bool) {
+	n.operand.names(res)
+	n.key.names(res)
+}
+
+// An attrNode is an attribute of a dict, i.e. the value of one of its
+// keys, or an attribute of another value given by the scope.
+type attrNode struct {
+	operand node
+	name    string
+}
+
+func (n attrNode) eval(scope Scope) (interface{}, error) {
+	operand, err := n.operand.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	if dict, ok := asDict(operand); ok {
+		val, ok := dict[n.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %q", n.name)
+		}
+		return normalize(val), nil
+	}
+	if operand == nil {
+		return nil, fmt.Errorf("None has no attribute %q", n.name)
+	}
+	val, err := scope.Attr(operand, n.name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := val.(Func); ok {
+		return nil, fmt.Errorf("method %q must be called", n.name)
+	}
+	return normalize(val), nil
+}
+
+func (n attrNode) names(res map[string]bool) {
+	n.operand.names(res)
+}
+
+// A methodNode is a call to the 'get' method of a dict, or to
+// a method of another value, which is a Func attribute.
+type methodNode struct {
+	operand node
+	name    string
+	args    []node
+}
+
+func (n methodNode) eval(scope Scope) (interface{}, error) {
+	operand, err := n.operand.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	var fnct Func
+	dict, isDict := asDict(operand)
+	switch {
+	case isDict && n.name != "get":
+		return nil, fmt.Errorf("unknown method %q of dict", n.name)
+	case isDict:
+		fnct = dictGet(dict)
+	case operand == nil:
+		return nil, fmt.Errorf("None has no method %q", n.name)
+	default:
+		attr, err := scope.Attr(operand, n.name)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if fnct, ok = attr.(Func); !ok {
+			return nil, fmt.Errorf("%q is not a method", n.name)
+		}
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		argVal, err := arg.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = argVal
+	}
+	res, err := fnct(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s() failed: %s", n.name, err)
+	}
+	return normalize(res), nil
+}
+
+func (n methodNode) names(res map[string]bool) {
+	n.operand.names(res)
+	for _, arg := range n.args {
+		arg.names(res)
+	}
+}
+
+// dictGet returns the 'get' method of the given dict, which returns the
+// value of a key or a default value (None if not given) if it is missing.
+func dictGet(dict map[string]interface{}) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("get takes 1 or 2 arguments, got %d", len(args))
+		}
+		keyStr, _ := args[0].(string)
+		if val, ok := dict[keyStr]; ok {
+			return val, nil
+		}
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return nil, nil
+	}
+}
+
+// dictType is the type of dicts
+var dictType = reflect.TypeOf(map[string]interface{}{})
+
+// asDict returns val as a dict if it is a map[string]interface{}
+// or a value of a type based on it, such as models.FieldMap.
+func asDict(val interface{}) (map[string]interface{}, bool) {
+	if dict, ok := val.(map[string]interface{}); ok {
+		return dict, true
+	}
+	if val == nil || reflect.TypeOf(val).Kind() != reflect.Map || !reflect.TypeOf(val).ConvertibleTo(dictType) {
+		return nil, false
+	}
+	return reflect.ValueOf(val).Convert(dictType).Interface().(map[string]interface{}), true
+}
+
+// normalize converts the given variable value to the types of expression
+// values: integers to int64, floats to float64, slices to []interface{}
+// and maps with string keys to map[string]interface{}. Values of types
+// with methods, such as translatable strings, are kept as they are.
+func normalize(val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(val)
+	if rv.NumMethod() > 0 {
+		return val
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Slice:
+		if _, ok := val.([]interface{}); ok {
+			return val
+		}
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			res[i] = normalize(rv.Index(i).Interface())
+		}
+		return res
+	case reflect.Map:
+		if _, ok := val.(map[string]interface{}); ok || rv.Type().Key().Kind() != reflect.String {
+			return val
+		}
+		res := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			res[key.String()] = normalize(rv.MapIndex(key).Interface())
+		}
+		return res
+	}
+	return val
+}
+
+// Truth returns the truth value of the given value, as in Python: None,
+// False, zeros, empty strings, lists and dicts and values with a Len method
+// returning 0, such as empty RecordSets, are false.
+func Truth(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	case interface{ Len() int }:
+		return v.Len() > 0
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() > 0
+	case reflect.Ptr:
+		return !rv.IsNil()
+	}
+	return true
+}
+
+// toFloat returns the given numeric value as a float64
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal returns true if the given values are equal.
+// Integers and floats are compared by value.
+func equal(left, right interface{}) bool {
+	if lf, ok := toFloat(left); ok {
+		rf, ok := toFloat(right)
+		return ok && lf == rf
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// contains returns true if the given container holds the given item.
+// Containers are lists, dicts (by key) and strings (by substring).
+func contains(container, item interface{}) (bool, error) {
+	switch c := container.(type) {
+	case []interface{}:
+		for _, elt := range c {
+			if equal(elt, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case string:
+		if sub, ok := item.(string); ok {
+			return strings.Contains(c, sub), nil
+		}
+	}
+	if dict, ok := asDict(container); ok {
+		key, _ := item.(string)
+		_, ok := dict[key]
+		return ok, nil
+	}
+	return false, fmt.Errorf("unsupported membership test of %v in %v", item, container)
+}
+
+// compare returns -1, 0 or 1 if left is less than, equal to or greater
+// than right. Only numbers and strings can be compared.
+func compare(left, right interface{}) (int, error) {
+	if lf, ok := toFloat(left); ok {
+		if rf, ok := toFloat(right); ok {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v and %v", left, right)
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package exprutils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpressions(t *testing.T) {
+	vars := map[string]interface{}{
+		"uid":        int64(2),
+		"active_id":  7,
+		"active_ids": []int64{7, 8},
+		"context":    map[string]interface{}{"lang": "fr_FR", "active_test": false},
+		"parent":     map[string]interface{}{"partner_id": int64(3), "company": map[string]interface{}{"name": "NDP"}},
+		"today": Func(func(args ...interface{}) (interface{}, error) {
+			return "2017-06-30", nil
+		}),
+		"max": Func(func(args ...interface{}) (interface{}, error) {
+			if len(args) != 2 {
+				return nil, errors.New("max takes 2 arguments")
+			}
+			if args[0].(int64) > args[1].(int64) {
+				return args[0], nil
+			}
+			return args[1], nil
+		}),
+	}
+	Convey("Testing expression evaluation", t, func() {
+		Convey("Literals", func() {
+			So(mustEval("42", nil), ShouldEqual, int64(42))
+			So(mustEval("-1.5", nil), ShouldEqual, -1.5)
+			So(mustEval(`'it\'s'`, nil), ShouldEqual, "it's")
+			So(mustEval(`"double"`, nil), ShouldEqual, "double")
+			So(mustEval("True", nil), ShouldEqual, true)
+			So(mustEval("false", nil), ShouldEqual, false)
+			So(mustEval("None", nil), ShouldBeNil)
+		})
+		Convey("Lists, tuples and dicts", func() {
+			So(mustEval("[1, 'a', (2, 3), ()]", nil), ShouldResemble,
+				[]interface{}{int64(1), "a", []interface{}{int64(2), int64(3)}, []interface{}{}})
+			So(mustEval("(1,)", nil), ShouldResemble, []interface{}{int64(1)})
+			So(mustEval("(1)", nil), ShouldEqual, int64(1))
+			So(mustEval(`{'a': 1, "b": [True],}`, nil), ShouldResemble,
+				map[string]interface{}{"a": int64(1), "b": []interface{}{true}})
+			So(mustEval("{}", nil), ShouldResemble, map[string]interface{}{})
+		})
+		Convey("Variables", func() {
+			So(mustEval("[('user_id', '=', uid), ('date', '<=', today())]", vars), ShouldResemble, []interface{}{
+				[]interface{}{"user_id", "=", int64(2)},
+				[]interface{}{"date", "<=", "2017-06-30"},
+			})
+			So(mustEval("{'default_partner_id': active_id}", vars), ShouldResemble, map[string]interface{}{"default_partner_id": int64(7)})
+			So(mustEval("[('id', 'in', active_ids)]", vars), ShouldResemble, []interface{}{
+				[]interface{}{"id", "in", []interface{}{int64(7), int64(8)}},
+			})
+			So(mustEval("active_ids[0]", vars), ShouldEqual, int64(7))
+			So(mustEval("active_ids[-1]", vars), ShouldEqual, int64(8))
+			So(mustEval("context['lang']", vars), ShouldEqual, "fr_FR")
+			So(mustEval("context.get('lang')", vars), ShouldEqual, "fr_FR")
+			So(mustEval("context.get('tz')", vars), ShouldBeNil)
+			So(mustEval("context.get('tz', 'UTC')", vars), ShouldEqual, "UTC")
+		})
+		Convey("Operators", func() {
+			So(mustEval("uid + 1 - 0.5", vars), ShouldEqual, 2.5)
+			So(mustEval("'a' + 'b'", nil), ShouldEqual, "ab")
+			So(mustEval("[1] + [2]", nil), ShouldResemble, []interface{}{int64(1), int64(2)})
+			So(mustEval("uid == 2 and active_id > 5", vars), ShouldEqual, true)
+			So(mustEval("uid != 2 or 'x'", vars), ShouldEqual, "x")
+			So(mustEval("not context.get('active_test', True)", vars), ShouldEqual, true)
+			So(mustEval("8 in active_ids", vars), ShouldEqual, true)
+			So(mustEval("'tz' not in context", vars), ShouldEqual, true)
+			So(mustEval("today() >= '2017-01-01'", vars), ShouldEqual, true)
+			So(mustEval("1 == 1.0", nil), ShouldEqual, true)
+			So(mustEval("2 + uid * 3", vars), ShouldEqual, int64(8))
+			So(mustEval("(2 + uid) * 3", vars), ShouldEqual, int64(12))
+			So(mustEval("7 / 2", nil), ShouldEqual, 3.5)
+			So(mustEval("-7 % 3", nil), ShouldEqual, int64(2))
+			So(mustEval("7.5 % -2", nil), ShouldEqual, -0.5)
+		})
+		Convey("Attributes and function calls", func() {
+			So(mustEval("parent.partner_id", vars), ShouldEqual, int64(3))
+			So(mustEval("parent.company.name", vars), ShouldEqual, "NDP")
+			So(mustEval("[('partner_id', '=', parent.partner_id)]", vars), ShouldResemble, []interface{}{
+				[]interface{}{"partner_id", "=", int64(3)},
+			})
+			So(mustEval("today()", vars), ShouldEqual, "2017-06-30")
+			So(mustEval("max(uid, parent.partner_id)", vars), ShouldEqual, int64(3))
+		})
+		Convey("Attributes and methods given by a scope", func() {
+			scope := testScope{Vars: vars}
+			expr, err := Compile("uid.double + uid.add(1, 2)")
+			So(err, ShouldBeNil)
+			res, err := expr.EvalIn(scope)
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, int64(9))
+			for _, src := range []string{"uid.add", "uid.triple", "uid.double()", "None.add(1)", "context.add(1)"} {
+				expr, err := Compile(src)
+				So(err, ShouldBeNil)
+				_, err = expr.EvalIn(scope)
+				So(err, ShouldNotBeNil)
+			}
+			_, err = expr.Eval(vars)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Go functions", func() {
+			repeat := FuncOf(strings.Repeat)
+			res, err := repeat("ab", int64(2))
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "abab")
+			_, err = repeat("ab")
+			So(err, ShouldNotBeNil)
+			_, err = repeat("ab", "2")
+			So(err, ShouldNotBeNil)
+			join := FuncOf(func(sep string, items ...string) (string, error) {
+				if len(items) == 0 {
+					return "", errors.New("nothing to join")
+				}
+				return strings.Join(items, sep), nil
+			})
+			res, err = join(", ", "a", "b")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "a, b")
+			_, err = join(", ")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Truth values", func() {
+			So(Truth(testLen(0)), ShouldBeFalse)
+			So(Truth(testLen(2)), ShouldBeTrue)
+			So(Truth([]string{}), ShouldBeFalse)
+			So(Truth(map[string]string{"a": "b"}), ShouldBeTrue)
+			So(Truth((*int)(nil)), ShouldBeFalse)
+		})
+		Convey("Names of variables", func() {
+			expr, err := Compile("[('user_id', '=', uid), ('id', 'in', active_ids or [context.get('x')])]")
+			So(err, ShouldBeNil)
+			So(expr.Names(), ShouldResemble, []string{"active_ids", "context", "uid"})
+			expr, err = Compile("[('date', '<', today()), ('partner_id', '=', parent.partner_id)]")
+			So(err, ShouldBeNil)
+			So(expr.Names(), ShouldResemble, []string{"parent", "today"})
+		})
+		Convey("Syntax errors", func() {
+			for _, src := range []string{"", "[1, 2", "{'a' 1}", "'unterminated", "a.1", "1 +", "uid; 1", "import os", "(f)(1)"} {
+				_, err := Compile(src)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("Evaluation errors", func() {
+			for _, src := range []string{"unknown", "active_ids['a']", "active_ids[5]", "context['tz']", "uid + 'a'", "uid < 'a'", "{1: 2}", "uid.get('a')", "1 in 2",
+				"1 / 0", "1 % 0", "'a' * 2", "context.keys()", "context.get()",
+				"today", "uid()", "unknown()", "max(1)", "parent.unknown", "uid.name", "__import__('os')"} {
+				_, err := Eval(src, vars)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+// A testScope gives the double attribute and the add method to integers
+type testScope struct {
+	Vars
+}
+
+func (s testScope) Attr(val interface{}, name string) (interface{}, error) {
+	i, ok := val.(int64)
+	switch {
+	case ok && name == "double":
+		return i * 2, nil
+	case ok && name == "add":
+		return Func(func(args ...interface{}) (interface{}, error) {
+			return i + args[0].(int64) + args[1].(int64), nil
+		}), nil
+	}
+	return s.Vars.Attr(val, name)
+}
+
+// A testLen is a value with a length
+type testLen int
+
+func (l testLen) Len() int {
+	return int(l)
+}
+
+func mustEval(src string, vars map[string]interface{}) interface{} {
+	res, err := Eval(src, vars)
+	So(err, ShouldBeNil)
+	return res
+}
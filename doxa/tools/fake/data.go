@@ -0,0 +1,72 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package fake
+
+var firstNames = []string{
+	"Adam", "Alice", "Amelia", "Anna", "Arthur", "Ben", "Charlotte", "Chloe", "Daniel", "David",
+	"Elena", "Emma", "Ethan", "Eva", "Felix", "Grace", "Hannah", "Hugo", "Isabel", "Jack",
+	"James", "Julia", "Leo", "Lily", "Louis", "Lucas", "Lucy", "Marie", "Martin", "Mia",
+	"Noah", "Nora", "Oliver", "Olivia", "Paul", "Rose", "Samuel", "Sophie", "Thomas", "Zoe",
+}
+
+var lastNames = []string{
+	"Adams", "Baker", "Bernard", "Brown", "Clark", "Davis", "Dubois", "Durand", "Evans", "Fischer",
+	"Garcia", "Green", "Hall", "Harris", "Jackson", "Johnson", "King", "Lambert", "Lee", "Lewis",
+	"Martin", "Meyer", "Miller", "Moore", "Moreau", "Muller", "Nelson", "Petit", "Robert", "Rossi",
+	"Schmidt", "Smith", "Taylor", "Thomas", "Turner", "Walker", "Weber", "White", "Wilson", "Young",
+}
+
+var companySuffixes = []string{
+	"& Co", "& Sons", "Consulting", "Group", "Holdings", "Industries", "Logistics", "Partners",
+	"Services", "Systems", "Technologies", "Trading",
+}
+
+var streetNames = []string{
+	"Acacia", "Bridge", "Castle", "Cedar", "Church", "Elm", "Garden", "High", "Hill", "Lake",
+	"Maple", "Market", "Mill", "North", "Oak", "Park", "River", "School", "Station", "Victoria",
+}
+
+var streetTypes = []string{"Avenue", "Boulevard", "Lane", "Road", "Street", "Way"}
+
+// An address is a city with its zip code and country,
+// so that generated addresses are consistent.
+type address struct {
+	city    string
+	zip     string
+	country string
+}
+
+var addresses = []address{
+	{city: "Amsterdam", zip: "1012", country: "Netherlands"},
+	{city: "Barcelona", zip: "08001", country: "Spain"},
+	{city: "Berlin", zip: "10115", country: "Germany"},
+	{city: "Boston", zip: "02108", country: "United States"},
+	{city: "Brussels", zip: "1000", country: "Belgium"},
+	{city: "Chicago", zip: "60601", country: "United States"},
+	{city: "Dublin", zip: "D01", country: "Ireland"},
+	{city: "Geneva", zip: "1201", country: "Switzerland"},
+	{city: "Lisbon", zip: "1100", country: "Portugal"},
+	{city: "London", zip: "EC1A", country: "United Kingdom"},
+	{city: "Lyon", zip: "69001", country: "France"},
+	{city: "Madrid", zip: "28001", country: "Spain"},
+	{city: "Manchester", zip: "M1", country: "United Kingdom"},
+	{city: "Milan", zip: "20121", country: "Italy"},
+	{city: "Montreal", zip: "H2Y", country: "Canada"},
+	{city: "Munich", zip: "80331", country: "Germany"},
+	{city: "Paris", zip: "75001", country: "France"},
+	{city: "Rome", zip: "00184", country: "Italy"},
+	{city: "Toronto", zip: "M5H", country: "Canada"},
+	{city: "Vienna", zip: "1010", country: "Austria"},
+}
+
+var domains = []string{"example.com", "example.net", "example.org"}
+
+var words = []string{
+	"account", "agreement", "amount", "analysis", "annual", "approval", "balance", "budget", "call", "client",
+	"contract", "cost", "customer", "delivery", "demand", "design", "detail", "document", "estimate", "event",
+	"forecast", "goal", "growth", "invoice", "issue", "item", "market", "meeting", "method", "order",
+	"payment", "plan", "price", "process", "product", "project", "proposal", "quality", "quote", "report",
+	"request", "resource", "review", "sale", "schedule", "service", "shipment", "stock", "strategy", "supplier",
+	"support", "target", "task", "team", "training", "update", "value", "vendor", "volume", "warehouse",
+}
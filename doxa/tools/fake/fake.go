@@ -0,0 +1,437 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package fake generates records with realistic random values for any model, such
+as names, addresses, dates and relations to other records. It is used by the
+large demo mode and by performance benchmarks.
+
+Generated records only depend on the seed of the Generator and on the records
+already in the database, so that demo databases and benchmarks can be
+reproduced:
+
+	g := fake.New(42)
+	g.Generate(env,
+		fake.Volume{Model: "Partner", Count: 10000},
+		fake.Volume{Model: "SaleOrder", Count: 50000, Fields: fake.Fields{
+			"State": fake.Pick("draft", "sale", "done"),
+		}},
+	)
+
+Values are generated from the type and the name of the fields: a char field named
+"Email" gets an email address and a field named "City" the name of a city, with the
+fields of a record being consistent with each other. Many2one and many2many fields
+are set to random records of the related model, in the order of their ids.
+
+Fields with a default value, computed fields and the fields set by the ORM are left
+out. Fields that must follow model constraints must be given a FieldFunc.
+*/
+package fake
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/types/dates"
+)
+
+// maxMany2ManyRecords is the maximum number of records
+// set by a Generator in a many2many field.
+const maxMany2ManyRecords = 3
+
+// ormFields are the fields that are set by the ORM itself
+var ormFields = map[string]bool{
+	"id":               true,
+	"create_date":      true,
+	"create_uid":       true,
+	"write_date":       true,
+	"write_uid":        true,
+	"__last_update":    true,
+	"display_name":     true,
+	"doxa_external_id": true,
+	"doxa_version":     true,
+	"doxa_no_update":   true,
+}
+
+// A FieldFunc returns the value of a field of the nth record of a model
+// created by a Generator. Records are numbered from the number of records
+// of the model in the database, starting at 1.
+type FieldFunc func(g *Generator, env models.Environment, n int) interface{}
+
+// Fields maps field names of a model to their FieldFunc
+type Fields map[string]FieldFunc
+
+// A Volume is a number of records of a model to generate
+type Volume struct {
+	Model string
+	Count int
+	// Fields are the FieldFunc of the fields whose values
+	// must not be generated from their type and name.
+	Fields Fields
+}
+
+// A Generator creates records with realistic random values.
+//
+// A Generator is not safe for concurrent use.
+type Generator struct {
+	// Reference is the date around which dates are generated, from two years
+	// before to one year after. It does not depend on the current date, so
+	// that generated records are reproducible.
+	Reference dates.DateTime
+	// FillRate is the probability for a field which is not required to be
+	// set. It is 0.8 by default.
+	FillRate float64
+	rand     *rand.Rand
+	counters map[string]int
+	ids      map[string][]int64
+}
+
+// New returns a new Generator with the given seed
+func New(seed int64) *Generator {
+	return &Generator{
+		Reference: dates.DateTime{Time: time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		FillRate:  0.8,
+		rand:      rand.New(rand.NewSource(seed)),
+		counters:  make(map[string]int),
+		ids:       make(map[string][]int64),
+	}
+}
+
+// Rand returns the source of random numbers of this Generator, for
+// use in FieldFunc so that their values are reproducible too.
+func (g *Generator) Rand() *rand.Rand {
+	return g.rand
+}
+
+// Generate creates the records of the given volumes, in order.
+func (g *Generator) Generate(env models.Environment, volumes ...Volume) {
+	for _, volume := range volumes {
+		g.Create(env, volume.Model, volume.Count, volume.Fields)
+	}
+}
+
+// Create creates count records of the given model with generated values,
+// except for the given fields which are set by their FieldFunc.
+func (g *Generator) Create(env models.Environment, modelName string, count int, fields Fields) *models.RecordCollection {
+	model := models.Registry.MustGet(modelName)
+	// Load the existing records first, so that created records are not added twice
+	g.candidates(env, modelName)
+	ids := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		rec := model.Create(env, g.Values(env, modelName, fields))
+		ids = append(ids, rec.Ids()...)
+		g.ids[modelName] = append(g.ids[modelName], rec.Ids()...)
+	}
+	return model.Browse(env, ids)
+}
+
+// Values returns the values of a new record of the given model, without creating
+// it. The given fields are set by their FieldFunc. Related records are created
+// for required one2one fields, and for required many2one fields if the related
+// model has no records.
+func (g *Generator) Values(env models.Environment, modelName string, fields Fields) models.FieldMap {
+	model := models.Registry.MustGet(modelName)
+	funcs := make(map[string]FieldFunc, len(fields))
+	for field, fnct := range fields {
+		funcs[model.JSONizeFieldName(field)] = fnct
+	}
+	rec := &record{g: g, n: g.next(env, modelName)}
+	defaults := env.Pool(modelName).Call("DefaultGet").(models.FieldMap)
+	fInfos := model.FieldsGet()
+	// Fields are generated in a fixed order so that generated values are reproducible
+	jsonNames := make([]string, 0, len(fInfos))
+	for jsonName := range fInfos {
+		jsonNames = append(jsonNames, jsonName)
+	}
+	sort.Strings(jsonNames)
+	res := make(models.FieldMap)
+	for _, jsonName := range jsonNames {
+		if fnct, ok := funcs[jsonName]; ok {
+			res.Set(jsonName, fnct(g, env, rec.n), model)
+			continue
+		}
+		fInfo := fInfos[jsonName]
+		// Many2many fields are not stored in the table of the model but can be set
+		stored := fInfo.Store || fInfo.Type == fieldtype.Many2Many
+		if ormFields[jsonName] || !stored || len(fInfo.Depends) > 0 || fInfo.Embed {
+			continue
+		}
+		if _, ok := defaults.Get(jsonName, model); ok {
+			continue
+		}
+		if !fInfo.Required && g.rand.Float64() >= g.FillRate {
+			continue
+		}
+		if value := rec.value(env, jsonName, fInfo); value != nil {
+			res.Set(jsonName, value, model)
+		}
+	}
+	return res
+}
+
+// next returns the number of the next record of the given model
+func (g *Generator) next(env models.Environment, modelName string) int {
+	if _, ok := g.counters[modelName]; !ok {
+		g.counters[modelName] = env.Pool(modelName).SearchAll().SearchCount()
+	}
+	g.counters[modelName]++
+	return g.counters[modelName]
+}
+
+// candidates returns the ids of the records of the given model that
+// can be set in relation fields, in the order of their creation.
+func (g *Generator) candidates(env models.Environment, modelName string) []int64 {
+	ids, ok := g.ids[modelName]
+	if !ok {
+		ids = env.Pool(modelName).SearchAll().OrderBy("ID").Ids()
+		g.ids[modelName] = ids
+	}
+	return ids
+}
+
+// FirstName returns a random first name
+func (g *Generator) FirstName() string {
+	return firstNames[g.rand.Intn(len(firstNames))]
+}
+
+// LastName returns a random last name
+func (g *Generator) LastName() string {
+	return lastNames[g.rand.Intn(len(lastNames))]
+}
+
+// Name returns a random full name
+func (g *Generator) Name() string {
+	return fmt.Sprintf("%s %s", g.FirstName(), g.LastName())
+}
+
+// Company returns a random company name
+func (g *Generator) Company() string {
+	return fmt.Sprintf("%s %s", g.LastName(), companySuffixes[g.rand.Intn(len(companySuffixes))])
+}
+
+// Email returns a random email address
+func (g *Generator) Email() string {
+	return g.email(g.FirstName(), g.LastName(), g.rand.Intn(1000))
+}
+
+// email returns the email address of the given person with the given number
+func (g *Generator) email(firstName, lastName string, n int) string {
+	return fmt.Sprintf("%s.%s%d@%s", strings.ToLower(firstName), strings.ToLower(lastName), n,
+		domains[g.rand.Intn(len(domains))])
+}
+
+// Phone returns a random phone number
+func (g *Generator) Phone() string {
+	return fmt.Sprintf("+1 555 %03d %04d", g.rand.Intn(1000), g.rand.Intn(10000))
+}
+
+// Street returns a random street address
+func (g *Generator) Street() string {
+	return fmt.Sprintf("%d %s %s", g.rand.Intn(200)+1, streetNames[g.rand.Intn(len(streetNames))],
+		streetTypes[g.rand.Intn(len(streetTypes))])
+}
+
+// Words returns count random words separated by spaces
+func (g *Generator) Words(count int) string {
+	res := make([]string, count)
+	for i := range res {
+		res[i] = words[g.rand.Intn(len(words))]
+	}
+	return strings.Join(res, " ")
+}
+
+// Sentence returns a random sentence
+func (g *Generator) Sentence() string {
+	sentence := g.Words(4 + g.rand.Intn(8))
+	return strings.ToUpper(sentence[:1]) + sentence[1:] + "."
+}
+
+// Paragraph returns a random paragraph
+func (g *Generator) Paragraph() string {
+	res := make([]string, 2+g.rand.Intn(4))
+	for i := range res {
+		res[i] = g.Sentence()
+	}
+	return strings.Join(res, " ")
+}
+
+// Date returns a random date around the Reference date of this Generator
+func (g *Generator) Date() dates.Date {
+	return g.Reference.ToDate().AddDate(0, 0, g.rand.Intn(3*365)-2*365)
+}
+
+// DateTime returns a random date and time around the Reference date of this Generator
+func (g *Generator) DateTime() dates.DateTime {
+	seconds := g.rand.Int63n(int64(3*365*24*time.Hour/time.Second)) - int64(2*365*24*time.Hour/time.Second)
+	return g.Reference.Add(time.Duration(seconds) * time.Second)
+}
+
+// Sequence returns a FieldFunc of strings formatted with the given
+// format and the number of the record, e.g. "Order %05d".
+func Sequence(format string) FieldFunc {
+	return func(_ *Generator, _ models.Environment, n int) interface{} {
+		return fmt.Sprintf(format, n)
+	}
+}
+
+// Pick returns a FieldFunc that returns one of the given values at random
+func Pick(values ...interface{}) FieldFunc {
+	return func(g *Generator, _ models.Environment, _ int) interface{} {
+		return values[g.rand.Intn(len(values))]
+	}
+}
+
+// A record holds the values shared by the fields of a generated
+// record, so that its name, email and address are consistent.
+type record struct {
+	g         *Generator
+	n         int
+	firstName string
+	lastName  string
+	address   *address
+}
+
+// person returns the first and last names of this record
+func (r *record) person() (string, string) {
+	if r.firstName == "" {
+		r.firstName, r.lastName = r.g.FirstName(), r.g.LastName()
+	}
+	return r.firstName, r.lastName
+}
+
+// city returns the city, zip code and country of this record
+func (r *record) city() address {
+	if r.address == nil {
+		r.address = &addresses[r.g.rand.Intn(len(addresses))]
+	}
+	return *r.address
+}
+
+// value returns a value for the given field of this record depending on its type,
+// or nil if the field must not be set.
+func (r *record) value(env models.Environment, jsonName string, fInfo *models.FieldInfo) interface{} {
+	switch fInfo.Type {
+	case fieldtype.Char:
+		return r.charValue(jsonName, fInfo)
+	case fieldtype.Text:
+		return r.g.Paragraph()
+	case fieldtype.HTML:
+		return fmt.Sprintf("<p>%s</p>", r.g.Paragraph())
+	case fieldtype.Integer:
+		if fInfo.Unique {
+			return r.n
+		}
+		return r.g.rand.Intn(1000)
+	case fieldtype.Float:
+		return math.Round(r.g.rand.Float64()*100000) / 100
+	case fieldtype.Boolean:
+		return r.g.rand.Intn(2) == 0
+	case fieldtype.Date:
+		return r.g.Date()
+	case fieldtype.DateTime:
+		return r.g.DateTime()
+	case fieldtype.Selection:
+		keys := make([]string, 0, len(fInfo.Selection))
+		for key := range fInfo.Selection {
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		sort.Strings(keys)
+		return keys[r.g.rand.Intn(len(keys))]
+	case fieldtype.Many2One, fieldtype.One2One:
+		ids := r.g.candidates(env, fInfo.Relation)
+		if fInfo.Type == fieldtype.One2One || len(ids) == 0 {
+			// One2one fields cannot share records
+			if !fInfo.Required {
+				return nil
+			}
+			return r.g.Create(env, fInfo.Relation, 1, nil)
+		}
+		return models.Registry.MustGet(fInfo.Relation).Browse(env, []int64{ids[r.g.rand.Intn(len(ids))]})
+	case fieldtype.Many2Many:
+		ids := r.g.candidates(env, fInfo.Relation)
+		count := r.g.rand.Intn(maxMany2ManyRecords + 1)
+		if count > len(ids) {
+			count = len(ids)
+		}
+		if count == 0 {
+			return nil
+		}
+		selected := make(map[int64]bool, count)
+		relIds := make([]int64, 0, count)
+		for len(relIds) < count {
+			id := ids[r.g.rand.Intn(len(ids))]
+			if selected[id] {
+				continue
+			}
+			selected[id] = true
+			relIds = append(relIds, id)
+		}
+		return models.Registry.MustGet(fInfo.Relation).Browse(env, relIds)
+	}
+	return nil
+}
+
+// charValue returns a value for the given char field of this record
+// depending on its name.
+func (r *record) charValue(jsonName string, fInfo *models.FieldInfo) string {
+	var value string
+	name := strings.ToLower(jsonName)
+	switch {
+	case strings.Contains(name, "email"):
+		// Email addresses and logins include the record number to be unique
+		firstName, lastName := r.person()
+		return truncate(r.g.email(firstName, lastName, r.n), fInfo.Size)
+	case name == "login":
+		firstName, lastName := r.person()
+		return truncate(fmt.Sprintf("%s.%s%d", strings.ToLower(firstName), strings.ToLower(lastName), r.n), fInfo.Size)
+	case strings.Contains(name, "phone"), strings.Contains(name, "mobile"), strings.Contains(name, "fax"):
+		value = r.g.Phone()
+	case name == "street2":
+		value = fmt.Sprintf("Apt %d", r.g.rand.Intn(200)+1)
+	case strings.Contains(name, "street"):
+		value = r.g.Street()
+	case strings.Contains(name, "city"):
+		value = r.city().city
+	case strings.Contains(name, "zip"), strings.Contains(name, "postcode"):
+		value = r.city().zip
+	case strings.Contains(name, "country"):
+		value = r.city().country
+	case strings.Contains(name, "website"), strings.Contains(name, "url"):
+		_, lastName := r.person()
+		value = fmt.Sprintf("https://www.%s.%s", strings.ToLower(lastName), domains[r.g.rand.Intn(len(domains))])
+	case strings.Contains(name, "company"):
+		value = r.g.Company()
+	case name == "first_name", name == "firstname":
+		value, _ = r.person()
+	case name == "last_name", name == "lastname":
+		_, value = r.person()
+	case strings.Contains(name, "name"):
+		firstName, lastName := r.person()
+		value = fmt.Sprintf("%s %s", firstName, lastName)
+	default:
+		value = r.g.Words(1 + r.g.rand.Intn(3))
+		value = strings.ToUpper(value[:1]) + value[1:]
+	}
+	if fInfo.Unique {
+		value = fmt.Sprintf("%s %d", value, r.n)
+	}
+	return truncate(value, fInfo.Size)
+}
+
+// truncate returns value truncated to size characters if size is not 0
+func truncate(value string, size int) string {
+	runes := []rune(value)
+	if size == 0 || len(runes) <= size {
+		return value
+	}
+	return string(runes[:size])
+}
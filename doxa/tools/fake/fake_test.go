@@ -0,0 +1,58 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package fake
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/labneco/doxa/doxa/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGenerator(t *testing.T) {
+	Convey("Testing fake values", t, func() {
+		Convey("Generators with the same seed should return the same values", func() {
+			g1, g2, g3 := New(1), New(1), New(2)
+			var values1, values2, values3 []string
+			for i := 0; i < 10; i++ {
+				values1 = append(values1, g1.Name(), g1.Email(), g1.Street(), g1.Sentence(), g1.Date().String())
+				values2 = append(values2, g2.Name(), g2.Email(), g2.Street(), g2.Sentence(), g2.Date().String())
+				values3 = append(values3, g3.Name(), g3.Email(), g3.Street(), g3.Sentence(), g3.Date().String())
+			}
+			So(values1, ShouldResemble, values2)
+			So(values1, ShouldNotResemble, values3)
+		})
+		Convey("Dates should be around the reference date", func() {
+			g := New(1)
+			for i := 0; i < 100; i++ {
+				So(g.Date().Year(), ShouldBeBetweenOrEqual, 2015, 2017)
+				So(g.DateTime().Year(), ShouldBeBetweenOrEqual, 2015, 2017)
+			}
+		})
+		Convey("Char values should depend on the field name", func() {
+			rec := &record{g: New(1), n: 7}
+			char := &models.FieldInfo{}
+			name := rec.charValue("name", char)
+			firstName, lastName := rec.person()
+			So(name, ShouldEqual, firstName+" "+lastName)
+			So(rec.charValue("email", char), ShouldStartWith, strings.ToLower(firstName+"."+lastName)+"7@")
+			So(rec.charValue("login", char), ShouldEqual, strings.ToLower(firstName+"."+lastName)+"7")
+			city := rec.city()
+			So(rec.charValue("city", char), ShouldEqual, city.city)
+			So(rec.charValue("zip", char), ShouldEqual, city.zip)
+			So(rec.charValue("country_name", char), ShouldEqual, city.country)
+		})
+		Convey("Values should be truncated to the size of fields", func() {
+			So(truncate("Élodie Martin", 6), ShouldEqual, "Élodie")
+			So(truncate("Emma", 6), ShouldEqual, "Emma")
+			So(truncate("Emma", 0), ShouldEqual, "Emma")
+		})
+		Convey("FieldFunc helpers should return the expected values", func() {
+			g := New(1)
+			So(Sequence("SO%04d")(g, models.Environment{}, 12), ShouldEqual, "SO0012")
+			So(Pick("draft", "done")(g, models.Environment{}, 1), ShouldBeIn, []interface{}{"draft", "done"})
+		})
+	})
+}
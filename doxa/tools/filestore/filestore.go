@@ -0,0 +1,108 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package filestore provides a content addressed store of files on disk.
+
+Files are identified by the SHA1 checksum of their content, so that the
+same content is only stored once.
+*/
+package filestore
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrInvalidChecksum is returned when a checksum is not a valid SHA1 hex digest
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
+// A Store is a content addressed file store rooted in a directory.
+//
+// The file with checksum 'abcdef...' is stored at '<root>/ab/abcdef...'.
+type Store struct {
+	root string
+}
+
+// New returns a Store rooted in the given directory.
+// The directory is created when the first file is written.
+func New(root string) *Store {
+	return &Store{root: root}
+}
+
+// Root returns the root directory of this store
+func (s *Store) Root() string {
+	return s.root
+}
+
+// Path returns the path of the file with the given checksum
+func (s *Store) Path(checksum string) (string, error) {
+	if len(checksum) != 2*sha1.Size {
+		return "", ErrInvalidChecksum
+	}
+	if _, err := hex.DecodeString(checksum); err != nil {
+		return "", ErrInvalidChecksum
+	}
+	return filepath.Join(s.root, checksum[:2], checksum), nil
+}
+
+// Write streams the content of r into the store and returns its checksum
+// and its size. Content is never fully loaded in memory: it is written to
+// a temporary file which is then moved to its final location.
+func (s *Store) Write(r io.Reader) (checksum string, size int64, err error) {
+	tmpDir := filepath.Join(s.root, "tmp")
+	if err = os.MkdirAll(tmpDir, 0700); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(tmpDir, "upload")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha1.New()
+	size, err = io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	path, _ := s.Path(checksum)
+	if _, statErr := os.Stat(path); statErr == nil {
+		// Same content is already stored
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), path)
+	log.Debug("File stored", "checksum", checksum, "size", size, "error", err)
+	return
+}
+
+// Open opens the file with the given checksum for reading
+func (s *Store) Open(checksum string) (*os.File, error) {
+	path, err := s.Path(checksum)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Remove deletes the file with the given checksum from the store.
+//
+// Since files are shared by all contents with the same checksum, callers
+// must make sure that the file is not referenced anymore.
+func (s *Store) Remove(checksum string) error {
+	path, err := s.Path(checksum)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
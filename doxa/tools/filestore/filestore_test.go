@@ -0,0 +1,54 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileStore(t *testing.T) {
+	Convey("Testing the file store", t, func() {
+		root, err := ioutil.TempDir("", "doxa-filestore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		store := New(root)
+		checksum, size, err := store.Write(strings.NewReader("hello world"))
+		So(err, ShouldBeNil)
+		So(checksum, ShouldEqual, "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed")
+		So(size, ShouldEqual, 11)
+		Convey("Stored files should be readable by checksum", func() {
+			f, err := store.Open(checksum)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			content, _ := ioutil.ReadAll(f)
+			So(string(content), ShouldEqual, "hello world")
+			path, _ := store.Path(checksum)
+			So(path, ShouldEqual, filepath.Join(root, "2a", checksum))
+		})
+		Convey("Same content should be stored once", func() {
+			again, _, err := store.Write(strings.NewReader("hello world"))
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, checksum)
+			tmpFiles, _ := ioutil.ReadDir(filepath.Join(root, "tmp"))
+			So(tmpFiles, ShouldBeEmpty)
+		})
+		Convey("Invalid checksums should be rejected", func() {
+			_, err := store.Open("../../etc/passwd")
+			So(err, ShouldEqual, ErrInvalidChecksum)
+			_, err = store.Path(strings.Repeat("z", 40))
+			So(err, ShouldEqual, ErrInvalidChecksum)
+		})
+		Convey("Removed files should not be found", func() {
+			So(store.Remove(checksum), ShouldBeNil)
+			_, err := store.Open(checksum)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,12 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package filestore
+
+import "github.com/labneco/doxa/doxa/tools/logging"
+
+var log *logging.Logger
+
+func init() {
+	log = logging.GetLogger("filestore")
+}
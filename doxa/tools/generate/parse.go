@@ -148,6 +148,9 @@ type ModelASTData struct {
 	Mixins       map[string]bool
 	Embeds       map[string]bool
 	Validated    bool
+	// SelectionUpdates are the selections given to SetSelection
+	// and UpdateSelection, indexed by field name.
+	SelectionUpdates map[string]map[string]string
 }
 
 // newModelASTData returns an initialized ModelASTData instance
@@ -161,13 +164,14 @@ func newModelASTData(name string) ModelASTData {
 	}
 	var modelType string
 	return ModelASTData{
-		Name:         name,
-		Fields:       map[string]FieldASTData{"ID": idField},
-		Methods:      make(map[string]MethodASTData),
-		Mixins:       make(map[string]bool),
-		Embeds:       make(map[string]bool),
-		IsModelMixin: ModelMixins[name],
-		ModelType:    modelType,
+		Name:             name,
+		Fields:           map[string]FieldASTData{"ID": idField},
+		Methods:          make(map[string]MethodASTData),
+		Mixins:           make(map[string]bool),
+		Embeds:           make(map[string]bool),
+		IsModelMixin:     ModelMixins[name],
+		SelectionUpdates: make(map[string]map[string]string),
+		ModelType:        modelType,
 	}
 }
 
@@ -201,6 +205,8 @@ func GetModelsASTDataForModules(modInfos []*ModuleInfo, validate bool) map[strin
 						parseMixInModel(node, &modelsData)
 					case fnctName == "AddFields":
 						parseAddFields(node, modInfo, &modelsData)
+					case fnctName == "SetSelection" || fnctName == "UpdateSelection":
+						parseSelectionUpdate(node, &modelsData)
 					case strutils.StartsAndEndsWith(fnctName, "Declare", "Model"):
 						parseDeclareModel(node, &modelsData)
 					case strutils.StartsAndEndsWith(fnctName, "New", "Model"):
@@ -436,6 +442,84 @@ func extractSelection(expr ast.Expr) map[string]string {
 	return res
 }
 
+// parseSelectionUpdate parses the given node which is a SetSelection or
+// an UpdateSelection call on a field, such as:
+//
+//	h.User().Fields().State().UpdateSelection(types.Selection{...})
+//
+// Calls whose field or model cannot be determined are ignored.
+func parseSelectionUpdate(node *ast.CallExpr, modelsData *map[string]ModelASTData) {
+	if len(node.Args) != 1 {
+		return
+	}
+	modelName, fieldName, ok := extractFieldOfCall(node)
+	if !ok {
+		return
+	}
+	if _, exists := (*modelsData)[modelName]; !exists {
+		(*modelsData)[modelName] = newModelASTData(modelName)
+	}
+	selection := (*modelsData)[modelName].SelectionUpdates[fieldName]
+	if selection == nil {
+		selection = make(map[string]string)
+	}
+	for k, v := range extractSelection(node.Args[0]) {
+		selection[k] = v
+	}
+	(*modelsData)[modelName].SelectionUpdates[fieldName] = selection
+}
+
+// extractFieldOfCall returns the names of the model and of the field
+// on which the method of the given call expression is called. The field
+// is either given by a field getter or by MustGet on the Fields() of a
+// model. Other field modifier calls between the field and the method are
+// skipped. ok is false if the model or the field cannot be determined.
+func extractFieldOfCall(node *ast.CallExpr) (modelName string, fieldName string, ok bool) {
+	expr := node.Fun
+	for {
+		sel, isSel := expr.(*ast.SelectorExpr)
+		if !isSel {
+			return "", "", false
+		}
+		call, isCall := sel.X.(*ast.CallExpr)
+		if !isCall {
+			return "", "", false
+		}
+		fieldSel, isSel := call.Fun.(*ast.SelectorExpr)
+		if !isSel {
+			return "", "", false
+		}
+		fieldsCall, isCall := fieldSel.X.(*ast.CallExpr)
+		if !isCall {
+			return "", "", false
+		}
+		fieldsSel, isSel := fieldsCall.Fun.(*ast.SelectorExpr)
+		if !isSel || fieldsSel.Sel.Name != "Fields" {
+			expr = call.Fun
+			continue
+		}
+		fieldName = fieldSel.Sel.Name
+		if fieldName == "MustGet" || fieldName == "Get" {
+			if len(call.Args) != 1 {
+				return "", "", false
+			}
+			lit, isLit := call.Args[0].(*ast.BasicLit)
+			if !isLit {
+				return "", "", false
+			}
+			fieldName = strings.Trim(lit.Value, "\"`")
+		}
+		if ident, isIdent := fieldsSel.X.(*ast.Ident); isIdent && ident.Obj == nil {
+			return "", "", false
+		}
+		modelName, err := extractModel(fieldsSel.X)
+		if err != nil {
+			return "", "", false
+		}
+		return modelName, fieldName, true
+	}
+}
+
 // parseAddMethod parses the given node which is an AddMethod function
 func parseAddMethod(node *ast.CallExpr, modInfo *ModuleInfo, modelsData *map[string]ModelASTData) {
 	fNode := node.Fun.(*ast.SelectorExpr)
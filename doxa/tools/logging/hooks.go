@@ -0,0 +1,138 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+)
+
+// An ErrorEvent is an error logged at the error or critical level,
+// or a panic logged by LogPanicData, which is passed to error hooks.
+type ErrorEvent struct {
+	// Level is the level of the event
+	Level log15.Lvl
+	// Message is the logged message, or the panic message
+	Message string
+	// Panic is true if the event is a recovered panic
+	Panic bool
+	// Frames are the stack frames where the error was logged or where
+	// the panic occurred, from the innermost to the outermost.
+	Frames []runtime.Frame
+	// Context holds the key/value pairs of the context of the logger
+	// and of the event, such as the request ID or the user ID.
+	Context []interface{}
+}
+
+// An ErrorHook is a function called with the errors and panics logged by
+// Doxa, e.g. to send them to an error tracking service. Hooks are called
+// synchronously and must return quickly.
+type ErrorHook func(event ErrorEvent)
+
+var (
+	errorHooksMutex sync.RWMutex
+	errorHooks      []ErrorHook
+)
+
+// AddErrorHook registers the given hook, which will be called
+// for each error and recovered panic that is logged.
+//
+// Errors logged by Logger.Panic are not passed to hooks, since
+// the panic is passed to hooks when it is recovered and logged.
+func AddErrorHook(hook ErrorHook) {
+	errorHooksMutex.Lock()
+	defer errorHooksMutex.Unlock()
+	errorHooks = append(errorHooks, hook)
+}
+
+// hasErrorHooks returns true if at least one error hook is registered
+func hasErrorHooks() bool {
+	errorHooksMutex.RLock()
+	defer errorHooksMutex.RUnlock()
+	return len(errorHooks) > 0
+}
+
+// runErrorHooks calls all the registered error hooks with the given event.
+// A hook that panics is reported on stderr and does not prevent the others
+// from being called.
+func runErrorHooks(event ErrorEvent) {
+	errorHooksMutex.RLock()
+	hooks := errorHooks
+	errorHooksMutex.RUnlock()
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					// We cannot log since a hook could be called again
+					fmt.Fprintf(os.Stderr, "Error hook panicked: %v\n", r)
+				}
+			}()
+			hook(event)
+		}()
+	}
+}
+
+// callerFrames returns the stack frames of the caller, skipping skip frames
+func callerFrames(skip int) []runtime.Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var res []runtime.Frame
+	for {
+		frame, more := frames.Next()
+		res = append(res, frame)
+		if !more {
+			break
+		}
+	}
+	return res
+}
+
+// panicFrames returns the given frames of a deferred function that
+// recovered a panic without the frames of the deferred function, so
+// that the first frame is the one where the panic occurred.
+func panicFrames(frames []runtime.Frame) []runtime.Frame {
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].Function == "runtime.gopanic" {
+			return frames[i+1:]
+		}
+	}
+	return frames
+}
+
+// reportError passes the given message logged at the given level with
+// the given context to the error hooks. skip is the number of frames
+// to skip to get to the caller of the logging function.
+func (l *Logger) reportError(lvl log15.Lvl, msg string, skip int, ctx []interface{}) {
+	if !hasErrorHooks() {
+		return
+	}
+	fullCtx := make([]interface{}, 0, len(l.ctx)+len(ctx))
+	fullCtx = append(fullCtx, l.ctx...)
+	fullCtx = append(fullCtx, ctx...)
+	runErrorHooks(ErrorEvent{
+		Level:   lvl,
+		Message: msg,
+		Frames:  callerFrames(skip + 1),
+		Context: fullCtx,
+	})
+}
+
+// Error logs the given message and context at the error level
+// and passes them to the error hooks.
+func (l *Logger) Error(msg string, ctx ...interface{}) {
+	l.Logger.Error(msg, ctx...)
+	l.reportError(log15.LvlError, msg, 1, ctx)
+}
+
+// Crit logs the given message and context at the critical level
+// and passes them to the error hooks.
+func (l *Logger) Crit(msg string, ctx ...interface{}) {
+	l.Logger.Crit(msg, ctx...)
+	l.reportError(log15.LvlCrit, msg, 1, ctx)
+}
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"runtime"
 	"time"
 
@@ -44,19 +43,25 @@ func init() {
 // A Logger writes logs to a handler
 type Logger struct {
 	log15.Logger
+	// ctx is the context of the logger, which is passed to error hooks
+	ctx []interface{}
 }
 
 // NewLogger returns a pointer to a new Logger instance
 func NewLogger(ctx ...interface{}) *Logger {
 	return &Logger{
 		Logger: log15.New(ctx...),
+		ctx:    ctx,
 	}
 }
 
 // New returns a new Logger that has this logger's context plus the given context
 func (l *Logger) New(ctx ...interface{}) *Logger {
+	newCtx := make([]interface{}, 0, len(l.ctx)+len(ctx))
+	newCtx = append(newCtx, l.ctx...)
 	return &Logger{
 		Logger: l.Logger.New(ctx...),
+		ctx:    append(newCtx, ctx...),
 	}
 }
 
@@ -64,7 +69,8 @@ func (l *Logger) New(ctx ...interface{}) *Logger {
 func (l *Logger) Panic(msg string, ctx ...interface{}) {
 	pc, _, _, _ := runtime.Caller(1)
 	ctx = append(ctx, "caller", string(function(pc)))
-	l.Error(msg, ctx...)
+	// The error is not passed to error hooks: the panic is, once recovered and logged
+	l.Logger.Error(msg, ctx...)
 
 	fullMsg := fmt.Sprintf("%s, %v\n", msg, ctx)
 	panic(exceptions.UserError{
@@ -73,7 +79,11 @@ func (l *Logger) Panic(msg string, ctx ...interface{}) {
 	})
 }
 
-// Initialize starts the base logger used by all Doxa components
+// Initialize starts the base logger used by all Doxa components.
+//
+// Logs are written to the sinks of the configuration (see Sink), each
+// with its own level and format. Records of SQL queries are written to
+// all sinks whatever their level if LogSQL is set.
 func Initialize() {
 	logLevel, err := log15.LvlFromString(viper.GetString("LogLevel"))
 	if err != nil {
@@ -81,28 +91,37 @@ func Initialize() {
 		logLevel = log15.LvlInfo
 	}
 
-	stdoutHandler := log15.DiscardHandler()
-	if viper.GetBool("LogStdout") {
-		stdoutHandler = log15.StreamHandler(os.Stdout, log15.TerminalFormat())
+	for _, closer := range sinkClosers {
+		closer.Close()
 	}
+	sinkClosers = nil
 
-	fileHandler := log15.DiscardHandler()
-	if path := viper.GetString("LogFile"); path != "" {
-		fileHandler = log15.Must.FileHandler(path, log15.LogfmtFormat())
+	logSQL := viper.GetBool("LogSQL")
+	var handlers []log15.Handler
+	for _, sink := range configuredSinks() {
+		handler, closer, err := newSinkHandler(sink, logLevel, logSQL)
+		if err != nil {
+			log.Panic("Unable to create log sink", "type", sink.Type, "path", sink.Path, "error", err)
+		}
+		if closer != nil {
+			sinkClosers = append(sinkClosers, closer)
+		}
+		handlers = append(handlers, handler)
 	}
-
-	log.SetHandler(
-		log15.LvlFilterHandler(
-			logLevel,
-			log15.MultiHandler(
-				stdoutHandler,
-				fileHandler,
-			),
-		),
-	)
+	log.SetHandler(log15.MultiHandler(handlers...))
 	log.Info("Doxa Starting...")
 }
 
+// isSQLRecord returns true if the given record is the log of an SQL query
+func isSQLRecord(r *log15.Record) bool {
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		if r.Ctx[i] == "query" {
+			return true
+		}
+	}
+	return false
+}
+
 // GetLogger returns a context logger for the given module
 func GetLogger(moduleName string) *Logger {
 	l := log.New("module", moduleName)
@@ -114,12 +133,25 @@ func GetLogger(moduleName string) *Logger {
 // error with the panic message. This function is separated from
 // LogAndPanic so that unwanted panics can still be logged with
 // this function.
-func LogPanicData(panicData interface{}) error {
+//
+// The panic is passed to the error hooks with the given context key/value
+// pairs. It must be called from the deferred function that recovered the
+// panic, so that the stack frames of the panic are still available.
+func LogPanicData(panicData interface{}, ctx ...interface{}) error {
 	msg := fmt.Sprintf("%v", panicData)
-	log.Error("Doxa panicked", "msg", msg)
+	log.Logger.Error("Doxa panicked", append([]interface{}{"msg", msg}, ctx...)...)
 
 	stackTrace := stack(1)
-	log.Error(fmt.Sprintf("Stack trace:\n%s", stackTrace))
+	log.Logger.Error(fmt.Sprintf("Stack trace:\n%s", stackTrace))
+	if hasErrorHooks() {
+		runErrorHooks(ErrorEvent{
+			Level:   log15.LvlCrit,
+			Message: msg,
+			Panic:   true,
+			Frames:  panicFrames(callerFrames(1)),
+			Context: ctx,
+		})
+	}
 
 	fullMsg := fmt.Sprintf("%s\n\n%s", msg, stackTrace)
 	return exceptions.UserError{
@@ -0,0 +1,166 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doxa-logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Convey("Testing rotating log files", t, func() {
+		Convey("Files should be rotated by size and old backups removed", func() {
+			path := filepath.Join(dir, "size.log")
+			rf, err := NewRotatingFile(path, 10, 0, 2)
+			So(err, ShouldBeNil)
+			for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+				_, err = rf.Write([]byte(line))
+				So(err, ShouldBeNil)
+				time.Sleep(2 * time.Millisecond)
+			}
+			So(rf.Close(), ShouldBeNil)
+			content, _ := ioutil.ReadFile(path)
+			So(string(content), ShouldEqual, "fourth\n")
+			backups := rf.Backups()
+			So(backups, ShouldHaveLength, 2)
+			content, _ = ioutil.ReadFile(backups[0])
+			So(string(content), ShouldEqual, "second\n")
+			content, _ = ioutil.ReadFile(backups[1])
+			So(string(content), ShouldEqual, "third\n")
+			_, err = rf.Write([]byte("closed\n"))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Files should be rotated at the end of their period", func() {
+			rf := &RotatingFile{Period: 24 * time.Hour}
+			start := time.Date(2017, 6, 30, 15, 4, 5, 0, time.Local)
+			So(rf.periodEnd(start), ShouldEqual, time.Date(2017, 7, 1, 0, 0, 0, 0, time.Local))
+			rf.Period = time.Hour
+			So(rf.periodEnd(start), ShouldEqual, time.Date(2017, 6, 30, 16, 0, 0, 0, time.Local))
+			rf.size = 10
+			rf.nextRotation = rf.periodEnd(start)
+			So(rf.mustRotate(5, start.Add(30*time.Minute)), ShouldBeFalse)
+			So(rf.mustRotate(5, start.Add(time.Hour)), ShouldBeTrue)
+			rf.size = 0
+			So(rf.mustRotate(5, start.Add(time.Hour)), ShouldBeFalse)
+			rf.Period = 0
+			So(rf.periodEnd(start).IsZero(), ShouldBeTrue)
+		})
+		Convey("Existing files of a previous period should be rotated at first write", func() {
+			path := filepath.Join(dir, "daily.log")
+			So(ioutil.WriteFile(path, []byte("yesterday\n"), 0644), ShouldBeNil)
+			yesterday := time.Now().Add(-24 * time.Hour)
+			So(os.Chtimes(path, yesterday, yesterday), ShouldBeNil)
+			rf, err := NewRotatingFile(path, 0, 24*time.Hour, 0)
+			So(err, ShouldBeNil)
+			_, err = rf.Write([]byte("today\n"))
+			So(err, ShouldBeNil)
+			So(rf.Close(), ShouldBeNil)
+			content, _ := ioutil.ReadFile(path)
+			So(string(content), ShouldEqual, "today\n")
+			So(rf.Backups(), ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doxa-logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Convey("Testing log sinks", t, func() {
+		Convey("Sinks should filter records with their own level and format", func() {
+			jsonPath := filepath.Join(dir, "doxa.json")
+			textPath := filepath.Join(dir, "doxa.log")
+			jsonHandler, jsonCloser, err := newSinkHandler(Sink{Type: SinkFile, Path: jsonPath, Format: FormatJSON}, log15.LvlInfo, false)
+			So(err, ShouldBeNil)
+			textHandler, textCloser, err := newSinkHandler(Sink{Type: SinkFile, Path: textPath, Level: "warn"}, log15.LvlInfo, true)
+			So(err, ShouldBeNil)
+			logger := log15.New("module", "test")
+			logger.SetHandler(log15.MultiHandler(jsonHandler, textHandler))
+			logger.Info("Information")
+			logger.Warn("Warning")
+			logger.Debug("Query", "query", "SELECT 1")
+			So(jsonCloser.Close(), ShouldBeNil)
+			So(textCloser.Close(), ShouldBeNil)
+			content, _ := ioutil.ReadFile(jsonPath)
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So(lines[0], ShouldStartWith, "{")
+			So(lines[0], ShouldContainSubstring, `"msg":"Information"`)
+			So(lines[1], ShouldContainSubstring, `"msg":"Warning"`)
+			content, _ = ioutil.ReadFile(textPath)
+			lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So(lines[0], ShouldContainSubstring, "msg=Warning")
+			So(lines[1], ShouldContainSubstring, `query="SELECT 1"`)
+		})
+		Convey("Invalid sinks should return an error", func() {
+			for _, sink := range []Sink{
+				{Type: "unknown"},
+				{Type: SinkStdout, Format: "xml"},
+				{Type: SinkStdout, Level: "verbose"},
+				{Type: SinkFile},
+				{Type: SinkFile, Path: filepath.Join(dir, "bad.log"), Rotate: "monthly"},
+			} {
+				_, _, err := newSinkHandler(sink, log15.LvlInfo, false)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestErrorHooks(t *testing.T) {
+	Convey("Testing error hooks", t, func() {
+		var events []ErrorEvent
+		AddErrorHook(func(event ErrorEvent) {
+			events = append(events, event)
+		})
+		AddErrorHook(func(event ErrorEvent) {
+			panic("hooks should not break logging")
+		})
+		logger := GetLogger("hooks").New("request_id", "abcd")
+		logger.SetHandler(log15.DiscardHandler())
+		Convey("Errors should be passed to hooks with their context", func() {
+			logger.Info("Information")
+			logger.Warn("Warning")
+			logger.Error("Failure", "uid", 2)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Level, ShouldEqual, log15.LvlError)
+			So(events[0].Message, ShouldEqual, "Failure")
+			So(events[0].Panic, ShouldBeFalse)
+			So(events[0].Context, ShouldResemble, []interface{}{"module", "hooks", "request_id", "abcd", "uid", 2})
+			So(events[0].Frames[0].Function, ShouldEndWith, "TestErrorHooks.func1.3")
+		})
+		Convey("Recovered panics should be passed to hooks, but not their error log", func() {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						LogPanicData(r, "uid", 2)
+					}
+				}()
+				logger.Panic("Invalid value", "value", 3)
+			}()
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Level, ShouldEqual, log15.LvlCrit)
+			So(events[0].Message, ShouldStartWith, "Invalid value")
+			So(events[0].Panic, ShouldBeTrue)
+			So(events[0].Context, ShouldResemble, []interface{}{"uid", 2})
+			So(events[0].Frames[0].Function, ShouldEndWith, "(*Logger).Panic")
+		})
+		errorHooks = nil
+	})
+}
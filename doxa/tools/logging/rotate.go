@@ -0,0 +1,184 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the time suffix of rotated log files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// A RotatingFile is a log file that is rotated when it reaches a maximum
+// size or at the end of each period of time. The rotated file is renamed with
+// the time of its rotation as suffix, e.g. doxa.log.2017-06-30T00-00-00.000,
+// and a new file is created at the same path.
+type RotatingFile struct {
+	// Path is the path of the current log file
+	Path string
+	// MaxSize is the size in bytes above which the file is rotated.
+	// If 0, the file is not rotated by size.
+	MaxSize int64
+	// Period is the duration after which the file is rotated. Periods start at
+	// local midnight, so that daily files are rotated at midnight and hourly
+	// files at each round hour. If 0, the file is not rotated by time.
+	Period time.Duration
+	// MaxBackups is the number of rotated files to keep. If 0, all rotated
+	// files are kept.
+	MaxBackups int
+
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+}
+
+// NewRotatingFile opens the log file at the given path for appending, creating
+// it if needed, and returns a RotatingFile with the given rotation settings.
+func NewRotatingFile(path string, maxSize int64, period time.Duration, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		Period:     period,
+		MaxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write writes p to the log file, after rotating it if
+// it is too large or if its period has elapsed.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.mustRotate(len(p), time.Now()) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// open opens the log file and initializes its size and next rotation time.
+// The period of an existing file starts when it was last modified, so that
+// a file of a previous period is rotated at the first write.
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	start := time.Now()
+	if rf.size > 0 {
+		start = info.ModTime()
+	}
+	rf.nextRotation = rf.periodEnd(start)
+	return nil
+}
+
+// mustRotate returns true if the file must be rotated before
+// writing n bytes at the given time.
+func (rf *RotatingFile) mustRotate(n int, now time.Time) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.MaxSize > 0 && rf.size+int64(n) > rf.MaxSize {
+		return true
+	}
+	return !rf.nextRotation.IsZero() && !now.Before(rf.nextRotation)
+}
+
+// periodEnd returns the end of the rotation period that contains the
+// given time, or the zero time if the file is not rotated by time.
+func (rf *RotatingFile) periodEnd(t time.Time) time.Time {
+	if rf.Period <= 0 {
+		return time.Time{}
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return midnight.Add(t.Sub(midnight).Truncate(rf.Period) + rf.Period)
+}
+
+// rotate renames the current log file with the time as suffix,
+// opens a new file and removes the backups in excess.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	backup := rf.Path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(rf.Path, backup)
+	// Reopen the file even if it could not be renamed, so that logs are not lost
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	rf.removeOldBackups()
+	return nil
+}
+
+// Backups returns the paths of the rotated files of this
+// RotatingFile that still exist, from the oldest to the newest.
+func (rf *RotatingFile) Backups() []string {
+	matches, _ := filepath.Glob(rf.Path + ".*")
+	var res []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, rf.Path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err != nil {
+			continue
+		}
+		res = append(res, match)
+	}
+	// The time format sorts in chronological order
+	sort.Strings(res)
+	return res
+}
+
+// removeOldBackups removes the oldest rotated files
+// so that at most MaxBackups files are kept.
+func (rf *RotatingFile) removeOldBackups() {
+	if rf.MaxBackups <= 0 {
+		return
+	}
+	backups := rf.Backups()
+	for len(backups) > rf.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			// We cannot log this error since we hold the lock of the log file
+			fmt.Fprintf(os.Stderr, "Unable to remove rotated log file %s: %s\n", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/spf13/viper"
+)
+
+// Sink types
+const (
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// rotationPeriods are the periods of the Rotate setting of file sinks
+var rotationPeriods = map[string]time.Duration{
+	"":       0,
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// A Sink is a destination of the logs. Each sink has its own level and format.
+//
+// Sinks are defined in the LogSinks setting of the configuration file, e.g.:
+//
+//	[[LogSinks]]
+//	Type = "file"
+//	Path = "/var/log/doxa/doxa.json"
+//	Format = "json"
+//	Rotate = "daily"
+//	MaxBackups = 7
+//
+//	[[LogSinks]]
+//	Type = "syslog"
+//	Level = "warn"
+type Sink struct {
+	// Type is the type of the sink: "stdout", "stderr", "file" or "syslog".
+	// Syslog sinks are also collected by journald on systemd hosts.
+	Type string
+	// Level is the maximum level of the logs written to this sink.
+	// Defaults to the LogLevel setting.
+	Level string
+	// Format is the format of the logs: "text" (default) or "json". Text
+	// logs are colored on consoles and in logfmt format otherwise.
+	Format string
+	// Path is the path of the log file of file sinks
+	Path string
+	// MaxSize is the size in megabytes above which the log file of a
+	// file sink is rotated. If 0, the file is not rotated by size.
+	MaxSize int
+	// Rotate is the period after which the log file of a file sink is
+	// rotated: "hourly", "daily", "weekly" or empty to disable it.
+	Rotate string
+	// MaxBackups is the number of rotated files of a file sink to keep.
+	// If 0, all rotated files are kept.
+	MaxBackups int
+	// Tag is the tag of the messages of syslog sinks. Defaults to "doxa".
+	Tag string
+	// Network and Address are those of a remote syslog server (e.g. "udp"
+	// and "logs.example.com:514"). Local syslog is used if they are empty.
+	Network string
+	Address string
+}
+
+// sinkClosers are the files opened by the sinks of the
+// base logger, which are closed when it is initialized again.
+var sinkClosers []io.Closer
+
+// configuredSinks returns the sinks of the configuration: a stdout sink if
+// LogStdout is set, a file sink if LogFile is set and the sinks of LogSinks.
+func configuredSinks() []Sink {
+	var sinks []Sink
+	if viper.GetBool("LogStdout") {
+		sinks = append(sinks, Sink{
+			Type:   SinkStdout,
+			Format: viper.GetString("LogFormat"),
+		})
+	}
+	if path := viper.GetString("LogFile"); path != "" {
+		sinks = append(sinks, Sink{
+			Type:       SinkFile,
+			Format:     viper.GetString("LogFormat"),
+			Path:       path,
+			MaxSize:    viper.GetInt("LogFileMaxSize"),
+			Rotate:     viper.GetString("LogFileRotate"),
+			MaxBackups: viper.GetInt("LogFileMaxBackups"),
+		})
+	}
+	var extraSinks []Sink
+	if err := viper.UnmarshalKey("LogSinks", &extraSinks); err != nil {
+		log.Panic("Invalid LogSinks setting", "error", err)
+	}
+	return append(sinks, extraSinks...)
+}
+
+// newSinkHandler returns a handler that writes the logs to the given sink.
+// Records with a level above the level of the sink are discarded, unless
+// they are SQL queries and logSQL is true. defaultLevel is used if the sink
+// has no level.
+//
+// It also returns the file opened by the handler, if any.
+func newSinkHandler(sink Sink, defaultLevel log15.Lvl, logSQL bool) (log15.Handler, io.Closer, error) {
+	level := defaultLevel
+	if sink.Level != "" {
+		var err error
+		level, err = log15.LvlFromString(sink.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var (
+		handler log15.Handler
+		closer  io.Closer
+		err     error
+	)
+	switch sink.Type {
+	case SinkStdout, SinkStderr:
+		stream := os.Stdout
+		if sink.Type == SinkStderr {
+			stream = os.Stderr
+		}
+		var format log15.Format
+		format, err = sinkFormat(sink, log15.TerminalFormat())
+		if err == nil {
+			handler = log15.StreamHandler(stream, format)
+		}
+	case SinkFile:
+		handler, closer, err = newFileHandler(sink)
+	case SinkSyslog:
+		var format log15.Format
+		format, err = sinkFormat(sink, log15.LogfmtFormat())
+		if err == nil {
+			handler, err = newSyslogHandler(sink, format)
+		}
+	default:
+		err = fmt.Errorf("unknown log sink type: %s", sink.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return log15.FilterHandler(func(r *log15.Record) bool {
+		return r.Lvl <= level || (logSQL && isSQLRecord(r))
+	}, handler), closer, nil
+}
+
+// newFileHandler returns a handler that writes to the
+// rotating log file of the given file sink.
+func newFileHandler(sink Sink) (log15.Handler, io.Closer, error) {
+	if sink.Path == "" {
+		return nil, nil, fmt.Errorf("file log sinks must have a path")
+	}
+	period, ok := rotationPeriods[sink.Rotate]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown log rotation period: %s", sink.Rotate)
+	}
+	format, err := sinkFormat(sink, log15.LogfmtFormat())
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := NewRotatingFile(sink.Path, int64(sink.MaxSize)*1024*1024, period, sink.MaxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return log15.StreamHandler(file, format), file, nil
+}
+
+// sinkFormat returns the log format of the given sink.
+// textFormat is the format used for text logs.
+func sinkFormat(sink Sink, textFormat log15.Format) (log15.Format, error) {
+	switch sink.Format {
+	case "", FormatText:
+		return textFormat, nil
+	case FormatJSON:
+		return log15.JsonFormat(), nil
+	}
+	return nil, fmt.Errorf("unknown log format: %s", sink.Format)
+}
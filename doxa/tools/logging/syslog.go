@@ -0,0 +1,25 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"log/syslog"
+
+	"github.com/inconshreveable/log15"
+)
+
+// newSyslogHandler returns a handler that writes to the local
+// syslog or to the remote syslog server of the given sink.
+func newSyslogHandler(sink Sink, format log15.Format) (log15.Handler, error) {
+	tag := sink.Tag
+	if tag == "" {
+		tag = "doxa"
+	}
+	if sink.Address != "" {
+		return log15.SyslogNetHandler(sink.Network, sink.Address, syslog.LOG_DAEMON|syslog.LOG_INFO, tag, format)
+	}
+	return log15.SyslogHandler(syslog.LOG_DAEMON|syslog.LOG_INFO, tag, format)
+}
@@ -0,0 +1,17 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+
+	"github.com/inconshreveable/log15"
+)
+
+// newSyslogHandler returns an error since syslog is not available on this platform
+func newSyslogHandler(sink Sink, format log15.Format) (log15.Handler, error) {
+	return nil, errors.New("syslog log sinks are not supported on this platform")
+}
@@ -17,6 +17,7 @@ package tools
 import (
 	"io/ioutil"
 	"path"
+	"path/filepath"
 
 	"github.com/labneco/doxa/doxa/tools/generate"
 )
@@ -27,13 +28,16 @@ import (
 // If diskPath is true, returned file names are relative to the doxa directory
 // (e.g. doxa/server/static/src/js/foo.js) otherwise file names are relative
 // to the http root (e.g. /static/src/js/foo.js)
-func ListStaticFiles(subDir string, modules []string, diskPath bool) []string {
+//
+// Modules are scanned in the given order. If extensions are given, only the
+// files with one of these extensions (e.g. ".js") are returned.
+func ListStaticFiles(subDir string, modules []string, diskPath bool, extensions ...string) []string {
 	var res []string
 	for _, module := range modules {
 		dirName := path.Join(generate.DoxaDir, "doxa", "server", "static", module, subDir)
 		fileInfos, _ := ioutil.ReadDir(dirName)
 		for _, fi := range fileInfos {
-			if !fi.IsDir() {
+			if !fi.IsDir() && hasExtension(fi.Name(), extensions) {
 				fPath := path.Join("static", module, subDir, fi.Name())
 				if diskPath {
 					fPath = path.Join(generate.DoxaDir, "doxa", "server", fPath)
@@ -44,3 +48,18 @@ func ListStaticFiles(subDir string, modules []string, diskPath bool) []string {
 	}
 	return res
 }
+
+// hasExtension returns true if the given file name has one of the
+// given extensions or if extensions is empty.
+func hasExtension(fileName string, extensions []string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := filepath.Ext(fileName)
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -164,3 +164,84 @@ func IsZero(value float64, precision float64) bool {
 	}
 	return false
 }
+
+// A RoundingMode defines how RoundWithMode rounds values
+type RoundingMode int8
+
+// Rounding modes
+const (
+	// RoundHalfUp rounds to the nearest value, ties away from zero
+	RoundHalfUp RoundingMode = iota
+	// RoundHalfEven rounds to the nearest value, ties to the nearest even value
+	RoundHalfEven
+	// RoundUp rounds away from zero
+	RoundUp
+	// RoundDown rounds towards zero
+	RoundDown
+)
+
+// RoundWithMode rounds the given value to the given precision with the given
+// mode. precision is a float such as:
+//
+// - 0.01 to round at the nearest 100th
+// - 10 to round at the nearest ten
+//
+// Values are first normalized to the precision and corrected by the
+// representation error of floats, so that e.g. 2.675 is rounded to 2.68
+// in RoundHalfUp mode at 0.01 precision, even though its float value is
+// slightly lower than 2.675, and 1.1 is rounded to 1.1 in RoundUp mode.
+func RoundWithMode(value, precision float64, mode RoundingMode) float64 {
+	if value == 0 || precision <= 0 {
+		return value
+	}
+	normalized := value / precision
+	sign := math.Copysign(1, normalized)
+	// Representation error of the normalized value, i.e. one unit in the last place
+	epsilon := math.Pow(2, math.Floor(math.Log2(math.Abs(normalized)))-52)
+	var rounded float64
+	switch mode {
+	case RoundHalfEven:
+		integer, frac := math.Modf(math.Abs(normalized))
+		if math.Abs(frac-0.5) <= epsilon {
+			normalized = sign * (integer + 0.5)
+		}
+		rounded = math.RoundToEven(normalized)
+	case RoundUp:
+		rounded = sign * math.Ceil(math.Abs(normalized)-epsilon)
+	case RoundDown:
+		rounded = sign * math.Floor(math.Abs(normalized)+epsilon)
+	default:
+		rounded = math.Round(normalized + sign*epsilon)
+	}
+	if precision < 1 {
+		// Dividing by the inverse gives exact results for precisions such as 0.01
+		if inverse := math.Round(1 / precision); math.Abs(inverse*precision-1) < 1e-12 {
+			return rounded / inverse
+		}
+	}
+	return rounded * precision
+}
+
+// AlmostEqual returns true if the difference between value1
+// and value2 is lower than or equal to the given tolerance.
+func AlmostEqual(value1, value2, tolerance float64) bool {
+	return math.Abs(value1-value2) <= tolerance
+}
+
+// Round rounds the given value to the precision of these
+// digits with the given mode. See RoundWithMode.
+func (d Digits) Round(value float64, mode RoundingMode) float64 {
+	return RoundWithMode(value, d.ToPrecision(), mode)
+}
+
+// Compare compares value1 and value2 after rounding them to
+// the precision of these digits. See Compare for details.
+func (d Digits) Compare(value1, value2 float64) int8 {
+	return Compare(value1, value2, d.ToPrecision())
+}
+
+// IsZero returns true if value is small enough to be treated as
+// zero at the precision of these digits. See IsZero for details.
+func (d Digits) IsZero(value float64) bool {
+	return IsZero(value, d.ToPrecision())
+}
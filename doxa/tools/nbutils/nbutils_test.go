@@ -0,0 +1,59 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package nbutils
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRounding(t *testing.T) {
+	Convey("Testing rounding with modes", t, func() {
+		Convey("Half up should round ties away from zero", func() {
+			So(RoundWithMode(2.675, 0.01, RoundHalfUp), ShouldEqual, 2.68)
+			So(RoundWithMode(-2.675, 0.01, RoundHalfUp), ShouldEqual, -2.68)
+			So(RoundWithMode(1.005, 0.01, RoundHalfUp), ShouldEqual, 1.01)
+			So(RoundWithMode(2.674, 0.01, RoundHalfUp), ShouldEqual, 2.67)
+			So(RoundWithMode(125, 10, RoundHalfUp), ShouldEqual, 130)
+		})
+		Convey("Half even should round ties to the nearest even value", func() {
+			So(RoundWithMode(2.675, 0.01, RoundHalfEven), ShouldEqual, 2.68)
+			So(RoundWithMode(2.665, 0.01, RoundHalfEven), ShouldEqual, 2.66)
+			So(RoundWithMode(-0.125, 0.01, RoundHalfEven), ShouldEqual, -0.12)
+			So(RoundWithMode(2.6651, 0.01, RoundHalfEven), ShouldEqual, 2.67)
+			So(RoundWithMode(125, 10, RoundHalfEven), ShouldEqual, 120)
+		})
+		Convey("Up should round away from zero", func() {
+			So(RoundWithMode(1.1, 0.01, RoundUp), ShouldEqual, 1.1)
+			So(RoundWithMode(1.101, 0.01, RoundUp), ShouldEqual, 1.11)
+			So(RoundWithMode(-1.101, 0.01, RoundUp), ShouldEqual, -1.11)
+			So(RoundWithMode(121, 10, RoundUp), ShouldEqual, 130)
+		})
+		Convey("Down should round towards zero", func() {
+			So(RoundWithMode(1.15, 0.01, RoundDown), ShouldEqual, 1.15)
+			So(RoundWithMode(1.159, 0.01, RoundDown), ShouldEqual, 1.15)
+			So(RoundWithMode(-1.159, 0.01, RoundDown), ShouldEqual, -1.15)
+			So(RoundWithMode(0.3-0.1, 0.1, RoundDown), ShouldEqual, 0.2)
+		})
+		Convey("Zero values and precisions should be returned as is", func() {
+			So(RoundWithMode(0, 0.01, RoundUp), ShouldEqual, 0)
+			So(RoundWithMode(1.234, 0, RoundUp), ShouldEqual, 1.234)
+		})
+	})
+	Convey("Testing digits helpers", t, func() {
+		digits := Digits{Scale: 16, Precision: 2}
+		So(digits.Round(2.675, RoundHalfUp), ShouldEqual, 2.68)
+		So(digits.Round(2.671, RoundUp), ShouldEqual, 2.68)
+		So(digits.Compare(1.432, 1.431), ShouldEqual, 0)
+		So(digits.Compare(0.006, 0.002), ShouldEqual, 1)
+		So(digits.IsZero(0.004), ShouldBeTrue)
+		So(digits.IsZero(0.006), ShouldBeFalse)
+	})
+	Convey("Testing float comparison with tolerance", t, func() {
+		So(AlmostEqual(0.1+0.2, 0.3, 1e-9), ShouldBeTrue)
+		So(AlmostEqual(1.01, 1, 0.01), ShouldBeFalse)
+		So(AlmostEqual(1.01, 1, 0.02), ShouldBeTrue)
+	})
+}
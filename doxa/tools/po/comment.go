@@ -225,7 +225,20 @@ func (p *Comment) GetFuzzy() bool {
 
 // SetFuzzy sets the fuzzy flag.
 func (p *Comment) SetFuzzy(fuzzy bool) {
-	//
+	if fuzzy == p.GetFuzzy() {
+		return
+	}
+	if fuzzy {
+		p.Flags = append([]string{"fuzzy"}, p.Flags...)
+		return
+	}
+	flags := p.Flags[:0]
+	for _, s := range p.Flags {
+		if s != "fuzzy" {
+			flags = append(flags, s)
+		}
+	}
+	p.Flags = flags
 }
 
 // String returns the po format comment string.
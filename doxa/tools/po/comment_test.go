@@ -8,6 +8,30 @@ import (
 	"testing"
 )
 
+func TestSetFuzzy(t *testing.T) {
+	c := Comment{Flags: []string{"c-format"}}
+	c.SetFuzzy(true)
+	if !reflect.DeepEqual(c.Flags, []string{"fuzzy", "c-format"}) {
+		t.Fatalf("Unexpected flags after setting fuzzy: %q", c.Flags)
+	}
+	c.SetFuzzy(true)
+	if len(c.Flags) != 2 {
+		t.Fatalf("Fuzzy flag added twice: %q", c.Flags)
+	}
+	c.SetFuzzy(false)
+	if !reflect.DeepEqual(c.Flags, []string{"c-format"}) || c.GetFuzzy() {
+		t.Fatalf("Unexpected flags after unsetting fuzzy: %q", c.Flags)
+	}
+}
+
+func TestPrevMsgIdString(t *testing.T) {
+	c := Comment{Flags: []string{"fuzzy"}, PrevMsgId: "Previous string"}
+	expected := "#, fuzzy\n#| msgid \"Previous string\"\n"
+	if s := c.String(); s != expected {
+		t.Fatalf("Expected %q, got %q", expected, s)
+	}
+}
+
 func TestPoComment(t *testing.T) {
 	var x Comment
 	for i := 0; i < len(testPoComments); i++ {
@@ -0,0 +1,79 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package po
+
+// IsTranslated returns true if this message has a translation which is not
+// fuzzy. Messages with plural forms are translated if all their forms are.
+func (p *Message) IsTranslated() bool {
+	if p.GetFuzzy() {
+		return false
+	}
+	if p.MsgIdPlural == "" {
+		return p.MsgStr != ""
+	}
+	if len(p.MsgStrPlural) == 0 {
+		return false
+	}
+	for _, str := range p.MsgStrPlural {
+		if str == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// A Coverage reports the translation completeness of a PO file
+type Coverage struct {
+	Total      int       // number of messages to translate
+	Translated int       // number of translated messages
+	Missing    []Message // messages that are not translated
+}
+
+// Percent returns the percentage of translated messages.
+// It returns 100 if there are no messages to translate.
+func (c Coverage) Percent() float64 {
+	if c.Total == 0 {
+		return 100
+	}
+	return float64(c.Translated) * 100 / float64(c.Total)
+}
+
+// Coverage returns the translation coverage of this file.
+//
+// If template is not nil, the messages to translate are those of the template
+// (i.e. the POT file of the module), so that messages that have not been
+// merged into this file yet are reported missing. Otherwise, they are the
+// messages of this file. Missing messages are listed in the order of the
+// messages to translate.
+func (f *File) Coverage(template *File) Coverage {
+	translated := make(map[messageKey]bool, len(f.Messages))
+	for _, msg := range f.Messages {
+		translated[msg.key()] = msg.IsTranslated()
+	}
+	reference := f
+	if template != nil {
+		reference = template
+	}
+	var res Coverage
+	for _, msg := range reference.Messages {
+		res.Total++
+		if translated[msg.key()] {
+			res.Translated++
+			continue
+		}
+		res.Missing = append(res.Missing, msg)
+	}
+	return res
+}
+
+// A messageKey identifies a message in a PO file
+type messageKey struct {
+	context string
+	id      string
+}
+
+// key returns the messageKey of this message
+func (p *Message) key() messageKey {
+	return messageKey{context: p.MsgContext, id: p.MsgId}
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package po
+
+import (
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	file := &File{
+		Messages: []Message{
+			{MsgId: "Draft", MsgStr: "Brouillon"},
+			{MsgId: "Done"},
+			{MsgId: "Draft", MsgContext: "state", MsgStr: "Ébauche", Comment: Comment{Flags: []string{"fuzzy"}}},
+			{MsgId: "%d record", MsgIdPlural: "%d records", MsgStrPlural: []string{"%d enregistrement", "%d enregistrements"}},
+			{MsgId: "%d file", MsgIdPlural: "%d files", MsgStrPlural: []string{"%d fichier", ""}},
+		},
+	}
+	cov := file.Coverage(nil)
+	if cov.Total != 5 || cov.Translated != 2 || cov.Percent() != 40 {
+		t.Fatalf("Expected 2 translated messages out of 5, got %d out of %d", cov.Translated, cov.Total)
+	}
+	var missing []string
+	for _, msg := range cov.Missing {
+		missing = append(missing, msg.MsgContext+"|"+msg.MsgId)
+	}
+	if len(missing) != 3 || missing[0] != "|Done" || missing[1] != "state|Draft" || missing[2] != "|%d file" {
+		t.Fatalf("Unexpected missing messages: %q", missing)
+	}
+
+	template := &File{
+		Messages: []Message{
+			{MsgId: "Draft"},
+			{MsgId: "%d record", MsgIdPlural: "%d records"},
+			{MsgId: "Cancelled"},
+		},
+	}
+	cov = file.Coverage(template)
+	if cov.Total != 3 || cov.Translated != 2 || len(cov.Missing) != 1 || cov.Missing[0].MsgId != "Cancelled" {
+		t.Fatalf("Expected only 'Cancelled' to be missing from template, got %d out of %d (missing: %v)", cov.Translated, cov.Total, cov.Missing)
+	}
+
+	if pct := (&File{}).Coverage(nil).Percent(); pct != 100 {
+		t.Fatalf("Expected empty file to be fully translated, got %v", pct)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package po
+
+// FuzzyMatchThreshold is the minimum similarity between the source strings
+// of two messages for the translation of one to be proposed for the other.
+const FuzzyMatchThreshold = 0.6
+
+// MergeStats counts the messages of a file merged with Merge
+type MergeStats struct {
+	Kept     int // messages whose translation has been kept
+	Fuzzy    int // messages whose translation has been marked fuzzy
+	New      int // new messages without translation
+	Obsolete int // messages removed since they are no longer in the template
+}
+
+// Merge updates the messages of this file with those of the given template,
+// in the way of msgmerge:
+//
+//   - messages of the template that are in this file keep their translation,
+//     translator comments and flags. Their translation is marked fuzzy if their
+//     plural source string has changed.
+//   - new messages take the translation of the removed message with the most
+//     similar source string, if any is similar enough, and are marked fuzzy with
+//     the source string of this message as previous source string.
+//   - messages that are not in the template are removed.
+//
+// New messages with plural forms get nPlurals empty translations.
+func (f *File) Merge(template *File, nPlurals int) MergeStats {
+	existing := make(map[messageKey]Message, len(f.Messages))
+	for _, msg := range f.Messages {
+		existing[msg.key()] = msg
+	}
+	used := make(map[messageKey]bool, len(template.Messages))
+	for _, msg := range template.Messages {
+		if _, ok := existing[msg.key()]; ok {
+			used[msg.key()] = true
+		}
+	}
+	// Candidates for fuzzy matching are the translated messages that are removed
+	var candidates []Message
+	for _, msg := range f.Messages {
+		if !used[msg.key()] && msg.hasTranslation() {
+			candidates = append(candidates, msg)
+		}
+	}
+	var stats MergeStats
+	msgs := make([]Message, len(template.Messages))
+	for i, msg := range template.Messages {
+		old, ok := existing[msg.key()]
+		switch {
+		case ok:
+			msg.MsgStr = old.MsgStr
+			msg.MsgStrPlural = old.MsgStrPlural
+			msg.TranslatorComment = old.TranslatorComment
+			msg.Flags = old.Flags
+			msg.PrevMsgContext = old.PrevMsgContext
+			msg.PrevMsgId = old.PrevMsgId
+			if old.MsgIdPlural != msg.MsgIdPlural && old.hasTranslation() {
+				msg.SetFuzzy(true)
+			}
+		default:
+			if similar, found := mostSimilarMessage(msg, candidates); found {
+				msg.MsgStr = similar.MsgStr
+				msg.MsgStrPlural = similar.MsgStrPlural
+				msg.PrevMsgContext = similar.MsgContext
+				msg.PrevMsgId = similar.MsgId
+				msg.SetFuzzy(true)
+				break
+			}
+			if msg.MsgIdPlural != "" {
+				msg.MsgStrPlural = make([]string, nPlurals)
+			}
+		}
+		switch {
+		case msg.GetFuzzy() && msg.hasTranslation():
+			stats.Fuzzy++
+		case msg.hasTranslation():
+			stats.Kept++
+		default:
+			stats.New++
+		}
+		if !msg.GetFuzzy() {
+			// Previous source strings are only meaningful for fuzzy messages
+			msg.PrevMsgContext, msg.PrevMsgId = "", ""
+		}
+		msgs[i] = msg
+	}
+	stats.Obsolete = len(f.Messages) - len(used)
+	f.Messages = msgs
+	return stats
+}
+
+// hasTranslation returns true if this message has a
+// translation, even partial, whether it is fuzzy or not.
+func (p *Message) hasTranslation() bool {
+	if p.MsgStr != "" {
+		return true
+	}
+	for _, str := range p.MsgStrPlural {
+		if str != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mostSimilarMessage returns the message of candidates whose source string is
+// the most similar to that of msg, if their similarity reaches FuzzyMatchThreshold.
+// Only messages with plural forms are matched to messages with plural forms.
+func mostSimilarMessage(msg Message, candidates []Message) (Message, bool) {
+	var (
+		res       Message
+		bestScore float64
+	)
+	for _, cand := range candidates {
+		if (cand.MsgIdPlural == "") != (msg.MsgIdPlural == "") {
+			continue
+		}
+		score := similarity(cand.MsgContext+cand.MsgId, msg.MsgContext+msg.MsgId)
+		if score >= FuzzyMatchThreshold && score > bestScore {
+			res, bestScore = cand, score
+		}
+	}
+	return res, bestScore > 0
+}
+
+// similarity returns the similarity of a and b between 0 and 1, computed
+// as the ratio of the length of their longest common subsequence to their
+// mean length.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	total := len(ra) + len(rb)
+	if total == 0 {
+		return 1
+	}
+	short, long := len(ra), len(rb)
+	if short > long {
+		short, long = long, short
+	}
+	if float64(2*short)/float64(total) < FuzzyMatchThreshold {
+		// a and b cannot be similar enough
+		return 0
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			switch {
+			case ra[i-1] == rb[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] > cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return float64(2*prev[len(rb)]) / float64(total)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package po
+
+import (
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	file := &File{
+		Messages: []Message{
+			{MsgId: "Draft", MsgStr: "Brouillon", Comment: Comment{TranslatorComment: "State"}},
+			{MsgId: "The record has been saved", MsgStr: "L'enregistrement a été sauvegardé"},
+			{MsgId: "%d file", MsgIdPlural: "%d files", MsgStrPlural: []string{"%d fichier", "%d fichiers"}},
+			{MsgId: "Obsolete", MsgStr: "Obsolète"},
+		},
+	}
+	template := &File{
+		Messages: []Message{
+			{MsgId: "Draft", Comment: Comment{ExtractedComment: "code:"}},
+			{MsgId: "The records have been saved"},
+			{MsgId: "%d file", MsgIdPlural: "%d documents"},
+			{MsgId: "%d record", MsgIdPlural: "%d records"},
+			{MsgId: "Cancelled"},
+		},
+	}
+	stats := file.Merge(template, 3)
+	if stats != (MergeStats{Kept: 1, Fuzzy: 2, New: 2, Obsolete: 2}) {
+		t.Fatalf("Unexpected merge stats: %+v", stats)
+	}
+	if len(file.Messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(file.Messages))
+	}
+	draft := file.Messages[0]
+	if draft.MsgStr != "Brouillon" || draft.TranslatorComment != "State" || draft.ExtractedComment != "code:" || draft.GetFuzzy() {
+		t.Fatalf("Unexpected kept message: %+v", draft)
+	}
+	saved := file.Messages[1]
+	if saved.MsgStr != "L'enregistrement a été sauvegardé" || !saved.GetFuzzy() || saved.PrevMsgId != "The record has been saved" {
+		t.Fatalf("Unexpected fuzzy message: %+v", saved)
+	}
+	files := file.Messages[2]
+	if len(files.MsgStrPlural) != 2 || files.MsgStrPlural[1] != "%d fichiers" || !files.GetFuzzy() {
+		t.Fatalf("Unexpected message with changed plural: %+v", files)
+	}
+	records := file.Messages[3]
+	if len(records.MsgStrPlural) != 3 || records.hasTranslation() || records.GetFuzzy() {
+		t.Fatalf("Unexpected new plural message: %+v", records)
+	}
+	if cancelled := file.Messages[4]; cancelled.MsgStr != "" || cancelled.GetFuzzy() {
+		t.Fatalf("Unexpected new message: %+v", cancelled)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := similarity("", ""); s != 1 {
+		t.Errorf("Expected empty strings to be identical, got %f", s)
+	}
+	if s := similarity("abcd", "abcd"); s != 1 {
+		t.Errorf("Expected identical strings to have similarity 1, got %f", s)
+	}
+	if s := similarity("abcd", "abxd"); s != 0.75 {
+		t.Errorf("Expected similarity 0.75, got %f", s)
+	}
+	if s := similarity("a", "abcdefgh"); s != 0 {
+		t.Errorf("Expected strings of very different lengths not to be similar, got %f", s)
+	}
+}
@@ -90,7 +90,7 @@ func encodeCommentPoString(text string) string {
 		buf.WriteString(`""` + "\n")
 	}
 	for i := 0; i < len(lines); i++ {
-		if len(lines) > 0 {
+		if len(lines) > 1 {
 			buf.WriteString("#| ")
 		}
 		buf.WriteRune('"')
@@ -0,0 +1,405 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package sentry reports the errors and panics of Doxa to an error tracking
+service compatible with the Sentry protocol, such as Sentry or GlitchTip.
+
+Reporting is disabled by default. When it is enabled with Initialize, the
+errors logged at the error or critical level and the panics recovered by the
+framework are sent as events to the endpoint given by the DSN of the project,
+with their stack trace and context: request ID, user ID, and the model and
+method being executed. Context values whose key looks sensitive, such as
+passwords or tokens, and credentials found in messages and values are
+replaced by "[Filtered]" before being sent.
+
+Events are sent in the background, so that logging is not slowed down.
+Events are dropped if the endpoint cannot keep up.
+*/
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/labneco/doxa/doxa/tools/logging"
+	"github.com/labneco/doxa/doxa/tools/strutils"
+	"github.com/spf13/viper"
+)
+
+const (
+	// clientName is the name of the client sent to the endpoint
+	clientName = "doxa-sentry/1.0"
+	// queueSize is the number of events waiting to be sent above which events are dropped
+	queueSize = 100
+)
+
+// tagKeys are the context keys of error events that are sent as event
+// tags, which can be searched. Other keys are sent as extra data.
+var tagKeys = map[string]bool{
+	"request_id": true,
+	"module":     true,
+	"model":      true,
+	"method":     true,
+	"route":      true,
+}
+
+// filtered replaces the sensitive data of events
+const filtered = "[Filtered]"
+
+// sensitiveValues match the credentials that may appear in the values of
+// error events and in their message, such as "password=xxx", "Bearer xxx" or
+// the password of a URL. The credential itself is their second group.
+var sensitiveValues = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api_?key)["']?\s*[:=]\s*["']?)([^\s"'&,;]+)`),
+	regexp.MustCompile(`(?i)((?:bearer|basic)\s+)([\w.~+/=-]+)`),
+	regexp.MustCompile(`(://[^/\s:@]+:)([^/\s@]+)(@)`),
+}
+
+// isSensitiveKey returns true if the values of the given context key
+// must not be sent to the endpoint, because its name contains one of
+// the strutils.SensitiveWords.
+func isSensitiveKey(key string) bool {
+	return strutils.IsSensitiveName(key)
+}
+
+// scrub returns the given value with the credentials it may contain replaced
+func scrub(value string) string {
+	for _, re := range sensitiveValues {
+		value = re.ReplaceAllString(value, "${1}"+filtered+"${3}")
+	}
+	return value
+}
+
+// An InvalidDSNError is returned when a DSN cannot be parsed
+type InvalidDSNError string
+
+// Error returns the error message
+func (ide InvalidDSNError) Error() string {
+	return "Invalid Sentry DSN: " + string(ide)
+}
+
+// A DSN holds the connection settings to a project of a Sentry-compatible
+// endpoint, parsed from a DSN string such as
+// 'https://<public_key>@sentry.example.com/<project_id>'.
+type DSN struct {
+	// StoreURL is the URL to which events are posted
+	StoreURL string
+	// PublicKey and SecretKey authenticate the client.
+	// SecretKey is optional in recent versions of Sentry.
+	PublicKey string
+	SecretKey string
+}
+
+// ParseDSN parses the given DSN string
+func ParseDSN(dsn string) (DSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return DSN{}, InvalidDSNError(err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return DSN{}, InvalidDSNError("scheme must be http or https")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return DSN{}, InvalidDSNError("missing public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return DSN{}, InvalidDSNError("missing project ID")
+	}
+	secretKey, _ := u.User.Password()
+	return DSN{
+		StoreURL:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:slash], projectID),
+		PublicKey: u.User.Username(),
+		SecretKey: secretKey,
+	}, nil
+}
+
+// An Event is an error or a panic in the format of the Sentry protocol
+type Event struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Level       string                 `json:"level"`
+	Platform    string                 `json:"platform"`
+	Logger      string                 `json:"logger,omitempty"`
+	Message     string                 `json:"message,omitempty"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	User        *User                  `json:"user,omitempty"`
+	Exception   *Exceptions            `json:"exception,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+// A User is the user for whom an event occurred
+type User struct {
+	ID string `json:"id"`
+}
+
+// Exceptions are the exceptions of an event
+type Exceptions struct {
+	Values []Exception `json:"values"`
+}
+
+// An Exception is an error or a panic with its stack trace
+type Exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *Stacktrace `json:"stacktrace,omitempty"`
+}
+
+// A Stacktrace holds the frames of an exception, from the outermost to the innermost
+type Stacktrace struct {
+	Frames []Frame `json:"frames"`
+}
+
+// A Frame is a frame of a stack trace
+type Frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// NewEvent returns the Event of the given error event of the logging package
+func NewEvent(ee logging.ErrorEvent) *Event {
+	event := &Event{
+		EventID:   newEventID(),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:     "error",
+		Platform:  "go",
+		Message:   scrub(ee.Message),
+		Tags:      make(map[string]string),
+		Extra:     make(map[string]interface{}),
+	}
+	if ee.Level == log15.LvlCrit {
+		event.Level = "fatal"
+	}
+	for i := 0; i+1 < len(ee.Context); i += 2 {
+		key := fmt.Sprintf("%v", ee.Context[i])
+		value := scrub(fmt.Sprintf("%v", ee.Context[i+1]))
+		switch {
+		case isSensitiveKey(key):
+			event.Extra[key] = filtered
+		case key == "uid":
+			event.User = &User{ID: value}
+		case tagKeys[key]:
+			event.Tags[key] = value
+		default:
+			event.Extra[key] = value
+		}
+	}
+	event.Logger = event.Tags["module"]
+	excType := "error"
+	if ee.Panic {
+		excType = "panic"
+	}
+	frames := make([]Frame, len(ee.Frames))
+	for i, f := range ee.Frames {
+		module, function := splitFunctionName(f.Function)
+		frames[len(frames)-1-i] = Frame{
+			Function: function,
+			Module:   module,
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    !isStandardPackage(module),
+		}
+	}
+	event.Exception = &Exceptions{Values: []Exception{{
+		Type:       excType,
+		Value:      event.Message,
+		Stacktrace: &Stacktrace{Frames: frames},
+	}}}
+	return event
+}
+
+// splitFunctionName splits the given fully qualified function
+// name into its package path and its function name.
+func splitFunctionName(name string) (string, string) {
+	lastSlash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[lastSlash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:lastSlash+1+dot], name[lastSlash+2+dot:]
+}
+
+// isStandardPackage returns true if the given package path is a package
+// of the standard library, i.e. if its first element has no dot.
+func isStandardPackage(pkg string) bool {
+	return !strings.Contains(strings.SplitN(pkg, "/", 2)[0], ".")
+}
+
+// newEventID returns a new random event ID
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// A Client sends events to a Sentry-compatible endpoint in the background
+type Client struct {
+	dsn         DSN
+	release     string
+	environment string
+	serverName  string
+	httpClient  *http.Client
+	events      chan *Event
+	wg          sync.WaitGroup
+	mu          sync.RWMutex
+	closed      bool
+}
+
+// NewClient returns a new Client sending events to the project of the given
+// DSN, with the given release and environment, and starts sending events.
+func NewClient(dsn, release, environment string) (*Client, error) {
+	parsedDSN, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	serverName, _ := os.Hostname()
+	c := &Client{
+		dsn:         parsedDSN,
+		release:     release,
+		environment: environment,
+		serverName:  serverName,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		events:      make(chan *Event, queueSize),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+// Capture queues the given event to be sent. It returns false if the event
+// is dropped because too many events are waiting to be sent or because the
+// client is closed.
+func (c *Client) Capture(event *Event) bool {
+	event.Release = c.release
+	event.Environment = c.environment
+	event.ServerName = c.serverName
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close sends the queued events and stops the client.
+// It waits at most for the given timeout.
+func (c *Client) Close(timeout time.Duration) {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.events)
+	}
+	c.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("Timeout while sending error events", "timeout", timeout)
+	}
+}
+
+// run sends the queued events until the client is closed
+func (c *Client) run() {
+	defer c.wg.Done()
+	for event := range c.events {
+		if err := c.send(event); err != nil {
+			// Errors are logged as warnings so that they are not reported again
+			log.Warn("Unable to send error event", "event_id", event.EventID, "error", err)
+		}
+	}
+}
+
+// send posts the given event to the endpoint
+func (c *Client) send(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.dsn.StoreURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_timestamp=%d, sentry_key=%s",
+		clientName, time.Now().Unix(), c.dsn.PublicKey)
+	if c.dsn.SecretKey != "" {
+		auth += ", sentry_secret=" + c.dsn.SecretKey
+	}
+	req.Header.Set("X-Sentry-Auth", auth)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+var (
+	log    *logging.Logger
+	client *Client
+)
+
+// Initialize enables error reporting if a DSN is set in the 'Sentry.DSN'
+// configuration key. The following keys are also read:
+//
+// - Sentry.Environment is the environment of the events (e.g. 'production'),
+// - Sentry.Release is the release of the events (e.g. 'doxa@0.1').
+func Initialize() {
+	dsn := viper.GetString("Sentry.DSN")
+	if dsn == "" {
+		return
+	}
+	var err error
+	client, err = NewClient(dsn, viper.GetString("Sentry.Release"), viper.GetString("Sentry.Environment"))
+	if err != nil {
+		log.Panic("Unable to configure error reporting", "error", err)
+	}
+	logging.AddErrorHook(func(ee logging.ErrorEvent) {
+		client.Capture(NewEvent(ee))
+	})
+	log.Info("Error reporting enabled", "endpoint", client.dsn.StoreURL)
+}
+
+// Shutdown sends the pending events and stops error reporting
+func Shutdown() {
+	if client == nil {
+		return
+	}
+	client.Close(5 * time.Second)
+}
+
+func init() {
+	log = logging.GetLogger("sentry")
+}
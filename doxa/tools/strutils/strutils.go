@@ -109,3 +109,21 @@ func Substitute(str string, mapping map[string]string) string {
 	}
 	return str
 }
+
+// SensitiveWords are the words found in the names of the context keys,
+// fields and settings whose values are credentials. Such values are never
+// displayed, reported to error trackers or posted to webhooks.
+var SensitiveWords = []string{"password", "passwd", "secret", "token", "authorization", "apikey", "api_key",
+	"cookie", "hash", "recovery"}
+
+// IsSensitiveName returns true if the given name contains one
+// of the SensitiveWords, regardless of its case.
+func IsSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range SensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
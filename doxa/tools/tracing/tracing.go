@@ -0,0 +1,134 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+/*
+Package tracing configures OpenTelemetry distributed tracing for Doxa.
+
+Tracing is disabled by default. When it is enabled with Initialize, spans
+are created for HTTP requests and their middlewares, model method calls and
+SQL queries, so that slow requests can be broken down across layers.
+*/
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labneco/doxa/doxa/tools/logging"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExporterStdout is the name of the built-in exporter that writes
+// spans as JSON to the standard output. It is meant for development.
+const ExporterStdout = "stdout"
+
+// An ExporterFactory returns a new span exporter
+type ExporterFactory func() (sdktrace.SpanExporter, error)
+
+var (
+	exportersMutex sync.RWMutex
+	exporters      map[string]ExporterFactory
+)
+
+// RegisterExporter registers the given span exporter factory under the given
+// name, so that it can be selected with the 'Tracing.Exporter' configuration key.
+//
+// Modules can register exporters for their tracing backend, such as an OTLP
+// exporter sending spans to an OpenTelemetry collector.
+func RegisterExporter(name string, factory ExporterFactory) {
+	exportersMutex.Lock()
+	defer exportersMutex.Unlock()
+	exporters[name] = factory
+}
+
+var (
+	log      *logging.Logger
+	enabled  int32
+	provider *sdktrace.TracerProvider
+)
+
+// Enabled returns true if tracing has been enabled with Initialize
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Tracer returns the tracer with the given instrumentation name
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// newExporter returns a new span exporter from the factory registered under the given name
+func newExporter(name string) (sdktrace.SpanExporter, error) {
+	exportersMutex.RLock()
+	defer exportersMutex.RUnlock()
+	factory, ok := exporters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tracing exporter '%s'", name)
+	}
+	return factory()
+}
+
+// Initialize enables tracing if the name of a registered exporter is set in
+// the 'Tracing.Exporter' configuration key. The following keys are also read:
+//
+// - Tracing.SampleRatio is the ratio of traces to sample (defaults to 1),
+// - Tracing.ServiceName is the name of the service (defaults to 'doxa').
+func Initialize() {
+	exporterName := viper.GetString("Tracing.Exporter")
+	if exporterName == "" {
+		return
+	}
+	exporter, err := newExporter(exporterName)
+	if err != nil {
+		log.Panic("Unable to create tracing exporter", "exporter", exporterName, "error", err)
+	}
+	serviceName := viper.GetString("Tracing.ServiceName")
+	if serviceName == "" {
+		serviceName = "doxa"
+	}
+	ratio := 1.0
+	if viper.IsSet("Tracing.SampleRatio") {
+		ratio = viper.GetFloat64("Tracing.SampleRatio")
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	atomic.StoreInt32(&enabled, 1)
+	log.Info("Tracing enabled", "exporter", exporterName, "service", serviceName, "ratio", ratio)
+}
+
+// Shutdown flushes the pending spans and stops tracing
+func Shutdown() {
+	if !Enabled() {
+		return
+	}
+	atomic.StoreInt32(&enabled, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Warn("Error while shutting down tracing", "error", err)
+	}
+}
+
+func init() {
+	log = logging.GetLogger("tracing")
+	exporters = make(map[string]ExporterFactory)
+	RegisterExporter(ExporterStdout, func() (sdktrace.SpanExporter, error) {
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	})
+}
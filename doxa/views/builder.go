@@ -0,0 +1,255 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"fmt"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+)
+
+// An Arch is an element of a view arch that is built or altered from Go
+// code. Methods that add elements return the new element, so that its
+// content can be added in turn:
+//
+//	form := views.NewArch("form")
+//	group := form.Add("group", "string", "Settings")
+//	group.AddField("Name")
+//	group.AddField("Active", "widget", "boolean_toggle")
+//	form.AddButton("Apply", "object", "Apply")
+type Arch struct {
+	element *etree.Element
+}
+
+// NewArch returns a new arch root element with the given tag, usually a
+// view type, and attributes given as key/value pairs.
+func NewArch(tag string, attrs ...string) *Arch {
+	doc := etree.NewDocument()
+	return newArch(doc.CreateElement(tag), attrs)
+}
+
+// newArch returns an Arch for the given element after
+// setting the given attributes given as key/value pairs.
+func newArch(element *etree.Element, attrs []string) *Arch {
+	if len(attrs)%2 != 0 {
+		log.Panic("Arch attributes must be given as key/value pairs", "tag", element.Tag, "attrs", attrs)
+	}
+	for i := 0; i < len(attrs); i += 2 {
+		element.CreateAttr(attrs[i], attrs[i+1])
+	}
+	return &Arch{element: element}
+}
+
+// Element returns the underlying etree element of this arch
+func (a *Arch) Element() *etree.Element {
+	return a.element
+}
+
+// Tag returns the tag of this arch element
+func (a *Arch) Tag() string {
+	return a.element.Tag
+}
+
+// Attr returns the value of the attribute of this arch
+// element with the given key, or an empty string.
+func (a *Arch) Attr(key string) string {
+	return a.element.SelectAttrValue(key, "")
+}
+
+// SetAttr sets the attribute with the given key of this
+// arch element and returns this element.
+func (a *Arch) SetAttr(key, value string) *Arch {
+	a.element.CreateAttr(key, value)
+	return a
+}
+
+// RemoveAttr removes the attribute with the given key of
+// this arch element and returns this element.
+func (a *Arch) RemoveAttr(key string) *Arch {
+	a.element.RemoveAttr(key)
+	return a
+}
+
+// SetText sets the text of this arch element and returns this element.
+func (a *Arch) SetText(text string) *Arch {
+	a.element.SetText(text)
+	return a
+}
+
+// Add appends a new child element with the given tag and attributes given
+// as key/value pairs to this arch element and returns it.
+func (a *Arch) Add(tag string, attrs ...string) *Arch {
+	child := newArch(etree.NewElement(tag), attrs)
+	a.element.AddChild(child.element)
+	return child
+}
+
+// AddField appends a field element for the field with the given name
+// and the given attributes to this arch element and returns it.
+func (a *Arch) AddField(name string, attrs ...string) *Arch {
+	return a.Add("field", append([]string{"name", name}, attrs...)...)
+}
+
+// AddButton appends a button element calling the method or action with the
+// given name and type and with the given label to this arch element and
+// returns it.
+func (a *Arch) AddButton(name, typ, label string, attrs ...string) *Arch {
+	return a.Add("button", append([]string{"name", name, "type", typ, "string", label}, attrs...)...)
+}
+
+// AddArch appends a copy of the given arch to this arch element
+// and returns it.
+func (a *Arch) AddArch(arch *Arch) *Arch {
+	child := arch.element.Copy()
+	a.element.AddChild(child)
+	return &Arch{element: child}
+}
+
+// InsertBefore inserts a new element with the given tag and attributes
+// before this arch element and returns it.
+func (a *Arch) InsertBefore(tag string, attrs ...string) *Arch {
+	parent := a.mustParent()
+	child := newArch(etree.NewElement(tag), attrs)
+	parent.InsertChild(a.element, child.element)
+	return child
+}
+
+// InsertAfter inserts a new element with the given tag and attributes
+// after this arch element and returns it.
+func (a *Arch) InsertAfter(tag string, attrs ...string) *Arch {
+	parent := a.mustParent()
+	child := newArch(etree.NewElement(tag), attrs)
+	if next := xmlutils.FindNextSibling(a.element); next != nil {
+		parent.InsertChild(next, child.element)
+		return child
+	}
+	parent.AddChild(child.element)
+	return child
+}
+
+// Remove removes this arch element from its parent.
+// It panics if this element is the root of the arch.
+func (a *Arch) Remove() {
+	a.mustParent().RemoveChild(a.element)
+}
+
+// mustParent returns the parent element of this arch element.
+// It panics if this element is the root of the arch.
+func (a *Arch) mustParent() *etree.Element {
+	parent := a.element.Parent()
+	if parent == nil || parent.Parent() == nil {
+		log.Panic("The root element of an arch cannot be moved or removed", "tag", a.element.Tag)
+	}
+	return parent
+}
+
+// Find returns the first element of this arch matching the given
+// path, as in XML view inheritance specs, or nil if none matches.
+func (a *Arch) Find(path string) *Arch {
+	element := a.element.FindElement(path)
+	if element == nil {
+		return nil
+	}
+	return &Arch{element: element}
+}
+
+// FindField returns the first field element of this arch
+// with the given name, or nil if there is none.
+func (a *Arch) FindField(name string) *Arch {
+	return a.Find(fmt.Sprintf("//field[@name='%s']", name))
+}
+
+// String returns the XML of this arch element
+func (a *Arch) String() string {
+	return xmlutils.ElementToXML(a.element)
+}
+
+// A viewGenerator generates a view at bootstrap
+type viewGenerator struct {
+	id       string
+	model    string
+	generate func(*models.Model) *Arch
+}
+
+// A viewExtension alters the arch of a view at bootstrap
+type viewExtension struct {
+	id    string
+	alter func(*Arch)
+}
+
+// NewView creates a view with the given id for the given model from the
+// given arch, adds it to this collection and returns it. The name and the
+// priority of the view can be changed on the returned view before bootstrap.
+func (vc *Collection) NewView(id, model string, arch *Arch) *View {
+	vc.createNewViewFromXML(&ViewXML{
+		ID:    id,
+		Model: model,
+		Arch:  arch.String(),
+	})
+	return vc.GetByID(id)
+}
+
+// Generate registers the given function to generate the arch of the view with
+// the given id for the given model at bootstrap. Generated views can use the
+// fields and methods of the bootstrapped model and can be extended by XML
+// inheritance specs as any other view.
+func (vc *Collection) Generate(id, model string, generate func(*models.Model) *Arch) {
+	vc.Lock()
+	defer vc.Unlock()
+	vc.generators = append(vc.generators, viewGenerator{id: id, model: model, generate: generate})
+}
+
+// Extend registers the given function to alter the arch of the view with the
+// given id at bootstrap. Functions are called in the order of registration,
+// after XML inheritance specs have been applied.
+func (vc *Collection) Extend(id string, alter func(*Arch)) {
+	vc.Lock()
+	defer vc.Unlock()
+	vc.extensions = append(vc.extensions, viewExtension{id: id, alter: alter})
+}
+
+// generateViews creates the views of the generators of this collection.
+func (vc *Collection) generateViews() {
+	for _, gen := range vc.generators {
+		model, ok := models.Registry.Get(gen.model)
+		if !ok {
+			log.Panic("Unknown model of generated view", "view", gen.id, "model", gen.model)
+		}
+		vc.NewView(gen.id, gen.model, gen.generate(model))
+	}
+	vc.generators = nil
+}
+
+// applyExtensions alters the views of this collection
+// with the registered extension functions.
+func (vc *Collection) applyExtensions() {
+	for _, ext := range vc.extensions {
+		view := vc.GetByID(ext.id)
+		if view == nil {
+			log.Panic("Unknown view to extend", "view", ext.id)
+		}
+		ext.alter(&Arch{element: view.arch})
+	}
+	vc.extensions = nil
+}
+
+// NewView creates a view with the given id for the given model from
+// the given arch and adds it to the Registry. See Collection.NewView.
+func NewView(id, model string, arch *Arch) *View {
+	return Registry.NewView(id, model, arch)
+}
+
+// Generate registers the given function to generate a view of the
+// Registry at bootstrap. See Collection.Generate.
+func Generate(id, model string, generate func(*models.Model) *Arch) {
+	Registry.Generate(id, model, generate)
+}
+
+// Extend registers the given function to alter a view of
+// the Registry at bootstrap. See Collection.Extend.
+func Extend(id string, alter func(*Arch)) {
+	Registry.Extend(id, alter)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+)
+
+// calendarModes are the periods that can be displayed by a calendar view
+var calendarModes = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// dateFieldTypes are the types of the fields that can
+// be used as start or stop dates of calendar and gantt views.
+var dateFieldTypes = []fieldtype.Type{fieldtype.Date, fieldtype.DateTime}
+
+// numericFieldTypes are the types of the fields that can be
+// used as duration or progress in calendar and gantt views.
+var numericFieldTypes = []fieldtype.Type{fieldtype.Integer, fieldtype.Float}
+
+// A CalendarInfo holds the settings of a calendar view, parsed from its arch.
+// Field names are JSON names.
+type CalendarInfo struct {
+	// DateStart is the field of the start date of the records
+	DateStart string `json:"date_start"`
+	// DateStop is the field of the end date of the records
+	DateStop string `json:"date_stop,omitempty"`
+	// DateDelay is the field of the duration of the records in hours,
+	// used when there is no DateStop field.
+	DateDelay string `json:"date_delay,omitempty"`
+	// Color is the field by which the records are colored
+	Color string `json:"color,omitempty"`
+	// AllDay is the boolean field telling if the records last all day
+	AllDay string `json:"all_day,omitempty"`
+	// Mode is the period displayed by default: 'day', 'week' or 'month'
+	Mode string `json:"mode"`
+	// QuickAdd is true if records can be created by clicking in the calendar
+	QuickAdd bool `json:"quick_add"`
+}
+
+// processCalendar parses and checks the calendar specific attributes of the
+// arch of this view and sets its Calendar field. Field names are changed
+// to JSON names in the arch.
+//
+// It panics if the view has no start date or if a field or the mode is invalid.
+func (v *View) processCalendar(model *models.Model, fInfos map[string]*models.FieldInfo) {
+	if v.Type != ViewTypeCalendar {
+		return
+	}
+	quickAdd := v.arch.SelectAttrValue("quick_add", "true")
+	info := CalendarInfo{
+		DateStart: v.fieldAttr(model, fInfos, "date_start", dateFieldTypes...),
+		DateStop:  v.fieldAttr(model, fInfos, "date_stop", dateFieldTypes...),
+		DateDelay: v.fieldAttr(model, fInfos, "date_delay", numericFieldTypes...),
+		Color:     v.fieldAttr(model, fInfos, "color"),
+		AllDay:    v.fieldAttr(model, fInfos, "all_day", fieldtype.Boolean),
+		Mode:      v.arch.SelectAttrValue("mode", "week"),
+		QuickAdd:  quickAdd != "false" && quickAdd != "0",
+	}
+	if info.DateStart == "" {
+		log.Panic("Calendar views must have a date_start attribute", "view", v.ID)
+	}
+	if !calendarModes[info.Mode] {
+		log.Panic("Unknown calendar view mode", "view", v.ID, "mode", info.Mode)
+	}
+	v.Calendar = &info
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"strings"
+
+	"github.com/labneco/doxa/doxa/models"
+)
+
+// defaultGanttScales are the scales of gantt views that do not define them
+var defaultGanttScales = []string{"day", "week", "month", "year"}
+
+// A GanttInfo holds the settings of a gantt view, parsed from its arch.
+// Field names are JSON names.
+type GanttInfo struct {
+	// DateStart is the field of the start date of the records
+	DateStart string `json:"date_start"`
+	// DateStop is the field of the end date of the records
+	DateStop string `json:"date_stop,omitempty"`
+	// DateDelay is the field of the duration of the records in hours,
+	// used when there is no DateStop field.
+	DateDelay string `json:"date_delay,omitempty"`
+	// Progress is the numeric field of the completion percentage of the records
+	Progress string `json:"progress,omitempty"`
+	// Color is the field by which the records are colored
+	Color string `json:"color,omitempty"`
+	// DefaultGroupBy is the field by which the records are grouped in rows
+	DefaultGroupBy string `json:"default_group_by,omitempty"`
+	// Scales are the time scales the user can choose from. Each scale is
+	// also an interval by which DateStart can be grouped in read group queries.
+	Scales []string `json:"scales"`
+	// DefaultScale is the scale displayed by default
+	DefaultScale string `json:"default_scale"`
+}
+
+// processGantt parses and checks the gantt specific attributes of the
+// arch of this view and sets its Gantt field. Field names are changed
+// to JSON names in the arch.
+//
+// It panics if the view has no start date or if a field or a scale is invalid.
+func (v *View) processGantt(model *models.Model, fInfos map[string]*models.FieldInfo) {
+	if v.Type != ViewTypeGantt {
+		return
+	}
+	info := GanttInfo{
+		DateStart:    v.fieldAttr(model, fInfos, "date_start", dateFieldTypes...),
+		DateStop:     v.fieldAttr(model, fInfos, "date_stop", dateFieldTypes...),
+		DateDelay:    v.fieldAttr(model, fInfos, "date_delay", numericFieldTypes...),
+		Progress:     v.fieldAttr(model, fInfos, "progress", numericFieldTypes...),
+		Color:        v.fieldAttr(model, fInfos, "color"),
+		Scales:       defaultGanttScales,
+		DefaultScale: v.arch.SelectAttrValue("default_scale", "month"),
+	}
+	if info.DateStart == "" {
+		log.Panic("Gantt views must have a date_start attribute", "view", v.ID)
+	}
+	if groupBy := v.arch.SelectAttr("default_group_by"); groupBy != nil {
+		groupBy.Value = v.groupByField(model, fInfos, groupBy.Value, "default_group_by")
+		info.DefaultGroupBy = groupBy.Value
+	}
+	if scales := v.arch.SelectAttrValue("scales", ""); scales != "" {
+		info.Scales = nil
+		for _, scale := range strings.Split(scales, ",") {
+			scale = strings.TrimSpace(scale)
+			if !models.IsGroupInterval(scale) {
+				log.Panic("Unknown gantt view scale", "view", v.ID, "scale", scale)
+			}
+			info.Scales = append(info.Scales, scale)
+		}
+	}
+	var validDefault bool
+	for _, scale := range info.Scales {
+		validDefault = validDefault || scale == info.DefaultScale
+	}
+	if !validDefault {
+		log.Panic("The default scale of gantt views must be one of their scales", "view", v.ID, "default_scale", info.DefaultScale, "scales", info.Scales)
+	}
+	v.Gantt = &info
+}
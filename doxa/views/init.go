@@ -4,6 +4,8 @@
 package views
 
 import (
+	"fmt"
+
 	"github.com/beevik/etree"
 	"github.com/labneco/doxa/doxa/models"
 	"github.com/labneco/doxa/doxa/tools/logging"
@@ -14,13 +16,21 @@ const maxInheritanceDepth = 100
 var log *logging.Logger
 
 // BootStrap makes the necessary updates to view definitions. In particular:
+// - generates the views registered with Generate.
+// - resolves inherited views, in dependency order.
+// - alters views with the functions registered with Extend.
+// - validates views against their model (see View.Validate).
 // - sets the type of the view from the arch root.
 // - extracts embedded views
 // - populates the fields map from the views arch.
+// - parses and checks the templates, grouping field and progress bar of kanban views.
+// - checks the date, duration and grouping fields and the scales of calendar and gantt views.
+// - parses and checks the filters and group by options of search views.
 func BootStrap() {
 	if !models.BootStrapped() {
 		log.Panic("Models must be bootstrapped before bootstrapping views")
 	}
+	Registry.generateViews()
 	// Inherit/Extend views
 	for loop := 0; loop < maxInheritanceDepth; loop++ {
 		// First step: we extend all we can with pure extension views (no ID)
@@ -47,7 +57,8 @@ func BootStrap() {
 				continue
 			}
 			baseView := Registry.GetByID(xmlView.InheritID)
-			if baseView == nil {
+			if baseView == nil || Registry.hasPendingExtensions(baseView.ID) {
+				// Named extensions are created from the final arch of their base view
 				continue
 			}
 			model := baseView.Model
@@ -68,12 +79,26 @@ func BootStrap() {
 				Type:        baseView.Type,
 				arches:      make(map[string]*etree.Element),
 				FieldParent: baseView.FieldParent,
+				Groups:      splitGroups(xmlView.Groups),
+				Source:      xmlView.Source,
 			}
 			newView.updateViewFromXML(xmlView)
 			Registry.Add(&newView)
 			Registry.rawInheritedViews[i] = nil
 		}
 	}
+	var unresolved []string
+	for _, xmlView := range Registry.rawInheritedViews {
+		if xmlView != nil {
+			unresolved = append(unresolved, fmt.Sprintf("%s (inherit_id: %s)", xmlView.ID, xmlView.InheritID))
+		}
+	}
+	if len(unresolved) > 0 {
+		log.Panic("Unable to resolve inherited views", "views", unresolved)
+	}
+	Registry.rawInheritedViews = nil
+	Registry.applyExtensions()
+	Registry.validateViews()
 	// Post-process all views
 	for _, v := range Registry.views {
 		log.Debug("Postprocessing view", "viewID", v.ID, "model", v.Model, "Type", v.Type)
@@ -0,0 +1,117 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"encoding/json"
+
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+)
+
+// KanbanCardTemplate is the name of the template of the cards of kanban views
+const KanbanCardTemplate = "kanban-box"
+
+// kanbanProgressBarColors are the colors that can be given to the
+// values of the field of the progress bar of a kanban view.
+var kanbanProgressBarColors = map[string]bool{
+	"success": true,
+	"warning": true,
+	"danger":  true,
+	"info":    true,
+	"muted":   true,
+}
+
+// A KanbanInfo holds the settings of a kanban view, parsed from its arch
+type KanbanInfo struct {
+	// DefaultGroupBy is the JSON name of the field by which records are grouped in columns
+	DefaultGroupBy string `json:"default_group_by,omitempty"`
+	// QuickCreate is true if records can be created from the column headers
+	QuickCreate bool `json:"quick_create"`
+	// Templates are the names of the QWeb templates of the view
+	Templates   []string           `json:"templates"`
+	ProgressBar *KanbanProgressBar `json:"progressbar,omitempty"`
+}
+
+// A KanbanProgressBar is the progress bar displayed in the columns
+// of a kanban view, showing the distribution of the values of a field.
+type KanbanProgressBar struct {
+	// Field is the JSON name of the field whose values are counted
+	Field string `json:"field"`
+	// Colors maps the values of the field to the colors of the bar
+	Colors map[string]string `json:"colors"`
+	// SumField is the JSON name of a numeric field summed in the column
+	// header instead of counting the records.
+	SumField string `json:"sum_field,omitempty"`
+}
+
+// processKanban parses and checks the kanban specific parts of the arch of
+// this view and sets its Kanban field. Field names of the default_group_by
+// and progressbar attributes are changed to JSON names.
+//
+// It panics if the view has no card template or if a field is invalid.
+func (v *View) processKanban(model *models.Model, fInfos map[string]*models.FieldInfo) {
+	if v.Type != ViewTypeKanban {
+		return
+	}
+	quickCreate := v.arch.SelectAttrValue("quick_create", "true")
+	info := KanbanInfo{
+		QuickCreate: quickCreate != "false" && quickCreate != "0",
+	}
+	templates := v.arch.SelectElement("templates")
+	if templates == nil {
+		log.Panic("Kanban views must have a templates element", "view", v.ID)
+	}
+	var hasCard bool
+	for _, tmpl := range templates.ChildElements() {
+		name := tmpl.SelectAttrValue("t-name", "")
+		if name == "" {
+			log.Panic("Kanban view templates must have a t-name attribute", "view", v.ID, "template", xmlutils.ElementToXML(tmpl))
+		}
+		hasCard = hasCard || name == KanbanCardTemplate
+		info.Templates = append(info.Templates, name)
+	}
+	if !hasCard {
+		log.Panic("Kanban views must have a card template", "view", v.ID, "template", KanbanCardTemplate)
+	}
+	if groupBy := v.arch.SelectAttr("default_group_by"); groupBy != nil {
+		groupBy.Value = v.groupByField(model, fInfos, groupBy.Value, "default_group_by")
+		info.DefaultGroupBy = groupBy.Value
+	}
+	if bar := v.arch.SelectElement("progressbar"); bar != nil {
+		info.ProgressBar = v.parseKanbanProgressBar(model, fInfos, bar.SelectAttrValue("field", ""), bar.SelectAttrValue("colors", ""))
+		bar.SelectAttr("field").Value = info.ProgressBar.Field
+		if sumField := bar.SelectAttr("sum_field"); sumField != nil {
+			fInfo, jsonName := v.archField(model, fInfos, sumField.Value, "sum_field")
+			if fInfo.Type != fieldtype.Integer && fInfo.Type != fieldtype.Float {
+				log.Panic("Kanban progress bar sum field must be numeric", "view", v.ID, "field", sumField.Value, "type", fInfo.Type)
+			}
+			sumField.Value = jsonName
+			info.ProgressBar.SumField = jsonName
+			v.addField(jsonName)
+		}
+		v.addField(info.ProgressBar.Field)
+	}
+	v.Kanban = &info
+}
+
+// parseKanbanProgressBar returns the progress bar on the given field with the
+// given JSON encoded colors. It panics if the field or the colors are invalid.
+func (v *View) parseKanbanProgressBar(model *models.Model, fInfos map[string]*models.FieldInfo, field, colors string) *KanbanProgressBar {
+	if field == "" {
+		log.Panic("Kanban progress bars must have a field attribute", "view", v.ID)
+	}
+	_, jsonName := v.archField(model, fInfos, field, "progressbar")
+	bar := KanbanProgressBar{Field: jsonName}
+	if err := json.Unmarshal([]byte(colors), &bar.Colors); err != nil {
+		log.Panic("Kanban progress bar colors must be a JSON object", "view", v.ID, "colors", colors, "error", err)
+	}
+	for value, color := range bar.Colors {
+		if !kanbanProgressBarColors[color] {
+			log.Panic("Unknown kanban progress bar color", "view", v.ID, "value", value, "color", color)
+		}
+	}
+	return &bar
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+)
+
+// modifierNames are the names of the modifiers that can be set
+// on the elements of a view arch, either as attributes or in attrs.
+var modifierNames = []string{"invisible", "readonly", "required", "column_invisible"}
+
+// parentPrefix is the prefix of the field names of modifier conditions
+// of embedded views that refer to the fields of the parent record.
+const parentPrefix = "parent."
+
+// Modifiers are the conditions that hide an element of a view arch or make
+// it read only or required. Each modifier name is mapped to either a bool or
+// a domain evaluated by the client on the record displayed in the view.
+//
+// Modifiers are sent to clients as the JSON encoded 'modifiers' attribute of
+// the elements of view archs.
+type Modifiers map[string]interface{}
+
+// An InvalidModifierError is returned when the attrs or the modifier
+// attributes of an element of a view arch are invalid.
+type InvalidModifierError string
+
+// Error returns the error message
+func (ime InvalidModifierError) Error() string {
+	return string(ime)
+}
+
+// ParseModifiers returns the Modifiers of the given element of a view arch of
+// the given model. They are given by the 'invisible', 'readonly', 'required'
+// and 'column_invisible' attributes of the element, with a boolean value, and
+// by its 'attrs' attribute, which is a constant Python dict mapping modifier
+// names to a domain or a boolean, such as:
+//
+//	attrs="{'invisible': [('State', '=', 'draft')], 'required': [('Active', '=', True)]}"
+//
+// Modifiers of attrs take precedence over modifier attributes. Field names of
+// domains are given as JSON names. Fields of the parent record of embedded
+// views are prefixed with 'parent.' and are not checked.
+//
+// It returns nil if the element has no modifiers.
+func ParseModifiers(model *models.Model, element *etree.Element) (Modifiers, error) {
+	res := make(Modifiers)
+	for _, name := range modifierNames {
+		attr := element.SelectAttr(name)
+		if attr == nil {
+			continue
+		}
+		switch strings.TrimSpace(attr.Value) {
+		case "1", "True", "true":
+			res[name] = true
+		case "0", "False", "false":
+		default:
+			return nil, InvalidModifierError(fmt.Sprintf("invalid %s attribute: %s", name, attr.Value))
+		}
+	}
+	if attrs := element.SelectAttrValue("attrs", ""); attrs != "" {
+		if err := parseAttrs(model, attrs, res); err != nil {
+			return nil, err
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// parseAttrs parses the given attrs expression of an element of a view
+// of the given model and sets the modifiers it defines in res.
+func parseAttrs(model *models.Model, attrs string, res Modifiers) error {
+	expr, err := exprutils.Compile(attrs)
+	if err != nil {
+		return InvalidModifierError(fmt.Sprintf("invalid attrs %s: %s", attrs, err))
+	}
+	if len(expr.Names()) > 0 {
+		return InvalidModifierError(fmt.Sprintf("attrs cannot use variables: %s", attrs))
+	}
+	val, err := expr.Eval(nil)
+	if err != nil {
+		return InvalidModifierError(fmt.Sprintf("invalid attrs %s: %s", attrs, err))
+	}
+	attrsMap, ok := val.(map[string]interface{})
+	if !ok {
+		return InvalidModifierError(fmt.Sprintf("attrs must be a dict: %s", attrs))
+	}
+	for name, value := range attrsMap {
+		if !isModifierName(name) {
+			return InvalidModifierError(fmt.Sprintf("unknown modifier %s in attrs", name))
+		}
+		switch v := value.(type) {
+		case bool:
+			res[name] = v
+			if !v {
+				delete(res, name)
+			}
+		case []interface{}:
+			domain, err := normalizeDomain(model, v)
+			if err != nil {
+				return InvalidModifierError(fmt.Sprintf("invalid %s condition: %s", name, err))
+			}
+			res[name] = domain
+		default:
+			return InvalidModifierError(fmt.Sprintf("%s must be a domain or a boolean in attrs", name))
+		}
+	}
+	return nil
+}
+
+// isModifierName returns true if the given name is a modifier name
+func isModifierName(name string) bool {
+	for _, modifier := range modifierNames {
+		if name == modifier {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain checks the given domain of a modifier of an element of a
+// view of the given model and returns it with JSON field names.
+func normalizeDomain(model *models.Model, domain []interface{}) ([]interface{}, error) {
+	if _, err := models.ParseDomain(domain); err != nil {
+		return nil, err
+	}
+	res := make([]interface{}, len(domain))
+	for i, term := range domain {
+		res[i] = term
+		predicate, ok := term.([]interface{})
+		if !ok {
+			continue
+		}
+		field := predicate[0].(string)
+		if strings.HasPrefix(field, parentPrefix) {
+			continue
+		}
+		if _, ok := model.Fields().Get(field); !ok {
+			return nil, fmt.Errorf("unknown field %s in model %s", field, model.Name())
+		}
+		res[i] = []interface{}{model.JSONizeFieldName(field), predicate[1], predicate[2]}
+	}
+	return res, nil
+}
+
+// processModifiers sets the JSON encoded 'modifiers' attribute of all the
+// elements of the arch of this view that have modifiers. It must be called
+// after embedded views have been extracted.
+func (v *View) processModifiers(model *models.Model) {
+	for _, element := range v.arch.FindElements("//*") {
+		modifiers, err := ParseModifiers(model, element)
+		if err != nil {
+			log.Panic("Invalid modifiers in view", "view", v.ID, "element", startTag(element), "error", err)
+		}
+		if modifiers == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(modifiers)
+		element.CreateAttr("modifiers", strings.TrimSpace(buf.String()))
+	}
+}
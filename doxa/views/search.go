@@ -0,0 +1,142 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"encoding/json"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/tools/exprutils"
+	"github.com/labneco/doxa/doxa/tools/xmlutils"
+)
+
+// A SearchInfo holds the filters of a search view, parsed from its arch
+type SearchInfo struct {
+	// Filters are the filters of the view that apply a domain
+	Filters []SearchFilter `json:"filters"`
+	// GroupBys are the filters of the view that group records by a field
+	GroupBys []SearchFilter `json:"group_bys"`
+}
+
+// A SearchFilter is a predefined search of a search view that
+// the user can toggle. It either applies a domain or groups records.
+type SearchFilter struct {
+	Name   string `json:"name,omitempty"`
+	String string `json:"string"`
+	// Domain is the domain applied when the filter is active
+	Domain string `json:"domain,omitempty"`
+	// GroupBy is the JSON name of the field by which records are grouped when the
+	// filter is active, optionally followed by a group interval (e.g. 'start_date:month').
+	GroupBy string `json:"group_by,omitempty"`
+	// Default is true if the filter is active when the view is opened.
+	// Actions can also activate filters with 'search_default_<name>' context keys.
+	Default bool `json:"default"`
+}
+
+// Defaults returns the context keys that activate the default filters
+// of this search view, in the form 'search_default_<name>'.
+func (si *SearchInfo) Defaults() map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, filters := range [][]SearchFilter{si.Filters, si.GroupBys} {
+		for _, filter := range filters {
+			if filter.Default {
+				res["search_default_"+filter.Name] = 1
+			}
+		}
+	}
+	return res
+}
+
+// processSearch parses and checks the filters of the arch of this search view
+// and sets its Search field. Group by field names are changed to JSON names.
+//
+// It panics if a filter has neither a domain nor a group by, if a group by
+// field is invalid, or if a default filter has no name or a name that is used
+// by another filter.
+func (v *View) processSearch(model *models.Model, fInfos map[string]*models.FieldInfo) {
+	if v.Type != ViewTypeSearch {
+		return
+	}
+	info := SearchInfo{
+		Filters:  []SearchFilter{},
+		GroupBys: []SearchFilter{},
+	}
+	names := make(map[string]bool)
+	for _, element := range v.arch.FindElements("//filter") {
+		if xmlutils.HasParentTag(element, "field") {
+			// Discard filters of fields
+			continue
+		}
+		filter := v.parseSearchFilter(model, fInfos, element)
+		if filter.Name != "" {
+			if names[filter.Name] {
+				log.Panic("Search view filter names must be unique", "view", v.ID, "filter", filter.Name)
+			}
+			names[filter.Name] = true
+		}
+		if filter.GroupBy != "" {
+			info.GroupBys = append(info.GroupBys, filter)
+			continue
+		}
+		info.Filters = append(info.Filters, filter)
+	}
+	v.Search = &info
+}
+
+// parseSearchFilter returns the SearchFilter of the given filter element of
+// this view. Its group by is either given by the group_by attribute or by
+// the 'group_by' key of its context, and is rewritten with JSON names.
+//
+// Domain and context attributes are expressions (see exprutils). Constant
+// contexts are rewritten as JSON objects. Contexts with variables are
+// evaluated by the client, and their group by is not rewritten.
+func (v *View) parseSearchFilter(model *models.Model, fInfos map[string]*models.FieldInfo, element *etree.Element) SearchFilter {
+	filter := SearchFilter{
+		Name:    element.SelectAttrValue("name", ""),
+		String:  element.SelectAttrValue("string", element.SelectAttrValue("name", "")),
+		Domain:  element.SelectAttrValue("domain", ""),
+		GroupBy: element.SelectAttrValue("group_by", ""),
+	}
+	if filter.GroupBy != "" {
+		filter.GroupBy = v.groupByField(model, fInfos, filter.GroupBy, "group_by")
+		element.CreateAttr("group_by", filter.GroupBy)
+	}
+	if filter.Domain != "" {
+		if _, err := exprutils.Compile(filter.Domain); err != nil {
+			log.Panic("Invalid search view filter domain", "view", v.ID, "filter", filter.Name, "domain", filter.Domain, "error", err)
+		}
+	}
+	if ctxAttr := element.SelectAttr("context"); ctxAttr != nil {
+		expr, err := exprutils.Compile(ctxAttr.Value)
+		if err != nil {
+			log.Panic("Invalid search view filter context", "view", v.ID, "filter", filter.Name, "context", ctxAttr.Value, "error", err)
+		}
+		if len(expr.Names()) == 0 {
+			val, err := expr.Eval(nil)
+			ctx, ok := val.(map[string]interface{})
+			if err != nil || !ok {
+				log.Panic("Search view filter context must be a dict", "view", v.ID, "filter", filter.Name, "context", ctxAttr.Value, "error", err)
+			}
+			if groupBy, ok := ctx["group_by"].(string); ok {
+				filter.GroupBy = v.groupByField(model, fInfos, groupBy, "context")
+				ctx["group_by"] = filter.GroupBy
+			}
+			ctxBytes, _ := json.Marshal(ctx)
+			ctxAttr.Value = string(ctxBytes)
+		}
+	}
+	if filter.Domain == "" && filter.GroupBy == "" {
+		log.Panic("Search view filters must have a domain or a group by", "view", v.ID, "filter", xmlutils.ElementToXML(element))
+	}
+	switch element.SelectAttrValue("default", "") {
+	case "", "0", "false":
+	default:
+		if filter.Name == "" {
+			log.Panic("Default search view filters must have a name", "view", v.ID, "filter", xmlutils.ElementToXML(element))
+		}
+		filter.Default = true
+	}
+	return filter
+}
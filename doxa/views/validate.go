@@ -0,0 +1,209 @@
+// Copyright 2017 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package views
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
+)
+
+// StrictValidation defines what happens when invalid views are found at
+// bootstrap. If true, which is the default, BootStrap panics with the list
+// of all errors. Otherwise, errors are logged and invalid views are
+// discarded so that the application can start.
+var StrictValidation = true
+
+// viewTypes are the valid root tags of view archs
+var viewTypes = map[ViewType]bool{
+	ViewTypeTree:     true,
+	ViewTypeList:     true,
+	ViewTypeForm:     true,
+	ViewTypeGraph:    true,
+	ViewTypeCalendar: true,
+	ViewTypeDiagram:  true,
+	ViewTypeGantt:    true,
+	ViewTypeKanban:   true,
+	ViewTypeSearch:   true,
+	ViewTypeQWeb:     true,
+}
+
+// A ValidationError is an error found in the arch of a view
+// when validating it against its model.
+type ValidationError struct {
+	// View is the ID of the invalid view
+	View string
+	// Source is the file and line where the view is defined, if known
+	Source string
+	// Message describes the error
+	Message string
+	// Element is the start tag of the invalid element, if any
+	Element string
+}
+
+// Error returns the error message
+func (ve ValidationError) Error() string {
+	res := fmt.Sprintf("view %s: %s", ve.View, ve.Message)
+	if ve.Element != "" {
+		res = fmt.Sprintf("%s in %s", res, ve.Element)
+	}
+	if ve.Source != "" {
+		res = fmt.Sprintf("%s: %s", ve.Source, res)
+	}
+	return res
+}
+
+// Validate checks the arch of this view against its model and returns the
+// list of errors found. It checks that:
+//   - the model of the view exists and the arch root is a view type,
+//   - the groups of the view exist,
+//   - fields and labels have a name or a target that is a field of the model,
+//     fields of embedded views being checked against the related model,
+//   - buttons have a name, and that the name of 'object' buttons is a method
+//     of the model,
+//   - notebook pages have a string,
+//   - attrs and modifier attributes are valid (see ParseModifiers).
+func (v *View) Validate() []ValidationError {
+	model, ok := models.Registry.Get(v.Model)
+	if !ok {
+		return []ValidationError{v.validationError(nil, "unknown model %s", v.Model)}
+	}
+	if !viewTypes[ViewType(v.arch.Tag)] {
+		return []ValidationError{v.validationError(nil, "unknown view type %s", v.arch.Tag)}
+	}
+	var errs []ValidationError
+	for _, group := range v.Groups {
+		if security.Registry.GetGroup(group) == nil {
+			errs = append(errs, v.validationError(nil, "unknown group %s", group))
+		}
+	}
+	return v.validateElement(model, v.arch, errs)
+}
+
+// validateElement checks the given element of the arch of this view and its
+// descendants against the given model, and appends the errors found to errs.
+func (v *View) validateElement(model *models.Model, element *etree.Element, errs []ValidationError) []ValidationError {
+	if _, err := ParseModifiers(model, element); err != nil {
+		errs = append(errs, v.validationError(element, "%s", err))
+	}
+	switch element.Tag {
+	case "field":
+		name := element.SelectAttrValue("name", "")
+		if name == "" {
+			return append(errs, v.validationError(element, "missing name attribute"))
+		}
+		if _, ok := model.Fields().Get(name); !ok {
+			return append(errs, v.validationError(element, "unknown field %s in model %s", name, model.Name()))
+		}
+		if len(element.ChildElements()) == 0 {
+			return errs
+		}
+		// Children of fields are embedded views of the related model
+		relModel, ok := models.Registry.Get(model.FieldsGet()[model.JSONizeFieldName(name)].Relation)
+		if !ok {
+			return append(errs, v.validationError(element, "embedded view in non relational field %s", name))
+		}
+		for _, child := range element.ChildElements() {
+			errs = v.validateElement(relModel, child, errs)
+		}
+		return errs
+	case "label":
+		target := element.SelectAttrValue("for", "")
+		if target == "" && element.SelectAttr("string") == nil {
+			errs = append(errs, v.validationError(element, "missing for or string attribute"))
+		}
+		if _, ok := model.Fields().Get(target); target != "" && !ok {
+			errs = append(errs, v.validationError(element, "unknown field %s in model %s", target, model.Name()))
+		}
+	case "button":
+		errs = v.validateButton(model, element, errs)
+	case "page":
+		if element.SelectAttrValue("string", "") == "" {
+			errs = append(errs, v.validationError(element, "missing string attribute"))
+		}
+	}
+	for _, child := range element.ChildElements() {
+		errs = v.validateElement(model, child, errs)
+	}
+	return errs
+}
+
+// validateButton checks the given button element of the arch of this view
+// against the given model, and appends the errors found to errs.
+func (v *View) validateButton(model *models.Model, element *etree.Element, errs []ValidationError) []ValidationError {
+	if element.SelectAttr("special") != nil {
+		return errs
+	}
+	name := element.SelectAttrValue("name", "")
+	if name == "" {
+		return append(errs, v.validationError(element, "missing name attribute"))
+	}
+	switch element.SelectAttrValue("type", "") {
+	case "object":
+		for _, method := range model.Methods().Names() {
+			if method == name {
+				return errs
+			}
+		}
+		return append(errs, v.validationError(element, "unknown method %s in model %s", name, model.Name()))
+	case "", "action":
+		return errs
+	default:
+		return append(errs, v.validationError(element, "unknown button type %s", element.SelectAttrValue("type", "")))
+	}
+}
+
+// validationError returns a ValidationError of this view for the given
+// element, which may be nil, with the given formatted message.
+func (v *View) validationError(element *etree.Element, format string, args ...interface{}) ValidationError {
+	res := ValidationError{
+		View:    v.ID,
+		Source:  v.Source,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if element != nil {
+		res.Element = startTag(element)
+	}
+	return res
+}
+
+// startTag returns the XML start tag of the given element
+func startTag(element *etree.Element) string {
+	var attrs []string
+	for _, attr := range element.Attr {
+		attrs = append(attrs, fmt.Sprintf(` %s="%s"`, attr.FullKey(), attr.Value))
+	}
+	return fmt.Sprintf("<%s%s>", element.FullTag(), strings.Join(attrs, ""))
+}
+
+// validateViews validates all the views of this collection. If
+// StrictValidation is true, it panics if a view is invalid. Otherwise,
+// errors are logged and invalid views are removed from the collection.
+func (vc *Collection) validateViews() {
+	var errs []ValidationError
+	for _, v := range vc.views {
+		errs = append(errs, v.Validate()...)
+	}
+	if len(errs) == 0 {
+		return
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].View < errs[j].View
+	})
+	if StrictValidation {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		log.Panic("Invalid views", "errors", msgs)
+	}
+	for _, err := range errs {
+		log.Error("Discarding invalid view", "view", err.View, "source", err.Source, "error", err.Error())
+		vc.remove(err.View)
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"github.com/beevik/etree"
 	"github.com/labneco/doxa/doxa/i18n"
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/fieldtype"
+	"github.com/labneco/doxa/doxa/models/security"
 	"github.com/labneco/doxa/doxa/tools/xmlutils"
 )
 
@@ -183,6 +185,8 @@ type Collection struct {
 	views             map[string]*View
 	orderedViews      map[string][]*View
 	rawInheritedViews []*ViewXML
+	generators        []viewGenerator
+	extensions        []viewExtension
 }
 
 // NewCollection returns a pointer to a new
@@ -216,6 +220,35 @@ func (vc *Collection) Add(v *View) {
 	vc.orderedViews[v.Model] = append(append(vc.orderedViews[v.Model][:index], v), endElems...)
 }
 
+// hasPendingExtensions returns true if there are pure extension views (i.e.
+// without ID) of the view with the given id that have not been applied yet.
+func (vc *Collection) hasPendingExtensions(id string) bool {
+	for _, xmlView := range vc.rawInheritedViews {
+		if xmlView != nil && xmlView.ID == "" && xmlView.InheritID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the view with the given id from this collection
+func (vc *Collection) remove(id string) {
+	vc.Lock()
+	defer vc.Unlock()
+	view, ok := vc.views[id]
+	if !ok {
+		return
+	}
+	delete(vc.views, id)
+	ordered := vc.orderedViews[view.Model]
+	for i, v := range ordered {
+		if v == view {
+			vc.orderedViews[view.Model] = append(ordered[:i:i], ordered[i+1:]...)
+			break
+		}
+	}
+}
+
 // GetByID returns the View with the given id
 func (vc *Collection) GetByID(id string) *View {
 	return vc.views[id]
@@ -243,6 +276,34 @@ func (vc *Collection) GetFirstViewForModel(model string, viewType ViewType) *Vie
 	return vc.defaultViewForModel(model, viewType)
 }
 
+// GetViewForUser returns the view of type viewType for the given model that
+// the user with the given uid should see. This is the view with the lowest
+// priority among the views restricted to groups the user is member of if any,
+// or else among the views without groups, or else a default view.
+func (vc *Collection) GetViewForUser(model string, viewType ViewType, uid int64) *View {
+	var restricted, public *View
+	for _, view := range vc.orderedViews[model] {
+		if view.Type != viewType || !view.IsAllowed(uid) {
+			continue
+		}
+		switch {
+		case len(view.Groups) > 0:
+			if restricted == nil || view.Priority < restricted.Priority {
+				restricted = view
+			}
+		case public == nil || view.Priority < public.Priority:
+			public = view
+		}
+	}
+	switch {
+	case restricted != nil:
+		return restricted
+	case public != nil:
+		return public
+	}
+	return vc.defaultViewForModel(model, viewType)
+}
+
 // defaultViewForModel returns a default view for the given model and type
 func (vc *Collection) defaultViewForModel(model string, viewType ViewType) *View {
 	view := View{
@@ -256,6 +317,17 @@ func (vc *Collection) defaultViewForModel(model string, viewType ViewType) *View
 		view.Fields = []models.FieldNamer{models.FieldName("Name")}
 		view.arch = xmlutils.XMLToElement(fmt.Sprintf(`<%s><field name="Name"/></%s>`, viewType, viewType))
 	}
+	if viewType == ViewTypeKanban {
+		card := `<div class="oe_kanban_global_click"/>`
+		if len(view.Fields) > 0 {
+			card = `<div class="oe_kanban_global_click"><field name="Name"/></div>`
+		}
+		view.arch = xmlutils.XMLToElement(fmt.Sprintf(`<kanban><templates><t t-name="%s">%s</t></templates></kanban>`, KanbanCardTemplate, card))
+		view.Kanban = &KanbanInfo{QuickCreate: true, Templates: []string{KanbanCardTemplate}}
+	}
+	if viewType == ViewTypeSearch {
+		view.Search = &SearchInfo{Filters: []SearchFilter{}, GroupBys: []SearchFilter{}}
+	}
 	view.translateArch()
 	return &view
 }
@@ -274,11 +346,19 @@ func (vc *Collection) GetAllViewsForModel(model string) []*View {
 // LoadFromEtree loads the given view given as Element
 // into this collection.
 func (vc *Collection) LoadFromEtree(element *etree.Element) {
+	vc.LoadFromEtreeWithSource(element, "")
+}
+
+// LoadFromEtreeWithSource loads the given view given as Element into this
+// collection. source is the location of the view definition, such as
+// 'file.xml:12', that is reported when the view is invalid.
+func (vc *Collection) LoadFromEtreeWithSource(element *etree.Element, source string) {
 	xmlBytes := []byte(xmlutils.ElementToXML(element))
 	var viewXML ViewXML
 	if err := xml.Unmarshal(xmlBytes, &viewXML); err != nil {
 		log.Panic("Unable to unmarshal element", "error", err, "bytes", string(xmlBytes))
 	}
+	viewXML.Source = source
 	if viewXML.InheritID != "" {
 		// Update an existing view.
 		// Put in raw inherited view for now, as the base view may not exist yet.
@@ -308,6 +388,8 @@ func (vc *Collection) createNewViewFromXML(viewXML *ViewXML) {
 		Priority:    priority,
 		arch:        arch,
 		FieldParent: viewXML.FieldParent,
+		Groups:      splitGroups(viewXML.Groups),
+		Source:      viewXML.Source,
 		SubViews:    make(map[string]SubViews),
 		arches:      make(map[string]*etree.Element),
 	}
@@ -325,7 +407,46 @@ type View struct {
 	FieldParent string
 	Fields      []models.FieldNamer
 	SubViews    map[string]SubViews
-	arches      map[string]*etree.Element
+	// Kanban holds the settings of kanban views. It is nil for other view types.
+	Kanban *KanbanInfo
+	// Calendar holds the settings of calendar views. It is nil for other view types.
+	Calendar *CalendarInfo
+	// Gantt holds the settings of gantt views. It is nil for other view types.
+	Gantt *GanttInfo
+	// Search holds the filters of search views. It is nil for other view types.
+	Search *SearchInfo
+	// Groups are the ids of the groups allowed to access this view.
+	// All users can access it if empty.
+	Groups []string
+	// Source is the location of the view definition, if known
+	Source string
+	arches map[string]*etree.Element
+}
+
+// IsAllowed returns true if the user with the given uid can access this view,
+// that is if the view has no groups or if the user is member of one of them.
+// The super user can access all views.
+func (v *View) IsAllowed(uid int64) bool {
+	if len(v.Groups) == 0 || uid == security.SuperUserID {
+		return true
+	}
+	for _, groupID := range v.Groups {
+		if group := security.Registry.GetGroup(groupID); group != nil && security.Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitGroups returns the group ids of the given comma separated list
+func splitGroups(groups string) []string {
+	var res []string
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			res = append(res, group)
+		}
+	}
+	return res
 }
 
 // A SubViews is a holder for embedded views of a field
@@ -339,6 +460,89 @@ func (v *View) populateFieldNames() {
 	}
 }
 
+// addField adds the field with the given JSON name to the
+// fields of this view if it is not already present.
+func (v *View) addField(jsonName string) {
+	for _, f := range v.Fields {
+		if string(f.FieldName()) == jsonName {
+			return
+		}
+	}
+	v.Fields = append(v.Fields, models.FieldName(jsonName))
+}
+
+// archField returns the FieldInfo and the JSON name of the field with the
+// given name, referenced by the given attribute of the arch of this view.
+// It panics if the field does not exist.
+func (v *View) archField(model *models.Model, fInfos map[string]*models.FieldInfo, name, attr string) (*models.FieldInfo, string) {
+	if _, ok := model.Fields().Get(name); !ok {
+		log.Panic("Unknown field in view", "view", v.ID, "model", v.Model, "attribute", attr, "field", name)
+	}
+	jsonName := model.JSONizeFieldName(name)
+	return fInfos[jsonName], jsonName
+}
+
+// fieldAttr returns the JSON name of the field given by the attribute with the
+// given name of the root element of the arch of this view, or an empty string
+// if the attribute is not set. The attribute is rewritten with the JSON name
+// and the field is added to the fields of this view.
+//
+// It panics if the field does not exist or if types are given and the field
+// is not of one of them.
+func (v *View) fieldAttr(model *models.Model, fInfos map[string]*models.FieldInfo, attr string, types ...fieldtype.Type) string {
+	fieldAttr := v.arch.SelectAttr(attr)
+	if fieldAttr == nil {
+		return ""
+	}
+	fInfo, jsonName := v.archField(model, fInfos, fieldAttr.Value, attr)
+	if len(types) > 0 && !fieldTypeIn(fInfo.Type, types) {
+		log.Panic("Invalid type of field in view", "view", v.ID, "attribute", attr, "field", fieldAttr.Value, "type", fInfo.Type, "expected", types)
+	}
+	fieldAttr.Value = jsonName
+	v.addField(jsonName)
+	return jsonName
+}
+
+// fieldTypeIn returns true if typ is one of the given types
+func fieldTypeIn(typ fieldtype.Type, types []fieldtype.Type) bool {
+	for _, t := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// groupByField returns the JSON name of the field with the given name, given
+// by the given attribute of the arch of this view to group its records, and
+// adds the field to the fields of this view. Date and datetime fields can be
+// suffixed with a group interval, as in 'StartDate:month', which is kept in
+// the returned name.
+//
+// It panics if the records cannot be grouped by this field.
+func (v *View) groupByField(model *models.Model, fInfos map[string]*models.FieldInfo, name, attr string) string {
+	field, interval := name, ""
+	if i := strings.Index(name, models.GroupIntervalSep); i >= 0 {
+		field, interval = name[:i], name[i+1:]
+	}
+	fInfo, jsonName := v.archField(model, fInfos, field, attr)
+	switch {
+	case fInfo.Type.Is2ManyRelationType(), fInfo.Type == fieldtype.Rev2One, fInfo.Type == fieldtype.Binary,
+		fInfo.Type == fieldtype.Text, fInfo.Type == fieldtype.HTML:
+		log.Panic("Views cannot be grouped by this type of field", "view", v.ID, "field", name, "type", fInfo.Type)
+	case !fInfo.Store:
+		log.Panic("Views cannot be grouped by a non stored field", "view", v.ID, "field", name)
+	}
+	v.addField(jsonName)
+	if interval == "" {
+		return jsonName
+	}
+	if !models.IsGroupInterval(interval) || !fieldTypeIn(fInfo.Type, dateFieldTypes) {
+		log.Panic("Invalid group by interval", "view", v.ID, "field", name, "type", fInfo.Type)
+	}
+	return jsonName + models.GroupIntervalSep + interval
+}
+
 // Arch returns the arch XML string of this view for the given language.
 // Call with empty string to get the default language's arch
 func (v *View) Arch(lang string) *etree.Element {
@@ -398,7 +602,12 @@ func (v *View) postProcess() {
 	v.setViewType()
 	v.extractSubViews(model, fInfos)
 	v.updateFieldNames(model)
+	v.processModifiers(model)
 	v.populateFieldNames()
+	v.processKanban(model, fInfos)
+	v.processCalendar(model, fInfos)
+	v.processGantt(model, fInfos)
+	v.processSearch(model, fInfos)
 	v.AddOnchanges(fInfos)
 	v.SanitizeSearchView()
 	v.translateArch()
@@ -478,18 +687,18 @@ func (v *View) translateArch() {
 // updateViewFromXML updates this view with the given XML
 // viewXML must have an InheritID
 func (v *View) updateViewFromXML(viewXML *ViewXML) {
-	baseElem := xmlutils.CopyElement(v.arch)
+	// root is the document of the copied arch, so that the root node can be replaced
+	root := xmlutils.CopyElement(v.arch).Parent()
 	specDoc := etree.NewDocument()
 	if err := specDoc.ReadFromString(viewXML.Arch); err != nil {
 		log.Panic("Unable to read inheritance specs", "error", err, "arch", viewXML.Arch)
 	}
 	for _, spec := range specDoc.ChildElements() {
 		xpath := getInheritXPathFromSpec(spec)
-		nodeToModify := baseElem.Parent().FindElement(xpath)
+		nodeToModify := root.FindElement(xpath)
 		if nodeToModify == nil {
 			log.Panic("Node not found in parent view", "xpath", xpath, "spec", xmlutils.ElementToXML(spec), "view", v.ID, "arch", v.arch)
 		}
-		nextNode := xmlutils.FindNextSibling(nodeToModify)
 		modifyAction := spec.SelectAttr("position")
 		if modifyAction == nil {
 			log.Panic("Spec should include 'position' attribute", "xpath", xpath, "spec", xmlutils.ElementToXML(spec), "view", v.ID)
@@ -500,11 +709,18 @@ func (v *View) updateViewFromXML(viewXML *ViewXML) {
 				nodeToModify.Parent().InsertChild(nodeToModify, node)
 			}
 		case "after":
+			nextNode := xmlutils.FindNextSibling(nodeToModify)
 			for _, node := range spec.ChildElements() {
+				if nextNode == nil {
+					// nodeToModify is the last child
+					nodeToModify.Parent().AddChild(node)
+					continue
+				}
 				nodeToModify.Parent().InsertChild(nextNode, node)
 			}
 		case "replace":
 			for _, node := range spec.ChildElements() {
+				insertOriginalNode(node, nodeToModify)
 				nodeToModify.Parent().InsertChild(nodeToModify, node)
 			}
 			nodeToModify.Parent().RemoveChild(nodeToModify)
@@ -514,13 +730,36 @@ func (v *View) updateViewFromXML(viewXML *ViewXML) {
 			}
 		case "attributes":
 			for _, node := range spec.FindElements("./attribute") {
-				attrName := node.SelectAttr("name").Value
+				attrName := node.SelectAttrValue("name", "")
+				if attrName == "" {
+					log.Panic("Attribute spec should include 'name' attribute", "xpath", xpath, "view", v.ID)
+				}
 				nodeToModify.RemoveAttr(attrName)
-				nodeToModify.CreateAttr(attrName, node.Text())
+				if value := strings.TrimSpace(node.Text()); value != "" {
+					// An empty value removes the attribute
+					nodeToModify.CreateAttr(attrName, value)
+				}
 			}
+		default:
+			log.Panic("Unknown position in view inherit spec", "position", modifyAction.Value, "xpath", xpath, "view", v.ID)
 		}
 	}
-	v.arch = baseElem
+	if len(root.ChildElements()) != 1 {
+		log.Panic("Inheritance specs must keep a single root node", "view", v.ID, "inherit", viewXML.ID)
+	}
+	v.arch = root.ChildElements()[0]
+}
+
+// insertOriginalNode replaces the '$0' text of the given node and of its
+// descendants by a copy of the original node replaced by an inheritance spec.
+func insertOriginalNode(node, original *etree.Element) {
+	if strings.TrimSpace(node.Text()) == "$0" {
+		node.SetText("")
+		node.AddChild(original.Copy())
+	}
+	for _, child := range node.ChildElements() {
+		insertOriginalNode(child, original)
+	}
 }
 
 // A TranslatableAttribute is a reference to an attribute in a
@@ -556,6 +795,8 @@ type ViewXML struct {
 	Arch        string `xml:",innerxml"`
 	InheritID   string `xml:"inherit_id,attr"`
 	FieldParent string `xml:"field_parent,attr"`
+	Groups      string `xml:"groups,attr"`
+	Source      string `xml:"-"`
 }
 
 // LoadFromEtree reads the view given etree.Element, creates or updates the view
@@ -564,12 +805,23 @@ func LoadFromEtree(element *etree.Element) {
 	Registry.LoadFromEtree(element)
 }
 
+// LoadFromEtreeWithSource is the same as LoadFromEtree, but also sets the
+// location of the view definition, such as 'file.xml:12', that is reported
+// when the view is invalid.
+func LoadFromEtreeWithSource(element *etree.Element, source string) {
+	Registry.LoadFromEtreeWithSource(element, source)
+}
+
 // getInheritXPathFromSpec returns an XPath string that is suitable for
 // searching the base view and find the node to modify.
 func getInheritXPathFromSpec(spec *etree.Element) string {
 	if spec.Tag == "xpath" {
 		// We have an xpath expression, we take it
-		return spec.SelectAttr("expr").Value
+		expr := spec.SelectAttrValue("expr", "")
+		if expr == "" {
+			log.Panic("XPath inherit spec should include 'expr' attribute", "spec", xmlutils.ElementToXML(spec))
+		}
+		return expr
 	}
 	if len(spec.Attr) < 1 || len(spec.Attr) > 2 {
 		log.Panic("Invalid view inherit spec", "spec", xmlutils.ElementToXML(spec))
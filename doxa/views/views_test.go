@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/labneco/doxa/doxa/models"
+	"github.com/labneco/doxa/doxa/models/security"
 	"github.com/labneco/doxa/doxa/tools/xmlutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -156,7 +157,294 @@ var viewDef10 = `
 </view>
 `
 
+var viewDef11 = `
+<view inherit_id="new_base_view" id="newer_view">
+	<field name="Fax" position="attributes">
+		<attribute name="string">Fax Number</attribute>
+	</field>
+</view>
+`
+
+var viewDef12 = `
+<view inherit_id="new_base_view">
+	<field name="Fax" position="attributes">
+		<attribute name="widget"></attribute>
+	</field>
+</view>
+`
+
+var viewDef14 = `
+<view inherit_id="my_id">
+	<xpath expr="//group" position="replace">
+		<sheet>$0</sheet>
+	</xpath>
+</view>
+`
+
+var viewDef13 = `
+<view inherit_id="my_id">
+	<xpath expr="//group" position="around">
+		<separator/>
+	</xpath>
+</view>
+`
+
+var viewDef15 = `
+<view id="user_kanban" model="User">
+	<kanban default_group_by="Age" quick_create="false">
+		<field name="UserName"/>
+		<progressbar field="UserName" colors='{"admin": "danger", "demo": "success"}' sum_field="Age"/>
+		<templates>
+			<t t-name="kanban-box">
+				<div class="oe_kanban_global_click">
+					<field name="UserName"/>
+					<field name="Categories"/>
+				</div>
+			</t>
+			<t t-name="kanban-tooltip">
+				<field name="Age"/>
+			</t>
+		</templates>
+	</kanban>
+</view>
+`
+
+var viewDef16 = `
+<view id="user_kanban_no_card" model="User">
+	<kanban>
+		<templates>
+			<t t-name="kanban-tooltip">
+				<field name="UserName"/>
+			</t>
+		</templates>
+	</kanban>
+</view>
+`
+
+var viewDef17 = `
+<view id="user_kanban_bad_group" model="User">
+	<kanban default_group_by="Groups">
+		<templates>
+			<t t-name="kanban-box"><field name="UserName"/></t>
+		</templates>
+	</kanban>
+</view>
+`
+
+var viewDef18 = `
+<view id="user_kanban_bad_color" model="User">
+	<kanban>
+		<progressbar field="UserName" colors='{"admin": "red"}'/>
+		<templates>
+			<t t-name="kanban-box"><field name="UserName"/></t>
+		</templates>
+	</kanban>
+</view>
+`
+
+var viewDef19 = `
+<view id="user_kanban_unknown_field" model="User">
+	<kanban default_group_by="Unknown">
+		<templates>
+			<t t-name="kanban-box"><field name="UserName"/></t>
+		</templates>
+	</kanban>
+</view>
+`
+
+var viewDef20 = `
+<view id="user_calendar" model="User">
+	<calendar date_start="StartDate" date_stop="StopDate" color="Age" all_day="AllDay" mode="month" quick_add="false">
+		<field name="UserName"/>
+	</calendar>
+</view>
+`
+
+var viewDef21 = `
+<view id="user_gantt" model="User">
+	<gantt date_start="StartDate" date_delay="Age" progress="Progress" default_group_by="UserName" scales="week, month" default_scale="week">
+		<field name="UserName"/>
+	</gantt>
+</view>
+`
+
+var viewDef22 = `
+<view id="user_calendar_no_start" model="User">
+	<calendar date_stop="StopDate">
+		<field name="UserName"/>
+	</calendar>
+</view>
+`
+
+var viewDef23 = `
+<view id="user_calendar_bad_start" model="User">
+	<calendar date_start="UserName"/>
+</view>
+`
+
+var viewDef24 = `
+<view id="user_calendar_bad_mode" model="User">
+	<calendar date_start="StartDate" mode="decade"/>
+</view>
+`
+
+var viewDef25 = `
+<view id="user_gantt_bad_scale" model="User">
+	<gantt date_start="StartDate" scales="week,decade"/>
+</view>
+`
+
+var viewDef26 = `
+<view id="user_gantt_bad_default_scale" model="User">
+	<gantt date_start="StartDate" scales="week,month" default_scale="day"/>
+</view>
+`
+
+var viewDef27 = `
+<view id="user_gantt_bad_progress" model="User">
+	<gantt date_start="StartDate" progress="AllDay"/>
+</view>
+`
+
+var viewDef28 = `
+<view id="user_search_filters" model="User">
+	<search>
+		<field name="UserName"/>
+		<filter name="adults" string="Adults" domain='[["age", ">=", 18]]' default="1"/>
+		<filter string="No Name" domain='[["user_name", "=", false]]'/>
+		<group string="Group By">
+			<filter name="by_age" string="Age" group_by="Age"/>
+			<filter name="by_start_month" string="Start Month" context='{"group_by": "StartDate:month"}'/>
+		</group>
+	</search>
+</view>
+`
+
+var viewDef29 = `
+<view id="user_search_empty_filter" model="User">
+	<search>
+		<filter name="empty" string="Empty"/>
+	</search>
+</view>
+`
+
+var viewDef30 = `
+<view id="user_search_bad_group_by" model="User">
+	<search>
+		<filter name="by_categories" string="Categories" group_by="Categories"/>
+	</search>
+</view>
+`
+
+var viewDef31 = `
+<view id="user_search_duplicate" model="User">
+	<search>
+		<filter name="adults" string="Adults" domain='[["age", ">=", 18]]'/>
+		<filter name="adults" string="Grown-ups" domain='[["age", ">=", 21]]'/>
+	</search>
+</view>
+`
+
+var viewDef32 = `
+<view id="user_search_bad_interval" model="User">
+	<search>
+		<filter name="by_age_month" string="Age" group_by="Age:month"/>
+	</search>
+</view>
+`
+
+var viewDef33 = `
+<view id="user_search_unnamed_default" model="User">
+	<search>
+		<filter string="Adults" domain='[["age", ">=", 18]]' default="1"/>
+	</search>
+</view>
+`
+
+var viewDef34 = `
+<view id="user_form_valid" model="User">
+	<form>
+		<header>
+			<button name="OnChangeAge" type="object" string="Check Age"/>
+			<button name="some_action" type="action" string="Open"/>
+			<button special="cancel" string="Cancel"/>
+		</header>
+		<notebook>
+			<page string="Groups">
+				<field name="Groups">
+					<tree>
+						<field name="Name"/>
+						<field name="active"/>
+					</tree>
+				</field>
+			</page>
+		</notebook>
+		<label string="Age"/>
+		<field name="age"/>
+	</form>
+</view>
+`
+
+var viewDef36 = `
+<view id="user_form_modifiers" model="User">
+	<form>
+		<field name="UserName" required="1" attrs="{'readonly': [('AllDay', '=', True)]}"/>
+		<field name="Age" attrs="{'invisible': ['|', ('Progress', '>', 50), ('StartDate', '=', False)], 'required': False}"/>
+		<field name="Groups">
+			<tree>
+				<field name="Name" attrs="{'readonly': [('parent.AllDay', '=', True)], 'column_invisible': True}"/>
+				<field name="Active" invisible="1"/>
+			</tree>
+		</field>
+		<button name="OnChangeAge" type="object" string="Check" attrs="{'invisible': [('age', '&lt;', 18)]}"/>
+	</form>
+</view>
+`
+
+var viewDef35 = `
+<view id="user_form_invalid" model="User">
+	<form>
+		<header>
+			<button name="UnknownMethod" type="object" string="Check"/>
+			<button type="object" string="No Name"/>
+			<button name="OnChangeAge" type="workflow" string="Bad Type"/>
+		</header>
+		<field name="UnknownField"/>
+		<field string="No Name"/>
+		<label for="UnknownLabel"/>
+		<notebook>
+			<page>
+				<field name="Groups">
+					<tree>
+						<field name="UserName"/>
+					</tree>
+				</field>
+			</page>
+		</notebook>
+	</form>
+</view>
+`
+
+var viewDef37 = `
+<view id="user_search_expressions" model="User">
+	<search>
+		<filter name="mine" string="Mine" domain="[('user_name', '=', uid)]" context="{'default_user_id': uid}"/>
+		<filter name="by_start_month" string="Start Month" context="{'group_by': 'StartDate:month', 'active_test': False}"/>
+	</search>
+</view>
+`
+
+var viewDef38 = `
+<view id="user_search_bad_domain" model="User">
+	<search>
+		<filter name="bad" string="Bad" domain="[('user_name', '=', "/>
+	</search>
+</view>
+`
+
 func TestViews(t *testing.T) {
+	managers := security.Registry.NewGroup("view_test_managers", "View Managers")
+	security.Registry.AddMembership(2, managers)
 	Convey("Creating View 1", t, func() {
 		LoadFromEtree(xmlutils.XMLToElement(viewDef1))
 		So(len(Registry.views), ShouldEqual, 1)
@@ -227,6 +515,10 @@ func TestViews(t *testing.T) {
 			"Groups":   models.Many2ManyField{RelationModel: models.Registry.MustGet("Group")},
 			"Categories": models.Many2ManyField{RelationModel: models.Registry.MustGet("Category"),
 				JSON: "category_ids"},
+			"StartDate": models.DateTimeField{},
+			"StopDate":  models.DateTimeField{},
+			"AllDay":    models.BooleanField{},
+			"Progress":  models.FloatField{},
 		})
 		partner.AddFields(map[string]models.FieldDefinition{
 			"Name":        models.CharField{},
@@ -432,7 +724,7 @@ func TestViews(t *testing.T) {
 		So(view.ID, ShouldEqual, "embedded_form")
 		So(xmlutils.ElementToXML(view.Arch("")), ShouldEqual,
 			`<form>
-	<field required="1" name="user_name"/>
+	<field required="1" name="user_name" modifiers="{&quot;required&quot;:true}"/>
 	<field name="age" on_change="1"/>
 	<field name="category_ids"/>
 	<field name="groups"/>
@@ -447,7 +739,7 @@ func TestViews(t *testing.T) {
 		So(viewCategoriesForm.ID, ShouldEqual, "embedded_form_childview_Categories_1")
 		So(xmlutils.ElementToXML(viewCategoriesForm.Arch("")), ShouldEqual, `<form>
 	<h1>This is my form</h1>
-	<field readonly="1" name="name"/>
+	<field readonly="1" name="name" modifiers="{&quot;readonly&quot;:true}"/>
 	<field name="color"/>
 	<field name="sequence"/>
 </form>
@@ -699,5 +991,417 @@ func TestViews(t *testing.T) {
 </search>
 `)
 	})
+	Convey("Named extensions should inherit the final arch of their base view", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef2))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef3))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef8))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef11))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef9))
+		BootStrap()
+		So(len(Registry.views), ShouldEqual, 4)
+		arch := xmlutils.ElementToXML(Registry.GetByID("newer_view").Arch(""))
+		So(arch, ShouldContainSubstring, `widget="phone"`)
+		So(arch, ShouldContainSubstring, `string="Fax Number"`)
+		So(xmlutils.ElementToXML(Registry.GetByID("new_base_view").Arch("")), ShouldNotContainSubstring, `Fax Number`)
+	})
+	Convey("Empty attribute specs should remove the attribute", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef2))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef3))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef8))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef9))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef12))
+		BootStrap()
+		arch := xmlutils.ElementToXML(Registry.GetByID("new_base_view").Arch(""))
+		So(arch, ShouldContainSubstring, `<field name="fax"/>`)
+	})
+	Convey("Replaced nodes can be wrapped with $0", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+		LoadFromEtree(xmlutils.XMLToElement(viewDef14))
+		BootStrap()
+		So(xmlutils.ElementToXML(Registry.GetByID("my_id").Arch("")), ShouldEqual,
+			`<form>
+	<sheet>
+		<group>
+			<field name="user_name"/>
+			<label for="age"/>
+			<field name="age" on_change="1"/>
+		</group>
+	</sheet>
+</form>
+`)
+	})
+	Convey("Kanban views should be parsed at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef15))
+		BootStrap()
+		view := Registry.GetByID("user_kanban")
+		So(view.Type, ShouldEqual, ViewTypeKanban)
+		So(view.Kanban, ShouldNotBeNil)
+		So(view.Kanban.DefaultGroupBy, ShouldEqual, "age")
+		So(view.Kanban.QuickCreate, ShouldBeFalse)
+		So(view.Kanban.Templates, ShouldResemble, []string{"kanban-box", "kanban-tooltip"})
+		So(view.Kanban.ProgressBar, ShouldResemble, &KanbanProgressBar{
+			Field:    "user_name",
+			Colors:   map[string]string{"admin": "danger", "demo": "success"},
+			SumField: "age",
+		})
+		So(view.Fields, ShouldHaveLength, 4)
+		So(view.Fields, ShouldContain, models.FieldName("age"))
+		arch := xmlutils.ElementToXML(view.Arch(""))
+		So(arch, ShouldContainSubstring, `<kanban default_group_by="age" quick_create="false">`)
+		So(arch, ShouldContainSubstring, `<progressbar field="user_name" colors="{&quot;admin&quot;: &quot;danger&quot;, &quot;demo&quot;: &quot;success&quot;}" sum_field="age"/>`)
+		So(Registry.GetByID("my_id"), ShouldBeNil)
+		Convey("Other views should have no kanban settings", func() {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+			BootStrap()
+			So(Registry.GetByID("my_id").Kanban, ShouldBeNil)
+		})
+		Convey("Default kanban views should have a card template", func() {
+			view := Registry.GetFirstViewForModel("Partner", ViewTypeKanban)
+			So(view.Kanban, ShouldNotBeNil)
+			So(xmlutils.ElementToXML(view.Arch("")), ShouldContainSubstring, `<t t-name="kanban-box">`)
+		})
+	})
+	Convey("Invalid kanban views should panic at bootstrap", t, func() {
+		for _, def := range []string{viewDef16, viewDef17, viewDef18, viewDef19} {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(def))
+			So(BootStrap, ShouldPanic)
+		}
+	})
+	Convey("Calendar views should be parsed at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef20))
+		BootStrap()
+		view := Registry.GetByID("user_calendar")
+		So(view.Type, ShouldEqual, ViewTypeCalendar)
+		So(view.Gantt, ShouldBeNil)
+		So(view.Calendar, ShouldResemble, &CalendarInfo{
+			DateStart: "start_date",
+			DateStop:  "stop_date",
+			Color:     "age",
+			AllDay:    "all_day",
+			Mode:      "month",
+			QuickAdd:  false,
+		})
+		So(view.Fields, ShouldHaveLength, 5)
+		So(view.Fields, ShouldContain, models.FieldName("start_date"))
+		So(view.Fields, ShouldContain, models.FieldName("all_day"))
+		So(xmlutils.ElementToXML(view.Arch("")), ShouldContainSubstring,
+			`<calendar date_start="start_date" date_stop="stop_date" color="age" all_day="all_day" mode="month" quick_add="false">`)
+	})
+	Convey("Gantt views should be parsed at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef21))
+		BootStrap()
+		view := Registry.GetByID("user_gantt")
+		So(view.Type, ShouldEqual, ViewTypeGantt)
+		So(view.Calendar, ShouldBeNil)
+		So(view.Gantt, ShouldResemble, &GanttInfo{
+			DateStart:      "start_date",
+			DateDelay:      "age",
+			Progress:       "progress",
+			DefaultGroupBy: "user_name",
+			Scales:         []string{"week", "month"},
+			DefaultScale:   "week",
+		})
+		So(view.Fields, ShouldHaveLength, 4)
+		So(xmlutils.ElementToXML(view.Arch("")), ShouldContainSubstring,
+			`<gantt date_start="start_date" date_delay="age" progress="progress" default_group_by="user_name"`)
+		Convey("Gantt views without scales should have all scales", func() {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(`<view id="user_gantt_default" model="User"><gantt date_start="StartDate"/></view>`))
+			BootStrap()
+			gantt := Registry.GetByID("user_gantt_default").Gantt
+			So(gantt.Scales, ShouldResemble, []string{"day", "week", "month", "year"})
+			So(gantt.DefaultScale, ShouldEqual, "month")
+		})
+	})
+	Convey("Invalid calendar and gantt views should panic at bootstrap", t, func() {
+		for _, def := range []string{viewDef22, viewDef23, viewDef24, viewDef25, viewDef26, viewDef27} {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(def))
+			So(BootStrap, ShouldPanic)
+		}
+	})
+	Convey("Search view filters should be parsed at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef28))
+		BootStrap()
+		view := Registry.GetByID("user_search_filters")
+		So(view.Search, ShouldNotBeNil)
+		So(view.Search.Filters, ShouldResemble, []SearchFilter{
+			{Name: "adults", String: "Adults", Domain: `[["age", ">=", 18]]`, Default: true},
+			{String: "No Name", Domain: `[["user_name", "=", false]]`},
+		})
+		So(view.Search.GroupBys, ShouldResemble, []SearchFilter{
+			{Name: "by_age", String: "Age", GroupBy: "age"},
+			{Name: "by_start_month", String: "Start Month", GroupBy: "start_date:month"},
+		})
+		So(view.Search.Defaults(), ShouldResemble, map[string]interface{}{"search_default_adults": 1})
+		So(view.Fields, ShouldContain, models.FieldName("start_date"))
+		arch := xmlutils.ElementToXML(view.Arch(""))
+		So(arch, ShouldContainSubstring, `<filter name="by_age" string="Age" group_by="age"/>`)
+		So(arch, ShouldContainSubstring, `context="{&quot;group_by&quot;:&quot;start_date:month&quot;}"`)
+		Convey("Default search views should have no filters", func() {
+			search := Registry.GetFirstViewForModel("Partner", ViewTypeSearch).Search
+			So(search.Filters, ShouldBeEmpty)
+			So(search.GroupBys, ShouldBeEmpty)
+		})
+	})
+	Convey("Search view filter expressions should be parsed at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef37))
+		BootStrap()
+		view := Registry.GetByID("user_search_expressions")
+		So(view.Search.Filters, ShouldResemble, []SearchFilter{
+			{Name: "mine", String: "Mine", Domain: "[('user_name', '=', uid)]"},
+		})
+		So(view.Search.GroupBys, ShouldResemble, []SearchFilter{
+			{Name: "by_start_month", String: "Start Month", GroupBy: "start_date:month"},
+		})
+		arch := xmlutils.ElementToXML(view.Arch(""))
+		So(arch, ShouldContainSubstring, `context="{&apos;default_user_id&apos;: uid}"`)
+		So(arch, ShouldContainSubstring, `context="{&quot;active_test&quot;:false,&quot;group_by&quot;:&quot;start_date:month&quot;}"`)
+	})
+	Convey("Invalid search view filters should panic at bootstrap", t, func() {
+		for _, def := range []string{viewDef29, viewDef30, viewDef31, viewDef32, viewDef33, viewDef38} {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(def))
+			So(BootStrap, ShouldPanic)
+		}
+	})
+	Convey("Views should be validated against their model at bootstrap", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef34))
+		LoadFromEtreeWithSource(xmlutils.XMLToElement(viewDef35), "test/views.xml:12")
+		LoadFromEtree(xmlutils.XMLToElement(`<view id="unknown_model" model="Unknown"><form/></view>`))
+		LoadFromEtree(xmlutils.XMLToElement(`<view id="unknown_type" model="User"><sheet/></view>`))
+		Convey("Valid views should have no errors", func() {
+			So(Registry.GetByID("user_form_valid").Validate(), ShouldBeEmpty)
+		})
+		Convey("All errors of invalid views should be reported with their source", func() {
+			errs := Registry.GetByID("user_form_invalid").Validate()
+			So(errs, ShouldHaveLength, 8)
+			So(errs[0], ShouldResemble, ValidationError{
+				View:    "user_form_invalid",
+				Source:  "test/views.xml:12",
+				Message: "unknown method UnknownMethod in model User",
+				Element: `<button name="UnknownMethod" type="object" string="Check">`,
+			})
+			So(errs[0].Error(), ShouldEqual, `test/views.xml:12: view user_form_invalid: unknown method UnknownMethod in model User in <button name="UnknownMethod" type="object" string="Check">`)
+			So(errs[1].Message, ShouldEqual, "missing name attribute")
+			So(errs[2].Message, ShouldEqual, "unknown button type workflow")
+			So(errs[3].Message, ShouldEqual, "unknown field UnknownField in model User")
+			So(errs[4].Message, ShouldEqual, "missing name attribute")
+			So(errs[5].Message, ShouldEqual, "unknown field UnknownLabel in model User")
+			So(errs[6].Message, ShouldEqual, "missing string attribute")
+			So(errs[7].Message, ShouldEqual, "unknown field UserName in model Group")
+			So(Registry.GetByID("unknown_model").Validate()[0].Message, ShouldEqual, "unknown model Unknown")
+			So(Registry.GetByID("unknown_type").Validate()[0].Message, ShouldEqual, "unknown view type sheet")
+		})
+		Convey("Fields of embedded views should be checked against the related model", func() {
+			LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_embedded" model="User">
+	<form><field name="Groups"><tree><field name="UserName"/></tree></field></form>
+</view>`))
+			errs := Registry.GetByID("user_form_embedded").Validate()
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Message, ShouldEqual, "unknown field UserName in model Group")
+		})
+		Convey("Invalid views should panic in strict mode", func() {
+			So(BootStrap, ShouldPanic)
+		})
+		Convey("Invalid views should be discarded in non strict mode", func() {
+			StrictValidation = false
+			defer func() { StrictValidation = true }()
+			BootStrap()
+			So(Registry.GetByID("user_form_valid"), ShouldNotBeNil)
+			So(Registry.GetByID("user_form_invalid"), ShouldBeNil)
+			So(Registry.GetByID("unknown_model"), ShouldBeNil)
+			So(Registry.GetByID("unknown_type"), ShouldBeNil)
+			So(Registry.GetFirstViewForModel("User", ViewTypeForm).ID, ShouldEqual, "user_form_valid")
+		})
+	})
+	Convey("Modifiers of view elements should be parsed and normalized", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(viewDef36))
+		So(Registry.GetByID("user_form_modifiers").Validate(), ShouldBeEmpty)
+		Convey("Modifiers should be parsed from attributes and attrs", func() {
+			model := models.Registry.MustGet("User")
+			element := xmlutils.XMLToElement(`<field name="UserName" invisible="True" attrs="{'readonly': [('Age', '>=', 18), ('AllDay', '!=', True)]}"/>`)
+			modifiers, err := ParseModifiers(model, element)
+			So(err, ShouldBeNil)
+			So(modifiers, ShouldResemble, Modifiers{
+				"invisible": true,
+				"readonly": []interface{}{
+					[]interface{}{"age", ">=", int64(18)},
+					[]interface{}{"all_day", "!=", true},
+				},
+			})
+			modifiers, err = ParseModifiers(model, xmlutils.XMLToElement(`<field name="Age" readonly="0"/>`))
+			So(err, ShouldBeNil)
+			So(modifiers, ShouldBeNil)
+		})
+		Convey("Invalid modifiers should return an error", func() {
+			model := models.Registry.MustGet("User")
+			for _, def := range []string{
+				`<field name="Age" invisible="maybe"/>`,
+				`<field name="Age" attrs="{'invisible': [('Age', '=', 1)"/>`,
+				`<field name="Age" attrs="[('Age', '=', 1)]"/>`,
+				`<field name="Age" attrs="{'invisible': [('Age', '=', uid)]}"/>`,
+				`<field name="Age" attrs="{'hidden': [('Age', '=', 1)]}"/>`,
+				`<field name="Age" attrs="{'invisible': 'yes'}"/>`,
+				`<field name="Age" attrs="{'invisible': [('Age', 'is', 1)]}"/>`,
+				`<field name="Age" attrs="{'invisible': ['|', ('Age', '=', 1)]}"/>`,
+			} {
+				_, err := ParseModifiers(model, xmlutils.XMLToElement(def))
+				So(err, ShouldHaveSameTypeAs, InvalidModifierError(""))
+			}
+			_, err := ParseModifiers(model, xmlutils.XMLToElement(`<field name="Age" attrs="{'invisible': [('Agee', '=', 1)]}"/>`))
+			So(err.Error(), ShouldEqual, "invalid invisible condition: unknown field Agee in model User")
+		})
+		Convey("Invalid modifiers should be reported by view validation", func() {
+			LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_bad_modifiers" model="User">
+	<form>
+		<field name="UserName" attrs="{'invisible': [('Agee', '=', 1)]}"/>
+		<field name="Groups"><tree><field name="Name" attrs="{'readonly': [('UserName', '=', 'a')]}"/></tree></field>
+	</form>
+</view>`))
+			errs := Registry.GetByID("user_form_bad_modifiers").Validate()
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Message, ShouldEqual, "invalid invisible condition: unknown field Agee in model User")
+			So(errs[1].Message, ShouldEqual, "invalid readonly condition: unknown field UserName in model Group")
+			So(BootStrap, ShouldPanic)
+		})
+		Convey("Modifiers should be sent to clients in view archs", func() {
+			BootStrap()
+			view := Registry.GetByID("user_form_modifiers")
+			arch := xmlutils.ElementToXML(view.Arch(""))
+			So(arch, ShouldContainSubstring, `modifiers="{&quot;readonly&quot;:[[&quot;all_day&quot;,&quot;=&quot;,true]],&quot;required&quot;:true}"`)
+			So(arch, ShouldContainSubstring, `modifiers="{&quot;invisible&quot;:[&quot;|&quot;,[&quot;progress&quot;,&quot;&gt;&quot;,50],[&quot;start_date&quot;,&quot;=&quot;,false]]}"`)
+			So(arch, ShouldContainSubstring, `modifiers="{&quot;invisible&quot;:[[&quot;age&quot;,&quot;&lt;&quot;,18]]}"`)
+			subArch := xmlutils.ElementToXML(view.SubViews["Groups"][ViewTypeTree].Arch(""))
+			So(subArch, ShouldContainSubstring, `modifiers="{&quot;column_invisible&quot;:true,&quot;readonly&quot;:[[&quot;parent.AllDay&quot;,&quot;=&quot;,true]]}"`)
+			So(subArch, ShouldContainSubstring, `modifiers="{&quot;invisible&quot;:true}"`)
+		})
+	})
+	Convey("Building view archs from Go code", t, func() {
+		Registry = NewCollection()
+		form := NewArch("form", "string", "User")
+		group := form.Add("group", "name", "main")
+		group.AddField("UserName")
+		group.AddField("Age", "widget", "integer")
+		form.AddButton("OnChangeAge", "object", "Check Age").InsertBefore("separator")
+		Convey("Archs should be built with etree elements", func() {
+			So(form.Tag(), ShouldEqual, "form")
+			So(form.FindField("Age").Attr("widget"), ShouldEqual, "integer")
+			So(form.Find("//group[@name='main']").Element(), ShouldEqual, group.Element())
+			So(form.FindField("Unknown"), ShouldBeNil)
+			So(form.String(), ShouldEqual, `<form string="User">
+	<group name="main">
+		<field name="UserName"/>
+		<field name="Age" widget="integer"/>
+	</group>
+	<separator/>
+	<button name="OnChangeAge" type="object" string="Check Age"/>
+</form>
+`)
+			So(func() { form.Add("group", "name") }, ShouldPanic)
+			So(form.Remove, ShouldPanic)
+		})
+		Convey("Views should be created and generated from archs", func() {
+			view := NewView("user_built_form", "User", form)
+			view.Priority = 4
+			Generate("user_generated_tree", "User", func(model *models.Model) *Arch {
+				tree := NewArch("tree")
+				for _, field := range []string{"UserName", "Age", "Progress"} {
+					if _, ok := model.Fields().Get(field); ok {
+						tree.AddField(field)
+					}
+				}
+				return tree
+			})
+			LoadFromEtree(xmlutils.XMLToElement(`<view inherit_id="user_generated_tree">
+	<field name="Age" position="after"><field name="StartDate"/></field>
+</view>`))
+			BootStrap()
+			So(Registry.GetFirstViewForModel("User", ViewTypeForm).ID, ShouldEqual, "user_built_form")
+			So(Registry.GetByID("user_built_form").Name, ShouldEqual, "user.built.form")
+			So(Registry.GetByID("user_generated_tree").Fields, ShouldResemble, []models.FieldNamer{
+				models.FieldName("user_name"), models.FieldName("age"), models.FieldName("start_date"), models.FieldName("progress"),
+			})
+		})
+		Convey("Views should be altered from Go code at bootstrap", func() {
+			NewView("user_built_form", "User", form)
+			Extend("user_built_form", func(arch *Arch) {
+				arch.FindField("Age").Remove()
+				arch.FindField("UserName").InsertAfter("field", "name", "StartDate")
+				arch.SetAttr("string", "Person")
+			})
+			BootStrap()
+			arch := xmlutils.ElementToXML(Registry.GetByID("user_built_form").Arch(""))
+			So(arch, ShouldContainSubstring, `<form string="Person">`)
+			So(arch, ShouldContainSubstring, `<field name="user_name"/>
+		<field name="start_date"/>
+	</group>`)
+			So(arch, ShouldNotContainSubstring, `name="age"`)
+			Extend("unknown_view", func(arch *Arch) {})
+			So(BootStrap, ShouldPanic)
+		})
+	})
+	Convey("Views should be restricted to their groups", t, func() {
+		Registry = NewCollection()
+		LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_public" model="User" priority="10"><form><field name="UserName"/></form></view>`))
+		LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_admin" model="User" priority="20" groups="admin">
+	<form><field name="UserName"/><field name="Age"/></form>
+</view>`))
+		LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_managers" model="User" inherit_id="user_form_public" priority="30"
+	groups="view_test_managers, admin">
+	<field name="UserName" position="after"><field name="Progress"/></field>
+</view>`))
+		BootStrap()
+		Convey("Groups should be loaded from XML", func() {
+			So(Registry.GetByID("user_form_public").Groups, ShouldBeEmpty)
+			So(Registry.GetByID("user_form_managers").Groups, ShouldResemble, []string{"view_test_managers", "admin"})
+		})
+		Convey("Views should only be allowed to the members of their groups", func() {
+			So(Registry.GetByID("user_form_public").IsAllowed(3), ShouldBeTrue)
+			So(Registry.GetByID("user_form_managers").IsAllowed(2), ShouldBeTrue)
+			So(Registry.GetByID("user_form_managers").IsAllowed(3), ShouldBeFalse)
+			So(Registry.GetByID("user_form_admin").IsAllowed(security.SuperUserID), ShouldBeTrue)
+		})
+		Convey("Users should get the first view restricted to their groups", func() {
+			So(Registry.GetViewForUser("User", ViewTypeForm, 3).ID, ShouldEqual, "user_form_public")
+			So(Registry.GetViewForUser("User", ViewTypeForm, 2).ID, ShouldEqual, "user_form_managers")
+			So(Registry.GetViewForUser("User", ViewTypeForm, security.SuperUserID).ID, ShouldEqual, "user_form_admin")
+			So(Registry.GetViewForUser("User", ViewTypeTree, 3).ID, ShouldBeEmpty)
+		})
+		Convey("Views with unknown groups should be invalid", func() {
+			LoadFromEtree(xmlutils.XMLToElement(`<view id="user_form_unknown_group" model="User" groups="unknown_group"><form/></view>`))
+			errs := Registry.GetByID("user_form_unknown_group").Validate()
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Message, ShouldEqual, "unknown group unknown_group")
+		})
+	})
+	Convey("Invalid inheritance should panic at bootstrap", t, func() {
+		Convey("Extensions of unknown views", func() {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+			LoadFromEtree(xmlutils.XMLToElement(viewDef9))
+			So(BootStrap, ShouldPanic)
+		})
+		Convey("Unknown positions", func() {
+			Registry = NewCollection()
+			LoadFromEtree(xmlutils.XMLToElement(viewDef1))
+			LoadFromEtree(xmlutils.XMLToElement(viewDef13))
+			So(BootStrap, ShouldPanic)
+		})
+	})
 
 }